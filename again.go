@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+func again(context.Context) {
+	path, err := historyPath()
+	if err != nil {
+		bail("failed to find history: %s", err)
+	}
+	records, err := readHistory(path)
+	if err != nil {
+		bail("failed to read history: %s", err)
+	}
+
+	var last *historyRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Command == "create" || records[i].Command == "extract" {
+			last = &records[i]
+			break
+		}
+	}
+	if last == nil {
+		bail("no create or extract operations were found in the history")
+	}
+
+	// Records written before the working directory was recorded are run in
+	// the current one.
+	dir := last.Dir
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			bail("failed to find working directory: %s", err)
+		}
+	}
+
+	if cli.Again.DryRun {
+		fmt.Printf("cd %s && squish %s\n", shellQuote(dir), shellJoin(last.Args))
+		return
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		bail("failed to find executable: %s", err)
+	}
+	cmd := exec.Command(executable, last.Args...)
+	cmd.Dir = dir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// The operation is recorded in the same history, even if it was given by
+	// --history.
+	cmd.Env = append(os.Environ(), "SQUISH_HISTORY="+path)
+
+	// The operation reports its own errors, so only its exit status is kept.
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exitErr) {
+		exitCode = max(exitErr.ExitCode(), 1)
+	} else if err != nil {
+		bail("failed to run squish %s: %s", shellJoin(last.Args), err)
+	}
+}
+
+// shellSafe matches arguments that don't have to be quoted for a POSIX shell.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes arg for a POSIX shell, if it has to be.
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes each of args for a POSIX shell and joins them with spaces.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAgain(t *testing.T) {
+	t.Setenv(mainTestEnv, "1")
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "in"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "in", "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last create or extract is replayed in the directory it was run
+	// in, and recorded in the same history.
+	history := filepath.Join(t.TempDir(), "history.jsonl")
+	writeHistory := func(records ...historyRecord) {
+		t.Helper()
+		var data []byte
+		for _, record := range records {
+			line, err := json.Marshal(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(append(data, line...), '\n')
+		}
+		if err := os.WriteFile(history, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeHistory(
+		historyRecord{Command: "extract", Args: []string{"extract", "missing.tar"}, Dir: dir},
+		historyRecord{Command: "create", Args: []string{"create", "out.tar", "in"}, Dir: dir},
+		historyRecord{Command: "list", Args: []string{"list", "missing.tar"}, Dir: dir},
+	)
+	parseCLI(t, "--history", history, "again", "--dry-run")
+	if got, want := captureStdout(t, func() { runCommand(t, func() { again(context.Background()) }) }), "cd "+shellQuote(dir)+" && squish create out.tar in\n"; got != want {
+		t.Errorf("dry run: got %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.tar")); !os.IsNotExist(err) {
+		t.Fatalf("the dry run created the archive: %v", err)
+	}
+
+	parseCLI(t, "--history", history, "again")
+	if code := runCommand(t, func() { again(context.Background()) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if got, want := tarNames(t, filepath.Join(dir, "out.tar")), []string{"in", "in/a.txt"}; !slices.Equal(got, want) {
+		t.Errorf("got entries %q, want %q", got, want)
+	}
+	records, err := readHistory(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := records[len(records)-1]; len(records) != 4 || last.Command != "create" || last.Dir != dir {
+		t.Errorf("got %d records ending with %+v, want the replayed create to be recorded", len(records), last)
+	}
+
+	// The replayed operation's exit status is kept.
+	writeHistory(historyRecord{Command: "extract", Args: []string{"extract", "missing.tar"}, Dir: dir})
+	parseCLI(t, "--history", history, "again")
+	if code := runCommand(t, func() { again(context.Background()) }); code == 0 {
+		t.Error("the failed replay got exit code 0")
+	}
+
+	writeHistory(historyRecord{Command: "list", Args: []string{"list", "out.tar"}, Dir: dir})
+	parseCLI(t, "--history", history, "again")
+	if code := runCommand(t, func() { again(context.Background()) }); code == 0 {
+		t.Error("got exit code 0 without an operation to replay")
+	}
+}
+func TestShellJoin(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"create", "out.tar.gz", "src/"}, "create out.tar.gz src/"},
+		{[]string{"extract", "my archive.zip"}, "extract 'my archive.zip'"},
+		{[]string{"create", "--transform", "s|^a|b|", "it's"}, `create --transform 's|^a|b|' 'it'\''s'`},
+		{[]string{""}, "''"},
+	}
+
+	for _, test := range tests {
+		if got := shellJoin(test.args); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+)
+
+// ageIntro is the first line of binary age files, which are described at
+// https://age-encryption.org/v1.
+const ageIntro = "age-encryption.org/v1"
+
+// ageExtension is the extension of files encrypted with age, which is removed
+// to identify the format of their plaintext.
+const ageExtension = ".age"
+
+var (
+	errAgeHeader         = errors.New("invalid age header")
+	errAgeNoIdentity     = errors.New("none of the --identity keys can decrypt the input")
+	errAgeAuthentication = errors.New("age payload is corrupt or was modified")
+)
+
+// isAge reports whether header, the start of an input, is the start of a
+// binary age file. ASCII-armored files aren't supported.
+func isAge(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(ageIntro+"\n"))
+}
+
+// parseAgeRecipient parses an X25519 recipient, like age1....
+func parseAgeRecipient(s string) (age.Recipient, error) {
+	recipient, err := age.ParseX25519Recipient(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age recipient %q", s)
+	}
+	return recipient, nil
+}
+
+// readAgeIdentities reads the X25519 identities in the files at paths, which
+// have one per line, along with comments starting with #, as written by
+// age-keygen.
+func readAgeIdentities(paths []string) ([]age.Identity, error) {
+	var identities []age.Identity
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		identities = append(identities, parsed...)
+	}
+	return identities, nil
+}
+
+// ageWriter encrypts what's written to it with age, writing the encrypted
+// file to an output. Its commit method is like the one returned by
+// createOutput, but only commits the output once the last chunk is written.
+type ageWriter struct {
+	io.WriteCloser
+	output       io.WriteCloser
+	commitOutput func()
+	committed    bool
+}
+
+// encryptAge writes the header of an age file encrypted to each of
+// recipients to output, and returns a writer encrypting its payload.
+func encryptAge(output io.WriteCloser, commit func(), recipients []age.Recipient) (*ageWriter, error) {
+	encrypted, err := age.Encrypt(output, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	return &ageWriter{WriteCloser: encrypted, output: output, commitOutput: commit}, nil
+}
+
+func (w *ageWriter) commit() {
+	w.committed = true
+}
+
+// Close writes the last chunk, and closes the output, committing it if commit
+// was called.
+func (w *ageWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if err == nil && w.committed {
+		w.commitOutput()
+	}
+	return errors.Join(err, w.output.Close())
+}
+
+// ageReader decrypts the payload of an age file.
+type ageReader struct {
+	io.Reader
+	input io.Closer
+}
+
+// decryptAge returns the plaintext of the age file read from input, decrypted
+// with whichever of identities it was encrypted to. Closing the returned
+// reader closes input. age doesn't distinguish malformed files from errors
+// reading them, so errors other than none of identities matching wrap
+// errAgeHeader, or errAgeAuthentication once the payload is being read.
+func decryptAge(input io.ReadCloser, identities []age.Identity) (io.ReadCloser, error) {
+	decrypted, err := age.Decrypt(input, identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, errAgeNoIdentity
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", errAgeHeader, err)
+	}
+	return &ageReader{decrypted, input}, nil
+}
+
+func (r *ageReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %w", errAgeAuthentication, err)
+	}
+	return n, err
+}
+
+func (r *ageReader) Close() error {
+	return r.input.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestAgeKeys(t *testing.T) {
+	// The identity of 32 0x42 bytes used by age's test suite.
+	path := filepath.Join(t.TempDir(), "key.txt")
+	identityFile := "# created: 2006-01-02T15:04:05Z\n\nAGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX\n"
+	if err := os.WriteFile(path, []byte(identityFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	identities, err := readAgeIdentities([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const recipient = "age1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwj"
+	if len(identities) != 1 || identities[0].(*age.X25519Identity).Recipient().String() != recipient {
+		t.Errorf("got identities %v, want the one for %s", identities, recipient)
+	}
+	if _, err := parseAgeRecipient(recipient); err != nil {
+		t.Error(err)
+	}
+	if _, err := parseAgeRecipient(recipient[:len(recipient)-1] + "q"); err == nil {
+		t.Error("parsed a recipient with an invalid checksum")
+	}
+}
+
+// ageRoundTrip encrypts plaintext to recipients and decrypts it with
+// identities, tampering with the encrypted file in between.
+func ageRoundTrip(t *testing.T, plaintext []byte, recipients []age.Recipient, identities []age.Identity, tamper func([]byte) []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	committed := false
+	w, err := encryptAge(nopWriteCloser{&buf}, func() { committed = true }, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	w.commit()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("output wasn't committed")
+	}
+
+	r, err := decryptAge(io.NopCloser(bytes.NewReader(tamper(buf.Bytes()))), identities)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestAge(t *testing.T) {
+	var identities []age.Identity
+	var recipients []age.Recipient
+	for range 3 {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities = append(identities, identity)
+		recipients = append(recipients, identity.Recipient())
+	}
+	unchanged := func(b []byte) []byte { return b }
+	// age encrypts payloads in chunks of this size, other than the last.
+	const ageChunkSize = 64 << 10
+
+	for _, size := range []int{0, 1, ageChunkSize, ageChunkSize + 1, 2 * ageChunkSize} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		// Each recipient can decrypt it.
+		for _, identity := range identities {
+			got, err := ageRoundTrip(t, plaintext, recipients, []age.Identity{identity}, unchanged)
+			if err != nil {
+				t.Fatalf("size %d: %s", size, err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("size %d: decrypted %d bytes that don't match", size, len(got))
+			}
+		}
+
+		if _, err := ageRoundTrip(t, plaintext, recipients[1:], identities[:1], unchanged); !errors.Is(err, errAgeNoIdentity) {
+			t.Errorf("size %d: got error %v for the wrong identity, want %v", size, err, errAgeNoIdentity)
+		}
+
+		flipLast := func(b []byte) []byte {
+			b[len(b)-1] ^= 1
+			return b
+		}
+		if _, err := ageRoundTrip(t, plaintext, recipients, identities, flipLast); !errors.Is(err, errAgeAuthentication) {
+			t.Errorf("size %d: got error %v for tampered payload, want %v", size, err, errAgeAuthentication)
+		}
+
+		// Dropping the last chunk leaves one that isn't marked as the
+		// last.
+		if size > ageChunkSize {
+			truncate := func(b []byte) []byte {
+				return b[:len(b)-(size-ageChunkSize)-16]
+			}
+			if _, err := ageRoundTrip(t, plaintext, recipients, identities, truncate); !errors.Is(err, errAgeAuthentication) {
+				t.Errorf("size %d: got error %v for truncated payload, want %v", size, err, errAgeAuthentication)
+			}
+		}
+	}
+
+	// Stanzas that identities don't recognize are skipped, but are still
+	// covered by the header's MAC.
+	addStanza := func(b []byte) []byte {
+		return bytes.Replace(b, []byte(ageIntro+"\n"), []byte(ageIntro+"\n-> unknown\n\n"), 1)
+	}
+	if _, err := ageRoundTrip(t, []byte("a"), recipients, identities, addStanza); !errors.Is(err, errAgeHeader) {
+		t.Errorf("got error %v for tampered header, want %v", err, errAgeHeader)
+	}
+}
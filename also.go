@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// alsoFormat returns the format of the --also output at path, identified from
+// its name.
+func alsoFormat(ctx context.Context, path string) (archives.ArchiverAsync, error) {
+	if _, ok := squish.LookupZipVariant(filepath.Ext(path)); ok {
+		return nil, fmt.Errorf("%s is a zip variant, which orders its entries itself", path)
+	}
+	format, _, err := archives.Identify(ctx, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cli.Create.Dedup && !isTar(format) {
+		return nil, fmt.Errorf("%s isn't a tar archive, which --dedup needs to store hard links", path)
+	}
+	async, ok := withThreads(format, threadsWithin(cli.Create.Threads, int64(cli.MaxMemory))).(archives.ArchiverAsync)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't an archive, like out.zip", path)
+	}
+	return async, nil
+}
+
+// createAlsoOutputs creates the --also outputs at paths, which are only kept if
+// they're committed before they're closed, as with the main output. Existing
+// outputs are only replaced if force is true.
+func createAlsoOutputs(paths []string, force bool) ([]*atomicFile, error) {
+	var files []*atomicFile
+	for _, path := range paths {
+		if !force {
+			var err error
+			if _, statErr := os.Lstat(path); statErr == nil {
+				err = fmt.Errorf("output %s already exists, use --force to replace it", path)
+			} else if !errors.Is(statErr, fs.ErrNotExist) {
+				err = fmt.Errorf("failed to check for existing output: %w", statErr)
+			}
+			if err != nil {
+				closeAlsoOutputs(files)
+				return nil, err
+			}
+		}
+		file, err := createAtomic(path, cli.Create.Tempdir)
+		if err != nil {
+			closeAlsoOutputs(files)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// closeAlsoOutputs closes files, returning the first error.
+func closeAlsoOutputs(files []*atomicFile) error {
+	var err error
+	for _, file := range files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// archiveShared writes files to each of outputs using the format at the same
+// index of formats, all at once, reading the contents of each file once and
+// sharing them between the formats, and printing each entry as it's archived
+// if --verbose was given.
+func archiveShared(ctx context.Context, formats []archives.ArchiverAsync, outputs []io.Writer, files []archives.FileInfo, progress *progress) error {
+	verboseW := verboseOutput(cli.Create.Output)
+
+	jobs := make([]chan archives.ArchiveAsyncJob, len(formats))
+	archiveErrs := make(chan error, len(formats))
+	for i, format := range formats {
+		jobs[i] = make(chan archives.ArchiveAsyncJob)
+		go func() {
+			archiveErrs <- format.ArchiveAsync(ctx, outputs[i], jobs[i])
+		}()
+	}
+
+	results := make(chan error, len(formats))
+	var err error
+	for _, file := range files {
+		printEntry(verboseW, progress, file.NameInArchive, file)
+		contents := newSharedContents(file, len(formats))
+		for i := range formats {
+			// Each format is done with the contents once it reports its
+			// result, whether or not it read all of them.
+			result := make(chan error, 1)
+			jobs[i] <- archives.ArchiveAsyncJob{File: contents.file(i), Result: result}
+			go func() {
+				err := <-result
+				contents.done(i)
+				results <- err
+			}()
+		}
+		for range formats {
+			if resultErr := withEntry(file.NameInArchive, <-results); err == nil {
+				err = resultErr
+			}
+		}
+		contents.wait()
+		if err != nil {
+			break
+		}
+	}
+	for i := range jobs {
+		close(jobs[i])
+	}
+
+	for range formats {
+		if archiveErr := <-archiveErrs; err == nil {
+			err = archiveErr
+		}
+	}
+	return err
+}
+
+// sharedContents shares the contents of a file between several formats, which
+// each read them through their own pipe. The file is opened when the first
+// format opens it, and its contents are written to every pipe as they're read,
+// until each format is done with them.
+type sharedContents struct {
+	info    archives.FileInfo
+	readers []*io.PipeReader
+	writers []*io.PipeWriter
+
+	once    sync.Once
+	openErr error
+	copied  chan struct{}
+}
+
+func newSharedContents(info archives.FileInfo, n int) *sharedContents {
+	s := &sharedContents{info: info, copied: make(chan struct{})}
+	for range n {
+		r, w := io.Pipe()
+		s.readers, s.writers = append(s.readers, r), append(s.writers, w)
+	}
+	return s
+}
+
+// file returns the file that the format at index i archives.
+func (s *sharedContents) file(i int) archives.FileInfo {
+	file := s.info
+	if file.Open == nil {
+		return file
+	}
+	file.Open = func() (fs.File, error) {
+		s.once.Do(s.start)
+		if s.openErr != nil {
+			return nil, s.openErr
+		}
+		return sharedFile{s.readers[i], s.info.FileInfo}, nil
+	}
+	return file
+}
+
+// start opens the file, and writes its contents to each pipe in the
+// background.
+func (s *sharedContents) start() {
+	src, err := s.info.Open()
+	if err != nil {
+		s.openErr = err
+		close(s.copied)
+		return
+	}
+
+	go func() {
+		defer close(s.copied)
+		defer src.Close()
+
+		buf := copyBuffers.Get().(*[copyBufferSize]byte)
+		defer copyBuffers.Put(buf)
+		writers := slices.Clone(s.writers)
+		for {
+			n, err := src.Read(buf[:])
+			if n > 0 {
+				// Pipes that fail to be written to are those of
+				// formats that are done with the contents.
+				live := writers[:0]
+				for _, w := range writers {
+					if _, err := w.Write(buf[:n]); err == nil {
+						live = append(live, w)
+					}
+				}
+				writers = live
+			}
+			if err == nil && len(writers) == 0 {
+				err = io.EOF
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				for _, w := range s.writers {
+					w.CloseWithError(err)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// done records that the format at index i is done with the contents.
+func (s *sharedContents) done(i int) {
+	s.readers[i].Close()
+}
+
+// wait waits for the contents to be written to every pipe, if the file was
+// opened.
+func (s *sharedContents) wait() {
+	started := true
+	s.once.Do(func() {
+		started = false
+		close(s.copied)
+	})
+	if started {
+		<-s.copied
+	}
+}
+
+// sharedFile is a file as one of the formats sharing its contents reads it.
+type sharedFile struct {
+	*io.PipeReader
+	info fs.FileInfo
+}
+
+func (f sharedFile) Stat() (fs.FileInfo, error) { return f.info, nil }
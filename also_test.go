@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestArchiveShared(t *testing.T) {
+	dir := t.TempDir()
+	large := strings.Repeat("0123456789abcdef", copyBufferSize/8)
+	if err := os.MkdirAll(filepath.Join(dir, "in", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "in", "sub", "large"), []byte(large), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "in", "small"), []byte("small"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{filepath.Join(dir, "in"): "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opens atomic.Int64
+	for i, file := range files {
+		if open := file.Open; open != nil {
+			files[i].Open = func() (fs.File, error) {
+				opens.Add(1)
+				return open()
+			}
+		}
+	}
+
+	formats := []archives.ArchiverAsync{archives.Tar{}, archives.Zip{}, archives.CompressedArchive{Compression: archives.Gz{}, Archival: archives.Tar{}, Extraction: archives.Tar{}}}
+	outputs := []*bytes.Buffer{{}, {}, {}}
+	writers := []io.Writer{outputs[0], outputs[1], outputs[2]}
+	if err := archiveShared(context.Background(), formats, writers, files, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Only the regular files are opened, and only once each.
+	if n := opens.Load(); n != 2 {
+		t.Errorf("files were opened %d times, want 2", n)
+	}
+
+	want := map[string]string{"in": "", "in/sub": "", "in/sub/large": large, "in/small": "small"}
+	for i, format := range formats {
+		got := map[string]string{}
+		err := format.(archives.Extractor).Extract(context.Background(), bytes.NewReader(outputs[i].Bytes()), func(ctx context.Context, f archives.FileInfo) error {
+			var contents []byte
+			if !f.IsDir() {
+				r, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+				if contents, err = io.ReadAll(r); err != nil {
+					return err
+				}
+			}
+			got[strings.TrimSuffix(f.NameInArchive, "/")] = string(contents)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("%s: got entries that differ from the inputs", format.(archives.Format).Extension())
+		}
+	}
+}
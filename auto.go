@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// autoSniffSize is how much of the start of each sampled file --auto reads to
+// judge its contents.
+const autoSniffSize = 64 << 10
+
+// incompressibleEntropy is the entropy, in bits per byte, above which --auto
+// judges contents to already be compressed or encrypted.
+const incompressibleEntropy = 7.5
+
+// compressedExtensions are the extensions of files whose contents are already
+// compressed, so that compressing them again gains next to nothing.
+var compressedExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".aac": true, ".m4a": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".lz4": true, ".br": true, ".7z": true, ".rar": true,
+	".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true,
+}
+
+// compressedTypes are the sniffed content types of files whose contents are
+// already compressed.
+var compressedTypes = map[string]bool{
+	"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true,
+	"audio/mpeg": true, "application/ogg": true, "video/mp4": true, "video/webm": true, "video/avi": true,
+	"application/zip": true, "application/x-gzip": true, "application/x-rar-compressed": true,
+}
+
+// autoFormat chooses the format and level to archive files in for --auto, by
+// judging how much of a sample of them is text, and how much is already
+// compressed, and logs the reasoning behind its choice.
+func autoFormat(files []archives.FileInfo) (archives.Format, error) {
+	sample, _ := sampleFiles(files, totalSize(files))
+	var text, compressed, total int64
+	for _, file := range sample {
+		if !file.Mode().IsRegular() || file.Size() == 0 {
+			continue
+		}
+		isText, isCompressed, err := sniffContents(file)
+		if err != nil {
+			return nil, err
+		}
+		total += file.Size()
+		if isText {
+			text += file.Size()
+		} else if isCompressed {
+			compressed += file.Size()
+		}
+	}
+	share := func(n int64) int64 {
+		if total == 0 {
+			return 0
+		}
+		return n * 100 / total
+	}
+
+	switch {
+	case total > 0 && compressed*10 >= total*9:
+		logMessage(slog.LevelInfo, nil, "--auto chose zip without compression, since %d%% of the sampled data is already compressed", share(compressed))
+		return archives.Zip{}, nil
+
+	case total > 0 && text*2 >= total:
+		logMessage(slog.LevelInfo, nil, "--auto chose tar.zst at level 19, since %d%% of the sampled data is text", share(text))
+		format, err := squish.LookupFormat("tar.zst")
+		if err != nil {
+			return nil, err
+		}
+		return squish.WithLevel(format, 19)
+
+	default:
+		logMessage(slog.LevelInfo, nil, "--auto chose tar.zst at its default level, since %d%% of the sampled data is text and %d%% is already compressed", share(text), share(compressed))
+		return squish.LookupFormat("tar.zst")
+	}
+}
+
+// withAutoExtension appends ext, the extension of the format chosen by --auto,
+// to output, before the extension of its encryption if it has one.
+func withAutoExtension(output, ext string) string {
+	base := output
+	if ageRecipients, gpgRecipients := encryptionRecipients(); len(ageRecipients) > 0 {
+		base = strings.TrimSuffix(output, ageExtension)
+	} else if len(gpgRecipients) > 0 {
+		base, _ = trimGPGExtension(output)
+	}
+	return base + ext + output[len(base):]
+}
+
+// sniffContents reports whether the regular file is text, or already
+// compressed, judged by its extension, its content type, and the entropy of
+// its first autoSniffSize bytes.
+func sniffContents(file archives.FileInfo) (text, compressed bool, err error) {
+	if compressedExtensions[strings.ToLower(path.Ext(file.NameInArchive))] {
+		return false, true, nil
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	head := make([]byte, autoSniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, false, err
+	}
+	head = head[:n]
+
+	contentType := sniffType(head)
+	if strings.HasPrefix(contentType, "text/") {
+		return true, false, nil
+	}
+	return false, compressedTypes[contentType] || entropy(head) > incompressibleEntropy, nil
+}
+
+// entropy returns the Shannon entropy of data in bits per byte, from 0 for
+// data that's a single repeated byte, to 8 for random data.
+func entropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(len(data))
+			bits -= p * math.Log2(p)
+		}
+	}
+	return bits
+}
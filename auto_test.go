@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestAutoFormat(t *testing.T) {
+	ctx := context.Background()
+	rng := rand.New(rand.NewPCG(1, 2))
+	random := make([]byte, 100000)
+	for i := range random {
+		random[i] = byte(rng.Uint32())
+	}
+	text := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 2500))
+
+	tests := []struct {
+		name  string
+		files map[string][]byte
+		want  string
+	}{
+		{"photos", map[string][]byte{"a.jpg": text, "b.bin": random}, ".zip"},
+		{"text", map[string][]byte{"a.txt": text, "b.bin": random[:1000]}, ".tar.zst"},
+		{"mixed", map[string][]byte{"a.txt": text[:10000], "b.jpg": random, "c.bin": bytes.Repeat([]byte{0, 1, 2, 3}, 10000)}, ".tar.zst"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		for name, data := range test.files {
+			if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		files, err := archives.FilesFromDisk(ctx, nil, map[string]string{dir: "in"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		format, err := autoFormat(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := format.Extension(); got != test.want {
+			t.Errorf("%s: got format %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	if got := entropy(bytes.Repeat([]byte{'a'}, 100)); got != 0 {
+		t.Errorf("got entropy %f for a repeated byte, want 0", got)
+	}
+	all := make([]byte, 256*4)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	if got := entropy(all); got != 8 {
+		t.Errorf("got entropy %f for evenly distributed bytes, want 8", got)
+	}
+}
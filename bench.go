@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// benchCase is a format to archive the sample in when benchmarking, at the
+// level named by level.
+type benchCase struct {
+	name, level string
+	format      archives.Archiver
+}
+
+// bench archives a sample of a directory in each of the formats given by
+// --formats, at each of the levels given by --levels, and prints a table of
+// the size of the output and how long it would take to create, extrapolated
+// from the sample as with --estimate. With --suite, the synthetic corpora are
+// archived instead, and the results are printed as JSON.
+func bench(ctx context.Context) {
+	if (cli.Bench.Directory == "") == !cli.Bench.Suite {
+		bail("bench takes either a directory or --suite")
+	}
+	if cli.Bench.Threads < 1 {
+		bail("invalid number of threads: %d", cli.Bench.Threads)
+	}
+	threads := threadsWithin(cli.Bench.Threads, int64(cli.MaxMemory))
+	cases := benchCases(threads)
+	if cli.Bench.Suite {
+		benchSuite(ctx, cases, threads)
+		return
+	}
+
+	dir := cli.Bench.Directory
+	found, err := archives.FilesFromDisk(ctx, nil, map[string]string{dir: filepath.Base(dir)})
+	if err != nil {
+		bail("failed to discover files: %s", err)
+	}
+	files := withoutSpecialFiles(found, false)
+
+	inputSize := totalSize(files)
+	sample, sampledSize := sampleFiles(files, inputSize)
+	// The sample is read once beforehand, so that the first format isn't
+	// slowed down by reading it from disk rather than the page cache.
+	if err := (archives.Tar{}).Archive(ctx, io.Discard, sample); err != nil {
+		bail("failed to read files: %s", err)
+	}
+
+	fmt.Printf("input size: %s, sampled: %s\n\n", byteSize(inputSize), byteSize(sampledSize))
+	fmt.Printf("%-10s %-7s %10s %6s %9s %12s\n", "FORMAT", "LEVEL", "SIZE", "RATIO", "TIME", "SPEED")
+	for _, c := range cases {
+		output, elapsed := runBenchCase(ctx, c, sample)
+
+		scale := 1.0
+		if sampledSize > 0 {
+			scale = float64(inputSize) / float64(sampledSize)
+		}
+		size := int64(float64(output) * scale)
+		duration := time.Duration(float64(elapsed) * scale).Round(time.Millisecond)
+
+		ratio, speed := 0.0, 0.0
+		if inputSize > 0 {
+			ratio = float64(size) / float64(inputSize)
+		}
+		if elapsed > 0 {
+			speed = float64(sampledSize) / elapsed.Seconds()
+		}
+		fmt.Printf("%-10s %-7s %10s %6.3f %9s %10s/s\n", c.name, c.level, byteSize(size), ratio, duration, byteSize(speed))
+	}
+}
+
+// benchCases returns the formats given by --formats, at each of the levels
+// given by --levels, compressing with up to threads threads.
+func benchCases(threads int) []benchCase {
+	var cases []benchCase
+	add := func(name, level string, format archives.Format) {
+		format = withThreads(format, threads)
+		format = withMemoryLimit(format, int64(cli.MaxMemory))
+		cases = append(cases, benchCase{name: name, level: level, format: format.(archives.Archiver)})
+	}
+	for _, name := range cli.Bench.Formats {
+		format, err := squish.LookupFormat(name)
+		if err != nil {
+			bail("failed to identify format: %s", err)
+		}
+		if _, ok := format.(archives.Archiver); !ok {
+			bail("%s doesn't support archiving, so it can only be compared as part of an archive format like tar.%s", name, name)
+		}
+
+		if len(cli.Bench.Levels) == 0 {
+			add(name, "default", format)
+		}
+		for _, level := range cli.Bench.Levels {
+			leveled, err := squish.WithLevel(format, level)
+			if err != nil {
+				warn("skipped %s at level %d: %s", name, level, err)
+				continue
+			}
+			add(name, strconv.Itoa(level), leveled)
+		}
+	}
+	return cases
+}
+
+// runBenchCase archives files with the format of c, returning the size of the
+// archive and how long it took to create.
+func runBenchCase(ctx context.Context, c benchCase, files []archives.FileInfo) (int64, time.Duration) {
+	var output countingWriter
+	started := time.Now()
+	if err := c.format.Archive(ctx, &output, files); err != nil {
+		bail("failed to create archive: %s", err)
+	}
+	return int64(output), time.Since(started)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte(strings.Repeat("squish ", 1000)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte(strings.Repeat("b", 1000)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Levels that a format doesn't have are skipped.
+	parseCLI(t, "bench", "--formats", "tar.gz,zip", "--levels", "1,9,30", dir)
+	var code int
+	output := captureStdout(t, func() { code = runCommand(t, func() { bench(context.Background()) }) })
+	if code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("got %q, want the sizes, a blank line, a header and 4 rows", output)
+	}
+
+	// The whole directory is small enough to be the sample.
+	if want := "input size: 7.8 KiB, sampled: 7.8 KiB"; lines[0] != want || lines[1] != "" {
+		t.Errorf("got %q, want %q and a blank line", lines[:2], want)
+	}
+	if want := "FORMAT     LEVEL         SIZE  RATIO      TIME        SPEED"; lines[2] != want {
+		t.Errorf("got header %q, want %q", lines[2], want)
+	}
+
+	// Each row is aligned with the header, and its size and ratio agree.
+	for i, want := range [][2]string{{"tar.gz", "1"}, {"tar.gz", "9"}, {"zip", "1"}, {"zip", "9"}} {
+		row := lines[3+i]
+		if len(row) != len(lines[2]) {
+			t.Errorf("row %q isn't as wide as the header", row)
+			continue
+		}
+		format, level := strings.TrimSpace(row[:10]), strings.TrimSpace(row[11:18])
+		size, ratio := strings.TrimSpace(row[19:29]), strings.TrimSpace(row[30:36])
+		elapsed, speed := strings.TrimSpace(row[37:46]), strings.TrimSpace(row[47:])
+		if format != want[0] || level != want[1] {
+			t.Errorf("got %s at level %s, want %s at level %s", format, level, want[0], want[1])
+		}
+		var parsedSize byteSize
+		if err := parsedSize.UnmarshalText([]byte(strings.ReplaceAll(size, " ", ""))); err != nil {
+			t.Errorf("%s: invalid size %q: %s", row, size, err)
+		}
+		parsedRatio, err := strconv.ParseFloat(ratio, 64)
+		if err != nil || parsedRatio <= 0 || parsedRatio >= 1 {
+			t.Errorf("%s: got ratio %q, want one between 0 and 1", row, ratio)
+		}
+		if got := float64(parsedSize) / 8000; got < parsedRatio-0.05 || got > parsedRatio+0.05 {
+			t.Errorf("%s: size %s of 8000 bytes doesn't match ratio %s", row, size, ratio)
+		}
+		if _, err := time.ParseDuration(elapsed); err != nil {
+			t.Errorf("%s: invalid time %q: %s", row, elapsed, err)
+		}
+		if !strings.HasSuffix(speed, "/s") {
+			t.Errorf("%s: got speed %q, want bytes per second", row, speed)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+// benchCorpusSize is roughly the size of each of the corpora of bench --suite.
+const benchCorpusSize = 8 << 20
+
+// benchCorpora are the synthetic corpora of bench --suite, which are generated
+// from a fixed seed, so that they're the same in every version and results can
+// be compared across them.
+var benchCorpora = []struct {
+	name     string
+	generate func(rng *rand.Rand) []archives.FileInfo
+}{
+	{"text", func(rng *rand.Rand) []archives.FileInfo {
+		return []archives.FileInfo{memFile("text.txt", benchText(rng, benchCorpusSize))}
+	}},
+	{"binary", func(rng *rand.Rand) []archives.FileInfo {
+		return []archives.FileInfo{memFile("binary", benchBinary(rng, benchCorpusSize))}
+	}},
+	{"compressed", func(rng *rand.Rand) []archives.FileInfo {
+		data := make([]byte, benchCorpusSize)
+		for i := 0; i < len(data); i += 8 {
+			binary.LittleEndian.PutUint64(data[i:], rng.Uint64())
+		}
+		return []archives.FileInfo{memFile("compressed.bin", data)}
+	}},
+	{"small-files", func(rng *rand.Rand) []archives.FileInfo {
+		var files []archives.FileInfo
+		for size, i := 0, 0; size < benchCorpusSize; i++ {
+			dir := fmt.Sprintf("d%03d", i/100)
+			if i%100 == 0 {
+				files = append(files, memDir(dir))
+			}
+			data := benchText(rng, 512+rng.IntN(2560))
+			files = append(files, memFile(path.Join(dir, fmt.Sprintf("f%05d.txt", i)), data))
+			size += len(data)
+		}
+		return files
+	}},
+}
+
+// benchWords are the words that benchText is made of, with the more common
+// letters being more likely.
+var benchWords = sync.OnceValue(func() []string {
+	const letters = "etaoinshrdlcumwfgypbvkjxqz"
+	rng := rand.New(rand.NewPCG(0, 0))
+	words := make([]string, 1000)
+	for i := range words {
+		word := make([]byte, 2+rng.IntN(8))
+		for j := range word {
+			word[j] = letters[min(rng.IntN(len(letters)), rng.IntN(len(letters)))]
+		}
+		words[i] = string(word)
+	}
+	return words
+})
+
+// benchText returns size bytes of lines of words, whose frequencies follow
+// Zipf's law, as those of natural language do.
+func benchText(rng *rand.Rand, size int) []byte {
+	words := benchWords()
+	zipf := rand.NewZipf(rng, 1.1, 1, uint64(len(words)-1))
+	var buf bytes.Buffer
+	lineLength := 0
+	for buf.Len() < size {
+		word := words[zipf.Uint64()]
+		if lineLength+len(word) > 72 {
+			buf.WriteByte('\n')
+			lineLength = 0
+		} else if lineLength > 0 {
+			buf.WriteByte(' ')
+			lineLength++
+		}
+		buf.WriteString(word)
+		lineLength += len(word)
+	}
+	return buf.Bytes()[:size]
+}
+
+// benchBinary returns size bytes resembling machine code, made of a small set
+// of common instructions, some with operands, and runs of zero padding.
+func benchBinary(rng *rand.Rand, size int) []byte {
+	opcodes := make([]uint32, 256)
+	for i := range opcodes {
+		opcodes[i] = rng.Uint32()
+	}
+	zipf := rand.NewZipf(rng, 1.2, 1, uint64(len(opcodes)-1))
+
+	data := make([]byte, 0, size+64)
+	for len(data) < size {
+		switch n := rng.IntN(100); {
+		case n < 2:
+			data = append(data, make([]byte, 16*(1+rng.IntN(4)))...)
+		case n < 30:
+			data = binary.LittleEndian.AppendUint32(data, opcodes[zipf.Uint64()])
+			data = binary.LittleEndian.AppendUint32(data, uint32(rng.IntN(1<<16)))
+		default:
+			data = binary.LittleEndian.AppendUint32(data, opcodes[zipf.Uint64()])
+		}
+	}
+	return data[:size]
+}
+
+// benchSuiteTime is the modification time of the files of the corpora, which
+// is fixed so that archives of them are the same size every time.
+var benchSuiteTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// memFileInfo describes a file or directory of a corpus.
+type memFileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (i memFileInfo) Name() string       { return path.Base(i.name) }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i memFileInfo) ModTime() time.Time { return benchSuiteTime }
+func (i memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memFileInfo) Sys() any           { return nil }
+
+// memReader is an open file of a corpus.
+type memReader struct {
+	*bytes.Reader
+	info memFileInfo
+}
+
+func (r memReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+func (r memReader) Close() error               { return nil }
+
+// memFile returns the regular file name of a corpus, with contents data.
+func memFile(name string, data []byte) archives.FileInfo {
+	info := memFileInfo{name: name, size: int64(len(data)), mode: 0o644}
+	return archives.FileInfo{
+		FileInfo:      info,
+		NameInArchive: name,
+		Open: func() (fs.File, error) {
+			return memReader{bytes.NewReader(data), info}, nil
+		},
+	}
+}
+
+// memDir returns the directory name of a corpus.
+func memDir(name string) archives.FileInfo {
+	return archives.FileInfo{FileInfo: memFileInfo{name: name, mode: fs.ModeDir | 0o755}, NameInArchive: name + "/"}
+}
+
+// benchResult is the result of archiving a corpus with a format at a level,
+// as printed by bench --suite.
+type benchResult struct {
+	Corpus         string  `json:"corpus"`
+	Format         string  `json:"format"`
+	Level          string  `json:"level"`
+	InputBytes     int64   `json:"input_bytes"`
+	OutputBytes    int64   `json:"output_bytes"`
+	Ratio          float64 `json:"ratio"`
+	Seconds        float64 `json:"seconds"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+// benchSuite archives each of the corpora with each of cases, and prints the
+// results as a JSON object, along with the version of squish and the platform
+// they were measured with.
+func benchSuite(ctx context.Context, cases []benchCase, threads int) {
+	report := struct {
+		Version   string        `json:"version"`
+		GoVersion string        `json:"go_version"`
+		Platform  string        `json:"platform"`
+		Threads   int           `json:"threads"`
+		Results   []benchResult `json:"results"`
+	}{Version: buildVersion(), GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH, Threads: threads}
+
+	for _, corpus := range benchCorpora {
+		files := corpus.generate(rand.New(rand.NewPCG(1, 2)))
+		inputSize := totalSize(files)
+		for _, c := range cases {
+			size, elapsed := runBenchCase(ctx, c, files)
+			result := benchResult{Corpus: corpus.name, Format: c.name, Level: c.level, InputBytes: inputSize, OutputBytes: size, Seconds: elapsed.Seconds()}
+			if inputSize > 0 {
+				result.Ratio = float64(size) / float64(inputSize)
+			}
+			if elapsed > 0 {
+				result.BytesPerSecond = float64(inputSize) / elapsed.Seconds()
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		bail("failed to write results: %s", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestBenchCorpora(t *testing.T) {
+	read := func(files []archives.FileInfo) []byte {
+		var buf bytes.Buffer
+		for _, file := range files {
+			buf.WriteString(file.NameInArchive)
+			if !file.Mode().IsRegular() {
+				continue
+			}
+			f, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.Copy(&buf, f); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+		}
+		return buf.Bytes()
+	}
+
+	for _, corpus := range benchCorpora {
+		files := corpus.generate(rand.New(rand.NewPCG(1, 2)))
+		if size := totalSize(files); size < benchCorpusSize || size > benchCorpusSize+4096 {
+			t.Errorf("%s corpus is %d bytes, want about %d", corpus.name, size, benchCorpusSize)
+		}
+		// Results are only comparable if the corpora are always the same.
+		if !bytes.Equal(read(files), read(corpus.generate(rand.New(rand.NewPCG(1, 2))))) {
+			t.Errorf("%s corpus differs when generated again", corpus.name)
+		}
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mholt/archives"
+)
+
+// browseHelp is shown at the bottom of the browser until there's a status to
+// show instead.
+const browseHelp = "enter open  left back  space select  x extract  p page  / search  q quit"
+
+// browsePreviewSize is how much of a regular file is read to preview it.
+const browsePreviewSize = 16 << 10
+
+// browseAction is what the browser must do after handling a key, beyond
+// showing its new state.
+type browseAction int
+
+const (
+	browseNone browseAction = iota
+	browseQuit
+	browsePage
+)
+
+// browseEntry is an entry listed by the browser, by its path in the archive.
+type browseEntry struct {
+	name string
+	info fs.FileInfo
+}
+
+// browser is the state of browse's terminal UI over the file system of an
+// archive: the directory that's listed, or the entries matching the search,
+// the entry under the cursor, which is previewed, and the entries selected to
+// be extracted to output.
+type browser struct {
+	fsys          fs.FS
+	title, output string
+
+	dir     string
+	entries []browseEntry
+	// all is every entry in the archive, which is searched, once there's been
+	// a search.
+	all []browseEntry
+
+	cursor, top int
+	selected    map[string]bool
+	query       string
+	searching   bool
+	status      string
+	previews    map[string][]string
+
+	width, height int
+}
+
+func newBrowser(fsys fs.FS, title, output string) (*browser, error) {
+	b := &browser{fsys: fsys, title: title, output: output, dir: ".", selected: map[string]bool{}, previews: map[string][]string{}, width: 80, height: 24}
+	return b, b.list("")
+}
+
+// list lists the directory that's being browsed, or the entries whose names
+// contain the search, directories first, putting the cursor on focus if it's
+// listed.
+func (b *browser) list(focus string) error {
+	var entries []browseEntry
+	if b.query != "" {
+		if b.all == nil {
+			err := fs.WalkDir(b.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+				if err != nil || name == "." {
+					return err
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				b.all = append(b.all, browseEntry{name, info})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		query := strings.ToLower(b.query)
+		for _, entry := range b.all {
+			if strings.Contains(strings.ToLower(path.Base(entry.name)), query) {
+				entries = append(entries, entry)
+			}
+		}
+	} else {
+		dirEntries, err := fs.ReadDir(b.fsys, b.dir)
+		if err != nil {
+			return err
+		}
+		for _, d := range dirEntries {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			entries = append(entries, browseEntry{path.Join(b.dir, d.Name()), info})
+		}
+		slices.SortStableFunc(entries, func(a, b browseEntry) int {
+			if a.info.IsDir() != b.info.IsDir() {
+				if a.info.IsDir() {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(a.name, b.name)
+		})
+	}
+
+	b.entries = entries
+	b.cursor = max(0, slices.IndexFunc(entries, func(entry browseEntry) bool { return entry.name == focus }))
+	b.top = 0
+	return nil
+}
+
+// current returns the entry under the cursor, which has no name if nothing's
+// listed.
+func (b *browser) current() browseEntry {
+	if b.cursor < len(b.entries) {
+		return b.entries[b.cursor]
+	}
+	return browseEntry{}
+}
+
+// relist lists the entries again after the directory or search changed,
+// showing why it failed if it did.
+func (b *browser) relist(focus string) {
+	if err := b.list(focus); err != nil {
+		b.entries = nil
+		b.status = localize("failed to read archive: %s", err)
+	}
+}
+
+// handleKey updates the browser for the key, as returned by parseKeys.
+func (b *browser) handleKey(key string) browseAction {
+	if b.searching {
+		switch key {
+		case "enter":
+			b.searching = false
+		case "esc":
+			b.searching, b.query = false, ""
+			b.relist("")
+		case "backspace":
+			if b.query != "" {
+				_, size := utf8.DecodeLastRuneInString(b.query)
+				b.query = b.query[:len(b.query)-size]
+				b.relist("")
+			}
+		case "ctrl-c":
+			return browseQuit
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				b.query += key
+				b.relist("")
+			}
+		}
+		return browseNone
+	}
+
+	b.status = ""
+	rows := max(1, b.height-2)
+	switch key {
+	case "q", "ctrl-c":
+		return browseQuit
+	case "up", "k":
+		b.cursor--
+	case "down", "j":
+		b.cursor++
+	case "pgup":
+		b.cursor -= rows
+	case "pgdn":
+		b.cursor += rows
+	case "home", "g":
+		b.cursor = 0
+	case "end", "G":
+		b.cursor = len(b.entries) - 1
+	case "enter", "right", "l":
+		entry := b.current()
+		if entry.name == "" {
+			break
+		}
+		if !entry.info.IsDir() {
+			return b.pageable(entry)
+		}
+		b.dir, b.query = entry.name, ""
+		b.relist("")
+	case "left", "h", "backspace":
+		if b.query != "" {
+			b.query = ""
+			b.relist(b.current().name)
+		} else if b.dir != "." {
+			dir := b.dir
+			b.dir = path.Dir(dir)
+			b.relist(dir)
+		}
+	case "esc":
+		if b.query != "" {
+			b.query = ""
+			b.relist(b.current().name)
+		} else {
+			clear(b.selected)
+		}
+	case " ":
+		if entry := b.current(); entry.name != "" {
+			if b.selected[entry.name] {
+				delete(b.selected, entry.name)
+			} else {
+				b.selected[entry.name] = true
+			}
+			b.cursor++
+		}
+	case "/":
+		b.searching = true
+	case "p":
+		if entry := b.current(); entry.name != "" {
+			return b.pageable(entry)
+		}
+	case "x":
+		b.extract()
+	}
+	b.cursor = max(0, min(b.cursor, len(b.entries)-1))
+	return browseNone
+}
+
+// pageable returns browsePage if entry can be shown in the pager, and
+// otherwise shows why it can't.
+func (b *browser) pageable(entry browseEntry) browseAction {
+	if !entry.info.Mode().IsRegular() {
+		b.status = localize("only regular files can be paged")
+		return browseNone
+	}
+	return browsePage
+}
+
+// extract extracts the selected entries, or the one under the cursor if none
+// are selected, to the output directory, showing how it went.
+func (b *browser) extract() {
+	var names []string
+	for name := range b.selected {
+		names = append(names, name)
+	}
+	if len(names) == 0 && b.current().name != "" {
+		names = append(names, b.current().name)
+	}
+	if len(names) == 0 {
+		return
+	}
+	extracted, skipped, err := extractBrowsed(b.fsys, names, b.output)
+	switch {
+	case err != nil:
+		b.status = localize("failed to extract: %s", err)
+	case skipped > 0:
+		b.status = localize("extracted %d entries to %s, skipping %d that aren't regular files or directories", extracted, b.output, skipped)
+	default:
+		b.status = localize("extracted %d entries to %s", extracted, b.output)
+	}
+	clear(b.selected)
+}
+
+// extractBrowsed extracts the entries names in fsys, along with the contents of
+// directories, into dir under their paths in the archive, refusing to replace
+// files that already exist. Entries that aren't regular files or directories
+// are skipped, and counted separately.
+func extractBrowsed(fsys fs.FS, names []string, dir string) (extracted, skipped int, err error) {
+	slices.Sort(names)
+	var roots []string
+	for _, name := range names {
+		// Entries within selected directories are extracted along with them,
+		// so they're skipped if they were selected too.
+		if !slices.ContainsFunc(roots, func(root string) bool { return strings.HasPrefix(name, root+"/") }) {
+			roots = append(roots, name)
+		}
+	}
+
+	for _, name := range roots {
+		err := fs.WalkDir(fsys, name, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dir, filepath.FromSlash(name))
+			switch {
+			case info.IsDir():
+				if err := os.MkdirAll(target, info.Mode().Perm()|0o700); err != nil {
+					return err
+				}
+			case info.Mode().IsRegular():
+				if err := extractBrowsedFile(fsys, name, target, info); err != nil {
+					return err
+				}
+			default:
+				skipped++
+				return nil
+			}
+			extracted++
+			return nil
+		})
+		if err != nil {
+			return extracted, skipped, err
+		}
+	}
+	return extracted, skipped, nil
+}
+
+func extractBrowsedFile(fsys fs.FS, name, target string, info fs.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	src, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := copyContents(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
+
+// preview returns the lines previewing entry: its mode, size and modification
+// time, followed by its contents if it's a directory, the start of its
+// contents if it's a regular file that isn't binary, or its target if it's a
+// symbolic link.
+func (b *browser) preview(entry browseEntry) []string {
+	if entry.name == "" {
+		return nil
+	}
+	if lines, ok := b.previews[entry.name]; ok {
+		return lines
+	}
+
+	info := entry.info
+	lines := []string{fmt.Sprintf("%s  %s  %s", info.Mode(), byteSize(info.Size()), info.ModTime().Format(time.DateTime)), ""}
+	switch {
+	case info.IsDir():
+		dirEntries, err := fs.ReadDir(b.fsys, entry.name)
+		if err != nil {
+			lines = append(lines, localize("failed to read directory: %s", err))
+		}
+		for _, d := range dirEntries {
+			name := d.Name()
+			if d.IsDir() {
+				name += "/"
+			}
+			lines = append(lines, name)
+		}
+	case info.Mode()&fs.ModeSymlink != 0:
+		if info, ok := info.(archives.FileInfo); ok {
+			lines = append(lines, "-> "+info.LinkTarget)
+		}
+	case info.Mode().IsRegular():
+		contents, err := readPreview(b.fsys, entry.name)
+		switch {
+		case err != nil:
+			lines = append(lines, localize("failed to read file: %s", err))
+		case isBinary(contents):
+			lines = append(lines, localize("(binary)"))
+		default:
+			lines = append(lines, strings.Split(strings.ReplaceAll(string(contents), "\t", "    "), "\n")...)
+		}
+	}
+	b.previews[entry.name] = lines
+	return lines
+}
+
+func readPreview(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	contents, err := io.ReadAll(io.LimitReader(f, browsePreviewSize))
+	// Truncating the contents may split a character.
+	return []byte(strings.ToValidUTF8(string(contents), "")), err
+}
+
+// render draws the browser on the terminal w, which it fills: the directory or
+// search at the top, the entries on the left, the preview of the one under the
+// cursor on the right if there's room, and the status or the keys at the
+// bottom.
+func (b *browser) render(w io.Writer) {
+	rows := max(1, b.height-2)
+	if b.cursor < b.top {
+		b.top = b.cursor
+	} else if b.cursor >= b.top+rows {
+		b.top = b.cursor - rows + 1
+	}
+	listWidth := b.width
+	if b.width >= 60 {
+		listWidth = b.width * 2 / 5
+	}
+
+	var s strings.Builder
+	s.WriteString("\x1b[H")
+	header := b.title + ": /" + strings.TrimPrefix(b.dir, ".")
+	if b.query != "" || b.searching {
+		header = b.title + ": " + localize("search") + " /" + b.query
+	}
+	s.WriteString("\x1b[7m" + fitText(header, b.width) + "\x1b[m\x1b[K\r\n")
+
+	preview := b.preview(b.current())
+	for i := range rows {
+		line := ""
+		if j := b.top + i; j < len(b.entries) {
+			line = b.entryLine(b.entries[j], listWidth)
+			if j == b.cursor {
+				line = "\x1b[7m" + line + "\x1b[m"
+			}
+		} else {
+			line = strings.Repeat(" ", listWidth)
+		}
+		s.WriteString(line)
+		if listWidth < b.width {
+			s.WriteString("│")
+			if i < len(preview) {
+				s.WriteString(fitText(preview[i], b.width-listWidth-1))
+			}
+		}
+		s.WriteString("\x1b[K\r\n")
+	}
+
+	footer := b.status
+	if footer == "" {
+		footer = localize(browseHelp)
+		if len(b.selected) > 0 {
+			footer = localize("%d selected", len(b.selected)) + "  " + footer
+		}
+	}
+	s.WriteString(fitText(footer, b.width) + "\x1b[K")
+	io.WriteString(w, s.String())
+}
+
+// entryLine returns the line listing entry, width columns wide: whether it's
+// selected, its name, which is its whole path when searching, and its size if
+// it's a regular file.
+func (b *browser) entryLine(entry browseEntry, width int) string {
+	mark := "  "
+	if b.selected[entry.name] {
+		mark = "* "
+	}
+	name := path.Base(entry.name)
+	if b.query != "" {
+		name = entry.name
+	}
+	size := ""
+	if entry.info.IsDir() {
+		name += "/"
+	} else if entry.info.Mode().IsRegular() {
+		size = " " + byteSize(entry.info.Size()).String()
+	}
+	nameWidth := max(0, width-len(mark)-len(size))
+	return fitText(mark+fitText(name, nameWidth)+size, width)
+}
+
+// fitText returns s, with control characters replaced so that they can't
+// affect the terminal, truncated or padded with spaces to width columns,
+// treating each character as a column.
+func fitText(s string, width int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0) {
+			return '?'
+		}
+		return r
+	}, s)
+	if n := utf8.RuneCountInString(s); n <= width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	if width <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// browseKeyNames are the names parseKeys gives the escape sequences of keys.
+var browseKeyNames = map[string]string{
+	"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+	"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+	"\x1b[H": "home", "\x1b[F": "end", "\x1bOH": "home", "\x1bOF": "end",
+	"\x1b[1~": "home", "\x1b[4~": "end", "\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+}
+
+// parseKeys returns the keys pressed in p, as read from a terminal in raw mode:
+// the characters typed, or the names of keys like up, enter and esc.
+// Unrecognized escape sequences are skipped.
+func parseKeys(p []byte) []string {
+	var keys []string
+	for len(p) > 0 {
+		switch c := p[0]; {
+		case c == 0x1b:
+			n := escapeSequenceLen(p)
+			if n == 1 {
+				keys = append(keys, "esc")
+			} else if key, ok := browseKeyNames[string(p[:n])]; ok {
+				keys = append(keys, key)
+			}
+			p = p[n:]
+			continue
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c == 0x03:
+			keys = append(keys, "ctrl-c")
+		case c < 0x20:
+		default:
+			r, size := utf8.DecodeRune(p)
+			if r != utf8.RuneError {
+				keys = append(keys, string(r))
+			}
+			p = p[size:]
+			continue
+		}
+		p = p[1:]
+	}
+	return keys
+}
+
+// escapeSequenceLen returns the length of the escape sequence p starts with,
+// which is 1 if it's just the escape key.
+func escapeSequenceLen(p []byte) int {
+	if len(p) < 2 || (p[1] != '[' && p[1] != 'O') {
+		return 1
+	}
+	if p[1] == 'O' {
+		return min(len(p), 3)
+	}
+	// Control sequences end with a byte from @ to ~.
+	for i := 2; i < len(p); i++ {
+		if p[i] >= 0x40 && p[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(p)
+}
+
+// page writes the contents of the regular file name in fsys to the pager,
+// which is run by the shell, like git runs $PAGER.
+func page(fsys fs.FS, name, pager string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = f, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// browse opens a terminal UI over the archive's file system, to navigate its
+// directories, preview and search its entries, and extract them or view them
+// in a pager.
+func browse(ctx context.Context) {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		bail("browse needs stdin and stdout to be a terminal")
+	}
+
+	input, _, _ := openExtractor(ctx, cli.Browse.Input)
+	defer closeInput(input)
+
+	fsys, err := archives.FileSystem(ctx, filepath.Base(trimVolumeSuffix(cli.Browse.Input)), input)
+	if err != nil {
+		bail("failed to open archive file system: %s", err)
+	}
+	b, err := newBrowser(fsys, cli.Browse.Input, cli.Browse.Output)
+	if err != nil {
+		bail("failed to read archive: %s", err)
+	}
+
+	var restore func() error
+	enter := func() {
+		if restore, err = rawTerminal(os.Stdin); err != nil {
+			bail("failed to set up terminal: %s", err)
+		}
+		// Switch to the alternate screen, hiding the cursor.
+		fmt.Print("\x1b[?1049h\x1b[?25l\x1b[2J")
+	}
+	leave := func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		restore()
+	}
+	enter()
+	defer leave()
+
+	buf := make([]byte, 256)
+	dirty := true
+	for ctx.Err() == nil {
+		width, height, err := terminalSize(os.Stdout)
+		if err == nil && (width != b.width || height != b.height) {
+			b.width, b.height, dirty = width, height, true
+		}
+		if dirty {
+			b.render(os.Stdout)
+			dirty = false
+		}
+
+		// Reads return nothing every tenth of a second, so that resizes and
+		// interrupts are noticed.
+		n, err := os.Stdin.Read(buf)
+		if err != nil && !errors.Is(err, io.EOF) {
+			bail("failed to read from terminal: %s", err)
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			dirty = true
+			switch b.handleKey(key) {
+			case browseQuit:
+				return
+			case browsePage:
+				leave()
+				err := page(fsys, b.current().name, cmp.Or(cli.Browse.Pager, "less"))
+				enter()
+				if err != nil {
+					b.status = localize("failed to page: %s", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testBrowser(t *testing.T) *browser {
+	t.Helper()
+	fsys := fstest.MapFS{
+		"README.md":         {Data: []byte("# squish\n"), Mode: 0o644},
+		"src/main.go":       {Data: []byte("package main\n\tfunc main() {}\n"), Mode: 0o644},
+		"src/lib/util.go":   {Data: []byte("package lib\n"), Mode: 0o644},
+		"src/lib/image.png": {Data: []byte("\x89PNG\x00\x00"), Mode: 0o644},
+	}
+	b, err := newBrowser(fsys, "test.tar", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func listedNames(b *browser) []string {
+	var names []string
+	for _, entry := range b.entries {
+		names = append(names, entry.name)
+	}
+	return names
+}
+
+func TestBrowserNavigation(t *testing.T) {
+	b := testBrowser(t)
+	if got := listedNames(b); !slices.Equal(got, []string{"src", "README.md"}) {
+		t.Fatalf("listed %q, want directories first", got)
+	}
+	if action := b.handleKey("enter"); action != browseNone || b.dir != "src" {
+		t.Fatalf("entering src: got action %d in %q", action, b.dir)
+	}
+	b.handleKey("down")
+	if action := b.handleKey("enter"); action != browsePage || b.current().name != "src/main.go" {
+		t.Errorf("opening a file: got action %d on %q", action, b.current().name)
+	}
+	b.handleKey("left")
+	if b.dir != "." || b.current().name != "src" {
+		t.Errorf("going back: in %q on %q, want the cursor on the directory that was left", b.dir, b.current().name)
+	}
+	for range 5 {
+		b.handleKey("up")
+	}
+	if b.cursor != 0 {
+		t.Errorf("cursor moved past the first entry to %d", b.cursor)
+	}
+	if b.handleKey("q") != browseQuit {
+		t.Error("q didn't quit")
+	}
+}
+
+func TestBrowserSearch(t *testing.T) {
+	b := testBrowser(t)
+	for _, key := range parseKeys([]byte("/.GOx\x7f\r")) {
+		b.handleKey(key)
+	}
+	if b.searching || b.query != ".GO" {
+		t.Fatalf("searching: %t with query %q", b.searching, b.query)
+	}
+	if got := listedNames(b); !slices.Equal(got, []string{"src/lib/util.go", "src/main.go"}) {
+		t.Errorf("search listed %q", got)
+	}
+	b.handleKey("esc")
+	if got := listedNames(b); !slices.Equal(got, []string{"src", "README.md"}) {
+		t.Errorf("clearing the search listed %q", got)
+	}
+}
+
+func TestBrowserPreview(t *testing.T) {
+	b := testBrowser(t)
+	b.handleKey("enter")
+	b.handleKey("down")
+	if got := b.preview(b.current()); !slices.Contains(got, "    func main() {}") {
+		t.Errorf("text preview: %q", got)
+	}
+	b.handleKey("up")
+	b.handleKey("enter")
+	if got := b.preview(b.current()); !slices.Contains(got, "(binary)") {
+		t.Errorf("binary preview of %s: %q", b.current().name, got)
+	}
+
+	var s strings.Builder
+	b.width, b.height = 80, 10
+	b.render(&s)
+	if out := s.String(); !strings.Contains(out, "test.tar: /src/lib") || !strings.Contains(out, "util.go") || !strings.Contains(out, browseHelp) {
+		t.Errorf("rendered %q", out)
+	}
+}
+
+func TestBrowserExtract(t *testing.T) {
+	b := testBrowser(t)
+	// Select src and its own contents, which are only extracted once.
+	b.handleKey(" ")
+	b.handleKey("up")
+	b.handleKey("enter")
+	b.handleKey(" ")
+	b.handleKey("x")
+	if b.status != "extracted 5 entries to "+b.output {
+		t.Errorf("got status %q", b.status)
+	}
+	data, err := os.ReadFile(filepath.Join(b.output, "src", "lib", "util.go"))
+	if err != nil || string(data) != "package lib\n" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(b.output, "README.md")); err == nil {
+		t.Error("extracted an entry that wasn't selected")
+	}
+
+	// Extracting again doesn't replace what's there.
+	b.handleKey("x")
+	if !strings.HasPrefix(b.status, "failed to extract") {
+		t.Errorf("got status %q", b.status)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("j\x1b[A\x1b[6~\x1b\x1b[99zé\x03"))
+	if want := []string{"j", "up", "pgdn", "esc", "é", "ctrl-c"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFitText(t *testing.T) {
+	for _, test := range []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"abc", 5, "abc  "},
+		{"abcdef", 4, "abc…"},
+		{"a\x1b[2Jb", 6, "a?[2Jb"},
+		{"abc", 0, ""},
+	} {
+		if got := fitText(test.s, test.width); got != test.want {
+			t.Errorf("fitText(%q, %d) = %q, want %q", test.s, test.width, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/mholt/archives"
+)
+
+// bundle archives the inputs, which are usually files that are already
+// compressed, like rotated logs, into a plain tar archive without
+// recompressing them, or with --unbundle, extracts the files in such an
+// archive into a directory.
+func bundle(ctx context.Context) {
+	if cli.Bundle.Unbundle {
+		unbundle(ctx)
+		return
+	}
+
+	if len(cli.Bundle.Files) == 0 {
+		bail("no files were given to bundle")
+	}
+	format, _, err := archives.Identify(ctx, cli.Bundle.Archive, nil)
+	if err != nil {
+		bail("failed to identify format: %s", err)
+	}
+	if _, ok := format.(archives.Tar); !ok {
+		bail("bundles must be plain tar archives, since their files aren't recompressed")
+	}
+	if !cli.Bundle.Force {
+		if _, err := os.Lstat(cli.Bundle.Archive); err == nil {
+			bail("output %s already exists, use --force to replace it", cli.Bundle.Archive)
+		}
+	}
+
+	// Files are stored under their base names, so that the archive is as
+	// flat as the directories of logs that are usually bundled.
+	paths := map[string]string{}
+	names := map[string]string{}
+	for _, file := range cli.Bundle.Files {
+		info, err := os.Stat(file)
+		if err != nil {
+			bail("failed to stat input: %s", err)
+		}
+		if !info.Mode().IsRegular() {
+			bail("%s isn't a regular file, so it can't be bundled", file)
+		}
+		name := filepath.Base(file)
+		if other, ok := names[name]; ok {
+			bail("%s and %s would both be bundled as %s", other, file, name)
+		}
+		names[name] = file
+		paths[file] = name
+	}
+	files, err := archives.FilesFromDisk(ctx, &archives.FromDiskOptions{FollowSymlinks: true}, paths)
+	if err != nil {
+		bail("failed to read inputs: %s", err)
+	}
+
+	progress := newProgress("")
+	defer progress.clear()
+	for i, file := range files {
+		files[i] = interruptibleFile(ctx, progress.file(file))
+	}
+	progress.setTotal(totalSize(files))
+
+	output, err := createAtomic(cli.Bundle.Archive, "")
+	if err != nil {
+		bail("failed to create archive file: %s", err)
+	}
+	defer func() {
+		if err := output.Close(); err != nil {
+			bail("failed to close archive file: %s", err)
+		}
+	}()
+	if err := (archives.Tar{}).Archive(ctx, output, files); err != nil {
+		bail("failed to create archive: %s", err)
+	}
+	output.commit()
+}
+
+// unbundle extracts the regular files in the archive into the directory given
+// in place of the files, or the current directory, under their base names.
+// Existing files are skipped with a warning.
+func unbundle(ctx context.Context) {
+	dir := "."
+	switch len(cli.Bundle.Files) {
+	case 0:
+	case 1:
+		dir = cli.Bundle.Files[0]
+	default:
+		bail("--unbundle takes the archive and at most one directory to extract to")
+	}
+	dirMode := fs.ModeDir | 0o755
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		bail("failed to create output directory: %s", err)
+	}
+
+	input, extractor, inputR := openExtractor(ctx, cli.Bundle.Archive)
+	defer closeInput(input)
+
+	progress := newProgress("")
+	defer progress.clear()
+
+	e := &entryExtractor{
+		root:       pathRoot(dir),
+		outputPath: dir,
+		types:      []string{"f"},
+		overwrite:  "never",
+		progress:   progress,
+		dirMode:    dirMode,
+		times:      true,
+	}
+	err := extractor.Extract(ctx, inputR, func(ctx context.Context, info archives.FileInfo) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		info.NameInArchive = path.Base(info.NameInArchive)
+		return e.extract(ctx, info)
+	})
+	err = errors.Join(err, e.finish())
+	if err != nil {
+		bail("failed to unbundle archive: %s", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBundle(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Unix(1e9, 0)
+	logs := map[string][]byte{}
+	var paths []string
+	for _, name := range []string{"a/app.log.1.gz", "b/app.log.2.gz"} {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		io.WriteString(zw, name)
+		zw.Close()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		logs[filepath.Base(name)] = buf.Bytes()
+		paths = append(paths, path)
+	}
+
+	// Files are stored as they are, under their base names.
+	archive := filepath.Join(dir, "logs.tar")
+	parseCLI(t, append([]string{"bundle", archive}, paths...)...)
+	if code := runCommand(t, func() { bundle(context.Background()) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for range logs {
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(contents, logs[header.Name]) || !header.ModTime.Equal(mtime) {
+			t.Errorf("%s was changed when it was bundled", header.Name)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("got %v after the bundled files, want the end of the archive", err)
+	}
+
+	// Bundles can't be compressed, their files' names can't clash, they can't
+	// hold directories, and they aren't replaced without --force.
+	for _, args := range [][]string{
+		{"bundle", filepath.Join(dir, "logs.tar.gz"), paths[0]},
+		{"bundle", filepath.Join(dir, "clash.tar"), paths[0], dir + "/a/./app.log.1.gz"},
+		{"bundle", filepath.Join(dir, "dir.tar"), filepath.Join(dir, "a")},
+		{"bundle", archive, paths[0]},
+	} {
+		parseCLI(t, args...)
+		if code := runCommand(t, func() { bundle(context.Background()) }); code == 0 {
+			t.Errorf("%q: got no error", args)
+		}
+	}
+
+	// Unbundling skips files that already exist.
+	output := filepath.Join(dir, "out")
+	if err := os.Mkdir(output, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(output, "app.log.1.gz"), []byte("existing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parseCLI(t, "bundle", "--unbundle", archive, output)
+	warned := warnings.Load()
+	if code := runCommand(t, func() { bundle(context.Background()) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	want := map[string]string{"app.log.1.gz": "existing", "app.log.2.gz": string(logs["app.log.2.gz"])}
+	if got := readTree(t, output); !maps.Equal(got, want) {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+	if n := warnings.Load() - warned; n != 1 {
+		t.Errorf("got %d warnings, want 1", n)
+	}
+	if info, err := os.Stat(filepath.Join(output, "app.log.2.gz")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("unbundled file doesn't have its modification time: %v", err)
+	}
+}
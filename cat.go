@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/mholt/archives"
+)
+
+func cat(ctx context.Context) {
+	if archive, entry := nestedEntry(ctx, cli.Cat.Input); entry != "" {
+		if cli.Cat.Entry != "" {
+			bail("an entry path can't be given when the input is an entry within an archive")
+		}
+		cli.Cat.Input, cli.Cat.Entry = archive, entry
+	}
+	selector := newEntrySelector(cli.Cat.Entry, cli.Cat.EntryIndex)
+
+	if events.usesStdout() {
+		bail("--porcelain-fd must be changed from stdout when writing an entry to stdout")
+	}
+
+	input, extractor, inputR := openExtractor(ctx, cli.Cat.Input)
+	defer closeInput(input)
+	if cli.Cat.EntryIndex == nil {
+		extractor = withSeekableEntries(cli.Cat.Input, input, extractor, func(entry indexEntry) bool {
+			return path.Clean(entry.Name) == path.Clean(cli.Cat.Entry)
+		})
+	}
+
+	found := false
+	err := extractor.Extract(ctx, inputR, func(ctx context.Context, info archives.FileInfo) error {
+		if !selector.selects(info) {
+			return nil
+		}
+		found = true
+
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", selector)
+		}
+
+		entry, err := info.Open()
+		if err != nil {
+			return err
+		}
+		defer entry.Close()
+
+		if _, err := io.Copy(withRetries(ctx, os.Stdout), entry); err != nil {
+			return err
+		}
+		return fs.SkipAll
+	})
+	if err != nil {
+		bail("failed to read entry: %s", err)
+	}
+
+	if !found {
+		selector.bailNotFound()
+	}
+}
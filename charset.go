@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mholt/archives"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	texttransform "golang.org/x/text/transform"
+)
+
+// charsets are the character sets that --charset accepts, other than auto and
+// utf-8, by name.
+var charsets = map[string]encoding.Encoding{
+	"cp437":        charmap.CodePage437,
+	"cp850":        charmap.CodePage850,
+	"cp852":        charmap.CodePage852,
+	"cp866":        charmap.CodePage866,
+	"windows-1250": charmap.Windows1250,
+	"windows-1251": charmap.Windows1251,
+	"windows-1252": charmap.Windows1252,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"shift-jis":    japanese.ShiftJIS,
+	"euc-jp":       japanese.EUCJP,
+	"gbk":          simplifiedchinese.GBK,
+	"gb18030":      simplifiedchinese.GB18030,
+	"big5":         traditionalchinese.Big5,
+	"euc-kr":       korean.EUCKR,
+}
+
+// withCharset returns format, decoding the names of its entries that aren't
+// marked as UTF-8 with --charset if it's a zip.
+func withCharset(format archives.Format) archives.Format {
+	if zip, ok := format.(archives.Zip); ok {
+		zip.TextEncoding = zipCharset(cli.Charset)
+		return zip
+	}
+	return format
+}
+
+// zipCharset returns the encoding that the names of zip entries that aren't
+// marked as UTF-8 are decoded with for charset, or nil if they're left as they
+// are.
+func zipCharset(charset string) encoding.Encoding {
+	switch charset {
+	case "", "utf-8":
+		return nil
+	case "auto":
+		return autoCharset{localeCharset()}
+	default:
+		return charsets[charset]
+	}
+}
+
+// localeCharset returns the legacy character set that tools used by the
+// environment's locale are likely to have written zips with, or nil if there
+// isn't one.
+func localeCharset() encoding.Encoding {
+	var lang string
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if lang = os.Getenv(name); lang != "" {
+			break
+		}
+	}
+	lang, _, _ = strings.Cut(lang, ".")
+	switch lang, _, _ = strings.Cut(lang, "@"); {
+	case strings.HasPrefix(lang, "ja"):
+		return japanese.ShiftJIS
+	case lang == "zh_TW" || lang == "zh_HK":
+		return traditionalchinese.Big5
+	case strings.HasPrefix(lang, "zh"):
+		return simplifiedchinese.GB18030
+	case strings.HasPrefix(lang, "ko"):
+		return korean.EUCKR
+	case strings.HasPrefix(lang, "ru"), strings.HasPrefix(lang, "uk"), strings.HasPrefix(lang, "be"):
+		return charmap.CodePage866
+	}
+	return nil
+}
+
+// autoCharset guesses the character set of each name it decodes: names that
+// are valid UTF-8 are left alone, since many tools write them without marking
+// them, and others are decoded with preferred, if it's non-nil and decodes
+// them cleanly, then Shift JIS if that gives Japanese kana, GBK if that gives
+// only Chinese characters, and otherwise CP437, which the zip specification
+// says unmarked names are in.
+type autoCharset struct {
+	preferred encoding.Encoding
+}
+
+func (a autoCharset) NewDecoder() *encoding.Decoder {
+	return &encoding.Decoder{Transformer: autoDecoder{a}}
+}
+
+func (a autoCharset) NewEncoder() *encoding.Encoder {
+	return encoding.Nop.NewEncoder()
+}
+
+type autoDecoder struct {
+	charset autoCharset
+}
+
+func (d autoDecoder) Reset() {}
+
+// Transform decodes all of src at once, since the character set is guessed
+// from the whole of it.
+func (d autoDecoder) Transform(dst, src []byte, atEOF bool) (int, int, error) {
+	if !atEOF {
+		return 0, 0, texttransform.ErrShortSrc
+	}
+	decoded := d.charset.decode(src)
+	if len(dst) < len(decoded) {
+		return 0, 0, texttransform.ErrShortDst
+	}
+	return copy(dst, decoded), len(src), nil
+}
+
+func (a autoCharset) decode(name []byte) []byte {
+	if utf8.Valid(name) {
+		return name
+	}
+	if a.preferred != nil {
+		if decoded, ok := decodeCleanly(a.preferred, name); ok {
+			return decoded
+		}
+	}
+	if decoded, ok := decodeCleanly(japanese.ShiftJIS, name); ok && containsRunes(decoded, unicode.Hiragana, unicode.Katakana) {
+		return decoded
+	}
+	if decoded, ok := decodeCleanly(simplifiedchinese.GBK, name); ok && containsRunes(decoded, unicode.Han) && onlyRunes(decoded, unicode.Han, unicode.Latin, unicode.Common) {
+		return decoded
+	}
+	decoded, _ := charmap.CodePage437.NewDecoder().Bytes(name)
+	return decoded
+}
+
+// decodeCleanly decodes name with charset, reporting false if any of it
+// isn't valid in charset.
+func decodeCleanly(charset encoding.Encoding, name []byte) ([]byte, bool) {
+	decoded, err := charset.NewDecoder().Bytes(name)
+	return decoded, err == nil && !bytes.ContainsRune(decoded, utf8.RuneError)
+}
+
+func containsRunes(s []byte, tables ...*unicode.RangeTable) bool {
+	return bytes.ContainsFunc(s, func(r rune) bool { return unicode.IsOneOf(tables, r) })
+}
+
+func onlyRunes(s []byte, tables ...*unicode.RangeTable) bool {
+	return !bytes.ContainsFunc(s, func(r rune) bool { return !unicode.IsOneOf(tables, r) })
+}
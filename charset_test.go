@@ -0,0 +1,75 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/mholt/archives"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func encodeName(t *testing.T, charset encoding.Encoding, name string) string {
+	encoded, err := charset.NewEncoder().String(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestAutoCharset(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		preferred encoding.Encoding
+		raw       string
+		want      string
+	}{
+		{"utf-8", nil, "ファイル.txt", "ファイル.txt"},
+		{"shift-jis with kana", nil, encodeName(t, japanese.ShiftJIS, "ファイル.txt"), "ファイル.txt"},
+		{"gbk", nil, encodeName(t, simplifiedchinese.GBK, "文件夹/说明.txt"), "文件夹/说明.txt"},
+		{"cp437", nil, encodeName(t, charmap.CodePage437, "Ü.txt"), "Ü.txt"},
+		{"preferred", japanese.ShiftJIS, encodeName(t, japanese.ShiftJIS, "資料.txt"), "資料.txt"},
+	} {
+		decoded, err := (autoCharset{test.preferred}).NewDecoder().String(test.raw)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if decoded != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, decoded, test.want)
+		}
+	}
+}
+
+func TestExtractZipCharset(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	if _, err := w.CreateHeader(&zip.FileHeader{Name: encodeName(t, japanese.ShiftJIS, "テスト.txt"), NonUTF8: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(charset string) { cli.Charset = charset }(cli.Charset)
+	for charset, want := range map[string]string{
+		"auto":      "テスト.txt",
+		"shift-jis": "テスト.txt",
+		"utf-8":     encodeName(t, japanese.ShiftJIS, "テスト.txt"),
+	} {
+		cli.Charset = charset
+		var names []string
+		err := withCharset(archives.Zip{}).(archives.Zip).Extract(context.Background(), bytes.NewReader(buf.Bytes()), func(ctx context.Context, info archives.FileInfo) error {
+			names = append(names, info.NameInArchive)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 1 || names[0] != want {
+			t.Errorf("%s: got names %q, want %q", charset, names, want)
+		}
+	}
+}
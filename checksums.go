@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mholt/archives"
+)
+
+// checksumHashes are the hashes that --manifest accepts, which are written
+// beside the output with the hash's name as the extension.
+var checksumHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// checksumManifest records the digest of the contents of every regular file as
+// it's archived, to write them to a sidecar file in the format of sha256sum,
+// so that extracted files can be checked with sha256sum -c. When extracting,
+// it holds the digests read from such a file, which files are checked
+// against as they're written.
+type checksumManifest struct {
+	newHash func() hash.Hash
+
+	mu      sync.Mutex
+	digests map[string][]byte
+	// found are the names in a manifest read by readChecksums that were
+	// found in the archive being extracted.
+	found map[string]bool
+}
+
+var errChecksumMismatch = errors.New("digest doesn't match the checksums")
+
+func newChecksumManifest(newHash func() hash.Hash) *checksumManifest {
+	return &checksumManifest{newHash: newHash, digests: map[string][]byte{}}
+}
+
+// file returns file with its contents hashed as they're read. Its digest is
+// only recorded once it's read entirely.
+func (m *checksumManifest) file(file archives.FileInfo) archives.FileInfo {
+	if !file.Mode().IsRegular() || file.Open == nil {
+		return file
+	}
+
+	open := file.Open
+	name := strings.TrimPrefix(file.NameInArchive, "/")
+	file.Open = func() (fs.File, error) {
+		f, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &hashingFile{File: f, hash: m.newHash(), done: func(digest []byte) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.digests[name] = digest
+		}}, nil
+	}
+	return file
+}
+
+// write writes the recorded digests to w, sorted by name. Names containing
+// newlines or backslashes are escaped as by sha256sum, with the line starting
+// with a backslash.
+func (m *checksumManifest) write(w io.Writer) error {
+	names := make([]string, 0, len(m.digests))
+	for name := range m.digests {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	bw := bufio.NewWriter(w)
+	escape := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	for _, name := range names {
+		digest := hex.EncodeToString(m.digests[name])
+		if strings.ContainsAny(name, "\n\\") {
+			digest, name = `\`+digest, escape.Replace(name)
+		}
+		bw.WriteString(digest + "  " + name + "\n")
+	}
+	return bw.Flush()
+}
+
+type hashingFile struct {
+	fs.File
+	hash hash.Hash
+	done func([]byte)
+}
+
+func (f *hashingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.hash.Write(p[:n])
+	if err == io.EOF && f.done != nil {
+		f.done(f.hash.Sum(nil))
+		f.done = nil
+	}
+	return n, err
+}
+
+// writeChecksums writes the digests recorded by m to the file at path.
+func writeChecksums(path string, m *checksumManifest) error {
+	f, err := createAtomic(path, "")
+	if err != nil {
+		return err
+	}
+	if err := m.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	f.commit()
+	return f.Close()
+}
+
+// findChecksums returns the path of the manifest written beside input by
+// create --manifest, or "" if there isn't one.
+func findChecksums(input string) string {
+	if input == stdioPath || isURL(input) {
+		return ""
+	}
+	for _, name := range []string{"sha256", "sha512"} {
+		if _, err := os.Stat(input + "." + name); err == nil {
+			return input + "." + name
+		}
+	}
+	return ""
+}
+
+// readChecksums reads the manifest in file, in the format of sha256sum or
+// sha512sum, like those written by create --manifest or SHA256SUMS files,
+// identifying the hash by the length of the digests.
+func readChecksums(file string) (*checksumManifest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *checksumManifest
+	unescape := strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(line, `\`)
+		line = strings.TrimPrefix(line, `\`)
+
+		// Names are preceded by a space, and a space or * for binary mode.
+		digestHex, name, ok := strings.Cut(line, " ")
+		if !ok || name == "" || (name[0] != ' ' && name[0] != '*') {
+			return nil, fmt.Errorf("%s:%d: invalid line", file, i+1)
+		}
+		name = name[1:]
+		if escaped {
+			name = unescape.Replace(name)
+		}
+		digest, err := hex.DecodeString(digestHex)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid digest", file, i+1)
+		}
+
+		if m == nil {
+			switch len(digest) {
+			case sha256.Size:
+				m = newChecksumManifest(sha256.New)
+			case sha512.Size:
+				m = newChecksumManifest(sha512.New)
+			default:
+				return nil, fmt.Errorf("%s:%d: digests must be SHA-256 or SHA-512", file, i+1)
+			}
+		} else if len(digest) != m.newHash().Size() {
+			return nil, fmt.Errorf("%s:%d: digests must all use the same hash", file, i+1)
+		}
+		m.digests[path.Clean(strings.TrimPrefix(name, "/"))] = digest
+	}
+	if m == nil {
+		return nil, errors.New("no digests found")
+	}
+	m.found = map[string]bool{}
+	return m, nil
+}
+
+// check returns r with its contents hashed as they're read, if the entry name
+// is in the manifest, along with a function that fails once r has been read if
+// its digest doesn't match.
+func (m *checksumManifest) check(name string, r io.Reader) (io.Reader, func() error) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	want, ok := m.digests[name]
+	if !ok {
+		return r, func() error { return nil }
+	}
+	m.mu.Lock()
+	m.found[name] = true
+	m.mu.Unlock()
+
+	h := m.newHash()
+	return io.TeeReader(r, h), func() error {
+		if !bytes.Equal(h.Sum(nil), want) {
+			return errChecksumMismatch
+		}
+		return nil
+	}
+}
+
+// notFound returns the names in the manifest that weren't checked, sorted.
+func (m *checksumManifest) notFound() []string {
+	var names []string
+	for name := range m.digests {
+		if !m.found[name] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
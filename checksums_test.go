@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestChecksumManifest(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"a": "a\n", "b\\c": "x", "partial": "abc"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{dir: "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newChecksumManifest(sha256.New)
+	for _, file := range files {
+		file = m.file(file)
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Files that aren't read entirely have no digest.
+		if file.NameInArchive == "in/partial" {
+			_, err = f.Read(make([]byte, 1))
+		} else {
+			_, err = io.Copy(io.Discard, f)
+		}
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := m.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "87428fc522803d31065e7bce3cf03fe475096631e5e07bbd7a0fde60c4cf25c7  in/a\n" +
+		`\2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881  in/b\\c` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got manifest\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestReadChecksums(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "SHA256SUMS")
+	data := "# comment\n" +
+		"87428fc522803d31065e7bce3cf03fe475096631e5e07bbd7a0fde60c4cf25c7 *./in/a\r\n" +
+		`\2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881  in/b\\c` + "\n" +
+		"0000000000000000000000000000000000000000000000000000000000000000  in/missing\n"
+	if err := os.WriteFile(manifest, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readChecksums(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name, data string
+		err        error
+	}{
+		{"in/a", "a\n", nil},
+		{"in/b\\c", "y", errChecksumMismatch},
+		{"in/unlisted", "z", nil},
+	} {
+		r, verify := m.check(test.name, strings.NewReader(test.data))
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			t.Fatal(err)
+		}
+		if err := verify(); !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+	if got := m.notFound(); !slices.Equal(got, []string{"in/missing"}) {
+		t.Errorf("got %q not found, want in/missing", got)
+	}
+
+	if err := os.WriteFile(manifest, []byte("abcd  in/a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readChecksums(manifest); err == nil {
+		t.Error("read manifest with truncated digest")
+	}
+}
+
+func TestExtractChecksums(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{dir: "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newChecksumManifest(sha256.New)
+	m.digests["in/a"] = make([]byte, sha256.Size)
+	m.found = map[string]bool{}
+	e := &entryExtractor{root: pathRoot(t.TempDir()), dirMode: fs.ModeDir | 0o755, checksums: m}
+	for _, file := range files {
+		err = errors.Join(err, e.extract(context.Background(), file))
+	}
+	if !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("got %v, want %v", err, errChecksumMismatch)
+	}
+}
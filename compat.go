@@ -0,0 +1,246 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+// MS-DOS file attributes, which are all that Windows reads from zips.
+const (
+	msdosReadOnly = 0x01
+	msdosDir      = 0x10
+)
+
+// compatZip writes zips that the built-in zip support of a platform can open,
+// for --compat. Every file is compressed with deflate, since that's the only
+// method that's supported everywhere, and names are stored as ASCII, which is
+// the same in CP437, or with the UTF-8 flag set.
+//
+// For windows, entries only have MS-DOS attributes, so they're marked as
+// created on FAT rather than Unix, and symbolic links are skipped with a
+// warning. For macos, entries keep their Unix modes, and symbolic links are
+// stored with their targets as their contents, as Archive Utility expects,
+// rather than the contents of the files they point to. Special files are
+// always skipped with a warning.
+type compatZip struct {
+	archives.Zip
+	platform string
+}
+
+func (z compatZip) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	zw := zip.NewWriter(output)
+	for _, file := range files {
+		if err := z.archiveFile(ctx, zw, file); err != nil {
+			zw.Close()
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (z compatZip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	zw := zip.NewWriter(output)
+	for job := range jobs {
+		job.Result <- z.archiveFile(ctx, zw, job.File)
+	}
+	return zw.Close()
+}
+
+func (z compatZip) archiveFile(ctx context.Context, zw *zip.Writer, file archives.FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mode := file.Mode()
+	symlink := mode&fs.ModeSymlink != 0
+	if symlink && z.platform == "windows" {
+		warn("skipped symbolic link %s, which Windows can't extract from zips", file.NameInArchive)
+		return nil
+	}
+	if !mode.IsRegular() && !mode.IsDir() && !symlink {
+		warn("skipped special file %s, which can't be stored in zips", file.NameInArchive)
+		return nil
+	}
+	if elem, ok := windowsInvalidElem(file.NameInArchive); ok && z.platform == "windows" {
+		warn("%s may not be extracted on Windows, since %q isn't a valid file name there", file.NameInArchive, elem)
+	}
+
+	header := &zip.FileHeader{Modified: file.ModTime()}
+	if z.platform == "windows" {
+		if mode&0o200 == 0 {
+			header.ExternalAttrs |= msdosReadOnly
+		}
+		if mode.IsDir() {
+			header.ExternalAttrs |= msdosDir
+		}
+	} else {
+		header.SetMode(mode)
+	}
+	header.Name = strings.TrimPrefix(file.NameInArchive, "/")
+	header.Method = zip.Deflate
+	header.Extra = zipExtra(file)
+	if mode.IsDir() {
+		header.Name = strings.TrimSuffix(header.Name, "/") + "/"
+		header.Method = zip.Store
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+	}
+	if mode.IsDir() {
+		return nil
+	}
+	if symlink {
+		_, err := io.WriteString(w, file.LinkTarget)
+		return err
+	}
+
+	input, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	if _, err := io.Copy(w, input); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.NameInArchive, err)
+	}
+	return nil
+}
+
+// ustarTar writes plain ustar archives, for --compat=busybox, since minimal tar
+// implementations like BusyBox's don't support PAX or GNU extensions. Entries
+// that ustar can't represent, like those with long names, large sizes, or
+// non-ASCII names, are skipped with a warning. Timestamps are rounded to the
+// nearest second, since ustar only stores seconds.
+type ustarTar struct {
+	archives.Tar
+}
+
+func (t ustarTar) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	tw := tar.NewWriter(output)
+	for _, file := range files {
+		if err := t.archiveFile(ctx, tw, file); err != nil {
+			tw.Close()
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (t ustarTar) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	tw := tar.NewWriter(output)
+	for job := range jobs {
+		job.Result <- t.archiveFile(ctx, tw, job.File)
+	}
+	return tw.Close()
+}
+
+func (t ustarTar) archiveFile(ctx context.Context, tw *tar.Writer, file archives.FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(file, file.LinkTarget)
+	if err != nil {
+		warn("skipped %s, which can't be stored in tar archives: %s", file.NameInArchive, err)
+		return nil
+	}
+	header.Name = file.NameInArchive
+	if file.IsDir() {
+		header.Name = strings.TrimSuffix(header.Name, "/") + "/"
+	}
+	header.Format = tar.FormatUSTAR
+	header.ModTime = header.ModTime.Round(time.Second)
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	header.PAXRecords = nil
+	if t.NumericUIDGID {
+		header.Uname, header.Gname = "", ""
+	}
+
+	// Headers that can't be encoded aren't written, and don't stop further
+	// headers from being written.
+	if err := tw.WriteHeader(header); err != nil {
+		warn("skipped %s, which can't be stored in plain ustar: %s", file.NameInArchive, err)
+		return nil
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	input, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	if _, err := io.Copy(tw, input); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.NameInArchive, err)
+	}
+	return nil
+}
+
+// compatFormat returns the format to create for --compat=compat in place of
+// format, which is nil if it couldn't be identified from the output path, or
+// false if format isn't compatible.
+func compatFormat(compat string, format archives.Format) (archives.Format, bool) {
+	if compat == "busybox" {
+		switch format := format.(type) {
+		case nil:
+			return ustarTar{}, true
+		case archives.Tar:
+			return ustarTar{format}, true
+		case archives.CompressedArchive:
+			if tar, ok := format.Archival.(archives.Tar); ok {
+				format.Archival = ustarTar{tar}
+				return format, true
+			}
+		}
+		return nil, false
+	}
+
+	switch format.(type) {
+	case nil, archives.Zip:
+		return compatZip{platform: compat}, true
+	}
+	return nil, false
+}
+
+// windowsReservedNames are the device names that Windows doesn't allow as file
+// names, even with an extension.
+var windowsReservedNames = []string{"CON", "PRN", "AUX", "NUL", "COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9", "LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9"}
+
+// windowsInvalidElem returns the first element of the slash-separated name
+// that isn't a valid file name on Windows, if there is one.
+func windowsInvalidElem(name string) (string, bool) {
+	for _, elem := range strings.Split(strings.Trim(name, "/"), "/") {
+		base, _, _ := strings.Cut(elem, ".")
+		switch {
+		case strings.ContainsAny(elem, `<>:"\|?*`),
+			strings.ContainsFunc(elem, func(r rune) bool { return r < 0x20 }),
+			elem != "." && elem != ".." && strings.HasSuffix(elem, "."),
+			strings.HasSuffix(elem, " "),
+			slices.ContainsFunc(windowsReservedNames, func(reserved string) bool { return strings.EqualFold(reserved, strings.TrimRight(base, " ")) }):
+			return elem, true
+		}
+	}
+	return "", false
+}
+
+// isMacosxMetadata reports whether the slash-separated name is in the __MACOSX
+// directory or is an AppleDouble file, where macOS stores metadata like
+// extended attributes and resource forks when archiving.
+func isMacosxMetadata(name string) bool {
+	first, _, _ := strings.Cut(name, "/")
+	return first == "__MACOSX" || strings.HasPrefix(path.Base(name), "._")
+}
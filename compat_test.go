@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWindowsInvalidElem(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"a/b.txt", ""},
+		{"a/b/", ""},
+		{"./a/../b", ""},
+		{"a/b:c", "b:c"},
+		{"a?/b", "a?"},
+		{"a/trailing.", "trailing."},
+		{"a/trailing ", "trailing "},
+		{"con", "con"},
+		{"a/NUL.txt", "NUL.txt"},
+		{"a/COM10", ""},
+		{"a/console", ""},
+	}
+
+	for _, test := range tests {
+		if got, _ := windowsInvalidElem(test.name); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestIsMacosxMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"__MACOSX", true},
+		{"__MACOSX/a/._b", true},
+		{"a/._b", true},
+		{"a/b", false},
+		{"a/__MACOSX", false},
+		{"a/.b", false},
+	}
+
+	for _, test := range tests {
+		if got := isMacosxMetadata(test.name); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompatBusybox(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("l", 150)
+	for _, name := range []string{"in/short.txt", "in/" + long, "in/café.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Unix(1e9, 7e8)
+	if err := os.Chtimes(filepath.Join(dir, "in/short.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries that plain ustar can't store are skipped with a warning.
+	output := filepath.Join(dir, "out.tar")
+	parseCLI(t, "create", "--compat", "busybox", "-C", dir, output, "in")
+	warned := warnings.Load()
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if n := warnings.Load() - warned; n != 2 {
+		t.Errorf("got %d warnings, want 2", n)
+	}
+
+	archive, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		if header.Format != tar.FormatUSTAR {
+			t.Errorf("%s: got format %s, want ustar", header.Name, header.Format)
+		}
+		if header.Name == "in/short.txt" && !header.ModTime.Equal(mtime.Round(time.Second)) {
+			t.Errorf("%s: got time %s, want %s", header.Name, header.ModTime, mtime.Round(time.Second))
+		}
+	}
+	if want := []string{"in/", "in/short.txt"}; !slices.Equal(names, want) {
+		t.Errorf("got entries %q, want %q", names, want)
+	}
+
+	parseCLI(t, "create", "--compat", "busybox", "-C", dir, filepath.Join(dir, "out.zip"), "in")
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code == 0 {
+		t.Error("--compat=busybox was accepted for a zip")
+	}
+}
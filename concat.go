@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strconv"
+
+	"github.com/mholt/archives"
+)
+
+// tarBlockSize is the size of the blocks that tar archives are made of.
+const tarBlockSize = 512
+
+// concatTar reads tar archives that continue past their end-of-archive marker,
+// like GNU tar's --ignore-zeros. Blocks of zeros, which end each of a number
+// of concatenated archives and which some tools pad archives with, are
+// skipped, and reading stops at the end of the input or at trailing data
+// that isn't a tar header, which is reported with a warning.
+type concatTar struct {
+	archives.Tar
+}
+
+func (t concatTar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile archives.FileHandler) error {
+	r := bufio.NewReader(sourceArchive)
+
+	// archives.Tar stops without an error when fs.SkipAll is returned, which
+	// must stop the following archives from being read too.
+	stopped := false
+	handle := func(ctx context.Context, info archives.FileInfo) error {
+		err := handleFile(ctx, info)
+		if errors.Is(err, fs.SkipAll) {
+			stopped = true
+		}
+		return err
+	}
+
+	for {
+		if err := t.Tar.Extract(ctx, r, handle); err != nil || stopped {
+			return err
+		}
+
+		block, err := skipZeroBlocks(r)
+		if len(bytes.Trim(block, "\x00")) == 0 && err == io.EOF {
+			return nil
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		if !isTarHeader(block) {
+			warn("ignored trailing data after the end of the tar archive")
+			return nil
+		}
+	}
+}
+
+// skipZeroBlocks discards blocks of zeros from r, returning the next block
+// without discarding it, which is shorter than a block at the end of the
+// input.
+func skipZeroBlocks(r *bufio.Reader) ([]byte, error) {
+	zeroBlock := make([]byte, tarBlockSize)
+	for {
+		block, err := r.Peek(tarBlockSize)
+		if err != nil || !bytes.Equal(block, zeroBlock) {
+			return block, err
+		}
+		if _, err := r.Discard(tarBlockSize); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isTarHeader reports whether block is a tar header with a valid checksum,
+// which is the sum of its bytes with those of the checksum field taken to be
+// spaces. Some historic implementations summed signed bytes, which is
+// accepted too.
+func isTarHeader(block []byte) bool {
+	if len(block) < tarBlockSize {
+		return false
+	}
+
+	field := bytes.Trim(block[148:156], " \x00")
+	want, err := strconv.ParseInt(string(field), 8, 64)
+	if err != nil {
+		return false
+	}
+
+	var unsigned, signed int64
+	for i, b := range block[:tarBlockSize] {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		unsigned += int64(b)
+		signed += int64(int8(b))
+	}
+	return want == unsigned || want == signed
+}
+
+// withIgnoreZeros makes format read concatenated tar archives with concatTar,
+// if it's a tar archive.
+func withIgnoreZeros(format archives.Extractor) archives.Extractor {
+	switch format := format.(type) {
+	case archives.CompressedArchive:
+		if tar, ok := format.Extraction.(archives.Tar); ok {
+			format.Extraction = concatTar{tar}
+		}
+		return format
+
+	case archives.Tar:
+		return concatTar{format}
+
+	default:
+		return format
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"maps"
+	"testing"
+)
+
+func TestConcatTar(t *testing.T) {
+	first := makeTar(t, []testEntry{{name: "a", typeflag: tar.TypeReg, contents: "a"}})
+	second := makeTar(t, []testEntry{{name: "b", typeflag: tar.TypeReg, contents: "b"}})
+	padding := make([]byte, 3*tarBlockSize)
+
+	tests := []struct {
+		name    string
+		archive []byte
+		want    map[string]string
+	}{
+		{"single", first, map[string]string{"a": "a"}},
+		{"concatenated", append(bytes.Clone(first), second...), map[string]string{"a": "a", "b": "b"}},
+		{"padded", bytes.Join([][]byte{first, padding, second, padding[:100]}, nil), map[string]string{"a": "a", "b": "b"}},
+		{"trailing garbage", append(bytes.Clone(first), bytes.Repeat([]byte("garbage"), 100)...), map[string]string{"a": "a"}},
+	}
+
+	for _, test := range tests {
+		_, output, err := extractTest(t, concatTar{}, test.archive, &entryExtractor{})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got := readTree(t, output); !maps.Equal(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestIsTarHeader(t *testing.T) {
+	archive := makeTar(t, []testEntry{{name: "a", typeflag: tar.TypeReg, contents: "a"}})
+	if !isTarHeader(archive[:tarBlockSize]) {
+		t.Error("header wasn't recognized")
+	}
+
+	corrupted := bytes.Clone(archive[:tarBlockSize])
+	corrupted[0] ^= 1
+	if isTarHeader(corrupted) {
+		t.Error("header with an invalid checksum was recognized")
+	}
+	if isTarHeader(make([]byte, tarBlockSize)) {
+		t.Error("zero block was recognized")
+	}
+}
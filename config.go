@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alecthomas/kong"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// configEnv is the environment variable that gives the path of the config
+// file, overriding the default one.
+const configEnv = "SQUISH_CONFIG"
+
+// configKeys are the flags that the config file gives defaults for.
+var configKeys = []string{"format", "level", "threads", "exclude", "overwrite"}
+
+// formatConfigKeys are the keys of the tables defining formats run by other
+// programs, like [format.lzo].
+var formatConfigKeys = []string{"compress", "decompress", "magic", "archive"}
+
+// configHelp documents the config file.
+const configHelp = "Defaults for --format, --level, --threads, --exclude and --overwrite are read from squish/config.toml in $XDG_CONFIG_HOME, or in ~/.config, or the file given by $SQUISH_CONFIG. Keys at the top of it apply to every command with the flag, and those in a table named after a command, like [create], only to that command, taking precedence. Environment variables like SQUISH_LEVEL override the config file, and flags override both. Formats squish doesn't support can be added with tables like [format.lzo], in which compress and decompress are arrays of a program and its arguments that compress their standard input to their standard output and back, and magic is the hex of what files in the format start with, if anything. With archive = true, the programs instead convert a tar archive to the format and back."
+
+// configured records the flags whose values came from the config file or the
+// environment, rather than the command line.
+var configured = map[string]bool{}
+
+// config holds the defaults read from the config file, by the tables they're
+// in, which are named after commands, or empty for those at the top, and then
+// by their keys. Values are kept as they would be given on the command line,
+// with the elements of arrays separated by commas.
+type config map[string]map[string]string
+
+// configPath returns the path of the config file given by $SQUISH_CONFIG, or
+// the default one in the user's config directory.
+func configPath() (string, error) {
+	if path := os.Getenv(configEnv); path != "" {
+		return path, nil
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "squish", "config.toml"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "squish", "config.toml"), nil
+}
+
+// loadConfig reads the config file, which doesn't have to exist.
+func loadConfig() (config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// parseConfig parses the config file, in which keys' values are strings,
+// integers, booleans, or arrays of strings.
+func parseConfig(r io.Reader) (config, error) {
+	var raw map[string]any
+	if _, err := toml.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	c := config{"": {}}
+	for key, value := range raw {
+		table, ok := value.(map[string]any)
+		if !ok {
+			if err := c.set("", key, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Formats are defined by the tables within [format], like
+		// [format.lzo].
+		tables := map[string]map[string]any{key: table}
+		if key == "format" {
+			tables = map[string]map[string]any{}
+			for name, value := range table {
+				format, ok := value.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("[format]: %s must be a table like [format.%s]", name, name)
+				}
+				tables["format."+name] = format
+			}
+		}
+		for name, table := range tables {
+			c[name] = map[string]string{}
+			for key, value := range table {
+				if err := c.set(name, key, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return c, nil
+}
+
+// set sets key in table of c to value, as it would be given on the command
+// line, with the elements of arrays separated by commas.
+func (c config) set(table, key string, value any) error {
+	keys := configKeys
+	if strings.HasPrefix(table, "format.") {
+		keys = formatConfigKeys
+	}
+	if !slices.Contains(keys, key) {
+		return fmt.Errorf("%sunknown key %q, expected one of %s", tablePrefix(table), key, strings.Join(keys, ", "))
+	}
+
+	switch value := value.(type) {
+	case string:
+		c[table][key] = value
+	case int64:
+		c[table][key] = strconv.FormatInt(value, 10)
+	case bool:
+		c[table][key] = strconv.FormatBool(value)
+	case []any:
+		elements := make([]string, len(value))
+		for i, element := range value {
+			s, ok := element.(string)
+			if !ok {
+				return fmt.Errorf("%sinvalid value for %s: expected an array of strings", tablePrefix(table), key)
+			}
+			elements[i] = strings.ReplaceAll(s, ",", `\,`)
+		}
+		c[table][key] = strings.Join(elements, ",")
+	default:
+		return fmt.Errorf("%sinvalid value for %s: expected a string, integer, boolean or array", tablePrefix(table), key)
+	}
+	return nil
+}
+
+// tablePrefix returns the prefix of errors in table, which is empty for the
+// top of the config file.
+func tablePrefix(table string) string {
+	if table == "" {
+		return ""
+	}
+	return "[" + table + "]: "
+}
+
+// splitConfigArray splits an array value of the config file, as returned by
+// parseConfig, into its elements.
+func splitConfigArray(value string) []string {
+	var elements []string
+	var element strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case strings.HasPrefix(value[i:], `\,`):
+			element.WriteByte(',')
+			i++
+		case value[i] == ',':
+			elements = append(elements, element.String())
+			element.Reset()
+		default:
+			element.WriteByte(value[i])
+		}
+	}
+	if value != "" {
+		elements = append(elements, element.String())
+	}
+	return elements
+}
+
+// registerFormats registers the formats run by other programs that are
+// defined by tables of c like [format.lzo].
+func (c config) registerFormats() error {
+	tables := make([]string, 0, len(c))
+	for table := range c {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+
+	for _, table := range tables {
+		name, ok := strings.CutPrefix(table, "format.")
+		if !ok {
+			continue
+		}
+		keys := c[table]
+		format := squish.ExternalFormat{
+			Name:       name,
+			Compress:   splitConfigArray(keys["compress"]),
+			Decompress: splitConfigArray(keys["decompress"]),
+		}
+		if len(format.Compress) == 0 && len(format.Decompress) == 0 {
+			return fmt.Errorf("[%s]: compress or decompress must be given", table)
+		}
+		var err error
+		if format.Magic, err = hex.DecodeString(keys["magic"]); err != nil {
+			return fmt.Errorf("[%s]: invalid magic: %w", table, err)
+		}
+		if archive, ok := keys["archive"]; ok {
+			if format.Archive, err = strconv.ParseBool(archive); err != nil {
+				return fmt.Errorf("[%s]: invalid value for archive: %q", table, archive)
+			}
+		}
+		if err := squish.RegisterFormat(format.Format()); err != nil {
+			return fmt.Errorf("[%s]: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// resolver returns a resolver of the flags' defaults from c, which are
+// overridden by environment variables named after the flags, like
+// SQUISH_LEVEL. The flags it resolves are recorded in configured.
+func (c config) resolver() kong.Resolver {
+	return kong.ResolverFunc(func(_ *kong.Context, parent *kong.Path, flag *kong.Flag) (any, error) {
+		if parent.Command == nil || !slices.Contains(configKeys, flag.Name) {
+			return nil, nil
+		}
+		value, ok := os.LookupEnv("SQUISH_" + strings.ToUpper(flag.Name))
+		if !ok {
+			value, ok = c[parent.Command.Name][flag.Name]
+		}
+		if !ok {
+			value, ok = c[""][flag.Name]
+		}
+		if !ok {
+			return nil, nil
+		}
+		configured[flag.Name] = true
+		return value, nil
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := parseConfig(strings.NewReader(`# Defaults
+threads = 4
+[create]
+format = "tar.zst" # compressed with zstd
+level = 1_9
+exclude = [
+	"*.tmp", # scratch files
+	'a,b',
+]
+[extract]
+overwrite = 'newer'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config{
+		"":        {"threads": "4"},
+		"create":  {"format": "tar.zst", "level": "19", "exclude": `*.tmp,a\,b`},
+		"extract": {"overwrite": "newer"},
+	}
+	if !maps.EqualFunc(c, want, maps.Equal) {
+		t.Errorf("got %v, want %v", c, want)
+	}
+
+	for _, invalid := range []string{
+		"levl = 3",
+		"level = x",
+		"level = 1.5",
+		`format = "tar`,
+		`exclude = ["a" "b"]`,
+		"exclude = [1]",
+		"[create",
+		"exclude = [",
+		"[create.sub]\nlevel = 1",
+		"[format]\nlzo = 1",
+	} {
+		if _, err := parseConfig(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q: got no error", invalid)
+		}
+	}
+}
+
+func TestConfigResolver(t *testing.T) {
+	c := config{
+		"":       {"threads": "4", "level": "3"},
+		"create": {"level": "9", "exclude": "*.tmp,*.bak"},
+	}
+	t.Setenv("SQUISH_THREADS", "2")
+
+	var args struct {
+		Create struct {
+			Level   *int
+			Threads int
+			Exclude []glob
+			Format  string
+		} `cmd:""`
+	}
+	parser, err := kong.New(&args, kong.Resolvers(c.resolver()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.Parse([]string{"create", "--format", "zip"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if args.Create.Level == nil || *args.Create.Level != 9 {
+		t.Errorf("got level %v, want 9 from [create]", args.Create.Level)
+	}
+	if args.Create.Threads != 2 {
+		t.Errorf("got %d threads, want 2 from the environment", args.Create.Threads)
+	}
+	if len(args.Create.Exclude) != 2 || args.Create.Exclude[1] != "*.bak" {
+		t.Errorf("got exclude %v, want *.tmp and *.bak", args.Create.Exclude)
+	}
+	if args.Create.Format != "zip" {
+		t.Errorf("got format %q, want zip from the command line", args.Create.Format)
+	}
+}
+
+func TestConfigFormats(t *testing.T) {
+	c, err := parseConfig(strings.NewReader(`[format.cfgz]
+compress = ["gzip", "-c"]
+decompress = ["gzip", "-dc"]
+magic = "c0ffee"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.registerFormats(); err != nil {
+		t.Fatal(err)
+	}
+	format, err := squish.LookupFormat("tar.cfgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := format.Extension(); got != ".tar.cfgz" {
+		t.Errorf("got extension %q, want .tar.cfgz", got)
+	}
+
+	for _, invalid := range []string{
+		"[format.a]\nlevel = 3",
+		"[format.b]\nmagic = \"1f\"",
+		"[format.c]\ncompress = [\"gzip\"]\nmagic = \"zz\"",
+		"[format.d]\ncompress = [\"gzip\"]\narchive = \"maybe\"",
+		"[format.zip]\ncompress = [\"zip\"]",
+	} {
+		c, err := parseConfig(strings.NewReader(invalid))
+		if err == nil {
+			err = c.registerFormats()
+		}
+		if err == nil {
+			t.Errorf("%q: got no error", invalid)
+		}
+	}
+}
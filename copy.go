@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers that extracted contents are copied
+// through, which is larger than io.Copy's, so that fewer writes are made to
+// the output, and fewer reads are made from decompressors.
+const copyBufferSize = 1 << 20
+
+// preallocateMinSize is the size of the smallest files whose space is
+// allocated before they're written, since smaller ones aren't fragmented
+// enough for it to be worth another system call.
+const preallocateMinSize = 1 << 20
+
+var copyBuffers = sync.Pool{New: func() any { return new([copyBufferSize]byte) }}
+
+// copyContents copies src to dst as io.Copy does, through a buffer that's
+// reused between copies.
+func copyContents(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[copyBufferSize]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(dst, src, buf[:])
+}
+
+// copyFileRange copies the first size bytes of src to output, which is written
+// from its start, counting them towards limits. The copy is made within the
+// kernel where that's supported, like with copy_file_range on Linux, which
+// filesystems like Btrfs and XFS make reflinks for, sharing their blocks.
+func copyFileRange(output, src *os.File, size int64, limits *extractLimits) (int64, error) {
+	if err := limits.grow(size); err != nil {
+		return 0, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	// *os.File only copies within the kernel from other *os.Files, or
+	// *io.LimitedReaders of them.
+	return output.ReadFrom(&io.LimitedReader{R: src, N: size})
+}
@@ -0,0 +1,1149 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"filippo.io/age"
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// create creates the archive given by cli.Create, replacing any output that
+// already exists if force is true.
+func create(ctx context.Context, force bool) {
+	// The placeholders in the output are replaced before anything else
+	// uses its path.
+	template, now := cli.Create.Output, time.Now()
+	if output, err := expandTemplate(template, now); err != nil {
+		bail("invalid output: %s", err)
+	} else {
+		cli.Create.Output = output
+	}
+	also := make([]string, len(cli.Create.Also))
+	for i, output := range cli.Create.Also {
+		var err error
+		if also[i], err = expandTemplate(output, now); err != nil {
+			bail("invalid --also: %s", err)
+		}
+	}
+
+	stdin := slices.Contains(cli.Create.Inputs, stdioPath)
+	if stdin && (len(cli.Create.Inputs) > 1 || cli.Create.FilesFrom != "") {
+		bail("stdin must be the only input when it is used")
+	}
+	if (cli.Create.Xattrs || cli.Create.ACLs || cli.Create.Capabilities) && !xattrsSupported {
+		bail("--xattrs, --acls and --capabilities are only supported on Linux")
+	}
+
+	if cli.Create.MaxSize > 0 && cli.Create.MinSize > cli.Create.MaxSize {
+		bail("--min-size can't be larger than --max-size")
+	}
+	if cli.Create.Prefix != "" && !filepath.IsLocal(filepath.FromSlash(path.Clean(cli.Create.Prefix))) {
+		bail("--prefix must be a relative path that doesn't refer to a parent directory")
+	}
+
+	// Archives written to outputs that can't be seeked, like stdout, are
+	// streamed, with their inputs discovered as they're archived, unless
+	// that's ruled out once the format is known.
+	outputs := existingOutputs(cli.Create.Output, cli.Create.SplitSize > 0)
+	for _, output := range also {
+		outputs = append(outputs, existingOutputs(output, false)...)
+	}
+	streaming := canStream(stdin)
+	var files []archives.FileInfo
+	if !streaming {
+		files = discoverFiles(ctx, outputs)
+	}
+
+	// The snapshot is of every file, but only those that changed since the
+	// last one are archived.
+	var nextSnapshot *snapshot
+	if cli.Create.ListedIncremental != "" {
+		previous, err := readSnapshot(cli.Create.ListedIncremental)
+		if err != nil {
+			bail("failed to read snapshot: %s", err)
+		}
+		files, nextSnapshot = previous.changed(files)
+	}
+
+	// Like tar -u, only the files that are newer than the entries already in
+	// the archive are appended to it.
+	var update *tarUpdate
+	if cli.Create.Update {
+		if cli.Create.Output == stdioPath || isURL(cli.Create.Output) || isStreamOutput(cli.Create.Output) || cli.Create.SplitSize > 0 {
+			bail("--update can only be used with outputs on disk that aren't split")
+		}
+		var err error
+		update, err = readTarUpdate(cli.Create.Output)
+		if errors.Is(err, errUpdateFormat) {
+			bail("--update can only be used with uncompressed tar archives")
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			bail("failed to read archive to update: %s", err)
+		}
+		if update != nil {
+			files = update.newer(files)
+		}
+	}
+
+	if cli.Create.Output == stdioPath && events.usesStdout() {
+		bail("--porcelain-fd must be changed from stdout when writing output to stdout")
+	}
+
+	// Estimating and dry runs don't write the archive, so there's nothing to summarize.
+	summary := cli.Create.Summary
+	if cli.Create.Estimate || cli.Create.DryRun {
+		summary = ""
+	}
+	progress := newProgress(summary)
+	defer progress.clear()
+
+	var checksums *checksumManifest
+	if cli.Create.Manifest != "" {
+		if cli.Create.Output == stdioPath || isURL(cli.Create.Output) || isStreamOutput(cli.Create.Output) {
+			bail("--manifest can only be used with outputs on disk")
+		}
+		checksums = newChecksumManifest(checksumHashes[cli.Create.Manifest])
+	}
+	// The sidecar holds the digest of a sha256 manifest, which is recorded
+	// separately unless that's the one being written.
+	var sidecarChecksums *checksumManifest
+	if cli.Create.Sidecar != "" {
+		if cli.Create.Output == stdioPath || isURL(cli.Create.Output) || isStreamOutput(cli.Create.Output) || cli.Create.SplitSize > 0 {
+			bail("--sidecar can only be used with outputs on disk that aren't split")
+		}
+		sidecarChecksums = checksums
+		if cli.Create.Manifest != "sha256" {
+			sidecarChecksums = newChecksumManifest(checksumHashes["sha256"])
+		}
+	}
+
+	var format archives.Format
+	var err error
+	if cli.Create.Auto {
+		if cli.Create.Format != "" || cli.Create.Level != nil || cli.Create.Compat != "" || cli.Create.Update {
+			bail("--auto can't be used with --format, --level, --compat or --update")
+		}
+		if stdin {
+			bail("--auto can't be used when compressing stdin")
+		}
+		if format, err = autoFormat(files); err != nil {
+			bail("failed to sample files: %s", err)
+		}
+		if cli.Create.Output != stdioPath && !isStreamOutput(cli.Create.Output) {
+			cli.Create.Output = withAutoExtension(cli.Create.Output, format.Extension())
+			history.setOutput(cli.Create.Output)
+		}
+	} else if cli.Create.Format != "" {
+		format, err = squish.LookupFormat(cli.Create.Format)
+	} else if cli.Create.Output == stdioPath {
+		bail("the format must be specified with --format when writing to stdout")
+	} else {
+		outputName := cli.Create.Output
+		if isURL(outputName) {
+			outputName = urlBaseName(outputName)
+		}
+		if ageRecipients, gpgRecipients := encryptionRecipients(); len(ageRecipients) > 0 {
+			outputName = strings.TrimSuffix(outputName, ageExtension)
+		} else if len(gpgRecipients) > 0 {
+			outputName, _ = trimGPGExtension(outputName)
+		}
+		if variant, ok := squish.LookupZipVariant(path.Ext(outputName)); ok {
+			format = variant
+		} else {
+			format, _, err = archives.Identify(ctx, outputName, nil)
+		}
+	}
+	if cli.Create.Compat != "" {
+		// Outputs without an extension can't be identified, but are fine.
+		if cli.Create.Format == "" && err != nil {
+			format, err = nil, nil
+		}
+
+		var ok bool
+		if format, ok = compatFormat(cli.Create.Compat, format); !ok && cli.Create.Compat == "busybox" {
+			bail("--compat=busybox can only be used to create tar archives")
+		} else if !ok {
+			bail("--compat=%s can only be used to create zip archives", cli.Create.Compat)
+		}
+	}
+	if err != nil {
+		bail("failed to identify format: %s", err)
+	}
+	if cli.Create.TarFormat != "" {
+		if cli.Create.Compat != "" {
+			bail("--tar-format can't be used with --compat")
+		}
+		var ok bool
+		if format, ok = withTarFormat(format, cli.Create.TarFormat); !ok {
+			bail("--tar-format can only be used to create tar archives")
+		}
+	}
+	if cli.Create.ZipMethod != "" {
+		if cli.Create.Compat != "" {
+			bail("--zip-method can't be used with --compat")
+		}
+		if cli.Create.Level != nil && !configured["level"] && cli.Create.ZipMethod != "deflate" && cli.Create.ZipMethod != "auto" {
+			bail("--level can only be used with --zip-method=deflate or auto")
+		}
+		if cli.Create.Password.given() && cli.Create.ZipMethod != "store" && cli.Create.ZipMethod != "deflate" {
+			bail("--password can only be used with --zip-method=store or deflate")
+		}
+		var ok bool
+		if format, ok = withZipMethod(format, cli.Create.ZipMethod); !ok {
+			bail("--zip-method can only be used to create zip archives")
+		}
+	}
+	zip64 := cli.Create.Zip64
+	zip64ForbiddenBy := "--zip64=never"
+	if cli.Create.Compat == "windows" {
+		if zip64 == "always" {
+			bail("--zip64=always can't be used with --compat=windows, since older versions of Windows can't open zips with zip64 records")
+		}
+		zip64, zip64ForbiddenBy = "never", "--compat=windows"
+	}
+	if zip64 != "auto" && !isZip(format) {
+		bail("--zip64 can only be used to create zip archives")
+	}
+	if cli.Create.Comment != "" {
+		if !isZip(format) {
+			bail("--comment can only be used to create zip archives")
+		}
+		if err := checkZipComment(cli.Create.Comment); err != nil {
+			bail("invalid --comment: %s", err)
+		}
+	}
+	if cli.Create.Level != nil && configured["level"] {
+		// A default level only applies to the formats it's valid for.
+		leveled := format
+		if auto, ok := format.(autoZip); ok {
+			leveled = auto.Zip
+		}
+		if _, err := squish.WithLevel(leveled, *cli.Create.Level); err != nil || cli.Create.Compat != "" || cli.Create.Password.given() || cli.Create.ZipMethod == "store" || cli.Create.ZipMethod == "zstd" {
+			logMessage(slog.LevelDebug, nil, "ignoring the default --level of %d", *cli.Create.Level)
+			cli.Create.Level = nil
+		}
+	}
+	if cli.Create.Level != nil {
+		if cli.Create.Compat != "" || cli.Create.Password.given() {
+			bail("--level can't be used with --compat or --password")
+		}
+		if auto, ok := format.(autoZip); ok {
+			if _, err := squish.WithLevel(auto.Zip, *cli.Create.Level); err != nil {
+				bail("invalid --level: %s", err)
+			}
+			auto.level = *cli.Create.Level
+			format = auto
+		} else if format, err = squish.WithLevel(format, *cli.Create.Level); err != nil {
+			bail("invalid --level: %s", err)
+		}
+	}
+	if cli.Create.Password.given() {
+		zipFormat, ok := format.(archives.Zip)
+		if cli.Create.Compat != "" {
+			bail("--password can't be used with --compat, since the built-in zip support of other platforms can't extract AES-encrypted zips")
+		} else if !ok {
+			bail("--password can only be used to create zip archives")
+		}
+		// Zips can always be encrypted.
+		format, _ = squish.WithPassword(zipFormat, cli.Create.Password.get(true))
+	}
+	if cli.Create.Pipe && cli.Create.Output != stdioPath {
+		bail("--pipe can only be used when writing to stdout")
+	}
+	var signingKey ed25519.PrivateKey
+	if cli.Create.Sign != "" {
+		if cli.Create.Output == stdioPath || isURL(cli.Create.Output) || isStreamOutput(cli.Create.Output) || cli.Create.SplitSize > 0 {
+			bail("--sign can only be used with outputs on disk that aren't split")
+		}
+		if signingKey, err = readSigningKey(cli.Create.Sign); err != nil {
+			bail("failed to read signing key: %s", err)
+		}
+	}
+	if update != nil && !isPlainTar(format) {
+		bail("--update can only be used with uncompressed tar archives")
+	}
+	if update != nil && (checksums != nil || signingKey != nil || sidecarChecksums != nil) {
+		bail("--manifest, --sign and --sidecar can't be used with --update, since they would only cover the appended files")
+	}
+	var alsoFormats []archives.ArchiverAsync
+	if len(also) > 0 {
+		if _, ok := format.(archives.ArchiverAsync); !ok {
+			bail("--also can only be used when creating archives")
+		}
+		if update != nil || zip64 != "auto" || len(cli.Create.Meta) > 0 {
+			bail("--also can't be used with --update, --zip64 or --meta")
+		}
+		for _, output := range also {
+			if output == stdioPath || isURL(output) || isStreamOutput(output) {
+				bail("--also can only be used with outputs on disk")
+			}
+			alsoFormat, err := alsoFormat(ctx, output)
+			if err != nil {
+				bail("invalid --also: %s", err)
+			}
+			alsoFormats = append(alsoFormats, alsoFormat)
+		}
+	}
+	if cli.Create.Dedup && !isTar(format) && !isPlainTar(format) {
+		bail("--dedup can only be used to create tar archives, since other formats can't store hard links")
+	}
+	if (cli.Create.Xattrs || cli.Create.ACLs || cli.Create.Capabilities) && !isTar(format) {
+		warn("extended attributes aren't stored by the identified format, so --xattrs, --acls and --capabilities have no effect")
+	}
+	// Formats that can't archive entries as they're discovered, or that
+	// reorder them, need every input to be discovered first after all.
+	if streaming && !streamsFormat(format, zip64) {
+		streaming = false
+		files = discoverFiles(ctx, outputs)
+	}
+	// The files are only read once the options have been checked, since
+	// --dedup reads all of them.
+	if !streaming {
+		prepareFiles(ctx, files, progress, stdin)
+	}
+	// Digests are of the contents as they're archived, after any filters.
+	if checksums != nil {
+		for i := range files {
+			files[i] = checksums.file(files[i])
+		}
+	}
+	if sidecarChecksums != nil && sidecarChecksums != checksums {
+		for i := range files {
+			files[i] = sidecarChecksums.file(files[i])
+		}
+	}
+	if len(cli.Create.Meta) > 0 {
+		if !supportsMeta(format) {
+			bail("--meta can only be used to create tar archives and zips")
+		}
+		if zipFormat, ok := format.(archives.Zip); ok {
+			format = metaZip{zipFormat}
+		}
+		if files, err = withEntryMeta(files, cli.Create.Meta, format); err != nil {
+			bail("failed to store metadata: %s", err)
+		}
+	}
+	// Special files can still be compressed, like a named pipe that's being
+	// written to.
+	if _, ok := format.(archives.Archiver); ok {
+		files = withoutSpecialFiles(files, cli.Create.SpecialFiles && isTar(format))
+	}
+
+	if variant, ok := format.(squish.ZipVariant); ok {
+		variant.Warn = warn
+		format, files = variant, variant.Order(files)
+	}
+
+	if cli.Create.Threads < 1 {
+		bail("invalid number of threads: %d", cli.Create.Threads)
+	}
+	format = withThreads(format, threadsWithin(cli.Create.Threads, int64(cli.MaxMemory)))
+	if cli.Create.GzipName != "" || cli.Create.GzipMtime != nil || cli.Create.Rsyncable {
+		var modTime time.Time
+		if cli.Create.GzipMtime != nil {
+			modTime = time.Time(*cli.Create.GzipMtime)
+			if unix := modTime.Unix(); unix < 0 || unix > math.MaxUint32 {
+				bail("--gzip-mtime must be from 1970 to 2106, which is all gzip headers can store")
+			}
+		}
+		var ok bool
+		if format, ok = withGzipHeader(format, cli.Create.GzipName, modTime, cli.Create.Rsyncable); !ok {
+			bail("--gzip-name, --gzip-mtime and --rsyncable can only be used to create gzip output")
+		}
+	}
+	format = withMemoryLimit(format, int64(cli.MaxMemory))
+	if cli.Create.ZstdLong || cli.Create.ZstdWindowLog != 0 {
+		windowLog := cli.Create.ZstdWindowLog
+		if cli.Create.ZstdLong && windowLog != 0 {
+			bail("--zstd-long and --zstd-window-log can't be used together")
+		} else if cli.Create.ZstdLong {
+			windowLog = zstdLongWindowLog
+		} else if windowLog < zstdMinWindowLog || windowLog > zstdMaxWindowLog {
+			bail("invalid --zstd-window-log: %d isn't from %d to %d", windowLog, zstdMinWindowLog, zstdMaxWindowLog)
+		}
+		if cli.MaxMemory > 0 && 4<<windowLog > int64(cli.MaxMemory) {
+			bail("a zstd window of %s needs more memory than --max-memory allows", byteSize(1<<windowLog))
+		}
+		var ok bool
+		if format, ok = withZstdWindow(format, windowLog); !ok {
+			bail("--zstd-long and --zstd-window-log can only be used to create zstd output")
+		}
+	}
+	if cli.Create.Dictionary != "" {
+		var ok bool
+		if format, ok = withZstdDictionary(format, readDictionary(cli.Create.Dictionary)); !ok {
+			bail("--dictionary can only be used to create zstd output")
+		}
+	}
+	var seekable *archiveIndex
+	if cli.Create.Index {
+		if cli.Create.Output == stdioPath || isURL(cli.Create.Output) || isStreamOutput(cli.Create.Output) || cli.Create.SplitSize > 0 || len(cli.Create.Encrypt) > 0 || len(cli.Create.Recipient) > 0 || cli.Create.Update {
+			bail("--index can only be used with outputs on disk that aren't split, encrypted or updated")
+		}
+		tar, ok := withSeekIndex(format)
+		if !ok {
+			bail("--index can only be used to create tar.gz and tar.zst archives")
+		}
+		format, seekable = tar, tar.index
+	}
+	var sfxStubFile *os.File
+	var sfxStubSize int64
+	if cli.Create.SelfExtracting != "" {
+		if cli.Create.Output == stdioPath || isURL(cli.Create.Output) || isStreamOutput(cli.Create.Output) || cli.Create.SplitSize > 0 || len(cli.Create.Encrypt) > 0 || len(cli.Create.Recipient) > 0 || cli.Create.Update || cli.Create.Index {
+			bail("--self-extracting can only be used with outputs on disk that aren't split, encrypted, indexed or updated")
+		}
+		if _, ok := format.(archives.Archiver); !ok {
+			bail("--self-extracting can only be used to create archives")
+		}
+		var err error
+		if sfxStubFile, sfxStubSize, err = sfxStub(cli.Create.SelfExtracting); err != nil {
+			bail("failed to open self-extracting stub: %s", err)
+		}
+		defer sfxStubFile.Close()
+	}
+
+	keep := retention{cli.Create.Keep, cli.Create.KeepDaily, cli.Create.KeepWeekly, cli.Create.KeepMonthly}
+	if min(keep.last, keep.daily, keep.weekly, keep.monthly) < 0 {
+		bail("--keep, --keep-daily, --keep-weekly and --keep-monthly can't be negative")
+	}
+	if keep.enabled() {
+		if cli.Create.Output == stdioPath || isURL(cli.Create.Output) || isStreamOutput(cli.Create.Output) || cli.Create.SplitSize > 0 {
+			bail("--keep can only be used with outputs on disk that aren't split")
+		}
+		pattern, ok := backupPattern(template, cli.Create.Output)
+		if !ok {
+			bail("--keep can only be used with outputs whose names have placeholders or digits, like dates, that tell backups apart")
+		}
+		// Old backups are only pruned once the output is in place, which
+		// is after the deferred calls that close it, and only if they
+		// didn't fail.
+		defer func() {
+			if exitCode == 0 && ctx.Err() == nil && !cli.Create.DryRun && !cli.Create.Estimate {
+				pruneBackups(cli.Create.Output, pattern, keep)
+			}
+		}()
+	}
+
+	if cli.Create.Estimate && cli.Create.DryRun {
+		bail("--estimate and --dry-run can't be used together")
+	}
+	if cli.Create.Estimate {
+		estimate(ctx, format, files, stdin)
+		return
+	}
+	if update == nil {
+		checkClobber(force)
+	}
+	if cli.Create.DryRun {
+		dryRunCreate(files, update != nil, progress)
+		return
+	}
+
+	extension := format.Extension()
+	switch format := format.(type) {
+	case archives.Archiver:
+		if stdin {
+			bail("stdin can only be used as the input when compressing")
+		}
+
+		if update != nil {
+			if cli.Create.Zip64 != "auto" {
+				bail("--zip64 can't be used with --update")
+			}
+			err := update.append(cli.Create.Output, func(w io.Writer) error {
+				return archive(ctx, format, progress.output(throttleOutput(stats.output(nopWriteCloser{w}))), files, progress)
+			})
+			if err != nil {
+				bail("failed to update archive: %s", err)
+			}
+			if nextSnapshot != nil {
+				if err := writeSnapshot(cli.Create.ListedIncremental, nextSnapshot); err != nil {
+					bail("failed to write snapshot: %s", err)
+				}
+			}
+			return
+		}
+
+		alsoFiles, err := createAlsoOutputs(also, force)
+		if err != nil {
+			bail("failed to create archive file: %s", err)
+		}
+		defer func() {
+			if err := closeAlsoOutputs(alsoFiles); err != nil {
+				bail("failed to close archive file: %s", err)
+			}
+		}()
+		file, commit, err := createOutput(ctx, signingKey, progress)
+		if err != nil {
+			bail("failed to create archive file: %s", err)
+		}
+		output := io.WriteCloser(file)
+		archived := false
+		defer func() {
+			if err := output.Close(); err != nil {
+				bail("failed to close archive file: %s", err)
+			}
+			// The manifest and snapshot are only written once the archive
+			// is in place.
+			if checksums != nil && archived {
+				path := cli.Create.Output + "." + cli.Create.Manifest
+				if err := writeChecksums(path, checksums); err != nil {
+					bail("failed to write manifest: %s", err)
+				}
+			}
+			if sfxStubFile != nil && archived {
+				if err := makeExecutable(cli.Create.Output); err != nil {
+					bail("failed to make archive executable: %s", err)
+				}
+			}
+			if seekable != nil && archived {
+				if err := writeIndex(cli.Create.Output, seekable); err != nil {
+					bail("failed to write index: %s", err)
+				}
+			}
+			if sidecarChecksums != nil && archived {
+				if err := writeSidecar(cli.Create.Output, extension, files, sidecarChecksums); err != nil {
+					bail("failed to write sidecar: %s", err)
+				}
+			}
+			if nextSnapshot != nil && archived {
+				if err := writeSnapshot(cli.Create.ListedIncremental, nextSnapshot); err != nil {
+					bail("failed to write snapshot: %s", err)
+				}
+			}
+		}()
+
+		// Self-extracting archives start with the stub, and end with a
+		// trailer that locates the archive after it.
+		if sfxStubFile != nil {
+			if err := writeSFXStub(output, sfxStubFile, sfxStubSize); err != nil {
+				bail("failed to write self-extracting stub: %s", err)
+			}
+		}
+
+		// With --zip64, zips are written through a zip64Writer, which
+		// rewrites their central directories or checks their sizes.
+		var w io.Writer = output
+		entries := files
+		var zip64W *zip64Writer
+		if zip64 != "auto" {
+			zip64W = newZip64Writer(output, zip64 == "always", zip64ForbiddenBy)
+			w, entries = zip64W, zip64W.watch(files)
+		}
+		// The comment follows the end of central directory record, which
+		// zip64Writer keeps it in when rewriting it.
+		var commentW *zipCommentWriter
+		if cli.Create.Comment != "" {
+			commentW = &zipCommentWriter{w: w}
+			w = commentW
+		}
+		if streaming {
+			err = archiveStream(ctx, format.(archives.ArchiverAsync), w, outputs, progress)
+		} else if len(alsoFiles) > 0 {
+			formats, writers := []archives.ArchiverAsync{format.(archives.ArchiverAsync)}, []io.Writer{w}
+			for i, file := range alsoFiles {
+				formats, writers = append(formats, alsoFormats[i]), append(writers, file)
+			}
+			err = archiveShared(ctx, formats, writers, entries, progress)
+		} else {
+			err = archive(ctx, format, w, entries, progress)
+		}
+		if err == nil && commentW != nil {
+			err = commentW.finish(cli.Create.Comment)
+		}
+		if err == nil && zip64W != nil {
+			err = zip64W.finish()
+		}
+		if err == nil && sfxStubFile != nil {
+			_, err = output.Write(sfxTrailer(sfxStubSize))
+		}
+		if err != nil {
+			bail("failed to create archive: %s", err)
+		}
+		commit()
+		for _, file := range alsoFiles {
+			file.commit()
+		}
+		archived = true
+
+	case archives.Compressor:
+		if checksums != nil {
+			bail("--manifest can only be used when creating archives")
+		}
+		if sidecarChecksums != nil {
+			bail("--sidecar can only be used when creating archives")
+		}
+		if nextSnapshot != nil {
+			bail("--listed-incremental can only be used when creating archives")
+		}
+		if len(files) < 1 && !stdin {
+			bail("identified format only supports compression, but no input file was provided")
+		}
+		if len(files) > 1 {
+			bail("identified format only supports compression, but multiple input files were provided")
+		}
+
+		output, commit, err := createOutput(ctx, signingKey, progress)
+		if err != nil {
+			bail("failed to create compressed file: %s", err)
+		}
+		defer func() {
+			if err := output.Close(); err != nil {
+				bail("failed to close compressed file: %s", err)
+			}
+		}()
+
+		var input io.Reader
+		if stdin {
+			progress.start(stdioPath, -1)
+			input = progress.reader(os.Stdin)
+		} else {
+			inputF, err := files[0].Open()
+			if err != nil {
+				bail("failed to open input file: %s", err)
+			}
+			defer func() {
+				if err := inputF.Close(); err != nil {
+					bail("failed to open input file: %s", err)
+				}
+			}()
+			input = inputF
+		}
+
+		// The compressor is closed by squish.Compress, which writes the
+		// end of the output, so it's complete once that returns.
+		written, err := squish.Compress(ctx, format, output, input)
+		if err != nil {
+			bail("failed to compress input file: %s", err)
+		}
+		commit()
+		if stdin {
+			progress.finish(stdioPath, written)
+		}
+
+	default:
+		bail("identified format doesn't support archiving or compression")
+	}
+}
+
+// discoverFiles returns the files to archive from the inputs and
+// --files-from, except for any of outputs, named as they are in the archive.
+func discoverFiles(ctx context.Context, outputs []fs.FileInfo) []archives.FileInfo {
+	defer stats.walked(time.Now())
+	var files []archives.FileInfo
+	for _, file := range cli.Create.Inputs {
+		if file == stdioPath {
+			continue
+		}
+
+		found, err := archives.FilesFromDisk(ctx, nil, map[string]string{inputPath(file): filepath.Base(file)})
+		if err != nil {
+			bail("failed to discover files: %s", err)
+		}
+		if found, err = dereferenceFiles(ctx, found, inputPath(file)); err != nil {
+			bail("failed to dereference symbolic links: %s", err)
+		}
+		found = withoutOutputs(found, outputs)
+		if found, err = ignoreFiles(found, inputPath(file)); err != nil {
+			bail("failed to read ignore file: %s", err)
+		}
+		if found, err = withXattrs(found, inputPath(file)); err != nil {
+			bail("failed to read extended attributes: %s", err)
+		}
+		files = append(files, found...)
+	}
+	if cli.Create.FilesFrom != "" {
+		listed, err := filesFrom(cli.Create.FilesFrom, cli.Create.Null, outputs)
+		if err != nil {
+			bail("failed to read --files-from: %s", err)
+		}
+		files = append(files, listed...)
+	}
+	files = filterFiles(files, cli.Create.Include, cli.Create.Exclude)
+	files = filterSizesAndTimes(files, cli.Create.NewerMtime, cli.Create.OlderMtime, cli.Create.MinSize, cli.Create.MaxSize)
+	files, err := filterTypes(files, cli.Create.IncludeType, cli.Create.ExcludeType)
+	if err != nil {
+		bail("failed to detect content type: %s", err)
+	}
+
+	var stripped bool
+	named := files[:0]
+	for _, file := range files {
+		name, trimmed := archiveName(file.NameInArchive)
+		stripped = stripped || trimmed
+		if name != "" {
+			file.NameInArchive = name
+			named = append(named, file)
+		}
+	}
+	if stripped {
+		warn("removed leading / from entry names, use --absolute-names to keep it")
+	}
+	return named
+}
+
+// archiveName returns the name that a file discovered as name is given in the
+// archive by --transform, --absolute-names and --prefix, or "" if it isn't
+// archived, and whether a leading / was removed from it.
+func archiveName(name string) (_ string, stripped bool) {
+	if len(cli.Create.Transform) > 0 {
+		if name = transformName(cli.Create.Transform, name); name == "" {
+			return "", false
+		}
+	}
+	// Like tar, the leading / of absolute names is removed, so that entries
+	// are extracted beneath the output.
+	if !cli.Create.AbsoluteNames {
+		relative := strings.TrimLeft(name, "/")
+		if name, stripped = relative, relative != name; name == "" {
+			return "", stripped
+		}
+	}
+	if cli.Create.Prefix != "" {
+		name = path.Join(path.Clean(cli.Create.Prefix), name)
+	}
+	return name, stripped
+}
+
+// prepareFiles prepares each of files to be archived, and records their total
+// size in the history, and as the total of progress with --prescan.
+func prepareFiles(ctx context.Context, files []archives.FileInfo, progress *progress, stdin bool) {
+	// Duplicates are found before the files are read through progress, so
+	// that reading them to compare them isn't counted.
+	if cli.Create.Dedup {
+		n, err := dedupFiles(ctx, files)
+		if err != nil {
+			bail("failed to find duplicate files: %s", err)
+		}
+		logMessage(slog.LevelDebug, nil, "storing %d duplicate files as hard links", n)
+	}
+	for i, file := range files {
+		files[i] = prepareFile(ctx, file, progress)
+	}
+	history.setInputBytes(totalSize(files))
+	if cli.Create.Prescan && !stdin {
+		progress.setTotal(totalSize(files))
+	}
+	// Filters are applied outside of progress, which counts the bytes of
+	// the original files, as the total does.
+	withContentFilters(files, contentFilters(), newTempBudget(int64(cli.Create.MaxTmp)))
+}
+
+// prepareFile returns file with its --mode, read through progress, and
+// interrupted along with ctx.
+func prepareFile(ctx context.Context, file archives.FileInfo, progress *progress) archives.FileInfo {
+	if cli.Create.Mode != nil {
+		file.FileInfo = changedMode{file.FileInfo, cli.Create.Mode}
+	}
+	return interruptibleFile(ctx, progress.file(stats.file(file)))
+}
+
+// totalSize returns the total size of the regular files in files, which is how
+// many bytes are read from them while archiving. Their sizes were found when
+// they were discovered, so they don't have to be statted again.
+func totalSize(files []archives.FileInfo) int64 {
+	var total int64
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			total += file.Size()
+		}
+	}
+	return total
+}
+
+// archive writes files to output using format, printing each entry as it's
+// archived if --verbose was given.
+func archive(ctx context.Context, format archives.Archiver, output io.Writer, files []archives.FileInfo, progress *progress) error {
+	verboseW := verboseOutput(cli.Create.Output)
+	return squish.Archive(ctx, format, output, files, func(file archives.FileInfo) {
+		printEntry(verboseW, progress, file.NameInArchive, file)
+	})
+}
+
+// archiveAsync writes the files returned by next to output using format,
+// printing each entry as it's archived if --verbose was given, until next
+// returns io.EOF.
+func archiveAsync(ctx context.Context, format archives.ArchiverAsync, output io.Writer, next func() (archives.FileInfo, error), progress *progress) error {
+	verboseW := verboseOutput(cli.Create.Output)
+	return squish.ArchiveFrom(ctx, format, output, next, func(file archives.FileInfo) {
+		printEntry(verboseW, progress, file.NameInArchive, file)
+	})
+}
+
+// nopWriteCloser is an io.WriteCloser whose Close method does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// filesFrom returns an entry for each path listed in the file at listPath, or
+// stdin if it's -, separated by newlines, or NUL bytes if null is true. Unlike
+// positional inputs, directories aren't walked, and entries are named with
+// the paths as they're listed, so the archive contains exactly the listed
+// files, except for any of outputs.
+func filesFrom(listPath string, null bool, outputs []fs.FileInfo) ([]archives.FileInfo, error) {
+	var list []byte
+	var err error
+	if listPath == stdioPath {
+		list, err = io.ReadAll(os.Stdin)
+	} else {
+		list, err = os.ReadFile(listPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sep := "\n"
+	if null {
+		sep = "\x00"
+	}
+
+	var files []archives.FileInfo
+	for _, name := range strings.Split(string(list), sep) {
+		if name == "" {
+			continue
+		}
+
+		nameInArchive := path.Clean(filepath.ToSlash(name))
+		if nameInArchive == ".." || strings.HasPrefix(nameInArchive, "../") {
+			return nil, fmt.Errorf("%s is outside of the current directory", name)
+		}
+
+		diskPath := inputPath(name)
+		info, err := os.Lstat(diskPath)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 && cli.Create.Dereference {
+			if target, err := os.Stat(diskPath); err == nil {
+				info = target
+			} else if errors.Is(err, fs.ErrNotExist) {
+				warn("symbolic link %s is broken, so it was archived as a link", nameInArchive)
+			} else {
+				return nil, err
+			}
+		}
+
+		var linkTarget string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if linkTarget, err = os.Readlink(diskPath); err != nil {
+				return nil, err
+			}
+		}
+		if isOutput(info, outputs) {
+			warn("skipped %s, which is the output", nameInArchive)
+			continue
+		}
+
+		file, err := xattrFile(archives.FileInfo{
+			FileInfo:      info,
+			NameInArchive: nameInArchive,
+			LinkTarget:    linkTarget,
+			Open: func() (fs.File, error) {
+				return os.Open(diskPath)
+			},
+		}, diskPath)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// inputPath returns the path of the input file relative to --directory, if it
+// was given.
+func inputPath(file string) string {
+	if cli.Create.Directory == "" || filepath.IsAbs(file) {
+		return file
+	}
+
+	resolved := filepath.Join(cli.Create.Directory, file)
+	// A trailing separator means the input's contents are archived without
+	// it, so it has to be kept.
+	if strings.HasSuffix(file, string(filepath.Separator)) {
+		resolved += string(filepath.Separator)
+	}
+	return resolved
+}
+
+// createOutput creates the output given by --output, encrypting it with age
+// or gpg if --encrypt or --gpg-recipient was given, and signing it with
+// signingKey if it's non-nil. Outputs written to disk are only kept if commit
+// is called before they're closed, so that failed writes never leave a
+// partial output behind.
+func createOutput(ctx context.Context, signingKey ed25519.PrivateKey, progress *progress) (output io.WriteCloser, commit func(), err error) {
+	output, commit, err = createPlainOutput(ctx)
+	if err == nil {
+		output = throttleOutput(stats.output(output))
+	}
+	if err == nil && progress != nil {
+		output = struct {
+			io.Writer
+			io.Closer
+		}{progress.output(output), output}
+	}
+	if err == nil && cli.Create.Pipe {
+		piped := newPipeWriter(output, commit)
+		output, commit = piped, piped.commit
+	}
+	if err == nil && signingKey != nil {
+		// The signature covers the output as written, after encryption.
+		signed := newSigningWriter(output, commit, cli.Create.Output, signingKey)
+		output, commit = signed, signed.commit
+	}
+	ageRecipients, gpgRecipients := encryptionRecipients()
+	if err != nil || len(ageRecipients) == 0 && len(gpgRecipients) == 0 {
+		return output, commit, err
+	}
+	return encryptOutput(output, commit, ageRecipients, gpgRecipients, strings.HasSuffix(cli.Create.Output, ".asc"))
+}
+
+// encryptOutput wraps output, which is kept if commit is called, so that
+// what's written to it is encrypted to ageRecipients, or to gpgRecipients,
+// ASCII-armored if armor is true. output is closed if that fails.
+func encryptOutput(output io.WriteCloser, commit func(), ageRecipients []age.Recipient, gpgRecipients []string, armor bool) (io.WriteCloser, func(), error) {
+	// age encrypts in-process, so only gpg has to be run.
+	var encrypted interface {
+		io.WriteCloser
+		commit()
+	}
+	var err error
+	if len(ageRecipients) > 0 {
+		encrypted, err = encryptAge(output, commit, ageRecipients)
+	} else {
+		encrypted, err = encryptGPG(output, commit, gpgRecipients, armor)
+	}
+	if err != nil {
+		output.Close()
+		return nil, nil, err
+	}
+	return encrypted, encrypted.commit, nil
+}
+
+func createPlainOutput(ctx context.Context) (io.WriteCloser, func(), error) {
+	if cli.Create.Output == stdioPath {
+		if cli.Create.SplitSize > 0 {
+			return nil, nil, errors.New("output can't be split when writing to stdout")
+		}
+		return withRetries(ctx, os.Stdout), func() {}, nil
+	}
+
+	if isURL(cli.Create.Output) {
+		if cli.Create.SplitSize > 0 {
+			return nil, nil, errors.New("output can't be split when uploading it")
+		}
+		output, err := createURL(ctx, cli.Create.Output)
+		return output, func() {}, err
+	}
+
+	// Tape drives and named pipes can't be replaced, so they're written in
+	// place.
+	if isStreamOutput(cli.Create.Output) {
+		if cli.Create.SplitSize > 0 {
+			return nil, nil, errors.New("output can't be split when writing to a tape drive or named pipe, use --tape-length instead")
+		}
+		if cli.Create.BlockingFactor < 1 {
+			return nil, nil, fmt.Errorf("invalid blocking factor: %d", cli.Create.BlockingFactor)
+		}
+		tape, err := newTapeWriter(cli.Create.Output, cli.Create.BlockingFactor*tarBlockSize, int64(cli.Create.TapeLength))
+		if err != nil {
+			return nil, nil, err
+		}
+		return withRetries(ctx, tape), func() {}, nil
+	}
+
+	if cli.Create.SplitSize > 0 {
+		volumes := newVolumeWriter(cli.Create.Output, cli.Create.Tempdir, int64(cli.Create.SplitSize))
+		return withRetries(ctx, volumes), volumes.commit, nil
+	}
+
+	file, err := createAtomic(cli.Create.Output, cli.Create.Tempdir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return withRetries(ctx, file), file.commit, nil
+}
+
+// existingOutputs returns the output, or its volumes if it's split, that
+// already exist, which mustn't be archived into themselves if they're inside
+// an input. New outputs are created after the inputs are discovered, so
+// they're never archived.
+func existingOutputs(output string, split bool) []fs.FileInfo {
+	if output == stdioPath || isURL(output) {
+		return nil
+	}
+	if !split {
+		if info, err := os.Stat(output); err == nil {
+			return []fs.FileInfo{info}
+		}
+		return nil
+	}
+
+	var outputs []fs.FileInfo
+	for i := 1; ; i++ {
+		info, err := os.Stat(volumeName(output, i))
+		if err != nil {
+			break
+		}
+		outputs = append(outputs, info)
+	}
+	return outputs
+}
+
+// isOutput reports whether info, which must have come from the file system
+// rather than being wrapped, is one of outputs.
+func isOutput(info fs.FileInfo, outputs []fs.FileInfo) bool {
+	return slices.ContainsFunc(outputs, func(output fs.FileInfo) bool { return os.SameFile(info, output) })
+}
+
+// withoutOutputs removes outputs from files, warning about each.
+func withoutOutputs(files []archives.FileInfo, outputs []fs.FileInfo) []archives.FileInfo {
+	if len(outputs) == 0 {
+		return files
+	}
+
+	kept := files[:0]
+	for _, file := range files {
+		if isOutput(file.FileInfo, outputs) {
+			warn("skipped %s, which is the output", file.NameInArchive)
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+// checkClobber bails if the output given by --output already exists on disk,
+// unless force is true or it's a tape drive or named pipe, which is written to
+// in place.
+func checkClobber(force bool) {
+	if force || cli.Create.Output == stdioPath || isURL(cli.Create.Output) || isStreamOutput(cli.Create.Output) {
+		return
+	}
+
+	output := cli.Create.Output
+	if cli.Create.SplitSize > 0 {
+		output = volumeName(output, 1)
+	}
+	if _, err := os.Lstat(output); err == nil {
+		bail("output %s already exists, use --force to replace it", output)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		bail("failed to check for existing output: %s", err)
+	}
+}
+
+// atomicFile writes to a temporary file beside path, or in tempDir if it's
+// given, which replaces path when it's closed if commit was called, and is
+// removed otherwise. The temporary file can only be left behind if the process
+// is killed.
+type atomicFile struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+func createAtomic(path, tempDir string) (*atomicFile, error) {
+	file, err := os.CreateTemp(cmp.Or(tempDir, filepath.Dir(path)), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+
+	// Replaced outputs keep their permissions, as with retouch.
+	perm := fs.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &atomicFile{File: file, path: path}, nil
+}
+
+func (f *atomicFile) commit() {
+	f.committed = true
+}
+
+func (f *atomicFile) Close() error {
+	// The contents are synced before the file is renamed over path, so that
+	// after a crash, path holds either what it did before or all of them.
+	var err error
+	if f.committed {
+		err = f.File.Sync()
+	}
+	if closeErr := f.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && f.committed {
+		err = moveFile(f.Name(), f.path)
+	}
+	if err != nil || !f.committed {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// moveFile renames temp to path. If they're on different file systems, as
+// they can be with --tempdir, temp is copied to another temporary file beside
+// path, which is renamed instead, so that path is still replaced atomically
+// and never seen partially written, and temp is removed.
+func moveFile(temp, path string) error {
+	err := os.Rename(temp, path)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	src, err := os.Open(temp)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), path)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return os.Remove(temp)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestAtomicFile(t *testing.T) {
+	for _, commit := range []bool{false, true} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "out")
+		if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := createAtomic(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString("new"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(path); err != nil || string(got) != "old" {
+			t.Errorf("output was changed before closing: %q, %v", got, err)
+		}
+		if commit {
+			f.commit()
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		want := "old"
+		if commit {
+			want = "new"
+		}
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("commit %t: got output %q, %v, want %q", commit, got, err, want)
+		}
+		if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+			t.Errorf("commit %t: temporary file was left behind: %v, %v", commit, entries, err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("commit %t: permissions weren't kept: %v, %v", commit, info, err)
+		}
+	}
+}
+
+func TestAtomicFileTempDir(t *testing.T) {
+	dir, tempDir := t.TempDir(), t.TempDir()
+	path := filepath.Join(dir, "out")
+	f, err := createAtomic(path, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(f.Name()) != tempDir {
+		t.Errorf("temporary file %s isn't in %s", f.Name(), tempDir)
+	}
+	if _, err := f.WriteString("new"); err != nil {
+		t.Fatal(err)
+	}
+	f.commit()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := os.ReadFile(path); err != nil || string(got) != "new" {
+		t.Errorf("got output %q, %v, want %q", got, err, "new")
+	}
+	if entries, err := os.ReadDir(tempDir); err != nil || len(entries) != 0 {
+		t.Errorf("temporary file was left behind: %v, %v", entries, err)
+	}
+}
+
+func TestVolumeWriterUncommitted(t *testing.T) {
+	dir := t.TempDir()
+	w := newVolumeWriter(filepath.Join(dir, "out"), "", 2)
+	if _, err := w.Write([]byte("abcde")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("uncommitted volumes were left behind: %v, %v", entries, err)
+	}
+}
+
+func TestWithoutOutputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "out.tar", "out.tar.001", "out.tar.002"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, split := range []bool{false, true} {
+		files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{dir: "in"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = withoutOutputs(files, existingOutputs(filepath.Join(dir, "out.tar"), split))
+
+		var got []string
+		for _, file := range files {
+			got = append(got, file.NameInArchive)
+		}
+		slices.Sort(got)
+		want := []string{"in", "in/a", "in/out.tar.001", "in/out.tar.002"}
+		if split {
+			want = []string{"in", "in/a", "in/out.tar"}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("split %t: got files %v, want %v", split, got, want)
+		}
+	}
+}
+
+func TestCreateFormat(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(input, []byte("notes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The format is taken from --format rather than the misleading name.
+	output := filepath.Join(dir, "out.zip")
+	parseCLI(t, "create", "--format", "tar", output, input)
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	header, err := tar.NewReader(f).Next()
+	if err != nil {
+		t.Fatalf("output isn't a tar archive: %s", err)
+	}
+	if path.Base(header.Name) != "notes.txt" {
+		t.Errorf("got entry %s, want notes.txt", header.Name)
+	}
+
+	parseCLI(t, "create", "--format", "tar.zip", filepath.Join(dir, "other"), input)
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code == 0 {
+		t.Error("an unknown format was accepted")
+	}
+}
+
+func TestCreateMode(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(input, []byte("#!/bin/sh"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(input, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out.tar")
+	parseCLI(t, "create", "--mode", "go-w", output, input)
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	header, err := tar.NewReader(f).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Mode != 0o755 {
+		t.Errorf("got mode %o, want 755", header.Mode)
+	}
+}
+
+// tarNames returns the names of the entries in the tar archive at path.
+func tarNames(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+}
+
+func TestCreateFilesFrom(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/c.txt", "new\nline"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(t.TempDir(), "out.tar")
+
+	// Listed directories are archived without their contents.
+	list := filepath.Join(t.TempDir(), "list")
+	if err := os.WriteFile(list, []byte("a.txt\n\nsub\nsub/b.txt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parseCLI(t, "create", "-C", dir, "--files-from", list, output)
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if got, want := tarNames(t, output), []string{"a.txt", "sub", "sub/b.txt"}; !slices.Equal(got, want) {
+		t.Errorf("got entries %q, want %q", got, want)
+	}
+
+	// With --null, names can contain newlines, and the list can be read from
+	// stdin.
+	stdin, err := os.Open(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if err := os.WriteFile(list, []byte("new\nline\x00sub/c.txt\x00"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	savedStdin := os.Stdin
+	defer func() { os.Stdin = savedStdin }()
+	os.Stdin = stdin
+	parseCLI(t, "create", "-C", dir, "--files-from", "-", "-0", "--force", output)
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if got, want := tarNames(t, output), []string{"new\nline", "sub/c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("got entries %q, want %q", got, want)
+	}
+
+	// Paths outside of the directory aren't archived.
+	if err := os.WriteFile(list, []byte("a.txt\n../escaped\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parseCLI(t, "create", "-C", dir, "--files-from", list, "--force", output)
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code == 0 {
+		t.Error("a path outside of the directory was accepted")
+	}
+}
+
+func TestCreatePrescan(t *testing.T) {
+	dir := t.TempDir()
+	for i, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, 1000*(i+1)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	events = &eventWriter{w: &buf}
+	t.Cleanup(func() { events = nil })
+
+	// totals returns the distinct totals of the progress events that were
+	// emitted while creating an archive with extra flags.
+	totals := func(extra ...string) []float64 {
+		buf.Reset()
+		parseCLI(t, append(append([]string{"create", "--force"}, extra...), filepath.Join(t.TempDir(), "out.tar"), dir)...)
+		if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+			t.Fatalf("got exit code %d", code)
+		}
+		var totals []float64
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var event struct {
+				Event string  `json:"event"`
+				Total float64 `json:"total"`
+			}
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Event == "progress" && !slices.Contains(totals, event.Total) {
+				totals = append(totals, event.Total)
+			}
+		}
+		return totals
+	}
+
+	if got := totals(); !slices.Equal(got, []float64{6000}) {
+		t.Errorf("got totals %v, want 6000", got)
+	}
+	if got := totals("--no-prescan"); !slices.Equal(got, []float64{-1}) {
+		t.Errorf("with --no-prescan: got totals %v, want unknown", got)
+	}
+}
+
+func TestCreatePrefix(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "in", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "in", "sub", "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "out.tar")
+
+	// Relative inputs are resolved in -C's directory, and named relative to
+	// it, beneath the prefix, however it's written.
+	for _, prefix := range []string{"top", "top/", "./top", "top/./", "x/../top"} {
+		parseCLI(t, "create", "--force", "-C", dir, "--prefix", prefix, output, "in")
+		if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+			t.Fatalf("%s: got exit code %d", prefix, code)
+		}
+		if got, want := tarNames(t, output), []string{"top/in", "top/in/sub", "top/in/sub/a.txt"}; !slices.Equal(got, want) {
+			t.Errorf("%s: got entries %q, want %q", prefix, got, want)
+		}
+	}
+	parseCLI(t, "create", "--force", "-C", filepath.Join(dir, "in"), "--prefix", "a/b", output, "sub")
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if got, want := tarNames(t, output), []string{"a/b/sub", "a/b/sub/a.txt"}; !slices.Equal(got, want) {
+		t.Errorf("got entries %q, want %q", got, want)
+	}
+
+	// -C's directory can itself be relative.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	parseCLI(t, "create", "--force", "-C", "in", "--prefix", "a/b", output, "sub")
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if got, want := tarNames(t, output), []string{"a/b/sub", "a/b/sub/a.txt"}; !slices.Equal(got, want) {
+		t.Errorf("relative -C: got entries %q, want %q", got, want)
+	}
+
+	// The entries are extracted beneath the prefix.
+	extracted := filepath.Join(t.TempDir(), "extracted")
+	parseCLI(t, "extract", output, extracted)
+	if code := runCommand(t, func() { extract(context.Background()) }); code != 0 {
+		t.Fatalf("extract: got exit code %d", code)
+	}
+	if got, want := readTree(t, extracted), map[string]string{"a": "/", "a/b": "/", "a/b/sub": "/", "a/b/sub/a.txt": "a"}; !maps.Equal(got, want) {
+		t.Errorf("extracted %q, want %q", got, want)
+	}
+
+	// Prefixes that would nest entries outside of where they're extracted
+	// are rejected.
+	for _, prefix := range []string{"..", "../top", "top/../..", "/top"} {
+		parseCLI(t, "create", "--force", "-C", dir, "--prefix", prefix, output, "in")
+		if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code == 0 {
+			t.Errorf("%s: got exit code 0", prefix)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+
+	"github.com/mholt/archives"
+)
+
+// dedupInfo stores a regular file whose contents are the same as those of an
+// earlier entry as a hard link to it, in the tar header that archives.Tar
+// takes the type and link target of entries from if their Sys method returns
+// one. Its size is 0, since it has no contents of its own in the archive.
+type dedupInfo struct {
+	fs.FileInfo
+	header *tar.Header
+}
+
+func (i dedupInfo) Size() int64 {
+	return 0
+}
+
+func (i dedupInfo) Sys() any {
+	return i.header
+}
+
+// dedupFiles replaces the regular files in files whose contents are the same
+// as an earlier one's with hard links to it, for --dedup, returning how many
+// were replaced. Only files whose sizes match another's are read to compare
+// them. Empty files are left alone, since they have no contents to save.
+func dedupFiles(ctx context.Context, files []archives.FileInfo) (int, error) {
+	bySize := map[int64][]int{}
+	for i, file := range files {
+		if file.Mode().IsRegular() && file.Size() > 0 && file.Open != nil {
+			bySize[file.Size()] = append(bySize[file.Size()], i)
+		}
+	}
+
+	var replaced int
+	for i, file := range files {
+		same := bySize[file.Size()]
+		if len(same) < 2 || same[0] != i {
+			continue
+		}
+		// Each group is handled once, from its first file, so that the
+		// earliest copy is the one that's linked to.
+		first := map[[sha256.Size]byte]string{}
+		for _, j := range same {
+			if err := ctx.Err(); err != nil {
+				return replaced, err
+			}
+			digest, err := fileDigest(files[j])
+			if err != nil {
+				return replaced, fmt.Errorf("failed to read %s: %w", files[j].NameInArchive, err)
+			}
+			target, ok := first[digest]
+			if !ok {
+				first[digest] = files[j].NameInArchive
+				continue
+			}
+
+			header, err := tar.FileInfoHeader(files[j].FileInfo, "")
+			if err != nil {
+				return replaced, fmt.Errorf("failed to create header of %s: %w", files[j].NameInArchive, err)
+			}
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, target, 0
+			files[j].FileInfo = dedupInfo{files[j].FileInfo, header}
+			files[j].Open = nil
+			replaced++
+		}
+	}
+	return replaced, nil
+}
+
+// fileDigest returns the SHA-256 digest of the contents of file.
+func fileDigest(file archives.FileInfo) ([sha256.Size]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := copyContents(hash, f); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return [sha256.Size]byte(hash.Sum(nil)), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestDedupFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{"a": "same", "b/c": "same", "d": "diff", "e": "", "f": ""} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{dir: "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := dedupFiles(context.Background(), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("replaced %d files, want 1", n)
+	}
+
+	var buf bytes.Buffer
+	if err := (archives.Tar{}).Archive(context.Background(), &buf, files); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeLink {
+			links[header.Name] = header.Linkname
+		}
+	}
+	// Empty files aren't worth linking.
+	if len(links) != 1 || links["in/b/c"] != "in/a" {
+		t.Errorf("got hard links %v, want in/b/c linked to in/a", links)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// dereferenceFiles replaces the symbolic links in files with the files they
+// point to, for --dereference, walking the directories that links point to as
+// archives.FilesFromDisk would have if they'd been given directly. files must
+// have been discovered from root by archives.FilesFromDisk. Broken links are
+// kept with a warning, as are links to directories that contain them, which
+// would otherwise be walked forever.
+func dereferenceFiles(ctx context.Context, files []archives.FileInfo, root string) ([]archives.FileInfo, error) {
+	if len(files) == 0 || !cli.Create.Dereference {
+		return files, nil
+	}
+	return dereferenceWalked(ctx, files, root, nil)
+}
+
+// dereferenceWalked dereferences the links in files, which were discovered from
+// root, where walked are the absolute, resolved paths of the directories that
+// links were followed to on the way to root.
+func dereferenceWalked(ctx context.Context, files []archives.FileInfo, root string, walked []string) ([]archives.FileInfo, error) {
+	walkedFrom := newWalkedRoot(files, root)
+
+	var dereferenced []archives.FileInfo
+	for _, file := range files {
+		if file.Mode()&fs.ModeSymlink == 0 {
+			dereferenced = append(dereferenced, file)
+			continue
+		}
+
+		diskPath := walkedFrom.diskPath(file)
+		info, err := os.Stat(diskPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			warn("symbolic link %s is broken, so it was archived as a link", file.NameInArchive)
+			dereferenced = append(dereferenced, file)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			file.FileInfo = info
+			file.LinkTarget = ""
+			dereferenced = append(dereferenced, file)
+			continue
+		}
+
+		target, err := resolvedPath(diskPath)
+		if err != nil {
+			return nil, err
+		}
+		parent, err := resolvedPath(filepath.Dir(diskPath))
+		if err != nil {
+			return nil, err
+		}
+		if loops(target, append([]string{parent}, walked...)) {
+			warn("symbolic link %s points to a directory containing it, so it was archived as a link", file.NameInArchive)
+			dereferenced = append(dereferenced, file)
+			continue
+		}
+
+		found, err := archives.FilesFromDisk(ctx, nil, map[string]string{target: file.NameInArchive})
+		if err != nil {
+			return nil, err
+		}
+		if found, err = dereferenceWalked(ctx, found, target, append(walked, target)); err != nil {
+			return nil, err
+		}
+		dereferenced = append(dereferenced, found...)
+	}
+	return dereferenced, nil
+}
+
+// resolvedPath returns the absolute path of name with every symbolic link
+// resolved.
+func resolvedPath(name string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// loops reports whether walking the directory dir would lead back to any of
+// dirs, because it's the same as or contains one of them.
+func loops(dir string, dirs []string) bool {
+	for _, other := range dirs {
+		if other == dir || strings.HasPrefix(other, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
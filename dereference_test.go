@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestDereferenceFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"in/real/sub", "other"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, contents := range map[string]string{"in/real/sub/f": "f", "other/o": "o"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range map[string]string{
+		"in/file":    "real/sub/f",
+		"in/dir":     "../other",
+		"in/real/up": "..",
+		"in/broken":  "nowhere",
+		"other/back": "../in/dir",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root := filepath.Join(dir, "in")
+	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{root: "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, err = dereferenceWalked(context.Background(), files, root, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, file := range files {
+		switch {
+		case file.IsDir():
+			got[file.NameInArchive] = "/"
+		case file.Mode()&fs.ModeSymlink != 0:
+			got[file.NameInArchive] = "-> " + file.LinkTarget
+		default:
+			f, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[file.NameInArchive] = string(contents)
+		}
+	}
+	want := map[string]string{
+		"in":            "/",
+		"in/file":       "f",
+		"in/dir":        "/",
+		"in/dir/o":      "o",
+		"in/dir/back":   "-> ../in/dir",
+		"in/real":       "/",
+		"in/real/sub":   "/",
+		"in/real/sub/f": "f",
+		"in/real/up":    "-> ..",
+		"in/broken":     "-> nowhere",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mholt/archives"
+)
+
+const (
+	// maxDictSample is the most that's read from each sample, as by zstd
+	// --train, since the start of a file is what a dictionary helps with.
+	maxDictSample = 128 << 10
+	// dictHashBytes is the length of the shortest strings that dictionaries
+	// are made of, as by default with builddict.
+	dictHashBytes = 6
+)
+
+func trainDict() {
+	if cli.TrainDict.Size < dictHashBytes || cli.TrainDict.Size > 1<<30 {
+		bail("invalid dictionary size: %s", cli.TrainDict.Size)
+	}
+
+	samples, err := readDictSamples(cli.TrainDict.Samples)
+	if err != nil {
+		bail("failed to read samples: %s", err)
+	}
+	if len(samples) < 2 {
+		bail("at least 2 samples are needed to train a dictionary, but %d were given", len(samples))
+	}
+
+	trained, err := buildDict(samples, int(cli.TrainDict.Size))
+	if err != nil {
+		bail("failed to train dictionary: %s", err)
+	}
+	if err := os.WriteFile(cli.TrainDict.Output, trained, 0o644); err != nil {
+		bail("failed to write dictionary: %s", err)
+	}
+	logger.Info("trained dictionary", "samples", len(samples), "size", len(trained))
+}
+
+// readDictSamples reads up to maxDictSample bytes from each of the regular
+// files named by paths, and those beneath the directories among them, skipping
+// empty ones.
+func readDictSamples(paths []string) ([][]byte, error) {
+	var samples [][]byte
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			sample, err := io.ReadAll(io.LimitReader(f, maxDictSample))
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			if len(sample) > 0 {
+				samples = append(samples, sample)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return samples, nil
+}
+
+// buildDict returns a zstd dictionary of about size bytes for data like
+// samples, made of the strings found most often in them. Its ID is derived from
+// the samples, so that the same samples give the same dictionary, and
+// different dictionaries are unlikely to share one.
+func buildDict(samples [][]byte, size int) ([]byte, error) {
+	crc := crc32.NewIEEE()
+	for _, sample := range samples {
+		crc.Write(sample)
+	}
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: size,
+		HashBytes:   dictHashBytes,
+		// IDs from 32768 to 2^31 - 1 aren't reserved.
+		ZstdDictID: 1<<15 + crc.Sum32()%(1<<31-1<<15),
+		// Dictionaries are read by zstd 1.5.5 and earlier too.
+		ZstdDictCompat: true,
+	})
+}
+
+// readDictionary reads the zstd dictionary for --dictionary from path.
+func readDictionary(path string) []byte {
+	dict, err := os.ReadFile(path)
+	if err != nil {
+		bail("failed to read dictionary: %s", err)
+	}
+	if _, err := zstd.InspectDictionary(dict); err != nil {
+		bail("%s isn't a zstd dictionary: %s", path, err)
+	}
+	return dict
+}
+
+// withZstdDictionary returns format, which must compress with zstd, possibly
+// as a compressed archive, compressing and decompressing with dict, or false if
+// it doesn't compress with zstd.
+func withZstdDictionary(format archives.Format, dict []byte) (archives.Format, bool) {
+	switch format := format.(type) {
+	case archives.CompressedArchive:
+		compression, ok := withZstdDictionary(format.Compression, dict)
+		if ok {
+			format.Compression = compression.(archives.Compression)
+		}
+		return format, ok
+	case archives.Zstd:
+		format.EncoderOptions = append(format.EncoderOptions, zstd.WithEncoderDict(dict))
+		format.DecoderOptions = append(format.DecoderOptions, zstd.WithDecoderDicts(dict))
+		return format, true
+	}
+	return nil, false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestBuildDict(t *testing.T) {
+	record := func(i int) []byte {
+		return fmt.Appendf(nil, `{"id":%d,"user":"user%d","event":"login","status":"ok","agent":"squish/1.0 (linux; amd64)"}`, i, i%7)
+	}
+	var samples [][]byte
+	for i := range 200 {
+		samples = append(samples, record(i))
+	}
+	dict, err := buildDict(samples, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compress := func(format archives.Format, data []byte) []byte {
+		var buf bytes.Buffer
+		w, err := format.(archives.Compressor).OpenWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	format, ok := withZstdDictionary(archives.Zstd{}, dict)
+	if !ok {
+		t.Fatal("zst wasn't given a dictionary")
+	}
+	data := record(1000)
+	withDict, without := compress(format, data), compress(archives.Zstd{}, data)
+	if len(withDict) >= len(without) {
+		t.Errorf("got %d bytes with the dictionary, and %d without", len(withDict), len(without))
+	}
+
+	r, err := format.(archives.Decompressor).OpenReader(bytes.NewReader(withDict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+
+	if _, ok := withZstdDictionary(archives.Gz{}, dict); ok {
+		t.Error("gz was given a zstd dictionary")
+	}
+}
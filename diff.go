@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+
+	"github.com/mholt/archives"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// binarySniffSize is how much of the start of contents is checked for NUL
+// bytes to decide whether they're binary, as by git and GNU diff.
+const binarySniffSize = 8000
+
+func diffEntry(ctx context.Context) {
+	selector := newEntrySelector(cli.DiffEntry.Entry, nil)
+
+	file, err := os.ReadFile(cli.DiffEntry.File)
+	if err != nil {
+		bail("failed to read file: %s", err)
+	}
+
+	input, extractor, inputR := openExtractor(ctx, cli.DiffEntry.Input)
+	defer closeInput(input)
+
+	var entry []byte
+	var entryName string
+	found := false
+	err = extractor.Extract(ctx, inputR, func(ctx context.Context, info archives.FileInfo) error {
+		if !selector.selects(info) {
+			return nil
+		}
+		found = true
+
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", selector)
+		}
+
+		f, err := info.Open()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		entryName = info.NameInArchive
+		if entry, err = io.ReadAll(f); err != nil {
+			return err
+		}
+		return fs.SkipAll
+	})
+	if err != nil {
+		bail("failed to read entry: %s", err)
+	}
+	if !found {
+		selector.bailNotFound()
+	}
+
+	if bytes.Equal(entry, file) {
+		return
+	}
+	exitCode = 1
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if isBinary(entry) || isBinary(file) {
+		writeBinaryDiff(w, entryName, entry, cli.DiffEntry.File, file)
+		return
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", entryName, cli.DiffEntry.File)
+	writeUnifiedDiff(w, splitLines(entry), splitLines(file))
+}
+
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), binarySniffSize)], 0) >= 0
+}
+
+// writeBinaryDiff summarizes how the binary contents a and b, which aren't the
+// same, differ.
+func writeBinaryDiff(w io.Writer, nameA string, a []byte, nameB string, b []byte) {
+	common := min(len(a), len(b))
+	first, differing := -1, 0
+	for i := range common {
+		if a[i] != b[i] {
+			if first < 0 {
+				first = i
+			}
+			differing++
+		}
+	}
+	if first < 0 {
+		first = common
+	}
+
+	fmt.Fprintf(w, "entry:      %s (%d bytes)\n", nameA, len(a))
+	fmt.Fprintf(w, "file:       %s (%d bytes)\n", nameB, len(b))
+	fmt.Fprintf(w, "first diff: byte %d\n", first)
+	fmt.Fprintf(w, "differing:  %d of the first %d bytes\n", differing, common)
+}
+
+// splitLines splits data into lines, each ending with its newline except for
+// a last line without one, so that lines only compare equal if both or
+// neither end the contents without a newline.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		lines = append(lines, string(data[:end]))
+		data = data[end:]
+	}
+	return lines
+}
+
+// diffOp is a line that's in both sequences being compared (' '), or only in
+// the first ('-') or second ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the shortest edit script turning a into b, using Myers'
+// algorithm, after setting aside their common prefix and suffix.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+func myersDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace holds the furthest x reached on each diagonal k from -d-1 to
+	// d+1 before each step d, at index k+d+1.
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, slices.Clone(v[offset-d-1:offset+d+2]))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, diffOp{' ', a[x-1]})
+		x, y = x-1, y-1
+	}
+	slices.Reverse(ops)
+	return ops
+}
+
+// writeUnifiedDiff writes the hunks of a unified diff between a and b, with
+// diffContext lines of context around each change.
+func writeUnifiedDiff(w io.Writer, a, b []string) {
+	ops := diffLines(a, b)
+	// aLine and bLine are the number of lines of a and b before each op.
+	aLine, bLine := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Changes separated by no more than twice the context share a
+		// hunk.
+		start, last := max(0, i-diffContext), i
+		for j := i; j < len(ops) && j-last <= 2*diffContext; j++ {
+			if ops[j].kind != ' ' {
+				last = j
+			}
+		}
+		end := min(len(ops), last+diffContext+1)
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(aLine[start], aLine[end]-aLine[start]), hunkRange(bLine[start], bLine[end]-bLine[start]))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(w, "%c%s", op.kind, op.line)
+			if len(op.line) == 0 || op.line[len(op.line)-1] != '\n' {
+				fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+}
+
+// hunkRange formats the range of count lines after the first before lines,
+// as in the header of a hunk.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	default:
+		return fmt.Sprintf("%d,%d", before+1, count)
+	}
+}
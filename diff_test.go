@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	randomLines := func() []string {
+		lines := make([]string, rng.IntN(20))
+		for i := range lines {
+			lines[i] = string(rune('a'+rng.IntN(4))) + "\n"
+		}
+		return lines
+	}
+
+	for range 1000 {
+		a, b := randomLines(), randomLines()
+		var gotA, gotB []string
+		for _, op := range diffLines(a, b) {
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+		}
+		if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+			t.Fatalf("diff of %q and %q gives %q and %q", a, b, gotA, gotB)
+		}
+	}
+}
+
+func TestWriteUnifiedDiff(t *testing.T) {
+	var a, b strings.Builder
+	for i := range 20 {
+		line := strings.Repeat("x", i) + "\n"
+		a.WriteString(line)
+		if i == 2 {
+			b.WriteString("changed\n")
+		} else if i != 15 {
+			b.WriteString(line)
+		}
+	}
+	b.WriteString("end")
+
+	var buf bytes.Buffer
+	writeUnifiedDiff(&buf, splitLines([]byte(a.String())), splitLines([]byte(b.String())))
+	want := "@@ -1,6 +1,6 @@\n" +
+		" \n" +
+		" x\n" +
+		"-xx\n" +
+		"+changed\n" +
+		" xxx\n" +
+		" xxxx\n" +
+		" xxxxx\n" +
+		"@@ -13,8 +13,8 @@\n" +
+		" xxxxxxxxxxxx\n" +
+		" xxxxxxxxxxxxx\n" +
+		" xxxxxxxxxxxxxx\n" +
+		"-xxxxxxxxxxxxxxx\n" +
+		" xxxxxxxxxxxxxxxx\n" +
+		" xxxxxxxxxxxxxxxxx\n" +
+		" xxxxxxxxxxxxxxxxxx\n" +
+		" xxxxxxxxxxxxxxxxxxx\n" +
+		"+end\n" +
+		"\\ No newline at end of file\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got diff\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteBinaryDiff(t *testing.T) {
+	a := []byte("\x00abcdef")
+	b := []byte("\x00abXdeY!")
+	if !isBinary(a) {
+		t.Error("contents with a NUL byte aren't binary")
+	}
+
+	var buf bytes.Buffer
+	writeBinaryDiff(&buf, "a", a, "b", b)
+	want := "entry:      a (7 bytes)\n" +
+		"file:       b (8 bytes)\n" +
+		"first diff: byte 3\n" +
+		"differing:  2 of the first 7 bytes\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// canStream reports whether the inputs can be archived as they're discovered,
+// rather than all being discovered first, which is done for outputs that
+// can't be seeked, like stdout, so that archiving a large directory starts
+// writing right away, without --prescan. The options that need every input
+// before any are archived rule it out.
+func canStream(stdin bool) bool {
+	c := cli.Create
+	if c.Output != stdioPath && !isURL(c.Output) && !isStreamOutput(c.Output) {
+		return false
+	}
+	return !stdin && c.SplitSize == 0 && c.FilesFrom == "" &&
+		len(c.Include) == 0 && len(c.IncludeType) == 0 && len(c.ExcludeType) == 0 &&
+		c.NewerMtime == nil && c.OlderMtime == nil && c.MinSize == 0 && c.MaxSize == 0 &&
+		!c.Gitignore && len(c.IgnoreFile) == 0 && !c.Dereference &&
+		!c.Xattrs && !c.ACLs && !c.Capabilities &&
+		c.ListedIncremental == "" && !c.Update && !c.Auto && !c.Estimate && !c.DryRun &&
+		c.Manifest == "" && c.Sidecar == "" && len(c.Meta) == 0 && len(c.Also) == 0 && !c.Dedup
+}
+
+// streamsFormat reports whether format can archive entries as they're
+// discovered, which zips with --zip64 can't, since their sizes are checked
+// against every entry, and zip variants can't, since they reorder entries.
+func streamsFormat(format archives.Format, zip64 string) bool {
+	if compressed, ok := format.(archives.CompressedArchive); ok {
+		format = compressed.Archival
+	}
+	if _, ok := format.(squish.ZipVariant); ok {
+		return false
+	}
+	_, ok := format.(archives.ArchiverAsync)
+	return ok && zip64 == "auto"
+}
+
+// archiveStream writes the inputs to output using format as they're
+// discovered, except for any of outputs.
+func archiveStream(ctx context.Context, format archives.ArchiverAsync, output io.Writer, outputs []fs.FileInfo, progress *progress) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	filters, budget := contentFilters(), newTempBudget(int64(cli.Create.MaxTmp))
+	storeSpecial := cli.Create.SpecialFiles && isTar(format)
+	var inputBytes int64
+	next := streamFiles(ctx, outputs)
+	return archiveAsync(ctx, format, output, func() (archives.FileInfo, error) {
+		for {
+			file, err := next()
+			if err != nil {
+				return file, err
+			}
+			if len(withoutSpecialFiles([]archives.FileInfo{file}, storeSpecial)) == 0 {
+				continue
+			}
+
+			if file.Mode().IsRegular() {
+				inputBytes += file.Size()
+				history.setInputBytes(inputBytes)
+			}
+			// Filters are applied outside of progress, as they are for
+			// inputs that are discovered first.
+			prepared := []archives.FileInfo{prepareFile(ctx, file, progress)}
+			withContentFilters(prepared, filters, budget)
+			return prepared[0], nil
+		}
+	}, progress)
+}
+
+// streamedFile is a file discovered by streamFiles, or the error that ended
+// its discovery.
+type streamedFile struct {
+	file archives.FileInfo
+	err  error
+}
+
+// streamFiles walks the inputs in the background, returning a function that
+// returns each file that discoverFiles would, in the same order, as it's
+// discovered, until it returns io.EOF. Walking stops once ctx is done.
+func streamFiles(ctx context.Context, outputs []fs.FileInfo) func() (archives.FileInfo, error) {
+	files := make(chan streamedFile)
+	go func() {
+		defer close(files)
+		// Only the time between sends is spent walking, not that spent
+		// waiting for the archive to take the files.
+		walking := time.Now()
+		send := func(file streamedFile) bool {
+			stats.walked(walking)
+			defer func() { walking = time.Now() }()
+			select {
+			case files <- file:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var stripped bool
+		for _, input := range cli.Create.Inputs {
+			root := inputPath(input)
+			err := filepath.WalkDir(root, func(diskPath string, d fs.DirEntry, err error) error {
+				if err == nil {
+					err = ctx.Err()
+				}
+				if err != nil {
+					return err
+				}
+				file, err := discoveredFile(diskPath, root, filepath.Base(input), d)
+				if err != nil || file.NameInArchive == "" {
+					return err
+				}
+
+				// The contents of excluded directories are excluded too,
+				// so they aren't walked.
+				if len(withoutOutputs([]archives.FileInfo{file}, outputs)) == 0 ||
+					len(filterFiles([]archives.FileInfo{file}, nil, cli.Create.Exclude)) == 0 {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				name, trimmed := archiveName(file.NameInArchive)
+				if trimmed && !stripped {
+					stripped = true
+					warn("removed leading / from entry names, use --absolute-names to keep it")
+				}
+				if name == "" {
+					return nil
+				}
+				file.NameInArchive = name
+				if !send(streamedFile{file: file}) {
+					return ctx.Err()
+				}
+				return nil
+			})
+			if err != nil {
+				send(streamedFile{err: fmt.Errorf("failed to discover files: %w", err)})
+				return
+			}
+		}
+	}()
+
+	return func() (archives.FileInfo, error) {
+		file, ok := <-files
+		if !ok {
+			return archives.FileInfo{}, io.EOF
+		}
+		return file.file, file.err
+	}
+}
+
+// walkedRoot locates the files that archives.FilesFromDisk discovered from a
+// root on disk, which is always walked first, and is given the name that the
+// rest are relative to.
+type walkedRoot struct {
+	path, name string
+}
+
+// newWalkedRoot returns the root on disk at path that files were discovered
+// from by archives.FilesFromDisk.
+func newWalkedRoot(files []archives.FileInfo, path string) walkedRoot {
+	return walkedRoot{path: path, name: files[0].NameInArchive}
+}
+
+// relative returns the name of file relative to the root, which is "" for
+// the root itself.
+func (r walkedRoot) relative(file archives.FileInfo) string {
+	switch {
+	case file.NameInArchive == r.name:
+		return ""
+	case r.name == ".":
+		return file.NameInArchive
+	default:
+		return strings.TrimPrefix(file.NameInArchive, r.name+"/")
+	}
+}
+
+// diskPath returns the path of file on disk.
+func (r walkedRoot) diskPath(file archives.FileInfo) string {
+	return filepath.Join(r.path, filepath.FromSlash(r.relative(file)))
+}
+
+// discoveredFile returns the file at diskPath, which was walked from root, as
+// archives.FilesFromDisk would discover it when root is given the name
+// rootName, with no name if it's root and isn't archived itself.
+func discoveredFile(diskPath, root, rootName string, d fs.DirEntry) (archives.FileInfo, error) {
+	info, err := d.Info()
+	if err != nil {
+		return archives.FileInfo{}, err
+	}
+
+	if strings.HasSuffix(root, string(filepath.Separator)) {
+		if _, rest, ok := strings.Cut(strings.TrimPrefix(rootName, "/"), "/"); ok {
+			rootName = rest
+		} else {
+			rootName = strings.TrimPrefix(rootName, "/")
+		}
+	}
+	if strings.HasSuffix(rootName, "/") {
+		rootName += filepath.Base(root)
+	}
+	name := path.Join(rootName, filepath.ToSlash(strings.TrimPrefix(diskPath, root)))
+	if info.IsDir() && name == "" {
+		return archives.FileInfo{}, nil
+	}
+
+	var linkTarget string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if linkTarget, err = os.Readlink(diskPath); err != nil {
+			return archives.FileInfo{}, fmt.Errorf("%s: readlink: %w", diskPath, err)
+		}
+	}
+	return archives.FileInfo{
+		FileInfo:      info,
+		NameInArchive: name,
+		LinkTarget:    linkTarget,
+		Open:          func() (fs.File, error) { return os.Open(diskPath) },
+	}, nil
+}
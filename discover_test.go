@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestStreamFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a/b/c.txt", "a/d.txt", "a/skip/e.txt", "f.txt"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("f.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := cli.Create
+	t.Cleanup(func() { cli.Create = saved })
+	cli.Create.Directory = dir
+	cli.Create.Exclude = []glob{"skip"}
+	cli.Create.Prefix = "p"
+	for _, inputs := range [][]string{{"a"}, {"a" + string(filepath.Separator)}, {"f.txt", "link", "a"}} {
+		cli.Create.Inputs = inputs
+		var want []string
+		for _, file := range discoverFiles(context.Background(), nil) {
+			want = append(want, file.NameInArchive+" "+file.Mode().String()+" "+file.LinkTarget)
+		}
+
+		var got []string
+		next := streamFiles(context.Background(), nil)
+		for {
+			file, err := next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, file.NameInArchive+" "+file.Mode().String()+" "+file.LinkTarget)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%q: streamed %q, want %q", inputs, got, want)
+		}
+	}
+}
+
+func TestStreamFilesCancel(t *testing.T) {
+	dir := t.TempDir()
+	for i := range 10 {
+		if err := os.WriteFile(filepath.Join(dir, string(rune('a'+i))), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved := cli.Create
+	t.Cleanup(func() { cli.Create = saved })
+	cli.Create.Inputs = []string{dir}
+	ctx, cancel := context.WithCancel(context.Background())
+	next := streamFiles(ctx, nil)
+	if _, err := next(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	// Walking stops once ctx is done, possibly after an error for the
+	// interrupted walk.
+	for {
+		if _, err := next(); errors.Is(err, io.EOF) {
+			break
+		}
+	}
+}
+
+func TestArchiveStream(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "in", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "in", "sub", "file"), []byte("contents"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := cli.Create
+	t.Cleanup(func() { cli.Create = saved })
+	cli.Create.Inputs = []string{filepath.Join(dir, "in")}
+	var buf bytes.Buffer
+	if err := archiveStream(context.Background(), archives.Tar{}, &buf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	err := archives.Tar{}.Extract(context.Background(), &buf, func(ctx context.Context, f archives.FileInfo) error {
+		var contents []byte
+		if !f.IsDir() {
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			if contents, err = io.ReadAll(r); err != nil {
+				return err
+			}
+		}
+		got[f.NameInArchive] = string(contents)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"in": "", "in/sub": "", "in/sub/file": "contents"}
+	if !maps.Equal(got, want) {
+		t.Errorf("got entries %q, want %q", got, want)
+	}
+}
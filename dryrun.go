@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"syscall"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// printPlanned prints the name of an entry to w for --dry-run, prefixed with
+// the letter of the action that would be taken on it, and preceded by its mode
+// and size if --verbose was given twice.
+func printPlanned(w io.Writer, progress *progress, action, name string, info fs.FileInfo) {
+	line := action + " " + name
+	if cli.Verbose > 1 {
+		line = fmt.Sprintf("%s %s %12d %s", action, info.Mode(), info.Size(), name)
+	}
+	progress.println(w, line)
+}
+
+// dryRunCreate prints the files that create would archive, and logs what it
+// would do with the output, for --dry-run. appending is whether they would be
+// appended to an existing archive by --update.
+func dryRunCreate(files []archives.FileInfo, appending bool, progress *progress) {
+	w := verboseOutput(cli.Create.Output)
+	for _, file := range files {
+		printPlanned(w, progress, "A", file.NameInArchive, file)
+	}
+
+	size := byteSize(totalSize(files))
+	output := cli.Create.Output
+	switch {
+	case output == stdioPath:
+		logMessage(slog.LevelInfo, nil, "would write %d entries, %s to stdout", len(files), size)
+	case isURL(output) || isStreamOutput(output):
+		logMessage(slog.LevelInfo, nil, "would write %d entries, %s to %s", len(files), size, output)
+	case appending:
+		logMessage(slog.LevelInfo, nil, "would append %d entries, %s to %s", len(files), size, output)
+	default:
+		if cli.Create.SplitSize > 0 {
+			output = volumeName(output, 1)
+		}
+		// checkClobber already refused to replace the output without
+		// --force.
+		if _, err := os.Lstat(output); err == nil {
+			logMessage(slog.LevelInfo, nil, "would replace %s with %d entries, %s", output, len(files), size)
+		} else {
+			logMessage(slog.LevelInfo, nil, "would create %s with %d entries, %s", output, len(files), size)
+		}
+	}
+}
+
+// plan prints what extracting info to name would do for --dry-run, given what
+// already exists there, without writing anything. It fails as extraction
+// would, if the entry would replace a directory or be a symbolic link
+// pointing outside of the output.
+func (e *entryExtractor) plan(info archives.FileInfo, name string, existing fs.FileInfo) error {
+	// With --if-changed, regular files that are already the same are left
+	// alone, so they aren't printed at all.
+	if e.ifChanged && existing != nil && existing.Mode().IsRegular() && info.Mode().IsRegular() && existing.Size() == info.Size() {
+		var matched int64
+		var err error
+		if info, matched, err = e.compareExisting(info, name); err != nil {
+			return withEntry(info.NameInArchive, fmt.Errorf("failed to compare with existing output: %w", err))
+		}
+		if matched == info.Size() {
+			return nil
+		}
+		// Nothing is written, so the entry and the existing file it was
+		// compared with are closed straight away.
+		if f, err := info.Open(); err == nil {
+			f.Close()
+		}
+	}
+
+	action := "A"
+	switch {
+	case existing != nil && existing.IsDir() && info.IsDir():
+		action = "M"
+	case existing != nil && e.overwrite == "always":
+		action = "R"
+	case existing != nil && e.overwrite == "newer" && info.ModTime().After(existing.ModTime()):
+		action = "R"
+	case existing != nil && e.overwrite == "prompt":
+		action = "?"
+	case existing != nil:
+		action = "S"
+	}
+	if action == "R" && existing.IsDir() {
+		return withEntry(info.NameInArchive, fmt.Errorf("failed to replace %s: %w", name, syscall.EISDIR))
+	} else if action == "R" {
+		e.symlinks.Remove(name)
+	}
+
+	if action != "S" {
+		if err := e.limits.entry(); err != nil {
+			return withEntry(info.NameInArchive, err)
+		}
+		// Later entries are checked against the symbolic links that would
+		// have been created, as they are when extracting.
+		if info.Mode()&fs.ModeSymlink != 0 {
+			target, err := squish.LinkTarget(info)
+			if err != nil {
+				return withEntry(info.NameInArchive, fmt.Errorf("failed to read symbolic link target: %w", err))
+			}
+			if e.linkEscapes(name, target) {
+				return withEntry(info.NameInArchive, &unsafeLinkError{name: name, target: target})
+			}
+			e.symlinks.Add(name)
+		}
+	}
+
+	printPlanned(os.Stdout, e.progress, action, name, info)
+	return nil
+}
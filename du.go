@@ -0,0 +1,73 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+	"github.com/nwaples/rardecode/v2"
+)
+
+type duEntry struct {
+	name                  string
+	size, compressedSize  int64
+	compressedSizeUnknown bool
+}
+
+func du(ctx context.Context) {
+	input, extractor, inputR := openExtractor(ctx, cli.Du.Input)
+	defer closeInput(input)
+
+	entries := map[string]*duEntry{}
+	err := extractor.Extract(ctx, inputR, func(ctx context.Context, info archives.FileInfo) error {
+		name, rest, nested := strings.Cut(strings.TrimPrefix(info.NameInArchive, "/"), "/")
+		if nested && rest != "" || info.IsDir() {
+			name += "/"
+		}
+
+		entry, ok := entries[name]
+		if !ok {
+			entry = &duEntry{name: name}
+			entries[name] = entry
+		}
+
+		// Directories have no contents, but their compressed sizes are
+		// still only known if the format records them.
+		if !info.IsDir() {
+			entry.size += info.Size()
+		}
+		switch header := info.Header.(type) {
+		case zip.FileHeader:
+			entry.compressedSize += int64(header.CompressedSize64)
+		case *rardecode.FileHeader:
+			entry.compressedSize += header.PackedSize
+		default:
+			entry.compressedSizeUnknown = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		bail("failed to read archive: %s", err)
+	}
+
+	sorted := make([]*duEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	slices.SortFunc(sorted, func(a, b *duEntry) int {
+		return cmp.Or(cmp.Compare(b.size, a.size), strings.Compare(a.name, b.name))
+	})
+
+	for _, entry := range sorted {
+		compressedSize := "-"
+		if !entry.compressedSizeUnknown {
+			compressedSize = fmt.Sprint(entry.compressedSize)
+		}
+		fmt.Printf("%d\t%s\t%s\n", entry.size, compressedSize, entry.name)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs f, which may bail, and returns what it printed to
+// stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	return captureOutput(t, &os.Stdout, f)
+}
+
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	return captureOutput(t, &os.Stderr, f)
+}
+
+// captureOutput returns what's written to *file while f runs, in its own
+// goroutine so that it can bail.
+func captureOutput(t *testing.T, file **os.File, f func()) string {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	saved := *file
+	*file = out
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	<-done
+	*file = saved
+
+	// f may close the file, as create does when it writes to stdout.
+	printed, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(printed)
+}
+
+func TestDu(t *testing.T) {
+	entries := []testEntry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/x", typeflag: tar.TypeReg, contents: strings.Repeat("x", 10)},
+		{name: "a/b/y", typeflag: tar.TypeReg, contents: strings.Repeat("y", 20)},
+		{name: "c", typeflag: tar.TypeReg, contents: strings.Repeat("c", 25)},
+		{name: "d/", typeflag: tar.TypeDir},
+		{name: "e", typeflag: tar.TypeReg, contents: strings.Repeat("e", 25)},
+	}
+	dir := t.TempDir()
+	saved := cli
+	t.Cleanup(func() { cli = saved })
+
+	// Sizes are summed per top-level entry, and sorted by size and then
+	// name, with unknown compressed sizes shown as -.
+	cli.Du.Input = filepath.Join(dir, "in.tar")
+	if err := os.WriteFile(cli.Du.Input, makeTar(t, entries), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := "30\t-\ta/\n25\t-\tc\n25\t-\te\n0\t-\td/\n"
+	if got := captureStdout(t, func() { du(context.Background()) }); got != want {
+		t.Errorf("tar: got %q, want %q", got, want)
+	}
+
+	// Zip archives record the compressed sizes of their entries, including
+	// directories.
+	var files []testEntry
+	for _, entry := range entries {
+		if entry.typeflag == tar.TypeReg || entry.name == "d/" {
+			files = append(files, entry)
+		}
+	}
+	archive := makeZip(t, files)
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := map[string]uint64{}
+	for _, f := range r.File {
+		compressed[strings.SplitAfter(f.Name, "/")[0]] += f.CompressedSize64
+	}
+	cli.Du.Input = filepath.Join(dir, "in.zip")
+	if err := os.WriteFile(cli.Du.Input, archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want = fmt.Sprintf("30\t%d\ta/\n25\t%d\tc\n25\t%d\te\n0\t0\td/\n", compressed["a/"], compressed["c"], compressed["e"])
+	if got := captureStdout(t, func() { du(context.Background()) }); got != want {
+		t.Errorf("zip: got %q, want %q", got, want)
+	}
+}
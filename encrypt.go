@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// encryptRecipient is a flag value naming a recipient to encrypt the output to,
+// given as age:RECIPIENT or gpg:KEY.
+type encryptRecipient struct {
+	age age.Recipient
+	gpg string
+}
+
+func (r *encryptRecipient) UnmarshalText(text []byte) error {
+	scheme, recipient, ok := strings.Cut(string(text), ":")
+	if !ok {
+		return fmt.Errorf("invalid recipient %q, expected SCHEME:RECIPIENT", text)
+	}
+
+	switch scheme {
+	case "age":
+		key, err := parseAgeRecipient(recipient)
+		if err != nil {
+			return err
+		}
+		*r = encryptRecipient{age: key}
+	case "gpg":
+		if recipient == "" {
+			return fmt.Errorf("invalid recipient %q, expected gpg:KEY", text)
+		}
+		*r = encryptRecipient{gpg: recipient}
+	default:
+		return fmt.Errorf("unknown encryption scheme %q, expected age or gpg", scheme)
+	}
+	return nil
+}
+
+// encryptionRecipients returns the age and gpg recipients that the output is
+// encrypted to, from --encrypt and --gpg-recipient. At most one of them is
+// non-empty, since an output can only be encrypted one way.
+func encryptionRecipients() (ageRecipients []age.Recipient, gpg []string) {
+	return splitRecipients(cli.Create.Encrypt, cli.Create.Recipient)
+}
+
+// splitRecipients returns the age and gpg recipients among recipients, along
+// with the gpg recipients gpg, bailing if there are both.
+func splitRecipients(recipients []encryptRecipient, gpg []string) ([]age.Recipient, []string) {
+	var ageRecipients []age.Recipient
+	gpg = slices.Clone(gpg)
+	for _, recipient := range recipients {
+		if recipient.age != nil {
+			ageRecipients = append(ageRecipients, recipient.age)
+		} else {
+			gpg = append(gpg, recipient.gpg)
+		}
+	}
+	if len(ageRecipients) > 0 && len(gpg) > 0 {
+		bail("the output can't be encrypted to both age and gpg recipients")
+	}
+	return ageRecipients, gpg
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"path/filepath"
+)
+
+// entryReport collects the outcome of extracting an entry, which is emitted as
+// an entry_result event once the entry is handled, so that consumers of
+// --porcelain=json can act on each file as soon as it's in place. A nil
+// *entryReport, which is used when events aren't written, reports nothing.
+type entryReport struct {
+	entry, path string
+	// action is what was done with the entry: created, replaced, rewritten
+	// in place by --if-changed, resumed, merged with an existing directory,
+	// left unchanged, or skipped.
+	action   string
+	bytes    int64
+	digest   hash.Hash
+	warnings []string
+}
+
+// newReport returns the report of extracting the entry info to name beneath
+// e.root, or nil if events aren't written.
+func (e *entryExtractor) newReport(entry, name string) *entryReport {
+	if events == nil {
+		return nil
+	}
+	path := name
+	if !filepath.IsAbs(name) {
+		path = filepath.Join(e.outputPath, name)
+	}
+	return &entryReport{entry: entry, path: path, action: "created"}
+}
+
+// setAction sets what was done with the entry.
+func (r *entryReport) setAction(action string) {
+	if r != nil {
+		r.action = action
+	}
+}
+
+// hash returns the hash that the contents of the entry should be written to,
+// or nil if they aren't reported.
+func (r *entryReport) hash() hash.Hash {
+	if r == nil {
+		return nil
+	}
+	r.digest = sha256.New()
+	return r.digest
+}
+
+// warn reports a problem with the entry, as warn does, and records it in the
+// report.
+func (r *entryReport) warn(format string, a ...any) {
+	warn(format, a...)
+	if r != nil {
+		r.warnings = append(r.warnings, fmt.Sprintf(format, a...))
+	}
+}
+
+// skip reports that the entry was skipped, with a warning giving the reason.
+func (r *entryReport) skip(format string, a ...any) {
+	r.setAction("skipped")
+	r.warn(format, a...)
+	r.emit()
+}
+
+// done emits the report if err, the result of extracting the entry, is nil,
+// and returns err.
+func (r *entryReport) done(err error) error {
+	if err == nil {
+		r.emit()
+	}
+	return err
+}
+
+// emit writes the report as an entry_result event.
+func (r *entryReport) emit() {
+	if r == nil {
+		return
+	}
+	fields := map[string]any{"entry": r.entry, "path": r.path, "action": r.action, "bytes": r.bytes}
+	if r.digest != nil {
+		fields["sha256"] = hex.EncodeToString(r.digest.Sum(nil))
+	}
+	if len(r.warnings) > 0 {
+		fields["warnings"] = r.warnings
+	}
+	events.emit("entry_result", fields)
+}
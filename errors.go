@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"syscall"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/pgzip"
+	"github.com/mholt/archives"
+	"github.com/nwaples/rardecode/v2"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// unsafePathError is returned for entries whose paths would be written outside
+// of the output.
+type unsafePathError struct {
+	name string
+}
+
+func (e *unsafePathError) Error() string {
+	if path.IsAbs(filepath.ToSlash(e.name)) {
+		return fmt.Sprintf("input entry %s has an absolute path, which is only extracted with --absolute-names", e.name)
+	}
+	return fmt.Sprintf("input entry %s was non-local, potential directory traversal attack", e.name)
+}
+
+// unsafeLinkError is returned for symbolic links whose targets are outside of
+// the output, and for entries that would be written through symbolic links
+// that were extracted earlier.
+type unsafeLinkError struct {
+	name string
+	// target is the target of the symbolic link name, if it's outside of
+	// the output, and otherwise link is the symbolic link that name would be
+	// written through.
+	target, link string
+}
+
+func (e *unsafeLinkError) Error() string {
+	if e.link != "" {
+		return fmt.Sprintf("input entry %s would be written through symbolic link %s, potential directory traversal attack", e.name, e.link)
+	}
+	return fmt.Sprintf("symbolic link %s points to %s, outside of the output, potential directory traversal attack", e.name, e.target)
+}
+
+// partialError is returned when some entries couldn't be handled, while the
+// rest were, as with --keep-going.
+type partialError struct {
+	err error
+}
+
+func (e *partialError) Error() string { return e.err.Error() }
+func (e *partialError) Unwrap() error { return e.err }
+
+// withEntry wraps err, if it's non-nil, in a *squish.EntryError with the name
+// of the entry it occurred for, unless it already has one.
+func withEntry(entry string, err error) error {
+	var entryErr *squish.EntryError
+	if err == nil || errors.As(err, &entryErr) {
+		return err
+	}
+	return &squish.EntryError{Name: entry, Err: err}
+}
+
+// errnoCodes are the names of the errnos reported as error codes.
+var errnoCodes = map[syscall.Errno]string{
+	syscall.EACCES:  "EACCES",
+	syscall.EDQUOT:  "EDQUOT",
+	syscall.EEXIST:  "EEXIST",
+	syscall.EIO:     "EIO",
+	syscall.EISDIR:  "EISDIR",
+	syscall.ELOOP:   "ELOOP",
+	syscall.ENOENT:  "ENOENT",
+	syscall.ENOSPC:  "ENOSPC",
+	syscall.ENOTDIR: "ENOTDIR",
+	syscall.EPERM:   "EPERM",
+	syscall.EROFS:   "EROFS",
+}
+
+// errorDetails describes the first error in a, the arguments of a message
+// passed to bail or warn, as fields of JSON logs and events. Every description
+// has a category, which is one of not_found, permission, no_space, password,
+// corrupt, unsafe_path, limit_exceeded, unsupported, canceled, io, partial, or
+// other, or usage if a has no error, since those messages report invalid
+// arguments.
+// It also has an errno name as the code and the entry that was being handled
+// when they're known.
+func errorDetails(a []any) map[string]any {
+	var err error
+	for _, arg := range a {
+		if argErr, ok := arg.(error); ok {
+			err = argErr
+			break
+		}
+	}
+	if err == nil {
+		return map[string]any{"category": "usage"}
+	}
+
+	details := map[string]any{"category": errorCategory(err)}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if code, ok := errnoCodes[errno]; ok {
+			details["code"] = code
+		}
+	}
+	var entryErr *squish.EntryError
+	if errors.As(err, &entryErr) {
+		details["entry"] = entryErr.Name
+	}
+	return details
+}
+
+func errorCategory(err error) string {
+	var errno syscall.Errno
+	var corruptErr flate.CorruptInputError
+	var unsafePathErr *unsafePathError
+	var unsafeLinkErr *unsafeLinkError
+	var reserveErr *reserveError
+	var limitErr *limitError
+	var partialErr *partialError
+	var unsupportedErr *unsupportedError
+	var sevenZipErr *sevenzip.ReadError
+	switch {
+	case errors.As(err, &partialErr):
+		return "partial"
+	case errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		return "permission"
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT), errors.As(err, &reserveErr):
+		return "no_space"
+	case errors.Is(err, rardecode.ErrBadPassword), errors.Is(err, squish.ErrIncorrectPassword), errors.Is(err, squish.ErrPasswordRequired), errors.Is(err, errAgeNoIdentity):
+		return "password"
+	// Encrypted 7z archives don't authenticate what they decrypt, so a
+	// missing or wrong password only shows as a failure to read what's
+	// encrypted.
+	case errors.As(err, &sevenZipErr) && sevenZipErr.Encrypted:
+		return "password"
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, tar.ErrHeader), errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrChecksum), errors.Is(err, squish.ErrAuthentication),
+		errors.Is(err, errAgeHeader), errors.Is(err, errAgeAuthentication), errors.Is(err, errPipeChecksum), errors.Is(err, errPipeDigest), errors.Is(err, errPipeTruncated), errors.Is(err, errChecksumMismatch),
+		errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum), errors.Is(err, pgzip.ErrHeader), errors.Is(err, pgzip.ErrChecksum),
+		errors.As(err, &corruptErr):
+		return "corrupt"
+	case errors.As(err, &unsafePathErr), errors.As(err, &unsafeLinkErr):
+		return "unsafe_path"
+	case errors.As(err, &limitErr):
+		return "limit_exceeded"
+	case errors.Is(err, archives.NoMatch), errors.Is(err, zip.ErrAlgorithm), errors.As(err, &unsupportedErr):
+		return "unsupported"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case errors.As(err, &errno):
+		return "io"
+	default:
+		return "other"
+	}
+}
+
+// exitCodes are the statuses that squish exits with when it fails with an
+// error of each category, so that scripts can react to them. Failures of other
+// categories exit with status 1, as do commands like grep that find nothing.
+var exitCodes = map[string]int{
+	"usage":          2,
+	"unsupported":    3,
+	"not_found":      4,
+	"permission":     4,
+	"no_space":       4,
+	"io":             4,
+	"corrupt":        5,
+	"partial":        6,
+	"password":       7,
+	"unsafe_path":    8,
+	"limit_exceeded": 8,
+	"canceled":       130,
+}
+
+// exitCodeHelp documents exitCodes.
+const exitCodeHelp = "Exits with status 0 on success, 2 for invalid arguments, 3 if the format can't be identified or isn't supported, 4 for I/O errors, like missing files, denied permissions or full disks, 5 if an archive is corrupt, 6 if only some entries could be extracted with --keep-going, 7 for missing or wrong passwords, 8 if an entry is unsafe or a limit was exceeded, 130 if interrupted, and 1 otherwise."
+
+// exitCodeOf returns the status to exit with after failing with the error
+// described by details, as returned by errorDetails.
+func exitCodeOf(details map[string]any) int {
+	if code, ok := exitCodes[details["category"].(string)]; ok {
+		return code
+	}
+	return 1
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/mholt/archives"
+)
+
+func TestErrorDetails(t *testing.T) {
+	tests := []struct {
+		args []any
+		want map[string]any
+	}{
+		{[]any{3}, map[string]any{"category": "usage"}},
+		{
+			[]any{&os.PathError{Op: "open", Path: "a", Err: syscall.ENOENT}},
+			map[string]any{"category": "not_found", "code": "ENOENT"},
+		},
+		{
+			[]any{"a", fmt.Errorf("handling file: %w", withEntry("a/b", fmt.Errorf("failed: %w", &os.PathError{Op: "write", Path: "b", Err: syscall.ENOSPC})))},
+			map[string]any{"category": "no_space", "code": "ENOSPC", "entry": "a/b"},
+		},
+		{
+			[]any{withEntry("outer", withEntry("inner", io.ErrUnexpectedEOF))},
+			map[string]any{"category": "corrupt", "entry": "inner"},
+		},
+		{
+			[]any{withEntry("../a", &unsafePathError{"../a"})},
+			map[string]any{"category": "unsafe_path", "entry": "../a"},
+		},
+		{
+			[]any{&partialError{withEntry("a", io.ErrUnexpectedEOF)}},
+			map[string]any{"category": "partial", "entry": "a"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := errorDetails(test.args); !maps.Equal(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.args, got, test.want)
+		}
+	}
+}
+
+func TestExitCodeOf(t *testing.T) {
+	tests := []struct {
+		args []any
+		want int
+	}{
+		{[]any{"a"}, 2},
+		{[]any{archives.NoMatch}, 3},
+		{[]any{&os.PathError{Op: "open", Path: "a", Err: syscall.EACCES}}, 4},
+		{[]any{io.ErrUnexpectedEOF}, 5},
+		{[]any{fmt.Errorf("reading: %w", &sevenzip.ReadError{Encrypted: true, Err: io.ErrUnexpectedEOF})}, 7},
+		{[]any{&sevenzip.ReadError{Err: io.ErrUnexpectedEOF}}, 5},
+		{[]any{&partialError{&unsafePathError{"../a"}}}, 6},
+		{[]any{&unsafePathError{"../a"}}, 8},
+		{[]any{context.Canceled}, 130},
+		{[]any{errors.New("other")}, 1},
+	}
+
+	for _, test := range tests {
+		if got := exitCodeOf(errorDetails(test.args)); got != test.want {
+			t.Errorf("%v: got exit code %d, want %d", test.args, got, test.want)
+		}
+	}
+}
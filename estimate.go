@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+// estimateSampleSize is roughly the amount of input that --estimate
+// compresses, and estimateChunkSize is the most that's read from each sampled
+// file, so that the sample is spread across many files.
+const (
+	estimateSampleSize = 64 << 20
+	estimateChunkSize  = 1 << 20
+)
+
+// estimate compresses a sample of files with format, discarding the output,
+// and prints the size of the output and the time it would take to create it,
+// extrapolated from the sample. If the input is small enough, it's all
+// compressed, and the size is exact.
+func estimate(ctx context.Context, format archives.Format, files []archives.FileInfo, stdin bool) {
+	if stdin {
+		bail("--estimate can't be used when compressing stdin")
+	}
+
+	var inputSize int64
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			inputSize += file.Size()
+		}
+	}
+
+	var output countingWriter
+	var sampledSize int64
+	started := time.Now()
+	switch format := format.(type) {
+	case archives.Archiver:
+		var sample []archives.FileInfo
+		sample, sampledSize = sampleFiles(files, inputSize)
+		if err := format.Archive(ctx, &output, sample); err != nil {
+			bail("failed to create archive: %s", err)
+		}
+
+	case archives.Compressor:
+		if len(files) < 1 {
+			bail("identified format only supports compression, but no input file was provided")
+		}
+		if len(files) > 1 {
+			bail("identified format only supports compression, but multiple input files were provided")
+		}
+
+		inputF, err := files[0].Open()
+		if err != nil {
+			bail("failed to open input file: %s", err)
+		}
+		defer inputF.Close()
+
+		outputWC, err := format.OpenWriter(&output)
+		if err != nil {
+			bail("failed to create compressed file writer: %s", err)
+		}
+		sampledSize, err = io.Copy(outputWC, io.LimitReader(inputF, estimateSampleSize))
+		if err != nil {
+			bail("failed to copy input file to compressed file writer: %s", err)
+		}
+		if err := outputWC.Close(); err != nil {
+			bail("failed to close compressed file writer: %s", err)
+		}
+
+	default:
+		bail("identified format doesn't support archiving or compression")
+	}
+	elapsed := time.Since(started)
+
+	scale := 1.0
+	if sampledSize > 0 {
+		scale = float64(inputSize) / float64(sampledSize)
+	}
+	size := int64(float64(output) * scale)
+	duration := time.Duration(float64(elapsed) * scale)
+	if duration < time.Second {
+		duration = duration.Round(time.Millisecond)
+	} else {
+		duration = duration.Round(time.Second)
+	}
+
+	ratio := 0.0
+	if inputSize > 0 {
+		ratio = float64(size) / float64(inputSize)
+	}
+
+	fmt.Printf("input size:     %s\n", byteSize(inputSize))
+	fmt.Printf("sampled:        %s\n", byteSize(sampledSize))
+	fmt.Printf("estimated size: %s\n", byteSize(size))
+	fmt.Printf("ratio:          %.3f\n", ratio)
+	fmt.Printf("estimated time: %s\n", duration)
+}
+
+// sampleFiles returns a sample of files, whose regular files total inputSize
+// bytes, along with the number of bytes of regular files in the sample. Unless
+// they're small enough to be sampled entirely, every nth entry is sampled,
+// with no more than estimateChunkSize bytes read from each.
+func sampleFiles(files []archives.FileInfo, inputSize int64) ([]archives.FileInfo, int64) {
+	if inputSize <= estimateSampleSize {
+		return files, inputSize
+	}
+
+	var chunksSize int64
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			chunksSize += min(file.Size(), estimateChunkSize)
+		}
+	}
+	stride := max(1, int((chunksSize+estimateSampleSize-1)/estimateSampleSize))
+
+	var sample []archives.FileInfo
+	var sampledSize int64
+	for i := 0; i < len(files); i += stride {
+		file := files[i]
+		if file.Mode().IsRegular() && file.Size() > estimateChunkSize {
+			open := file.Open
+			file.FileInfo = truncatedInfo{file.FileInfo}
+			file.Open = func() (fs.File, error) {
+				f, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return truncatedFile{f, io.LimitReader(f, estimateChunkSize)}, nil
+			}
+		}
+		if file.Mode().IsRegular() {
+			sampledSize += file.Size()
+		}
+		sample = append(sample, file)
+	}
+	return sample, sampledSize
+}
+
+// truncatedInfo reports the size of a file truncated to estimateChunkSize.
+type truncatedInfo struct {
+	fs.FileInfo
+}
+
+func (i truncatedInfo) Size() int64 {
+	return estimateChunkSize
+}
+
+// truncatedFile reads a file only up to estimateChunkSize.
+type truncatedFile struct {
+	fs.File
+	r io.Reader
+}
+
+func (f truncatedFile) Read(b []byte) (int, error) {
+	return f.r.Read(b)
+}
+
+func (f truncatedFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return truncatedInfo{info}, nil
+}
+
+// countingWriter discards what's written to it, counting the bytes.
+type countingWriter int64
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	*w += countingWriter(len(b))
+	return len(b), nil
+}
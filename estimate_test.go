@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mholt/archives"
+)
+
+func TestSampleFiles(t *testing.T) {
+	fsys := fstest.MapFS{"d": {Mode: fs.ModeDir | 0o755}}
+	names := []string{"d"}
+	for i := range 128 {
+		name := "d/" + strings.Repeat("x", i+1)
+		fsys[name] = &fstest.MapFile{Data: make([]byte, 2*estimateChunkSize)}
+		names = append(names, name)
+	}
+
+	var files []archives.FileInfo
+	var inputSize int64
+	for _, name := range names {
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, archives.FileInfo{FileInfo: info, NameInArchive: name, Open: func() (fs.File, error) { return fsys.Open(name) }})
+		if info.Mode().IsRegular() {
+			inputSize += info.Size()
+		}
+	}
+
+	sample, sampledSize := sampleFiles(files, inputSize)
+	if want := int64(estimateSampleSize); sampledSize != want {
+		t.Errorf("got sampled size %d, want %d", sampledSize, want)
+	}
+
+	var read int64
+	for _, file := range sample {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if file.Size() != estimateChunkSize {
+			t.Errorf("%s: got size %d, want %d", file.NameInArchive, file.Size(), estimateChunkSize)
+		}
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(io.Discard, f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += n
+	}
+	if read != sampledSize {
+		t.Errorf("read %d bytes from the sample, want %d", read, sampledSize)
+	}
+
+	if sample, sampledSize := sampleFiles(files[:4], 3*2*estimateChunkSize); len(sample) != 4 || sampledSize != 3*2*estimateChunkSize {
+		t.Errorf("small input wasn't sampled entirely: got %d entries of %d bytes", len(sample), sampledSize)
+	}
+}
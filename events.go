@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// events writes newline-delimited JSON events describing the operation for
+// --porcelain=json, or is nil if they weren't requested. Every event has an
+// "event" field naming its kind: entry_start, entry_end, entry_result,
+// progress, warning, error, or summary.
+var events *eventWriter
+
+type eventWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	fd  int
+	err error
+}
+
+func openEvents(fd int) (*eventWriter, error) {
+	file := os.NewFile(uintptr(fd), "porcelain")
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	if _, err := file.Stat(); err != nil {
+		return nil, fmt.Errorf("invalid file descriptor %d: %w", fd, err)
+	}
+	return &eventWriter{w: file, fd: fd}, nil
+}
+
+// emit writes an event of the given kind with fields. A nil *eventWriter is
+// valid and writes nothing. Failing to write events doesn't stop the
+// operation, but only the first failure is reported.
+func (e *eventWriter) emit(kind string, fields map[string]any) {
+	if e == nil {
+		return
+	}
+
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	fields["event"] = kind
+	line, err := json.Marshal(fields)
+	if err != nil {
+		panic(err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(append(line, '\n')); err != nil && e.err == nil {
+		e.err = err
+		logger.Error("failed to write event", "error", err)
+	}
+}
+
+// usesStdout reports whether events are written to stdout, so that it can't
+// also be used for output.
+func (e *eventWriter) usesStdout() bool {
+	return e != nil && e.fd == 1
+}
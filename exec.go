@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+// execMagicSize is the number of bytes at the start of a file that
+// isExecutableContent needs.
+const execMagicSize = 8
+
+// Values of the upper byte of the creator version of zip entries whose
+// external attributes hold Unix modes.
+const (
+	zipCreatorUnix  = 3
+	zipCreatorMacOS = 19
+)
+
+// machOMagics are the magic numbers of thin Mach-O binaries, in both byte
+// orders.
+var machOMagics = [][]byte{
+	{0xfe, 0xed, 0xfa, 0xce},
+	{0xfe, 0xed, 0xfa, 0xcf},
+	{0xce, 0xfa, 0xed, 0xfe},
+	{0xcf, 0xfa, 0xed, 0xfe},
+}
+
+// storesUnixMode reports whether the archive stores the Unix permissions of
+// info, which zips created on Windows and other non-Unix systems don't.
+func storesUnixMode(info archives.FileInfo) bool {
+	header, ok := info.Header.(zip.FileHeader)
+	if !ok {
+		return true
+	}
+
+	switch header.CreatorVersion >> 8 {
+	case zipCreatorUnix, zipCreatorMacOS:
+		return true
+	}
+	return false
+}
+
+// isExecutableContent reports whether a file starting with magic is a script
+// with a shebang, or an ELF or Mach-O binary.
+func isExecutableContent(magic []byte) bool {
+	if bytes.HasPrefix(magic, []byte("#!")) || bytes.HasPrefix(magic, []byte("\x7fELF")) {
+		return true
+	}
+	for _, machO := range machOMagics {
+		if bytes.HasPrefix(magic, machO) {
+			return true
+		}
+	}
+
+	// Universal Mach-O binaries share their magic number with Java class
+	// files, which are told apart by the number of architectures that
+	// follows it, since class file versions start at 45.
+	if len(magic) >= 8 && (bytes.HasPrefix(magic, []byte{0xca, 0xfe, 0xba, 0xbe}) || bytes.HasPrefix(magic, []byte{0xca, 0xfe, 0xba, 0xbf})) {
+		archs := binary.BigEndian.Uint32(magic[4:8])
+		return archs > 0 && archs < 45
+	}
+	return false
+}
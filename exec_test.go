@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestIsExecutableContent(t *testing.T) {
+	tests := []struct {
+		name  string
+		magic string
+		want  bool
+	}{
+		{"shebang", "#!/bin/sh\n", true},
+		{"elf", "\x7fELF\x02\x01\x01\x00", true},
+		{"mach-o", "\xcf\xfa\xed\xfe\x07\x00\x00\x01", true},
+		{"universal mach-o", "\xca\xfe\xba\xbe\x00\x00\x00\x02", true},
+		{"java class", "\xca\xfe\xba\xbe\x00\x00\x00\x34", false},
+		{"text", "hello world", false},
+		{"short", "#", false},
+		{"empty", "", false},
+	}
+
+	for _, test := range tests {
+		if got := isExecutableContent([]byte(test.magic)); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,1616 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/internal/safepath"
+	"mtoohey.com/squish/pkg/squish"
+)
+
+func extract(ctx context.Context) {
+	if archive, entry := nestedEntry(ctx, cli.Extract.Input); entry != "" {
+		if len(cli.Extract.Patterns) > 0 {
+			bail("patterns can't be given when the input is an entry within an archive")
+		}
+		cli.Extract.Input = archive
+		cli.Extract.Patterns = []glob{entryGlob(entry)}
+	}
+
+	var input io.ReadCloser
+	inputSize := int64(-1)
+	inputName := trimVolumeSuffix(cli.Extract.Input)
+	if isURL(cli.Extract.Input) {
+		inputName = urlBaseName(cli.Extract.Input)
+	} else if outer, _, ok := splitNestedPath(cli.Extract.Input); ok {
+		// Archives within others are extracted beside the outermost one.
+		inputName = filepath.Join(filepath.Dir(outer), path.Base(filepath.ToSlash(cli.Extract.Input)))
+	}
+
+	var header []byte
+	var remote *httpInput
+	var pipe *pipeReader
+	var stream bool
+	if cli.Extract.Input == stdioPath {
+		// Hide os.Stdin's Seek and ReadAt methods, since they fail for
+		// pipes, so that identification buffers what it reads instead.
+		var stdinR io.Reader = os.Stdin
+		var err error
+		if cli.Extract.Pipe {
+			if pipe, err = newPipeReader(os.Stdin); err != nil {
+				bail("failed to read input: %s", err)
+			}
+			stdinR = pipe
+		}
+		stdin := bufio.NewReader(throttleReader(stats.reader(stdinR)))
+		header, err = stdin.Peek(gpgSniffSize)
+		// Corrupted --pipe streams would otherwise fail to be identified.
+		if pipe != nil && err != nil && err != io.EOF {
+			bail("failed to read input: %s", err)
+		}
+		input = io.NopCloser(stdin)
+		inputName = ""
+	} else {
+		if cli.Extract.Pipe {
+			bail("--pipe can only be used when extracting from stdin")
+		}
+		inputF, err := openFile(ctx, cli.Extract.Input)
+		if err != nil {
+			bail("failed to open input file: %s", err)
+		}
+		input = throttleInput(stats.input(inputF))
+		inputSize = fileSize(inputF)
+		remote, _ = inputF.(*httpInput)
+		_, stream = inputF.(*streamInput)
+
+		header = make([]byte, gpgSniffSize)
+		n, _ := inputF.ReadAt(header, 0)
+		header = header[:n]
+	}
+
+	if cli.Extract.Verify != "" {
+		verifyInput(input, inputSize)
+	}
+
+	// Inputs encrypted with age are decrypted in-process, and those encrypted
+	// with OpenPGP by gpg, and both are identified by their plaintext.
+	trimmedName, encrypted := strings.CutSuffix(inputName, ageExtension)
+	if encrypted = encrypted || isAge(header); encrypted {
+		if len(cli.Extract.Identity) == 0 {
+			input.Close()
+			bail("--identity must be given to decrypt age-encrypted inputs")
+		}
+		identities, err := readAgeIdentities(cli.Extract.Identity)
+		if err != nil {
+			input.Close()
+			bail("failed to read identities: %s", err)
+		}
+
+		decrypted, err := decryptAge(input, identities)
+		if err != nil {
+			input.Close()
+			bail("failed to decrypt input: %s", err)
+		}
+		input, inputName, inputSize = decrypted, trimmedName, -1
+	} else if trimmedName, encrypted = trimGPGExtension(inputName); encrypted || isOpenPGP(header) {
+		encrypted = true
+		if cli.Extract.Sandbox {
+			bail("--sandbox can't be used with encrypted inputs, since gpg must be run to decrypt them")
+		}
+
+		decrypted, err := decryptGPG(input)
+		if err != nil {
+			input.Close()
+			bail("failed to decrypt input: %s", err)
+		}
+		input, inputName, inputSize = decrypted, trimmedName, -1
+	}
+	defer func() {
+		if err := input.Close(); err != nil {
+			bail("failed to close input file: %s", err)
+		}
+	}()
+
+	var format archives.Format
+	var inputR io.Reader = input
+	var err error
+	if cli.Extract.Format != "" {
+		format, err = squish.LookupFormat(cli.Extract.Format)
+	} else if cli.Extract.Dictionary != "" {
+		// Inputs compressed with a dictionary can't be identified by their
+		// contents without it.
+		format, _, err = archives.Identify(ctx, inputName, nil)
+	} else {
+		format, inputR, err = identify(ctx, inputName, input)
+	}
+	if err != nil {
+		bail("failed to identify format: %s", err)
+	}
+	// Zip variants given by --format only differ from zips when they're
+	// written.
+	if variant, ok := format.(squish.ZipVariant); ok {
+		format = variant.Zip
+	}
+	format = withCharset(format)
+	logger.Debug("identified format", "format", format.Extension())
+	if cli.Extract.Sandbox && squish.IsExternal(format) {
+		bail("--sandbox can't be used with formats defined in the config, since their programs must be run to extract them")
+	}
+	format = withMemoryLimit(format, int64(cli.MaxMemory))
+	if cli.Extract.Dictionary != "" {
+		var ok bool
+		if format, ok = withZstdDictionary(format, readDictionary(cli.Extract.Dictionary)); !ok {
+			bail("--dictionary can only be used to extract zstd input")
+		}
+	}
+
+	if cli.Extract.Input == stdioPath && requiresRandomAccess(format) {
+		bail("identified format requires random access, so it can't be extracted from stdin")
+	}
+	if stream && requiresRandomAccess(format) {
+		bail("identified format requires random access, so it can't be extracted from a tape drive or pipe")
+	}
+	if encrypted && requiresRandomAccess(format) {
+		bail("identified format requires random access, so it can't be extracted from an encrypted input")
+	}
+
+	// Entries that can't be extracted are reported before extracting
+	// anything when the archive can be read twice, which is cheap for
+	// formats with random access, and otherwise only worth it for --strict.
+	// With --sandbox, that's left to the sandboxed process.
+	capabilities := &capabilityReport{}
+	seeker, seekable := inputR.(io.Seeker)
+	if extractor, ok := format.(archives.Extractor); ok && seekable && (!cli.Extract.Sandbox || inSandbox()) && (requiresRandomAccess(format) || cli.Extract.Strict && !stream && !encrypted) {
+		// 7z archives with encrypted headers can't even be listed without
+		// the password.
+		if decrypting, err := squish.WithPassword(format, cli.Extract.Password.get(false)); err == nil {
+			extractor = decrypting.(archives.Extractor)
+		}
+		if cli.Extract.IgnoreZeros {
+			extractor = withIgnoreZeros(extractor)
+		}
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			err = scanCapabilities(ctx, extractor, inputR, cli.Extract.Type, cli.Extract.Patterns, capabilities)
+		}
+		if err == nil {
+			_, err = seeker.Seek(start, io.SeekStart)
+		}
+		if err != nil {
+			bail("failed to read archive: %s", err)
+		}
+		capabilities.warn()
+		if n := capabilities.len(); n > 0 && cli.Extract.Strict {
+			bail("%d entries can't be extracted, so nothing was extracted, since --strict was given", n)
+		}
+	}
+
+	_, extracting := format.(archives.Extractor)
+
+	var output string
+	if cli.Extract.Output != nil {
+		output = *cli.Extract.Output
+	} else if inputName == "" && !extracting {
+		output = stdioPath
+	} else if output = squish.OutputName(inputName, format, extracting); output == "" {
+		bail("failed to determine output path from input path and format, please specify it manually")
+	}
+
+	if extracting && output == stdioPath {
+		bail("archive entries can't be extracted to stdout")
+	}
+	if output == stdioPath && events.usesStdout() {
+		bail("--porcelain-fd must be changed from stdout when writing output to stdout")
+	}
+
+	// With --resume, an interrupted extraction of an uncompressed tar from a
+	// remote input is continued from the first entry that it didn't finish,
+	// with a range request, rather than by downloading the input again.
+	var resume *resumeState
+	var resumedAt int64
+	var continued bool
+	_, plainTar := format.(archives.Tar)
+	continuable := remote != nil && plainTar && !encrypted && !cli.Extract.IgnoreZeros && !cli.Extract.Xattrs && !cli.Extract.ACLs && !cli.Extract.Capabilities
+	if cli.Extract.Resume && extracting {
+		var source resumeRecord
+		if remote != nil {
+			source = resumeRecord{URL: cli.Extract.Input, ETag: remote.etag}
+		}
+		if resume, err = readResumeState(output, source); err != nil {
+			bail("failed to read --resume state: %s", err)
+		}
+		if continuable {
+			if resumedAt, continued = resume.continueRemote(remote); continued {
+				logger.Debug("continuing remote input", "offset", resumedAt)
+				inputR = input
+			}
+		}
+	}
+
+	progress := newProgress(cli.Extract.Summary)
+	defer progress.clear()
+	progress.extractFrom(inputSize)
+
+	if !requiresRandomAccess(format) {
+		if cli.Extract.Prefetch > 0 {
+			size := cli.Extract.Prefetch * prefetchChunkSize
+			if cli.MaxMemory > 0 {
+				// Leave half of the memory for decompression.
+				size = int(min(int64(size), int64(cli.MaxMemory)/2))
+			}
+			prefetcher := newPrefetchReader(inputR, size)
+			defer prefetcher.Close()
+			inputR = prefetcher
+		}
+
+		inputR = progress.input(inputR, inputSize)
+	}
+
+	if cli.Extract.MaxEntries < 0 || cli.Extract.MaxRatio < 0 {
+		bail("--max-entries and --max-ratio can't be negative")
+	}
+	// When the size of the input isn't known, --max-ratio compares the output
+	// to what's been read of it so far.
+	ratioBase := func() int64 { return inputSize }
+	if inputSize < 0 && cli.Extract.MaxRatio > 0 {
+		counter := &countingReader{Reader: inputR}
+		inputR, ratioBase = counter, counter.n.Load
+	}
+	limits := newExtractLimits(int64(cli.Extract.MaxOutputSize), cli.Extract.MaxEntries, cli.Extract.MaxRatio, ratioBase)
+
+	dirMode := cli.Extract.DirMode.apply(fs.ModeDir | 0o755)
+
+	if cli.Extract.Overwrite == "prompt" && cli.Extract.Input == stdioPath {
+		bail("--overwrite=prompt can't be used when the input is stdin")
+	}
+	if cli.Extract.AbsoluteNames && (cli.Extract.Sandbox || cli.Extract.RestrictTo != "") {
+		bail("--absolute-names can't be used with --sandbox or --restrict-to, which keep entries within the output")
+	}
+	if cli.Extract.DryRun && (cli.Extract.Sandbox || cli.Extract.Resume) {
+		bail("--dry-run can't be used with --sandbox or --resume")
+	}
+	if cli.Extract.ReflinkFrom != "" {
+		cli.Extract.Reflink = true
+	}
+	if cli.Extract.Reflink && (cli.Extract.Sandbox || cli.Extract.Resume) {
+		bail("--reflink can't be used with --sandbox or --resume")
+	}
+
+	// In the sandbox, the output was already created or checked before
+	// re-executing, and its parent can no longer be modified.
+	var replacing bool
+	if extracting && cli.Extract.DryRun {
+		// Nothing is created, but an output that exists must be usable.
+		if info, err := os.Stat(output); err == nil && !info.IsDir() {
+			bail("output %s already exists and isn't a directory", output)
+		}
+	} else if extracting && !inSandbox() {
+		// Entries are extracted into an existing output directory, leaving
+		// anything else in it alone. It may be nested in directories that
+		// don't exist yet.
+		if err := os.MkdirAll(filepath.Dir(output), dirMode); err != nil {
+			bail("failed to create output directory: %s", err)
+		}
+		if err := os.Mkdir(output, dirMode); errors.Is(err, fs.ErrExist) {
+			if info, err := os.Stat(output); err == nil && !info.IsDir() {
+				bail("output %s already exists and isn't a directory", output)
+			}
+		} else if err != nil {
+			bail("failed to create output directory: %s", err)
+		}
+	} else if output != stdioPath && !inSandbox() {
+		if cli.Extract.Overwrite == "newer" {
+			bail("--overwrite=newer can only be used when extracting archives")
+		}
+		if !cli.Extract.DryRun {
+			if err := os.MkdirAll(filepath.Dir(output), dirMode); err != nil {
+				bail("failed to create output directory: %s", err)
+			}
+		}
+		if existing, err := os.Lstat(output); err == nil {
+			if existing.IsDir() {
+				bail("output %s already exists and is a directory", output)
+			}
+			if cli.Extract.Overwrite == "never" {
+				bail("output %s already exists, use --overwrite to replace it", output)
+			}
+			if cli.Extract.DryRun {
+				replacing = true
+			} else if !replaceExisting(cli.Extract.Overwrite, output, time.Time{}, existing, progress) {
+				return
+			}
+		}
+	}
+
+	// The password is asked for before sandboxing, which passes it on to the
+	// re-executed process.
+	var password string
+	if extracting {
+		password = cli.Extract.Password.get(false)
+	}
+
+	if cli.Extract.Sandbox {
+		if cli.Extract.Input == stdioPath || output == stdioPath {
+			bail("--sandbox can't be used with stdin or stdout")
+		}
+		sandbox(output)
+	}
+
+	switch format := format.(type) {
+	case archives.Extractor:
+		var root extractRoot = pathRoot(extendedLengthPath(output))
+		if cli.Extract.RestrictTo != "" {
+			sub, err := relativeTo(cli.Extract.RestrictTo, output)
+			if err != nil || !filepath.IsLocal(sub) {
+				bail("output must be within the --restrict-to directory")
+			}
+
+			// The output may not exist yet when nothing is written, and
+			// it's only read from.
+			if !cli.Extract.DryRun {
+				restricted, err := openRestrictedRoot(cli.Extract.RestrictTo, sub)
+				if err != nil {
+					bail("failed to open restricted root: %s", err)
+				}
+				defer restricted.Close()
+				root = restricted
+			}
+		}
+
+		if cli.Extract.AbsoluteNames {
+			root = absoluteRoot{root}
+		}
+
+		var workers *workerPool
+		if cli.Extract.Threads > 1 && supportsConcurrentExtraction(format) {
+			workers = newWorkerPool(cli.Extract.Threads)
+		}
+
+		if (cli.Extract.Xattrs || cli.Extract.ACLs || cli.Extract.Capabilities) && !xattrsSupported {
+			bail("--xattrs, --acls and --capabilities are only supported on Linux")
+		}
+		if cli.Extract.SpecialFiles && !specialFilesSupported {
+			bail("--special-files is only supported on Linux")
+		}
+		if !cli.Extract.SameOwner && (len(cli.Extract.OwnerMap) > 0 || len(cli.Extract.GroupMap) > 0) {
+			bail("--owner-map and --group-map can only be used with --same-owner")
+		}
+
+		if cli.Extract.RenameMap != "" && !cli.Extract.SanitizeNames {
+			bail("--rename-map can only be used with --sanitize-names")
+		}
+		if cli.Extract.StripComponents < 0 {
+			bail("invalid number of components to strip: %d", cli.Extract.StripComponents)
+		}
+
+		flatten := shouldFlatten(output)
+
+		var checksums *checksumManifest
+		if manifest := cmp.Or(cli.Extract.Checksums, findChecksums(cli.Extract.Input)); manifest != "" {
+			var err error
+			if checksums, err = readChecksums(manifest); err != nil {
+				bail("failed to read checksums: %s", err)
+			}
+		}
+
+		if resume != nil {
+			if err := resume.open(); err != nil {
+				bail("failed to open --resume state: %s", err)
+			}
+			defer resume.close()
+		}
+
+		var reflinks *reflinkIndex
+		if cli.Extract.Reflink && !cli.Extract.DryRun {
+			var err error
+			if reflinks, err = newReflinkIndex(root, cli.Extract.ReflinkFrom); err != nil {
+				bail("failed to index --reflink-from directory: %s", err)
+			}
+		}
+
+		extractor := &entryExtractor{
+			root:            root,
+			types:           cli.Extract.Type,
+			outputPath:      output,
+			keepGoing:       cli.Extract.KeepGoing,
+			stripMacosx:     cli.Extract.StripMacosx,
+			sanitizeNames:   cli.Extract.SanitizeNames,
+			absoluteNames:   cli.Extract.AbsoluteNames,
+			patterns:        cli.Extract.Patterns,
+			includeTypes:    cli.Extract.IncludeType,
+			excludeTypes:    cli.Extract.ExcludeType,
+			patternMatched:  make([]bool, len(cli.Extract.Patterns)),
+			stripComponents: cli.Extract.StripComponents,
+			ifChanged:       cli.Extract.IfChanged,
+			transforms:      cli.Extract.Transform,
+			overwrite:       cli.Extract.Overwrite,
+			workers:         workers,
+			progress:        progress,
+			mode:            cli.Extract.Mode,
+			umask:           processUmask(),
+			samePermissions: cli.Extract.SamePermissions,
+			stripSetuid:     cli.Extract.StripSetuid,
+			dirMode:         dirMode,
+			times:           !cli.Extract.NoTimes,
+			sameOwner:       cli.Extract.SameOwner,
+			ownerMap:        idMap(cli.Extract.OwnerMap),
+			groupMap:        idMap(cli.Extract.GroupMap),
+			xattrs:          cli.Extract.Xattrs,
+			acls:            cli.Extract.ACLs,
+			capabilities:    cli.Extract.Capabilities,
+			specialFiles:    cli.Extract.SpecialFiles,
+			restoreExec:     cli.Extract.RestoreExec == "auto",
+			unsupported:     capabilities,
+			strict:          cli.Extract.Strict,
+			space:           newSpaceReserve(output, int64(cli.Extract.ReserveSpace)),
+			whenFull:        cli.Extract.WhenFull,
+			dryRun:          cli.Extract.DryRun,
+			reflinks:        reflinks,
+			resume:          resume,
+			limits:          limits,
+			checksums:       checksums,
+		}
+		if resume != nil {
+			extractor.restoreResumed(continued)
+		}
+		if cli.Extract.IgnoreZeros {
+			format = withIgnoreZeros(format)
+		}
+		// Selected entries are sought out with the index written by
+		// create --index, if there's one.
+		if inputF, ok := input.(inputFile); ok && len(cli.Extract.Patterns) > 0 && !cli.Extract.IgnoreZeros && !stream && !encrypted {
+			format = withSeekableEntries(inputName, inputF, format, func(entry indexEntry) bool {
+				return selectsName(archives.FileInfo{FileInfo: indexFileInfo{entry}, NameInArchive: entry.Name}, cli.Extract.Type, cli.Extract.Patterns)
+			})
+		}
+		// Zips are decrypted even without a password, so that their
+		// encrypted entries fail with squish.ErrPasswordRequired.
+		if archive, ok := format.(archives.Format); ok {
+			if decrypting, err := squish.WithPassword(archive, password); err == nil {
+				format = decrypting.(archives.Extractor)
+			}
+		}
+		handle := extractor.extract
+		if cli.Extract.KeepGoing {
+			inner := handle
+			handle = func(ctx context.Context, info archives.FileInfo) error {
+				return extractor.tolerate(inner(ctx, info))
+			}
+		}
+		if resume != nil && continuable {
+			position := newTarPosition(inputR, resumedAt)
+			inputR, resume.position = position, position
+			inner := handle
+			handle = func(ctx context.Context, info archives.FileInfo) error {
+				position.advance(info)
+				return inner(ctx, info)
+			}
+		}
+		err := format.Extract(ctx, inputR, handle)
+		if workers != nil {
+			// Wait even if extraction failed, so that no files are still
+			// being written when we exit.
+			if waitErr := workers.wait(); err == nil {
+				err = waitErr
+			}
+		}
+		if err == nil {
+			err = extractor.finish()
+		}
+		// The renamed entries are written even if extraction failed, so
+		// that those that were extracted can be found.
+		if cli.Extract.RenameMap != "" {
+			if err := writeRenameMap(cli.Extract.RenameMap, extractor.renames); err != nil {
+				warn("failed to write --rename-map: %s", err)
+			}
+		}
+		var reserveErr *reserveError
+		if errors.As(err, &reserveErr) && cli.Extract.WhenFull == "rollback" {
+			// The removed entries can't be resumed.
+			resume.finish()
+			bail("failed to extract archive: %s, so the %d extracted entries were removed", err, extractor.rollback())
+		} else if errors.Is(err, context.Canceled) && cli.Extract.Resume {
+			bail("extraction was interrupted, leaving %s incomplete, run the same command again to resume it: %s", output, err)
+		} else if errors.Is(err, context.Canceled) {
+			bail("extraction was interrupted, leaving %s incomplete: %s", output, err)
+		} else if err != nil {
+			bail("failed to extract archive: %s", err)
+		}
+		// With --keep-going, the --resume state is kept if any entries
+		// failed, so that extraction can be resumed to retry them.
+		if len(extractor.failures) == 0 {
+			if err := resume.finish(); err != nil {
+				warn("failed to remove --resume state: %s", err)
+			}
+		}
+		if flatten && len(extractor.failures) == 0 {
+			if flattened, err := flattenOutput(output, !cli.Extract.NoTimes); err != nil {
+				bail("failed to flatten output: %s", err)
+			} else if flattened {
+				logger.Debug("moved the contents of the archive's only directory into the output", "output", output)
+			}
+		}
+		if !capabilities.scanned {
+			capabilities.warn()
+		}
+		for i, matched := range extractor.patternMatched {
+			if !matched {
+				warn("no entries matched %s", extractor.patterns[i])
+			}
+		}
+		// Only the files that are extracted are checked, so those that are
+		// missing can only be reported when every file is extracted.
+		if checksums != nil && !cli.Extract.DryRun && len(extractor.failures) == 0 && len(cli.Extract.Patterns) == 0 && len(cli.Extract.Type) == 0 && len(cli.Extract.IncludeType) == 0 && len(cli.Extract.ExcludeType) == 0 && !cli.Extract.StripMacosx {
+			for _, name := range checksums.notFound() {
+				warn("%s is in the checksums but not in the archive", name)
+			}
+		}
+		if len(extractor.failures) > 0 {
+			bail("failed to extract %d entries:\n%s", len(extractor.failures), &partialError{errors.Join(extractor.failures...)})
+		}
+
+	case archives.Decompressor:
+		if cli.Extract.RestrictTo != "" {
+			bail("--restrict-to can only be used when extracting archives")
+		}
+		if cli.Extract.StripComponents != 0 {
+			bail("--strip-components can only be used when extracting archives")
+		}
+		if cli.Extract.IfChanged {
+			bail("--if-changed can only be used when extracting archives")
+		}
+		if len(cli.Extract.Patterns) > 0 {
+			bail("patterns can only be given when extracting archives")
+		}
+		if len(cli.Extract.Transform) > 0 {
+			bail("--transform can only be used when extracting archives")
+		}
+		if cli.Extract.WhenFull == "skip" {
+			bail("--when-full=skip can only be used when extracting archives")
+		}
+		if cli.Extract.Checksums != "" {
+			bail("--checksums can only be used when extracting archives")
+		}
+		if cli.Extract.Resume {
+			bail("--resume can only be used when extracting archives")
+		}
+		if cli.Extract.KeepGoing {
+			bail("--keep-going can only be used when extracting archives")
+		}
+		if cli.Extract.Password.given() {
+			bail("--password can only be used when extracting archives")
+		}
+		if cli.Extract.ReserveSpace > 0 && output == stdioPath {
+			bail("--reserve-space can't be used when writing to stdout")
+		}
+		if cli.Extract.DryRun {
+			switch {
+			case output == stdioPath:
+				logMessage(slog.LevelInfo, nil, "would decompress to stdout")
+			case replacing && cli.Extract.Overwrite == "prompt":
+				logMessage(slog.LevelInfo, nil, "would ask whether to replace %s", output)
+			case replacing:
+				logMessage(slog.LevelInfo, nil, "would replace %s", output)
+			default:
+				logMessage(slog.LevelInfo, nil, "would create %s", output)
+			}
+			return
+		}
+
+		progress.start(output, -1)
+
+		var outputW io.WriteCloser = os.Stdout
+		if output != stdioPath {
+			outputF, err := os.Create(output)
+			if err != nil {
+				bail("failed to create output file: %s", err)
+			}
+			defer func() {
+				if err := outputF.Close(); err != nil {
+					bail("failed to close output file: %s", err)
+				}
+			}()
+			outputW = outputF
+		}
+
+		var dst io.Writer = withRetries(ctx, stats.output(outputW))
+		if space := newSpaceReserve(filepath.Dir(output), int64(cli.Extract.ReserveSpace)); space != nil {
+			dst = reserveWriter{dst, space, output}
+		}
+		if limits != nil {
+			dst = limitWriter{dst, limits}
+		}
+
+		written, err := squish.Decompress(ctx, format, progress.writer(dst), inputR)
+		var reserveErr *reserveError
+		if errors.As(err, &reserveErr) && cli.Extract.WhenFull == "rollback" || errors.Is(err, context.Canceled) && output != stdioPath {
+			if err := os.Remove(output); err != nil {
+				warn("failed to remove %s: %s", output, err)
+			}
+			bail("failed to copy input to output file: %s, so it was removed", err)
+		} else if err != nil {
+			bail("failed to copy input to output file: %s", err)
+		}
+		progress.finish(output, written)
+
+	default:
+		bail("identified format doesn't support extraction or decompression")
+	}
+
+	if pipe != nil {
+		if err := pipe.finish(); err != nil {
+			bail("failed to read input: %s", err)
+		}
+	}
+}
+
+// entryExtractor writes archive entries beneath root.
+type entryExtractor struct {
+	root extractRoot
+	// types are the --type letters of the entries to extract, or empty to
+	// extract every entry.
+	types []string
+	// stripMacosx skips metadata entries added by macOS.
+	stripMacosx bool
+	// sanitizeNames is whether entries' names are renamed so that they're
+	// valid on Windows, and renames holds each entry's name in the archive
+	// and the name it was renamed to, in order, for --rename-map.
+	sanitizeNames bool
+	renames       [][2]string
+	// absoluteNames is whether entries whose names are absolute paths are
+	// extracted to those paths, through an absoluteRoot, and symbolic links
+	// may point anywhere.
+	absoluteNames bool
+	// patterns, if non-empty, are the patterns of the entries to extract,
+	// and patternMatched records which of them matched any entry.
+	patterns       []glob
+	patternMatched []bool
+	// includeTypes and excludeTypes select the regular files to extract by
+	// the types sniffed from their contents.
+	includeTypes, excludeTypes []typePattern
+	// ifChanged is whether regular files that already exist are compared
+	// with entries, to leave them as they are if they're the same.
+	ifChanged bool
+	// stripComponents is the number of leading elements removed from the
+	// name of each entry. Entries with no more elements than that are
+	// skipped.
+	stripComponents int
+	// transforms rename each entry, after its components are stripped.
+	// Entries whose names become empty are skipped.
+	transforms []transform
+	// overwrite is the --overwrite policy for files that already exist where
+	// entries would be extracted.
+	overwrite string
+	// workers, if non-nil, extracts regular files concurrently, in which case
+	// the caller must wait for it once extraction is finished.
+	workers  *workerPool
+	progress *progress
+	// mode, if non-nil, changes the permissions of every entry.
+	mode *modeChange
+	// umask is removed from the permissions of entries, unless
+	// samePermissions is set, in which case the permissions it would
+	// remove as entries are created are restored.
+	umask           fs.FileMode
+	samePermissions bool
+	// stripSetuid is whether the setuid and setgid bits of files are
+	// removed.
+	stripSetuid bool
+	// dirMode is the mode of the parent directories that have to be created
+	// for entries whose parents aren't in the archive.
+	dirMode fs.FileMode
+	// times is whether the access and modification times of entries are
+	// restored. Those of directories are only restored by finish, since
+	// extracting their contents changes them.
+	times bool
+	// sameOwner is whether entries are given the numeric owner and group
+	// stored in the archive, after mapping them with ownerMap and groupMap.
+	sameOwner          bool
+	ownerMap, groupMap map[int]int
+	// xattrs, acls and capabilities are whether the extended attributes,
+	// POSIX ACLs and file capabilities stored in the archive are restored.
+	// Those of directories are only restored by finish, so that their
+	// default ACLs aren't inherited by their contents.
+	xattrs, acls, capabilities bool
+	// specialFiles is whether named pipes and devices are created, rather
+	// than being skipped with a warning.
+	specialFiles bool
+	// unsupported collects the entries that can't be extracted, unless
+	// they were already collected before extracting, and with strict,
+	// extraction fails at them instead.
+	unsupported *capabilityReport
+	strict      bool
+	// restoreExec is whether regular files whose modes aren't stored in the
+	// archive are made executable if they start with a shebang or are
+	// binaries.
+	restoreExec bool
+	// dirs are the extracted directories, named by their paths in the output,
+	// whose times and extended attributes are restored by finish.
+	dirs []archives.FileInfo
+	// space claims space for regular files before they're written, and
+	// whenFull is the --when-full policy for those that don't fit.
+	space    *spaceReserve
+	whenFull string
+	// outputPath is the path of the output directory, which the paths of
+	// entries in entry_result events are joined to.
+	outputPath string
+	// keepGoing is whether entries that fail to be extracted are recorded in
+	// failures by tolerate, rather than stopping extraction.
+	keepGoing  bool
+	failuresMu sync.Mutex
+	failures   []error
+	// dryRun is whether entries are only printed by plan, rather than
+	// extracted.
+	dryRun bool
+	// reflinks, if non-nil, finds files with the same contents as regular
+	// files, to make them reflinks of those.
+	reflinks *reflinkIndex
+	// resume, if non-nil, records the progress of extraction, and holds that
+	// of the interrupted extraction being resumed.
+	resume *resumeState
+	// limits, if non-nil, fails extraction once too much is extracted.
+	limits *extractLimits
+	// linkTargets are the paths in the output of the regular files in tar
+	// archives that have been written, by their cleaned names in the
+	// archive, which hard links are resolved with, and hardLinks are the hard
+	// links that finish creates, once the files they link to are written.
+	linkMu      sync.Mutex
+	linkTargets map[string]string
+	hardLinks   []hardLink
+	// checksums, if non-nil, holds the digests that the contents of regular
+	// files are checked against as they're written.
+	checksums *checksumManifest
+	// created are the entries created by the extraction, in the order they
+	// were created, which are only recorded if they may be rolled back.
+	createdMu sync.Mutex
+	created   []createdEntry
+	// symlinks are the symbolic links created by the extraction, by their
+	// paths in the output, which no other entry may be written through.
+	symlinks safepath.Links
+	// implicitDirs are the parent directories that the extraction created
+	// for entries that came before them, or that aren't in the archive, by
+	// their paths in the output.
+	implicitMu   sync.Mutex
+	implicitDirs map[string]bool
+}
+
+type createdEntry struct {
+	name string
+	dir  bool
+}
+
+// extract is an archives.FileHandler that writes info beneath e.root.
+func (e *entryExtractor) extract(ctx context.Context, info archives.FileInfo) error {
+	if !typeMatches(e.types, info) {
+		return nil
+	}
+
+	// Names are sanitized before they're checked, since Windows doesn't
+	// consider names like CON local.
+	cleanedName := filepath.Clean(info.NameInArchive)
+	var sanitized bool
+	if e.sanitizeNames {
+		cleanedName, sanitized = sanitizeName(cleanedName)
+	}
+	if !filepath.IsLocal(cleanedName) && !(e.absoluteNames && filepath.IsAbs(cleanedName)) {
+		return withEntry(info.NameInArchive, &unsafePathError{info.NameInArchive})
+	}
+	// Leading ./ and trailing / are common enough to not be worth reporting.
+	if name := strings.TrimPrefix(strings.TrimSuffix(info.NameInArchive, "/"), "./"); cleanedName != filepath.FromSlash(name) {
+		warn("input entry %s was extracted to %s", info.NameInArchive, cleanedName)
+	}
+	if e.stripMacosx && isMacosxMetadata(filepath.ToSlash(cleanedName)) {
+		return nil
+	}
+	if len(e.patterns) > 0 && !e.matchesPattern(filepath.ToSlash(cleanedName)) {
+		return nil
+	}
+	if e.stripComponents > 0 {
+		// Absolute names, with --absolute-names, become relative.
+		elems := strings.Split(strings.TrimLeft(cleanedName, string(filepath.Separator)), string(filepath.Separator))
+		if len(elems) <= e.stripComponents {
+			return nil
+		}
+		cleanedName = filepath.Join(elems[e.stripComponents:]...)
+	}
+	if len(e.transforms) > 0 {
+		name := transformName(e.transforms, filepath.ToSlash(cleanedName))
+		if name == "" {
+			return nil
+		}
+		// The rules could have introduced .. elements or a leading /.
+		cleanedName = filepath.Clean(filepath.FromSlash(name))
+		if e.sanitizeNames {
+			var renamed bool
+			cleanedName, renamed = sanitizeName(cleanedName)
+			sanitized = sanitized || renamed
+		}
+		if !filepath.IsLocal(cleanedName) && !(e.absoluteNames && filepath.IsAbs(cleanedName)) {
+			return withEntry(info.NameInArchive, &unsafePathError{name})
+		}
+	}
+
+	if sanitized {
+		e.renames = append(e.renames, [2]string{info.NameInArchive, cleanedName})
+	}
+
+	if feature, ok := entryUnsupported(info); ok {
+		return e.skipUnsupported(info, feature)
+	}
+
+	if len(e.includeTypes) > 0 || len(e.excludeTypes) > 0 {
+		var selected bool
+		var err error
+		if info, selected, err = sniffEntry(info, e.includeTypes, e.excludeTypes); err != nil {
+			return withEntry(info.NameInArchive, fmt.Errorf("failed to detect content type: %w", err))
+		} else if !selected {
+			return nil
+		}
+	}
+
+	if e.resume != nil {
+		if cleanedName == resumeStateName {
+			warn("skipped %s, which is where --resume records its progress", info.NameInArchive)
+			return nil
+		}
+		if e.resume.completed(cleanedName) && info.Mode().IsRegular() {
+			return nil
+		}
+	}
+
+	if isSpecialFile(info.Mode()) && !e.specialFiles {
+		warn("skipped special file %s, which is only extracted with --special-files", info.NameInArchive)
+		return nil
+	}
+
+	if link, ok, err := e.symlinks.Through(cleanedName, e.root.Lstat); err != nil {
+		return withEntry(info.NameInArchive, fmt.Errorf("failed to check parent directories: %w", err))
+	} else if ok {
+		return withEntry(info.NameInArchive, &unsafeLinkError{name: cleanedName, link: link})
+	}
+
+	existing, err := e.root.Lstat(cleanedName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return withEntry(info.NameInArchive, fmt.Errorf("failed to check for existing output: %w", err))
+	}
+	if e.dryRun {
+		return e.plan(info, cleanedName, existing)
+	}
+	report := e.newReport(info.NameInArchive, cleanedName)
+	// Entries that an interrupted extraction started are replaced, or
+	// continued from where it got to, regardless of the policy.
+	var offset int64
+	if started, ok := e.resume.started(cleanedName); ok && existing != nil && !existing.IsDir() {
+		if info.Mode().IsRegular() && existing.Mode().IsRegular() && existing.Size() >= started && started > 0 {
+			offset = started
+			report.setAction("resumed")
+		} else if err := e.root.Remove(cleanedName); err != nil {
+			return withEntry(info.NameInArchive, fmt.Errorf("failed to remove partially extracted output: %w", err))
+		}
+		existing = nil
+	}
+	// With --if-changed, regular files that already exist with the same
+	// contents are left as they are, keeping their times, and those that
+	// differ are rewritten in place from the first byte that does, if the
+	// policy allows them to be replaced.
+	if e.ifChanged && offset == 0 && existing != nil && existing.Mode().IsRegular() && info.Mode().IsRegular() && existing.Size() == info.Size() {
+		var matched int64
+		if info, matched, err = e.compareExisting(info, cleanedName); err != nil {
+			return withEntry(info.NameInArchive, fmt.Errorf("failed to compare with existing output: %w", err))
+		}
+		if matched == info.Size() {
+			e.addLinkTarget(info, cleanedName)
+			if err := e.resume.record(resumeRecord{Name: cleanedName, Done: true, Entry: linkableEntry(info)}); err != nil {
+				return withEntry(info.NameInArchive, fmt.Errorf("failed to record progress: %w", err))
+			}
+			if report != nil {
+				report.bytes = matched
+			}
+			report.setAction("unchanged")
+			report.emit()
+			return nil
+		}
+		if !replaceExisting(e.overwrite, cleanedName, info.ModTime(), existing, e.progress) {
+			if f, err := info.Open(); err == nil {
+				f.Close()
+			}
+			report.setAction("skipped")
+			report.emit()
+			return nil
+		}
+		if matched == 0 {
+			if err := e.root.Remove(cleanedName); err != nil {
+				return withEntry(info.NameInArchive, fmt.Errorf("failed to remove existing output: %w", err))
+			}
+			report.setAction("replaced")
+		} else if perm := e.entryMode(info.Mode()).Perm(); existing.Mode().Perm() != perm {
+			// Files that are rewritten in place keep their modes
+			// otherwise.
+			if err := e.chmod(cleanedName, perm); err != nil {
+				return withEntry(info.NameInArchive, fmt.Errorf("failed to set output file mode: %w", err))
+			}
+		}
+		if matched > 0 {
+			report.setAction("rewritten")
+		}
+		offset, existing = matched, nil
+	}
+	// Directories are merged with existing ones, while anything else is
+	// replaced only if the policy allows it.
+	merge := existing != nil && existing.IsDir() && info.IsDir()
+	if merge {
+		report.setAction("merged")
+	} else if existing != nil {
+		if !replaceExisting(e.overwrite, cleanedName, info.ModTime(), existing, e.progress) {
+			report.setAction("skipped")
+			report.emit()
+			return nil
+		}
+		if existing.IsDir() {
+			return withEntry(info.NameInArchive, fmt.Errorf("failed to replace %s: %w", cleanedName, syscall.EISDIR))
+		}
+		if err := e.root.Remove(cleanedName); err != nil {
+			return withEntry(info.NameInArchive, fmt.Errorf("failed to remove existing output: %w", err))
+		}
+		e.symlinks.Remove(cleanedName)
+		report.setAction("replaced")
+	}
+
+	if err := e.limits.entry(); err != nil {
+		return withEntry(info.NameInArchive, err)
+	}
+
+	printEntry(os.Stdout, e.progress, info.NameInArchive, info)
+
+	if info.IsDir() {
+		// The parent may have been skipped, or not be in the archive at all.
+		if err := e.mkdirParents(cleanedName); err != nil {
+			return withEntry(info.NameInArchive, fmt.Errorf("failed to create parent directory: %w", err))
+		}
+		if !merge {
+			mode := e.entryMode(info.Mode())
+			if err := e.root.Mkdir(cleanedName, mode); err != nil {
+				return withEntry(info.NameInArchive, fmt.Errorf("failed to create output directory: %w", err))
+			}
+			e.recordCreated(cleanedName, true)
+			if e.samePermissions && mode.Perm()&e.umask != 0 {
+				if err := e.chmod(cleanedName, mode&chmodBits); err != nil {
+					return withEntry(info.NameInArchive, fmt.Errorf("failed to set output directory mode: %w", err))
+				}
+			}
+		} else if e.takeImplicitDir(cleanedName) {
+			// Directories that were created for entries before them are
+			// given their own modes once they're reached, as in zips
+			// that list directories after their contents.
+			if err := e.chmod(cleanedName, e.entryMode(info.Mode()).Perm()); err != nil {
+				return withEntry(info.NameInArchive, fmt.Errorf("failed to set output directory mode: %w", err))
+			}
+			report.setAction("created")
+		}
+
+		if uid, gid, ok := entryOwner(info, e.ownerMap, e.groupMap); ok && e.sameOwner {
+			if err := e.root.Lchown(cleanedName, uid, gid); err != nil {
+				report.warn("failed to change owner of %s: %s", cleanedName, err)
+			}
+		}
+		if e.times || e.xattrs || e.acls || e.capabilities {
+			info.NameInArchive = cleanedName
+			e.dirs = append(e.dirs, info)
+		}
+		if e.resume != nil {
+			// Continuing a remote input skips the directories before
+			// where it's continued from, so their times are recorded.
+			atime, mtime := entryTimes(info)
+			record := resumeRecord{Name: cleanedName, Done: true, Input: e.resume.entryStart(), ModTime: &mtime}
+			if !atime.IsZero() {
+				record.AccessTime = &atime
+			}
+			if err := e.resume.record(record); err != nil {
+				return withEntry(info.NameInArchive, fmt.Errorf("failed to record progress: %w", err))
+			}
+		}
+		report.emit()
+		return nil
+	}
+
+	if offset == 0 {
+		if err := e.resume.record(resumeRecord{Name: cleanedName, Input: e.resume.entryStart()}); err != nil {
+			return withEntry(info.NameInArchive, fmt.Errorf("failed to record progress: %w", err))
+		}
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		err := e.extractSymlink(info, cleanedName, report)
+		if err == nil {
+			err = e.resume.record(resumeRecord{Name: cleanedName, Done: true})
+		}
+		return withEntry(info.NameInArchive, report.done(err))
+	}
+	if target, ok := hardLinkTarget(info); ok {
+		// The file that's linked to may still be being written.
+		e.hardLinks = append(e.hardLinks, hardLink{info, cleanedName, target, report})
+		return nil
+	}
+
+	if mode := e.entryMode(info.Mode()); mode != info.Mode() {
+		info.FileInfo = fixedMode{info.FileInfo, mode}
+	}
+	if isSpecialFile(info.Mode()) {
+		return withEntry(info.NameInArchive, e.extractSpecial(info, cleanedName, report))
+	}
+
+	if e.workers != nil {
+		return e.workers.run(func() error {
+			return e.tolerate(withEntry(info.NameInArchive, e.extractFile(ctx, info, cleanedName, offset, report)))
+		})
+	}
+
+	return withEntry(info.NameInArchive, e.extractFile(ctx, info, cleanedName, offset, report))
+}
+
+// tolerate returns err, the failure to extract an entry, unless --keep-going
+// was given, in which case it's logged and recorded in e.failures so that
+// extraction carries on with the other entries. Failures that must stop
+// extraction, like reaching a limit or --reserve-space, are still returned.
+func (e *entryExtractor) tolerate(err error) error {
+	var limitErr *limitError
+	var reserveErr *reserveError
+	if err == nil || !e.keepGoing || errors.As(err, &limitErr) || errors.As(err, &reserveErr) || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	e.failuresMu.Lock()
+	e.failures = append(e.failures, err)
+	e.failuresMu.Unlock()
+	details := errorDetails([]any{err})
+	logMessage(slog.LevelError, details, "failed to extract entry: %s", err)
+	details["message"] = fmt.Sprintf("failed to extract entry: %s", err)
+	events.emit("error", details)
+	return nil
+}
+
+// skipUnsupported skips info, which uses feature that can't be extracted,
+// collecting it to be reported once extraction is done, or with --strict, or
+// if there's nowhere to collect it, fails.
+func (e *entryExtractor) skipUnsupported(info archives.FileInfo, feature unsupportedFeature) error {
+	if e.unsupported != nil && e.unsupported.scanned {
+		return nil
+	}
+	if e.strict || e.unsupported == nil {
+		return withEntry(info.NameInArchive, &unsupportedError{feature})
+	}
+	e.unsupported.add(feature, info.NameInArchive)
+	return nil
+}
+
+// matchesPattern reports whether name matches any of e.patterns, recording
+// each one that does.
+func (e *entryExtractor) matchesPattern(name string) bool {
+	matched := false
+	for i, pattern := range e.patterns {
+		if pattern.matches(name) {
+			e.patternMatched[i] = true
+			matched = true
+		}
+	}
+	return matched
+}
+
+// extractSymlink creates name beneath e.root as a symbolic link to the target
+// of info, which must resolve to a path inside the output.
+func (e *entryExtractor) extractSymlink(info archives.FileInfo, name string, report *entryReport) error {
+	target, err := squish.LinkTarget(info)
+	if err != nil {
+		return fmt.Errorf("failed to read symbolic link target: %w", err)
+	}
+	if e.linkEscapes(name, target) {
+		return &unsafeLinkError{name: name, target: target}
+	}
+
+	if err := e.mkdirParents(name); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	if err := e.root.Symlink(target, name); err != nil {
+		return fmt.Errorf("failed to create symbolic link: %w", err)
+	}
+	e.recordCreated(name, false)
+	e.symlinks.Add(name)
+
+	if uid, gid, ok := entryOwner(info, e.ownerMap, e.groupMap); ok && e.sameOwner {
+		if err := e.root.Lchown(name, uid, gid); err != nil {
+			report.warn("failed to change owner of %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// extractSpecial creates name beneath e.root as the named pipe or device given
+// by info. Devices can only be created by root, so they're skipped with a
+// warning otherwise.
+func (e *entryExtractor) extractSpecial(info archives.FileInfo, name string, report *entryReport) error {
+	if info.Mode()&fs.ModeSocket != 0 {
+		report.skip("skipped socket %s, which can't be extracted", name)
+		return nil
+	}
+
+	var major, minor int64
+	if header, ok := info.Header.(*tar.Header); ok {
+		major, minor = header.Devmajor, header.Devminor
+	}
+
+	if err := e.mkdirParents(name); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	if err := e.root.Mknod(name, info.Mode(), major, minor); errors.Is(err, fs.ErrPermission) && info.Mode()&fs.ModeDevice != 0 {
+		report.skip("skipped device %s, which can only be created by root", name)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to create special file: %w", err)
+	}
+	e.recordCreated(name, false)
+
+	if uid, gid, ok := entryOwner(info, e.ownerMap, e.groupMap); ok && e.sameOwner {
+		if err := e.root.Lchown(name, uid, gid); err != nil {
+			report.warn("failed to change owner of %s: %s", name, err)
+		}
+	}
+	e.restoreXattrs(name, info)
+	if e.times {
+		atime, mtime := entryTimes(info)
+		if err := e.root.Chtimes(name, atime, mtime); err != nil {
+			return fmt.Errorf("failed to set special file times: %w", err)
+		}
+	}
+	report.emit()
+	return nil
+}
+
+// linkEscapes reports whether the symbolic link name, with the slash-separated
+// target, would point outside of the output, which any target may with
+// --absolute-names.
+func (e *entryExtractor) linkEscapes(name, target string) bool {
+	return !e.absoluteNames && e.symlinks.Escapes(name, target)
+}
+
+// extractFile writes the contents of the regular file entry info to name
+// beneath e.root, creating its parent directories if necessary. If offset
+// isn't zero, name already holds that much of the contents, and the rest is
+// written after it.
+func (e *entryExtractor) extractFile(ctx context.Context, info archives.FileInfo, name string, offset int64, report *entryReport) (err error) {
+	if err := e.space.claim(name, info.Size()); err != nil {
+		var reserveErr *reserveError
+		if errors.As(err, &reserveErr) && e.whenFull == "skip" {
+			report.skip("skipped %s, which would leave less than %s free on the output filesystem", name, byteSize(reserveErr.reserve))
+			return nil
+		}
+		return err
+	}
+	defer e.space.release(info.Size())
+	// The entry is only reported, and hard links to it created, once the
+	// output file is closed.
+	defer func() {
+		if err == nil {
+			e.addLinkTarget(info, name)
+			report.emit()
+		}
+	}()
+
+	if err := e.mkdirParents(name); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	input, err := info.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open input entry reader: %w", err)
+	}
+	defer func() {
+		if closeErr := input.Close(); closeErr != nil {
+			if err == nil {
+				err = closeErr
+			} else {
+				report.warn("failed to close input entry reader: %s", closeErr)
+			}
+		}
+	}()
+
+	e.progress.start(info.NameInArchive, info.Size())
+
+	flag := os.O_CREATE | os.O_EXCL | os.O_WRONLY
+	if offset > 0 {
+		flag = os.O_WRONLY
+	}
+	output, err := e.root.OpenFile(name, flag, info.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	e.recordCreated(name, false)
+	defer func() {
+		if closeErr := output.Close(); closeErr != nil {
+			if err == nil {
+				err = closeErr
+			} else {
+				report.warn("failed to close output file: %s", closeErr)
+			}
+		}
+		// Files that were interrupted partway through are removed, unless
+		// they're to be finished by --resume.
+		if errors.Is(err, context.Canceled) && e.resume == nil {
+			if removeErr := e.root.Remove(name); removeErr != nil {
+				warn("failed to remove partially extracted %s: %s", name, removeErr)
+			}
+		}
+	}()
+	if offset == 0 && e.samePermissions && info.Mode().Perm()&e.umask != 0 {
+		if err := output.Chmod(info.Mode() & chmodBits); err != nil {
+			return fmt.Errorf("failed to set output file mode: %w", err)
+		}
+	}
+
+	// The space for large files is allocated before they're written, so
+	// that they aren't fragmented, unless they may be made reflinks, which
+	// share the blocks of others, or kept partway through for --resume.
+	preallocated := false
+	if offset == 0 && e.reflinks == nil && e.resume == nil && info.Size() >= preallocateMinSize && e.limits.fits(info.Size()) {
+		preallocated = preallocate(output, info.Size()) == nil
+	}
+
+	var inputR io.Reader = contextReader{ctx, input}
+	executable := false
+	if e.restoreExec && !storesUnixMode(info) {
+		buffered := bufio.NewReader(input)
+		magic, _ := buffered.Peek(execMagicSize)
+		executable = isExecutableContent(magic)
+		inputR = buffered
+	}
+
+	verify := func() error { return nil }
+	if e.checksums != nil {
+		inputR, verify = e.checksums.check(info.NameInArchive, inputR)
+	}
+	if digest := report.hash(); digest != nil {
+		inputR = io.TeeReader(inputR, digest)
+	}
+
+	if offset > 0 {
+		// The contents that were already written are skipped, but still
+		// checked against the checksums.
+		if err := output.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate partially extracted output: %w", err)
+		}
+		if _, err := output.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek output file: %w", err)
+		}
+		if _, err := io.CopyN(io.Discard, e.progress.reader(inputR), offset); err != nil {
+			return fmt.Errorf("failed to skip extracted contents of input entry: %w", err)
+		}
+	}
+
+	var dst io.Writer = withRetries(ctx, stats.output(output))
+	if e.limits != nil {
+		dst = limitWriter{dst, e.limits}
+	}
+	if e.resume != nil {
+		dst = &resumeWriter{Writer: dst, output: output, state: e.resume, name: name, offset: offset, next: offset + resumeCheckpoint}
+	}
+	var written int64
+	if e.reflinks != nil && offset == 0 {
+		written, err = e.reflinks.copy(output, dst, e.progress.reader(inputR), info.Size(), name, e.limits)
+	} else {
+		written, err = copyContents(dst, e.progress.reader(inputR))
+	}
+	if preallocated && written != info.Size() {
+		// Entries that were shorter than their sizes, or that failed
+		// partway through, don't keep the rest of the space.
+		if truncateErr := output.Truncate(written); truncateErr != nil && err == nil {
+			err = truncateErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy input entry to output file: %w", err)
+	}
+	e.progress.finish(info.NameInArchive, offset+written)
+	if err := verify(); err != nil {
+		return err
+	}
+
+	if executable {
+		// Whoever can read the file can execute it, as with the modes that
+		// files are created with by default.
+		stat, err := output.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat output file: %w", err)
+		}
+		perm := stat.Mode().Perm()
+		if err := output.Chmod(perm | (perm&0o444)>>2); err != nil {
+			return fmt.Errorf("failed to set output file mode: %w", err)
+		}
+	}
+
+	if uid, gid, ok := entryOwner(info, e.ownerMap, e.groupMap); ok && e.sameOwner {
+		if err := output.Chown(uid, gid); err != nil {
+			report.warn("failed to change owner of %s: %s", name, err)
+		} else if info.Mode()&(fs.ModeSetuid|fs.ModeSetgid) != 0 {
+			// Changing the owner clears the setuid and setgid bits.
+			if err := output.Chmod(info.Mode() & chmodBits); err != nil {
+				return fmt.Errorf("failed to set output file mode: %w", err)
+			}
+		}
+	}
+
+	// Changing the owner also clears file capabilities, so extended
+	// attributes are restored afterwards.
+	e.restoreXattrs(name, info)
+
+	if e.times {
+		atime, mtime := entryTimes(info)
+		if err := e.root.Chtimes(name, atime, mtime); err != nil {
+			return fmt.Errorf("failed to set output file times: %w", err)
+		}
+	}
+
+	if err := e.resume.record(resumeRecord{Name: name, Done: true, Entry: linkableEntry(info)}); err != nil {
+		return fmt.Errorf("failed to record progress: %w", err)
+	}
+	if report != nil {
+		report.bytes = offset + written
+	}
+	return nil
+}
+
+// hardLink is a hard link entry, extracted to name, linking to the entry
+// target, which finish creates.
+type hardLink struct {
+	info   archives.FileInfo
+	name   string
+	target string
+	report *entryReport
+}
+
+// hardLinkTarget returns the name of the entry that the hard link entry info
+// links to, or false if it isn't a hard link. Only tar archives have them.
+func hardLinkTarget(info archives.FileInfo) (string, bool) {
+	header, ok := info.Header.(*tar.Header)
+	if !ok || header.Typeflag != tar.TypeLink {
+		return "", false
+	}
+	return header.Linkname, true
+}
+
+// linkableEntry returns the name of the regular file entry info if hard links
+// can link to it, which only those in tar archives can, or "" otherwise.
+func linkableEntry(info archives.FileInfo) string {
+	if _, ok := info.Header.(*tar.Header); !ok {
+		return ""
+	}
+	return info.NameInArchive
+}
+
+// addLinkTarget records name as the output of the regular file entry info,
+// which has been written, so that the hard links to it can be created.
+func (e *entryExtractor) addLinkTarget(info archives.FileInfo, name string) {
+	entry := linkableEntry(info)
+	if entry == "" {
+		return
+	}
+	e.linkMu.Lock()
+	defer e.linkMu.Unlock()
+	if e.linkTargets == nil {
+		e.linkTargets = map[string]string{}
+	}
+	e.linkTargets[path.Clean(entry)] = name
+}
+
+// extractHardLink creates link as a hard link to the output of the regular
+// file it links to, which must have been extracted, or left as it was by
+// --if-changed.
+func (e *entryExtractor) extractHardLink(link hardLink) error {
+	target, ok := e.linkTargets[path.Clean(link.target)]
+	if !ok {
+		link.report.skip("skipped hard link %s, since %s, which it links to, wasn't extracted", link.info.NameInArchive, link.target)
+		return nil
+	}
+	// Symbolic links extracted after the entry was checked may have
+	// replaced the parents of either name since.
+	for _, name := range []string{link.name, target} {
+		if through, ok, err := e.symlinks.Through(name, e.root.Lstat); err != nil {
+			return fmt.Errorf("failed to check parent directories: %w", err)
+		} else if ok {
+			return &unsafeLinkError{name: name, link: through}
+		}
+	}
+	if err := e.mkdirParents(link.name); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	if err := e.root.Link(target, link.name); err != nil {
+		return fmt.Errorf("failed to create hard link: %w", err)
+	}
+	e.recordCreated(link.name, false)
+	return link.report.done(nil)
+}
+
+// finish creates the hard links, once the files they link to have been
+// written, and then restores the times and extended attributes of the
+// extracted directories, once all of their contents have been extracted.
+func (e *entryExtractor) finish() error {
+	for _, link := range e.hardLinks {
+		if err := e.tolerate(withEntry(link.info.NameInArchive, e.extractHardLink(link))); err != nil {
+			return err
+		}
+	}
+	for _, info := range e.dirs {
+		e.restoreXattrs(info.NameInArchive, info)
+		if !e.times {
+			continue
+		}
+
+		atime, mtime := entryTimes(info)
+		if err := e.root.Chtimes(info.NameInArchive, atime, mtime); err != nil {
+			return fmt.Errorf("failed to set output directory times: %w", err)
+		}
+	}
+	return nil
+}
+
+// mkdirParents creates the parent directories of name beneath e.root that
+// don't exist yet, recording them as implicit.
+func (e *entryExtractor) mkdirParents(name string) error {
+	var missing []string
+	for dir := filepath.Dir(name); dir != "." && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, err := e.root.Lstat(dir); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	if err := e.root.MkdirAll(filepath.Dir(name), e.dirMode); err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	e.implicitMu.Lock()
+	defer e.implicitMu.Unlock()
+	if e.implicitDirs == nil {
+		e.implicitDirs = map[string]bool{}
+	}
+	for _, dir := range missing {
+		e.implicitDirs[dir] = true
+	}
+	return nil
+}
+
+// entryMode returns the mode that an entry with mode is extracted with, after
+// --mode, the umask unless --same-permissions was given, and --strip-setuid.
+func (e *entryExtractor) entryMode(mode fs.FileMode) fs.FileMode {
+	mode = e.mode.apply(mode)
+	if !e.samePermissions {
+		mode &^= e.umask
+	}
+	if e.stripSetuid && !mode.IsDir() {
+		mode &^= fs.ModeSetuid | fs.ModeSetgid
+	}
+	return mode
+}
+
+// takeImplicitDir reports whether name was created by mkdirParents, and
+// forgets it, so that it's only reported once.
+func (e *entryExtractor) takeImplicitDir(name string) bool {
+	e.implicitMu.Lock()
+	defer e.implicitMu.Unlock()
+	implicit := e.implicitDirs[name]
+	delete(e.implicitDirs, name)
+	return implicit
+}
+
+func (e *entryExtractor) recordCreated(name string, dir bool) {
+	if e.whenFull != "rollback" {
+		return
+	}
+	e.createdMu.Lock()
+	defer e.createdMu.Unlock()
+	e.created = append(e.created, createdEntry{name: name, dir: dir})
+}
+
+// rollback removes the entries created by the extraction, most recent first
+// so that directories are empty by the time they're removed, and returns how
+// many were removed. Parent directories that weren't in the archive are
+// kept.
+func (e *entryExtractor) rollback() int {
+	removed := 0
+	for i := len(e.created) - 1; i >= 0; i-- {
+		entry := e.created[i]
+		remove := e.root.Remove
+		if entry.dir {
+			remove = e.root.RemoveDir
+		}
+		if err := remove(entry.name); err != nil {
+			warn("failed to remove %s: %s", entry.name, err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+// restoreXattrs sets the extended attributes stored for info on the extracted
+// entry name, warning about any that can't be set.
+func (e *entryExtractor) restoreXattrs(name string, info archives.FileInfo) {
+	xattrs := entryXattrs(info, e.xattrs, e.acls, e.capabilities)
+	attrs := make([]string, 0, len(xattrs))
+	for attr := range xattrs {
+		attrs = append(attrs, attr)
+	}
+	slices.Sort(attrs)
+
+	for _, attr := range attrs {
+		if err := e.root.Lsetxattr(name, attr, []byte(xattrs[attr])); err != nil {
+			warn("failed to restore extended attribute %s of %s: %s", attr, name, err)
+		}
+	}
+}
+
+// entryTimes returns the access and modification times that info should be
+// extracted with. The access time is zero, leaving it unchanged, unless it's
+// stored by the archive, which only tar archives can do.
+func entryTimes(info archives.FileInfo) (atime, mtime time.Time) {
+	if header, ok := info.Header.(*tar.Header); ok {
+		atime = header.AccessTime
+	}
+	return atime, info.ModTime()
+}
+
+// relativeTo returns the path of target relative to base, even if only one of
+// them is absolute.
+func relativeTo(base, target string) (string, error) {
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Rel(base, target)
+}
+
+// verifyInput bails unless the detached signature of input, which must be on
+// disk, was made by the key given by --verify. The input is read entirely
+// before anything is extracted, so that a tampered input is never extracted
+// even partially.
+func verifyInput(input io.Reader, size int64) {
+	inputAt, ok := input.(io.ReaderAt)
+	if cli.Extract.Input == stdioPath || isURL(cli.Extract.Input) || !ok {
+		bail("--verify can only be used with inputs on disk")
+	}
+
+	key, err := readVerifyingKey(cli.Extract.Verify)
+	if err != nil {
+		bail("failed to read verifying key: %s", err)
+	}
+	sig, err := os.ReadFile(cli.Extract.Input + signatureExtension)
+	if err != nil {
+		bail("failed to read signature: %s", err)
+	}
+	if err := verifySSHSignature(key, sig, io.NewSectionReader(inputAt, 0, size)); err != nil {
+		bail("failed to verify signature of %s: %s", cli.Extract.Input, err)
+	}
+	logger.Debug("verified signature", "signature", cli.Extract.Input+signatureExtension)
+}
@@ -0,0 +1,1009 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+type testEntry struct {
+	name     string
+	typeflag byte
+	contents string
+	linkname string
+}
+
+func makeTar(t testing.TB, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Mode:     0o644,
+			Size:     int64(len(entry.contents)),
+			Linkname: entry.linkname,
+		}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0o755
+		}
+		if entry.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entry.contents[:header.Size])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeZip(t testing.TB, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		f, err := w.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(entry.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readTree returns the contents of every regular file beneath dir, keyed by
+// slash-separated path, with directories mapped to "/" and symbolic links
+// mapped to "-> " followed by their targets.
+func readTree(t testing.TB, dir string) map[string]string {
+	tree := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		switch {
+		case d.IsDir():
+			tree[name] = "/"
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			tree[name] = "-> " + target
+		case d.Type().IsRegular():
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			tree[name] = string(contents)
+		default:
+			tree[name] = "?"
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// extractTest extracts archive with format into a fresh output directory,
+// which is returned along with its parent so that callers can check nothing
+// was written outside of it.
+func extractTest(t testing.TB, format archives.Extractor, archive []byte, e *entryExtractor) (parent, output string, err error) {
+	parent = t.TempDir()
+	output = filepath.Join(parent, "out")
+	if err := os.Mkdir(output, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.root == nil {
+		e.root = pathRoot(output)
+	}
+	if e.dirMode == 0 {
+		e.dirMode = fs.ModeDir | 0o755
+	}
+	err = format.Extract(context.Background(), bytes.NewReader(archive), e.extract)
+	if e.workers != nil {
+		if waitErr := e.workers.wait(); err == nil {
+			err = waitErr
+		}
+	}
+	if err == nil {
+		err = e.finish()
+	}
+	return parent, output, err
+}
+
+func TestExtractEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testEntry
+		types   []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "files and directories",
+			entries: []testEntry{
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "a/b", typeflag: tar.TypeReg, contents: "b"},
+				{name: "c/d/e", typeflag: tar.TypeReg, contents: "e"},
+			},
+			want: map[string]string{"a": "/", "a/b": "b", "c": "/", "c/d": "/", "c/d/e": "e"},
+		},
+		{
+			name:    "parent traversal",
+			entries: []testEntry{{name: "../evil", typeflag: tar.TypeReg, contents: "evil"}},
+			want:    map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "nested parent traversal",
+			entries: []testEntry{{name: "a/../../evil", typeflag: tar.TypeReg, contents: "evil"}},
+			want:    map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "absolute path",
+			entries: []testEntry{{name: "/evil", typeflag: tar.TypeReg, contents: "evil"}},
+			want:    map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "traversal in directory",
+			entries: []testEntry{{name: "../evil/", typeflag: tar.TypeDir}},
+			want:    map[string]string{},
+			wantErr: true,
+		},
+		{
+			name: "redundant components",
+			entries: []testEntry{
+				{name: "./a//b/../c", typeflag: tar.TypeReg, contents: "c"},
+			},
+			want: map[string]string{"a": "/", "a/c": "c"},
+		},
+		{
+			name: "type filter",
+			entries: []testEntry{
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "b", typeflag: tar.TypeReg, contents: "b"},
+				{name: "l", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+			},
+			types: []string{"f"},
+			want:  map[string]string{"b": "b"},
+		},
+		{
+			name: "symbolic links",
+			entries: []testEntry{
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "a/b", typeflag: tar.TypeReg, contents: "b"},
+				{name: "l", typeflag: tar.TypeSymlink, linkname: "a/b"},
+				{name: "a/l", typeflag: tar.TypeSymlink, linkname: "../a"},
+			},
+			want: map[string]string{"a": "/", "a/b": "b", "l": "-> a/b", "a/l": "-> ../a"},
+		},
+		{
+			name:    "symbolic link outside output",
+			entries: []testEntry{{name: "a/l", typeflag: tar.TypeSymlink, linkname: "../../evil"}},
+			want:    map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "absolute symbolic link",
+			entries: []testEntry{{name: "l", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+			want:    map[string]string{},
+			wantErr: true,
+		},
+		{
+			name: "write through symbolic link",
+			entries: []testEntry{
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "l", typeflag: tar.TypeSymlink, linkname: "a"},
+				{name: "l/evil", typeflag: tar.TypeReg, contents: "evil"},
+			},
+			want:    map[string]string{"a": "/", "l": "-> a"},
+			wantErr: true,
+		},
+		{
+			name: "traversal out of symbolic link",
+			entries: []testEntry{
+				{name: "l", typeflag: tar.TypeSymlink, linkname: "."},
+				{name: "a/l", typeflag: tar.TypeSymlink, linkname: "../l/.."},
+			},
+			want:    map[string]string{"l": "-> ."},
+			wantErr: true,
+		},
+		{
+			name: "traversal out of directory beneath symbolic link",
+			entries: []testEntry{
+				{name: "l", typeflag: tar.TypeSymlink, linkname: "."},
+				{name: "x/", typeflag: tar.TypeDir},
+				{name: "e", typeflag: tar.TypeSymlink, linkname: "l/l/x/../.."},
+			},
+			want:    map[string]string{"l": "-> .", "x": "/"},
+			wantErr: true,
+		},
+		{
+			name: "traversal out of symbolic link extracted later",
+			entries: []testEntry{
+				{name: "l", typeflag: tar.TypeSymlink, linkname: "sub/.."},
+				{name: "sub", typeflag: tar.TypeSymlink, linkname: "."},
+			},
+			want:    map[string]string{},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent, output, err := extractTest(t, archives.Tar{}, makeTar(t, test.entries), &entryExtractor{types: test.types})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, test.wantErr)
+			}
+
+			if got := readTree(t, output); !maps.Equal(got, test.want) {
+				t.Errorf("got output %v, want %v", got, test.want)
+			}
+			if got := readTree(t, parent); len(got) != 1+len(test.want) {
+				t.Errorf("files were written outside of the output: %v", got)
+			}
+		})
+	}
+}
+
+func TestExtractTruncated(t *testing.T) {
+	archive := makeTar(t, []testEntry{{name: "a", typeflag: tar.TypeReg, contents: string(make([]byte, 4096))}})
+
+	// Cut the archive off partway through the entry's contents.
+	_, _, err := extractTest(t, archives.Tar{}, archive[:1024], &entryExtractor{})
+	if err == nil {
+		t.Error("extracting a truncated archive succeeded")
+	}
+}
+
+func TestExtractPreallocated(t *testing.T) {
+	contents := strings.Repeat("0123456789abcdef", preallocateMinSize/8)
+	archive := makeTar(t, []testEntry{{name: "a", typeflag: tar.TypeReg, contents: contents}})
+
+	_, output, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, output); got["a"] != contents {
+		t.Errorf("got %d bytes of contents, want %d", len(got["a"]), len(contents))
+	}
+
+	// Files cut off partway through aren't left at the size they were
+	// allocated with.
+	_, output, err = extractTest(t, archives.Tar{}, archive[:len(archive)/2], &entryExtractor{})
+	if err == nil {
+		t.Fatal("extracting a truncated archive succeeded")
+	}
+	if info, err := os.Stat(filepath.Join(output, "a")); err == nil && info.Size() >= int64(len(contents)) {
+		t.Errorf("truncated entry was left with %d bytes", info.Size())
+	}
+}
+
+func TestExtractConcurrent(t *testing.T) {
+	var entries []testEntry
+	want := map[string]string{"d": "/"}
+	for _, name := range []string{"a", "b", "c", "d/e", "d/f"} {
+		entries = append(entries, testEntry{name: name, contents: name})
+		want[name] = name
+	}
+
+	e := &entryExtractor{workers: newWorkerPool(4)}
+	_, output, err := extractTest(t, archives.Zip{}, makeZip(t, entries), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readTree(t, output); !maps.Equal(got, want) {
+		t.Errorf("got output %v, want %v", got, want)
+	}
+}
+
+func TestExtractHardLinks(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a", typeflag: tar.TypeReg, contents: "contents"},
+		{name: "d/b", typeflag: tar.TypeLink, linkname: "a"},
+		{name: "c", typeflag: tar.TypeLink, linkname: "./missing"},
+	})
+
+	// Links are only created once the files they link to are written.
+	_, output, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{workers: newWorkerPool(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := os.Stat(filepath.Join(output, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.Stat(filepath.Join(output, "d", "b")); err != nil || !os.SameFile(a, b) {
+		t.Errorf("d/b isn't a hard link to a: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(output, "c")); err == nil {
+		t.Error("created a hard link to an entry that isn't in the archive")
+	}
+}
+
+func TestExtractHardLinkToRefused(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a", typeflag: tar.TypeReg, contents: "a"},
+		{name: "d", typeflag: tar.TypeSymlink, linkname: "."},
+		{name: "d/a", typeflag: tar.TypeReg, contents: "d/a"},
+		{name: "l", typeflag: tar.TypeLink, linkname: "d/a"},
+	})
+	output := t.TempDir()
+	e := &entryExtractor{root: pathRoot(output), dirMode: fs.ModeDir | 0o755, keepGoing: true}
+	handle := func(ctx context.Context, info archives.FileInfo) error {
+		return e.tolerate(e.extract(ctx, info))
+	}
+	if err := (archives.Tar{}).Extract(context.Background(), bytes.NewReader(archive), handle); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	// d/a is refused, since it would be written through d, so l mustn't
+	// be linked to a, which is what d/a resolves to.
+	if _, err := os.Lstat(filepath.Join(output, "l")); err == nil {
+		t.Error("created a hard link to an entry that was refused")
+	}
+	var linkErr *unsafeLinkError
+	if len(e.failures) != 1 || !errors.As(e.failures[0], &linkErr) {
+		t.Errorf("got failures %v, want one unsafe link error", e.failures)
+	}
+}
+
+func TestExtractThroughExistingLink(t *testing.T) {
+	archive := makeTar(t, []testEntry{{name: "l/escaped", typeflag: tar.TypeReg, contents: "escaped"}})
+	parent := t.TempDir()
+	output := filepath.Join(parent, "out")
+	if err := os.Mkdir(output, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(output, "l")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Symbolic links that were already in the output, like ones extracted
+	// from another archive, aren't written through either.
+	e := &entryExtractor{root: pathRoot(output), dirMode: fs.ModeDir | 0o755}
+	var linkErr *unsafeLinkError
+	if err := (archives.Tar{}).Extract(context.Background(), bytes.NewReader(archive), e.extract); !errors.As(err, &linkErr) {
+		t.Errorf("got error %v, want an unsafe link error", err)
+	}
+	if _, err := os.Lstat(filepath.Join(parent, "escaped")); err == nil {
+		t.Error("escaped was written outside of the output")
+	}
+}
+
+func TestExtractDirMode(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a/b/c", typeflag: tar.TypeReg, contents: "c"},
+		{name: "d/", typeflag: tar.TypeDir},
+	})
+
+	_, output, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{dirMode: fs.ModeDir | 0o700})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]fs.FileMode{"a": 0o700, "a/b": 0o700, "d": 0o755} {
+		info, err := os.Stat(filepath.Join(output, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s: got mode %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestExtractPermissions(t *testing.T) {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, header := range []*tar.Header{
+		{Name: "d/", Typeflag: tar.TypeDir, Mode: 0o2775},
+		{Name: "d/f", Typeflag: tar.TypeReg, Mode: 0o4775},
+		{Name: "g", Typeflag: tar.TypeReg, Mode: 0o666},
+	} {
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var goNoWrite modeChange
+	if err := goNoWrite.UnmarshalText([]byte("go-w")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		e    *entryExtractor
+		want map[string]fs.FileMode
+	}{
+		{
+			name: "mode",
+			e:    &entryExtractor{mode: &goNoWrite, stripSetuid: true},
+			want: map[string]fs.FileMode{"d": fs.ModeDir | 0o755, "d/f": 0o755, "g": 0o644},
+		},
+		{
+			name: "umask and strip setuid",
+			e:    &entryExtractor{umask: 0o022, stripSetuid: true},
+			want: map[string]fs.FileMode{"d": fs.ModeDir | 0o755, "d/f": 0o755, "g": 0o644},
+		},
+		{
+			name: "same permissions",
+			e:    &entryExtractor{umask: 0o077, samePermissions: true},
+			want: map[string]fs.FileMode{"d": fs.ModeDir | fs.ModeSetgid | 0o775, "d/f": 0o775, "g": 0o666},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, output, err := extractTest(t, archives.Tar{}, buf.Bytes(), test.e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range test.want {
+				info, err := os.Stat(filepath.Join(output, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Writing to files can clear their setuid bits when
+				// unprivileged, so those are only checked to be removed.
+				got := info.Mode()
+				if test.e.samePermissions && !got.IsDir() {
+					got &^= fs.ModeSetuid
+				}
+				if got != want {
+					t.Errorf("%s: got mode %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractOutOfOrder(t *testing.T) {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, header := range []*tar.Header{
+		{Name: "a/b/c", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "a/b/", Typeflag: tar.TypeDir, Mode: 0o700},
+		{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o750},
+	} {
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, output, err := extractTest(t, archives.Tar{}, buf.Bytes(), &entryExtractor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]fs.FileMode{"a": 0o750, "a/b": 0o700, "a/b/c": 0o644} {
+		info, err := os.Stat(filepath.Join(output, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s: got mode %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestExtractStripComponents(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "./project-1.2.3/", typeflag: tar.TypeDir},
+		{name: "./project-1.2.3/a", typeflag: tar.TypeReg, contents: "a"},
+		{name: "./project-1.2.3/b/c", typeflag: tar.TypeReg, contents: "c"},
+		{name: "d", typeflag: tar.TypeReg, contents: "d"},
+	})
+
+	_, output, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{stripComponents: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"a": "a", "b": "/", "b/c": "c"}
+	if got := readTree(t, output); !maps.Equal(got, want) {
+		t.Errorf("got output %v, want %v", got, want)
+	}
+}
+
+func TestExtractPatterns(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/docs/", typeflag: tar.TypeDir},
+		{name: "a/docs/x", typeflag: tar.TypeReg, contents: "x"},
+		{name: "a/README.md", typeflag: tar.TypeReg, contents: "r"},
+		{name: "a/src/main.go", typeflag: tar.TypeReg, contents: "m"},
+	})
+
+	e := &entryExtractor{patterns: []glob{"a/docs", "README*", "missing"}, patternMatched: make([]bool, 3)}
+	_, output, err := extractTest(t, archives.Tar{}, archive, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"a": "/", "a/docs": "/", "a/docs/x": "x", "a/README.md": "r"}
+	if got := readTree(t, output); !maps.Equal(got, want) {
+		t.Errorf("got output %v, want %v", got, want)
+	}
+	if wantMatched := []bool{true, true, false}; !slices.Equal(e.patternMatched, wantMatched) {
+		t.Errorf("got matched patterns %v, want %v", e.patternMatched, wantMatched)
+	}
+}
+
+func TestExtractTypes(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/b", typeflag: tar.TypeReg, contents: "b"},
+		{name: "a/link", typeflag: tar.TypeSymlink, linkname: "b"},
+		{name: "empty/", typeflag: tar.TypeDir},
+	})
+
+	tests := []struct {
+		types []string
+		want  map[string]string
+	}{
+		{[]string{"f"}, map[string]string{"a": "/", "a/b": "b"}},
+		{[]string{"d"}, map[string]string{"a": "/", "empty": "/"}},
+		{[]string{"f", "l"}, map[string]string{"a": "/", "a/b": "b", "a/link": "-> b"}},
+	}
+	for _, test := range tests {
+		_, output, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{types: test.types})
+		if err != nil {
+			t.Fatalf("%v: %s", test.types, err)
+		}
+		if got := readTree(t, output); !maps.Equal(got, test.want) {
+			t.Errorf("%v: got output %v, want %v", test.types, got, test.want)
+		}
+	}
+}
+
+func TestExtractTransform(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "artifacts/", typeflag: tar.TypeDir},
+		{name: "artifacts/a", typeflag: tar.TypeReg, contents: "a"},
+		{name: "artifacts/b.o", typeflag: tar.TypeReg, contents: "b"},
+		{name: "c", typeflag: tar.TypeReg, contents: "c"},
+	})
+
+	var transforms []transform
+	for _, rule := range []string{"s|^artifacts|build|", `s/.*\.o$//`} {
+		var tr transform
+		if err := tr.UnmarshalText([]byte(rule)); err != nil {
+			t.Fatal(err)
+		}
+		transforms = append(transforms, tr)
+	}
+
+	_, output, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{transforms: transforms})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"build": "/", "build/a": "a", "c": "c"}
+	if got := readTree(t, output); !maps.Equal(got, want) {
+		t.Errorf("got output %v, want %v", got, want)
+	}
+
+	var traversal transform
+	if err := traversal.UnmarshalText([]byte("s|^|../|")); err != nil {
+		t.Fatal(err)
+	}
+	parent, _, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{transforms: []transform{traversal}})
+	if err == nil {
+		t.Error("extracting entries transformed outside of the output succeeded")
+	}
+	if got := readTree(t, parent); len(got) != 1 {
+		t.Errorf("files were written outside of the output: %v", got)
+	}
+}
+
+func TestExtractOverwrite(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "d/", typeflag: tar.TypeDir},
+		{name: "d/a", typeflag: tar.TypeReg, contents: "new a"},
+		{name: "d/b", typeflag: tar.TypeReg, contents: "new b"},
+	})
+
+	tests := []struct {
+		overwrite string
+		want      map[string]string
+	}{
+		{"never", map[string]string{"d": "/", "d/a": "old a", "d/b": "old b", "d/c": "c"}},
+		{"always", map[string]string{"d": "/", "d/a": "new a", "d/b": "new b", "d/c": "c"}},
+		{"newer", map[string]string{"d": "/", "d/a": "old a", "d/b": "new b", "d/c": "c"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.overwrite, func(t *testing.T) {
+			output := t.TempDir()
+			if err := os.Mkdir(filepath.Join(output, "d"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for name, contents := range map[string]string{"a": "old a", "b": "old b", "c": "c"} {
+				if err := os.WriteFile(filepath.Join(output, "d", name), []byte(contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The entries have the Unix epoch as their modification time.
+			old := time.Unix(-1, 0)
+			if err := os.Chtimes(filepath.Join(output, "d", "b"), old, old); err != nil {
+				t.Fatal(err)
+			}
+
+			e := &entryExtractor{root: pathRoot(output), overwrite: test.overwrite, dirMode: fs.ModeDir | 0o755}
+			if err := (archives.Tar{}).Extract(context.Background(), bytes.NewReader(archive), e.extract); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readTree(t, output); !maps.Equal(got, test.want) {
+				t.Errorf("got output %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractDryRun(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "d/", typeflag: tar.TypeDir},
+		{name: "d/a", typeflag: tar.TypeReg, contents: "new a"},
+		{name: "d/b", typeflag: tar.TypeReg, contents: "b"},
+		{name: "l", typeflag: tar.TypeSymlink, linkname: "d"},
+	})
+	output := t.TempDir()
+	if err := os.Mkdir(filepath.Join(output, "d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(output, "d", "a"), []byte("old a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &entryExtractor{root: pathRoot(output), overwrite: "always", dirMode: fs.ModeDir | 0o755, dryRun: true}
+	if err := (archives.Tar{}).Extract(context.Background(), bytes.NewReader(archive), e.extract); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"d": "/", "d/a": "old a"}
+	if got := readTree(t, output); !maps.Equal(got, want) {
+		t.Errorf("got output %v, want %v", got, want)
+	}
+
+	// Entries written through symbolic links are still refused.
+	archive = makeTar(t, []testEntry{
+		{name: "l", typeflag: tar.TypeSymlink, linkname: "d"},
+		{name: "l/a", typeflag: tar.TypeReg, contents: "a"},
+	})
+	e = &entryExtractor{root: pathRoot(output), dirMode: fs.ModeDir | 0o755, dryRun: true}
+	var linkErr *unsafeLinkError
+	if err := (archives.Tar{}).Extract(context.Background(), bytes.NewReader(archive), e.extract); !errors.As(err, &linkErr) {
+		t.Errorf("got error %v, want an unsafe link error", err)
+	}
+}
+
+func TestExtractAbsoluteNames(t *testing.T) {
+	elsewhere := t.TempDir()
+	archive := makeTar(t, []testEntry{
+		{name: "a", typeflag: tar.TypeReg, contents: "a"},
+		{name: elsewhere + "/b", typeflag: tar.TypeReg, contents: "b"},
+	})
+
+	var pathErr *unsafePathError
+	if _, _, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{}); !errors.As(err, &pathErr) {
+		t.Errorf("got error %v, want an unsafe path error", err)
+	}
+
+	parent := t.TempDir()
+	e := &entryExtractor{root: absoluteRoot{pathRoot(parent)}, absoluteNames: true}
+	if _, _, err := extractTest(t, archives.Tar{}, archive, e); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readTree(t, parent), map[string]string{"a": "a"}; !maps.Equal(got, want) {
+		t.Errorf("got output %v, want %v", got, want)
+	}
+	if got, want := readTree(t, elsewhere), map[string]string{"b": "b"}; !maps.Equal(got, want) {
+		t.Errorf("got %v at the absolute path, want %v", got, want)
+	}
+}
+
+func TestExtractEntryResults(t *testing.T) {
+	var buf bytes.Buffer
+	events = &eventWriter{w: &buf}
+	t.Cleanup(func() { events = nil })
+
+	archive := makeTar(t, []testEntry{
+		{name: "d/", typeflag: tar.TypeDir},
+		{name: "d/a", typeflag: tar.TypeReg, contents: "a"},
+		{name: "d/b", typeflag: tar.TypeReg, contents: "new b"},
+	})
+	parent := t.TempDir()
+	if err := os.Mkdir(filepath.Join(parent, "d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "d", "b"), []byte("old b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e := &entryExtractor{root: pathRoot(parent), outputPath: "out", overwrite: "never"}
+	if _, _, err := extractTest(t, archives.Tar{}, archive, e); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event["event"] == "entry_result" {
+			delete(event, "event")
+			got = append(got, event)
+		}
+	}
+	want := []map[string]any{
+		{"entry": "d/", "path": filepath.Join("out", "d"), "action": "merged", "bytes": 0.0},
+		{"entry": "d/a", "path": filepath.Join("out", "d", "a"), "action": "created", "bytes": 1.0, "sha256": "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"},
+		{"entry": "d/b", "path": filepath.Join("out", "d", "b"), "action": "skipped", "bytes": 0.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got results %v, want %v", got, want)
+	}
+}
+
+func TestExtractKeepGoing(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a", typeflag: tar.TypeReg, contents: "a"},
+		{name: "../b", typeflag: tar.TypeReg, contents: "b"},
+		{name: "c", typeflag: tar.TypeReg, contents: "c"},
+	})
+	output := t.TempDir()
+	e := &entryExtractor{root: pathRoot(output), dirMode: fs.ModeDir | 0o755, keepGoing: true}
+	handle := func(ctx context.Context, info archives.FileInfo) error {
+		return e.tolerate(e.extract(ctx, info))
+	}
+	if err := (archives.Tar{}).Extract(context.Background(), bytes.NewReader(archive), handle); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readTree(t, output), map[string]string{"a": "a", "c": "c"}; !maps.Equal(got, want) {
+		t.Errorf("got output %v, want %v", got, want)
+	}
+	var pathErr *unsafePathError
+	if len(e.failures) != 1 || !errors.As(e.failures[0], &pathErr) {
+		t.Errorf("got failures %v, want one unsafe path error", e.failures)
+	}
+}
+
+func TestExtractReflink(t *testing.T) {
+	contents := strings.Repeat("0123456789abcdef", 8<<10)
+	differing := contents[:len(contents)-1] + "!"
+	from := t.TempDir()
+	if err := os.WriteFile(filepath.Join(from, "existing"), []byte(strings.ToUpper(contents)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archive := makeTar(t, []testEntry{
+		{name: "a", typeflag: tar.TypeReg, contents: contents},
+		{name: "b", typeflag: tar.TypeReg, contents: contents},
+		{name: "c", typeflag: tar.TypeReg, contents: differing},
+		{name: "d", typeflag: tar.TypeReg, contents: strings.ToUpper(contents)},
+		{name: "e", typeflag: tar.TypeReg, contents: "small"},
+	})
+
+	output := t.TempDir()
+	reflinks, err := newReflinkIndex(pathRoot(output), from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &entryExtractor{root: pathRoot(output), dirMode: fs.ModeDir | 0o755, reflinks: reflinks}
+	if err := (archives.Tar{}).Extract(context.Background(), bytes.NewReader(archive), e.extract); err != nil {
+		t.Fatal(err)
+	}
+
+	// Whether or not the filesystem supports reflinks, the contents must be
+	// those of the entries.
+	want := map[string]string{"a": contents, "b": contents, "c": differing, "d": strings.ToUpper(contents), "e": "small"}
+	if got := readTree(t, output); !maps.Equal(got, want) {
+		t.Errorf("got output that differs from the entries")
+	}
+}
+
+func TestExtractTimes(t *testing.T) {
+	// Entries are written with the Unix epoch as their modification time.
+	archive := makeTar(t, []testEntry{
+		{name: "d/", typeflag: tar.TypeDir},
+		{name: "d/e/f", typeflag: tar.TypeReg, contents: "f"},
+	})
+
+	for _, times := range []bool{false, true} {
+		_, output, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{times: times})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"d", "d/e/f"} {
+			info, err := os.Stat(filepath.Join(output, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored := info.ModTime().Equal(time.Unix(0, 0)); restored != times {
+				t.Errorf("times %t: %s has modification time %s", times, name, info.ModTime())
+			}
+		}
+	}
+}
+
+func TestExtractRestoreExec(t *testing.T) {
+	// Zips created by archive/zip's Create don't store Unix modes.
+	archive := makeZip(t, []testEntry{
+		{name: "script", contents: "#!/bin/sh\necho hi\n"},
+		{name: "binary", contents: "\x7fELF\x02\x01\x01\x00"},
+		{name: "text", contents: "hello"},
+	})
+
+	for _, restore := range []bool{false, true} {
+		_, output, err := extractTest(t, archives.Zip{}, archive, &entryExtractor{restoreExec: restore})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, executable := range map[string]bool{"script": restore, "binary": restore, "text": false} {
+			info, err := os.Stat(filepath.Join(output, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode()&0o100 != 0; got != executable {
+				t.Errorf("restoreExec %t: %s has mode %s", restore, name, info.Mode())
+			}
+		}
+	}
+}
+
+func FuzzExtractTar(f *testing.F) {
+	f.Add(makeTar(f, []testEntry{{name: "a/b", typeflag: tar.TypeReg, contents: "b"}}))
+	f.Add(makeTar(f, []testEntry{{name: "../evil", typeflag: tar.TypeReg, contents: "evil"}}))
+	f.Add(makeTar(f, []testEntry{{name: "a/", typeflag: tar.TypeDir}, {name: "a/../../evil/", typeflag: tar.TypeDir}}))
+	f.Add(makeTar(f, []testEntry{{name: "l", typeflag: tar.TypeSymlink, linkname: ".."}, {name: "l/evil", typeflag: tar.TypeReg}}))
+	f.Add(makeTar(f, []testEntry{{name: "l", typeflag: tar.TypeSymlink, linkname: "sub/.."}, {name: "sub", typeflag: tar.TypeSymlink, linkname: "."}, {name: "l/evil", typeflag: tar.TypeReg}}))
+
+	f.Fuzz(func(t *testing.T, archive []byte) {
+		parent, _, _ := extractTest(t, archives.Tar{}, archive, &entryExtractor{})
+
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("files were written outside of the output: %v", entries)
+		}
+	})
+}
+
+func TestExtractFormat(t *testing.T) {
+	var compressed bytes.Buffer
+	w, err := archives.Brotli{}.OpenWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("squish"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// brotli can't be identified by its contents, so without --format, it's
+	// only identified by its extension.
+	dir := t.TempDir()
+	input := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(input, compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "data")
+	parseCLI(t, "extract", input, output)
+	if code := runCommand(t, func() { extract(context.Background()) }); code == 0 {
+		t.Error("input with an unknown extension was identified")
+	}
+	parseCLI(t, "extract", "--format", "br", input, output)
+	if code := runCommand(t, func() { extract(context.Background()) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if got, err := os.ReadFile(output); err != nil || string(got) != "squish" {
+		t.Errorf("got output %q, %v, want squish", got, err)
+	}
+}
+
+// encryptedHeaders7z is bodgit/sevenzip's t2.7z, with its headers encrypted
+// with the password "password", and files foo and bar.
+const encryptedHeaders7z = "N3q8ryccAAQfQXHHwAAAAAAAAAAoAAAAAAAAALn7J1qgRMFFatX0e5vxiYNkCc0bOOTUmvi+KatqrtUARthD6ik2mQ2RgdM8A3HxtXiuzmUYq53Om8X6sE3kZ+A1br2Ylv2nvh3rUMcWgf1i+MC8UXkcAiQZme6XopM71m+OeNlu8lfFYkKp/EA4SKNOVdtinaYnj0Y6pRJQJhRTVRWX9XgSnN3fd0sFwKmndH7i0WMdM0gRC4Y6c4wSthZkV1kllHvYvgcInoSnXefRgKBVaQ34kCZCq0xo2g90+JSboO8XBiABCYCgAAcLAQABJAbxBwEKUwf0wep1D5nnYwyAlgoB8PBMOwAA"
+
+func TestExtractEncryptedHeaders(t *testing.T) {
+	archive, err := base64.StdEncoding.DecodeString(encryptedHeaders7z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(t.TempDir(), "t2.7z")
+	if err := os.WriteFile(input, archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, savedAnswers, savedExitCode := cli, answers, exitCode
+	t.Cleanup(func() { cli, answers, exitCode = saved, savedAnswers, savedExitCode })
+
+	// run extracts the archive in a goroutine, since bail exits it, and
+	// returns the exit code and the extracted tree.
+	run := func(p password) (int, map[string]string) {
+		output := filepath.Join(t.TempDir(), "out")
+		cli.Extract.Input, cli.Extract.Output, cli.Extract.Password = input, &output, p
+		exitCode = 0
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			extract(context.Background())
+		}()
+		<-done
+		if _, err := os.Stat(output); err != nil {
+			return exitCode, nil
+		}
+		return exitCode, readTree(t, output)
+	}
+	want := map[string]string{"foo": "foo\n", "bar": "bar\n"}
+
+	if code, _ := run(password{}); code != exitCodes["password"] {
+		t.Errorf("without a password: got exit code %d, want %d", code, exitCodes["password"])
+	}
+	if code, got := run(password{value: "password"}); code != 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("with --password=password: got exit code %d and tree %v, want %v", code, got, want)
+	}
+	// The password is read from stdin when it isn't a terminal.
+	stdin, err := os.Open(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	savedStdin := os.Stdin
+	defer func() { os.Stdin = savedStdin }()
+	os.Stdin, answers = stdin, bufio.NewReader(strings.NewReader("password\n"))
+	if code, got := run(password{prompt: true}); code != 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("with the password at the prompt: got exit code %d and tree %v, want %v", code, got, want)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+// entryType returns the --type letter describing info: "f" for regular files,
+// "d" for directories, "l" for symbolic links, and "" for anything else.
+func entryType(info archives.FileInfo) string {
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		return "f"
+	case mode.IsDir():
+		return "d"
+	case mode&fs.ModeSymlink != 0:
+		return "l"
+	default:
+		return ""
+	}
+}
+
+func typeMatches(types []string, info archives.FileInfo) bool {
+	return len(types) == 0 || slices.Contains(types, entryType(info))
+}
+
+// globHelp documents the syntax accepted by flags holding a glob.
+const globHelp = "Patterns are matched against paths in the archive, where * matches any part of a single element and ** matches any number of elements. Patterns without a slash match any element of a path, like *.o or node_modules."
+
+// glob is a flag value holding a slash-separated glob pattern, in which each
+// element is matched as by path.Match and an element of ** matches any number
+// of elements. Patterns containing a slash, other than a trailing one, are
+// matched against the whole path, with a leading slash being ignored. Others
+// are matched against any single element, so *.o matches a/b.o.
+type glob string
+
+func (g *glob) UnmarshalText(text []byte) error {
+	pattern := strings.TrimSuffix(string(text), "/")
+	if pattern == "" {
+		return fmt.Errorf("invalid pattern %q", text)
+	}
+	for _, elem := range strings.Split(pattern, "/") {
+		if _, err := path.Match(elem, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", text)
+		}
+	}
+
+	*g = glob(pattern)
+	return nil
+}
+
+// matches reports whether name, or any of its parents, matches g.
+func (g glob) matches(name string) bool {
+	pattern := string(g)
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	patternElems := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+
+	nameElems := strings.Split(strings.Trim(name, "/"), "/")
+	for i := range nameElems {
+		if matchElems(patternElems, nameElems[:i+1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := range len(name) + 1 {
+				if matchElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// filterFiles returns the files whose names in the archive, or those of their
+// parents, match any of include, or all files if include is empty, except
+// those that match any of exclude in the same way. Directories that have any
+// included descendants are kept too, so that their metadata is preserved.
+func filterFiles(files []archives.FileInfo, include, exclude []glob) []archives.FileInfo {
+	if len(include) == 0 && len(exclude) == 0 {
+		return files
+	}
+
+	matchesAny := func(globs []glob, name string) bool {
+		return slices.ContainsFunc(globs, func(g glob) bool { return g.matches(name) })
+	}
+
+	keep := make([]bool, len(files))
+	for i, file := range files {
+		name := strings.Trim(file.NameInArchive, "/")
+		if matchesAny(exclude, name) || (len(include) > 0 && !matchesAny(include, name)) {
+			continue
+		}
+
+		keep[i] = true
+	}
+	return keptWithParents(files, keep)
+}
+
+// keptWithParents returns the files that keep marks, along with the
+// directories that contain any of them, so that their metadata is preserved.
+func keptWithParents(files []archives.FileInfo, keep []bool) []archives.FileInfo {
+	needed := map[string]bool{}
+	for i, file := range files {
+		if !keep[i] {
+			continue
+		}
+		name := strings.Trim(file.NameInArchive, "/")
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			needed[dir] = true
+		}
+	}
+
+	var filtered []archives.FileInfo
+	for i, file := range files {
+		if keep[i] || (file.IsDir() && needed[strings.Trim(file.NameInArchive, "/")]) {
+			filtered = append(filtered, file)
+		}
+	}
+	return filtered
+}
+
+// timeBoundHelp documents the times held by flags holding a timeBound.
+const timeBoundHelp = "Times are given in RFC 3339 format, as a date (2006-01-02), as seconds since the Unix epoch prefixed with @, or as an age before now, like 7d, 2w or 12h."
+
+// timeBound is a flag value holding a time like a timestamp, or an age before
+// now, like 7d, 2w or 12h, in days, weeks, or any unit that
+// time.ParseDuration accepts.
+type timeBound time.Time
+
+func (t *timeBound) UnmarshalText(text []byte) error {
+	str := string(text)
+	// Ages that can't be parsed are left as zero, and parsed as timestamps
+	// instead.
+	var age time.Duration
+	if days, ok := strings.CutSuffix(str, "d"); ok {
+		n, _ := strconv.ParseFloat(days, 64)
+		age = time.Duration(n * float64(24*time.Hour))
+	} else if weeks, ok := strings.CutSuffix(str, "w"); ok {
+		n, _ := strconv.ParseFloat(weeks, 64)
+		age = time.Duration(n * float64(7*24*time.Hour))
+	} else if parsed, err := time.ParseDuration(str); err == nil {
+		age = parsed
+	}
+	if age > 0 {
+		*t = timeBound(time.Now().Add(-age))
+		return nil
+	}
+
+	var ts timestamp
+	if err := ts.UnmarshalText(text); err != nil {
+		return err
+	}
+	*t = timeBound(ts)
+	return nil
+}
+
+// filterSizesAndTimes returns the files modified after newer and before older
+// when they're given, of which the regular files are at least minSize and, if
+// maxSize isn't zero, at most maxSize, along with the directories that contain
+// any of them.
+func filterSizesAndTimes(files []archives.FileInfo, newer, older *timeBound, minSize, maxSize byteSize) []archives.FileInfo {
+	if newer == nil && older == nil && minSize == 0 && maxSize == 0 {
+		return files
+	}
+
+	keep := make([]bool, len(files))
+	for i, file := range files {
+		modTime := file.ModTime()
+		if newer != nil && !modTime.After(time.Time(*newer)) || older != nil && !modTime.Before(time.Time(*older)) {
+			continue
+		}
+		if file.Mode().IsRegular() && (file.Size() < int64(minSize) || maxSize > 0 && file.Size() > int64(maxSize)) {
+			continue
+		}
+		keep[i] = true
+	}
+	return keptWithParents(files, keep)
+}
+
+// typeHelp documents the content types matched by flags holding a
+// typePattern.
+const typeHelp = "Types are sniffed from the first 512 bytes of the contents of regular files, as by web browsers, like text/plain, image/png or application/octet-stream for unrecognized binaries, and patterns like video/* match any subtype. Other entries have no type."
+
+// sniffSize is how much of the start of a file's contents its type is
+// sniffed from.
+const sniffSize = 512
+
+// typePattern is a flag value holding a pattern matched against the content
+// types of regular files, like text/plain or video/*, in which each half is
+// matched as by path.Match. A pattern without a subtype, like video, matches
+// any subtype.
+type typePattern string
+
+func (p *typePattern) UnmarshalText(text []byte) error {
+	pattern := strings.ToLower(string(text))
+	if !strings.Contains(pattern, "/") {
+		pattern += "/*"
+	}
+	mediaType, subtype, _ := strings.Cut(pattern, "/")
+	if _, err := path.Match(mediaType, ""); err != nil || mediaType == "" || subtype == "" || strings.Contains(subtype, "/") {
+		return fmt.Errorf("invalid type pattern %q", text)
+	}
+	if _, err := path.Match(subtype, ""); err != nil {
+		return fmt.Errorf("invalid type pattern %q", text)
+	}
+
+	*p = typePattern(pattern)
+	return nil
+}
+
+func (p typePattern) matches(contentType string) bool {
+	ok, _ := path.Match(string(p), contentType)
+	return ok
+}
+
+// sniffType returns the content type of the contents that start with head,
+// without any parameters like charset.
+func sniffType(head []byte) string {
+	contentType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return contentType
+}
+
+// typeSelected reports whether contentType matches any of include, or include
+// is empty, and doesn't match any of exclude.
+func typeSelected(contentType string, include, exclude []typePattern) bool {
+	matchesAny := func(patterns []typePattern) bool {
+		return slices.ContainsFunc(patterns, func(p typePattern) bool { return p.matches(contentType) })
+	}
+	return !matchesAny(exclude) && (len(include) == 0 || matchesAny(include))
+}
+
+// filterTypes returns the regular files in files whose content types are
+// selected by include and exclude, as reported by typeSelected, along with
+// the directories that contain them, and the other entries if include is
+// empty. Each file is opened to sniff its type, and reopened when it's
+// archived.
+func filterTypes(files []archives.FileInfo, include, exclude []typePattern) ([]archives.FileInfo, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return files, nil
+	}
+
+	keep := make([]bool, len(files))
+	head := make([]byte, sniffSize)
+	for i, file := range files {
+		if !file.Mode().IsRegular() {
+			keep[i] = len(include) == 0
+			continue
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.ReadFull(f, head)
+		f.Close()
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%s: %w", file.NameInArchive, err)
+		}
+		if !typeSelected(sniffType(head[:n]), include, exclude) {
+			continue
+		}
+
+		keep[i] = true
+	}
+	return keptWithParents(files, keep), nil
+}
+
+// sniffedFile is an entry whose head was read to sniff its type, which is
+// read again before the rest of its contents.
+type sniffedFile struct {
+	fs.File
+	r io.Reader
+}
+
+func (f *sniffedFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+// sniffEntry reports whether the content type of info is selected by include
+// and exclude, as reported by typeSelected, returning it with Open replaced to
+// return the entry that was opened to sniff it, since the entries of streamed
+// archives can only be read once. Entries that aren't regular files are only
+// selected if include is empty.
+func sniffEntry(info archives.FileInfo, include, exclude []typePattern) (archives.FileInfo, bool, error) {
+	if !info.Mode().IsRegular() {
+		return info, len(include) == 0, nil
+	}
+
+	f, err := info.Open()
+	if err != nil {
+		return info, false, err
+	}
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		f.Close()
+		return info, false, err
+	}
+	if !typeSelected(sniffType(head[:n]), include, exclude) {
+		return info, false, f.Close()
+	}
+
+	sniffed := &sniffedFile{File: f, r: io.MultiReader(bytes.NewReader(head[:n]), f)}
+	info.Open = func() (fs.File, error) { return sniffed, nil }
+	return info, true, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func TestGlobMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.o", "a.o", true},
+		{"*.o", "a/b/c.o", true},
+		{"*.o", "a.o/b", true},
+		{"*.o", "a.go", false},
+		{"node_modules", "a/node_modules/b/c.js", true},
+		{"node_modules/", "node_modules", true},
+		{"a/b", "a/b/c", true},
+		{"a/b", "x/a/b", false},
+		{"/a/b", "a/b", true},
+		{"a/**/c", "a/c", true},
+		{"a/**/c", "a/b/b/c", true},
+		{"a/**/c", "b/a/c", false},
+		{"**/*.go", "a/b.go", true},
+		{"a/*/c", "a/b/b/c", false},
+	}
+
+	for _, test := range tests {
+		var g glob
+		if err := g.UnmarshalText([]byte(test.pattern)); err != nil {
+			t.Errorf("%s: %s", test.pattern, err)
+			continue
+		}
+
+		if got := g.matches(test.name); got != test.want {
+			t.Errorf("%s matching %s: got %t, want %t", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestGlobInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "/", "[", "a/[/b"} {
+		var g glob
+		if err := g.UnmarshalText([]byte(pattern)); err == nil {
+			t.Errorf("%q: got no error", pattern)
+		}
+	}
+}
+
+func TestFilterFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"p/src/a.go":          {},
+		"p/src/a.o":           {},
+		"p/src/deep/b.go":     {},
+		"p/node_modules/x.js": {},
+		"p/README":            {},
+	}
+	var files []archives.FileInfo
+	for _, name := range []string{"p", "p/src", "p/src/a.go", "p/src/a.o", "p/src/deep", "p/src/deep/b.go", "p/node_modules", "p/node_modules/x.js", "p/README"} {
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, archives.FileInfo{FileInfo: info, NameInArchive: name})
+	}
+
+	tests := []struct {
+		include, exclude []glob
+		want             []string
+	}{
+		{
+			want: []string{"p", "p/src", "p/src/a.go", "p/src/a.o", "p/src/deep", "p/src/deep/b.go", "p/node_modules", "p/node_modules/x.js", "p/README"},
+		},
+		{
+			exclude: []glob{"node_modules", "*.o"},
+			want:    []string{"p", "p/src", "p/src/a.go", "p/src/deep", "p/src/deep/b.go", "p/README"},
+		},
+		{
+			include: []glob{"*.go"},
+			want:    []string{"p", "p/src", "p/src/a.go", "p/src/deep", "p/src/deep/b.go"},
+		},
+		{
+			include: []glob{"p/src"},
+			exclude: []glob{"deep"},
+			want:    []string{"p", "p/src", "p/src/a.go", "p/src/a.o"},
+		},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, file := range filterFiles(files, test.include, test.exclude) {
+			got = append(got, file.NameInArchive)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("include %q, exclude %q: got %v, want %v", test.include, test.exclude, got, test.want)
+		}
+	}
+}
+
+func TestFilterTypes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"p/notes.txt":      {Data: []byte("hello\n")},
+		"p/img/photo.jpg":  {Data: []byte("\xff\xd8\xff\xe0")},
+		"p/img/mislabeled": {Data: []byte("\x89PNG\r\n\x1a\n")},
+		"p/empty":          {Mode: fs.ModeDir},
+	}
+	var files []archives.FileInfo
+	for _, name := range []string{"p", "p/notes.txt", "p/img", "p/img/photo.jpg", "p/img/mislabeled", "p/empty"} {
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, archives.FileInfo{FileInfo: info, NameInArchive: name, Open: func() (fs.File, error) { return fsys.Open(name) }})
+	}
+
+	tests := []struct {
+		include, exclude []string
+		want             []string
+	}{
+		{
+			want: []string{"p", "p/notes.txt", "p/img", "p/img/photo.jpg", "p/img/mislabeled", "p/empty"},
+		},
+		{
+			include: []string{"image/*"},
+			exclude: []string{"image/jpeg"},
+			want:    []string{"p", "p/img", "p/img/mislabeled"},
+		},
+		{
+			exclude: []string{"image"},
+			want:    []string{"p", "p/notes.txt", "p/img", "p/empty"},
+		},
+	}
+
+	for _, test := range tests {
+		var include, exclude []typePattern
+		for _, patterns := range []struct {
+			text []string
+			dst  *[]typePattern
+		}{{test.include, &include}, {test.exclude, &exclude}} {
+			for _, text := range patterns.text {
+				var p typePattern
+				if err := p.UnmarshalText([]byte(text)); err != nil {
+					t.Fatal(err)
+				}
+				*patterns.dst = append(*patterns.dst, p)
+			}
+		}
+
+		filtered, err := filterTypes(files, include, exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, file := range filtered {
+			got = append(got, file.NameInArchive)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("include %q, exclude %q: got %v, want %v", test.include, test.exclude, got, test.want)
+		}
+	}
+}
+
+func TestFilterSizesAndTimes(t *testing.T) {
+	now := time.Now()
+	fsys := fstest.MapFS{
+		"logs":           {Mode: fs.ModeDir, ModTime: now.Add(-30 * 24 * time.Hour)},
+		"logs/new.log":   {Data: []byte("new"), ModTime: now.Add(-time.Hour)},
+		"logs/huge.log":  {Data: make([]byte, 2048), ModTime: now.Add(-time.Hour)},
+		"logs/old":       {Mode: fs.ModeDir, ModTime: now.Add(-30 * 24 * time.Hour)},
+		"logs/old/a.log": {Data: []byte("old"), ModTime: now.Add(-30 * 24 * time.Hour)},
+	}
+	var files []archives.FileInfo
+	for _, name := range []string{"logs", "logs/new.log", "logs/huge.log", "logs/old", "logs/old/a.log"} {
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, archives.FileInfo{FileInfo: info, NameInArchive: name})
+	}
+
+	week := timeBound(now.Add(-7 * 24 * time.Hour))
+	tests := []struct {
+		newer, older     *timeBound
+		minSize, maxSize byteSize
+		want             []string
+	}{
+		{want: []string{"logs", "logs/new.log", "logs/huge.log", "logs/old", "logs/old/a.log"}},
+		{newer: &week, maxSize: 1024, want: []string{"logs", "logs/new.log"}},
+		{older: &week, want: []string{"logs", "logs/old", "logs/old/a.log"}},
+		{minSize: 1024, want: []string{"logs", "logs/huge.log", "logs/old"}},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, file := range filterSizesAndTimes(files, test.newer, test.older, test.minSize, test.maxSize) {
+			got = append(got, file.NameInArchive)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+func TestTimeBound(t *testing.T) {
+	for text, age := range map[string]time.Duration{"7d": 7 * 24 * time.Hour, "2w": 14 * 24 * time.Hour, "12h": 12 * time.Hour} {
+		var bound timeBound
+		if err := bound.UnmarshalText([]byte(text)); err != nil {
+			t.Fatal(err)
+		}
+		if got := time.Since(time.Time(bound)); got < age || got > age+time.Minute {
+			t.Errorf("%s: got an age of %s, want %s", text, got, age)
+		}
+	}
+
+	var bound timeBound
+	if err := bound.UnmarshalText([]byte("2024-03-01")); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC); !time.Time(bound).Equal(want) {
+		t.Errorf("got %s, want %s", time.Time(bound), want)
+	}
+	if err := bound.UnmarshalText([]byte("soon")); err == nil {
+		t.Error("got no error for an invalid time")
+	}
+}
+
+func TestSniffEntry(t *testing.T) {
+	data := "#!/bin/sh\necho hello\n"
+	fsys := fstest.MapFS{"script": {Data: []byte(data)}}
+	info, err := fsys.Stat("script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The entry can only be opened once, like those of streamed archives.
+	opened := false
+	file := archives.FileInfo{FileInfo: info, NameInArchive: "script", Open: func() (fs.File, error) {
+		if opened {
+			return nil, errors.New("already opened")
+		}
+		opened = true
+		return fsys.Open("script")
+	}}
+
+	file, selected, err := sniffEntry(file, []typePattern{"text/*"}, nil)
+	if err != nil || !selected {
+		t.Fatalf("got selected %t, error %v, want the entry to be selected", selected, err)
+	}
+	f, err := file.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got, err := io.ReadAll(f); err != nil || string(got) != data {
+		t.Errorf("read %q, %v, want %q", got, err, data)
+	}
+}
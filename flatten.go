@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// shouldFlatten reports whether the output directory should be flattened once
+// the archive is extracted into it: with --flatten, or by default when the
+// output is named after the archive, and only if it's empty, so that nothing
+// but what was extracted is moved.
+func shouldFlatten(output string) bool {
+	flatten := cli.Extract.Output == nil
+	if cli.Extract.Flatten != nil {
+		flatten = *cli.Extract.Flatten
+	}
+	if !flatten || cli.Extract.DryRun || cli.Extract.Resume {
+		return false
+	}
+
+	dir, err := os.Open(output)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	_, err = dir.Readdirnames(1)
+	return errors.Is(err, io.EOF)
+}
+
+// flattenOutput moves the contents of the only entry in output up into it if
+// that's a directory, so that archives of a single directory, like foo.tar.gz
+// containing foo, aren't extracted to foo/foo, and gives output its mode, and
+// its modification time if times is true. It reports whether it did.
+func flattenOutput(output string, times bool) (bool, error) {
+	entries, err := os.ReadDir(output)
+	// Symbolic links to directories aren't followed, so that nothing outside
+	// of the output is moved.
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return false, err
+	}
+	info, err := entries[0].Info()
+	if err != nil {
+		return false, err
+	}
+
+	// The directory is renamed first, since it may contain an entry with
+	// its own name.
+	dir := filepath.Join(output, "."+entries[0].Name()+".flatten")
+	if err := os.Rename(filepath.Join(output, entries[0].Name()), dir); err != nil {
+		return false, err
+	}
+	children, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, child := range children {
+		if err := os.Rename(filepath.Join(dir, child.Name()), filepath.Join(output, child.Name())); err != nil {
+			return false, err
+		}
+	}
+	if err := os.Remove(dir); err != nil {
+		return false, err
+	}
+
+	if err := os.Chmod(output, info.Mode().Perm()); err != nil {
+		return true, err
+	}
+	if times {
+		return true, os.Chtimes(output, info.ModTime(), info.ModTime())
+	}
+	return true, nil
+}
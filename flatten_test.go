@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFlattenOutput(t *testing.T) {
+	output := t.TempDir()
+	for _, name := range []string{"foo/foo/a", "foo/b"} {
+		path := filepath.Join(output, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flattened, err := flattenOutput(output, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flattened {
+		t.Fatal("the output wasn't flattened")
+	}
+	var got []string
+	filepath.WalkDir(output, func(path string, d os.DirEntry, err error) error {
+		if rel, _ := filepath.Rel(output, path); err == nil && rel != "." {
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if want := []string{"b", "foo", "foo/a"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFlattenOutputSeveralRoots(t *testing.T) {
+	output := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(output, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if flattened, err := flattenOutput(output, true); err != nil || flattened {
+		t.Errorf("got %t, %v, want the output left alone", flattened, err)
+	}
+
+	link := t.TempDir()
+	if err := os.Symlink(output, filepath.Join(link, "l")); err != nil {
+		t.Fatal(err)
+	}
+	if flattened, err := flattenOutput(link, true); err != nil || flattened {
+		t.Errorf("got %t, %v, want a symbolic link left alone", flattened, err)
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+// formatHelp documents the names accepted by --format.
+const formatHelp = "Accepted formats are tar, zip, 7z, and rar archives; gz (gzip), bz2 (bzip2), xz, zst (zstd), lz4, br (brotli), sz (snappy), lz (lzip), and zz (zlib) compression; archives combined with compression such as tar.gz; the shorthands tgz, tbz2 (tbz), txz, and tzst; and the zip variants jar, war, ear, apk, epub, and cbz. Formats run by other programs can be added in the config file."
+
+// levelHelp documents the levels accepted by --level.
+const levelHelp = "Levels are 1 to 9 for gz (gzip), bz2 (bzip2), zz (zlib), lz4, and zip, whose files are compressed with deflate when a level is given, 1 to 22 for zst (zstd), and 0 to 11 for br (brotli). Higher levels compress better, but more slowly. Other formats don't have levels."
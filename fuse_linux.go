@@ -0,0 +1,519 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+// This file implements just enough of the FUSE kernel protocol to serve an
+// fs.FS as a read-only file system. See the fuse(4) man page and
+// include/uapi/linux/fuse.h in the Linux source for the wire format.
+
+const (
+	fuseLookup      = 1
+	fuseForget      = 2
+	fuseGetattr     = 3
+	fuseReadlink    = 5
+	fuseOpen        = 14
+	fuseRead        = 15
+	fuseStatfs      = 17
+	fuseRelease     = 18
+	fuseFlush       = 25
+	fuseInit        = 26
+	fuseOpendir     = 27
+	fuseReaddir     = 28
+	fuseReleasedir  = 29
+	fuseAccess      = 34
+	fuseInterrupt   = 36
+	fuseDestroy     = 38
+	fuseBatchForget = 42
+)
+
+const (
+	fuseRootID        = 1
+	fuseInHeaderSize  = 40
+	fuseOutHeaderSize = 16
+	fuseMaxRead       = 128 << 10
+	fuseKeepCache     = 1 << 1
+	fuseTimeout       = time.Hour
+)
+
+type fuseNode struct {
+	path     string
+	info     fs.FileInfo
+	children map[string]uint64
+	names    []string
+}
+
+type fuseHandle struct {
+	node *fuseNode
+	file fs.File
+}
+
+// fuseServer serves requests read from dev, the FUSE device, which returns one
+// request from each read, and takes one reply from each write.
+type fuseServer struct {
+	fsys    fs.FS
+	dev     io.ReadWriter
+	nodes   []*fuseNode
+	handles map[uint64]*fuseHandle
+	nextFh  uint64
+}
+
+// newFuseServer indexes every entry of fsys up front, so that lookups and
+// directory listings never need to rescan the archive.
+func newFuseServer(fsys fs.FS, dev io.ReadWriter) (*fuseServer, error) {
+	rootInfo, err := fs.Stat(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fuseServer{
+		fsys:    seekableFS{fsys},
+		dev:     dev,
+		nodes:   []*fuseNode{nil, {path: ".", info: rootInfo, children: map[string]uint64{}}},
+		handles: map[uint64]*fuseHandle{},
+	}
+	ids := map[string]uint64{".": fuseRootID}
+
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		parent := s.nodes[ids[path.Dir(p)]]
+		node := &fuseNode{path: p, info: info}
+		if info.IsDir() {
+			node.children = map[string]uint64{}
+		}
+
+		id := uint64(len(s.nodes))
+		s.nodes = append(s.nodes, node)
+		ids[p] = id
+		parent.children[path.Base(p)] = id
+		parent.names = append(parent.names, path.Base(p))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range s.nodes[1:] {
+		slices.Sort(node.names)
+	}
+
+	return s, nil
+}
+
+// serve handles requests until the file system is unmounted.
+func (s *fuseServer) serve() error {
+	buf := make([]byte, fuseMaxRead+2*os.Getpagesize())
+	for {
+		n, err := s.dev.Read(buf)
+		if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOENT) {
+			// Interrupted, or the request was aborted before we read it.
+			continue
+		} else if errors.Is(err, syscall.ENODEV) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if n < fuseInHeaderSize {
+			return fmt.Errorf("short request of %d bytes", n)
+		}
+
+		opcode := binary.NativeEndian.Uint32(buf[4:])
+		unique := binary.NativeEndian.Uint64(buf[8:])
+		nodeID := binary.NativeEndian.Uint64(buf[16:])
+		body := buf[fuseInHeaderSize:n]
+
+		switch opcode {
+		case fuseForget, fuseBatchForget, fuseInterrupt:
+			// These never receive replies.
+			continue
+		case fuseDestroy:
+			return nil
+		}
+
+		reply, errno := s.handle(opcode, nodeID, body)
+		if err := s.reply(unique, reply, errno); err != nil && !errors.Is(err, syscall.ENOENT) {
+			return err
+		}
+	}
+}
+
+func (s *fuseServer) reply(unique uint64, reply []byte, errno syscall.Errno) error {
+	out := make([]byte, fuseOutHeaderSize, fuseOutHeaderSize+len(reply))
+	binary.NativeEndian.PutUint32(out[0:], uint32(fuseOutHeaderSize+len(reply)))
+	binary.NativeEndian.PutUint32(out[4:], uint32(-int32(errno)))
+	binary.NativeEndian.PutUint64(out[8:], unique)
+	out = append(out, reply...)
+
+	_, err := s.dev.Write(out)
+	return err
+}
+
+func (s *fuseServer) node(id uint64) *fuseNode {
+	if id == 0 || id >= uint64(len(s.nodes)) {
+		return nil
+	}
+	return s.nodes[id]
+}
+
+func (s *fuseServer) handle(opcode uint32, nodeID uint64, body []byte) ([]byte, syscall.Errno) {
+	switch opcode {
+	case fuseInit:
+		return s.init(body), 0
+
+	case fuseLookup:
+		parent := s.node(nodeID)
+		if parent == nil || parent.children == nil {
+			return nil, syscall.ENOENT
+		}
+		name, _, _ := cutNul(body)
+		id, ok := parent.children[name]
+		if !ok {
+			return nil, syscall.ENOENT
+		}
+		return s.entryOut(id), 0
+
+	case fuseGetattr:
+		if s.node(nodeID) == nil {
+			return nil, syscall.ENOENT
+		}
+		out := make([]byte, 16)
+		putDuration(out[0:], out[8:], fuseTimeout)
+		return append(out, s.attr(nodeID)...), 0
+
+	case fuseReadlink:
+		node := s.node(nodeID)
+		if node == nil {
+			return nil, syscall.ENOENT
+		}
+		if node.info.Mode()&fs.ModeSymlink == 0 {
+			return nil, syscall.EINVAL
+		}
+		target, err := s.readlink(node)
+		if err != nil {
+			return nil, syscall.EIO
+		}
+		return []byte(target), 0
+
+	case fuseOpen, fuseOpendir:
+		node := s.node(nodeID)
+		if node == nil {
+			return nil, syscall.ENOENT
+		}
+		if flags := binary.NativeEndian.Uint32(body); flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+			return nil, syscall.EROFS
+		}
+
+		s.nextFh++
+		handle := &fuseHandle{node: node}
+		if opcode == fuseOpen {
+			if node.info.IsDir() {
+				return nil, syscall.EISDIR
+			}
+			file, err := s.fsys.Open(node.path)
+			if err != nil {
+				return nil, syscall.EIO
+			}
+			handle.file = file
+		}
+		s.handles[s.nextFh] = handle
+
+		out := make([]byte, 16)
+		binary.NativeEndian.PutUint64(out[0:], s.nextFh)
+		binary.NativeEndian.PutUint32(out[8:], fuseKeepCache)
+		return out, 0
+
+	case fuseRead:
+		handle, ok := s.handles[binary.NativeEndian.Uint64(body[0:])]
+		if !ok {
+			return nil, syscall.EBADF
+		}
+		offset := int64(binary.NativeEndian.Uint64(body[8:]))
+		size := binary.NativeEndian.Uint32(body[16:])
+		data, err := s.read(handle, offset, min(size, fuseMaxRead))
+		if err != nil {
+			return nil, syscall.EIO
+		}
+		return data, 0
+
+	case fuseReaddir:
+		handle, ok := s.handles[binary.NativeEndian.Uint64(body[0:])]
+		if !ok || handle.node.children == nil {
+			return nil, syscall.EBADF
+		}
+		offset := binary.NativeEndian.Uint64(body[8:])
+		size := binary.NativeEndian.Uint32(body[16:])
+		return s.readdir(handle.node, offset, int(size)), 0
+
+	case fuseRelease, fuseReleasedir:
+		fh := binary.NativeEndian.Uint64(body[0:])
+		if handle, ok := s.handles[fh]; ok && handle.file != nil {
+			handle.file.Close()
+		}
+		delete(s.handles, fh)
+		return nil, 0
+
+	case fuseStatfs:
+		var blocks uint64
+		for _, node := range s.nodes[1:] {
+			blocks += uint64(node.info.Size()+4095) / 4096
+		}
+		out := make([]byte, 80)
+		binary.NativeEndian.PutUint64(out[0:], blocks)
+		binary.NativeEndian.PutUint64(out[24:], uint64(len(s.nodes)-1))
+		binary.NativeEndian.PutUint32(out[40:], 4096)
+		binary.NativeEndian.PutUint32(out[44:], 255)
+		binary.NativeEndian.PutUint32(out[48:], 4096)
+		return out, 0
+
+	case fuseFlush, fuseAccess:
+		return nil, 0
+
+	default:
+		return nil, syscall.ENOSYS
+	}
+}
+
+func (s *fuseServer) init(body []byte) []byte {
+	major := binary.NativeEndian.Uint32(body[0:])
+	minor := binary.NativeEndian.Uint32(body[4:])
+	maxReadahead := binary.NativeEndian.Uint32(body[8:])
+
+	out := make([]byte, 64)
+	binary.NativeEndian.PutUint32(out[0:], min(major, 7))
+	binary.NativeEndian.PutUint32(out[4:], min(minor, 31))
+	binary.NativeEndian.PutUint32(out[8:], maxReadahead)
+	binary.NativeEndian.PutUint16(out[16:], 16)          // max_background
+	binary.NativeEndian.PutUint16(out[18:], 12)          // congestion_threshold
+	binary.NativeEndian.PutUint32(out[20:], fuseMaxRead) // max_write
+	binary.NativeEndian.PutUint32(out[24:], 1)           // time_gran
+
+	// Kernels older than 7.23 expect the shorter, original reply.
+	if minor < 23 {
+		return out[:24]
+	}
+	return out
+}
+
+func (s *fuseServer) entryOut(id uint64) []byte {
+	out := make([]byte, 40)
+	binary.NativeEndian.PutUint64(out[0:], id)
+	putDuration(out[16:], out[32:], fuseTimeout)
+	putDuration(out[24:], out[36:], fuseTimeout)
+	return append(out, s.attr(id)...)
+}
+
+func (s *fuseServer) attr(id uint64) []byte {
+	info := s.nodes[id].info
+
+	mode := uint32(info.Mode().Perm())
+	nlink := uint32(1)
+	switch {
+	case info.IsDir():
+		mode |= syscall.S_IFDIR
+		nlink = 2
+		if info.Mode().Perm() == 0 {
+			mode |= 0o755
+		}
+	case info.Mode()&fs.ModeSymlink != 0:
+		mode |= syscall.S_IFLNK | 0o777
+	default:
+		mode |= syscall.S_IFREG
+		if info.Mode().Perm() == 0 {
+			mode |= 0o644
+		}
+	}
+
+	size := uint64(max(info.Size(), 0))
+	mtime := info.ModTime()
+
+	out := make([]byte, 88)
+	binary.NativeEndian.PutUint64(out[0:], id)
+	binary.NativeEndian.PutUint64(out[8:], size)
+	binary.NativeEndian.PutUint64(out[16:], (size+511)/512)
+	for _, offset := range []int{24, 32, 40} {
+		binary.NativeEndian.PutUint64(out[offset:], uint64(max(mtime.Unix(), 0)))
+		binary.NativeEndian.PutUint32(out[48+(offset-24)/2:], uint32(mtime.Nanosecond()))
+	}
+	binary.NativeEndian.PutUint32(out[60:], mode)
+	binary.NativeEndian.PutUint32(out[64:], nlink)
+	binary.NativeEndian.PutUint32(out[68:], uint32(os.Getuid()))
+	binary.NativeEndian.PutUint32(out[72:], uint32(os.Getgid()))
+	binary.NativeEndian.PutUint32(out[80:], 4096)
+	return out
+}
+
+// read returns up to size bytes of handle's file starting at offset.
+func (s *fuseServer) read(handle *fuseHandle, offset int64, size uint32) ([]byte, error) {
+	if _, err := handle.file.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	n, err := io.ReadFull(handle.file, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (s *fuseServer) readlink(node *fuseNode) (string, error) {
+	if info, ok := node.info.(archives.FileInfo); ok && info.LinkTarget != "" {
+		return info.LinkTarget, nil
+	}
+	if header, ok := node.info.Sys().(*tar.Header); ok {
+		return header.Linkname, nil
+	}
+
+	// Formats without a dedicated link target field, like zip, store the
+	// target as the entry's contents.
+	file, err := s.fsys.Open(node.path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	target, err := io.ReadAll(file)
+	return string(target), err
+}
+
+func (s *fuseServer) readdir(node *fuseNode, offset uint64, size int) []byte {
+	var out []byte
+	for i := offset; i < uint64(len(node.names)); i++ {
+		name := node.names[i]
+		id := node.children[name]
+
+		entrySize := (24 + len(name) + 7) &^ 7
+		if len(out)+entrySize > size {
+			break
+		}
+
+		entry := make([]byte, entrySize)
+		binary.NativeEndian.PutUint64(entry[0:], id)
+		binary.NativeEndian.PutUint64(entry[8:], i+1)
+		binary.NativeEndian.PutUint32(entry[16:], uint32(len(name)))
+		binary.NativeEndian.PutUint32(entry[20:], binary.NativeEndian.Uint32(s.attr(id)[60:])>>12)
+		copy(entry[24:], name)
+		out = append(out, entry...)
+	}
+	return out
+}
+
+func putDuration(seconds, nanoseconds []byte, d time.Duration) {
+	binary.NativeEndian.PutUint64(seconds, uint64(d/time.Second))
+	binary.NativeEndian.PutUint32(nanoseconds, uint32(d%time.Second))
+}
+
+func cutNul(b []byte) (string, []byte, bool) {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i]), b[i+1:], true
+		}
+	}
+	return string(b), nil, false
+}
+
+// fuseMount mounts a read-only FUSE file system at mountpoint, returning the
+// device to serve it from. Mounting directly requires CAP_SYS_ADMIN, so
+// unprivileged users fall back to the setuid fusermount helper.
+func fuseMount(mountpoint string) (*os.File, error) {
+	dev, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data := fmt.Sprintf("fd=%d,rootmode=%o,user_id=%d,group_id=%d", dev.Fd(), syscall.S_IFDIR, os.Getuid(), os.Getgid())
+	err = syscall.Mount("squish", mountpoint, "fuse.squish", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, data)
+	if err == nil {
+		return dev, nil
+	}
+	dev.Close()
+	if !errors.Is(err, syscall.EPERM) {
+		return nil, err
+	}
+
+	return fusermount(mountpoint)
+}
+
+func fusermount(mountpoint string) (*os.File, error) {
+	bin, err := exec.LookPath("fusermount3")
+	if err != nil {
+		if bin, err = exec.LookPath("fusermount"); err != nil {
+			return nil, errors.New("mounting requires either root privileges or fusermount")
+		}
+	}
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	local := os.NewFile(uintptr(fds[0]), "fusermount")
+	remote := os.NewFile(uintptr(fds[1]), "fusermount")
+	defer local.Close()
+	defer remote.Close()
+
+	cmd := exec.Command(bin, "-o", "ro,nosuid,nodev,fsname=squish,subtype=squish", "--", mountpoint)
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w", bin, err)
+	}
+
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := syscall.Recvmsg(fds[0], make([]byte, 1), oob, 0)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) == 0 {
+		return nil, fmt.Errorf("%s didn't send a file descriptor", bin)
+	}
+	rights, err := syscall.ParseUnixRights(&messages[0])
+	if err != nil || len(rights) == 0 {
+		return nil, fmt.Errorf("%s didn't send a file descriptor", bin)
+	}
+
+	return os.NewFile(uintptr(rights[0]), "/dev/fuse"), nil
+}
+
+func fuseUnmount(mountpoint string) error {
+	err := syscall.Unmount(mountpoint, syscall.MNT_DETACH)
+	if !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+
+	bin, lookErr := exec.LookPath("fusermount3")
+	if lookErr != nil {
+		if bin, lookErr = exec.LookPath("fusermount"); lookErr != nil {
+			return err
+		}
+	}
+	return exec.Command(bin, "-u", "-z", "--", mountpoint).Run()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"slices"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeFuseDevice serves requests to a fuseServer as the FUSE device would,
+// recording its replies.
+type fakeFuseDevice struct {
+	requests [][]byte
+	replies  [][]byte
+}
+
+func (d *fakeFuseDevice) Read(p []byte) (int, error) {
+	if len(d.requests) == 0 {
+		return 0, syscall.ENODEV
+	}
+	n := copy(p, d.requests[0])
+	d.requests = d.requests[1:]
+	return n, nil
+}
+
+func (d *fakeFuseDevice) Write(p []byte) (int, error) {
+	d.replies = append(d.replies, slices.Clone(p))
+	return len(p), nil
+}
+
+// request queues a request with the given opcode for the node nodeID, whose
+// reply has the header unique, and whose body is made of fields.
+func (d *fakeFuseDevice) request(opcode uint32, unique, nodeID uint64, fields ...any) {
+	var body bytes.Buffer
+	for _, field := range fields {
+		if s, ok := field.(string); ok {
+			body.WriteString(s + "\x00")
+		} else {
+			binary.Write(&body, binary.NativeEndian, field)
+		}
+	}
+	header := make([]byte, fuseInHeaderSize)
+	binary.NativeEndian.PutUint32(header[0:], uint32(fuseInHeaderSize+body.Len()))
+	binary.NativeEndian.PutUint32(header[4:], opcode)
+	binary.NativeEndian.PutUint64(header[8:], unique)
+	binary.NativeEndian.PutUint64(header[16:], nodeID)
+	d.requests = append(d.requests, append(header, body.Bytes()...))
+}
+
+func TestFuseServer(t *testing.T) {
+	fsys := fstest.MapFS{
+		"b.txt":     {Data: []byte("hello, world"), Mode: 0o644},
+		"dir/a.txt": {Data: []byte("a"), Mode: 0o600},
+		"link":      {Mode: fs.ModeSymlink | 0o777, Sys: &tar.Header{Linkname: "b.txt"}},
+	}
+	dev := &fakeFuseDevice{}
+	server, err := newFuseServer(fsys, dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries are numbered in the order they're walked, after the root.
+	const bID, dirID, linkID = 2, 3, 5
+
+	dev.request(fuseInit, 1, 0, uint32(7), uint32(31), uint32(65536), uint32(0))
+	dev.request(fuseLookup, 2, fuseRootID, "dir")
+	dev.request(fuseLookup, 3, fuseRootID, "missing")
+	dev.request(fuseGetattr, 4, bID, uint32(0), uint32(0), uint64(0))
+	dev.request(fuseOpen, 5, bID, uint32(syscall.O_RDONLY), uint32(0))
+	dev.request(fuseRead, 6, bID, uint64(1), uint64(7), uint32(100), uint32(0))
+	dev.request(fuseOpen, 7, bID, uint32(syscall.O_WRONLY), uint32(0))
+	dev.request(fuseOpendir, 8, fuseRootID, uint32(syscall.O_RDONLY), uint32(0))
+	dev.request(fuseReaddir, 9, fuseRootID, uint64(2), uint64(0), uint32(4096), uint32(0))
+	dev.request(fuseReaddir, 10, fuseRootID, uint64(2), uint64(1), uint32(32), uint32(0))
+	dev.request(fuseReadlink, 11, linkID)
+	dev.request(fuseForget, 12, bID, uint64(1))
+	dev.request(fuseDestroy, 13, 0)
+	if err := server.serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	replies := map[uint64][]byte{}
+	errnos := map[uint64]syscall.Errno{}
+	for _, reply := range dev.replies {
+		if got := binary.NativeEndian.Uint32(reply[0:]); int(got) != len(reply) {
+			t.Errorf("reply of %d bytes has length %d", len(reply), got)
+		}
+		unique := binary.NativeEndian.Uint64(reply[8:])
+		replies[unique] = reply[fuseOutHeaderSize:]
+		errnos[unique] = syscall.Errno(-int32(binary.NativeEndian.Uint32(reply[4:])))
+	}
+	if len(dev.replies) != 11 {
+		t.Errorf("got %d replies, want one to each request but forget and destroy", len(dev.replies))
+	}
+
+	if init := replies[1]; len(init) != 64 || binary.NativeEndian.Uint32(init[0:]) != 7 || binary.NativeEndian.Uint32(init[4:]) != 31 {
+		t.Errorf("got init reply %v", init)
+	}
+
+	// The attributes start after the node ID, generation and timeouts of
+	// entries, and after the timeout of getattr replies.
+	if entry := replies[2]; len(entry) != 128 || binary.NativeEndian.Uint64(entry[0:]) != dirID || binary.NativeEndian.Uint32(entry[40+60:])&syscall.S_IFMT != syscall.S_IFDIR {
+		t.Errorf("got lookup reply %v", entry)
+	}
+	if errnos[3] != syscall.ENOENT {
+		t.Errorf("got error %v looking up a missing entry", errnos[3])
+	}
+	if attr := replies[4]; len(attr) != 104 || binary.NativeEndian.Uint64(attr[16+8:]) != 12 || binary.NativeEndian.Uint32(attr[16+60:]) != syscall.S_IFREG|0o644 {
+		t.Errorf("got getattr reply %v", attr)
+	}
+
+	if open := replies[5]; len(open) != 16 || binary.NativeEndian.Uint64(open[0:]) != 1 {
+		t.Errorf("got open reply %v", open)
+	}
+	if got := string(replies[6]); got != "world" {
+		t.Errorf("read %q from offset 7", got)
+	}
+	if errnos[7] != syscall.EROFS {
+		t.Errorf("got error %v opening for writing", errnos[7])
+	}
+
+	type dirent struct {
+		name      string
+		id, off   uint64
+		entryType uint32
+	}
+	parseDirents := func(data []byte) []dirent {
+		var entries []dirent
+		for len(data) >= 24 {
+			nameLen := int(binary.NativeEndian.Uint32(data[16:]))
+			entries = append(entries, dirent{
+				name:      string(data[24 : 24+nameLen]),
+				id:        binary.NativeEndian.Uint64(data[0:]),
+				off:       binary.NativeEndian.Uint64(data[8:]),
+				entryType: binary.NativeEndian.Uint32(data[20:]),
+			})
+			data = data[(24+nameLen+7)&^7:]
+		}
+		return entries
+	}
+	want := []dirent{
+		{"b.txt", bID, 1, syscall.S_IFREG >> 12},
+		{"dir", dirID, 2, syscall.S_IFDIR >> 12},
+		{"link", linkID, 3, syscall.S_IFLNK >> 12},
+	}
+	if got := parseDirents(replies[9]); !slices.Equal(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+	// Entries are listed from the offset, as many as fit.
+	if got := parseDirents(replies[10]); !slices.Equal(got, want[1:2]) {
+		t.Errorf("got entries %v from offset 1, want %v", got, want[1:2])
+	}
+
+	if got := string(replies[11]); got != "b.txt" {
+		t.Errorf("got link target %q", got)
+	}
+}
+
+func TestFuseInitOldKernel(t *testing.T) {
+	body := make([]byte, 16)
+	binary.NativeEndian.PutUint32(body[0:], 7)
+	binary.NativeEndian.PutUint32(body[4:], 22)
+	out := (&fuseServer{}).init(body)
+	if len(out) != 24 || binary.NativeEndian.Uint32(out[4:]) != 22 {
+		t.Errorf("got init reply %v for protocol 7.22", out)
+	}
+}
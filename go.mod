@@ -5,27 +5,31 @@ go 1.22.2
 toolchain go1.23.4
 
 require (
+	filippo.io/age v1.2.1
+	github.com/BurntSushi/toml v1.6.0
 	github.com/alecthomas/kong v1.8.1
+	github.com/bodgit/sevenzip v1.6.0
+	github.com/klauspost/compress v1.17.11
+	github.com/klauspost/pgzip v1.2.6
 	github.com/mholt/archives v0.1.0
+	github.com/nwaples/rardecode/v2 v2.0.0-beta.4.0.20241112120701-034e449c6e78
+	github.com/therootcompany/xz v1.0.1
+	github.com/ulikunitz/xz v0.5.12
+	go4.org v0.0.0-20230225012048-214862532bf5
+	golang.org/x/crypto v0.29.0
+	golang.org/x/text v0.20.0
 )
 
 require (
 	github.com/STARRY-S/zip v0.2.1 // indirect
 	github.com/andybalholm/brotli v1.1.1 // indirect
 	github.com/bodgit/plumbing v1.3.0 // indirect
-	github.com/bodgit/sevenzip v1.6.0 // indirect
 	github.com/bodgit/windows v1.0.1 // indirect
 	github.com/dsnet/compress v0.0.2-0.20230904184137-39efe44ab707 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
 	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
-	github.com/klauspost/compress v1.17.11 // indirect
-	github.com/klauspost/pgzip v1.2.6 // indirect
-	github.com/nwaples/rardecode/v2 v2.0.0-beta.4.0.20241112120701-034e449c6e78 // indirect
 	github.com/pierrec/lz4/v4 v4.1.21 // indirect
 	github.com/sorairolake/lzip-go v0.3.5 // indirect
-	github.com/therootcompany/xz v1.0.1 // indirect
-	github.com/ulikunitz/xz v0.5.12 // indirect
-	go4.org v0.0.0-20230225012048-214862532bf5 // indirect
-	golang.org/x/text v0.20.0 // indirect
+	golang.org/x/sys v0.27.0 // indirect
 )
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// gpgExtensions are the extensions of files encrypted with OpenPGP, which are
+// removed to identify the format of their plaintext.
+var gpgExtensions = []string{".gpg", ".pgp", ".asc"}
+
+// gpgSniffSize is the number of bytes at the start of an input that are read
+// to recognize OpenPGP messages without an extension.
+const gpgSniffSize = 64
+
+// trimGPGExtension returns name without an OpenPGP extension, and whether it
+// had one.
+func trimGPGExtension(name string) (string, bool) {
+	for _, ext := range gpgExtensions {
+		if trimmed, ok := strings.CutSuffix(name, ext); ok {
+			return trimmed, true
+		}
+	}
+	return name, false
+}
+
+// isOpenPGP reports whether header, the start of an input, is an
+// ASCII-armored OpenPGP message, or a binary one starting with an encrypted
+// session key packet, as encrypted messages do.
+func isOpenPGP(header []byte) bool {
+	if bytes.HasPrefix(header, []byte("-----BEGIN PGP MESSAGE-----")) {
+		return true
+	}
+	if len(header) < 2 || header[0]&0x80 == 0 {
+		return false
+	}
+
+	// Packets in the new format give the tag in the low six bits, followed
+	// by a length of one, two or five bytes depending on the first, and
+	// those in the old format give it in the next four, followed by a length
+	// whose size is given by the low two bits.
+	var tag byte
+	var lengthSize int
+	if header[0]&0x40 != 0 {
+		tag = header[0] & 0x3f
+		switch length := header[1]; {
+		case length < 192:
+			lengthSize = 1
+		case length < 224:
+			lengthSize = 2
+		case length == 255:
+			lengthSize = 5
+		default:
+			return false
+		}
+	} else {
+		tag = (header[0] >> 2) & 0x0f
+		switch header[0] & 0x03 {
+		case 0:
+			lengthSize = 1
+		case 1:
+			lengthSize = 2
+		case 2:
+			lengthSize = 4
+		default:
+			return false
+		}
+	}
+	if len(header) <= 1+lengthSize {
+		return false
+	}
+
+	// Public-key encrypted session keys are version 3 or 6, and symmetric-key
+	// encrypted ones are version 4 to 6.
+	switch version := header[1+lengthSize]; tag {
+	case 1:
+		return version == 3 || version == 6
+	case 3:
+		return version >= 4 && version <= 6
+	}
+	return false
+}
+
+// gpgReader reads the plaintext of an OpenPGP message decrypted by gpg.
+type gpgReader struct {
+	io.ReadCloser
+	cmd   *exec.Cmd
+	input io.Closer
+}
+
+// decryptGPG returns the plaintext of the OpenPGP message read from input,
+// decrypted by running gpg, which asks for a passphrase itself if one is
+// needed. Closing the returned reader closes input, and fails if gpg did.
+func decryptGPG(input io.ReadCloser) (io.ReadCloser, error) {
+	cmd := exec.Command("gpg", "--quiet", "--decrypt")
+	cmd.Stdin = input
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run gpg: %w", err)
+	}
+	return &gpgReader{ReadCloser: stdout, cmd: cmd, input: input}, nil
+}
+
+func (r *gpgReader) Close() error {
+	// Closing the pipe first stops gpg if the plaintext wasn't fully read.
+	r.ReadCloser.Close()
+	err := r.cmd.Wait()
+	if err != nil {
+		err = fmt.Errorf("gpg failed: %w", err)
+	}
+	return errors.Join(err, r.input.Close())
+}
+
+// gpgWriter encrypts what's written to it with gpg, writing the OpenPGP
+// message to an output. Its commit method is like the one returned by
+// createOutput, but only commits the output if gpg succeeded.
+type gpgWriter struct {
+	io.WriteCloser
+	cmd          *exec.Cmd
+	output       io.WriteCloser
+	commitOutput func()
+	committed    bool
+}
+
+// encryptGPG returns a writer encrypting to each of recipients with gpg,
+// writing an ASCII-armored message if armor is true.
+func encryptGPG(output io.WriteCloser, commit func(), recipients []string, armor bool) (*gpgWriter, error) {
+	args := []string{"--quiet", "--batch", "--encrypt"}
+	if armor {
+		args = append(args, "--armor")
+	}
+	for _, recipient := range recipients {
+		args = append(args, "--recipient", recipient)
+	}
+
+	cmd := exec.Command("gpg", args...)
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run gpg: %w", err)
+	}
+	return &gpgWriter{WriteCloser: stdin, cmd: cmd, output: output, commitOutput: commit}, nil
+}
+
+func (w *gpgWriter) commit() {
+	w.committed = true
+}
+
+func (w *gpgWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if waitErr := w.cmd.Wait(); waitErr != nil {
+		err = fmt.Errorf("gpg failed: %w", waitErr)
+	}
+	if err == nil && w.committed {
+		w.commitOutput()
+	}
+	return errors.Join(err, w.output.Close())
+}
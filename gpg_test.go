@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestIsOpenPGP(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   bool
+	}{
+		{"armored", []byte("-----BEGIN PGP MESSAGE-----\n\nhQEMA"), true},
+		{"old public-key session key", []byte{0x85, 0x01, 0x0c, 0x03, 0x12}, true},
+		{"old symmetric-key session key", []byte{0x8c, 0x0d, 0x04, 0x09}, true},
+		{"new public-key session key", []byte{0xc1, 0xc0, 0x4c, 0x03}, true},
+		{"new symmetric-key session key", []byte{0xc3, 0x2e, 0x06}, true},
+		{"signature", []byte{0x89, 0x01, 0x33, 0x04}, false},
+		{"unknown version", []byte{0x85, 0x01, 0x0c, 0x07}, false},
+		{"truncated", []byte{0x85, 0x01}, false},
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, false},
+		{"zip", []byte("PK\x03\x04"), false},
+		{"empty", nil, false},
+	}
+
+	for _, test := range tests {
+		if got := isOpenPGP(test.header); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTrimGPGExtension(t *testing.T) {
+	for name, want := range map[string]string{"a.tar.gz.gpg": "a.tar.gz", "a.zip.asc": "a.zip", "a.tar.pgp": "a.tar", "a.tar.gz": ""} {
+		got, ok := trimGPGExtension(name)
+		if ok != (want != "") || (ok && got != want) {
+			t.Errorf("%s: got %q, %t, want %q", name, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/mholt/archives"
+)
+
+type gzipMember struct {
+	Name    string    `json:"name,omitempty"`
+	Comment string    `json:"comment,omitempty"`
+	ModTime time.Time `json:"modTime,omitempty"`
+	Size    int64     `json:"size"`
+}
+
+// gzipMembers decompresses each member of a possibly multi-member gzip stream
+// in turn, returning the header metadata and decompressed size of each.
+func gzipMembers(r io.Reader) ([]gzipMember, error) {
+	br := bufio.NewReader(r)
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []gzipMember
+	for {
+		zr.Multistream(false)
+
+		size, err := io.Copy(io.Discard, zr)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, gzipMember{
+			Name:    zr.Name,
+			Comment: zr.Comment,
+			ModTime: zr.ModTime,
+			Size:    size,
+		})
+
+		if err := zr.Reset(br); err == io.EOF {
+			return members, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// withGzipHeader returns format, which must compress with gzip, possibly as a
+// compressed archive, storing name and modTime in its header if they aren't
+// empty, and compressing rsyncably if rsyncable is true, or false if it
+// doesn't compress with gzip.
+func withGzipHeader(format archives.Format, name string, modTime time.Time, rsyncable bool) (archives.Format, bool) {
+	switch format := format.(type) {
+	case archives.CompressedArchive:
+		compression, ok := withGzipHeader(format.Compression, name, modTime, rsyncable)
+		if ok {
+			format.Compression = compression.(archives.Compression)
+		}
+		return format, ok
+	case parallelGz:
+		format.name, format.modTime, format.rsyncable = name, modTime, rsyncable
+		return format, true
+	}
+	return nil, false
+}
+
+// rsyncableGzMin is the least that rsyncableGz compresses between resets, and
+// rsyncableGzMask picks the bits of its hash that must be zero for one, so
+// that it resets about every 8 KiB.
+const (
+	rsyncableGzMin  = 4096
+	rsyncableGzMask = 1<<12 - 1
+)
+
+// rsyncableGzGear is the table of the gear hash that rsyncableGz resets by,
+// which is generated by splitmix64 from a fixed seed, since the output must
+// never change.
+var rsyncableGzGear = func() (gear [256]uint64) {
+	var x uint64
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		gear[i] = z ^ z>>31
+	}
+	return gear
+}()
+
+// rsyncableGz compresses gzip rsyncably, like gzip --rsyncable, by resetting
+// its compressor wherever a rolling hash of the previous 64 bytes has its low
+// bits set to zero. Since where that happens only depends on the bytes
+// nearby, a change to the input only changes the output until the next reset
+// after it, so rsync and other tools that transfer differences between files
+// only have to send that much, at the cost of compressing slightly worse.
+type rsyncableGz struct {
+	w    io.Writer
+	fw   *flate.Writer
+	crc  uint32
+	size uint32
+	// hash is the gear hash of what was written since the last reset, which
+	// was since bytes ago.
+	hash  uint64
+	since int
+}
+
+// newRsyncableGz returns an rsyncableGz writing to w at level, having written
+// a gzip header with name and modTime.
+func newRsyncableGz(w io.Writer, level int, name string, modTime time.Time) (*rsyncableGz, error) {
+	header := []byte{0x1f, 0x8b, 8, 0}
+	header = binary.LittleEndian.AppendUint32(header, uint32(modTime.Unix()))
+	switch level {
+	case flate.BestCompression:
+		header = append(header, 2)
+	case flate.BestSpeed:
+		header = append(header, 4)
+	default:
+		header = append(header, 0)
+	}
+	header = append(header, 255)
+	if name != "" {
+		// Names are stored in Latin-1, terminated by a zero byte.
+		header[3] |= 0x08
+		for _, r := range name {
+			if r == 0 || r > 0xff {
+				return nil, errors.New("gzip names must be in Latin-1")
+			}
+			header = append(header, byte(r))
+		}
+		header = append(header, 0)
+	}
+
+	fw, err := flate.NewWriter(w, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &rsyncableGz{w: w, fw: fw}, nil
+}
+
+func (z *rsyncableGz) Write(p []byte) (int, error) {
+	n := 0
+	start := 0
+	for i, b := range p {
+		z.hash = z.hash<<1 + rsyncableGzGear[b]
+		z.since++
+		if z.since < rsyncableGzMin || z.hash&rsyncableGzMask != 0 {
+			continue
+		}
+
+		written, err := z.write(p[start : i+1])
+		n += written
+		if err != nil {
+			return n, err
+		}
+		start = i + 1
+		// A sync flush ends the deflate block on a byte boundary, so the
+		// next one can be compressed without reference to anything before
+		// it.
+		if err := z.fw.Flush(); err != nil {
+			return n, err
+		}
+		z.fw.Reset(z.w)
+		z.hash, z.since = 0, 0
+	}
+	written, err := z.write(p[start:])
+	return n + written, err
+}
+
+func (z *rsyncableGz) write(p []byte) (int, error) {
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+	return z.fw.Write(p)
+}
+
+func (z *rsyncableGz) Close() error {
+	if err := z.fw.Close(); err != nil {
+		return err
+	}
+	trailer := binary.LittleEndian.AppendUint32(nil, z.crc)
+	trailer = binary.LittleEndian.AppendUint32(trailer, z.size)
+	_, err := z.w.Write(trailer)
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/mholt/archives"
+)
+
+func TestGzipHeader(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, rsyncable := range []bool{false, true} {
+		format, ok := withGzipHeader(withThreads(archives.Gz{}, 2), "notes.txt", modTime, rsyncable)
+		if !ok {
+			t.Fatal("gz wasn't given a header")
+		}
+		var buf bytes.Buffer
+		w, err := format.(archives.Compressor).OpenWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("squish")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		zr, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if zr.Name != "notes.txt" || !zr.ModTime.Equal(modTime) {
+			t.Errorf("got name %q and time %s with rsyncable %t", zr.Name, zr.ModTime, rsyncable)
+		}
+	}
+
+	if _, ok := withGzipHeader(withThreads(archives.Zstd{}, 2), "notes.txt", modTime, false); ok {
+		t.Error("zst was given a gzip header")
+	}
+}
+
+func TestGzipHeaderDefault(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := withThreads(archives.Gz{}, 2).(archives.Compressor).OpenWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if mtime := buf.Bytes()[4:8]; !bytes.Equal(mtime, []byte{0, 0, 0, 0}) {
+		t.Errorf("got modification time %x, want none", mtime)
+	}
+}
+
+func TestRsyncableGz(t *testing.T) {
+	input := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(input)
+	// Make the input compressible.
+	for i := range input {
+		input[i] %= 16
+	}
+	changed := bytes.Clone(input)
+	changed[1000] ^= 1
+
+	compress := func(input []byte) []byte {
+		var buf bytes.Buffer
+		w, err := newRsyncableGz(&buf, 6, "", time.Unix(0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Writes are split unevenly, to check that they don't affect the
+		// output.
+		for len(input) > 0 {
+			n := min(len(input), 12345)
+			if _, err := w.Write(input[:n]); err != nil {
+				t.Fatal(err)
+			}
+			input = input[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	original, modified := compress(input), compress(changed)
+
+	zr, err := gzip.NewReader(bytes.NewReader(modified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, changed) {
+		t.Fatal("the output didn't decompress to the input")
+	}
+
+	// Other than the trailer, everything after the change should be the same
+	// once the compressor has been reset.
+	shared := 0
+	for shared < min(len(original), len(modified))-8 && original[len(original)-9-shared] == modified[len(modified)-9-shared] {
+		shared++
+	}
+	if shared < len(original)/2 {
+		t.Errorf("only the last %d of %d bytes were unchanged", shared, len(original))
+	}
+}
+
+func TestGzipMembers(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	for _, member := range []gzipMember{
+		{Name: "a.log", ModTime: modTime, Size: 6},
+		{Name: "b.log", Comment: "rotated", ModTime: modTime.Add(time.Hour), Size: 0},
+		{Size: 3},
+	} {
+		zw := gzip.NewWriter(&buf)
+		zw.Name, zw.Comment, zw.ModTime = member.Name, member.Comment, member.ModTime
+		if _, err := zw.Write(bytes.Repeat([]byte("x"), int(member.Size))); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	concatenated := buf.Bytes()
+
+	members, err := gzipMembers(bytes.NewReader(concatenated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 3 {
+		t.Fatalf("got %d members, want 3", len(members))
+	}
+	if got := members[1]; got.Name != "b.log" || got.Comment != "rotated" || !got.ModTime.Equal(modTime.Add(time.Hour)) || got.Size != 0 {
+		t.Errorf("got second member %+v", got)
+	}
+	if got := members[0].Size + members[2].Size; got != 9 {
+		t.Errorf("got %d bytes in the other members, want 9", got)
+	}
+
+	// Decompressing reads every member, not just the first.
+	r, err := withThreads(archives.Gz{}, 2).(archives.Decompressor).OpenReader(bytes.NewReader(concatenated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decompressed) != 9 {
+		t.Errorf("decompressed %d bytes, want 9", len(decompressed))
+	}
+}
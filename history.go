@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyRecord describes an operation in the history, which is a file of
+// JSON records, one per line, so that concurrent operations can append to it
+// without coordinating.
+type historyRecord struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Args    []string  `json:"args"`
+	// Dir is the working directory that relative paths in Args are relative
+	// to.
+	Dir    string   `json:"dir,omitempty"`
+	Inputs []string `json:"inputs,omitempty"`
+	Output string   `json:"output,omitempty"`
+	// InputBytes is the total size of the inputs that are files, or of the
+	// files that were archived when creating an archive, and OutputBytes is
+	// the size of the output if it's a file and the operation succeeded.
+	InputBytes      int64   `json:"inputBytes,omitempty"`
+	OutputBytes     int64   `json:"outputBytes,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Success         bool    `json:"success"`
+	Warnings        int64   `json:"warnings,omitempty"`
+	Error           string  `json:"error,omitempty"`
+
+	// file is the history file that the record is appended to.
+	file *os.File
+}
+
+// historyFDEnv is set in the environment of the process re-executed by
+// --sandbox to the file descriptor of the history file, which it inherits
+// since it can't open the file itself.
+const historyFDEnv = "SQUISH_HISTORY_FD"
+
+// history is the record of the running operation, or nil if it isn't being
+// recorded.
+var history *historyRecord
+
+// openHistory starts recording the operation command, which began at started,
+// in the history file given by --history. The file is opened up front, so that
+// it can still be written once --sandbox prevents opening it.
+func openHistory(command string, started time.Time) (*historyRecord, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	var file *os.File
+	if fd, err := strconv.Atoi(os.Getenv(historyFDEnv)); err == nil {
+		file = os.NewFile(uintptr(fd), path)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		if file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600); err != nil {
+			return nil, err
+		}
+	}
+
+	// The directory is only needed to repeat the operation with again, so
+	// it's left out if it can't be found.
+	dir, _ := os.Getwd()
+	inputs, output := operationPaths(command)
+	return &historyRecord{Time: started, Command: command, Args: withoutPasswords(os.Args[1:]), Dir: dir, Inputs: inputs, Output: output, file: file}, nil
+}
+
+// withoutPasswords returns args with the values of --password, and of rekey's
+// --old-password and --new-password, removed, so that they aren't recorded,
+// and again asks for them instead.
+func withoutPasswords(args []string) []string {
+	args = slices.Clone(args)
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if flag, _, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(flag, "--") && strings.HasSuffix(flag, "password") {
+			args[i] = flag
+		}
+	}
+	return args
+}
+
+// operationPaths returns the inputs and output of command given by the flags.
+func operationPaths(command string) (inputs []string, output string) {
+	switch command {
+	case "create":
+		return cli.Create.Inputs, cli.Create.Output
+	case "extract":
+		if cli.Extract.Output != nil {
+			output = *cli.Extract.Output
+		}
+		return []string{cli.Extract.Input}, output
+	case "join":
+		if cli.Join.Output != nil {
+			output = *cli.Join.Output
+		}
+		return []string{cli.Join.Input}, output
+	case "index":
+		return []string{cli.Index.Input}, cmp.Or(cli.Index.Output, trimVolumeSuffix(cli.Index.Input)+indexSuffix)
+	case "manifest":
+		return []string{cli.Manifest.Input}, cmp.Or(cli.Manifest.Output, cli.Manifest.Input+".merkle")
+	case "retouch":
+		return []string{cli.Retouch.Input}, cmp.Or(cli.Retouch.Output, cli.Retouch.Input)
+	case "verify":
+		return []string{cli.Verify.Manifest, cli.Verify.Input}, ""
+	case "grep":
+		return cli.Grep.Inputs, ""
+	case "find":
+		return cli.Find.Inputs, ""
+	case "list":
+		return []string{cli.List.Input}, ""
+	case "info":
+		return []string{cli.Info.Input}, ""
+	case "stat":
+		return []string{cli.Stat.Input}, ""
+	case "cat":
+		return []string{cli.Cat.Input}, ""
+	case "diff-entry":
+		return []string{cli.DiffEntry.Input, cli.DiffEntry.File}, ""
+	case "mount":
+		return []string{cli.Mount.Input}, cli.Mount.Mountpoint
+	case "serve":
+		return []string{cli.Serve.Input}, ""
+	case "browse":
+		return []string{cli.Browse.Input}, ""
+	case "sample":
+		return []string{cli.Sample.Input}, ""
+	case "du":
+		return []string{cli.Du.Input}, ""
+	case "sync":
+		return []string{cli.Sync.Directory}, cli.Sync.Archive
+	case "rekey":
+		return []string{cli.Rekey.Input}, cmp.Or(cli.Rekey.Output, cli.Rekey.Input)
+	case "bench":
+		if cli.Bench.Suite {
+			return nil, ""
+		}
+		return []string{cli.Bench.Directory}, ""
+	}
+	return nil, ""
+}
+
+// fail records that the operation failed with message. A nil *historyRecord
+// records nothing.
+func (h *historyRecord) fail(message string) {
+	if h == nil || h.Error != "" {
+		return
+	}
+	h.Error = message
+}
+
+// setInputBytes records the size of the inputs, when it's known better than
+// from the sizes of the input files themselves.
+func (h *historyRecord) setInputBytes(n int64) {
+	if h == nil {
+		return
+	}
+	h.InputBytes = n
+}
+
+// setOutput records the output, when it's only decided once the operation
+// has started.
+func (h *historyRecord) setOutput(output string) {
+	if h == nil {
+		return
+	}
+	h.Output = output
+}
+
+// save appends the record of the finished operation to the history file.
+// Failing to do so doesn't change the result of the operation, so it's only
+// logged. A nil *historyRecord saves nothing.
+func (h *historyRecord) save() {
+	if h == nil {
+		return
+	}
+
+	h.DurationSeconds = time.Since(h.Time).Seconds()
+	h.Success = exitCode == 0
+	h.Warnings = warnings.Load()
+	if h.InputBytes == 0 {
+		for _, input := range h.Inputs {
+			h.InputBytes += pathSize(input)
+		}
+	}
+	// The output may have been left over from before if the operation
+	// failed.
+	if h.Success {
+		h.OutputBytes = pathSize(h.Output)
+	}
+
+	line, err := json.Marshal(h)
+	if err != nil {
+		panic(err)
+	}
+
+	defer h.file.Close()
+	if _, err := h.file.Write(append(line, '\n')); err != nil {
+		logger.Error("failed to record operation in history", "error", err)
+	}
+}
+
+// pathSize returns the size of the regular file at path, or 0 if it isn't one,
+// as with URLs, directories and stdin.
+func pathSize(path string) int64 {
+	if path == "" || path == stdioPath || isURL(path) {
+		return 0
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// historyPath returns the path of the history file given by --history, or the
+// default one in the user's state directory.
+func historyPath() (string, error) {
+	if cli.History != "" {
+		return cli.History, nil
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "squish", "history.jsonl"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "squish", "history.jsonl"), nil
+}
+
+// showHistory prints the operations recorded in the history, oldest first.
+func showHistory(context.Context) {
+	if cli.Log.Count < 0 {
+		bail("invalid number of operations: %d", cli.Log.Count)
+	}
+
+	path, err := historyPath()
+	if err != nil {
+		bail("failed to find history: %s", err)
+	}
+	records, err := readHistory(path)
+	if err != nil {
+		bail("failed to read history: %s", err)
+	}
+	if cli.Log.Failed {
+		records = slices.DeleteFunc(records, func(record historyRecord) bool { return record.Success })
+	}
+	if cli.Log.Count > 0 && len(records) > cli.Log.Count {
+		records = records[len(records)-cli.Log.Count:]
+	}
+
+	for _, record := range records {
+		if cli.Log.JSON {
+			line, err := json.Marshal(record)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println(string(line))
+			continue
+		}
+		fmt.Println(record)
+	}
+}
+
+func (r historyRecord) String() string {
+	result := "ok"
+	if !r.Success {
+		result = "failed"
+	}
+	duration := time.Duration(r.DurationSeconds * float64(time.Second)).Round(time.Millisecond)
+
+	line := fmt.Sprintf("%s  %-6s  %8s  squish %s", r.Time.Local().Format(time.DateTime), result, duration, shellJoin(r.Args))
+	if r.InputBytes > 0 {
+		line += fmt.Sprintf("  %s in", byteSize(r.InputBytes))
+	}
+	if r.OutputBytes > 0 {
+		line += fmt.Sprintf("  %s out", byteSize(r.OutputBytes))
+	}
+	if r.Warnings > 0 {
+		line += fmt.Sprintf("  %d warning(s)", r.Warnings)
+	}
+	if r.Error != "" {
+		line += "  error: " + r.Error
+	}
+	return line
+}
+
+// readHistory reads the records in the history file at path, in the order the
+// operations finished. A missing file is an empty history, and lines that
+// can't be parsed, like one cut off by a full disk, are skipped with a
+// warning.
+func readHistory(path string) ([]historyRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []historyRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var record historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			warn("skipped invalid history record on line %d: %s", lineNumber, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, record := range []*historyRecord{
+		{Time: started, Command: "create", Args: []string{"create", "a.tar", "a"}, InputBytes: 2048},
+		{Time: started, Command: "extract", Args: []string{"extract", "b.tar"}, Error: "failed to open input file"},
+	} {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.file = file
+		record.save()
+	}
+
+	// A record cut off partway through is skipped.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(`{"time":"2024-01-02T03:04:05Z","comm`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	records, err := readHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	create, extract := records[0], records[1]
+	if create.Command != "create" || create.InputBytes != 2048 || !create.Success {
+		t.Errorf("got create record %+v", create)
+	}
+	if extract.Command != "extract" || extract.Error != "failed to open input file" {
+		t.Errorf("got extract record %+v", extract)
+	}
+	if !create.Time.Equal(started) || create.DurationSeconds <= 0 {
+		t.Errorf("got time %s and duration %f", create.Time, create.DurationSeconds)
+	}
+}
+
+func TestHistoryMissing(t *testing.T) {
+	records, err := readHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil || records != nil {
+		t.Errorf("got records %v and error %v, want neither", records, err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/mholt/archives"
+)
+
+// identify identifies the format of stream, which is named name, by its
+// contents, so that inputs with missing or misleading extensions are read as
+// what they are, falling back to its name for formats that can't be
+// recognized by their contents. If name suggests a different format than the
+// contents, both are reported with a warning. Zips decode the names of their
+// entries with --charset. Like archives.Identify, it returns a reader that
+// must be read instead of stream.
+func identify(ctx context.Context, name string, stream io.Reader) (archives.Format, io.Reader, error) {
+	byName, _, nameErr := archives.Identify(ctx, name, nil)
+	format, r, err := archives.Identify(ctx, "", stream)
+	if errors.Is(err, archives.NoMatch) && nameErr == nil {
+		logger.Debug("identified format by name", "input", name, "format", byName.Extension())
+		return withCharset(byName), r, nil
+	}
+	if err != nil {
+		return nil, r, err
+	}
+
+	if nameErr == nil && !sameFormat(byName, format) {
+		warn("%s looks like %s by its name, but like %s by its contents, so it's read as %s", name, byName.Extension(), format.Extension(), format.Extension())
+	}
+	return withCharset(format), r, nil
+}
+
+// sameFormat reports whether byName, identified by an input's name, agrees
+// with format, identified by its contents, which is more specific when the
+// name only shows that it's compressed, like foo.gz for a compressed tar
+// archive.
+func sameFormat(byName, format archives.Format) bool {
+	if byName.Extension() == format.Extension() {
+		return true
+	}
+	compressed, ok := format.(archives.CompressedArchive)
+	return ok && compressed.Compression != nil && byName.Extension() == compressed.Compression.Extension()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestIdentify(t *testing.T) {
+	archive := makeTar(t, []testEntry{{name: "a", typeflag: tar.TypeReg, contents: "a"}})
+	tests := []struct {
+		name     string
+		contents []byte
+		want     string
+		warns    bool
+	}{
+		{"download", archive, ".tar", false},
+		{"foo.tar", archive, ".tar", false},
+		{"foo.zip", archive, ".tar", true},
+		{"foo.gz", nil, ".gz", false},
+	}
+	for _, test := range tests {
+		before := warnings.Load()
+		format, _, err := identify(context.Background(), test.name, bytes.NewReader(test.contents))
+		if err != nil {
+			t.Errorf("failed to identify %s: %s", test.name, err)
+			continue
+		}
+		if got := format.Extension(); got != test.want {
+			t.Errorf("got format %s for %s, want %s", got, test.name, test.want)
+		}
+		if warned := warnings.Load() > before; warned != test.warns {
+			t.Errorf("got warning %t for %s, want %t", warned, test.name, test.warns)
+		}
+	}
+
+	if _, _, err := identify(context.Background(), "download", bytes.NewReader([]byte("plain text"))); err != archives.NoMatch {
+		t.Errorf("got error %v for an unrecognized input, want %v", err, archives.NoMatch)
+	}
+}
+
+func TestSameFormat(t *testing.T) {
+	compressedTar := archives.CompressedArchive{Compression: archives.Gz{}, Extraction: archives.Tar{}, Archival: archives.Tar{}}
+	if !sameFormat(archives.Gz{}, compressedTar) {
+		t.Error("a compressed tar archive named .gz didn't match")
+	}
+	if sameFormat(archives.Zip{}, compressedTar) {
+		t.Error("a compressed tar archive named .zip matched")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// ignoreRule is a single pattern from a file in gitignore(5) format.
+type ignoreRule struct {
+	// dir is the slash-separated path of the directory containing the
+	// ignore file, relative to the input, which the pattern is relative to.
+	dir string
+	// elems are the elements of the pattern, which is preceded by ** unless
+	// it's anchored to dir by containing a slash.
+	elems   []string
+	negate  bool
+	dirOnly bool
+}
+
+// parseIgnore parses the rules in an ignore file in gitignore(5) format,
+// relative to dir. Invalid patterns are skipped, as they are by git.
+func parseIgnore(data, dir string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+			line = line[:len(line)-1]
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		rule := ignoreRule{dir: dir}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		if !anchored {
+			line = "**/" + line
+		}
+
+		// gitignore negates bracket expressions with !, which path.Match
+		// spells ^.
+		line = strings.ReplaceAll(line, "[!", "[^")
+
+		valid := true
+		for _, elem := range strings.Split(line, "/") {
+			if _, err := path.Match(elem, ""); err != nil {
+				valid = false
+			}
+			rule.elems = append(rule.elems, elem)
+		}
+		if valid {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// matches reports whether the entry at the slash-separated path name,
+// relative to the input, matches the rule.
+func (r ignoreRule) matches(name string, dir bool) bool {
+	if r.dirOnly && !dir {
+		return false
+	}
+
+	if r.dir != "" {
+		var ok bool
+		if name, ok = strings.CutPrefix(name, r.dir+"/"); !ok {
+			return false
+		}
+	}
+	return matchElems(r.elems, strings.Split(name, "/"))
+}
+
+// ignoreFiles removes the files under root that are ignored by the rules in
+// the ignore files given by --ignore-file, which are relative to root, and, if
+// --gitignore was given, by .gitignore files in any directory under root,
+// which take precedence over those in their parents. As with git, .git
+// directories are also ignored, and files in ignored directories can't be
+// included again by negated patterns. files must have been discovered from
+// root by archives.FilesFromDisk.
+func ignoreFiles(files []archives.FileInfo, root string) ([]archives.FileInfo, error) {
+	if len(files) == 0 || (!cli.Create.Gitignore && len(cli.Create.IgnoreFile) == 0) {
+		return files, nil
+	}
+
+	var baseRules []ignoreRule
+	for _, ignoreFile := range cli.Create.IgnoreFile {
+		data, err := os.ReadFile(ignoreFile)
+		if err != nil {
+			return nil, err
+		}
+		baseRules = append(baseRules, parseIgnore(string(data), "")...)
+	}
+
+	walked := newWalkedRoot(files, root)
+
+	// dirRules holds the rules from the .gitignore file of each directory
+	// that wasn't ignored, which includes all parents of any remaining file
+	// since directories are walked before their contents.
+	dirRules := map[string][]ignoreRule{}
+	filtered := []archives.FileInfo{files[0]}
+	if files[0].IsDir() {
+		rules, err := readGitignore(root, "")
+		if err != nil {
+			return nil, err
+		}
+		dirRules[""] = rules
+	}
+
+	for _, file := range files[1:] {
+		name := walked.relative(file)
+
+		parent := path.Dir(name)
+		if parent == "." {
+			parent = ""
+		}
+		if _, ok := dirRules[parent]; !ok {
+			// The parent was ignored.
+			continue
+		}
+		if cli.Create.Gitignore && path.Base(name) == ".git" && file.IsDir() {
+			continue
+		}
+
+		// Later rules take precedence, so rules from deeper ignore files are
+		// checked last.
+		rules := slices.Clone(baseRules)
+		rules = append(rules, dirRules[""]...)
+		if parent != "" {
+			elems := strings.Split(parent, "/")
+			for i := range elems {
+				rules = append(rules, dirRules[strings.Join(elems[:i+1], "/")]...)
+			}
+		}
+
+		ignored := false
+		for _, rule := range rules {
+			if rule.matches(name, file.IsDir()) {
+				ignored = !rule.negate
+			}
+		}
+		if ignored {
+			continue
+		}
+
+		if file.IsDir() {
+			rules, err := readGitignore(root, name)
+			if err != nil {
+				return nil, err
+			}
+			dirRules[name] = rules
+		}
+		filtered = append(filtered, file)
+	}
+
+	return filtered, nil
+}
+
+// readGitignore returns the rules in the .gitignore file in the directory dir
+// under root, if --gitignore was given and it exists.
+func readGitignore(root, dir string) ([]ignoreRule, error) {
+	if !cli.Create.Gitignore {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(dir), ".gitignore"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseIgnore(string(data), dir), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestIgnoreRules(t *testing.T) {
+	tests := []struct {
+		ignore string
+		dir    string
+		name   string
+		isDir  bool
+		want   bool
+	}{
+		{"*.log", "", "a.log", false, true},
+		{"*.log", "", "a/b.log", false, true},
+		{"*.log\n!keep.log", "", "a/keep.log", false, false},
+		{"build/", "", "a/build", true, true},
+		{"build/", "", "a/build", false, false},
+		{"/build", "", "a/build", true, false},
+		{"/build", "", "build", true, true},
+		{"a/b", "", "a/b", false, true},
+		{"a/b", "", "x/a/b", false, false},
+		{"a/**/c", "", "a/x/y/c", false, true},
+		{"*.go", "sub", "sub/a.go", false, true},
+		{"*.go", "sub", "a.go", false, false},
+		{"/a.go", "sub", "sub/a.go", false, true},
+		{"# comment\n\n", "", "# comment", false, false},
+		{`\#a`, "", "#a", false, true},
+		{"a  ", "", "a", false, true},
+		{"[!a]", "", "b", false, true},
+		{"[!a]", "", "a", false, false},
+	}
+
+	for _, test := range tests {
+		ignored := false
+		for _, rule := range parseIgnore(test.ignore, test.dir) {
+			if rule.matches(test.name, test.isDir) {
+				ignored = !rule.negate
+			}
+		}
+		if ignored != test.want {
+			t.Errorf("%q in %q matching %s: got %t, want %t", test.ignore, test.dir, test.name, ignored, test.want)
+		}
+	}
+}
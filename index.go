@@ -0,0 +1,286 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+const indexVersion = 1
+
+// indexSuffix is appended to the path of an archive to give that of its index,
+// written by index or create --index, which is used in place of the archive
+// when it's beside it.
+const indexSuffix = ".idx"
+
+// indexMagic is the prefix of every index file, used to tell them apart from
+// archives.
+var indexMagic = []byte(`{"squishIndex":`)
+
+// archiveIndex records the metadata of every entry of an archive, and for tar
+// archives, where each of them is, so that list and find can read it in place
+// of the archive, and cat and extract can read single entries without reading
+// everything before them.
+type archiveIndex struct {
+	Version int    `json:"squishIndex"`
+	Format  string `json:"format"`
+	// Size is the size of the archive, so that an index that's left beside
+	// a different archive of the same name is ignored.
+	Size    int64        `json:"size"`
+	Entries []indexEntry `json:"entries"`
+}
+
+type indexEntry struct {
+	Name       string       `json:"name"`
+	Size       int64        `json:"size"`
+	Mode       fs.FileMode  `json:"mode"`
+	ModTime    time.Time    `json:"modTime"`
+	LinkTarget string       `json:"linkTarget,omitempty"`
+	Offset     *entryOffset `json:"offset,omitempty"`
+}
+
+// entryOffset is where the header of an entry of a tar archive is. Frame is
+// the offset in the archive of the gzip member or zstd frame that it starts in,
+// which is 0 for uncompressed archives, and Skip is how much of it comes
+// before the header once it's decompressed.
+type entryOffset struct {
+	Frame int64 `json:"frame"`
+	Skip  int64 `json:"skip"`
+}
+
+type indexFileInfo struct {
+	entry indexEntry
+}
+
+func (i indexFileInfo) Name() string       { return path.Base(i.entry.Name) }
+func (i indexFileInfo) Size() int64        { return i.entry.Size }
+func (i indexFileInfo) Mode() fs.FileMode  { return i.entry.Mode }
+func (i indexFileInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i indexFileInfo) IsDir() bool        { return i.entry.Mode.IsDir() }
+func (i indexFileInfo) Sys() any           { return nil }
+
+func index(ctx context.Context) {
+	input, extractor, inputR := openExtractor(ctx, cli.Index.Input)
+	defer closeInput(input)
+
+	result := archiveIndex{
+		Version: indexVersion,
+		Format:  strings.TrimPrefix(extractor.(archives.Format).Extension(), "."),
+		Size:    fileSize(input),
+		Entries: []indexEntry{},
+	}
+	var err error
+	if _, compressed := extractor.(archives.CompressedArchive); isTar(extractor) && !compressed {
+		result.Entries, err = indexTar(ctx, inputR)
+	} else {
+		err = extractor.Extract(ctx, inputR, func(ctx context.Context, info archives.FileInfo) error {
+			result.Entries = append(result.Entries, newIndexEntry(info))
+			return nil
+		})
+	}
+	if err != nil {
+		bail("failed to read archive: %s", err)
+	}
+
+	outputPath := cli.Index.Output
+	if outputPath == "" {
+		outputPath = trimVolumeSuffix(cli.Index.Input) + indexSuffix
+	}
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		bail("failed to create index file: %s", err)
+	}
+	defer func() {
+		if err := output.Close(); err != nil {
+			bail("failed to close index file: %s", err)
+		}
+	}()
+
+	if err := json.NewEncoder(output).Encode(result); err != nil {
+		bail("failed to write index file: %s", err)
+	}
+}
+
+func newIndexEntry(info archives.FileInfo) indexEntry {
+	return indexEntry{
+		Name:       info.NameInArchive,
+		Size:       info.Size(),
+		Mode:       info.Mode(),
+		ModTime:    info.ModTime(),
+		LinkTarget: info.LinkTarget,
+	}
+}
+
+// indexTar returns the entries of the uncompressed tar archive r, along with
+// where each of their headers is. Since compressed archives have to be
+// decompressed from the start to reach any entry, unless they were written
+// by create --index, only their metadata is indexed.
+func indexTar(ctx context.Context, r io.Reader) ([]indexEntry, error) {
+	counted := &countingReader{Reader: r}
+	tr := tar.NewReader(counted)
+	entries := []indexEntry{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// The contents of the entry before are read entirely, so the next
+		// header starts at the block after them.
+		offset := (counted.n.Load() + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return nil, err
+		}
+		// archives.Tar skips global headers, so they aren't entries.
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		entry := newIndexEntry(archives.FileInfo{FileInfo: header.FileInfo(), NameInArchive: header.Name, LinkTarget: header.Linkname})
+		entry.Offset = &entryOffset{Skip: offset}
+		entries = append(entries, entry)
+	}
+}
+
+// writeIndex writes index beside the archive at output, once it's been
+// written.
+func writeIndex(output string, index *archiveIndex) error {
+	info, err := os.Stat(output)
+	if err != nil {
+		return err
+	}
+	index.Size = info.Size()
+
+	f, err := createAtomic(output+indexSuffix, "")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(index); err != nil {
+		f.Close()
+		return err
+	}
+	f.commit()
+	return f.Close()
+}
+
+// readIndex reads the index beside the archive at name, of size size,
+// reporting false if there isn't one. Indexes that can't be read, or are for a
+// different archive, are ignored with a warning.
+func readIndex(name string, size int64) (*archiveIndex, bool) {
+	if name == stdioPath || isURL(name) {
+		return nil, false
+	}
+	data, err := os.ReadFile(trimVolumeSuffix(name) + indexSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false
+	}
+
+	var index archiveIndex
+	if err == nil {
+		err = json.Unmarshal(data, &index)
+	}
+	if err == nil && index.Version != indexVersion {
+		err = fmt.Errorf("unsupported version %d", index.Version)
+	}
+	if err != nil {
+		warn("ignoring the index beside %s, which can't be read: %s", name, err)
+		return nil, false
+	}
+	if index.Size != size {
+		warn("ignoring the index beside %s, which is for a different archive", name)
+		return nil, false
+	}
+	return &index, true
+}
+
+// walkEntries calls handle for each entry of the archive at path, or of the
+// archive an index file at path was created from. If ignoreZeros is true,
+// concatenated tar archives are read entirely. Otherwise, if useIndex is true,
+// entries are read from the index beside the archive, if there is one.
+func walkEntries(ctx context.Context, path string, ignoreZeros, useIndex bool, handle archives.FileHandler) {
+	input, err := openFile(ctx, path)
+	if err != nil {
+		bail("failed to open input file: %s", err)
+	}
+	defer closeInput(input)
+
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(input, magic); err == nil && bytes.Equal(magic, indexMagic) {
+		if _, err := input.Seek(0, io.SeekStart); err != nil {
+			bail("failed to seek input file: %s", err)
+		}
+
+		var index archiveIndex
+		if err := json.NewDecoder(input).Decode(&index); err != nil {
+			bail("failed to read index file: %s", err)
+		}
+		if err := walkIndexEntries(ctx, index.Entries, handle); err != nil {
+			bail("failed to read index: %s", err)
+		}
+		return
+	}
+	if !ignoreZeros && useIndex {
+		if index, ok := readIndex(path, fileSize(input)); ok {
+			if err := walkIndexEntries(ctx, index.Entries, handle); err != nil {
+				bail("failed to read index: %s", err)
+			}
+			return
+		}
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		bail("failed to seek input file: %s", err)
+	}
+
+	format, inputR, err := identify(ctx, path, input)
+	if err != nil {
+		bail("failed to identify format: %s", err)
+	}
+	extractor, ok := format.(archives.Extractor)
+	if !ok {
+		bail("identified format doesn't support extraction")
+	}
+	if ignoreZeros {
+		extractor = withIgnoreZeros(extractor)
+	}
+
+	if err := extractor.Extract(ctx, inputR, handle); err != nil {
+		bail("failed to read archive: %s", err)
+	}
+}
+
+// walkIndexEntries calls handle for each of entries, which were read from an
+// index.
+func walkIndexEntries(ctx context.Context, entries []indexEntry, handle archives.FileHandler) error {
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info := archives.FileInfo{
+			FileInfo:      indexFileInfo{entry},
+			NameInArchive: entry.Name,
+			LinkTarget:    entry.LinkTarget,
+		}
+		if err := handle(ctx, info); errors.Is(err, fs.SkipAll) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
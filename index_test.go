@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	long := strings.Repeat("long/", 30) + "name"
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	headers := []*tar.Header{
+		{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "global"}},
+		{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "a/x", Typeflag: tar.TypeReg, Mode: 0o644, Size: 600},
+		{Name: long, Typeflag: tar.TypeReg, Mode: 0o644, Size: 3},
+		{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "a/x"},
+	}
+	contents := map[string]string{"a/x": strings.Repeat("x", 600), long: "end"}
+	for _, header := range headers {
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(contents[header.Name]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "in.tar")
+	if err := os.WriteFile(archive, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an index, cat reads the archive from the start.
+	parseCLI(t, "cat", archive, "a/x")
+	var code int
+	if got := captureStdout(t, func() { code = runCommand(t, func() { cat(context.Background()) }) }); got != contents["a/x"] || code != 0 {
+		t.Errorf("cat without an index: got %d bytes and exit code %d, want 600 and 0", len(got), code)
+	}
+
+	parseCLI(t, "index", archive)
+	if code := runCommand(t, func() { index(context.Background()) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	data, err := os.ReadFile(archive + indexSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written archiveIndex
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Format != "tar" || written.Size != int64(buf.Len()) {
+		t.Errorf("got format %s and size %d, want tar and %d", written.Format, written.Size, buf.Len())
+	}
+
+	// Global headers aren't entries, and each entry's offset is where its
+	// first header is, including the one holding its long name.
+	var names []string
+	for _, entry := range written.Entries {
+		names = append(names, entry.Name)
+		if entry.Offset == nil {
+			t.Errorf("%s has no offset", entry.Name)
+			continue
+		}
+		header, err := tar.NewReader(bytes.NewReader(buf.Bytes()[entry.Offset.Skip:])).Next()
+		if err != nil || header.Name != entry.Name {
+			t.Errorf("%s: got %v, %v at its offset", entry.Name, header, err)
+		}
+	}
+	if want := []string{"a/", "a/x", long, "l"}; !slices.Equal(names, want) {
+		t.Errorf("got entries %q, want %q", names, want)
+	}
+
+	// list and find read the index in place of the archive, which renaming
+	// an entry in it shows.
+	written.Entries[3].Name = "indexed"
+	renamed, err := json.Marshal(written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive+indexSuffix, renamed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parseCLI(t, "list", archive)
+	if got, want := captureStdout(t, func() { list(context.Background()) }), "a/\na/x\n"+long+"\nindexed\n"; got != want {
+		t.Errorf("list: got %q, want %q", got, want)
+	}
+	parseCLI(t, "find", "--name", "indexed", archive)
+	if got, want := captureStdout(t, func() { runCommand(t, func() { find(context.Background()) }) }), archive+":indexed\n"; got != want {
+		t.Errorf("find: got %q, want %q", got, want)
+	}
+
+	// cat seeks to the entry, so it's read even if the archive can't be
+	// read from the start, as long as its size is the same.
+	corrupted := bytes.Clone(buf.Bytes())
+	copy(corrupted, bytes.Repeat([]byte{0xff}, tarBlockSize))
+	if err := os.WriteFile(archive, corrupted, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parseCLI(t, "cat", archive, long)
+	if got := captureStdout(t, func() { code = runCommand(t, func() { cat(context.Background()) }) }); got != "end" || code != 0 {
+		t.Errorf("cat: got %q and exit code %d, want end and 0", got, code)
+	}
+
+	// Indexes of other formats record only the metadata of their entries.
+	zipArchive := filepath.Join(t.TempDir(), "in.zip")
+	if err := os.WriteFile(zipArchive, makeZip(t, []testEntry{{name: "z", contents: "z"}}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "z.idx")
+	parseCLI(t, "index", "-o", output, zipArchive)
+	if code := runCommand(t, func() { index(context.Background()) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	parseCLI(t, "list", output)
+	if got := captureStdout(t, func() { list(context.Background()) }); got != "z\n" {
+		t.Errorf("list of zip index: got %q, want z", got)
+	}
+	if data, err = os.ReadFile(output); err != nil {
+		t.Fatal(err)
+	}
+	var zipIndex archiveIndex
+	if err := json.Unmarshal(data, &zipIndex); err != nil {
+		t.Fatal(err)
+	}
+	if len(zipIndex.Entries) != 1 || zipIndex.Entries[0].Offset != nil {
+		t.Errorf("got entries %+v, want z without an offset", zipIndex.Entries)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+type archiveInfo struct {
+	Format         string  `json:"format"`
+	Entries        int     `json:"entries"`
+	Size           int64   `json:"size"`
+	CompressedSize int64   `json:"compressedSize"`
+	Ratio          float64 `json:"ratio"`
+	Encrypted      bool    `json:"encrypted"`
+	Comment        string  `json:"comment,omitempty"`
+
+	GzipMembers []gzipMember `json:"gzipMembers,omitempty"`
+}
+
+func info(ctx context.Context) {
+	input, format, inputR := openInput(ctx, cli.Info.Input)
+	defer closeInput(input)
+
+	size, err := input.Seek(0, io.SeekEnd)
+	if err != nil {
+		bail("failed to determine input file size: %s", err)
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		bail("failed to seek input file: %s", err)
+	}
+
+	result := archiveInfo{
+		Format:         strings.TrimPrefix(format.Extension(), "."),
+		CompressedSize: size,
+	}
+
+	switch format := format.(type) {
+	case archives.Extractor:
+		err := format.Extract(ctx, inputR, func(ctx context.Context, info archives.FileInfo) error {
+			result.Entries++
+			if !info.IsDir() {
+				result.Size += info.Size()
+			}
+			if header, ok := info.Header.(zip.FileHeader); ok && header.Flags&0x1 != 0 {
+				result.Encrypted = true
+			}
+			return nil
+		})
+		if err != nil {
+			bail("failed to read archive: %s", err)
+		}
+
+		if _, ok := format.(archives.Zip); ok {
+			zr, err := zip.NewReader(input, size)
+			if err != nil {
+				bail("failed to read zip comment: %s", err)
+			}
+			result.Comment = zr.Comment
+		}
+
+	case archives.Decompressor:
+		inputRC, err := format.OpenReader(inputR)
+		if err != nil {
+			bail("failed to create decompressor reader: %s", err)
+		}
+		defer func() {
+			if err := inputRC.Close(); err != nil {
+				bail("failed to close decompressor reader: %s", err)
+			}
+		}()
+
+		result.Entries = 1
+		result.Size, err = io.Copy(io.Discard, inputRC)
+		if err != nil {
+			bail("failed to decompress input: %s", err)
+		}
+
+	default:
+		bail("identified format doesn't support extraction or decompression")
+	}
+
+	if isGzip(format) {
+		if _, err := input.Seek(0, io.SeekStart); err != nil {
+			bail("failed to seek input file: %s", err)
+		}
+
+		result.GzipMembers, err = gzipMembers(input)
+		if err != nil {
+			bail("failed to read gzip members: %s", err)
+		}
+	}
+
+	if result.Size > 0 {
+		result.Ratio = float64(result.CompressedSize) / float64(result.Size)
+	}
+
+	if cli.Info.JSON {
+		if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+			bail("failed to encode info: %s", err)
+		}
+		return
+	}
+
+	encrypted := "no"
+	if result.Encrypted {
+		encrypted = "yes"
+	}
+	fmt.Printf("format:          %s\n", result.Format)
+	fmt.Printf("entries:         %d\n", result.Entries)
+	fmt.Printf("size:            %d\n", result.Size)
+	fmt.Printf("compressed size: %d\n", result.CompressedSize)
+	fmt.Printf("ratio:           %.3f\n", result.Ratio)
+	fmt.Printf("encrypted:       %s\n", encrypted)
+	if result.Comment != "" {
+		fmt.Printf("comment:         %s\n", result.Comment)
+	}
+	if len(result.GzipMembers) > 0 {
+		fmt.Printf("gzip members:    %d\n", len(result.GzipMembers))
+		for i, member := range result.GzipMembers {
+			fmt.Printf("  %d: size=%d name=%q modified=%s", i+1, member.Size, member.Name, member.ModTime)
+			if member.Comment != "" {
+				fmt.Printf(" comment=%q", member.Comment)
+			}
+			fmt.Println()
+		}
+	}
+}
+
+func isGzip(format archives.Format) bool {
+	if compressedArchive, ok := format.(archives.CompressedArchive); ok {
+		format = compressedArchive.Compression
+	}
+
+	_, ok := format.(archives.Gz)
+	return ok
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfo(t *testing.T) {
+	dir := t.TempDir()
+	archive := makeTar(t, []testEntry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/b", typeflag: tar.TypeReg, contents: strings.Repeat("b", 100)},
+		{name: "c", typeflag: tar.TypeReg, contents: strings.Repeat("c", 412)},
+	})
+	input := filepath.Join(dir, "in.tar")
+	if err := os.WriteFile(input, archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	parseCLI(t, "info", input)
+	want := fmt.Sprintf("format:          tar\nentries:         3\nsize:            512\ncompressed size: %d\nratio:           %.3f\nencrypted:       no\n", len(archive), float64(len(archive))/512)
+	if got := captureStdout(t, func() { info(context.Background()) }); got != want {
+		t.Errorf("tar: got %q, want %q", got, want)
+	}
+
+	// Zips have comments, and encrypted entries.
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.CreateHeader(&zip.FileHeader{Name: "secret", Flags: 0x1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("not really encrypted"))
+	w.SetComment("a comment")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	input = filepath.Join(dir, "in.zip")
+	if err := os.WriteFile(input, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parseCLI(t, "info", "--json", input)
+	var got archiveInfo
+	if err := json.Unmarshal([]byte(captureStdout(t, func() { info(context.Background()) })), &got); err != nil {
+		t.Fatal(err)
+	}
+	wantInfo := archiveInfo{
+		Format:         "zip",
+		Entries:        1,
+		Size:           int64(len("not really encrypted")),
+		CompressedSize: int64(buf.Len()),
+		Ratio:          float64(buf.Len()) / float64(len("not really encrypted")),
+		Encrypted:      true,
+		Comment:        "a comment",
+	}
+	if !reflect.DeepEqual(got, wantInfo) {
+		t.Errorf("zip: got %+v, want %+v", got, wantInfo)
+	}
+
+	// Each member of gzip files is described.
+	buf.Reset()
+	modTime := time.Unix(1e9, 0).UTC()
+	for _, name := range []string{"first", "second"} {
+		gw := gzip.NewWriter(&buf)
+		gw.Name, gw.Comment, gw.ModTime = name, "about "+name, modTime
+		gw.Write([]byte(name))
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	input = filepath.Join(dir, "in.gz")
+	if err := os.WriteFile(input, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parseCLI(t, "info", input)
+	output := captureStdout(t, func() { info(context.Background()) })
+	for _, line := range []string{
+		"format:          gz\n",
+		"entries:         1\n",
+		"size:            11\n",
+		"gzip members:    2\n",
+		fmt.Sprintf("  1: size=5 name=\"first\" modified=%s comment=\"about first\"\n", modTime),
+		fmt.Sprintf("  2: size=6 name=\"second\" modified=%s comment=\"about second\"\n", modTime),
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("gz: got %q, want it to contain %q", output, line)
+		}
+	}
+
+	parseCLI(t, "info", "--json", input)
+	got = archiveInfo{}
+	if err := json.Unmarshal([]byte(captureStdout(t, func() { info(context.Background()) })), &got); err != nil {
+		t.Fatal(err)
+	}
+	wantMembers := []gzipMember{
+		{Name: "first", Comment: "about first", ModTime: modTime, Size: 5},
+		{Name: "second", Comment: "about second", ModTime: modTime, Size: 6},
+	}
+	if !reflect.DeepEqual(got.GzipMembers, wantMembers) {
+		t.Errorf("gz: got members %+v, want %+v", got.GzipMembers, wantMembers)
+	}
+}
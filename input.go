@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// stdioPath is the path that refers to stdin when used as an input, and
+// stdout when used as an output.
+const stdioPath = "-"
+
+type inputFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// openFile opens the file at path for reading. If path names the first of a
+// sequence of split volumes, the returned file reads all of them in order. If
+// path is an HTTP or HTTPS URL, the returned file reads it remotely. If path
+// is a tape drive, named pipe or anything else that can't be seeked, the
+// returned file reads it in blocks of --block-size, and can only seek within
+// the start of it. If path names an archive within another, like
+// outer.zip/inner.tar.gz, the returned file reads that.
+func openFile(ctx context.Context, path string) (inputFile, error) {
+	if isURL(path) {
+		// Remote files resume dropped connections themselves, and retrying
+		// them with ReadAt would make a separate request for every read.
+		file, err := openURL(path)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	if outer, inner, ok := splitNestedPath(path); ok {
+		file, entry, err := openNested(ctx, outer, inner)
+		if err == nil && entry != "" {
+			file.Close()
+			return nil, &fs.PathError{Op: "open", Path: path, Err: errors.New("not an archive")}
+		}
+		return file, err
+	}
+
+	var file inputFile
+	var err error
+	if strings.HasSuffix(path, firstVolumeSuffix) {
+		file, err = openVolumes(path)
+	} else {
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if f, ok := file.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		// Reads of streams can't be retried, since they can't be seeked.
+		if isStream(info) {
+			if cli.BlockSize <= 0 {
+				f.Close()
+				return nil, errors.New("--block-size must be positive")
+			}
+			return newStreamInput(f, int(cli.BlockSize)), nil
+		}
+		if offset, length, ok := sfxArchive(f, info.Size()); ok {
+			file = sfxInput{io.NewSectionReader(f, offset, length), f}
+		}
+	}
+
+	if cli.Retries > 0 {
+		file = &retryingInput{inputFile: file, ctx: ctx}
+	}
+
+	return file, nil
+}
+
+// fileSize returns the size of file, which must be positioned at its start,
+// or -1 if it can't be determined.
+func fileSize(file inputFile) int64 {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return -1
+	}
+	return size
+}
+
+// openInput opens and identifies the file at path. The caller must close the
+// returned file with closeInput.
+func openInput(ctx context.Context, path string) (inputFile, archives.Format, io.Reader) {
+	input, format, inputR, err := identifyInput(ctx, path)
+	if err != nil {
+		bail("%s", err)
+	}
+	return input, format, inputR
+}
+
+// identifyInput is like openInput, but returns errors instead of bailing, so
+// it can be used from any goroutine. The caller must close the returned file.
+func identifyInput(ctx context.Context, path string) (inputFile, archives.Format, io.Reader, error) {
+	input, err := openFile(ctx, path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+
+	format, inputR, err := identify(ctx, path, input)
+	if err != nil {
+		input.Close()
+		return nil, nil, nil, fmt.Errorf("failed to identify format: %w", err)
+	}
+	logger.Debug("identified format", "input", path, "format", format.Extension())
+
+	return input, format, inputR, nil
+}
+
+func closeInput(input inputFile) {
+	if err := input.Close(); err != nil {
+		bail("failed to close input file: %s", err)
+	}
+}
+
+// openExtractor is like openInput, but additionally bails if the identified
+// format doesn't support extraction.
+func openExtractor(ctx context.Context, path string) (inputFile, archives.Extractor, io.Reader) {
+	input, format, inputR := openInput(ctx, path)
+
+	extractor, ok := format.(archives.Extractor)
+	if !ok {
+		closeInput(input)
+		bail("identified format doesn't support extraction")
+	}
+
+	return input, extractor, inputR
+}
@@ -0,0 +1,111 @@
+// Package safepath checks that the entries extracted from archives stay inside
+// the output directory, including through the symbolic links among them. It's
+// shared by the squish command and the squish package, so that both refuse the
+// same entries.
+package safepath
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Links are the symbolic links extracted into an output directory so far, by
+// their paths in it. The zero value has none.
+type Links struct {
+	paths map[string]bool
+	// dirs are the directories that were found not to be symbolic links
+	// when Through checked them, which only extracting a link over one can
+	// change.
+	dirs map[string]bool
+}
+
+// Add records that name is a symbolic link.
+func (l *Links) Add(name string) {
+	if l.paths == nil {
+		l.paths = map[string]bool{}
+	}
+	l.paths[name] = true
+	delete(l.dirs, name)
+}
+
+// Remove records that name, which may have been a symbolic link, has been
+// removed.
+func (l *Links) Remove(name string) {
+	delete(l.paths, name)
+	delete(l.dirs, name)
+}
+
+// Escapes reports whether a symbolic link named name, with the slash-separated
+// target, could point outside of the output directory. Targets may only
+// leave the directory of the link with leading .. elements, since a .. after
+// any other element, like sub/.., may go back up from wherever a link named
+// by that element, even one that's only extracted later, points, so they're
+// all treated as escaping. Targets that only descend after that stay inside
+// the output, since the links they pass through were checked in the same way.
+func (l *Links) Escapes(name, target string) bool {
+	if path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return true
+	}
+
+	depth := 0
+	if dir := filepath.Dir(name); dir != "." {
+		depth = len(strings.Split(dir, string(filepath.Separator)))
+	}
+	descended := false
+	for _, elem := range strings.Split(filepath.ToSlash(target), "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if descended || depth == 0 {
+				return true
+			}
+			depth--
+		default:
+			descended = true
+		}
+	}
+	return false
+}
+
+// Through returns the symbolic link that name would be written through, if
+// there is one, either among the links that were added, or among its parent
+// directories beneath the output directory, which lstat is called with the
+// names of, for links that were already there. Absolute names, which are only
+// extracted with --absolute-names, are only checked against the links that
+// were added.
+func (l *Links) Through(name string, lstat func(name string) (fs.FileInfo, error)) (string, bool, error) {
+	for dir := filepath.Dir(name); dir != "." && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if l.paths[dir] {
+			return dir, true, nil
+		}
+	}
+	if !filepath.IsLocal(name) {
+		return "", false, nil
+	}
+
+	var parents []string
+	for dir := filepath.Dir(name); dir != "." && !l.dirs[dir]; dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+	}
+	// Parents are checked from the top, so that those beneath a link are
+	// never looked up through it.
+	for i := len(parents) - 1; i >= 0; i-- {
+		info, err := lstat(parents[i])
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", false, nil
+		} else if err != nil {
+			return "", false, err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return parents[i], true, nil
+		}
+		if l.dirs == nil {
+			l.dirs = map[string]bool{}
+		}
+		l.dirs[parents[i]] = true
+	}
+	return "", false, nil
+}
@@ -0,0 +1,102 @@
+package safepath
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEscapes(t *testing.T) {
+	var links Links
+	links.Add("a")
+	links.Add(filepath.Join("d", "up"))
+
+	tests := []struct {
+		name, target string
+		want         bool
+	}{
+		{"l", "b", false},
+		{"l", "/etc/passwd", true},
+		{"l", "..", true},
+		{filepath.Join("d", "l"), "../b", false},
+		{filepath.Join("d", "l"), "../..", true},
+		{"l", "a/b", false},
+		{"l", "a/..", true},
+		// a is a link to ., so a/a/.. is the output directory, and a/a/../..
+		// its parent.
+		{"l", "a/a/../..", true},
+		{"l", "a/a/a/x/../..", true},
+		{"l", "d/up/../b", true},
+		// x may be a link that's only extracted later.
+		{"l", "d/x/../b", true},
+		{filepath.Join("d", "l"), "x/../../b", true},
+		{filepath.Join("d", "e", "l"), "../../b/c", false},
+	}
+	for _, test := range tests {
+		if got := links.Escapes(test.name, test.target); got != test.want {
+			t.Errorf("%s -> %s: got escapes %t, want %t", test.name, test.target, got, test.want)
+		}
+	}
+
+	// Neither order of l -> sub/.. and sub -> . gets both extracted, since
+	// together they point l at the parent of the output directory.
+	for _, order := range [][2]string{{"l", "sub"}, {"sub", "l"}} {
+		var links Links
+		targets := map[string]string{"l": "sub/..", "sub": "."}
+		escaped := false
+		for _, name := range order {
+			if links.Escapes(name, targets[name]) {
+				escaped = true
+				continue
+			}
+			links.Add(name)
+		}
+		if !escaped {
+			t.Errorf("%s then %s: both extracted", order[0], order[1])
+		}
+	}
+}
+
+func TestThrough(t *testing.T) {
+	dir := t.TempDir()
+	lstat := func(name string) (fs.FileInfo, error) {
+		return os.Lstat(filepath.Join(dir, name))
+	}
+
+	var links Links
+	links.Add("a")
+	if link, ok, err := links.Through(filepath.Join("a", "b", "c"), lstat); err != nil || !ok || link != "a" {
+		t.Errorf("got %q, %t, %v, want a", link, ok, err)
+	}
+	if _, ok, err := links.Through("a", lstat); err != nil || ok {
+		t.Errorf("a itself is written through a link: %v", err)
+	}
+
+	links.Remove("a")
+	if link, ok, err := links.Through(filepath.Join("a", "b"), lstat); err != nil || ok {
+		t.Errorf("got %q, %v after removing a", link, err)
+	}
+
+	// Links that were already in the output directory are found too, even
+	// beneath directories that were checked before.
+	if err := os.MkdirAll(filepath.Join(dir, "d", "e"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if link, ok, err := links.Through(filepath.Join("d", "e", "f"), lstat); err != nil || ok {
+		t.Errorf("got %q, %v through directories", link, err)
+	}
+	if err := os.Symlink("..", filepath.Join(dir, "d", "up")); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join("d", "up")
+	if link, ok, err := links.Through(filepath.Join("d", "up", "f"), lstat); err != nil || !ok || link != want {
+		t.Errorf("got %q, %t, %v, want %s", link, ok, err, want)
+	}
+
+	// Absolute names are only checked against the links that were added.
+	abs := filepath.Join(dir, "d", "up", "f")
+	if link, ok, err := links.Through(abs, lstat); err != nil || ok {
+		t.Errorf("got %q, %v for an absolute name", link, err)
+	}
+}
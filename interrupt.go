@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mholt/archives"
+)
+
+// interruptible returns a context derived from ctx that's canceled when squish
+// is sent SIGINT or SIGTERM, so that commands stop promptly and remove or mark
+// what they partially wrote, rather than being killed partway through.
+// Interrupting squish again kills it straight away.
+func interruptible(ctx context.Context) context.Context {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		logMessage(slog.LevelWarn, nil, "interrupted, stopping (interrupt again to stop immediately)")
+	}()
+	return ctx
+}
+
+// contextReader fails reads once ctx is canceled, so that long copies that
+// archives doesn't check the context during stop promptly.
+type contextReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
+
+// interruptibleFile wraps file so that reading it fails once ctx is canceled,
+// so that archiving a large file stops promptly.
+func interruptibleFile(ctx context.Context, file archives.FileInfo) archives.FileInfo {
+	if file.Open == nil {
+		return file
+	}
+	open := file.Open
+	file.Open = func() (fs.File, error) {
+		f, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return contextFile{f, contextReader{ctx, f}}, nil
+	}
+	return file
+}
+
+type contextFile struct {
+	fs.File
+	r contextReader
+}
+
+func (f contextFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+)
+
+func join(_ context.Context) {
+	if !strings.HasSuffix(cli.Join.Input, firstVolumeSuffix) {
+		bail("input must be the first volume, ending in %s", firstVolumeSuffix)
+	}
+
+	input, err := openVolumes(cli.Join.Input)
+	if err != nil {
+		bail("failed to open input volumes: %s", err)
+	}
+	defer closeInput(input)
+
+	output := trimVolumeSuffix(cli.Join.Input)
+	if cli.Join.Output != nil {
+		output = *cli.Join.Output
+	}
+
+	outputF, err := os.Create(output)
+	if err != nil {
+		bail("failed to create output file: %s", err)
+	}
+	defer func() {
+		if err := outputF.Close(); err != nil {
+			bail("failed to close output file: %s", err)
+		}
+	}()
+
+	if _, err := io.Copy(outputF, input); err != nil {
+		bail("failed to copy input volumes to output file: %s", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// limitError is returned once extraction exceeds one of --max-output-size,
+// --max-entries or --max-ratio.
+type limitError struct {
+	limit string
+}
+
+func (e *limitError) Error() string {
+	return "extraction exceeded " + e.limit + ", so the input may be a decompression bomb"
+}
+
+// extractLimits guards against decompression bombs, which are small inputs
+// that expand to enough data or entries to fill the output's filesystem, by
+// failing once they're exceeded. A nil *extractLimits is valid and limits
+// nothing.
+type extractLimits struct {
+	maxSize    int64
+	maxEntries int64
+	maxRatio   float64
+	// inputSize returns the size of the input that the output is compared
+	// to by maxRatio, which is what's been read of it so far if its size
+	// isn't known.
+	inputSize func() int64
+
+	entries, written atomic.Int64
+}
+
+func newExtractLimits(maxSize int64, maxEntries int64, maxRatio float64, inputSize func() int64) *extractLimits {
+	if maxSize <= 0 && maxEntries <= 0 && maxRatio <= 0 {
+		return nil
+	}
+	return &extractLimits{maxSize: maxSize, maxEntries: maxEntries, maxRatio: maxRatio, inputSize: inputSize}
+}
+
+// entry counts an entry that's about to be extracted.
+func (l *extractLimits) entry() error {
+	if l == nil {
+		return nil
+	}
+	if n := l.entries.Add(1); l.maxEntries > 0 && n > l.maxEntries {
+		return &limitError{fmt.Sprintf("--max-entries of %d", l.maxEntries)}
+	}
+	return nil
+}
+
+// grow counts size bytes that are about to be written.
+func (l *extractLimits) grow(size int64) error {
+	if l == nil {
+		return nil
+	}
+	written := l.written.Add(size)
+	if l.maxSize > 0 && written > l.maxSize {
+		return &limitError{fmt.Sprintf("--max-output-size of %s", byteSize(l.maxSize))}
+	}
+	if l.maxRatio > 0 {
+		if inputSize := l.inputSize(); inputSize > 0 && float64(written) > l.maxRatio*float64(inputSize) {
+			return &limitError{fmt.Sprintf("--max-ratio of %g", l.maxRatio)}
+		}
+	}
+	return nil
+}
+
+// fits reports whether size more bytes could be written without exceeding
+// the limits, without counting them.
+func (l *extractLimits) fits(size int64) bool {
+	if l == nil {
+		return true
+	}
+	written := l.written.Load() + size
+	if l.maxSize > 0 && written > l.maxSize {
+		return false
+	}
+	if l.maxRatio > 0 {
+		if inputSize := l.inputSize(); inputSize > 0 && float64(written) > l.maxRatio*float64(inputSize) {
+			return false
+		}
+	}
+	return true
+}
+
+// limitWriter counts each write against limits before making it.
+type limitWriter struct {
+	io.Writer
+	limits *extractLimits
+}
+
+func (w limitWriter) Write(p []byte) (int, error) {
+	if err := w.limits.grow(int64(len(p))); err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(p)
+}
+
+// countingReader counts the bytes read from it, for --max-ratio to compare
+// the output to when the size of the input isn't known.
+type countingReader struct {
+	io.Reader
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
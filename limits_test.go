@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestExtractLimits(t *testing.T) {
+	if newExtractLimits(0, 0, 0, nil) != nil {
+		t.Error("got limits without any being given")
+	}
+
+	l := newExtractLimits(0, 2, 0, nil)
+	for i := range 3 {
+		var limitErr *limitError
+		if err := l.entry(); errors.As(err, &limitErr) != (i == 2) {
+			t.Errorf("got %v for entry %d with --max-entries 2", err, i+1)
+		}
+	}
+
+	var buf bytes.Buffer
+	w := limitWriter{&buf, newExtractLimits(10, 0, 0, nil)}
+	if !w.limits.fits(10) {
+		t.Error("10 bytes didn't fit within --max-output-size 10")
+	}
+	if _, err := w.Write(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if w.limits.fits(1) {
+		t.Error("a byte fit past --max-output-size")
+	}
+	if n, err := w.Write([]byte{0}); n != 0 || err == nil {
+		t.Errorf("got %d, %v writing past --max-output-size, want 0 and an error", n, err)
+	}
+
+	inputSize := int64(0)
+	l = newExtractLimits(0, 0, 2.5, func() int64 { return inputSize })
+	// Nothing can be compared to an input that hasn't been read yet.
+	if err := l.grow(10); err != nil {
+		t.Error(err)
+	}
+	inputSize = 4
+	if err := l.grow(0); err != nil {
+		t.Error(err)
+	}
+	if err := l.grow(1); err == nil {
+		t.Error("grew past --max-ratio")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+func list(ctx context.Context) {
+	input, entry := nestedEntry(ctx, cli.List.Input)
+	// The comments of zip entries aren't kept in indexes.
+	walkEntries(ctx, input, cli.List.IgnoreZeros, len(cli.List.Meta) == 0 && !cli.List.Comments, func(ctx context.Context, info archives.FileInfo) error {
+		if !typeMatches(cli.List.Type, info) || !metaMatches(cli.List.Meta, info) {
+			return nil
+		}
+		if entry != "" && !entryWithin(info.NameInArchive, entry) {
+			return nil
+		}
+
+		if header, ok := info.Header.(zip.FileHeader); ok && cli.List.Comments && header.Comment != "" {
+			fmt.Printf("%s\t%s\n", info.NameInArchive, header.Comment)
+			return nil
+		}
+		fmt.Println(info.NameInArchive)
+		return nil
+	})
+}
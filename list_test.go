@@ -0,0 +1,39 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListTypes(t *testing.T) {
+	saved := cli
+	t.Cleanup(func() { cli = saved })
+	cli.List.Input = filepath.Join(t.TempDir(), "in.tar")
+	archive := makeTar(t, []testEntry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/b", typeflag: tar.TypeReg, contents: "b"},
+		{name: "a/link", typeflag: tar.TypeSymlink, linkname: "b"},
+		{name: "c/", typeflag: tar.TypeDir},
+	})
+	if err := os.WriteFile(cli.List.Input, archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		types []string
+		want  string
+	}{
+		{nil, "a/\na/b\na/link\nc/\n"},
+		{[]string{"d"}, "a/\nc/\n"},
+		{[]string{"f", "l"}, "a/b\na/link\n"},
+	}
+	for _, test := range tests {
+		cli.List.Type = test.types
+		if got := captureStdout(t, func() { list(context.Background()) }); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.types, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// locales holds a catalog for each supported language other than English,
+// named after its language code, which maps the format strings of messages
+// to their translations.
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+// catalog holds the translations for the --lang language, or is nil for
+// English.
+var catalog map[string]string
+
+// setupLocale loads the catalog for lang, or if it's empty, the language given
+// by the environment as gettext would find it. Unsupported languages are only
+// an error if they're given explicitly.
+func setupLocale(lang string) error {
+	explicit := lang != ""
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if lang != "" {
+			break
+		}
+		lang = os.Getenv(name)
+	}
+
+	// Strip the encoding and modifier, as in de_DE.UTF-8@euro, then try the
+	// language with and without its territory.
+	lang, _, _ = strings.Cut(lang, ".")
+	lang, _, _ = strings.Cut(lang, "@")
+	language, _, _ := strings.Cut(lang, "_")
+	if lang == "" || lang == "C" || lang == "POSIX" || language == "en" {
+		return nil
+	}
+
+	for _, candidate := range []string{lang, language} {
+		data, err := locales.ReadFile(path.Join("locales", candidate+".json"))
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(err)
+		}
+		return nil
+	}
+
+	if explicit {
+		return fmt.Errorf("unsupported language %q", lang)
+	}
+	return nil
+}
+
+// localize formats a message like fmt.Sprintf, using the translation of
+// format in the catalog if there is one.
+func localize(format string, a ...any) string {
+	if translated, ok := catalog[format]; ok {
+		format = translated
+	}
+	return fmt.Sprintf(format, a...)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+var formatVerb = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+// TestLocales checks that every translation uses the same formatting verbs in
+// the same order as the message it translates, so that its arguments are
+// formatted correctly.
+func TestLocales(t *testing.T) {
+	names, err := fs.Glob(locales, "locales/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		data, err := locales.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		for format, translated := range catalog {
+			want := formatVerb.FindAllString(format, -1)
+			if got := formatVerb.FindAllString(translated, -1); !slices.Equal(got, want) {
+				t.Errorf("%s: %q: got verbs %q, want %q", name, format, got, want)
+			}
+		}
+	}
+}
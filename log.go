@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// logger reports errors, warnings and diagnostics to stderr, at the level and
+// in the format given by --log-level and --log-format.
+var logger = slog.New(newTextHandler(os.Stderr, slog.LevelInfo))
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+func setupLogging(level, format string) {
+	options := &slog.HandlerOptions{Level: logLevels[level]}
+	switch format {
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, options))
+	default:
+		logger = slog.New(newTextHandler(os.Stderr, options.Level.Level()))
+	}
+}
+
+// logMessage logs the message given by format and a at level. Text logs are
+// translated into the --lang language, while JSON logs are left in English,
+// and have the fields of details as attributes, so that they can be parsed.
+func logMessage(level slog.Level, details map[string]any, format string, a ...any) {
+	if cli.LogFormat != "json" {
+		logger.Log(context.Background(), level, localize(format, a...))
+		return
+	}
+
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	var args []any
+	for _, key := range keys {
+		args = append(args, key, details[key])
+	}
+	logger.Log(context.Background(), level, fmt.Sprintf(format, a...), args...)
+}
+
+// textHandler is a slog.Handler that writes one human-readable line per
+// record: the message, prefixed with the level unless it's an error or
+// informational, followed by any attributes as key=value pairs.
+type textHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Level
+	attrs  []slog.Attr
+	prefix string
+}
+
+func newTextHandler(w io.Writer, level slog.Level) *textHandler {
+	return &textHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, record slog.Record) error {
+	var b strings.Builder
+	switch {
+	case record.Level >= slog.LevelError, record.Level == slog.LevelInfo:
+	case record.Level >= slog.LevelWarn:
+		b.WriteString("warning: ")
+	default:
+		b.WriteString("debug: ")
+	}
+	b.WriteString(record.Message)
+
+	for _, attr := range h.attrs {
+		fmt.Fprintf(&b, " %s=%s", attr.Key, attr.Value)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		fmt.Fprintf(&b, " %s%s=%s", h.prefix, attr.Key, attr.Value)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	for _, attr := range attrs {
+		attr.Key = h.prefix + attr.Key
+		clone.attrs = append(clone.attrs[:len(clone.attrs):len(clone.attrs)], attr)
+	}
+	return &clone
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.prefix += name + "."
+	return &clone
+}
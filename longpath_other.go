@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// extendedLengthPath returns path, since only Windows limits the length of
+// paths.
+func extendedLengthPath(path string) string {
+	return path
+}
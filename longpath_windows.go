@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// extendedLengthPath returns path as an extended-length path, prefixed with
+// \\?\, so that entries nested deeper than MAX_PATH can be created beneath it.
+func extendedLengthPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -3,239 +3,494 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
+	"log/slog"
 	"os"
-	"path/filepath"
 	"runtime"
-	"strings"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/alecthomas/kong"
-	"github.com/mholt/archives"
 )
 
 var cli struct {
+	Retries     int           `placeholder:"N" help:"Retry reads and writes that fail with transient I/O errors up to N times."`
+	RetryDelay  time.Duration `default:"1s" help:"The delay before the first retry, doubled for each subsequent retry."`
+	Progress    bool          `negatable:"" default:"${progress}" help:"Show the number of bytes processed overall and for the current entry, the throughput, and the estimated time remaining when it's known. Enabled by default when stderr is a terminal."`
+	Verbose     int           `short:"v" type:"counter" help:"Print the name of each entry as it's archived or extracted. Given twice, also print each entry's mode and size."`
+	FailOnWarn  bool          `help:"Exit with a non-zero status if any warnings were reported, once the operation is finished."`
+	LogLevel    string        `enum:"debug,info,warn,error" default:"info" help:"Only log messages at or above this level: debug, info, warn, or error."`
+	LogFormat   string        `enum:"text,json" default:"text" help:"Log messages as lines of text, or as JSON objects."`
+	Lang        string        `placeholder:"LANG" help:"The language to log messages in, like de. Defaults to the language given by the LC_ALL, LC_MESSAGES or LANG environment variables. Untranslated messages, JSON logs and events are in English."`
+	Porcelain   string        `enum:",json" default:"" help:"Write machine-readable events to --porcelain-fd as they happen: json writes one JSON object per line, whose event field is entry_start, entry_end, entry_result, progress, warning, error, or summary. When extracting, an entry_result event is written once each entry is in place, or skipped, with the entry's name, its path in the output, the action taken (created, replaced, rewritten, resumed, merged, unchanged or skipped), its size in bytes, the SHA-256 digest of regular files' contents, and any warnings about it. Error and warning events also have a category field, and code and entry fields when they're known, which are also logged with --log-format=json."`
+	PorcelainFD int           `name:"porcelain-fd" default:"1" placeholder:"FD" help:"The file descriptor to write --porcelain events to."`
+	History     string        `placeholder:"PATH" env:"SQUISH_HISTORY" help:"The file to record each operation in, which log reads. Defaults to squish/history.jsonl in $XDG_STATE_HOME, or in ~/.local/state."`
+	NoHistory   bool          `help:"Don't record the operation in the history."`
+	BlockSize   byteSize      `default:"64K" placeholder:"SIZE" help:"Read inputs that can't be seeked, like tape drives and named pipes, in blocks of SIZE, which must be at least the size of the blocks on tapes, like tar --record-size. Such inputs can be given by path, like /dev/nst0, but formats that need random access, like zip, can't be read from them."`
+	Nice        bool          `help:"Run at a lower CPU and I/O priority, like nice and ionice -c2 -n7, and use a quarter of the CPUs by default where --threads is accepted, so that background jobs like scheduled backups don't make the machine sluggish. Priorities are only lowered on Linux."`
+	Charset     string        `enum:"auto,utf-8,cp437,cp850,cp852,cp866,windows-1250,windows-1251,windows-1252,iso-8859-1,shift-jis,euc-jp,gbk,gb18030,big5,euc-kr" default:"auto" help:"The character set to decode the names of zip entries that aren't marked as UTF-8 with, so that zips from older Windows, Japanese or Chinese tools are read with the right names: auto, utf-8 to leave them as they are, cp437, cp850, cp852, cp866, windows-1250, windows-1251, windows-1252, iso-8859-1, shift-jis, euc-jp, gbk, gb18030, big5 or euc-kr. auto leaves names that are valid UTF-8 alone, and guesses the character set of others, preferring that of the locale, like shift-jis for ja_JP, then shift-jis for names with kana, gbk for names with only Chinese characters, and otherwise cp437, which zips default to."`
+	LimitRate   byteSize      `placeholder:"SIZE" help:"Limit how fast create writes the archive, and extract reads it, to SIZE per second, e.g. 10M, so that large backups don't saturate shared network links, like those to URLs, or slow disks."`
+	MaxMemory   byteSize      `placeholder:"SIZE" help:"Fit the buffers of create and extract within about SIZE of memory, e.g. 256M, for running in constrained containers. ${memory_help}"`
+	Stats       bool          `help:"Once the operation is finished, print to stderr how long was spent walking the inputs, reading inputs, writing outputs, and compressing or decompressing, along with how much memory was used, to diagnose why it was slow. Times spent by several threads at once are summed, so they can add up to more than the total."`
+	CPUProfile  string        `name:"cpuprofile" type:"path" hidden:"" help:"Write a CPU profile to this file, for go tool pprof."`
+	MemProfile  string        `name:"memprofile" type:"path" hidden:"" help:"Write a profile of the memory allocated to this file once the operation is finished, for go tool pprof."`
+	Trace       string        `type:"path" hidden:"" help:"Write an execution trace to this file, for go tool trace."`
+	HelpLong    helpLong      `help:"Show the help of every command and flag, along with the formats, the config file and the exit statuses, and exit."`
+
 	Create struct {
-		Output string   `arg:"" help:"The path of the archive or compressed file to create."`
-		Inputs []string `arg:"" optional:"" help:"The files to include in the output. Exactly one input must be provided when the output is a compressed file."`
+		Output string   `arg:"" help:"The path of the archive or compressed file to create, an s3://BUCKET/KEY, gs://BUCKET/KEY or az://ACCOUNT/CONTAINER/BLOB URL to upload it to, or - for stdout. ${template_help}"`
+		Inputs []string `arg:"" optional:"" help:"The files to include in the output. Exactly one input must be provided when the output is a compressed file, which may be - for stdin."`
+
+		Format            string             `help:"Use the given format instead of identifying it from the output path. ${format_help}"`
+		SplitSize         byteSize           `placeholder:"SIZE" help:"Split the output into numbered volumes (OUTPUT.001, OUTPUT.002, ...) of at most this size, e.g. 2G. The last volume is always smaller than the rest, and empty if the rest hold everything, so that it's noticed if it's missing when they're read."`
+		Dedup             bool               `help:"Store regular files whose contents are the same as an earlier file's as hard links to it, found by comparing the SHA-256 digests of files of the same size, so that trees with many identical files, like vendored dependencies, are much smaller. Only tar archives can store hard links, which are extracted as hard links too."`
+		Also              []string           `placeholder:"PATH" help:"Also write the archive to PATH, in the format identified from its name, e.g. --also out.zip with out.tar.gz, from the same inputs, which are only read once for all of the outputs. The options for the main output and its format, like --level and --sign, don't apply to it. ${template_help}"`
+		Comment           string             `placeholder:"TEXT" help:"Give zips the comment TEXT, which unzip prints before extracting them, and info and comment show. Change it later with comment."`
+		BlockingFactor    int                `default:"20" placeholder:"N" help:"Write outputs that are tape drives or named pipes, like /dev/nst0, in records of N 512-byte blocks, like tar --blocking-factor, padding the last record with zeros. Defaults to tar's 20, for records of 10 KiB."`
+		TapeLength        byteSize           `placeholder:"SIZE" help:"Write at most SIZE to each tape when the output is a tape drive, like tar --tape-length, then ask on stderr for the next tape to be inserted, which is also asked for when the drive reports the end of a tape. The tapes hold consecutive parts of the archive, which have to be joined in order to read it, e.g. by copying each with dd."`
+		Auto              bool               `help:"Choose the format and level from a sample of the inputs, logging the reasoning: zip without compression when nearly all of the data is already compressed, like JPEGs or videos, tar.zst at level 19 when it's mostly text, and tar.zst at its default level otherwise. Files are judged by their extensions, sniffed content types, and the entropy of their first 64 KiB. The extension of the chosen format is appended to the output path, before that of any encryption."`
+		Level             *int               `placeholder:"N" help:"Compress at level N rather than the default level of the format. ${level_help}"`
+		Threads           int                `default:"${num_cpu}" placeholder:"N" help:"Compress using up to N threads, defaulting to the number of CPUs. ${threads_help}"`
+		Mode              *modeChange        `placeholder:"MODE" help:"Change the permissions of every archived entry. ${mode_help}"`
+		Xattrs            bool               `help:"Store the extended attributes of inputs, such as SELinux labels and file capabilities, in tar archives as PAX records, except POSIX ACLs, which are stored by --acls (Linux only)."`
+		ACLs              bool               `name:"acls" help:"Store the POSIX ACLs of inputs in tar archives, as the extended attributes that Linux keeps them in (Linux only)."`
+		Capabilities      bool               `help:"Store the file capabilities of inputs, as set by setcap, in tar archives, without the rest of their extended attributes, which are stored by --xattrs (Linux only)."`
+		Meta              entryMetas         `placeholder:"GLOB KEY=VALUE" help:"Attach the metadata KEY=VALUE to the entries matching GLOB, as they're named in the archive, e.g. --meta 'bin/*' commit=1a2b3c, so that provenance travels inside the archive. It's stored as PAX records in tar archives, and in an extra field of zips, shown by stat, and matched by list --meta. Given more than once, later values replace earlier ones with the same key. ${glob_help}"`
+		Include           []glob             `placeholder:"GLOB" help:"Only archive entries matching any of these patterns, along with their contents and parent directories. ${glob_help}"`
+		Exclude           []glob             `placeholder:"GLOB" help:"Don't archive entries matching any of these patterns, or their contents, even if they're included. ${glob_help}"`
+		IncludeType       []typePattern      `placeholder:"TYPE" help:"Only archive the regular files whose contents are of a type matching any of these patterns, e.g. text/*, along with their parent directories, regardless of their names. ${type_help}"`
+		ExcludeType       []typePattern      `placeholder:"TYPE" help:"Don't archive the regular files whose contents are of a type matching any of these patterns, e.g. video/*, even if they're included. ${type_help}"`
+		NewerMtime        *timeBound         `placeholder:"TIME" help:"Only archive entries modified after TIME, like tar --newer-mtime, along with the directories that contain them. ${time_bound_help}"`
+		OlderMtime        *timeBound         `placeholder:"TIME" help:"Only archive entries modified before TIME, along with the directories that contain them. ${time_bound_help}"`
+		MinSize           byteSize           `placeholder:"SIZE" help:"Only archive regular files of at least SIZE, e.g. 1K, along with the other entries."`
+		MaxSize           byteSize           `placeholder:"SIZE" help:"Only archive regular files of at most SIZE, e.g. 1G, along with the other entries."`
+		Gitignore         bool               `help:"Don't archive files ignored by .gitignore files in the inputs, which apply to the directory containing them and its contents, or .git directories."`
+		IgnoreFile        []string           `type:"existingfile" placeholder:"PATH" help:"Don't archive files ignored by the patterns in this file, which is in .gitignore format and applies to each input."`
+		FilesFrom         string             `placeholder:"PATH" help:"Also archive each path listed in this file, or - for stdin, one per line. Listed directories are archived without their contents, and entries are named with the listed paths."`
+		SpecialFiles      bool               `help:"Store named pipes and character and block devices in tar archives, rather than skipping them with a warning, so that /dev and chroots can be backed up. Sockets are always skipped."`
+		Dereference       bool               `help:"Archive the files and directories that symbolic links point to in place of the links, like tar --dereference, rather than storing the links themselves. Broken links, and links to directories that contain them, are stored as links with a warning."`
+		Null              bool               `short:"0" help:"Separate the paths listed in --files-from with NUL bytes instead of newlines, as with find -print0 or git ls-files -z."`
+		Directory         string             `short:"C" type:"existingdir" placeholder:"DIR" help:"Resolve relative inputs and the paths listed in --files-from relative to DIR instead of the current directory."`
+		AbsoluteNames     bool               `short:"P" help:"Keep the leading / of entry names that are absolute paths, like those listed in --files-from or given by --transform, rather than removing it with a warning, like tar -P."`
+		Prefix            string             `placeholder:"NAME/" help:"Nest every entry under this directory in the archive. --include and --exclude patterns are matched before it's added."`
+		Transform         []transform        `sep:"none" placeholder:"RULE" help:"Rename entries in the archive with a sed-style rule, e.g. s|^build/|artifacts/|. Rules are applied after --include and --exclude and before --prefix. ${transform_help}"`
+		MinifyJSON        []glob             `name:"minify-json" placeholder:"GLOB" help:"Remove insignificant whitespace from the JSON files matching any of these patterns as they're archived. Files that aren't valid JSON are archived unchanged with a warning. ${glob_help}"`
+		StripBinaries     []glob             `placeholder:"GLOB" help:"Remove symbols and debugging information from the executables and libraries matching any of these patterns as they're archived, by running strip on a temporary copy of each, e.g. '*.so'. Files that strip fails for are archived unchanged with a warning. Files matching --minify-json are only minified."`
+		GzipName          string             `placeholder:"NAME" help:"Store NAME as the original file name in the header of gzip output, which gunzip -N restores it as. It must be in Latin-1."`
+		GzipMtime         *timestamp         `placeholder:"TIME" help:"Store TIME as the modification time in the header of gzip output, given in RFC 3339 format, as a date (2006-01-02), or as seconds since the Unix epoch prefixed with @. Gzip headers otherwise have neither a name nor a modification time, so the output doesn't depend on when or from what it was created."`
+		Rsyncable         bool               `help:"Compress gzip output so that changes to the input only change the output nearby, like gzip --rsyncable, by resetting the compressor at points chosen by the contents, so that rsync and similar tools can transfer new versions efficiently. The output is slightly larger, and is compressed using a single thread."`
+		ZstdLong          bool               `help:"Compress zstd output with a 128 MiB window, like zstd --long, so that data repeated far apart, like in archives of VM images or database dumps, is only stored once. Compressing and decompressing it use about 128 MiB more memory. The number of workers is set by --threads."`
+		ZstdWindowLog     int                `placeholder:"N" help:"Compress zstd output with a window of 2^N bytes, from 10 to 29, instead of the level's default of 4 or 8 MiB. Windows over 128 MiB (27) need zstd --long=N or --memory to decompress with zstd."`
+		Dictionary        string             `type:"existingfile" placeholder:"FILE" help:"Compress zstd output with the dictionary in FILE, as written by train-dict or zstd --train, which greatly improves how well small files like logs and JSON records compress. The same dictionary is needed to extract it, with extract --dictionary or zstd -D."`
+		Compat            string             `enum:",windows,macos,busybox" default:"" help:"Create an archive that the given platform's built-in tools can open. windows and macos create zips compressed with deflate: windows only stores MS-DOS attributes, skips symbolic links, and warns about names that aren't valid on Windows, while macos stores Unix modes and symbolic links as Archive Utility expects. busybox creates plain ustar archives without PAX or GNU extensions, skipping entries with a warning if they can't be represented."`
+		TarFormat         string             `enum:",pax,ustar,gnu" default:"" help:"Write every header of tar archives in this format, rather than in the oldest one that can represent each entry: pax keeps long names, large sizes, sub-second and access and change times, extended attributes and --meta in extended headers, for full fidelity; ustar writes plain headers that old tools can read, skipping entries with a warning if they can't be represented; and gnu uses GNU extensions for long names and large sizes, dropping extended attributes and --meta with a warning."`
+		ZipMethod         string             `enum:",store,deflate,zstd,auto" default:"" help:"Compress every file in zips with this method: store leaves them uncompressed, deflate is what every zip tool can extract, and zstd compresses better and faster, but fewer tools support it. auto deflates files, except those that are already compressed, like images, videos and archives, judged by their extensions or the start of their contents, which are stored rather than wasting time deflating them. Zips are otherwise stored, unless --level is given."`
+		Zip64             string             `name:"zip64" enum:"auto,always,never" default:"auto" help:"Whether zips have zip64 records, which are needed for zips of 4 GiB or more, files of 4 GiB or more, or 65,535 or more entries, but which some old tools can't read. auto adds them only where they're needed, always adds them to every entry, and never fails with an error if they'd be needed. --compat=windows implies never, since older versions of Windows can't open zips with them."`
+		Password          password           `placeholder:"PASSWORD" env:"SQUISH_PASSWORD" help:"Encrypt the files in a zip with AES-256 using PASSWORD, as WinZip and 7-Zip do, so that most zip tools can extract them. Given as --password without a value, the password is read from stdin, and asked for twice when stdin is a terminal. Names, symbolic link targets and other metadata aren't encrypted."`
+		Encrypt           []encryptRecipient `placeholder:"SCHEME:RECIPIENT" help:"Encrypt the output to this recipient as it's written: age:RECIPIENT encrypts it with age in-process to an X25519 recipient like age1..., and gpg:KEY encrypts it with gpg like --gpg-recipient. All recipients must use the same scheme. The output's format is identified with any .age extension removed, and age-encrypted inputs are decrypted with --identity when extracting."`
+		Recipient         []string           `name:"gpg-recipient" placeholder:"KEY" help:"Encrypt the output with gpg to this recipient, given as a key ID, fingerprint or user ID. The output's format is identified with any .gpg, .pgp or .asc extension removed, and .asc outputs are ASCII-armored. Encrypted inputs are decrypted with gpg automatically when extracting."`
+		Sign              string             `type:"existingfile" placeholder:"KEY" help:"Sign the output with the Ed25519 private key in this file, given in PEM format as written by openssl genpkey -algorithm ed25519, or as an unencrypted OpenSSH key, writing a detached signature to the output path with .sig appended. Signatures are in the format of ssh-keygen -Y sign with the file namespace, and are checked by extract --verify."`
+		ListedIncremental string             `placeholder:"SNAPSHOT" help:"Only archive the files that are new or changed since the snapshot at this path was written, judged by their size, modification time and mode, like tar --listed-incremental, and replace it with a snapshot of every input once the archive is created. If it doesn't exist yet, every file is archived, so copies of a first snapshot can be used to create level 1 archives. Directories are always archived, but deleted files aren't recorded."`
+		Update            bool               `short:"u" help:"Append the inputs to an existing uncompressed tar archive at the output, rather than replacing it, like tar -u, skipping those that are already in it and haven't been modified since, by comparing modification times to the second. Entries that are appended again are extracted over the copies before them. The archive is created if it doesn't exist yet."`
+		SelfExtracting    string             `placeholder:"GOOS/GOARCH" help:"Prepend an executable for the platform GOOS/GOARCH, like linux/amd64 or windows/amd64, to the archive, so that running the output extracts it, taking the arguments and flags of extract after the archive, without anything else installed. It's all of squish, not a smaller extractor: squish itself when it's built for that platform, or otherwise squish-GOOS-GOARCH beside it, with .exe appended for windows, which isn't installed with squish and must be built for that platform and put there by hand. squish reads the archive within such outputs as usual, and most zip tools can read self-extracting zips."`
+		Watch             bool               `help:"Keep running once the output is created, and create it again whenever the inputs change, so that it stays a live snapshot of them, e.g. during development, until squish is interrupted. With --update or --listed-incremental, the output is updated instead. Changes are watched for with inotify on Linux, and by checking the inputs every second elsewhere. Failed builds are logged, leaving the output as it was. An existing output is only replaced by the first build with --force."`
+		WatchDelay        time.Duration      `default:"500ms" placeholder:"DURATION" help:"With --watch, wait until the inputs have been left unchanged for DURATION before creating the output again, so that a burst of changes, like a checkout, causes a single build."`
+		Keep              int                `placeholder:"N" help:"Once the output is created, delete all but the N newest backups beside it in the same set, which are those its name with placeholders could expand to, or otherwise those named like it but for their digits, like the dates in backup-2006-01-02.tar.zst, ordered by modification time, along with the files beside them whose names start with theirs, like manifests. Backups kept by any of --keep, --keep-daily, --keep-weekly and --keep-monthly are kept, as is the output."`
+		KeepDaily         int                `placeholder:"N" help:"Once the output is created, keep the newest backup in the same set from each of the N most recent days with any, as with --keep."`
+		KeepWeekly        int                `placeholder:"N" help:"Once the output is created, keep the newest backup in the same set from each of the N most recent ISO weeks with any, as with --keep."`
+		KeepMonthly       int                `placeholder:"N" help:"Once the output is created, keep the newest backup in the same set from each of the N most recent months with any, as with --keep."`
+		Index             bool               `help:"Write an index of where each entry is beside a tar.gz or tar.zst archive, at the output path with .idx appended, in the same format as the index command writes, which list, find, cat and extract with patterns use to read single entries without decompressing everything before them. The archive is compressed as a sequence of independent gzip members or zstd frames of about 1 MiB, starting at entries, which every tool still reads as usual, at the cost of compressing slightly worse."`
+		Sidecar           string             `enum:",json" default:"" help:"Write a JSON file describing how the archive was created beside it, at the output path with .meta.json appended, so that it describes itself once it's in cold storage: the time, the host, the version of squish, the arguments and working directory, the format and level, the number of entries and their total size, and the SHA-256 digest of its contents' manifest, as written by --manifest=sha256."`
+		Manifest          string             `enum:",sha256,sha512" default:"" placeholder:"HASH" help:"Write the sha256 or sha512 digest of every regular file in the archive to a manifest beside it, at the output path with .sha256 or .sha512 appended, in the format of sha256sum, so that extracted files can be audited with sha256sum -c. Digests are of the contents as archived."`
+		Tempdir           string             `type:"existingdir" aliases:"tmpdir" placeholder:"DIR" help:"Write outputs on disk to a temporary file in DIR rather than beside the output, e.g. on faster or larger storage, along with the files rewritten by --minify-json and --strip-binaries. Once complete, the temporary file is moved into place, and if DIR is on a different file system, it's first copied beside the output, so that the output is still never seen partially written."`
+		MaxTmp            byteSize           `placeholder:"SIZE" help:"Keep the files rewritten by --minify-json and --strip-binaries, which are held in temporary files until they're archived, within SIZE in total, archiving those that don't fit unchanged with a warning."`
+		Pipe              bool               `help:"Frame the archive written to stdout with a checksum for every chunk and a digest of the whole archive, for extract --pipe to check, so that corruption between two squish processes, e.g. over ssh, is detected when extracting."`
+		Force             bool               `negatable:"no-clobber" help:"Replace the output if it already exists, rather than refusing to, which can be made explicit with --no-clobber. Outputs on disk are written to a temporary file that only replaces the output once it's complete."`
+		Prescan           bool               `negatable:"" default:"true" help:"Add up the sizes of the inputs before archiving, so that progress shows the percentage archived and the estimated time remaining. The sizes found while discovering the inputs are used, so they aren't statted again, and with --no-prescan the total is left unknown. Archives written to stdout, URLs and tape drives aren't prescanned, since their inputs are archived as they're discovered, unless options that need every input first, like --include, are given."`
+		Estimate          bool               `help:"Print an estimate of the size of the output and how long it will take to create, by compressing a sample of up to 64 MiB of the inputs, without writing anything. Inputs that are small enough are compressed entirely, giving the exact size."`
+		DryRun            bool               `short:"n" help:"Discover, filter and check the inputs and the output as usual, then print the entries that would be archived, prefixed with A, and log whether the output would be created, replaced or appended to, without writing anything."`
+		Summary           string             `enum:",text,json" default:"" help:"Once the archive is written, print the number of entries archived, their total size, the size of the archive, the ratio between them, the time taken and the throughput to stderr: text prints them as a line, and json as a JSON object."`
 	} `cmd:"" help:"Create an archive or compressed file."`
 	Extract struct {
-		Input  string  `arg:"" help:"The path of the archive or compressed to extract from."`
-		Output *string `arg:"" optional:"" help:"The directory to extract archive entries to, or the file to write the decompressed contents to."`
+		Input           string        `arg:"" help:"The path or HTTP(S), s3://, gs:// or az:// URL of the archive or compressed file to extract from, or - for stdin. Archives within other archives, and entries within them, can be given as paths through them, like outer.zip/inner.tar.gz or outer.zip/inner.tar.gz/docs/readme.md, which extracts just that entry, along with its contents. ${nested_help}"`
+		Output          *string       `arg:"" optional:"" help:"The directory to extract archive entries to, or the file to write the decompressed contents to, or - for stdout, which is created along with any parent directories that don't exist yet. Defaults to stdout when decompressing stdin, or otherwise to the input path without its extension, like foo for foo.tar.gz, foo.tgz or foo.cbz, or foo.tar when decompressing foo.tgz, or with .out appended if it has no extension, in which case the format is identified by the input's contents."`
+		Patterns        []glob        `arg:"" optional:"" help:"Only extract entries matching any of these patterns, along with their contents. ${glob_help}"`
+		Type            []string      `enum:"f,d,l" help:"Only extract entries of the given types: f (regular file), d (directory), or l (symbolic link)."`
+		IncludeType     []typePattern `placeholder:"TYPE" help:"Only extract the regular files whose contents are of a type matching any of these patterns, e.g. text/*, regardless of their names. ${type_help}"`
+		ExcludeType     []typePattern `placeholder:"TYPE" help:"Don't extract the regular files whose contents are of a type matching any of these patterns, e.g. video/*. ${type_help}"`
+		StripComponents int           `placeholder:"N" help:"Remove the first N elements from the path of each entry, skipping entries with no more than N elements, like tar --strip-components."`
+		Transform       []transform   `sep:"none" placeholder:"RULE" help:"Rename entries when extracting with a sed-style rule, e.g. s|^artifacts/|build/|. Rules are applied after patterns are matched and components are stripped. ${transform_help}"`
+		Format          string        `help:"Use the given format instead of identifying it from the input. ${format_help}"`
+		IgnoreZeros     bool          `help:"Keep reading tar archives past the blocks of zeros that mark their end, so that every archive in a concatenation of them is read, like tar --ignore-zeros. Trailing data that isn't a tar header is ignored with a warning."`
+		KeepGoing       bool          `help:"Log entries that fail to be extracted, like those that can't be written, fail their checksums or are unsafe, and carry on with the rest, rather than stopping at the first, then list the failures and exit with status 6. Extraction still stops if the archive itself can't be read any further, or a limit or --reserve-space is reached."`
+		Strict          bool          `help:"Fail without extracting anything if any of the selected entries use features that can't be extracted, like unsupported compression methods, encryption or entry types, rather than skipping them and reporting them together once extraction is done. Zip archives, and other archives that can be read twice, are checked before extracting, which for those that can't be seeked within means reading them twice."`
+		Prefetch        int           `placeholder:"N" help:"Read up to N MiB ahead of decompression in the background, to hide the latency of slow media. Ignored for formats that require random access, like zip."`
+		Threads         int           `default:"${num_cpu}" placeholder:"N" help:"Extract up to N entries concurrently, defaulting to the number of CPUs. Only zip archives are extracted concurrently."`
+		Mode            *modeChange   `placeholder:"MODE" help:"Change the permissions of every extracted entry, e.g. u=rwX,go=rX to normalize a tree, since X only makes directories and files that are already executable executable. ${mode_help}"`
+		DirMode         *modeChange   `placeholder:"MODE" help:"The mode of the output directory and of parent directories that have to be created for entries whose parents aren't in the archive, relative to 755. ${mode_help}"`
+		AbsoluteNames   bool          `short:"P" help:"Extract entries whose names are absolute paths to those paths, rather than refusing to, like tar -P, and allow symbolic links to point anywhere. Other entries are still extracted to the output. Only use this with archives you trust."`
+		RestrictTo      string        `placeholder:"DIR" help:"Create archive entries by resolving each path component relative to DIR, which must contain the output, without following symbolic links, so that no entry can be written outside of it (Linux only)."`
+		Sandbox         bool          `help:"Prevent the extracting process from modifying anything outside of the output and from using syscalls it doesn't need, using Landlock and seccomp (Linux only)."`
+		SanitizeNames   bool          `negatable:"" default:"${is_windows}" help:"Rename entries whose names aren't valid on Windows so that they can be extracted, replacing the characters <>:\"|?* and control characters, and trailing dots and spaces, with underscores, and appending an underscore to device names like CON, before any extension, as in aux_.txt. Defaults to true on Windows, where outputs are also given extended-length paths, so that deeply nested entries can be extracted."`
+		RenameMap       string        `placeholder:"PATH" help:"Write the entries renamed by --sanitize-names to PATH, one per line, with the name in the archive and the path it was extracted to separated by a tab."`
+		StripMacosx     bool          `name:"strip-macosx" help:"Skip the __MACOSX directory and ._ AppleDouble files that macOS adds to archives to store metadata."`
+		NoTimes         bool          `help:"Don't restore the modification times of extracted entries, or their access times where the archive stores them, giving them the current time instead."`
+		SamePermissions bool          `negatable:"" default:"${is_root}" help:"Give extracted entries exactly the permissions stored in the archive, after any --mode, like tar --same-permissions. Otherwise, the permissions in the umask are removed. Defaults to true when running as root."`
+		StripSetuid     bool          `help:"Remove the setuid and setgid bits of extracted files, so that untrusted archives can't create programs that run with the privileges of their owners. Directories keep their setgid bits, which only make their contents inherit their groups."`
+		SameOwner       bool          `negatable:"" default:"${is_root}" help:"Give extracted entries the numeric owner and group stored in the archive, like tar --same-owner --numeric-owner, warning if they can't be changed. Defaults to true when running as root. Only tar archives store ownership."`
+		OwnerMap        []idMapping   `placeholder:"FROM:TO" help:"Give entries owned by user ID FROM in the archive the owner TO instead, with --same-owner."`
+		GroupMap        []idMapping   `placeholder:"FROM:TO" help:"Give entries owned by group ID FROM in the archive the group TO instead, with --same-owner."`
+		Xattrs          bool          `help:"Restore the extended attributes stored in tar archives, except POSIX ACLs, which are restored by --acls, warning about any that can't be set (Linux only)."`
+		ACLs            bool          `name:"acls" help:"Restore the POSIX ACLs stored in tar archives (Linux only)."`
+		Capabilities    bool          `help:"Restore the file capabilities stored in tar archives, as by --xattrs but without the rest of the extended attributes, warning about any that can't be set. Setting them requires root or CAP_SETFCAP (Linux only)."`
+		SpecialFiles    bool          `help:"Create the named pipes and character and block devices stored in tar archives, rather than skipping them with a warning. Devices can only be created by root (Linux only)."`
+		Password        password      `placeholder:"PASSWORD" env:"SQUISH_PASSWORD" help:"Decrypt the encrypted entries of zip, 7z and rar archives with PASSWORD, along with the names in 7z and rar archives whose headers are encrypted. Zips encrypted with AES or with the traditional PKWARE encryption can be decrypted. Given as --password without a value, the password is read from stdin."`
+		Dictionary      string        `type:"existingfile" placeholder:"FILE" help:"Decompress zstd input with the dictionary in FILE, which must be the one it was compressed with. The input's format is identified by its name, unless --format is given."`
+		Verify          string        `type:"existingfile" placeholder:"KEY" help:"Refuse to extract the input unless the detached signature beside it, at its path with .sig appended, was made by the Ed25519 public key in this file, given in PEM format or as an OpenSSH public key. The input must be on disk, and is read entirely to check it before anything is extracted."`
+		Resume          bool          `help:"Record which entries have been extracted, and how much of large files has been written, in .squish-resume in the output, so that an interrupted extraction can be continued by running it again with --resume. Entries that were already extracted are skipped, and files that were partially written are continued from where they got to, regardless of --overwrite. The URL and ETag of remote inputs are recorded too, and an uncompressed tar from a server that supports range requests is requested from the first entry that wasn't finished, rather than downloaded again, unless it has changed."`
+		Checksums       string        `type:"existingfile" placeholder:"PATH" help:"Check the contents of every extracted file that's listed in this file, in the format of sha256sum or sha512sum, failing if any don't match. Defaults to the manifest written by create --manifest beside the input, at its path with .sha256 or .sha512 appended, if there is one."`
+		Pipe            bool          `help:"Read an archive framed by create --pipe from stdin, failing as soon as a chunk's checksum doesn't match, and once it's extracted, if the digest of the whole archive doesn't match or the stream was truncated."`
+		Identity        []string      `type:"existingfile" placeholder:"PATH" help:"Decrypt age-encrypted inputs with the X25519 identities in this file, as written by age-keygen. Inputs are recognized as age-encrypted by their .age extension or header, and decrypted in-process, so --sandbox can be used."`
+		Flatten         *bool         `negatable:"" help:"When every entry is within a single directory, move its contents up into the output once they're extracted, so that foo.tar.gz containing foo is extracted to foo rather than foo/foo. Archives with several entries at their root are still extracted within the output, which is named after the archive unless it's given. Enabled by default when the output isn't given, and only done when the output was empty. Paths in --porcelain events are those before the contents are moved."`
+		RestoreExec     string        `enum:"never,auto" default:"never" help:"Whether to make extracted files executable when the archive doesn't store their modes, as with zips created on Windows: never, or auto to make files that start with a shebang or are ELF or Mach-O binaries executable by whoever can read them."`
+		ReserveSpace    byteSize      `placeholder:"SIZE" help:"Stop extracting before less than SIZE would be left free on the output's filesystem, e.g. 1G, so that huge archives can't fill it (Linux only)."`
+		WhenFull        string        `enum:"stop,rollback,skip" default:"stop" help:"What to do when an entry would leave less than --reserve-space free: stop extracting, keeping what was already extracted, stop and remove the entries that were extracted, or skip the entry with a warning and keep going."`
+		MaxOutputSize   byteSize      `placeholder:"SIZE" help:"Stop extracting once more than SIZE has been written in total, e.g. 10G, to guard against decompression bombs."`
+		MaxEntries      int64         `placeholder:"N" help:"Stop extracting once more than N entries have been extracted, to guard against archives with huge numbers of entries."`
+		MaxRatio        float64       `placeholder:"RATIO" help:"Stop extracting once more than RATIO times the size of the input has been written, e.g. 100, to guard against decompression bombs. When the input's size isn't known, as with stdin, the output is compared to what's been read of it so far."`
+		IfChanged       bool          `help:"Compare regular files that already exist in the output with the entries that would replace them, leaving those with the same contents as they are, along with their modification times, and rewriting those that differ from the first byte that does, if --overwrite allows them to be replaced, so that repeatedly extracting an archive over the same output only writes what changed."`
+		Reflink         bool          `help:"Make regular files with the same contents as ones extracted before them reflinks of those, sharing their blocks rather than being written out again, on filesystems that support it, like Btrfs and XFS, which saves space and time for archives with many duplicate files. Elsewhere, and on other platforms than Linux, the contents are copied from the earlier files."`
+		ReflinkFrom     string        `type:"existingdir" placeholder:"DIR" help:"Also make regular files with the same contents as files beneath DIR, which must be on the same filesystem as the output, reflinks of those. Implies --reflink."`
+		DryRun          bool          `short:"n" help:"Select, rename and check the entries as usual, then print the path in the output of each entry that would be extracted, prefixed with A if nothing is there yet, R if it would replace what is, M if it's a directory that would be merged with an existing one, or ? if --overwrite=prompt would ask, and those of the entries that would be skipped since something is already there, prefixed with S, without writing anything."`
+		Summary         string        `enum:",text,json" default:"" help:"Once extraction is finished, print the number of entries extracted, the size of the input, the total size of the entries, the ratio between them, the time taken and the throughput to stderr: text prints them as a line, and json as a JSON object."`
+		Overwrite       string        `enum:"never,always,newer,prompt" default:"never" help:"What to do with files that already exist in the output: never replace them, skipping the entries with a warning, always replace them, replace them if the entry was modified more recently, or prompt for each one. Existing directories are always extracted into, and nothing else in the output is changed."`
 	} `cmd:"" help:"Extract files from an archive or compressed file."`
+	Join struct {
+		Input  string  `arg:"" help:"The path of the first volume (ending in .001) of a split archive or compressed file."`
+		Output *string `arg:"" optional:"" help:"The file to write the joined volumes to. Defaults to the input path without the .001 suffix."`
+	} `cmd:"" help:"Concatenate the volumes of a split archive or compressed file."`
+	List struct {
+		Input       string   `arg:"" help:"The path of the archive to list, or of an index created from it, or of a directory within an archive, like outer.zip/inner.tar.gz/docs, to list just its entries. ${nested_help}"`
+		Type        []string `enum:"f,d,l" help:"Only list entries of the given types: f (regular file), d (directory), or l (symbolic link)."`
+		Meta        []string `sep:"none" placeholder:"KEY[=VALUE]" help:"Only list entries with this metadata, attached by create --meta, or with any value of KEY if no value is given."`
+		IgnoreZeros bool     `help:"Keep reading tar archives past the blocks of zeros that mark their end, so that every archive in a concatenation of them is read, like tar --ignore-zeros. Trailing data that isn't a tar header is ignored with a warning."`
+		Comments    bool     `help:"Print the comments of zip entries that have them after their names, separated by a tab."`
+	} `cmd:"" help:"List the entries in an archive."`
+	Index struct {
+		Input  string `arg:"" help:"The path of the archive to index."`
+		Output string `short:"o" help:"The path of the index file to write. Defaults to the input path with .idx appended."`
+	} `cmd:"" help:"Write the metadata of every entry in an archive to an index file, along with where each entry is in uncompressed tar archives. Written beside the archive, as it is by default, list and find read it in place of the archive, and cat and extract with patterns read single entries of uncompressed tar archives by seeking to them. It can also be listed in place of the archive by giving its path to list."`
+	Manifest struct {
+		Input     string   `arg:"" help:"The path or URL of the archive or compressed file to hash."`
+		Output    string   `short:"o" help:"The path of the manifest file to write. Defaults to the input path with .merkle appended."`
+		ChunkSize byteSize `default:"1M" placeholder:"SIZE" help:"The size of the chunks that are hashed, which is the smallest amount that can be verified."`
+	} `cmd:"" help:"Write a manifest of a Merkle tree of SHA-256 hashes over fixed-size chunks of an archive, along with where the contents of each entry are stored in uncompressed tar and zip archives, so that parts of it can be verified without reading the rest."`
+	Verify struct {
+		Manifest string      `arg:"" type:"existingfile" help:"The path of the manifest file written by manifest."`
+		Input    string      `arg:"" help:"The path or URL of the archive to verify. Only the chunks being verified are read, so URLs are only partially downloaded."`
+		Entry    []string    `placeholder:"NAME" help:"Only verify the chunks storing the contents of this entry."`
+		Range    []byteRange `placeholder:"START-END" help:"Only verify the chunks containing the bytes from START to END inclusive, as in an HTTP Range header."`
+		Root     string      `placeholder:"HASH" help:"The hex-encoded root hash that the manifest must have, obtained from a trusted source. Without it, the manifest is only checked for consistency with itself."`
+	} `cmd:"" help:"Verify all or part of an archive against a manifest written by manifest."`
+	Info struct {
+		Input string `arg:"" help:"The path of the archive or compressed file to summarize."`
+		JSON  bool   `name:"json" help:"Print the summary as JSON."`
+	} `cmd:"" help:"Show a summary of an archive or compressed file."`
+	Stat struct {
+		Input      string `arg:"" help:"The path of the archive containing the entry."`
+		Entry      string `arg:"" optional:"" help:"The path of the entry within the archive."`
+		EntryIndex *int   `placeholder:"N" help:"Select the entry at index N, counting from 0 in the order entries are stored, instead of by path."`
+		Raw        bool   `help:"Also print format-specific header fields, such as tar PAX records, including those of global headers."`
+	} `cmd:"" help:"Show the metadata of a single archive entry."`
+	Comment struct {
+		Input string  `arg:"" type:"existingfile" help:"The path of the zip."`
+		Text  *string `arg:"" optional:"" help:"The comment to give the zip, replacing any it has, or an empty one to remove it. Without it, the zip's comment is printed."`
+	} `cmd:"" help:"Print the comment of a zip, or change it in place, rewriting only the end of the zip, which the comment follows."`
+	Cat struct {
+		Input      string `arg:"" help:"The path or URL of the archive containing the entry, or the path of the entry itself through the archive, like outer.zip/inner.tar.gz/docs/readme.md. ${nested_help}"`
+		Entry      string `arg:"" optional:"" help:"The path of the entry within the archive."`
+		EntryIndex *int   `placeholder:"N" help:"Select the entry at index N, counting from 0 in the order entries are stored, instead of by path."`
+	} `cmd:"" help:"Write the contents of a single archive entry to stdout."`
+	DiffEntry struct {
+		Input string `arg:"" help:"The path or URL of the archive containing the entry."`
+		Entry string `arg:"" help:"The path of the entry within the archive."`
+		File  string `arg:"" type:"existingfile" help:"The file on disk to compare the entry with."`
+	} `cmd:"" help:"Compare the contents of an archive entry with a file on disk, printing a unified diff if they're text, or the sizes and first differing byte if either is binary, which is judged by whether it has a NUL byte near its start. Exits with status 1 if they differ."`
+	Mount struct {
+		Input      string `arg:"" help:"The path of the archive to mount."`
+		Mountpoint string `arg:"" type:"existingdir" help:"The directory to mount the archive at."`
+	} `cmd:"" help:"Mount an archive as a read-only file system until interrupted (Linux only)."`
+	Serve struct {
+		Input  string `arg:"" help:"The path of the archive to serve."`
+		Listen string `default:"localhost:8080" help:"The address to listen on, which is only reachable from this machine by default. Use e.g. :8080 to serve the archive on every interface."`
+	} `cmd:"" help:"Serve the contents of an archive over HTTP."`
+	Browse struct {
+		Input  string `arg:"" help:"The path or URL of the archive to browse."`
+		Output string `short:"o" default:"." type:"path" help:"The directory to extract entries to, under their paths in the archive."`
+		Pager  string `env:"PAGER" default:"less" help:"The command to view regular files with, which is run by the shell with the contents on stdin."`
+	} `cmd:"" help:"Browse an archive in the terminal, previewing the entry under the cursor, with keys to move (up, down, page up, page down, home, end), open directories or view files in the pager (enter), go back (left), select entries (space), extract the selected entries, or the one under the cursor (x), search the names of every entry (/), and quit (q) (Linux only)."`
+	Retouch struct {
+		Input    string      `arg:"" help:"The path of the archive to rewrite."`
+		Patterns []string    `arg:"" optional:"" help:"Only change entries matching these glob patterns. Patterns containing a slash are matched against the whole entry path, and others against its last element. Defaults to every entry."`
+		Output   string      `short:"o" help:"The path of the archive to write. Defaults to replacing the input."`
+		SetMtime *timestamp  `placeholder:"TIME" help:"Set the modification time of matching entries, given in RFC 3339 format, as a date (2006-01-02), or as seconds since the Unix epoch prefixed with @."`
+		Chmod    *modeChange `placeholder:"MODE" help:"Change the permissions of matching entries. ${mode_help}"`
+		Chown    *owner      `placeholder:"UID:GID" help:"Change the numeric owner and group of matching entries. Only tar archives store ownership."`
+	} `cmd:"" help:"Rewrite an archive, changing the metadata of its entries without changing their contents."`
+	Rekey struct {
+		Input        string             `arg:"" type:"existingfile" help:"The path of the encrypted archive to re-encrypt."`
+		Output       string             `short:"o" placeholder:"PATH" help:"The path of the archive to write. Defaults to replacing the input."`
+		Identity     []string           `type:"existingfile" placeholder:"PATH" help:"Decrypt age-encrypted inputs with the X25519 identities in this file, as written by age-keygen. gpg-encrypted inputs are decrypted with the keys gpg has."`
+		OldPassword  password           `placeholder:"PASSWORD" help:"Decrypt the encrypted entries of a zip with PASSWORD. Given without a value, the password is read from stdin."`
+		NewPassword  password           `placeholder:"PASSWORD" help:"Encrypt the entries of a zip that were encrypted with AES-256 using PASSWORD. Given without a value, the password is read from stdin, and asked for twice when stdin is a terminal."`
+		NewRecipient []encryptRecipient `placeholder:"SCHEME:RECIPIENT" help:"Encrypt an age- or gpg-encrypted input to this recipient instead, given as age:RECIPIENT or gpg:KEY, as with create --encrypt. All recipients must use the same scheme."`
+	} `cmd:"" help:"Re-encrypt an encrypted archive under new keys, without writing its decrypted contents to disk. Archives encrypted as a whole with age or gpg are encrypted to --new-recipient, and the encrypted entries of zips are encrypted with --new-password, leaving other entries as they are."`
+	Repack struct {
+		Input   string `arg:"" help:"The path or URL of the compressed archive or file to recompress, like in.tar.gz."`
+		To      string `required:"" placeholder:"FORMAT" help:"The compression to recompress it with, like zst or xz, or a compressed archive format like tar.zst. ${format_help}"`
+		Output  string `short:"o" placeholder:"PATH" help:"The path to write the recompressed archive or file to, or - for stdout. Defaults to the input path with the extension of its compression replaced, like in.tar.zst for in.tar.gz or in.tgz."`
+		Level   *int   `placeholder:"N" help:"Compress at level N rather than the default level of the compression. ${level_help}"`
+		Threads int    `default:"${num_cpu}" placeholder:"N" help:"Compress using up to N threads, defaulting to the number of CPUs. ${threads_help}"`
+		Force   bool   `help:"Replace the output if it already exists, rather than refusing to."`
+	} `cmd:"" help:"Recompress a compressed archive or file with another compression in a single streaming pass, like a .tar.gz as a .tar.zst, keeping the archive within byte for byte, without extracting it or writing anything to disk but the output."`
+	Sample struct {
+		Input string  `arg:"" help:"The path or URL of the archive to sample."`
+		Count int     `short:"n" default:"10" placeholder:"N" help:"The number of entries to sample."`
+		Lines int     `default:"3" placeholder:"N" help:"The number of lines of each sampled text file to print."`
+		Seed  *uint64 `placeholder:"N" help:"Seed the random choice of entries with N, so that the same archive gives the same sample. Defaults to a random seed."`
+	} `cmd:"" help:"Print the names and first few lines of a random sample of the regular files and symbolic links in an archive, read in a single pass. Larger files are more likely to be sampled."`
+	Log struct {
+		Count  int  `short:"n" default:"20" placeholder:"N" help:"Only print the N most recent operations, or every operation if N is 0."`
+		Failed bool `help:"Only print operations that failed."`
+		JSON   bool `name:"json" help:"Print each operation as a JSON object on its own line."`
+	} `cmd:"" help:"Print the operations recorded in the history, oldest first, with their arguments, sizes, duration and result."`
+	Again struct {
+		DryRun bool `help:"Print the operation that would be repeated, and the directory it would be run in, without running it."`
+	} `cmd:"" help:"Repeat the most recent create or extract operation in the history, with the same arguments and working directory."`
+	Du struct {
+		Input string `arg:"" help:"The path of the archive to summarize."`
+	} `cmd:"" help:"Show the total size of each top-level entry in an archive."`
+	Grep struct {
+		Pattern    string   `arg:"" help:"The regular expression to search for, in RE2 syntax."`
+		Inputs     []string `arg:"" help:"The paths or URLs of the archives to search."`
+		IgnoreCase bool     `short:"i" help:"Match the pattern case-insensitively."`
+		Threads    int      `default:"${num_cpu}" placeholder:"N" help:"Search up to N archives concurrently, defaulting to the number of CPUs."`
+	} `cmd:"" help:"Print the lines of regular files in any of several archives that match a pattern, prefixed with the archive and entry names and the line number. Exits with status 1 if nothing matched."`
+	Bundle struct {
+		Archive  string   `arg:"" help:"The path of the plain tar archive to create, or with --unbundle, the archive to extract from."`
+		Files    []string `arg:"" optional:"" help:"The files to bundle, which are stored under their base names as they are, or with --unbundle, the directory to extract to, defaulting to the current directory."`
+		Unbundle bool     `help:"Extract the regular files in the archive into the directory under their base names, rather than creating it, skipping files that already exist with a warning."`
+		Force    bool     `help:"Replace the archive if it already exists, rather than refusing to."`
+	} `cmd:"" help:"Bundle files that are already compressed, like rotated logs, into a plain tar archive as they are, without recompressing them, keeping their modes, owners and modification times, or extract them again with --unbundle."`
+	Sync struct {
+		Directory string `arg:"" type:"existingdir" help:"The directory to mirror. As with create, entries are nested under its base name, unless it's given with a trailing slash."`
+		Archive   string `arg:"" help:"The path of the archive to update, which is created if it doesn't exist yet."`
+		Format    string `help:"Use the given format instead of identifying it from the archive path. ${format_help}"`
+		Exclude   []glob `placeholder:"GLOB" help:"Don't mirror entries matching any of these patterns, or their contents, deleting them from the archive if they're in it. ${glob_help}"`
+		DryRun    bool   `short:"n" help:"Print the entries that would be added (A), modified (M) and deleted (D), without changing the archive."`
+	} `cmd:"" help:"Make an archive exactly reflect a directory, adding, updating and deleting entries as needed, and printing each change with --verbose. Regular files are judged to be unchanged by their modes, sizes and modification times, and directories by their modes. Plain tar archives are appended to when files were only added, and the unchanged entries of zips are copied without being recompressed, while other archives are rewritten. Archives that are already up to date aren't written at all."`
+	Bench struct {
+		Directory string   `arg:"" optional:"" type:"existingdir" help:"The directory whose files to archive, unless --suite is given."`
+		Formats   []string `default:"tar.gz,tar.zst,tar.xz,zip" placeholder:"FORMAT,..." help:"The archive formats to compare. ${format_help}"`
+		Levels    []int    `placeholder:"N,..." help:"The levels to compare each format at, rather than only its default level. Levels that a format doesn't have are skipped with a warning. ${level_help}"`
+		Threads   int      `default:"${num_cpu}" placeholder:"N" help:"Compress using up to N threads, defaulting to the number of CPUs. ${threads_help}"`
+		Suite     bool     `help:"Archive the built-in synthetic corpora instead of a directory: about 8 MiB each of text, machine code, already compressed data, and many small text files. The corpora are the same in every version, so the results, which are printed as a JSON object along with the version and platform, can be compared between versions to find performance regressions."`
+	} `cmd:"" help:"Compare the sizes of the archives that formats and levels would create from a directory, and how long they would take to create, by archiving a sample of up to 64 MiB of its files with each and printing a table of the results, extrapolated from the sample as with create --estimate."`
+	Find struct {
+		Inputs  []string `arg:"" help:"The paths or URLs of the archives to search."`
+		Name    string   `placeholder:"GLOB" help:"Only print entries whose last element matches this glob pattern."`
+		Type    []string `enum:"f,d,l" help:"Only print entries of the given types: f (regular file), d (directory), or l (symbolic link)."`
+		Threads int      `default:"${num_cpu}" placeholder:"N" help:"Search up to N archives concurrently, defaulting to the number of CPUs."`
+	} `cmd:"" help:"Print the entries in any of several archives, prefixed with the archive name, reading them from the index beside each archive if there is one. Exits with status 1 if nothing matched."`
+	TrainDict struct {
+		Samples []string `arg:"" type:"existingpath" help:"The files to learn from, or directories whose regular files are all learned from. Only the first 128 KiB of each is read."`
+		Output  string   `short:"o" required:"" placeholder:"FILE" help:"Write the dictionary to FILE."`
+		Size    byteSize `default:"110K" placeholder:"SIZE" help:"The size of the dictionary, which is 110 KiB by default, as with zstd --train."`
+	} `cmd:"" help:"Train a zstd dictionary from samples of the data it's for, like thousands of small similar files such as logs or JSON records, to compress them with create --dictionary. The dictionary is made of the strings found most often in the samples."`
+	Man struct {
+		Output string `short:"o" placeholder:"DIR" help:"Write squish.1, along with a page for each command like squish-create.1, to DIR, rather than writing squish.1 to stdout."`
+	} `cmd:"" help:"Write the manual, generated from the commands and flags, as man pages. squish.1 describes every command and flag, along with the formats, the config file and the exit statuses. The same manual is printed as text by --help-long."`
+}
+
+var exitCode = 0
+
+// cliOptions returns the options that cli is parsed with, other than the
+// variables num_cpu, progress and is_root, whose values depend on the machine.
+func cliOptions() []kong.Option {
+	return []kong.Option{
+		kong.Description(configHelp + "\n\n" + exitCodeHelp),
+		kong.Vars{"format_help": formatHelp, "threads_help": threadsHelp, "memory_help": memoryHelp, "mode_help": modeHelp, "glob_help": globHelp, "type_help": typeHelp, "level_help": levelHelp, "transform_help": transformHelp, "nested_help": nestedHelp, "time_bound_help": timeBoundHelp, "template_help": templateHelp, "is_windows": strconv.FormatBool(runtime.GOOS == "windows")},
+	}
+}
+
+// bail must only be called from the main goroutine so that deferred cleanup
+// runs before exiting.
+func bail(format string, a ...any) {
+	message := fmt.Sprintf(format, a...)
+	details := errorDetails(a)
+	logMessage(slog.LevelError, details, format, a...)
+	details["message"] = message
+	events.emit("error", details)
+	history.fail(message)
+	exitCode = exitCodeOf(details)
+	runtime.Goexit()
+}
+
+// exitUsage exits with the status for invalid arguments when kong fails to
+// parse them, or with code otherwise, as after printing help.
+func exitUsage(code int) {
+	if code != 0 {
+		code = exitCodes["usage"]
+	}
+	os.Exit(code)
 }
 
 func main() {
-	ctx := context.Background()
+	ctx := interruptible(context.Background())
+	started := time.Now()
+
+	defer func() {
+		failOnWarnings()
+		history.save()
+		os.Exit(exitCode)
+	}()
+
+	// Self-extracting archives extract themselves, taking the arguments of
+	// extract after the archive.
+	if self, ok := selfExtracting(); ok {
+		os.Args = slices.Concat([]string{os.Args[0], "extract", self}, os.Args[1:])
+	}
 
-	exitCode := 0
-	defer func() { os.Exit(exitCode) }()
+	// The config file can only be reported on once logging is set up.
+	config, configErr := loadConfig()
+	kctx := kong.Parse(&cli, append(cliOptions(), kong.Resolvers(config.resolver()), kong.Exit(exitUsage), kong.Vars{"num_cpu": strconv.Itoa(runtime.NumCPU()), "progress": strconv.FormatBool(isTerminal(os.Stderr)), "is_root": strconv.FormatBool(os.Geteuid() == 0)})...)
+	command := kctx.Selected().Name
 
-	bail := func(format string, a ...any) {
-		_, err := fmt.Fprintf(os.Stderr, format+"\n", a...)
-		if err != nil {
-			panic(err)
+	setupLogging(cli.LogLevel, cli.LogFormat)
+	if command != "log" && command != "again" && !cli.NoHistory {
+		var err error
+		if history, err = openHistory(command, started); err != nil {
+			logger.Error("failed to open history", "error", err)
 		}
-		exitCode = 1
-		runtime.Goexit()
+	}
+	if err := setupLocale(cli.Lang); err != nil {
+		bail("%s", err)
+	}
+	if configErr != nil {
+		bail("failed to read config: %s", configErr)
+	}
+	if err := config.registerFormats(); err != nil {
+		bail("failed to read config: %s", err)
 	}
 
-	switch kong.Parse(&cli).Selected().Name {
-	case "create":
-		filenames := map[string]string{}
-		for _, file := range cli.Create.Inputs {
-			filenames[file] = filepath.Base(file)
-		}
-		files, err := archives.FilesFromDisk(ctx, nil, filenames)
-		if err != nil {
-			bail("failed to discover files: %s", err)
-		}
+	if cli.Nice {
+		applyNice(kctx)
+	}
 
-		format, _, err := archives.Identify(ctx, cli.Create.Output, nil)
-		if err != nil {
-			bail("failed to identify format: %s", err)
+	if cli.Stats {
+		stats = &opStats{started: started}
+		defer stats.print(command)
+	}
+	defer startProfiling()()
+
+	if cli.Porcelain != "" {
+		var err error
+		if events, err = openEvents(cli.PorcelainFD); err != nil {
+			bail("failed to open --porcelain-fd: %s", err)
 		}
+	}
 
-		switch format := format.(type) {
-		case archives.Archiver:
-			output, err := os.Create(cli.Create.Output)
-			if err != nil {
-				bail("failed to create archive file: %s", err)
-			}
-			defer func() {
-				if err := output.Close(); err != nil {
-					bail("failed to close archive file: %s", err)
-				}
-			}()
-
-			if err := format.Archive(ctx, output, files); err != nil {
-				bail("failed to create archive: %s", err)
-			}
-
-		case archives.Compressor:
-			if len(files) < 1 {
-				bail("identified format only supports compression, but no input file was provided")
-			}
-			if len(files) > 1 {
-				bail("identified format only supports compression, but multiple input files were provided")
-			}
-
-			output, err := os.Create(cli.Create.Output)
-			if err != nil {
-				bail("failed to create compressed file: %s", err)
-			}
-			defer func() {
-				if err := output.Close(); err != nil {
-					bail("failed to close compressed file: %s", err)
-				}
-			}()
-
-			outputWC, err := format.OpenWriter(output)
-			if err != nil {
-				bail("failed to create compressed file writer: %s", err)
-			}
-			defer func() {
-				if err := outputWC.Close(); err != nil {
-					bail("failed to close compressed file writer: %s", err)
-				}
-			}()
-
-			input, err := files[0].Open()
-			if err != nil {
-				bail("failed to open input file: %s", err)
-			}
-			defer func() {
-				if err := input.Close(); err != nil {
-					bail("failed to open input file: %s", err)
-				}
-			}()
-
-			if _, err := io.Copy(outputWC, input); err != nil {
-				bail("failed to copy input file to compressed file writer: %s", err)
-			}
-
-		default:
-			bail("identified format doesn't support archiving or compression")
+	switch command {
+	case "create":
+		if cli.Create.Watch {
+			watchCreate(ctx)
+		} else {
+			create(ctx, cli.Create.Force)
 		}
 
 	case "extract":
-		input, err := os.Open(cli.Extract.Input)
-		if err != nil {
-			bail("failed to open input file: %s", err)
-		}
-		defer func() {
-			if err := input.Close(); err != nil {
-				bail("failed to close input file: %s", err)
-			}
-		}()
-
-		format, inputR, err := archives.Identify(ctx, cli.Create.Output, input)
-		if err != nil {
-			bail("failed to identify format: %s", err)
-		}
+		extract(ctx)
 
-		var output string
-		if cli.Extract.Output != nil {
-			output = *cli.Extract.Output
-		} else if strings.HasSuffix(cli.Extract.Input, format.Extension()) {
-			output = strings.TrimSuffix(cli.Extract.Input, format.Extension())
-		} else if ext := filepath.Ext(cli.Extract.Input); ext != "" {
-			output = strings.TrimSuffix(cli.Extract.Input, ext)
-		} else {
-			bail("failed to determine output path from input path and format, please specify it manually")
-		}
+	case "join":
+		join(ctx)
 
-		switch format := format.(type) {
-		case archives.Extractor:
-			if err := os.RemoveAll(output); err != nil {
-				bail("failed to remove existing output: %s", err)
-			}
-
-			if err := os.Mkdir(output, 0o755); err != nil {
-				bail("failed to create output directory: %s", err)
-			}
-
-			err := format.Extract(ctx, inputR, func(ctx context.Context, info archives.FileInfo) (err error) {
-				cleanedName := filepath.Clean(info.NameInArchive)
-				if !filepath.IsLocal(cleanedName) {
-					return fmt.Errorf("input entry %s was non-local, potential directory traversal attack", info.NameInArchive)
-				}
-
-				joinedName := filepath.Join(output, cleanedName)
-
-				if info.IsDir() {
-					if err := os.Mkdir(joinedName, info.Mode()); err != nil {
-						return fmt.Errorf("failed to create output directory: %s", err)
-					}
-
-					return nil
-				}
-
-				input, err := info.Open()
-				if err != nil {
-					return fmt.Errorf("failed to open input entry reader: %w", err)
-				}
-				defer func() {
-					if closeErr := input.Close(); closeErr != nil {
-						if err == nil {
-							err = closeErr
-						} else {
-							fmt.Fprintf(os.Stderr, "failed to close input entry reader: %s\n", closeErr)
-						}
-					}
-				}()
-
-				output, err := os.OpenFile(joinedName, os.O_CREATE|os.O_WRONLY, info.Mode())
-				if err != nil {
-					return fmt.Errorf("failed to create output file: %s", err)
-				}
-				defer func() {
-					if closeErr := output.Close(); closeErr != nil {
-						if err == nil {
-							err = closeErr
-						} else {
-							fmt.Fprintf(os.Stderr, "failed to close output file: %s\n", closeErr)
-						}
-					}
-				}()
-
-				if _, err := io.Copy(output, input); err != nil {
-					return fmt.Errorf("failed to copy input entry to output file: %s", err)
-				}
-
-				return nil
-			})
-			if err != nil {
-				bail("failed to extract archive: %s", err)
-			}
-
-		case archives.Decompressor:
-			inputRC, err := format.OpenReader(inputR)
-			if err != nil {
-				bail("failed to create decompressor reader: %s", err)
-			}
-			defer func() {
-				if err := inputRC.Close(); err != nil {
-					bail("failed to close decompressor reader: %s", err)
-				}
-			}()
-
-			output, err := os.Create(output)
-			if err != nil {
-				bail("failed to create output file: %s", err)
-			}
-			defer func() {
-				if err := output.Close(); err != nil {
-					bail("failed to close output file: %s", err)
-				}
-			}()
-
-			if _, err := io.Copy(output, inputRC); err != nil {
-				bail("failed to copy input to output file: %s", err)
-			}
-
-		default:
-			bail("identified format doesn't support extraction or decompression")
-		}
+	case "list":
+		list(ctx)
+
+	case "index":
+		index(ctx)
+
+	case "manifest":
+		manifest(ctx)
+
+	case "verify":
+		verify(ctx)
+
+	case "info":
+		info(ctx)
+
+	case "stat":
+		stat(ctx)
+
+	case "comment":
+		comment(ctx)
+
+	case "cat":
+		cat(ctx)
+
+	case "diff-entry":
+		diffEntry(ctx)
+
+	case "mount":
+		mount(ctx)
+
+	case "serve":
+		serve(ctx)
+
+	case "browse":
+		browse(ctx)
+
+	case "retouch":
+		retouch(ctx)
+
+	case "sample":
+		sample(ctx)
+
+	case "log":
+		showHistory(ctx)
+
+	case "again":
+		again(ctx)
+
+	case "du":
+		du(ctx)
+
+	case "grep":
+		grep(ctx)
+
+	case "find":
+		find(ctx)
+
+	case "bundle":
+		bundle(ctx)
+
+	case "sync":
+		syncArchive(ctx)
+
+	case "rekey":
+		rekey(ctx)
+
+	case "repack":
+		repack(ctx)
+
+	case "man":
+		man()
+
+	case "bench":
+		bench(ctx)
+
+	case "train-dict":
+		trainDict()
 
 	default:
 		panic("unknown subcommand")
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+// mainTestEnv is set when the test binary is run in place of squish, as again
+// runs it, so that it runs main instead of the tests.
+const mainTestEnv = "SQUISH_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainTestEnv) != "" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// parseCLI parses args into cli, with the defaults of its flags, restoring it
+// when the test finishes.
+func parseCLI(t *testing.T, args ...string) *kong.Context {
+	t.Helper()
+	saved := cli
+	t.Cleanup(func() { cli = saved })
+	reflect.ValueOf(&cli).Elem().SetZero()
+
+	parser, err := kong.New(&cli, append(cliOptions(), kong.Vars{"num_cpu": "2", "progress": "false", "is_root": "false"})...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kctx, err := parser.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kctx
+}
+
+// runCommand runs f, which may bail, and returns the status squish would exit
+// with.
+func runCommand(t *testing.T, f func()) int {
+	t.Helper()
+	saved := exitCode
+	t.Cleanup(func() { exitCode = saved })
+	exitCode = 0
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	<-done
+	return exitCode
+}
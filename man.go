@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alecthomas/kong"
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// manDescription introduces squish at the top of its manual.
+const manDescription = "squish creates, extracts and inspects archives and compressed files, identifying their formats by their signatures or extensions."
+
+// manFormats are the formats listed in the table of formats in the manual, in
+// the order they're listed.
+var manFormats = []string{"tar", "zip", "7z", "rar", "jar", "war", "ear", "apk", "epub", "cbz", "gz", "bz2", "xz", "zst", "lz4", "br", "sz", "lz", "zz"}
+
+// manExample is a command line shown in the manual, with what it does.
+type manExample struct {
+	command     string
+	description string
+}
+
+// manExamples are the examples in the manual, by the commands they're shown
+// for, or "" for those in the overview.
+var manExamples = map[string][]manExample{
+	"": {
+		{"squish create backup.tar.zst ~/Documents", "Archive a directory, compressed with zstd."},
+		{"squish extract backup.tar.zst restored", "Extract it into the directory restored."},
+		{"squish list https://example.com/release.zip", "List the entries of a zip on a web server, reading only its central directory."},
+	},
+	"create": {
+		{"squish create site.zip public/", "Archive the contents of public, without nesting them under public in the zip."},
+		{"squish create --level 19 --exclude '*.log' logs.tar.zst /var/log/app", "Archive a directory at a higher level, leaving out its logs."},
+		{"squish create --encrypt age:age1... secrets.tar.gz.age secrets", "Encrypt the archive to an age recipient as it's written."},
+	},
+	"extract": {
+		{"squish extract release.tar.gz", "Extract an archive into the current directory."},
+		{"squish extract --overwrite newer backup.zip restored 'docs/*'", "Extract the entries under docs, replacing files that are older than them."},
+		{"squish extract data.csv.xz", "Decompress a compressed file beside it, as data.csv."},
+	},
+	"list": {
+		{"squish list --type f backup.tar.zst", "List only the regular files in an archive."},
+	},
+	"train-dict": {
+		{"squish train-dict -o logs.dict samples/", "Train a dictionary from the files in samples, for create --dictionary logs.dict."},
+	},
+	"man": {
+		{"squish man | man -l -", "Read the manual without installing it."},
+		{"squish man --output share/man/man1", "Write the pages of the manual for packaging."},
+	},
+}
+
+// helpLong is the type of --help-long, which prints the whole manual as text.
+type helpLong bool
+
+func (h helpLong) BeforeReset(ctx *kong.Context) error {
+	w := &textManual{w: bufio.NewWriter(ctx.Stdout), width: 80}
+	writeOverview(w, ctx.Model.Node)
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	ctx.Exit(0)
+	return nil
+}
+
+// man writes the manual generated from the flags, as squish.1 to stdout, or
+// with --output, as squish.1 and a page for each command to a directory.
+func man() {
+	app, err := manParser()
+	if err != nil {
+		bail("failed to generate manual: %s", err)
+	}
+	if cli.Man.Output == "" {
+		if err := writeManPage(os.Stdout, func(w manual) { writeOverview(w, app.Model.Node) }); err != nil {
+			bail("failed to write manual: %s", err)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(cli.Man.Output, 0o755); err != nil {
+		bail("failed to create output directory: %s", err)
+	}
+	pages := map[string]func(manual){"squish": func(w manual) { writeOverview(w, app.Model.Node) }}
+	for _, command := range app.Model.Children {
+		if !command.Hidden {
+			pages["squish-"+command.Name] = func(w manual) { writeCommandPage(w, command) }
+		}
+	}
+	for name, page := range pages {
+		path := filepath.Join(cli.Man.Output, name+".1")
+		output, err := createAtomic(path, "")
+		if err != nil {
+			bail("failed to create man page: %s", err)
+		}
+		err = writeManPage(output, page)
+		if err == nil {
+			output.commit()
+		}
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			bail("failed to write man page %s: %s", path, err)
+		}
+	}
+}
+
+// manParser returns a parser of a copy of cli, whose defaults don't depend on
+// the machine, so that the manual generated from it is the same everywhere.
+func manParser() (*kong.Kong, error) {
+	grammar := cli
+	return kong.New(&grammar, append(cliOptions(), kong.Name("squish"), kong.Vars{"num_cpu": "", "progress": "", "is_root": ""})...)
+}
+
+// writeManPage writes the page written by page to w as roff.
+func writeManPage(w io.Writer, page func(manual)) error {
+	roff := &roffManual{w: bufio.NewWriter(w)}
+	page(roff)
+	return roff.w.Flush()
+}
+
+// manual is written to by the functions that generate the manual, so that it
+// can be rendered both as man pages and as text.
+type manual interface {
+	// title starts a page named name, summarized by summary.
+	title(name, summary string)
+	heading(text string)
+	subheading(text string)
+	paragraph(text string)
+	// item describes term, like a flag, with an indented description.
+	item(term, description string)
+	// table writes rows, of which the first is the header.
+	table(rows [][]string)
+	// example shows a command line, with what it does.
+	example(command, description string)
+}
+
+// writeOverview writes the manual of squish as a whole, with each command and
+// its flags, to w.
+func writeOverview(w manual, app *kong.Node) {
+	w.title("squish", "create, extract and inspect archives")
+	w.heading("Synopsis")
+	w.paragraph("squish [flags] <command> [<args> ...]")
+	w.heading("Description")
+	w.paragraph(manDescription)
+	w.heading("Global options")
+	writeFlags(w, app.Flags)
+	w.heading("Commands")
+	for _, command := range app.Children {
+		if command.Hidden {
+			continue
+		}
+		w.subheading(command.Summary())
+		w.paragraph(command.Help)
+		writeArguments(w, command.Positional)
+		writeFlags(w, command.Flags)
+	}
+	writeFormats(w)
+	w.heading("Configuration")
+	w.paragraph(configHelp)
+	writeEnvironment(w, app)
+	w.heading("Exit status")
+	w.paragraph(exitCodeHelp)
+	writeExamples(w, "")
+}
+
+// writeCommandPage writes the page of command to w.
+func writeCommandPage(w manual, command *kong.Node) {
+	summary, _, _ := strings.Cut(command.Help, ". ")
+	w.title("squish-"+command.Name, strings.TrimSuffix(strings.ToLower(summary[:1])+summary[1:], "."))
+	w.heading("Synopsis")
+	w.paragraph("squish " + command.Summary())
+	w.heading("Description")
+	w.paragraph(command.Help)
+	w.paragraph("The global options of squish(1) can be given before the command.")
+	if len(command.Positional) > 0 {
+		w.heading("Arguments")
+		writeArguments(w, command.Positional)
+	}
+	if len(command.Flags) > 0 {
+		w.heading("Options")
+		writeFlags(w, command.Flags)
+	}
+	writeExamples(w, command.Name)
+	w.heading("See also")
+	w.paragraph("squish(1)")
+}
+
+func writeArguments(w manual, args []*kong.Positional) {
+	for _, arg := range args {
+		w.item(arg.Summary(), arg.Help)
+	}
+}
+
+func writeFlags(w manual, flags []*kong.Flag) {
+	for _, flag := range flags {
+		if flag.Hidden {
+			continue
+		}
+		term := "--" + flag.Name
+		if flag.IsBool() && flag.Tag.Negatable != "" {
+			term = "--[no-]" + flag.Name
+		}
+		if flag.Short != 0 {
+			term = "-" + string(flag.Short) + ", " + term
+		}
+		if !flag.IsBool() && !flag.IsCounter() {
+			term += "=" + flag.FormatPlaceHolder()
+		}
+
+		// Flags without placeholders already show their defaults in their
+		// terms.
+		description := flag.Help
+		if flag.HasDefault && flag.Default != "" && flag.Default != "false" && (flag.IsBool() || flag.PlaceHolder != "") {
+			description += " Default: " + flag.Default + "."
+		}
+		w.item(term, description)
+	}
+}
+
+// writeFormats writes the table of formats, with whether they can be created
+// and their levels, as they're looked up by --format.
+func writeFormats(w manual) {
+	w.heading("Formats")
+	w.paragraph(formatHelp)
+	rows := [][]string{{"Format", "Also named", "Create", "Levels"}}
+	for _, name := range manFormats {
+		format, err := squish.LookupFormat(name)
+		if err != nil {
+			panic(err)
+		}
+		var aliases []string
+		for _, alias := range squish.CompressionNames() {
+			other, _ := squish.LookupFormat(alias)
+			if alias != name && reflect.TypeOf(other) == reflect.TypeOf(format) {
+				aliases = append(aliases, alias)
+			}
+		}
+		create := "no"
+		switch format.(type) {
+		case archives.Archiver, archives.Compressor:
+			create = "yes"
+		}
+		levels := "-"
+		lowest, highest := -1, -1
+		for level := 0; level <= 22; level++ {
+			if _, err := squish.WithLevel(format, level); err == nil {
+				if lowest < 0 {
+					lowest = level
+				}
+				highest = level
+			}
+		}
+		if lowest >= 0 {
+			levels = strconv.Itoa(lowest) + "-" + strconv.Itoa(highest)
+		}
+		rows = append(rows, []string{name, strings.Join(aliases, ", "), create, levels})
+	}
+	w.table(rows)
+	w.paragraph(levelHelp)
+}
+
+// writeEnvironment writes the environment variables that flags are read from.
+func writeEnvironment(w manual, app *kong.Node) {
+	w.heading("Environment")
+	w.item(configEnv, "The path of the config file.")
+	for _, key := range configKeys {
+		w.item("SQUISH_"+strings.ToUpper(key), "The default of --"+key+", overriding the config file.")
+	}
+	seen := map[string]bool{}
+	flags := app.Flags
+	for _, command := range app.Children {
+		flags = append(flags, command.Flags...)
+	}
+	for _, flag := range flags {
+		for _, env := range flag.Envs {
+			if !seen[env] {
+				seen[env] = true
+				w.item(env, "The value of --"+flag.Name+".")
+			}
+		}
+	}
+}
+
+func writeExamples(w manual, command string) {
+	if examples := manExamples[command]; len(examples) > 0 {
+		w.heading("Examples")
+		for _, example := range examples {
+			w.example(example.command, example.description)
+		}
+	}
+}
+
+// roffManual renders the manual as a man page.
+type roffManual struct {
+	w *bufio.Writer
+}
+
+// roffEscaper escapes text for roff, so that backslashes and hyphens are
+// printed as they are.
+var roffEscaper = strings.NewReplacer(`\`, `\e`, "-", `\-`)
+
+// roffText escapes text, and lines of it that would start with a control
+// character.
+func roffText(text string) string {
+	text = roffEscaper.Replace(text)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m *roffManual) title(name, summary string) {
+	// The preprocessor line tells man to run tbl for the table of formats.
+	fmt.Fprintf(m.w, "'\\\" t\n.TH %s 1 \"\" \"squish\" \"squish manual\"\n", strings.ToUpper(name))
+	fmt.Fprintf(m.w, ".SH NAME\n%s \\- %s\n", roffText(name), roffText(summary))
+}
+
+func (m *roffManual) heading(text string) {
+	fmt.Fprintf(m.w, ".SH %s\n", strings.ToUpper(roffText(text)))
+}
+
+func (m *roffManual) subheading(text string) {
+	fmt.Fprintf(m.w, ".SS %s\n", roffText(text))
+}
+
+func (m *roffManual) paragraph(text string) {
+	fmt.Fprintf(m.w, ".PP\n%s\n", roffText(text))
+}
+
+func (m *roffManual) item(term, description string) {
+	fmt.Fprintf(m.w, ".TP\n\\fB%s\\fR\n%s\n", roffText(term), roffText(description))
+}
+
+func (m *roffManual) table(rows [][]string) {
+	// The header is bold, and every column is left-aligned.
+	columns := strings.TrimSpace(strings.Repeat("l ", len(rows[0])))
+	fmt.Fprintf(m.w, ".TS\ntab(\t);\n%s\n%s.\n", strings.ReplaceAll(columns, "l", "lb"), columns)
+	for _, row := range rows {
+		fmt.Fprintln(m.w, roffText(strings.Join(row, "\t")))
+	}
+	fmt.Fprintln(m.w, ".TE")
+}
+
+func (m *roffManual) example(command, description string) {
+	fmt.Fprintf(m.w, ".PP\n%s\n.PP\n.RS\n.nf\n%s\n.fi\n.RE\n", roffText(description), roffText(command))
+}
+
+// textManual renders the manual as text wrapped to width, for --help-long.
+type textManual struct {
+	w     *bufio.Writer
+	width int
+}
+
+// manIndent is the indent of text under headings, and of the descriptions of
+// items under their terms.
+const manIndent = 4
+
+func (m *textManual) title(name, summary string) {
+	fmt.Fprintf(m.w, "%s - %s\n", name, summary)
+}
+
+func (m *textManual) heading(text string) {
+	fmt.Fprintf(m.w, "\n%s\n", strings.ToUpper(text))
+}
+
+func (m *textManual) subheading(text string) {
+	fmt.Fprintf(m.w, "\n  %s\n", text)
+}
+
+func (m *textManual) paragraph(text string) {
+	fmt.Fprintln(m.w)
+	m.wrap(text, manIndent)
+}
+
+func (m *textManual) item(term, description string) {
+	fmt.Fprintf(m.w, "\n%s%s\n", strings.Repeat(" ", manIndent), term)
+	m.wrap(description, 2*manIndent)
+}
+
+func (m *textManual) table(rows [][]string) {
+	fmt.Fprintln(m.w)
+	tw := tabwriter.NewWriter(m.w, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s%s\n", strings.Repeat(" ", manIndent), strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+func (m *textManual) example(command, description string) {
+	fmt.Fprintln(m.w)
+	m.wrap(description, manIndent)
+	fmt.Fprintf(m.w, "%s%s\n", strings.Repeat(" ", 2*manIndent), command)
+}
+
+// wrap writes text indented by n spaces, wrapped between words to m.width.
+func (m *textManual) wrap(text string, n int) {
+	line := 0
+	for _, word := range strings.Fields(text) {
+		if line > n && line+1+len(word) > m.width {
+			fmt.Fprintln(m.w)
+			line = 0
+		}
+		if line == 0 {
+			fmt.Fprint(m.w, strings.Repeat(" ", n))
+			line = n
+		} else {
+			fmt.Fprint(m.w, " ")
+			line++
+		}
+		fmt.Fprint(m.w, word)
+		line += len(word)
+	}
+	fmt.Fprintln(m.w)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+func TestManExamples(t *testing.T) {
+	app, err := manParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := map[string]*kong.Node{}
+	for _, command := range app.Model.Children {
+		commands[command.Name] = command
+	}
+
+	// The flags given in the examples must exist, so that they're kept up to
+	// date as flags are renamed.
+	for name, examples := range manExamples {
+		for _, example := range examples {
+			fields := strings.Fields(example.command)
+			if fields[0] != "squish" || commands[fields[1]] == nil {
+				t.Errorf("%s: example %q isn't of a command", name, example.command)
+				continue
+			}
+			if name != "" && fields[1] != name {
+				t.Errorf("%s: example %q is of %s", name, example.command, fields[1])
+			}
+			flags := append(commands[fields[1]].Flags, app.Model.Flags...)
+			for _, field := range fields[2:] {
+				flag, ok := strings.CutPrefix(field, "--")
+				if !ok {
+					continue
+				}
+				flag, _, _ = strings.Cut(flag, "=")
+				found := false
+				for _, f := range flags {
+					found = found || f.Name == flag
+				}
+				if !found {
+					t.Errorf("%s: example %q has unknown flag --%s", name, example.command, flag)
+				}
+			}
+		}
+	}
+}
+
+func TestManPages(t *testing.T) {
+	app, err := manParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeManPage(&buf, func(w manual) { writeOverview(w, app.Model.Node) }); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+	for _, want := range []string{".TH SQUISH 1", ".SS create <output>", `\fB\-\-level=N\fR`, "zst\tzstd\tyes\t1\\-22\n", "SQUISH_PASSWORD"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("squish.1 doesn't contain %q", want)
+		}
+	}
+	// Defaults that depend on the machine are left out.
+	for _, item := range strings.Split(page, ".TP\n") {
+		if strings.HasPrefix(item, `\fB\-\-threads=`) && strings.Contains(item, "Default:") {
+			t.Errorf("squish.1 has the number of CPUs as the default of --threads: %q", item)
+		}
+	}
+
+	for _, command := range app.Model.Children {
+		buf.Reset()
+		if err := writeManPage(&buf, func(w manual) { writeCommandPage(w, command) }); err != nil {
+			t.Fatal(err)
+		}
+		if want := ".TH SQUISH-" + strings.ToUpper(command.Name) + " 1"; !strings.Contains(buf.String(), want) {
+			t.Errorf("page of %s doesn't contain %q", command.Name, want)
+		}
+	}
+}
+
+func TestTextManualWrap(t *testing.T) {
+	var buf bytes.Buffer
+	m := &textManual{w: bufio.NewWriter(&buf), width: 20}
+	m.wrap("the quick brown fox jumps over the lazy dog", 4)
+	m.w.Flush()
+	want := "    the quick brown\n    fox jumps over\n    the lazy dog\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
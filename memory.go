@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mholt/archives"
+	fastxz "github.com/therootcompany/xz"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// memoryHelp documents what --max-memory applies to.
+const memoryHelp = "This limits the windows and dictionaries of xz and zst (zstd), failing to decompress inputs that need larger ones, the number of threads used to compress, and --prefetch. Other formats use a fixed amount of memory."
+
+// threadMemory is roughly how much memory each thread that compresses uses,
+// to fit --threads within --max-memory.
+const threadMemory = 4 * parallelGzBlockSize
+
+// threadsWithin returns threads, reduced so that compressing with them should
+// fit in limit bytes, unless limit is zero.
+func threadsWithin(threads int, limit int64) int {
+	if limit <= 0 {
+		return threads
+	}
+	return int(max(1, min(int64(threads), limit/threadMemory)))
+}
+
+// withMemoryLimit configures format to compress and decompress using windows
+// and dictionaries that fit in limit bytes, unless limit is zero. Inputs that
+// were compressed with larger ones fail to decompress, rather than using more
+// memory.
+func withMemoryLimit(format archives.Format, limit int64) archives.Format {
+	if limit <= 0 {
+		return format
+	}
+
+	switch format := format.(type) {
+	case archives.CompressedArchive:
+		format.Compression = withMemoryLimit(format.Compression, limit).(archives.Compression)
+		return format
+
+	case archives.Zstd:
+		// The encoder keeps several blocks of the window in memory.
+		window := 1 << int(math.Log2(float64(max(limit/4, zstd.MinWindowSize))))
+		format.EncoderOptions = append(format.EncoderOptions, zstd.WithWindowSize(min(window, zstd.MaxWindowSize)), zstd.WithLowerEncoderMem(true))
+		format.DecoderOptions = append(format.DecoderOptions, zstd.WithDecoderMaxMemory(uint64(limit)), zstd.WithDecoderMaxWindow(uint64(max(limit, zstd.MinWindowSize))), zstd.WithDecoderLowmem(true))
+		return format
+
+	case archives.Xz:
+		return limitedXz{Xz: format, limit: limit}
+
+	default:
+		return format
+	}
+}
+
+// limitedXz compresses xz with a dictionary that fits in limit bytes, and
+// refuses to decompress inputs that need a larger one.
+type limitedXz struct {
+	archives.Xz
+	limit int64
+}
+
+// xzDictCap is the dictionary size that xz.NewWriter uses by default.
+const xzDictCap = 8 << 20
+
+func (x limitedXz) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	// The encoder's hash table takes about as much memory as the
+	// dictionary.
+	config := xz.WriterConfig{DictCap: int(max(min(x.limit/2, xzDictCap), lzma.MinDictCap))}
+	return config.NewWriter(w)
+}
+
+func (x limitedXz) OpenReader(r io.Reader) (io.ReadCloser, error) {
+	xr, err := fastxz.NewReader(r, uint32(min(x.limit, math.MaxUint32)))
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(xr), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestThreadsWithin(t *testing.T) {
+	for _, test := range []struct {
+		threads int
+		limit   int64
+		want    int
+	}{
+		{8, 0, 8},
+		{8, 2 * threadMemory, 2},
+		{8, 1, 1},
+		{2, 100 * threadMemory, 2},
+	} {
+		if got := threadsWithin(test.threads, test.limit); got != test.want {
+			t.Errorf("got %d threads within %d bytes for %d, want %d", got, test.limit, test.threads, test.want)
+		}
+	}
+}
+
+func TestLimitedXz(t *testing.T) {
+	compress := func(format archives.Compressor) []byte {
+		var buf bytes.Buffer
+		w, err := format.OpenWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("squish\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	decompress := func(format archives.Decompressor, data []byte) error {
+		r, err := format.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	limited := withMemoryLimit(archives.Xz{}, 1<<20).(archives.Compression)
+	if err := decompress(limited, compress(limited)); err != nil {
+		t.Error(err)
+	}
+	// The default dictionary doesn't fit.
+	if err := decompress(limited, compress(archives.Xz{})); err == nil {
+		t.Error("decompressed input with a dictionary larger than the limit")
+	}
+}
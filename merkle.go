@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+const manifestVersion = 1
+
+// merkleManifest describes a Merkle tree over fixed-size chunks of an archive,
+// so that any chunk can be verified on its own against the root hash. Hashes
+// are computed as in RFC 6962, with leaves and interior nodes prefixed by
+// different bytes so that one can't be passed off as the other.
+type merkleManifest struct {
+	Version   int             `json:"squishManifest"`
+	Algorithm string          `json:"algorithm"`
+	ChunkSize int64           `json:"chunkSize"`
+	Size      int64           `json:"size"`
+	Root      string          `json:"root"`
+	Leaves    []string        `json:"leaves"`
+	Entries   []manifestEntry `json:"entries,omitempty"`
+}
+
+// manifestEntry locates the stored data of an entry within the archive, which
+// is compressed for compressed zip entries.
+type manifestEntry struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+func manifest(ctx context.Context) {
+	if cli.Manifest.ChunkSize <= 0 {
+		bail("invalid chunk size: %s", cli.Manifest.ChunkSize)
+	}
+
+	input, err := openFile(ctx, cli.Manifest.Input)
+	if err != nil {
+		bail("failed to open input file: %s", err)
+	}
+	defer closeInput(input)
+
+	result := merkleManifest{
+		Version:   manifestVersion,
+		Algorithm: "sha256",
+		ChunkSize: int64(cli.Manifest.ChunkSize),
+		Leaves:    []string{},
+	}
+
+	var leaves [][]byte
+	chunk := make([]byte, result.ChunkSize)
+	for {
+		n, err := io.ReadFull(input, chunk)
+		if n > 0 {
+			leaf := merkleLeaf(chunk[:n])
+			leaves = append(leaves, leaf)
+			result.Leaves = append(result.Leaves, hex.EncodeToString(leaf))
+			result.Size += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			bail("failed to read input file: %s", err)
+		}
+	}
+	result.Root = hex.EncodeToString(merkleRoot(leaves))
+
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		bail("failed to seek input file: %s", err)
+	}
+	format, _, err := identify(ctx, cli.Manifest.Input, input)
+	if err != nil && !errors.Is(err, archives.NoMatch) {
+		bail("failed to identify format: %s", err)
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		bail("failed to seek input file: %s", err)
+	}
+	switch format.(type) {
+	case archives.Tar:
+		result.Entries, err = tarEntryRanges(input)
+	case archives.Zip:
+		result.Entries, err = zipEntryRanges(input, result.Size)
+	}
+	if err != nil {
+		bail("failed to locate entries: %s", err)
+	}
+
+	outputPath := cli.Manifest.Output
+	if outputPath == "" {
+		outputPath = trimVolumeSuffix(cli.Manifest.Input) + ".merkle"
+	}
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		bail("failed to create manifest file: %s", err)
+	}
+	defer func() {
+		if err := output.Close(); err != nil {
+			bail("failed to close manifest file: %s", err)
+		}
+	}()
+
+	if err := json.NewEncoder(output).Encode(result); err != nil {
+		bail("failed to write manifest file: %s", err)
+	}
+}
+
+func verify(ctx context.Context) {
+	data, err := os.ReadFile(cli.Verify.Manifest)
+	if err != nil {
+		bail("failed to read manifest file: %s", err)
+	}
+	var m merkleManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		bail("failed to read manifest file: %s", err)
+	}
+	if m.Version != manifestVersion || m.Algorithm != "sha256" || m.ChunkSize <= 0 || int64(len(m.Leaves)) != (m.Size+m.ChunkSize-1)/m.ChunkSize {
+		bail("invalid manifest file")
+	}
+
+	leaves := make([][]byte, len(m.Leaves))
+	for i, leaf := range m.Leaves {
+		if leaves[i], err = hex.DecodeString(leaf); err != nil || len(leaves[i]) != sha256.Size {
+			bail("invalid manifest file")
+		}
+	}
+	root := hex.EncodeToString(merkleRoot(leaves))
+	if root != m.Root {
+		bail("manifest root hash %s doesn't match its chunk hashes", m.Root)
+	}
+	if cli.Verify.Root != "" && !strings.EqualFold(cli.Verify.Root, root) {
+		bail("manifest root hash %s doesn't match the expected %s", root, cli.Verify.Root)
+	}
+
+	// Each range is inclusive, as with HTTP Range headers.
+	var ranges []byteRange
+	for _, name := range cli.Verify.Entry {
+		entry, ok := m.entry(name)
+		if !ok {
+			bail("entry %s isn't located by the manifest, which only locates entries of uncompressed tar and zip archives", name)
+		}
+		if entry.Size > 0 {
+			ranges = append(ranges, byteRange{entry.Offset, entry.Offset + entry.Size - 1})
+		}
+	}
+	ranges = append(ranges, cli.Verify.Range...)
+	if len(cli.Verify.Entry) == 0 && len(cli.Verify.Range) == 0 && m.Size > 0 {
+		ranges = append(ranges, byteRange{0, m.Size - 1})
+	}
+
+	checked := make([]bool, len(leaves))
+	for _, r := range ranges {
+		if r.end >= m.Size {
+			bail("range %s is beyond the end of the archive, which is %d bytes", r, m.Size)
+		}
+		for i := r.start / m.ChunkSize; i <= r.end/m.ChunkSize; i++ {
+			checked[i] = true
+		}
+	}
+
+	input, err := openFile(ctx, cli.Verify.Input)
+	if err != nil {
+		bail("failed to open input file: %s", err)
+	}
+	defer closeInput(input)
+
+	if size := fileSize(input); size >= 0 && size != m.Size {
+		bail("input is %d bytes, but the manifest is for %d bytes", size, m.Size)
+	}
+
+	var total, mismatched int
+	chunk := make([]byte, m.ChunkSize)
+	for i, check := range checked {
+		if !check {
+			continue
+		}
+		total++
+
+		start := int64(i) * m.ChunkSize
+		n, err := input.ReadAt(chunk[:min(m.ChunkSize, m.Size-start)], start)
+		if err != nil && !(errors.Is(err, io.EOF) && int64(n) == m.Size-start) {
+			bail("failed to read input file: %s", err)
+		}
+		if !bytes.Equal(merkleLeaf(chunk[:n]), leaves[i]) {
+			warn("bytes %d-%d don't match the manifest", start, start+int64(n)-1)
+			mismatched++
+		}
+	}
+	if mismatched > 0 {
+		bail("%d of %d checked chunks don't match the manifest", mismatched, total)
+	}
+	fmt.Printf("verified %d of %d chunks\n", total, len(leaves))
+}
+
+// entry returns the entry of m named name, ignoring a leading ./ or trailing
+// slash.
+func (m *merkleManifest) entry(name string) (manifestEntry, bool) {
+	clean := func(name string) string {
+		return strings.TrimPrefix(path.Clean("/"+name), "/")
+	}
+	for _, entry := range m.Entries {
+		if clean(entry.Name) == clean(name) {
+			return entry, true
+		}
+	}
+	return manifestEntry{}, false
+}
+
+func merkleLeaf(chunk []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(chunk)
+	return h.Sum(nil)
+}
+
+// merkleRoot returns the root hash of the tree with the given leaf hashes,
+// splitting them at the largest power of two smaller than their number.
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+
+	split := 1
+	for split*2 < len(leaves) {
+		split *= 2
+	}
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(merkleRoot(leaves[:split]))
+	h.Write(merkleRoot(leaves[split:]))
+	return h.Sum(nil)
+}
+
+// tarEntryRanges locates the contents of the regular files in the tar archive
+// read from input, which must be positioned at its start. Sparse files are
+// skipped, since their contents aren't stored contiguously.
+func tarEntryRanges(input inputFile) ([]manifestEntry, error) {
+	r := &offsetReader{inputFile: input}
+	tr := tar.NewReader(r)
+
+	var entries []manifestEntry
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg || isSparse(header) {
+			continue
+		}
+		entries = append(entries, manifestEntry{Name: header.Name, Offset: r.offset, Size: header.Size})
+	}
+}
+
+// zipEntryRanges locates the stored data of the regular files in the zip
+// archive input, which is size bytes long.
+func zipEntryRanges(input inputFile, size int64) ([]manifestEntry, error) {
+	zr, err := zip.NewReader(input, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []manifestEntry
+	for _, file := range zr.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		offset, err := file.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, manifestEntry{Name: file.Name, Offset: offset, Size: int64(file.CompressedSize64)})
+	}
+	return entries, nil
+}
+
+// isSparse reports whether header is that of a sparse file, whose contents
+// aren't stored as they are in the archive.
+func isSparse(header *tar.Header) bool {
+	sparse := header.Typeflag == tar.TypeGNUSparse
+	for key := range header.PAXRecords {
+		sparse = sparse || strings.HasPrefix(key, "GNU.sparse.")
+	}
+	return sparse
+}
+
+// offsetReader tracks the offset it has read or seeked to.
+type offsetReader struct {
+	inputFile
+	offset int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.inputFile.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	offset, err := r.inputFile.Seek(offset, whence)
+	if err == nil {
+		r.offset = offset
+	}
+	return offset, err
+}
+
+// byteRange is a flag value holding an inclusive range of byte offsets, given
+// as START-END, where each may have a unit suffix like a byteSize.
+type byteRange struct {
+	start, end int64
+}
+
+func (r *byteRange) UnmarshalText(text []byte) error {
+	startStr, endStr, ok := strings.Cut(string(text), "-")
+	if !ok {
+		return fmt.Errorf("invalid range %q, expected START-END", text)
+	}
+
+	var start, end byteSize
+	if err := start.UnmarshalText([]byte(startStr)); err != nil {
+		return fmt.Errorf("invalid range %q: %w", text, err)
+	}
+	if err := end.UnmarshalText([]byte(endStr)); err != nil {
+		return fmt.Errorf("invalid range %q: %w", text, err)
+	}
+	if end < start {
+		return fmt.Errorf("invalid range %q, END is before START", text)
+	}
+
+	*r = byteRange{int64(start), int64(end)}
+	return nil
+}
+
+func (r byteRange) String() string {
+	return fmt.Sprintf("%d-%d", r.start, r.end)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	node := func(left, right []byte) []byte {
+		sum := sha256.Sum256(append(append([]byte{1}, left...), right...))
+		return sum[:]
+	}
+	leaves := [][]byte{merkleLeaf([]byte("a")), merkleLeaf([]byte("b")), merkleLeaf([]byte("c"))}
+
+	want := node(node(leaves[0], leaves[1]), leaves[2])
+	if got := merkleRoot(leaves); !bytes.Equal(got, want) {
+		t.Errorf("got root %x, want %x", got, want)
+	}
+	if got := merkleRoot(leaves[:1]); !bytes.Equal(got, leaves[0]) {
+		t.Errorf("got root %x for a single leaf, want the leaf %x", got, leaves[0])
+	}
+}
+
+func TestTarEntryRanges(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/b", typeflag: tar.TypeReg, contents: "bbb"},
+		{name: "l", typeflag: tar.TypeSymlink, linkname: "a/b"},
+		{name: "c", typeflag: tar.TypeReg, contents: string(bytes.Repeat([]byte("c"), 1000))},
+	})
+	archivePath := filepath.Join(t.TempDir(), "a.tar")
+	if err := os.WriteFile(archivePath, archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	entries, err := tarEntryRanges(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"a/b": "bbb", "c": string(bytes.Repeat([]byte("c"), 1000))}
+	if len(entries) != len(want) {
+		t.Fatalf("got entries %v, want %d", entries, len(want))
+	}
+	for _, entry := range entries {
+		if got := string(archive[entry.Offset : entry.Offset+entry.Size]); got != want[entry.Name] {
+			t.Errorf("%s: got contents %q, want %q", entry.Name, got, want[entry.Name])
+		}
+	}
+}
+
+func TestByteRange(t *testing.T) {
+	var r byteRange
+	if err := r.UnmarshalText([]byte("1K-2K")); err != nil || r != (byteRange{1024, 2048}) {
+		t.Errorf("got %v, %v, want 1024-2048", r, err)
+	}
+	for _, text := range []string{"", "1", "2-1", "a-b"} {
+		if err := r.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("%q: got no error", text)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// metaPAXPrefix prefixes the keys of the metadata given by --meta in the PAX
+// records of tar headers.
+const metaPAXPrefix = "SQUISH.meta."
+
+// zipExtraMeta is the ID of the zip extra field that the metadata given by
+// --meta is stored in, as a length-prefixed key and value for each record.
+const zipExtraMeta = 0x7173
+
+// entryMeta attaches the metadata key=value to the entries matching pattern.
+type entryMeta struct {
+	pattern    glob
+	key, value string
+}
+
+// entryMetas is the value of create --meta, which takes a pattern and a
+// KEY=VALUE as separate arguments, and can be given more than once.
+type entryMetas []entryMeta
+
+func (m *entryMetas) Decode(ctx *kong.DecodeContext) error {
+	var args [2]string
+	for i := range args {
+		token, err := ctx.Scan.PopValue("meta")
+		if err != nil {
+			return err
+		}
+		args[i] = token.String()
+	}
+
+	var meta entryMeta
+	if err := meta.pattern.UnmarshalText([]byte(args[0])); err != nil {
+		return err
+	}
+	var ok bool
+	meta.key, meta.value, ok = strings.Cut(args[1], "=")
+	if !ok || meta.key == "" || strings.ContainsRune(meta.key, 0) || strings.ContainsRune(meta.value, 0) {
+		return fmt.Errorf("invalid metadata %q, expected KEY=VALUE", args[1])
+	}
+	*m = append(*m, meta)
+	return nil
+}
+
+// records returns the metadata of the entry called name, by its keys, or nil
+// if it has none. Metadata given later replaces that given earlier for the
+// same key.
+func (m entryMetas) records(name string) map[string]string {
+	var records map[string]string
+	for _, meta := range m {
+		if meta.pattern.matches(name) {
+			if records == nil {
+				records = map[string]string{}
+			}
+			records[meta.key] = meta.value
+		}
+	}
+	return records
+}
+
+// supportsMeta reports whether format, as created with the other flags given,
+// stores the metadata given by --meta. ustar archives, as written by
+// --compat=busybox, can't store PAX records.
+func supportsMeta(format archives.Format) bool {
+	if _, ok := format.(squish.ZipVariant); ok {
+		return true
+	}
+	return isTar(format) && cli.Create.Compat != "busybox" || format.Extension() == ".zip"
+}
+
+// withEntryMeta stores the metadata given by --meta in the headers of the
+// entries of files that it matches, which are in format.
+func withEntryMeta(files []archives.FileInfo, metas entryMetas, format archives.Format) ([]archives.FileInfo, error) {
+	tarFormat := isTar(format)
+	for i, file := range files {
+		records := metas.records(file.NameInArchive)
+		if records == nil {
+			continue
+		}
+
+		if tarFormat {
+			info, ok := file.FileInfo.(xattrInfo)
+			if !ok {
+				header, err := tar.FileInfoHeader(file.FileInfo, file.LinkTarget)
+				if err != nil {
+					return nil, err
+				}
+				info = xattrInfo{file.FileInfo, header}
+			}
+			if info.header.PAXRecords == nil {
+				info.header.PAXRecords = map[string]string{}
+			}
+			for key, value := range records {
+				info.header.PAXRecords[metaPAXPrefix+key] = value
+			}
+			files[i].FileInfo = info
+			continue
+		}
+
+		extra, err := zipMetaExtra(records)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.NameInArchive, err)
+		}
+		files[i].FileInfo = zipExtraInfo{file.FileInfo, &zip.FileHeader{Extra: extra}}
+	}
+	return files, nil
+}
+
+// zipMetaExtra encodes records as a zip extra field.
+func zipMetaExtra(records map[string]string) ([]byte, error) {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var data []byte
+	for _, key := range keys {
+		if len(key) > 0xffff || len(records[key]) > 0xffff {
+			return nil, fmt.Errorf("metadata %s is too long for a zip", key)
+		}
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(key)))
+		data = append(data, key...)
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(records[key])))
+		data = append(data, records[key]...)
+	}
+	if len(data) > 0xffff {
+		return nil, errors.New("metadata is too long for a zip")
+	}
+	extra := binary.LittleEndian.AppendUint16(nil, zipExtraMeta)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(len(data)))
+	return append(extra, data...), nil
+}
+
+// zipExtraInfo stores extra fields in the zip header of an entry, which the
+// zips squish writes take the extra fields of entries from if their Sys method
+// returns one.
+type zipExtraInfo struct {
+	fs.FileInfo
+	header *zip.FileHeader
+}
+
+func (i zipExtraInfo) Sys() any {
+	return i.header
+}
+
+// zipExtra returns the extra fields stored in the zip header returned by the
+// Sys method of file, if any.
+func zipExtra(file archives.FileInfo) []byte {
+	if header, ok := file.Sys().(*zip.FileHeader); ok {
+		return header.Extra
+	}
+	return nil
+}
+
+// entryMetaRecords returns the metadata stored in the header of info by
+// --meta, or nil if it has none.
+func entryMetaRecords(info archives.FileInfo) map[string]string {
+	var records map[string]string
+	add := func(key, value string) {
+		if records == nil {
+			records = map[string]string{}
+		}
+		records[key] = value
+	}
+
+	switch header := info.Header.(type) {
+	case *tar.Header:
+		for key, value := range header.PAXRecords {
+			if key, ok := strings.CutPrefix(key, metaPAXPrefix); ok {
+				add(key, value)
+			}
+		}
+
+	case zip.FileHeader:
+		extra := header.Extra
+		for len(extra) >= 4 {
+			id, size := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+			if len(extra) < 4+size {
+				break
+			}
+			data := extra[4 : 4+size]
+			extra = extra[4+size:]
+			for id == zipExtraMeta && len(data) >= 2 {
+				keyLen := int(binary.LittleEndian.Uint16(data))
+				if len(data) < 4+keyLen {
+					break
+				}
+				key := string(data[2 : 2+keyLen])
+				valueLen := int(binary.LittleEndian.Uint16(data[2+keyLen:]))
+				data = data[4+keyLen:]
+				if len(data) < valueLen {
+					break
+				}
+				add(key, string(data[:valueLen]))
+				data = data[valueLen:]
+			}
+		}
+	}
+	return records
+}
+
+// metaZip writes zips like archives.Zip, but stores the extra fields of the
+// zip headers returned by the Sys methods of files, for --meta.
+type metaZip struct {
+	archives.Zip
+}
+
+func (z metaZip) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	zw := zip.NewWriter(output)
+	for _, file := range files {
+		if err := z.archiveFile(ctx, zw, file); err != nil {
+			zw.Close()
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (z metaZip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	zw := zip.NewWriter(output)
+	for job := range jobs {
+		job.Result <- z.archiveFile(ctx, zw, job.File)
+	}
+	return zw.Close()
+}
+
+func (z metaZip) archiveFile(ctx context.Context, zw *zip.Writer, file archives.FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(file)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+	}
+	header.Name = file.NameInArchive
+	header.Method = z.Compression
+	header.Extra = zipExtra(file)
+	if file.IsDir() {
+		header.Name = strings.TrimSuffix(header.Name, "/") + "/"
+		header.Method = zip.Store
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+	}
+	if file.IsDir() {
+		return nil
+	}
+
+	input, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	if _, err := io.Copy(w, input); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.NameInArchive, err)
+	}
+	return nil
+}
+
+// metaMatches reports whether info has all of the metadata given by list
+// --meta, as KEY=VALUE, or as KEY for any value.
+func metaMatches(metas []string, info archives.FileInfo) bool {
+	if len(metas) == 0 {
+		return true
+	}
+	records := entryMetaRecords(info)
+	for _, meta := range metas {
+		key, want, hasValue := strings.Cut(meta, "=")
+		value, ok := records[key]
+		if !ok || hasValue && value != want {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"archive/tar"
+	"maps"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+func TestEntryMeta(t *testing.T) {
+	metas := entryMetas{
+		{pattern: "bin/*", key: "builder", value: "ci"},
+		{pattern: "*.so", key: "commit", value: "1a2b3c"},
+		{pattern: "bin/tool", key: "builder", value: "release"},
+	}
+	want := map[string]string{"builder": "ci", "commit": "1a2b3c"}
+	if got := metas.records("bin/lib.so"); !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := metas.records("bin/tool"); got["builder"] != "release" {
+		t.Errorf("got %v, want the later builder", got)
+	}
+	if got := metas.records("doc/readme"); got != nil {
+		t.Errorf("got %v for an unmatched entry", got)
+	}
+
+	// Both headers are read back alike.
+	extra, err := zipMetaExtra(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other extra fields are skipped.
+	extra = append([]byte{0x55, 0x54, 1, 0, 7}, extra...)
+	for _, header := range []any{
+		zip.FileHeader{Extra: extra},
+		&tar.Header{PAXRecords: map[string]string{"SQUISH.meta.builder": "ci", "SQUISH.meta.commit": "1a2b3c", "SCHILY.xattr.user.a": "b"}},
+	} {
+		info := archives.FileInfo{Header: header}
+		if got := entryMetaRecords(info); !maps.Equal(got, want) {
+			t.Errorf("%T: got %v, want %v", header, got, want)
+		}
+		if !metaMatches([]string{"builder=ci", "commit"}, info) || metaMatches([]string{"builder=release"}, info) || metaMatches([]string{"other"}, info) {
+			t.Errorf("%T: metadata wasn't matched correctly", header)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// modeHelp documents the syntax accepted by flags holding a modeChange.
+const modeHelp = "Modes are given as an octal mode like 644, or symbolically like u=rw,go-w or a+X as with chmod(1), where changes that don't say who they apply to apply to everyone."
+
+// modeChange is a flag value describing a change to permissions in the syntax
+// accepted by chmod(1): either an absolute octal mode such as 644, or
+// comma-separated symbolic clauses such as u=rw,go-w or a+X. Symbolic clauses
+// that don't name who they apply to apply to everyone.
+type modeChange struct {
+	absolute *uint32
+	clauses  []modeClause
+}
+
+type modeClause struct {
+	// who is the mask of the permission bits the clause applies to.
+	who uint32
+	ops []modeOp
+}
+
+type modeOp struct {
+	op    byte
+	perms string
+}
+
+func (m *modeChange) UnmarshalText(text []byte) error {
+	str := string(text)
+	if absolute, err := strconv.ParseUint(str, 8, 32); err == nil {
+		if absolute > 0o7777 {
+			return fmt.Errorf("invalid mode %q", str)
+		}
+		mode := uint32(absolute)
+		*m = modeChange{absolute: &mode}
+		return nil
+	}
+
+	var clauses []modeClause
+	for _, clauseStr := range strings.Split(str, ",") {
+		var clause modeClause
+		i := 0
+	who:
+		for ; i < len(clauseStr); i++ {
+			switch clauseStr[i] {
+			case 'u':
+				clause.who |= 0o4700
+			case 'g':
+				clause.who |= 0o2070
+			case 'o':
+				clause.who |= 0o1007
+			case 'a':
+				clause.who |= 0o7777
+			default:
+				break who
+			}
+		}
+		if clause.who == 0 {
+			clause.who = 0o7777
+		}
+
+		if i == len(clauseStr) {
+			return fmt.Errorf("invalid mode %q: missing operator", str)
+		}
+		for i < len(clauseStr) {
+			op := clauseStr[i]
+			if op != '+' && op != '-' && op != '=' {
+				return fmt.Errorf("invalid mode %q: unexpected %q", str, op)
+			}
+			i++
+
+			start := i
+			for i < len(clauseStr) && strings.IndexByte("rwxXstugo", clauseStr[i]) >= 0 {
+				i++
+			}
+			clause.ops = append(clause.ops, modeOp{op: op, perms: clauseStr[start:i]})
+		}
+
+		clauses = append(clauses, clause)
+	}
+
+	*m = modeChange{clauses: clauses}
+	return nil
+}
+
+// apply returns mode with the change applied. A nil *modeChange leaves mode
+// unchanged.
+func (m *modeChange) apply(mode fs.FileMode) fs.FileMode {
+	if m == nil {
+		return mode
+	}
+
+	unix := toUnixPerm(mode)
+	if m.absolute != nil {
+		unix = *m.absolute
+	} else {
+		for _, clause := range m.clauses {
+			for _, op := range clause.ops {
+				bits := op.bits(unix, mode.IsDir()) & clause.who
+				switch op.op {
+				case '+':
+					unix |= bits
+				case '-':
+					unix &^= bits
+				case '=':
+					unix = unix&^clause.who | bits
+				}
+			}
+		}
+	}
+
+	return mode&^chmodBits | fromUnixPerm(unix)
+}
+
+// chmodBits are the bits of a mode that chmod changes.
+const chmodBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// fixedMode overrides the mode of an entry.
+type fixedMode struct {
+	fs.FileInfo
+	mode fs.FileMode
+}
+
+func (f fixedMode) Mode() fs.FileMode {
+	return f.mode
+}
+
+// changedMode overrides the mode of an entry with change applied.
+type changedMode struct {
+	fs.FileInfo
+	change *modeChange
+}
+
+func (c changedMode) Mode() fs.FileMode {
+	return c.change.apply(c.FileInfo.Mode())
+}
+
+// bits returns the permission bits described by op for every class, given
+// the current permissions.
+func (op modeOp) bits(current uint32, dir bool) uint32 {
+	var bits uint32
+	for _, perm := range op.perms {
+		switch perm {
+		case 'r':
+			bits |= 0o444
+		case 'w':
+			bits |= 0o222
+		case 'x':
+			bits |= 0o111
+		case 'X':
+			if dir || current&0o111 != 0 {
+				bits |= 0o111
+			}
+		case 's':
+			bits |= 0o6000
+		case 't':
+			bits |= 0o1000
+		case 'u':
+			bits |= copyClass(current >> 6 & 7)
+		case 'g':
+			bits |= copyClass(current >> 3 & 7)
+		case 'o':
+			bits |= copyClass(current & 7)
+		}
+	}
+	return bits
+}
+
+// copyClass returns the permissions rwx of one class for every class.
+func copyClass(rwx uint32) uint32 {
+	return rwx<<6 | rwx<<3 | rwx
+}
+
+func toUnixPerm(mode fs.FileMode) uint32 {
+	unix := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		unix |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		unix |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		unix |= 0o1000
+	}
+	return unix
+}
+
+func fromUnixPerm(unix uint32) fs.FileMode {
+	mode := fs.FileMode(unix & 0o777)
+	if unix&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if unix&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if unix&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
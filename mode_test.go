@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestModeChange(t *testing.T) {
+	tests := []struct {
+		change string
+		mode   fs.FileMode
+		want   fs.FileMode
+	}{
+		{"644", 0o777, 0o644},
+		{"4755", 0o644, fs.ModeSetuid | 0o755},
+		{"a-x", 0o755, 0o644},
+		{"-x", 0o755, 0o644},
+		{"u=rw,go=r", 0o777, 0o644},
+		{"go-w", 0o666, 0o644},
+		{"o=", 0o777, 0o770},
+		{"u+x,g+w", 0o600, 0o720},
+		{"a+X", 0o644, 0o644},
+		{"a+X", 0o744, 0o755},
+		{"a+X", fs.ModeDir | 0o644, fs.ModeDir | 0o755},
+		{"g=u", 0o640, 0o660},
+		{"u+s", 0o755, fs.ModeSetuid | 0o755},
+		{"+t", fs.ModeDir | 0o777, fs.ModeDir | fs.ModeSticky | 0o777},
+		{"u=rwx-x+r", 0o000, 0o600},
+	}
+
+	for _, test := range tests {
+		var change modeChange
+		if err := change.UnmarshalText([]byte(test.change)); err != nil {
+			t.Errorf("%s: %s", test.change, err)
+			continue
+		}
+
+		if got := change.apply(test.mode); got != test.want {
+			t.Errorf("%s applied to %s: got %s, want %s", test.change, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestModeChangeInvalid(t *testing.T) {
+	for _, change := range []string{"", "u", "q+x", "u+x,", "17777", "u+z"} {
+		if err := new(modeChange).UnmarshalText([]byte(change)); err == nil {
+			t.Errorf("%q was accepted", change)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/mholt/archives"
+)
+
+func mount(ctx context.Context) {
+	input, _, _ := openExtractor(ctx, cli.Mount.Input)
+	defer closeInput(input)
+
+	fsys, err := archives.FileSystem(ctx, filepath.Base(trimVolumeSuffix(cli.Mount.Input)), input)
+	if err != nil {
+		bail("failed to open archive file system: %s", err)
+	}
+
+	dev, err := fuseMount(cli.Mount.Mountpoint)
+	if err != nil {
+		bail("failed to mount archive: %s", err)
+	}
+	defer func() {
+		if err := dev.Close(); err != nil {
+			bail("failed to close fuse device: %s", err)
+		}
+	}()
+
+	server, err := newFuseServer(fsys, dev)
+	if err != nil {
+		if err := fuseUnmount(cli.Mount.Mountpoint); err != nil {
+			bail("failed to unmount archive: %s", err)
+		}
+		bail("failed to index archive: %s", err)
+	}
+
+	// Interrupting squish unmounts the archive, which stops the server once
+	// the kernel has finished with it.
+	go func() {
+		<-ctx.Done()
+		if err := fuseUnmount(cli.Mount.Mountpoint); err != nil {
+			// The mount is still being served, so there's nothing better to do
+			// than report the failure and let the user unmount it manually.
+			warn("failed to unmount archive: %s", err)
+		}
+	}()
+
+	if err := server.serve(); err != nil {
+		bail("failed to serve archive: %s", err)
+	}
+}
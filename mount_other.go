@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "context"
+
+func mount(_ context.Context) {
+	bail("mount is only supported on Linux")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// nestedHelp documents paths through archives.
+const nestedHelp = "Each archive along such a path must be a regular file in the one before it, and those nested in others are read into memory."
+
+// splitNestedPath splits name, which names something inside an archive on
+// disk, like outer.zip/inner.tar.gz/docs/readme.md, into the path of the
+// archive and the elements of the rest of it. It reports false if name exists
+// on disk, or doesn't start with a regular file.
+func splitNestedPath(name string) (outer string, inner []string, ok bool) {
+	if name == stdioPath || isURL(name) {
+		return "", nil, false
+	}
+	if _, err := os.Lstat(name); err == nil {
+		return "", nil, false
+	}
+
+	elems := strings.Split(filepath.ToSlash(name), "/")
+	for i := len(elems) - 1; i > 0; i-- {
+		outer := strings.Join(elems[:i], "/")
+		if outer == "" {
+			continue
+		}
+		if info, err := os.Stat(outer); err == nil && info.Mode().IsRegular() {
+			return filepath.FromSlash(outer), elems[i:], true
+		}
+	}
+	return "", nil, false
+}
+
+// openNested opens the innermost archive named by the elements inner of a
+// path within the archive at outer, returning it along with the rest of them
+// joined, which name an entry within it, or an empty string if they name the
+// archive itself. Entries that are neither the last element nor
+// directories must be archives, and those that are nested in others are read
+// into memory, since they can't be seeked within.
+func openNested(ctx context.Context, outer string, inner []string) (inputFile, string, error) {
+	file, err := openFile(ctx, outer)
+	if err != nil {
+		return nil, "", err
+	}
+	name := outer
+
+	for len(inner) > 0 {
+		format, _, err := identify(ctx, name, file)
+		if err != nil {
+			file.Close()
+			return nil, "", fmt.Errorf("failed to identify format of %s: %w", name, err)
+		}
+		if !isArchiveFormat(format) {
+			file.Close()
+			return nil, "", fmt.Errorf("%s isn't an archive", name)
+		}
+		size := fileSize(file)
+		if size < 0 {
+			file.Close()
+			return nil, "", fmt.Errorf("failed to find the size of %s", name)
+		}
+		fsys := &archives.ArchiveFS{Stream: io.NewSectionReader(file, 0, size), Format: format.(archives.Extractor), Context: ctx}
+
+		// The nested archive is the first regular file along the rest of
+		// the path, since entries' names can contain slashes.
+		entry := ""
+		for i := range inner {
+			p := path.Clean(strings.Join(inner[:i+1], "/"))
+			info, err := fs.Stat(fsys, p)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if i == len(inner)-1 {
+				archive, err := isArchiveEntry(ctx, fsys, p)
+				if err != nil {
+					file.Close()
+					return nil, "", err
+				}
+				if !archive {
+					return file, strings.Join(inner, "/"), nil
+				}
+			}
+			entry, inner = p, inner[i+1:]
+			break
+		}
+		if entry == "" {
+			return file, strings.Join(inner, "/"), nil
+		}
+
+		data, err := fs.ReadFile(fsys, entry)
+		file.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read %s: %w", entry, err)
+		}
+		file, name = memInput{bytes.NewReader(data)}, entry
+	}
+	return file, "", nil
+}
+
+// isArchiveFormat reports whether format is that of an archive, rather than
+// of a compressed file.
+func isArchiveFormat(format archives.Format) bool {
+	if compressed, ok := format.(archives.CompressedArchive); ok {
+		return compressed.Extraction != nil
+	}
+	_, ok := format.(archives.Extraction)
+	return ok
+}
+
+// isArchiveEntry reports whether the regular file name in fsys is an archive.
+func isArchiveEntry(ctx context.Context, fsys fs.FS, name string) (bool, error) {
+	entry, err := fsys.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer entry.Close()
+
+	format, _, err := identify(ctx, name, entry)
+	if errors.Is(err, archives.NoMatch) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return isArchiveFormat(format), nil
+}
+
+// nestedEntry splits name, which may name an archive or an entry within
+// archives nested in others, into the path of the innermost archive, which
+// can be opened with openFile, and the path of the entry within it, or an
+// empty string if name is the archive itself.
+func nestedEntry(ctx context.Context, name string) (archive, entry string) {
+	outer, inner, ok := splitNestedPath(name)
+	if !ok {
+		return name, ""
+	}
+	file, entry, err := openNested(ctx, outer, inner)
+	if err != nil {
+		bail("failed to open input file: %s", err)
+	}
+	closeInput(file)
+
+	if entry == "" {
+		return name, ""
+	}
+	archive = strings.TrimSuffix(filepath.ToSlash(name), entry)
+	return filepath.FromSlash(strings.TrimSuffix(archive, "/")), path.Clean(entry)
+}
+
+// memInput is an input file read into memory.
+type memInput struct {
+	*bytes.Reader
+}
+
+func (memInput) Close() error { return nil }
+
+// entryWithin reports whether name is dir, or an entry within it.
+func entryWithin(name, dir string) bool {
+	name = path.Clean(name)
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// entryGlob returns a pattern that matches the entry name, along with its
+// contents, and nothing else.
+func entryGlob(name string) glob {
+	var pattern strings.Builder
+	pattern.WriteByte('/')
+	for _, r := range name {
+		if strings.ContainsRune(`*?[\`, r) {
+			pattern.WriteByte('\\')
+		}
+		pattern.WriteRune(r)
+	}
+	return glob(pattern.String())
+}
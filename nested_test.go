@@ -0,0 +1,64 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNestedEntry(t *testing.T) {
+	inner := makeTar(t, []testEntry{
+		{name: "docs/", typeflag: tar.TypeDir},
+		{name: "docs/readme.md", typeflag: tar.TypeReg, contents: "readme"},
+	})
+	dir := t.TempDir()
+	outer := filepath.Join(dir, "outer.zip")
+	if err := os.WriteFile(outer, makeZip(t, []testEntry{{name: "a/inner.tar", typeflag: tar.TypeReg, contents: string(inner)}}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, archive, entry string
+	}{
+		{outer, outer, ""},
+		{filepath.Join(outer, "a", "inner.tar"), filepath.Join(outer, "a", "inner.tar"), ""},
+		{filepath.Join(outer, "a", "inner.tar", "docs"), filepath.Join(outer, "a", "inner.tar"), "docs"},
+		{filepath.Join(outer, "a", "inner.tar", "docs", "readme.md"), filepath.Join(outer, "a", "inner.tar"), "docs/readme.md"},
+		{filepath.Join(outer, "a"), outer, "a"},
+	}
+	for _, test := range tests {
+		archive, entry := nestedEntry(context.Background(), test.name)
+		if archive != test.archive || entry != test.entry {
+			t.Errorf("got %s, %s for %s, want %s, %s", archive, entry, test.name, test.archive, test.entry)
+		}
+	}
+
+	file, err := openFile(context.Background(), filepath.Join(outer, "a", "inner.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(inner) {
+		t.Error("got different contents for the nested archive")
+	}
+
+	if _, err := openFile(context.Background(), filepath.Join(outer, "a", "inner.tar", "docs", "readme.md")); err == nil {
+		t.Error("opened an entry that isn't an archive")
+	}
+}
+
+func TestEntryGlob(t *testing.T) {
+	g := entryGlob("a/[b]*")
+	for name, want := range map[string]bool{"a/[b]*": true, "a/[b]*/c": true, "a/b": false, "x/a/[b]*": false} {
+		if got := g.matches(name); got != want {
+			t.Errorf("got %t for %s, want %t", got, name, want)
+		}
+	}
+}
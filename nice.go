@@ -0,0 +1,35 @@
+package main
+
+import (
+	"runtime"
+	"slices"
+
+	"github.com/alecthomas/kong"
+)
+
+// niceThreads is the number of threads that commands use by default with
+// --nice, leaving most of the CPUs to interactive work.
+func niceThreads() int {
+	return max(1, runtime.NumCPU()/4)
+}
+
+// applyNice lowers the CPU and I/O priority of squish for --nice, and the
+// number of threads used by the command parsed into kctx, unless it was given
+// with --threads or in the config file.
+func applyNice(kctx *kong.Context) {
+	if err := lowerPriority(); err != nil {
+		warn("failed to lower priority: %s", err)
+	}
+
+	given := slices.ContainsFunc(kctx.Path, func(p *kong.Path) bool {
+		return p.Flag != nil && p.Flag.Name == "threads"
+	})
+	if given {
+		return
+	}
+	for _, flag := range kctx.Flags() {
+		if flag.Name == "threads" {
+			flag.Target.SetInt(int64(niceThreads()))
+		}
+	}
+}
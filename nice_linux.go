@@ -0,0 +1,48 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	// niceness is the CPU priority set by --nice, which is the default of
+	// nice.
+	niceness = 10
+	// ioprioWhoProcess, ioprioClassBE and ioprioLowest give the I/O
+	// priority set by --nice, the lowest of the best-effort class, like
+	// ionice -c2 -n7.
+	ioprioWhoProcess = 1
+	ioprioClassBE    = 2
+	ioprioLowest     = 7
+	ioprioClassShift = 13
+)
+
+// lowerPriority lowers the CPU and I/O priority of every thread of the
+// process, which threads created later inherit, since Linux sets them for each
+// thread.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, niceness); err != nil && !errors.Is(err, syscall.ESRCH) {
+			errs = append(errs, err)
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassBE<<ioprioClassShift|ioprioLowest)
+		if errno != 0 && errno != syscall.ESRCH {
+			errs = append(errs, errno)
+		}
+	}
+	return errors.Join(errs...)
+}
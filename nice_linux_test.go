@@ -0,0 +1,51 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+// niceTestEnv is set when the test binary is run by TestNice to apply --nice
+// to itself, so that the priority of the other tests isn't lowered.
+const niceTestEnv = "SQUISH_TEST_NICE"
+
+func TestNice(t *testing.T) {
+	if os.Getenv(niceTestEnv) == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestNice$", "-test.v")
+		cmd.Env = append(os.Environ(), niceTestEnv+"=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("process with --nice failed: %s\n%s", err, out)
+		}
+		return
+	}
+
+	// Threads are reduced, unless they're given.
+	applyNice(parseCLI(t, "--nice", "create", "out.tar", "in"))
+	if cli.Create.Threads != niceThreads() {
+		t.Errorf("got %d threads, want %d", cli.Create.Threads, niceThreads())
+	}
+	applyNice(parseCLI(t, "--nice", "create", "--threads", "3", "out.tar", "in"))
+	if cli.Create.Threads != 3 {
+		t.Errorf("got %d threads, want the 3 given", cli.Create.Threads)
+	}
+
+	// The kernel returns 20 minus the niceness, so that it's positive.
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := 20 - prio; got != niceness {
+		t.Errorf("got niceness %d, want %d", got, niceness)
+	}
+	ioprio, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if want := uintptr(ioprioClassBE<<ioprioClassShift | ioprioLowest); ioprio != want {
+		t.Errorf("got I/O priority %#x, want %#x", ioprio, want)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func lowerPriority() error {
+	return errors.New("--nice only lowers priority on Linux")
+}
@@ -9,11 +9,16 @@
   outputs = { self, nixpkgs, flake-utils }: {
     overlays.default = final: _: {
       squish = final.callPackage
-        ({ buildGoModule }: buildGoModule {
+        ({ buildGoModule, installShellFiles }: buildGoModule {
           pname = "squish";
           version = "0.1.0";
           src = builtins.path { path = ./..; name = "squish-src"; };
           vendorHash = null;
+          nativeBuildInputs = [ installShellFiles ];
+          postInstall = ''
+            $out/bin/squish --no-history man --output man
+            installManPage man/*.1
+          '';
         })
         { };
     };
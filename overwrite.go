@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// answers reads the answers to --overwrite=prompt from stdin.
+var answers = bufio.NewReader(os.Stdin)
+
+// replaceExisting reports whether existing, which is at the path name would be
+// extracted to, should be replaced by an entry modified at modTime, according
+// to policy, which is one of the values of --overwrite. Existing files are
+// never replaced by default, with a warning.
+func replaceExisting(policy, name string, modTime time.Time, existing fs.FileInfo, progress *progress) bool {
+	switch policy {
+	case "always":
+		return true
+	case "newer":
+		return modTime.After(existing.ModTime())
+	case "prompt":
+		return progress.confirm(localize("replace %s? [y/N] ", name))
+	default:
+		warn("skipped %s, which already exists", name)
+		return false
+	}
+}
+
+// confirm asks question on stderr, pausing the progress line while waiting,
+// and reports whether the answer read from stdin was yes. A nil *progress just
+// asks the question.
+func (p *progress) confirm(question string) bool {
+	if p != nil && p.show {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		defer p.draw()
+	}
+
+	fmt.Fprint(os.Stderr, question)
+	answer, _ := answers.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
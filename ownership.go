@@ -0,0 +1,64 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// idMapping is a flag value mapping a numeric user or group ID stored in an
+// archive to the one to use instead, given as FROM:TO.
+type idMapping struct {
+	from, to int
+}
+
+func (m *idMapping) UnmarshalText(text []byte) error {
+	fromStr, toStr, ok := strings.Cut(string(text), ":")
+	if !ok {
+		return fmt.Errorf("invalid ID mapping %q, expected FROM:TO", text)
+	}
+
+	from, err := strconv.Atoi(fromStr)
+	if err != nil || from < 0 {
+		return fmt.Errorf("invalid ID %q", fromStr)
+	}
+	to, err := strconv.Atoi(toStr)
+	if err != nil || to < 0 {
+		return fmt.Errorf("invalid ID %q", toStr)
+	}
+
+	*m = idMapping{from: from, to: to}
+	return nil
+}
+
+// idMap returns mappings as a map, where later mappings of the same ID take
+// precedence.
+func idMap(mappings []idMapping) map[int]int {
+	m := make(map[int]int, len(mappings))
+	for _, mapping := range mappings {
+		m[mapping.from] = mapping.to
+	}
+	return m
+}
+
+// entryOwner returns the numeric owner and group that info should be extracted
+// with, after applying ownerMap and groupMap, or false if the archive doesn't
+// store them, which only tar archives do.
+func entryOwner(info archives.FileInfo, ownerMap, groupMap map[int]int) (uid, gid int, ok bool) {
+	header, ok := info.Header.(*tar.Header)
+	if !ok {
+		return 0, 0, false
+	}
+
+	uid, gid = header.Uid, header.Gid
+	if mapped, ok := ownerMap[uid]; ok {
+		uid = mapped
+	}
+	if mapped, ok := groupMap[gid]; ok {
+		gid = mapped
+	}
+	return uid, gid, true
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestIDMapping(t *testing.T) {
+	var mappings []idMapping
+	for _, text := range []string{"1000:0", "5:6", "5:7"} {
+		var m idMapping
+		if err := m.UnmarshalText([]byte(text)); err != nil {
+			t.Fatalf("%s: %s", text, err)
+		}
+		mappings = append(mappings, m)
+	}
+
+	want := map[int]int{1000: 0, 5: 7}
+	got := idMap(mappings)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for from, to := range want {
+		if got[from] != to {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestIDMappingInvalid(t *testing.T) {
+	for _, text := range []string{"", "1", "1:", ":1", "a:1", "1:-1", "1:2:3"} {
+		var m idMapping
+		if err := m.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("%q: got no error", text)
+		}
+	}
+}
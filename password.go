@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+)
+
+// passwordFDEnv is set in the environment of the process re-executed by
+// --sandbox to a file descriptor that the password given at the prompt can be
+// read from, so that it isn't asked for again.
+const passwordFDEnv = "SQUISH_PASSWORD_FD"
+
+// password is the value of --password, which is asked for when the flag is
+// given without one.
+type password struct {
+	value string
+	// prompt is whether the flag was given without a value.
+	prompt bool
+}
+
+// Decode only takes a value given as --password=VALUE, or from the
+// environment, so that a following argument isn't mistaken for the password.
+func (p *password) Decode(ctx *kong.DecodeContext) error {
+	token := ctx.Scan.Peek()
+	if token.Type != kong.FlagValueToken {
+		p.prompt = true
+		return nil
+	}
+	ctx.Scan.Pop()
+
+	p.value = token.String()
+	if p.value == "" {
+		return errors.New("password must not be empty")
+	}
+	return nil
+}
+
+// given reports whether --password was given, with or without a value.
+func (p password) given() bool {
+	return p.value != "" || p.prompt
+}
+
+// get returns the password, or "" if --password wasn't given. When it was
+// given without a value, the password is read from stdin the first time,
+// without echoing it if stdin is a terminal, and asked for twice if confirm is
+// true, so that a mistyped password doesn't make an archive impossible to
+// open.
+func (p *password) get(confirm bool) string {
+	if p.value != "" || !p.prompt {
+		return p.value
+	}
+
+	if fd, err := strconv.Atoi(os.Getenv(passwordFDEnv)); err == nil {
+		f := os.NewFile(uintptr(fd), "password")
+		defer f.Close()
+		value, err := io.ReadAll(f)
+		if err != nil {
+			bail("failed to read password: %s", err)
+		}
+		p.value = string(value)
+		return p.value
+	}
+
+	value, err := readPassword(localize("Password: "))
+	if err != nil {
+		bail("failed to read password: %s", err)
+	}
+	if value == "" {
+		bail("password must not be empty")
+	}
+	if confirm && isTerminal(os.Stdin) {
+		repeated, err := readPassword(localize("Repeat password: "))
+		if err != nil {
+			bail("failed to read password: %s", err)
+		}
+		if repeated != value {
+			bail("passwords don't match")
+		}
+	}
+	p.value = value
+	return p.value
+}
+
+// readPassword asks for a password on stderr and reads a line from stdin,
+// without echoing it if stdin is a terminal.
+func readPassword(prompt string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		line, err := answers.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	restore, err := disableEcho(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := answers.ReadString('\n')
+	// The newline that was typed wasn't echoed.
+	fmt.Fprintln(os.Stderr)
+	if restoreErr := restore(); err == nil {
+		err = restoreErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// disableEcho stops the terminal f from echoing what's typed, returning a
+// function that restores its previous settings.
+func disableEcho(f *os.File) (func() error, error) {
+	var termios syscall.Termios
+	if err := ioctlTermios(f, syscall.TCGETS, &termios); err != nil {
+		return nil, err
+	}
+	restored := termios
+
+	termios.Lflag &^= syscall.ECHO
+	if err := ioctlTermios(f, syscall.TCSETS, &termios); err != nil {
+		return nil, err
+	}
+	return func() error { return ioctlTermios(f, syscall.TCSETS, &restored) }, nil
+}
+
+func ioctlTermios(f *os.File, request uintptr, termios *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return os.NewSyscallError("ioctl", errno)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func disableEcho(*os.File) (func() error, error) {
+	return nil, errors.New("passwords can only be asked for on Linux, so give it as --password=PASSWORD or in $SQUISH_PASSWORD")
+}
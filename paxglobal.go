@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// paxGlobals are the PAX global headers read from a tar archive, which apply
+// to every entry after them, like the commit ID that git archive records.
+// archives.Tar skips them, so they'd be lost when an archive is rewritten.
+type paxGlobals struct {
+	headers []*tar.Header
+}
+
+// records returns the global records in effect after the headers read so far,
+// with later headers overriding earlier ones.
+func (g *paxGlobals) records() map[string]string {
+	records := map[string]string{}
+	for _, header := range g.headers {
+		for key, value := range header.PAXRecords {
+			records[key] = value
+		}
+	}
+	return records
+}
+
+// withPAXGlobals returns format, which may be a compressed tar archive, reading
+// global headers into globals when it extracts, and writing them back when it
+// archives, in the same places among the entries. Other formats are returned
+// as they are.
+func withPAXGlobals[F any](format F, globals *paxGlobals) F {
+	var wrapped any = format
+	switch f := wrapped.(type) {
+	case archives.Tar:
+		wrapped = globalTar{f, globals}
+	case archives.CompressedArchive:
+		if t, ok := f.Archival.(archives.Tar); ok {
+			f.Archival = globalTar{t, globals}
+		}
+		if t, ok := f.Extraction.(archives.Tar); ok {
+			f.Extraction = globalTar{t, globals}
+		}
+		wrapped = f
+	}
+	if wrapped, ok := wrapped.(F); ok {
+		return wrapped
+	}
+	return format
+}
+
+// globalTar is a tar format that keeps its archives' PAX global headers in
+// globals.
+type globalTar struct {
+	archives.Tar
+	globals *paxGlobals
+}
+
+// Extract is like archives.Tar's, but records global headers instead of
+// skipping them.
+func (t globalTar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile archives.FileHandler) error {
+	tr := tar.NewReader(sourceArchive)
+	var skipped []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			t.globals.headers = append(t.globals.headers, header)
+			continue
+		}
+		if skips(skipped, header.Name) {
+			continue
+		}
+
+		info := header.FileInfo()
+		err = handleFile(ctx, archives.FileInfo{
+			FileInfo:      info,
+			Header:        header,
+			NameInArchive: header.Name,
+			LinkTarget:    header.Linkname,
+			Open: func() (fs.File, error) {
+				return seekableEntryFile{tr, info}, nil
+			},
+		})
+		if errors.Is(err, fs.SkipAll) {
+			return nil
+		} else if errors.Is(err, fs.SkipDir) && info.IsDir() {
+			skipped = append(skipped, strings.TrimSuffix(header.Name, "/")+"/")
+		} else if err != nil {
+			return fmt.Errorf("handling file: %s: %w", header.Name, err)
+		}
+	}
+}
+
+// skips reports whether name is beneath one of the skipped directories.
+func skips(skipped []string, name string) bool {
+	for _, dir := range skipped {
+		if strings.HasPrefix(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Archive writes every global header that has been read before the files.
+func (t globalTar) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	return squish.Archive(ctx, t, output, files, nil)
+}
+
+// ArchiveAsync writes the global headers that have been read so far before
+// each file, so that when an archive is rewritten while it's extracted, they
+// keep their places among the entries.
+func (t globalTar) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	tw := tar.NewWriter(output)
+	written := 0
+	writeGlobals := func() error {
+		for ; written < len(t.globals.headers); written++ {
+			if err := tw.WriteHeader(t.globals.headers[written]); err != nil {
+				return fmt.Errorf("writing global header: %w", err)
+			}
+		}
+		return nil
+	}
+
+	for job := range jobs {
+		if err := writeGlobals(); err != nil {
+			job.Result <- err
+			continue
+		}
+		job.Result <- formatTar{t.Tar, tar.FormatUnknown}.archiveFile(ctx, tw, job.File)
+	}
+	if err := writeGlobals(); err != nil {
+		tw.Close()
+		return err
+	}
+	return tw.Close()
+}
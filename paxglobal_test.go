@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// writeGlobalTar writes a tar archive of headers to path, giving each regular
+// file its name as its contents.
+func writeGlobalTar(t *testing.T, path string, headers []*tar.Header) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			io.WriteString(tw, header.Name)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tarSequence returns the names of the entries of the tar archive in r, with
+// global headers as "global " and their comment record, in order.
+func tarSequence(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	var sequence []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return sequence
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			sequence = append(sequence, "global "+header.PAXRecords["comment"])
+		} else {
+			sequence = append(sequence, header.Name)
+		}
+	}
+}
+
+func TestPAXGlobals(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	global := func(comment string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": comment}}
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, ModTime: time.Unix(1e9, 0)}
+	}
+	archivePath := filepath.Join(dir, "in.tar")
+	writeGlobalTar(t, archivePath, []*tar.Header{global("first"), file("a"), global("second"), file("b"), global("last")})
+	want := []string{"global first", "a", "global second", "b", "global last"}
+
+	saved := cli
+	t.Cleanup(func() { cli = saved })
+
+	// Retouching keeps global headers among the entries they were between.
+	mtime := timestamp(time.Unix(2e9, 0))
+	cli.Retouch.Input, cli.Retouch.Output, cli.Retouch.SetMtime = archivePath, filepath.Join(dir, "retouched.tar"), &mtime
+	retouch(ctx)
+	retouched, err := os.Open(cli.Retouch.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer retouched.Close()
+	if got := tarSequence(t, retouched); !slices.Equal(got, want) {
+		t.Errorf("retouch: got %q, want %q", got, want)
+	}
+
+	// Repacking keeps the archive byte for byte.
+	original, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(original)
+	gw.Close()
+	compressed := filepath.Join(dir, "in.tar.gz")
+	if err := os.WriteFile(compressed, gzipped.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cli.Repack.Input, cli.Repack.To, cli.Repack.Output, cli.Repack.Threads = compressed, "zst", filepath.Join(dir, "repacked.tar.zst"), 1
+	repack(ctx)
+	repacked, err := os.ReadFile(cli.Repack.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zstd.NewReader(bytes.NewReader(repacked))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, original) {
+		t.Error("repack changed the archive")
+	}
+
+	// Syncing rewrites archives with their global headers before the entries.
+	mirrored := filepath.Join(dir, "in")
+	if err := os.Mkdir(mirrored, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mirrored, "a"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	synced := filepath.Join(dir, "synced.tar")
+	writeGlobalTar(t, synced, []*tar.Header{global("first"), file("in/a"), global("second"), file("in/deleted")})
+	cli.Sync.Directory, cli.Sync.Archive = mirrored, synced
+	syncArchive(ctx)
+	syncedFile, err := os.Open(synced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syncedFile.Close()
+	if got, want := tarSequence(t, syncedFile), []string{"global first", "global second", "in/", "in/a"}; !slices.Equal(got, want) {
+		t.Errorf("sync: got %q, want %q", got, want)
+	}
+}
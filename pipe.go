@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// The --pipe stream starts with pipeMagic, followed by frames that each hold
+// a 4-byte big-endian length, that many bytes of the archive, and the CRC-32C
+// of those bytes. It ends with a frame with a length of zero, followed by the
+// SHA-256 digest of the whole archive, which is only written if the archive
+// was created successfully, so that truncated streams are detected too.
+const (
+	pipeMagic     = "squish-pipe-v1\n"
+	pipeFrameSize = 256 << 10
+)
+
+var (
+	errPipeHeader    = errors.New("input isn't a --pipe stream")
+	errPipeChecksum  = errors.New("checksum of --pipe stream frame doesn't match, so it was corrupted in transit")
+	errPipeDigest    = errors.New("digest of --pipe stream doesn't match, so it was corrupted in transit")
+	errPipeTruncated = errors.New("--pipe stream ended early, so the sender failed or it was truncated in transit")
+)
+
+var pipeCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// pipeWriter frames what's written to it as a --pipe stream. Its commit method
+// is like the one returned by createOutput, and the final digest is only
+// written if it was called.
+type pipeWriter struct {
+	output       io.WriteCloser
+	commitOutput func()
+	committed    bool
+
+	frame  []byte
+	digest hash.Hash
+	err    error
+}
+
+func newPipeWriter(output io.WriteCloser, commit func()) *pipeWriter {
+	w := &pipeWriter{output: output, commitOutput: commit, frame: make([]byte, 4, 4+pipeFrameSize+4), digest: sha256.New()}
+	_, w.err = io.WriteString(output, pipeMagic)
+	return w
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && w.err == nil {
+		n := min(len(p), 4+pipeFrameSize-len(w.frame))
+		w.frame = append(w.frame, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.frame) == 4+pipeFrameSize {
+			w.flush()
+		}
+	}
+	return written, w.err
+}
+
+// flush writes the buffered frame, if it isn't empty.
+func (w *pipeWriter) flush() {
+	data := w.frame[4:]
+	if len(data) == 0 || w.err != nil {
+		return
+	}
+	binary.BigEndian.PutUint32(w.frame, uint32(len(data)))
+	w.digest.Write(data)
+	w.frame = binary.BigEndian.AppendUint32(w.frame, crc32.Checksum(data, pipeCRCTable))
+	_, w.err = w.output.Write(w.frame)
+	w.frame = w.frame[:4]
+}
+
+func (w *pipeWriter) commit() {
+	w.committed = true
+}
+
+// Close writes the last frame and, if commit was called, the final digest,
+// before closing the output.
+func (w *pipeWriter) Close() error {
+	w.flush()
+	if w.err == nil && w.committed {
+		_, w.err = w.output.Write(w.digest.Sum(make([]byte, 4)))
+		if w.err == nil {
+			w.commitOutput()
+		}
+	}
+	return errors.Join(w.err, w.output.Close())
+}
+
+// pipeReader reads the archive in a --pipe stream, failing if any of its
+// frames were corrupted.
+type pipeReader struct {
+	r      *bufio.Reader
+	digest hash.Hash
+	// frame is the unread part of the current frame, which has already been
+	// checked.
+	frame, buf []byte
+	done       bool
+}
+
+func newPipeReader(r io.Reader) (*pipeReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(pipeMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != pipeMagic {
+		return nil, errPipeHeader
+	}
+	return &pipeReader{r: br, digest: sha256.New()}, nil
+}
+
+func (r *pipeReader) Read(p []byte) (int, error) {
+	for len(r.frame) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.frame)
+	r.frame = r.frame[n:]
+	return n, nil
+}
+
+// next reads and checks the next frame, or the final digest.
+func (r *pipeReader) next() error {
+	var length [4]byte
+	if _, err := io.ReadFull(r.r, length[:]); err != nil {
+		return truncatedPipe(err)
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size == 0 {
+		digest := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(r.r, digest); err != nil {
+			return truncatedPipe(err)
+		}
+		if !bytes.Equal(digest, r.digest.Sum(nil)) {
+			return errPipeDigest
+		}
+		r.done = true
+		return nil
+	}
+	if size > pipeFrameSize {
+		return errPipeChecksum
+	}
+
+	if cap(r.buf) < int(size)+4 {
+		r.buf = make([]byte, pipeFrameSize+4)
+	}
+	frame := r.buf[:size+4]
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		return truncatedPipe(err)
+	}
+	data := frame[:size]
+	if crc32.Checksum(data, pipeCRCTable) != binary.BigEndian.Uint32(frame[size:]) {
+		return errPipeChecksum
+	}
+	r.digest.Write(data)
+	r.frame = data
+	return nil
+}
+
+// finish reads the rest of the stream, which formats like tar can leave unread
+// after the end of the archive, so that its final digest is checked.
+func (r *pipeReader) finish() error {
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+func truncatedPipe(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errPipeTruncated
+	}
+	return err
+}
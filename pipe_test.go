@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// pipeStream frames data as a --pipe stream, committing it if commit is true.
+func pipeStream(t *testing.T, data []byte, commit bool) []byte {
+	var buf bytes.Buffer
+	w := newPipeWriter(nopWriteCloser{&buf}, func() {})
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if commit {
+		w.commit()
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readPipeStream(stream []byte) ([]byte, error) {
+	r, err := newPipeReader(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestPipeStream(t *testing.T) {
+	for _, size := range []int{0, 1, pipeFrameSize, 2*pipeFrameSize + 1} {
+		data := make([]byte, size)
+		rand.Read(data)
+		stream := pipeStream(t, data, true)
+
+		got, err := readPipeStream(stream)
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: read %d bytes that don't match", size, len(got))
+		}
+
+		if _, err := readPipeStream(pipeStream(t, data, false)); !errors.Is(err, errPipeTruncated) {
+			t.Errorf("size %d: got error %v for an uncommitted stream, want %v", size, err, errPipeTruncated)
+		}
+		if _, err := readPipeStream(stream[:len(stream)-1]); !errors.Is(err, errPipeTruncated) {
+			t.Errorf("size %d: got error %v for a truncated stream, want %v", size, err, errPipeTruncated)
+		}
+
+		corrupted := bytes.Clone(stream)
+		corrupted[len(corrupted)-1] ^= 1
+		if _, err := readPipeStream(corrupted); !errors.Is(err, errPipeDigest) {
+			t.Errorf("size %d: got error %v for a corrupted digest, want %v", size, err, errPipeDigest)
+		}
+		if size > 0 {
+			corrupted := bytes.Clone(stream)
+			corrupted[len(pipeMagic)+4] ^= 1
+			if _, err := readPipeStream(corrupted); !errors.Is(err, errPipeChecksum) {
+				t.Errorf("size %d: got error %v for corrupted data, want %v", size, err, errPipeChecksum)
+			}
+		}
+	}
+}
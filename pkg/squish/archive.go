@@ -0,0 +1,137 @@
+package squish
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/mholt/archives"
+)
+
+// copyBufferSize is the size of the buffer that decompressed contents are
+// copied through, which is larger than io.Copy's, so that fewer writes are made
+// to the output, and fewer reads are made from decompressors.
+const copyBufferSize = 1 << 20
+
+// EntryError is an error that occurred while archiving or extracting a
+// particular entry. Its message is that of the wrapped error, which usually
+// already mentions the entry.
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (e *EntryError) Error() string { return e.Err.Error() }
+func (e *EntryError) Unwrap() error { return e.Err }
+
+// Archive writes files to output in format, calling archiving, if it's not nil,
+// with each of them as it's archived. That can only be done as each is archived
+// for formats that can archive asynchronously, so for others, it's called with
+// every file up front.
+func Archive(ctx context.Context, format archives.Archiver, output io.Writer, files []archives.FileInfo, archiving func(archives.FileInfo)) error {
+	async, ok := format.(archives.ArchiverAsync)
+	if !ok {
+		if archiving != nil {
+			for _, file := range files {
+				archiving(file)
+			}
+		}
+		return format.Archive(ctx, output, files)
+	}
+
+	i := 0
+	return ArchiveFrom(ctx, async, output, func() (archives.FileInfo, error) {
+		if i == len(files) {
+			return archives.FileInfo{}, io.EOF
+		}
+		i++
+		return files[i-1], nil
+	}, archiving)
+}
+
+// ArchiveFrom writes the files returned by next to output in format, until it
+// returns io.EOF, calling archiving, if it's not nil, with each of them as it's
+// archived, so that files can be archived as they're found. Errors archiving a
+// file are returned as *EntryErrors, and other errors returned by next are
+// returned as they are.
+func ArchiveFrom(ctx context.Context, format archives.ArchiverAsync, output io.Writer, next func() (archives.FileInfo, error), archiving func(archives.FileInfo)) error {
+	jobs := make(chan archives.ArchiveAsyncJob)
+	archiveErr := make(chan error, 1)
+	go func() {
+		archiveErr <- format.ArchiveAsync(ctx, output, jobs)
+	}()
+
+	result := make(chan error)
+	var err error
+	for {
+		var file archives.FileInfo
+		if file, err = next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			break
+		}
+		if archiving != nil {
+			archiving(file)
+		}
+		jobs <- archives.ArchiveAsyncJob{File: file, Result: result}
+		if err = <-result; err != nil {
+			var entryErr *EntryError
+			if !errors.As(err, &entryErr) {
+				err = &EntryError{Name: file.NameInArchive, Err: err}
+			}
+			break
+		}
+	}
+	close(jobs)
+
+	if asyncErr := <-archiveErr; err == nil {
+		err = asyncErr
+	}
+	return err
+}
+
+// Compress writes the contents of input to output compressed in format,
+// returning the number of bytes read from input. The compressed file is
+// complete once it returns, since closing the compressor is what writes its
+// end. It stops once ctx is canceled.
+func Compress(ctx context.Context, format archives.Compressor, output io.Writer, input io.Reader) (int64, error) {
+	w, err := format.OpenWriter(output)
+	if err != nil {
+		return 0, err
+	}
+	read, err := io.Copy(w, contextReader{ctx, input})
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return read, err
+}
+
+// Decompress writes the decompressed contents of input, which is compressed in
+// format, to output, returning the number of bytes written to it. It stops
+// once ctx is canceled.
+func Decompress(ctx context.Context, format archives.Decompressor, output io.Writer, input io.Reader) (int64, error) {
+	r, err := format.OpenReader(input)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.CopyBuffer(output, contextReader{ctx, r}, make([]byte, copyBufferSize))
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// contextReader fails to read once ctx is canceled, so that copying from a
+// large input stops promptly.
+type contextReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
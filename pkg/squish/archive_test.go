@@ -0,0 +1,52 @@
+package squish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mholt/archives"
+)
+
+func TestArchive(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("a"), Mode: 0o644},
+		"b.txt": {Data: []byte("b"), Mode: 0o644},
+	}
+	var files []archives.FileInfo
+	for _, name := range []string{"a.txt", "b.txt"} {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, archives.FileInfo{FileInfo: info, NameInArchive: name, Open: func() (fs.File, error) { return fsys.Open(name) }})
+	}
+
+	for _, format := range []archives.Archiver{archives.Tar{}, archives.Zip{}} {
+		var archiving []string
+		var buf bytes.Buffer
+		err := Archive(context.Background(), format, &buf, files, func(file archives.FileInfo) {
+			archiving = append(archiving, file.NameInArchive)
+		})
+		if err != nil {
+			t.Fatalf("%T: %s", format, err)
+		}
+		if want := []string{"a.txt", "b.txt"}; !slices.Equal(archiving, want) {
+			t.Errorf("%T: archived %q, want %q", format, archiving, want)
+		}
+	}
+
+	// Errors archiving a file name it.
+	failing := slices.Clone(files)
+	errOpen := errors.New("failed to open")
+	failing[1].Open = func() (fs.File, error) { return nil, errOpen }
+	var entryErr *EntryError
+	err := Archive(context.Background(), archives.Tar{}, &bytes.Buffer{}, failing, nil)
+	if !errors.As(err, &entryErr) || entryErr.Name != "b.txt" || !errors.Is(err, errOpen) {
+		t.Errorf("got error %v, want one for b.txt", err)
+	}
+}
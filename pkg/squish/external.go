@@ -0,0 +1,260 @@
+package squish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// RegisterFormat adds format to those looked up by LookupFormat, under its
+// extension without the leading dot, and to those identified by
+// archives.Identify, so that it can be created and extracted like the formats
+// squish supports itself. Compression formats can be combined with tar, like
+// tar.gz. It returns an error if a format with the same name is already
+// registered.
+func RegisterFormat(format archives.Format) (err error) {
+	name := strings.ToLower(strings.TrimPrefix(format.Extension(), "."))
+	if name == "" || strings.Contains(name, ".") {
+		return fmt.Errorf("invalid format name %q", name)
+	}
+	if _, err := LookupFormat(name); err == nil {
+		return fmt.Errorf("format %q already exists", name)
+	}
+
+	compression, isCompression := format.(archives.Compression)
+	extraction, isExtraction := format.(archives.Extraction)
+	if !isCompression && !isExtraction {
+		return fmt.Errorf("%s is neither a compression nor an archive format", name)
+	}
+
+	// archives panics rather than returning an error for formats it already
+	// has, which squish may not, like lzma.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("format %q already exists", name)
+		}
+	}()
+	archives.RegisterFormat(format)
+	if isCompression {
+		compressionFormats[name] = compression
+	} else {
+		archiveFormats[name] = extraction
+	}
+	return nil
+}
+
+// ExternalFormat is a format that's created and extracted by running other
+// programs, like lzop or zpaq, so that squish can use formats it doesn't
+// support itself. The programs read from their standard input and write to
+// their standard output. For compression formats, Compress compresses its
+// input, and Decompress decompresses it. For archive formats, Compress reads a
+// tar archive of the files to archive and writes the archive in the format,
+// and Decompress reads an archive in the format and writes a tar archive of
+// its entries, so that only converting to and from tar is needed.
+type ExternalFormat struct {
+	// Name is the name of the format, which is also its extension, like lzo.
+	Name string
+	// Magic is what files in the format start with, which they're identified
+	// by along with their extension. It may be empty.
+	Magic []byte
+	// Archive is whether the format is an archive format rather than a
+	// compression format.
+	Archive bool
+	// Compress and Decompress are the programs and arguments run to create
+	// and extract the format. Either may be empty, in which case the format
+	// can't be created or extracted.
+	Compress, Decompress []string
+}
+
+// Format returns the format f describes, to be given to RegisterFormat.
+func (f ExternalFormat) Format() archives.Format {
+	if f.Archive {
+		return externalArchive{f}
+	}
+	return externalCompression{f}
+}
+
+// IsExternal reports whether format, which may be a compressed archive, runs
+// an ExternalFormat's programs, so that callers that can't run other programs
+// can refuse it up front.
+func IsExternal(format archives.Format) bool {
+	var parts []any
+	if compressed, ok := format.(archives.CompressedArchive); ok {
+		parts = []any{compressed.Compression, compressed.Archival, compressed.Extraction}
+	} else {
+		parts = []any{format}
+	}
+	for _, part := range parts {
+		switch part.(type) {
+		case externalCompression, externalArchive:
+			return true
+		}
+	}
+	return false
+}
+
+func (f ExternalFormat) Extension() string {
+	return "." + f.Name
+}
+
+func (f ExternalFormat) MediaType() string {
+	return "application/x-" + f.Name
+}
+
+func (f ExternalFormat) Match(_ context.Context, filename string, stream io.Reader) (archives.MatchResult, error) {
+	var mr archives.MatchResult
+	mr.ByName = strings.HasSuffix(strings.ToLower(filename), f.Extension())
+	if len(f.Magic) == 0 || stream == nil {
+		return mr, nil
+	}
+	buf := make([]byte, len(f.Magic))
+	n, err := io.ReadFull(stream, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return mr, err
+	}
+	mr.ByStream = bytes.Equal(buf[:n], f.Magic)
+	return mr, nil
+}
+
+// compressor runs Compress, returning a writer of its input, which is written
+// to w.
+func (f ExternalFormat) compressor(w io.Writer) (io.WriteCloser, error) {
+	if len(f.Compress) == 0 {
+		return nil, fmt.Errorf("%s: %w", f.Name, ErrReadOnlyFormat)
+	}
+	cmd := exec.Command(f.Compress[0], f.Compress[1:]...)
+	cmd.Stdout = w
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &externalWriter{cmd: cmd, stdin: stdin, stderr: stderr}, nil
+}
+
+// decompressor runs Decompress on r, returning a reader of its output.
+func (f ExternalFormat) decompressor(r io.Reader) (io.ReadCloser, error) {
+	if len(f.Decompress) == 0 {
+		return nil, fmt.Errorf("%s files can't be extracted", f.Name)
+	}
+	cmd := exec.Command(f.Decompress[0], f.Decompress[1:]...)
+	cmd.Stdin = r
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &externalReader{cmd: cmd, stdout: stdout, stderr: stderr}, nil
+}
+
+// externalCompression is a compression format run by an ExternalFormat.
+type externalCompression struct {
+	ExternalFormat
+}
+
+func (f externalCompression) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	return f.compressor(w)
+}
+
+func (f externalCompression) OpenReader(r io.Reader) (io.ReadCloser, error) {
+	return f.decompressor(r)
+}
+
+// externalArchive is an archive format run by an ExternalFormat, which
+// converts to and from tar.
+type externalArchive struct {
+	ExternalFormat
+}
+
+func (f externalArchive) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	w, err := f.compressor(output)
+	if err != nil {
+		return err
+	}
+	err = archives.Tar{}.Archive(ctx, w, files)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f externalArchive) Extract(ctx context.Context, archive io.Reader, handleFile archives.FileHandler) error {
+	r, err := f.decompressor(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return archives.Tar{}.Extract(ctx, r, handleFile)
+}
+
+// externalWriter writes to the input of a running program, and waits for it
+// to exit once closed.
+type externalWriter struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *bytes.Buffer
+}
+
+func (w *externalWriter) Write(p []byte) (int, error) {
+	return w.stdin.Write(p)
+}
+
+func (w *externalWriter) Close() error {
+	w.stdin.Close()
+	return externalError(w.cmd, w.stderr, w.cmd.Wait())
+}
+
+// externalReader reads the output of a running program, failing at the end of
+// it if the program did. Closing it before then kills the program.
+type externalReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+	exited bool
+}
+
+func (r *externalReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF && !r.exited {
+		r.exited = true
+		if err := externalError(r.cmd, r.stderr, r.cmd.Wait()); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+func (r *externalReader) Close() error {
+	if !r.exited {
+		r.exited = true
+		r.cmd.Process.Kill()
+		r.cmd.Wait()
+	}
+	return nil
+}
+
+// externalError describes err, returned by waiting for cmd, along with the
+// last line cmd wrote to stderr, which usually says why it failed.
+func externalError(cmd *exec.Cmd, stderr *bytes.Buffer, err error) error {
+	if err == nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if message := lines[len(lines)-1]; message != "" {
+		return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, message)
+	}
+	return fmt.Errorf("%s: %w", cmd.Args[0], err)
+}
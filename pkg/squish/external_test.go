@@ -0,0 +1,66 @@
+package squish
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExternalFormat(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("gzip isn't installed")
+	}
+	ctx := context.Background()
+	for _, format := range []ExternalFormat{
+		{Name: "extgz", Compress: []string{"gzip", "-c"}, Decompress: []string{"gzip", "-dc"}},
+		{Name: "exttar", Archive: true, Compress: []string{"cat"}, Decompress: []string{"cat"}},
+	} {
+		if err := RegisterFormat(format.Format()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RegisterFormat(ExternalFormat{Name: "gz", Compress: []string{"gzip"}}.Format()); err == nil {
+		t.Error("gz was registered again")
+	}
+
+	for _, name := range []string{"extgz", "tar.extgz", "exttar"} {
+		if format, err := LookupFormat(name); err != nil || !IsExternal(format) {
+			t.Errorf("%s isn't external: %v", name, err)
+		}
+	}
+	if format, _ := LookupFormat("tar.gz"); IsExternal(format) {
+		t.Error("tar.gz is external")
+	}
+
+	files := fstest.MapFS{"a.txt": {Data: []byte(strings.Repeat("squish ", 1000)), Mode: 0o644}}
+	for _, name := range []string{"tar.extgz", "exttar"} {
+		var archive bytes.Buffer
+		if err := Create(ctx, CreateOptions{Format: name, Files: files, Output: &archive}); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		dir := t.TempDir()
+		if err := Extract(ctx, ExtractOptions{Input: &archive, Name: "archive." + name, Dir: dir}); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+		if err != nil || !bytes.Equal(got, files["a.txt"].Data) {
+			t.Errorf("%s: a.txt wasn't extracted correctly: %v", name, err)
+		}
+	}
+
+	// The program failing is reported at the end of its output.
+	r, err := ExternalFormat{Name: "extfail", Decompress: []string{"gzip", "-dc"}}.decompressor(strings.NewReader("not gzip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); err == nil || !strings.HasPrefix(err.Error(), "gzip: exit status") {
+		t.Errorf("got error %v, want gzip's", err)
+	}
+}
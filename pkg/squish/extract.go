@@ -0,0 +1,227 @@
+package squish
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/internal/safepath"
+)
+
+// ErrUnsafePath is returned by Extract for entries that would be extracted
+// outside of the output directory, or through a symbolic link, and links that
+// point outside of it.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// extractArchive extracts the entries of the archive read from r, in format,
+// into opts.Dir, as Extract does.
+func extractArchive(ctx context.Context, format archives.Extractor, r io.Reader, opts *ExtractOptions) error {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return err
+	}
+	x := &extraction{dir: opts.Dir, opts: opts}
+	return format.Extract(ctx, r, x.extract)
+}
+
+type extraction struct {
+	dir  string
+	opts *ExtractOptions
+	// symlinks are the symbolic links extracted so far, which the targets
+	// of later ones are resolved with.
+	symlinks safepath.Links
+}
+
+func (x *extraction) extract(ctx context.Context, file archives.FileInfo) error {
+	name := path.Clean(file.NameInArchive)
+	if name == "." {
+		return nil
+	}
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) {
+		return fmt.Errorf("%s: %w", name, ErrUnsafePath)
+	}
+
+	if x.opts.Entry != nil {
+		if err := x.opts.Entry(name, file); errors.Is(err, fs.SkipDir) && !file.IsDir() {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := x.checkParents(local); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	target := filepath.Join(x.dir, local)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	result := EntryResult{Name: name, Info: file, Path: target, Action: "created"}
+	switch {
+	case file.IsDir():
+		// Directories are kept writable, so that their contents can be
+		// extracted into them.
+		if err := os.Mkdir(target, file.Mode().Perm()|0o700); errors.Is(err, fs.ErrExist) {
+			result.Action = "merged"
+		} else if err != nil {
+			return err
+		}
+
+	case file.Mode()&fs.ModeSymlink != 0:
+		linkTarget, err := LinkTarget(file)
+		if err != nil {
+			return err
+		}
+		if x.symlinks.Escapes(local, linkTarget) {
+			return fmt.Errorf("%s: link to %s: %w", name, linkTarget, ErrUnsafePath)
+		}
+		if err := os.Symlink(filepath.FromSlash(linkTarget), target); err != nil {
+			return err
+		}
+		x.symlinks.Add(local)
+
+	case file.Mode().IsRegular() && file.LinkTarget != "":
+		// Regular files with link targets are hard links to earlier
+		// entries.
+		linked := filepath.FromSlash(path.Clean(file.LinkTarget))
+		if !filepath.IsLocal(linked) {
+			return fmt.Errorf("%s: link to %s: %w", name, file.LinkTarget, ErrUnsafePath)
+		}
+		if err := x.checkParents(linked); err != nil {
+			return fmt.Errorf("%s: link to %s: %w", name, file.LinkTarget, err)
+		}
+		if err := os.Link(filepath.Join(x.dir, linked), target); err != nil {
+			return err
+		}
+
+	case file.Mode().IsRegular():
+		if err := x.writeFile(ctx, file, target, &result); err != nil {
+			return err
+		}
+
+	default:
+		result.Action = "skipped"
+		x.warn(&result, "skipped %s, which is a special file", name)
+	}
+
+	if x.opts.Extracted != nil {
+		return x.opts.Extracted(result)
+	}
+	return nil
+}
+
+// warn records a problem with the entry of result, and reports it to
+// x.opts.Warning.
+func (x *extraction) warn(result *EntryResult, format string, a ...any) {
+	message := fmt.Sprintf(format, a...)
+	result.Warnings = append(result.Warnings, message)
+	if x.opts.Warning != nil {
+		x.opts.Warning(result.Name, message)
+	}
+}
+
+// checkParents returns ErrUnsafePath if any of the parent directories of local,
+// beneath x.dir, is a symbolic link, which could lead outside of it, whether it
+// was extracted or was already there.
+func (x *extraction) checkParents(local string) error {
+	_, ok, err := x.symlinks.Through(local, func(name string) (fs.FileInfo, error) {
+		return os.Lstat(filepath.Join(x.dir, name))
+	})
+	if err != nil {
+		return err
+	} else if ok {
+		return ErrUnsafePath
+	}
+	return nil
+}
+
+// writeFile writes the contents of the regular file entry file to target,
+// removing it if that fails, and records how much was written in result, along
+// with its digest if x.opts.Extracted is called with it.
+func (x *extraction) writeFile(ctx context.Context, file archives.FileInfo, target string, result *EntryResult) error {
+	input, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	output, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, file.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	var digest hash.Hash
+	var dst io.Writer = output
+	if x.opts.Extracted != nil {
+		digest = sha256.New()
+		dst = io.MultiWriter(output, digest)
+	}
+	w := &progressWriter{ctx: ctx, w: dst, name: result.Name, size: file.Size(), progress: x.opts.Progress}
+	result.Bytes, err = io.Copy(w, input)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+	if digest != nil {
+		result.SHA256 = digest.Sum(nil)
+	}
+	return os.Chtimes(target, file.ModTime(), file.ModTime())
+}
+
+// progressWriter reports what's written to it to progress, and fails once ctx
+// is canceled.
+type progressWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	name     string
+	written  int64
+	size     int64
+	progress func(name string, written, size int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	if w.progress != nil {
+		w.progress(w.name, w.written, w.size)
+	}
+	return n, err
+}
+
+// LinkTarget returns the target of the symbolic link entry info. Formats
+// without a dedicated link target field, like zip, store the target as the
+// entry's contents.
+func LinkTarget(info archives.FileInfo) (string, error) {
+	if info.LinkTarget != "" {
+		return info.LinkTarget, nil
+	}
+	if header, ok := info.Sys().(*tar.Header); ok {
+		return header.Linkname, nil
+	}
+
+	input, err := info.Open()
+	if err != nil {
+		return "", err
+	}
+	defer input.Close()
+
+	// Targets longer than PATH_MAX couldn't be created anyway.
+	target, err := io.ReadAll(io.LimitReader(input, 4096))
+	return string(target), err
+}
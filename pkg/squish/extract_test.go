@@ -0,0 +1,218 @@
+package squish
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTar writes a tar of headers, whose regular files contain their names, to
+// a file in a temporary directory, returning its path.
+func writeTar(t *testing.T, headers []*tar.Header) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "archive.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		if header.Mode == 0 {
+			header.Mode = 0o644
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			tw.Write([]byte(header.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// extractTar extracts the tar at path into dir with opts.
+func extractTar(ctx context.Context, path, dir string, opts ExtractOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	opts.Input, opts.Name, opts.Dir = f, path, dir
+	return Extract(ctx, opts)
+}
+
+func TestExtract(t *testing.T) {
+	archive := writeTar(t, []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeReg},
+		{Name: "skipped/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "skipped/b", Typeflag: tar.TypeReg},
+		{Name: "d/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "d/c", Typeflag: tar.TypeReg},
+		{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: "c"},
+		{Name: "stop", Typeflag: tar.TypeReg},
+		{Name: "after", Typeflag: tar.TypeReg},
+	})
+	dir := filepath.Join(t.TempDir(), "out")
+
+	var entries, extracted []string
+	progress := map[string]int64{}
+	err := extractTar(context.Background(), archive, dir, ExtractOptions{
+		Entry: func(name string, info fs.FileInfo) error {
+			entries = append(entries, name)
+			if name == "skipped" {
+				return fs.SkipDir
+			}
+			return nil
+		},
+		Progress: func(name string, written, size int64) {
+			if written > size {
+				t.Errorf("%s: wrote %d of %d bytes", name, written, size)
+			}
+			progress[name] = written
+		},
+		Extracted: func(result EntryResult) error {
+			extracted = append(extracted, result.Name)
+			if result.Name == "stop" {
+				return fs.SkipAll
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a", "skipped", "d", "d/c", "d/l", "stop"}; !slices.Equal(entries, want) {
+		t.Errorf("got entries %q, want %q", entries, want)
+	}
+	if want := []string{"a", "d", "d/c", "d/l", "stop"}; !slices.Equal(extracted, want) {
+		t.Errorf("got extracted %q, want %q", extracted, want)
+	}
+	if progress["d/c"] != int64(len("d/c")) {
+		t.Errorf("got progress %v, want all of d/c", progress)
+	}
+	for name, want := range map[string]bool{"a": true, "d/l": true, "skipped": false, "after": false} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s: got %v, want it to exist: %t", name, err, want)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "d/l")); err != nil || string(data) != "d/c" {
+		t.Errorf("got %q, %v reading through d/l, want \"d/c\"", data, err)
+	}
+}
+
+func TestExtractResults(t *testing.T) {
+	archive := writeTar(t, []*tar.Header{
+		{Name: "d/a", Typeflag: tar.TypeReg},
+		{Name: "d/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "fifo", Typeflag: tar.TypeFifo},
+		{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "d/a"},
+	})
+	dir := t.TempDir()
+
+	results := map[string]EntryResult{}
+	var warnings []string
+	err := extractTar(context.Background(), archive, dir, ExtractOptions{
+		Extracted: func(result EntryResult) error {
+			results[result.Name] = result
+			return nil
+		},
+		Warning: func(name, message string) {
+			warnings = append(warnings, name+": "+message)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// d was already created for d/a, so it's merged with, and the fifo is
+	// skipped with a warning.
+	digest := sha256.Sum256([]byte("d/a"))
+	want := map[string]EntryResult{
+		"d/a":  {Path: filepath.Join(dir, "d", "a"), Action: "created", Bytes: 3, SHA256: digest[:]},
+		"d":    {Path: filepath.Join(dir, "d"), Action: "merged"},
+		"fifo": {Path: filepath.Join(dir, "fifo"), Action: "skipped", Warnings: []string{"skipped fifo, which is a special file"}},
+		"l":    {Path: filepath.Join(dir, "l"), Action: "created"},
+	}
+	for name, want := range want {
+		got, ok := results[name]
+		if !ok {
+			t.Errorf("%s: no result", name)
+			continue
+		}
+		if got.Name != name || got.Info == nil || got.Path != want.Path || got.Action != want.Action || got.Bytes != want.Bytes || !bytes.Equal(got.SHA256, want.SHA256) || !slices.Equal(got.Warnings, want.Warnings) {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+	if want := []string{"fifo: skipped fifo, which is a special file"}; !slices.Equal(warnings, want) {
+		t.Errorf("got warnings %q, want %q", warnings, want)
+	}
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	for _, headers := range [][]*tar.Header{
+		{{Name: "../a", Typeflag: tar.TypeReg}},
+		{{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "../.."}},
+		{{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "."}, {Name: "l/a", Typeflag: tar.TypeReg}},
+		{{Name: "h", Typeflag: tar.TypeLink, Linkname: "../a"}},
+		// a/a is the output directory, so a/a/../.. is its parent.
+		{{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."}, {Name: "l", Typeflag: tar.TypeSymlink, Linkname: "a/a/../.."}},
+		// Together, these point l at the parent of the output directory.
+		{{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "sub/.."}, {Name: "sub", Typeflag: tar.TypeSymlink, Linkname: "."}},
+	} {
+		err := extractTar(context.Background(), writeTar(t, headers), t.TempDir(), ExtractOptions{})
+		if !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("%s: got %v, want %v", headers[len(headers)-1].Name, err, ErrUnsafePath)
+		}
+	}
+
+	// Symbolic links that were already in the output aren't written
+	// through either.
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "out")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(dir, "l")); err != nil {
+		t.Fatal(err)
+	}
+	archive := writeTar(t, []*tar.Header{{Name: "l/escaped", Typeflag: tar.TypeReg}})
+	if err := extractTar(context.Background(), archive, dir, ExtractOptions{}); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("l/escaped: got %v, want %v", err, ErrUnsafePath)
+	}
+	if _, err := os.Lstat(filepath.Join(parent, "escaped")); err == nil {
+		t.Error("escaped was written outside of the output")
+	}
+}
+
+func TestExtractCanceled(t *testing.T) {
+	archive := writeTar(t, []*tar.Header{{Name: "a", Typeflag: tar.TypeReg}})
+	ctx, cancel := context.WithCancel(context.Background())
+	dir := t.TempDir()
+	err := extractTar(ctx, archive, dir, ExtractOptions{
+		Entry: func(string, fs.FileInfo) error {
+			cancel()
+			return nil
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v for the partially extracted file, want it removed", err)
+	}
+}
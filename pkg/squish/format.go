@@ -0,0 +1,95 @@
+package squish
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// archiveFormats are the archive formats, by their names.
+var archiveFormats = map[string]archives.Extraction{
+	"tar": archives.Tar{},
+	"zip": archives.Zip{},
+	"7z":  archives.SevenZip{},
+	"rar": archives.Rar{},
+}
+
+// compressionFormats are the compression formats, by their names, some of which
+// are aliases of others.
+var compressionFormats = map[string]archives.Compression{
+	"gz":     archives.Gz{},
+	"gzip":   archives.Gz{},
+	"bz2":    archives.Bz2{},
+	"bzip2":  archives.Bz2{},
+	"xz":     archives.Xz{},
+	"zst":    archives.Zstd{},
+	"zstd":   archives.Zstd{},
+	"lz4":    archives.Lz4{},
+	"br":     archives.Brotli{},
+	"brotli": archives.Brotli{},
+	"sz":     archives.Sz{},
+	"snappy": archives.Sz{},
+	"lz":     archives.Lzip{},
+	"lzip":   archives.Lzip{},
+	"zz":     archives.Zlib{},
+	"zlib":   archives.Zlib{},
+}
+
+// formatShorthands are the shorthands for compressed tar archives.
+var formatShorthands = map[string]string{
+	"tgz":  "tar.gz",
+	"tbz":  "tar.bz2",
+	"tbz2": "tar.bz2",
+	"txz":  "tar.xz",
+	"tzst": "tar.zst",
+}
+
+// LookupFormat returns the format named by name, which is the name or
+// extension of an archive or compression format, like zip or .gz, of an
+// archive format combined with a compression format, like tar.gz, of a zip
+// variant like jar, or a shorthand like tgz. Names are case-insensitive.
+func LookupFormat(name string) (archives.Format, error) {
+	name = strings.ToLower(strings.TrimPrefix(name, "."))
+	if expanded, ok := formatShorthands[name]; ok {
+		name = expanded
+	}
+
+	archiveName, compressionName, compressed := strings.Cut(name, ".")
+	if !compressed {
+		if variant, ok := LookupZipVariant(name); ok {
+			return variant, nil
+		}
+		if archive, ok := archiveFormats[name]; ok {
+			return archive, nil
+		}
+		if compression, ok := compressionFormats[name]; ok {
+			return compression, nil
+		}
+		return nil, fmt.Errorf("unknown format %q", name)
+	}
+
+	archive, ok := archiveFormats[archiveName]
+	if !ok {
+		return nil, fmt.Errorf("unknown archive format %q", archiveName)
+	}
+	compression, ok := compressionFormats[compressionName]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression format %q", compressionName)
+	}
+
+	archival, _ := archive.(archives.Archival)
+	return archives.CompressedArchive{Archival: archival, Extraction: archive, Compression: compression}, nil
+}
+
+// CompressionNames returns the names of the compression formats, including
+// aliases like gzip, sorted.
+func CompressionNames() []string {
+	names := make([]string, 0, len(compressionFormats))
+	for name := range compressionFormats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
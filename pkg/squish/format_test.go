@@ -0,0 +1,31 @@
+package squish
+
+import "testing"
+
+func TestLookupFormat(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"zip", ".zip"},
+		{".7z", ".7z"},
+		{"gzip", ".gz"},
+		{"ZSTD", ".zst"},
+		{"tar.xz", ".tar.xz"},
+		{"tbz", ".tar.bz2"},
+		{"tar.brotli", ".tar.br"},
+	}
+	for _, test := range tests {
+		format, err := LookupFormat(test.name)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if got := format.Extension(); got != test.want {
+			t.Errorf("%s: got format %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	for _, name := range []string{"", "tar.", "tar.zip", "gz.tar", "tgz.gz", "foo"} {
+		if _, err := LookupFormat(name); err == nil {
+			t.Errorf("%q: got no error", name)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package squish
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mholt/archives"
+)
+
+// WithLevel configures format, as returned by LookupFormat, to compress at
+// level, returning an error if it doesn't have levels, or level is out of its
+// range. Levels are 1 to 9 for gz, bz2, zz, lz4 and zip, 1 to 22 for zst, and 0
+// to 11 for br.
+func WithLevel(format archives.Format, level int) (archives.Format, error) {
+	inRange := func(lowest, highest int) error {
+		if level < lowest || level > highest {
+			return fmt.Errorf("%s levels range from %d to %d", strings.TrimPrefix(format.Extension(), "."), lowest, highest)
+		}
+		return nil
+	}
+
+	switch format := format.(type) {
+	case archives.CompressedArchive:
+		compression, err := WithLevel(format.Compression, level)
+		if err != nil {
+			return nil, err
+		}
+		format.Compression = compression.(archives.Compression)
+		return format, nil
+
+	case archives.Gz:
+		if err := inRange(1, 9); err != nil {
+			return nil, err
+		}
+		format.CompressionLevel = level
+		return format, nil
+
+	case archives.Bz2:
+		if err := inRange(1, 9); err != nil {
+			return nil, err
+		}
+		format.CompressionLevel = level
+		return format, nil
+
+	case archives.Zlib:
+		if err := inRange(1, 9); err != nil {
+			return nil, err
+		}
+		format.CompressionLevel = level
+		return format, nil
+
+	case archives.Lz4:
+		if err := inRange(1, 9); err != nil {
+			return nil, err
+		}
+		// The levels of lz4.CompressionLevel are bit flags, starting from
+		// 1<<9 for level 1.
+		format.CompressionLevel = 1 << (8 + level)
+		return format, nil
+
+	case archives.Zstd:
+		if err := inRange(1, 22); err != nil {
+			return nil, err
+		}
+		format.EncoderOptions = append(format.EncoderOptions, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		return format, nil
+
+	case archives.Brotli:
+		if err := inRange(0, 11); err != nil {
+			return nil, err
+		}
+		format.Quality = level
+		return format, nil
+
+	case archives.Zip:
+		if err := inRange(1, 9); err != nil {
+			return nil, err
+		}
+		return leveledZip{Zip: format, level: level}, nil
+
+	case encryptedZip:
+		if err := inRange(1, 9); err != nil {
+			return nil, err
+		}
+		format.level = level
+		return format, nil
+
+	case ZipVariant:
+		if err := inRange(1, 9); err != nil {
+			return nil, err
+		}
+		format.level = level
+		return format, nil
+
+	default:
+		return nil, fmt.Errorf("%s doesn't have compression levels", strings.TrimPrefix(format.Extension(), "."))
+	}
+}
+
+// leveledZip writes zips whose files are compressed with deflate at level, for
+// --level, since archives.Zip always uses the default level.
+type leveledZip struct {
+	archives.Zip
+	level int
+}
+
+func (z leveledZip) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	zw := z.newWriter(output)
+	for _, file := range files {
+		if err := z.archiveFile(ctx, zw, file); err != nil {
+			zw.Close()
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (z leveledZip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	zw := z.newWriter(output)
+	for job := range jobs {
+		job.Result <- z.archiveFile(ctx, zw, job.File)
+	}
+	return zw.Close()
+}
+
+// newWriter returns a writer of a zip to output that compresses with deflate at
+// z.level, or the default level if it's zero.
+func (z leveledZip) newWriter(output io.Writer) *zip.Writer {
+	zw := zip.NewWriter(output)
+	if z.level != 0 {
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, z.level)
+		})
+	}
+	return zw
+}
+
+func (z leveledZip) archiveFile(ctx context.Context, zw *zip.Writer, file archives.FileInfo) error {
+	return writeZipEntry(ctx, zw, file, zip.Deflate)
+}
+
+// writeZipEntry writes file to zw, compressed with method unless it's a
+// directory.
+func writeZipEntry(ctx context.Context, zw *zip.Writer, file archives.FileInfo, method uint16) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(file)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+	}
+	header.Name = file.NameInArchive
+	header.Method = method
+	// Extra fields can be given by the zip header returned by Sys, as
+	// archives.Tar does for PAX records.
+	if sys, ok := file.Sys().(*zip.FileHeader); ok {
+		header.Extra = sys.Extra
+	}
+	if file.IsDir() {
+		header.Name = strings.TrimSuffix(header.Name, "/") + "/"
+		header.Method = zip.Store
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+	}
+	if file.IsDir() {
+		return nil
+	}
+
+	input, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	if _, err := io.Copy(w, input); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.NameInArchive, err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package squish
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestWithLevel(t *testing.T) {
+	tests := []struct {
+		format string
+		level  int
+		ok     bool
+	}{
+		{"tar.gz", 1, true},
+		{"tar.gz", 10, false},
+		{"tar.zst", 19, true},
+		{"tar.zst", 23, false},
+		{"tar.br", 0, true},
+		{"tar.lz4", 9, true},
+		{"zip", 9, true},
+		{"zip", 0, false},
+		{"tar.xz", 6, false},
+		{"tar", 1, false},
+	}
+	for _, test := range tests {
+		format, err := LookupFormat(test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := WithLevel(format, test.level); (err == nil) != test.ok {
+			t.Errorf("%s at level %d: got error %v", test.format, test.level, err)
+		}
+	}
+}
+
+func TestLeveledZip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	data := strings.Repeat("squish ", 10000)
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := archives.FilesFromDisk(ctx, nil, map[string]string{dir: "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	for _, level := range []int{1, 9} {
+		format, err := WithLevel(archives.Zip{}, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var archive bytes.Buffer
+		if err := format.(archives.Archiver).Archive(ctx, &archive, files); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, archive.Len())
+
+		found := false
+		err = (archives.Zip{}).Extract(ctx, bytes.NewReader(archive.Bytes()), func(ctx context.Context, info archives.FileInfo) error {
+			if info.NameInArchive != "in/file" {
+				return nil
+			}
+			found = true
+			f, err := info.Open()
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			got, err := io.ReadAll(f)
+			if err != nil {
+				return err
+			}
+			if string(got) != data {
+				t.Errorf("level %d: in/file wasn't archived correctly", level)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Errorf("level %d: in/file wasn't archived", level)
+		}
+	}
+	if sizes[0] >= len(data) || sizes[1] > sizes[0] {
+		t.Errorf("got sizes %d at levels 1 and 9 for %d bytes", sizes, len(data))
+	}
+}
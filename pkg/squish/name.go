@@ -0,0 +1,79 @@
+package squish
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// extensionAliases are the short and uncommon extensions of formats, along
+// with the extensions they stand for.
+var extensionAliases = []struct{ alias, extension string }{
+	{".tgz", ".tar.gz"},
+	{".taz", ".tar.gz"},
+	{".tbz2", ".tar.bz2"},
+	{".tbz", ".tar.bz2"},
+	{".tb2", ".tar.bz2"},
+	{".txz", ".tar.xz"},
+	{".tzst", ".tar.zst"},
+	{".tlz4", ".tar.lz4"},
+	{".cbt", ".tar"},
+	{".cbz", ".zip"},
+	{".jar", ".zip"},
+	{".war", ".zip"},
+	{".ear", ".zip"},
+	{".apk", ".zip"},
+	{".epub", ".zip"},
+	{".cb7", ".7z"},
+	{".cbr", ".rar"},
+}
+
+// extensionlessSuffix is appended to the names of inputs without extensions
+// to give their outputs.
+const extensionlessSuffix = ".out"
+
+// OutputName derives the path of the output of extracting or decompressing the
+// input at inputName, in format, by removing the extension of its format, or
+// any extension if it doesn't have that one, or appending extensionlessSuffix
+// if it doesn't have an extension. Aliases
+// like .tgz are first expanded, so that decompressing foo.tgz gives foo.tar,
+// and extracting an archive to a directory also removes a .tar extension left
+// over, so that extracting foo.tar.gz gives foo even if it was identified as
+// some other compressed tar archive by its contents.
+func OutputName(inputName string, format archives.Format, extracting bool) string {
+	name := inputName
+	for _, alias := range extensionAliases {
+		if base, ok := cutSuffixFold(name, alias.alias); ok {
+			name = base + alias.extension
+			break
+		}
+	}
+
+	if base, ok := cutSuffixFold(name, format.Extension()); ok {
+		name = base
+	} else if ext := filepath.Ext(name); ext != "" {
+		name = strings.TrimSuffix(name, ext)
+	} else if name != "" {
+		// Inputs without extensions, which are identified by their
+		// contents, are extracted beside themselves.
+		return name + extensionlessSuffix
+	} else {
+		return ""
+	}
+	if extracting {
+		if base, ok := cutSuffixFold(name, ".tar"); ok && filepath.Base(name) != ".tar" {
+			name = base
+		}
+	}
+	return name
+}
+
+// cutSuffixFold is like strings.CutSuffix, but ignores case, as extensions
+// often differ in case, like FOO.ZIP.
+func cutSuffixFold(s, suffix string) (string, bool) {
+	if len(s) < len(suffix) || !strings.EqualFold(s[len(s)-len(suffix):], suffix) {
+		return s, false
+	}
+	return s[:len(s)-len(suffix)], true
+}
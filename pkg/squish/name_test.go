@@ -0,0 +1,37 @@
+package squish
+
+import (
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestOutputName(t *testing.T) {
+	tests := []struct {
+		input      string
+		format     archives.Format
+		extracting bool
+		want       string
+	}{
+		{"foo.tar.gz", archives.CompressedArchive{Compression: archives.Gz{}, Extraction: archives.Tar{}}, true, "foo"},
+		{"foo.tgz", archives.CompressedArchive{Compression: archives.Gz{}, Extraction: archives.Tar{}}, true, "foo"},
+		{"dir/foo.TBZ2", archives.CompressedArchive{Compression: archives.Bz2{}, Extraction: archives.Tar{}}, true, "dir/foo"},
+		{"foo.txz", archives.CompressedArchive{Compression: archives.Xz{}, Extraction: archives.Tar{}}, true, "foo"},
+		{"foo.tzst", archives.CompressedArchive{Compression: archives.Zstd{}, Extraction: archives.Tar{}}, true, "foo"},
+		{"foo.tgz", archives.Gz{}, false, "foo.tar"},
+		{"foo.tar.gz", archives.Gz{}, false, "foo.tar"},
+		// Identified by its contents as another compressed tar archive.
+		{"foo.tar.gz", archives.CompressedArchive{Compression: archives.Xz{}, Extraction: archives.Tar{}}, true, "foo"},
+		{"comic.cbz", archives.Zip{}, true, "comic"},
+		{"app.jar", archives.Zip{}, true, "app"},
+		{"foo.v2.zip", archives.Zip{}, true, "foo.v2"},
+		{".tar.gz", archives.CompressedArchive{Compression: archives.Gz{}, Extraction: archives.Tar{}}, true, ""},
+		{"foo", archives.Zip{}, true, "foo.out"},
+		{"foo", archives.Gz{}, false, "foo.out"},
+	}
+	for _, test := range tests {
+		if got := OutputName(test.input, test.format, test.extracting); got != test.want {
+			t.Errorf("got output %q for %s, want %q", got, test.input, test.want)
+		}
+	}
+}
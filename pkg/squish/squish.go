@@ -0,0 +1,291 @@
+// Package squish creates and extracts archives and compressed files in the
+// formats the squish command supports, for Go programs that embed it rather
+// than running it. Archives are created from an fs.FS and written to an
+// io.Writer, and extracted from an io.Reader into a directory, with callbacks
+// for progress, choosing what to archive or extract, the result of extracting
+// each entry, and warnings about what's skipped. Entries are extracted
+// beneath the directory they're extracted into, refusing those that would be
+// written outside of it, as the command does. Archive, Compress and Decompress
+// are what Create, Extract and the command itself archive, compress and
+// decompress with, for programs that configure formats themselves.
+package squish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// ErrReadOnlyFormat is returned by Create for formats that can only be
+// extracted, like 7z and rar.
+var ErrReadOnlyFormat = errors.New("format can only be extracted")
+
+// CreateOptions configures Create.
+type CreateOptions struct {
+	// Format names the format to create, as accepted by LookupFormat, like
+	// tar.zst or zip.
+	Format string
+	// Level is the level to compress at, as accepted by WithLevel, or nil
+	// for the format's default level.
+	Level *int
+	// Password, if it's not empty, is what the files of zips are encrypted
+	// with, as WithPassword does.
+	Password string
+	// Files are the files to archive, named in the archive by their paths
+	// in it, when Format is an archive format. Regular files and
+	// directories are archived, and other files, like symbolic links, are
+	// skipped.
+	Files fs.FS
+	// Input is read and compressed when Format is a compression format,
+	// like gz, rather than an archive format.
+	Input io.Reader
+	// Output is where the archive or compressed file is written.
+	Output io.Writer
+	// Entry is called with the name and info of each of Files before it's
+	// archived. Returning fs.SkipDir skips it, along with the contents of
+	// directories, and returning fs.SkipAll archives only the files before
+	// it. Any other error stops Create, and is returned by it.
+	Entry func(name string, info fs.FileInfo) error
+	// Progress is called as the contents of each of Files, or Input, are
+	// read, with its name, or "" for Input, the number of bytes read so far,
+	// and its size, or -1 for Input.
+	Progress func(name string, read, size int64)
+	// Warning is called with the name and a description of each of Files
+	// that's skipped because it can't be archived, like symbolic links.
+	Warning func(name, message string)
+}
+
+// Create writes an archive of opts.Files, or opts.Input compressed, to
+// opts.Output in opts.Format. It stops once ctx is canceled, leaving what was
+// written to opts.Output incomplete.
+func Create(ctx context.Context, opts CreateOptions) error {
+	format, err := LookupFormat(opts.Format)
+	if err != nil {
+		return err
+	}
+	if opts.Password != "" {
+		if format, err = WithPassword(format, opts.Password); err != nil {
+			return err
+		}
+	}
+	if opts.Level != nil {
+		if format, err = WithLevel(format, *opts.Level); err != nil {
+			return err
+		}
+	}
+
+	name := strings.TrimPrefix(format.Extension(), ".")
+	switch format := format.(type) {
+	case archives.Archiver:
+		if opts.Files == nil {
+			return fmt.Errorf("files must be given to create %s archives", name)
+		}
+		files, err := filesFromFS(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if variant, ok := format.(ZipVariant); ok {
+			files = variant.Order(files)
+		}
+		return Archive(ctx, format, opts.Output, files, nil)
+
+	case archives.Compressor:
+		if opts.Input == nil {
+			return fmt.Errorf("an input must be given to create %s files", name)
+		}
+		_, err := Compress(ctx, format, opts.Output, &progressReader{ctx: ctx, r: opts.Input, size: -1, progress: opts.Progress})
+		return err
+
+	default:
+		return fmt.Errorf("%s: %w", name, ErrReadOnlyFormat)
+	}
+}
+
+// filesFromFS returns the regular files and directories of opts.Files, as
+// chosen by opts.Entry, to be archived.
+func filesFromFS(ctx context.Context, opts CreateOptions) ([]archives.FileInfo, error) {
+	var files []archives.FileInfo
+	err := fs.WalkDir(opts.Files, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			if opts.Warning != nil {
+				opts.Warning(name, fmt.Sprintf("skipped %s, which isn't a regular file or directory", name))
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if opts.Entry != nil {
+			if err := opts.Entry(name, info); errors.Is(err, fs.SkipDir) && !entry.IsDir() {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		file := archives.FileInfo{FileInfo: info, NameInArchive: name}
+		if !entry.IsDir() {
+			file.Open = func() (fs.File, error) {
+				f, err := opts.Files.Open(name)
+				if err != nil {
+					return nil, err
+				}
+				return progressFile{f, &progressReader{ctx: ctx, r: f, name: name, size: info.Size(), progress: opts.Progress}}, nil
+			}
+		}
+		files = append(files, file)
+		return nil
+	})
+	return files, err
+}
+
+// ExtractOptions configures Extract. Its callbacks, any of which may be nil,
+// are called one entry at a time, from the goroutine that called Extract, so
+// that interactive programs can show what's happening and decide what to
+// extract as it goes.
+type ExtractOptions struct {
+	// Input is the archive or compressed file to extract. Formats that need
+	// random access, like zip, can only be read from an Input that's an
+	// io.ReaderAt and an io.Seeker, like an *os.File.
+	Input io.Reader
+	// Name is the name of Input, like data.tar.gz, which its format is
+	// identified by along with its contents. It may be empty.
+	Name string
+	// Password is what the encrypted entries of zips, 7z and rar archives
+	// are decrypted with, as WithPassword does.
+	Password string
+	// Dir is the directory that the entries of archives are extracted into,
+	// which is created if it doesn't exist.
+	Dir string
+	// Output is where the decompressed contents of compressed files that
+	// aren't archives are written.
+	Output io.Writer
+	// Entry is called with the name and info of each entry of archives
+	// before it's extracted. Returning fs.SkipDir skips the entry, along
+	// with the contents of directories, and returning fs.SkipAll stops
+	// extracting, keeping the entries extracted so far. Any other error
+	// stops Extract, and is returned by it.
+	Entry func(name string, info fs.FileInfo) error
+	// Progress is called as the contents of each regular file are written,
+	// with the entry's name, the number of bytes written so far, and its
+	// size. It's also called for the contents of compressed files, which
+	// are named as OutputName names the output of Name, and have a size of
+	// -1.
+	Progress func(name string, written, size int64)
+	// Extracted is called with the result of extracting each entry of
+	// archives once it's in place, or once it's skipped because it can't be
+	// extracted. Returning fs.SkipAll stops extracting after it, and any
+	// other error stops Extract, and is returned by it.
+	Extracted func(result EntryResult) error
+	// Warning is called with the name and a description of each problem
+	// with an entry that doesn't stop Extract, like an entry that's skipped
+	// because it can't be extracted, as it's found.
+	Warning func(name, message string)
+}
+
+// EntryResult is the result of extracting an entry of an archive, as passed to
+// ExtractOptions.Extracted.
+type EntryResult struct {
+	// Name is the entry's name in the archive, cleaned, and Info its info.
+	Name string
+	Info fs.FileInfo
+	// Path is where it was extracted to, beneath ExtractOptions.Dir.
+	Path string
+	// Action is what was done with it: created, merged with a directory
+	// that already existed, or skipped.
+	Action string
+	// Bytes is how many bytes of its contents were written, and SHA256 is
+	// their SHA-256 digest, for regular files. SHA256 is nil for other
+	// entries.
+	Bytes  int64
+	SHA256 []byte
+	// Warnings are the problems with it that didn't stop Extract, which
+	// were also passed to ExtractOptions.Warning.
+	Warnings []string
+}
+
+// Extract extracts the entries of the archive opts.Input into opts.Dir, or if
+// it's a compressed file, writes its decompressed contents to opts.Output.
+// Regular files, directories, and symbolic and hard links are extracted, and
+// other entries, like devices, are skipped with a warning. Files that already exist aren't
+// replaced, and entries that would be written outside of opts.Dir, or through
+// a symbolic link, and links that point outside of it, fail with
+// ErrUnsafePath. It stops once ctx is canceled, even partway through a file,
+// which is removed.
+func Extract(ctx context.Context, opts ExtractOptions) error {
+	format, input, err := archives.Identify(ctx, opts.Name, opts.Input)
+	if err != nil {
+		return fmt.Errorf("failed to identify format: %w", err)
+	}
+
+	if decrypting, err := WithPassword(format, opts.Password); err == nil {
+		format = decrypting
+	}
+
+	// Compressed files' contents are named as their output would be.
+	name := OutputName(opts.Name, format, false)
+	switch format := format.(type) {
+	case archives.Extractor:
+		if opts.Dir == "" {
+			return errors.New("a directory must be given to extract archives into")
+		}
+		return extractArchive(ctx, format, input, &opts)
+
+	case archives.Decompressor:
+		if opts.Output == nil {
+			return errors.New("an output must be given to decompress compressed files to")
+		}
+		_, err = Decompress(ctx, format, &progressWriter{ctx: ctx, w: opts.Output, name: name, size: -1, progress: opts.Progress}, input)
+		return err
+
+	default:
+		return fmt.Errorf("%s can't be extracted", format.Extension())
+	}
+}
+
+// progressReader reports what's read from it to progress, and fails once ctx
+// is canceled.
+type progressReader struct {
+	ctx      context.Context
+	r        io.Reader
+	name     string
+	read     int64
+	size     int64
+	progress func(name string, read, size int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.progress != nil && n > 0 {
+		r.progress(r.name, r.read, r.size)
+	}
+	return n, err
+}
+
+type progressFile struct {
+	fs.File
+	r *progressReader
+}
+
+func (f progressFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
@@ -0,0 +1,134 @@
+package squish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCreateExtract(t *testing.T) {
+	ctx := context.Background()
+	files := fstest.MapFS{
+		"docs/a.txt":     {Data: []byte(strings.Repeat("squish ", 1000)), Mode: 0o644},
+		"docs/tmp/b.txt": {Data: []byte("b"), Mode: 0o644},
+		"c.txt":          {Data: []byte("c"), Mode: 0o600},
+	}
+
+	for _, format := range []string{"tar.zst", "zip", "tgz"} {
+		level := 1
+		var archive bytes.Buffer
+		read := map[string]int64{}
+		err := Create(ctx, CreateOptions{
+			Format: format,
+			Level:  &level,
+			Files:  files,
+			Output: &archive,
+			Entry: func(name string, info fs.FileInfo) error {
+				if name == "docs/tmp" {
+					return fs.SkipDir
+				}
+				return nil
+			},
+			Progress: func(name string, n, size int64) { read[name] = n },
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if read["docs/a.txt"] != 7000 {
+			t.Errorf("%s: got progress %v, want 7000 bytes of docs/a.txt", format, read)
+		}
+
+		// zips can only be extracted from inputs that can be seeked.
+		dir := t.TempDir()
+		var extracted []string
+		opts := ExtractOptions{Input: bytes.NewReader(archive.Bytes()), Name: "archive." + format, Dir: dir}
+		opts.Extracted = func(result EntryResult) error {
+			extracted = append(extracted, result.Name)
+			return nil
+		}
+		if err := Extract(ctx, opts); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, "docs", "a.txt"))
+		if err != nil || !bytes.Equal(got, files["docs/a.txt"].Data) {
+			t.Errorf("%s: docs/a.txt wasn't extracted correctly: %v", format, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "docs", "tmp")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: docs/tmp was archived, despite being skipped", format)
+		}
+		if len(extracted) != 3 {
+			t.Errorf("%s: got extracted entries %q, want c.txt, docs and docs/a.txt", format, extracted)
+		}
+	}
+}
+
+func TestCreateExtractCompressed(t *testing.T) {
+	ctx := context.Background()
+	data := strings.Repeat("squish ", 1000)
+	var compressed bytes.Buffer
+	if err := Create(ctx, CreateOptions{Format: "xz", Input: strings.NewReader(data), Output: &compressed}); err != nil {
+		t.Fatal(err)
+	}
+
+	var decompressed bytes.Buffer
+	var progressName string
+	opts := ExtractOptions{Input: &compressed, Name: "data.txt.xz", Output: &decompressed}
+	opts.Progress = func(name string, written, size int64) { progressName = name }
+	if err := Extract(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if decompressed.String() != data {
+		t.Error("data wasn't decompressed correctly")
+	}
+	if progressName != "data.txt" {
+		t.Errorf("got progress for %q, want data.txt", progressName)
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	ctx := context.Background()
+	level := 30
+	for _, opts := range []CreateOptions{
+		{Format: "7z", Files: fstest.MapFS{}},
+		{Format: "tar.gz", Level: &level, Files: fstest.MapFS{}},
+		{Format: "gz", Files: fstest.MapFS{}},
+		{Format: "nope", Files: fstest.MapFS{}},
+	} {
+		opts.Output = &bytes.Buffer{}
+		if err := Create(ctx, opts); err == nil {
+			t.Errorf("%s: got no error", opts.Format)
+		}
+	}
+	if err := Create(ctx, CreateOptions{Format: "rar", Files: fstest.MapFS{}, Output: &bytes.Buffer{}}); !errors.Is(err, ErrReadOnlyFormat) {
+		t.Errorf("rar: got error %v, want %v", err, ErrReadOnlyFormat)
+	}
+}
+
+func TestCreateWarnings(t *testing.T) {
+	files := fstest.MapFS{
+		"a.txt": {Data: []byte("a"), Mode: 0o644},
+		"link":  {Data: []byte("a.txt"), Mode: fs.ModeSymlink | 0o777},
+	}
+	var warnings []string
+	err := Create(context.Background(), CreateOptions{
+		Format: "tar",
+		Files:  files,
+		Output: &bytes.Buffer{},
+		Warning: func(name, message string) {
+			warnings = append(warnings, name+": "+message)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"link: skipped link, which isn't a regular file or directory"}; !slices.Equal(warnings, want) {
+		t.Errorf("got warnings %q, want %q", warnings, want)
+	}
+}
@@ -0,0 +1,658 @@
+package squish
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Constants of WinZip's AES encryption for zips, which is described at
+// https://www.winzip.com/en/support/aes-encryption/.
+const (
+	// zipMethodAES is the method of entries encrypted with AES, whose
+	// actual compression method is stored in their zipExtraAES field.
+	zipMethodAES  = 99
+	zipExtraAES   = 0x9901
+	zipVersionAES = 51
+	// zipAESVersion is the version of the format that's written, AE-2,
+	// which leaves out the CRC-32 of the plaintext, since it would reveal
+	// something about it, and the authentication code covers it anyway.
+	zipAESVersion = 2
+	// zipAESStrength is the key size that's written, AES-256.
+	zipAESStrength     = 3
+	zipAESIterations   = 1000
+	zipAESVerifierSize = 2
+	zipAESMACSize      = 10
+)
+
+const (
+	zipFlagEncrypted      = 0x1
+	zipFlagDataDescriptor = 0x8
+	zipFlagUTF8           = 0x800
+
+	// zipExtraTimestamp is the extended timestamp extra field, which stores
+	// modification times in UTC, unlike the MS-DOS fields of the header.
+	zipExtraTimestamp = 0x5455
+
+	// zipCryptoHeaderSize is the size of the header that traditional PKWARE
+	// encryption adds before the data of each entry.
+	zipCryptoHeaderSize = 12
+)
+
+var (
+	// ErrIncorrectPassword is returned when encrypted entries are read with
+	// the wrong password.
+	ErrIncorrectPassword = errors.New("incorrect password")
+	// ErrPasswordRequired is returned when encrypted entries are read
+	// without a password.
+	ErrPasswordRequired = errors.New("entry is encrypted, but no password was given")
+	// ErrAuthentication is returned when the contents of entries encrypted
+	// with AES don't match their authentication codes.
+	ErrAuthentication = errors.New("authentication code doesn't match, so the entry is corrupt or was modified")
+)
+
+// WithPassword configures format, as returned by LookupFormat, to encrypt and
+// decrypt the contents of entries with password. Zips are created with their
+// files encrypted with AES-256, as WinZip and 7-Zip do, and the entries of
+// zips encrypted with AES or with the traditional PKWARE encryption, and of 7z
+// and rar archives, are decrypted when they're extracted. It returns an error
+// for other formats.
+func WithPassword(format archives.Format, password string) (archives.Format, error) {
+	switch format := format.(type) {
+	case archives.Zip:
+		return encryptedZip{Zip: format, password: password}, nil
+	case archives.SevenZip:
+		format.Password = password
+		return format, nil
+	case archives.Rar:
+		format.Password = password
+		return format, nil
+	default:
+		return nil, fmt.Errorf("%s archives can't be encrypted", strings.TrimPrefix(format.Extension(), "."))
+	}
+}
+
+// encryptedZip reads and writes zips whose files are encrypted using password.
+// The names and metadata of entries are stored in the clear, as the format
+// requires, and directories have no contents to encrypt.
+type encryptedZip struct {
+	archives.Zip
+	password string
+	// level is the level that files are compressed at with deflate, or
+	// zero for the default level.
+	level int
+}
+
+func (z encryptedZip) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	zw := zip.NewWriter(output)
+	for _, file := range files {
+		if err := z.archiveFile(ctx, zw, file); err != nil {
+			zw.Close()
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (z encryptedZip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	zw := zip.NewWriter(output)
+	for job := range jobs {
+		job.Result <- z.archiveFile(ctx, zw, job.File)
+	}
+	return zw.Close()
+}
+
+func (z encryptedZip) archiveFile(ctx context.Context, zw *zip.Writer, file archives.FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(file)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+	}
+	header.Name = file.NameInArchive
+	// Extra fields can be given by the zip header returned by Sys, as
+	// writeZipEntry allows.
+	if sys, ok := file.Sys().(*zip.FileHeader); ok {
+		header.Extra = sys.Extra
+	}
+	if file.IsDir() {
+		header.Name = strings.TrimSuffix(header.Name, "/") + "/"
+	}
+	// Symbolic links are stored with their targets as their contents, which
+	// aren't encrypted, since some tools, like libarchive, can't decrypt
+	// them.
+	if file.IsDir() || file.Mode()&fs.ModeSymlink != 0 {
+		header.Method = zip.Store
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+		}
+		if _, err := io.WriteString(w, file.LinkTarget); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.NameInArchive, err)
+		}
+		return nil
+	}
+	if z.Compression != zip.Store && z.Compression != zip.Deflate {
+		return fmt.Errorf("compression method %d can't be used with encryption", z.Compression)
+	}
+
+	header.ModifiedDate, header.ModifiedTime = msdosTime(header.Modified)
+	header.Extra = binary.LittleEndian.AppendUint16(header.Extra, zipExtraTimestamp)
+	header.Extra = binary.LittleEndian.AppendUint16(header.Extra, 5)
+	header.Extra = append(header.Extra, 1)
+	header.Extra = binary.LittleEndian.AppendUint32(header.Extra, uint32(header.Modified.Unix()))
+
+	input, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	return writeZipAESEntry(zw, header, z.Compression, z.level, z.password, input)
+}
+
+// writeZipAESEntry writes an entry with header to zw, whose contents are read
+// from input, compressed with method, which must be zip.Store or zip.Deflate, at
+// level, or the default level if it's zero, and encrypted with AES-256 using
+// password.
+func writeZipAESEntry(zw *zip.Writer, header *zip.FileHeader, method uint16, level int, password string, input io.Reader) error {
+	// The sizes aren't known until the data has been written, so they're
+	// stored in a data descriptor after it, which the writer writes using
+	// the header as it is when the next entry is created.
+	header.Method = zipMethodAES
+	header.Flags |= zipFlagEncrypted | zipFlagDataDescriptor
+	if utf8.ValidString(header.Name) && strings.ContainsFunc(header.Name, func(r rune) bool { return r >= utf8.RuneSelf }) {
+		header.Flags |= zipFlagUTF8
+	}
+	header.CreatorVersion = header.CreatorVersion&0xff00 | zipVersionAES
+	header.ReaderVersion = zipVersionAES
+	header.Extra = binary.LittleEndian.AppendUint16(header.Extra, zipExtraAES)
+	header.Extra = binary.LittleEndian.AppendUint16(header.Extra, 7)
+	header.Extra = binary.LittleEndian.AppendUint16(header.Extra, zipAESVersion)
+	header.Extra = append(header.Extra, 'A', 'E', zipAESStrength)
+	header.Extra = binary.LittleEndian.AppendUint16(header.Extra, method)
+	header.CRC32 = 0
+
+	raw, err := zw.CreateRaw(header)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", header.Name, err)
+	}
+	encrypted, err := newZipAESWriter(raw, password)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", header.Name, err)
+	}
+	var w io.Writer = encrypted
+	var deflater *flate.Writer
+	if method == zip.Deflate {
+		if deflater, err = flate.NewWriter(encrypted, cmp.Or(level, flate.DefaultCompression)); err != nil {
+			return err
+		}
+		w = deflater
+	}
+
+	size, err := io.Copy(w, input)
+	if err == nil && deflater != nil {
+		err = deflater.Close()
+	}
+	if err == nil {
+		err = encrypted.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", header.Name, err)
+	}
+
+	header.UncompressedSize64 = uint64(size)
+	header.CompressedSize64 = uint64(encrypted.written)
+	header.UncompressedSize = uint32(min(header.UncompressedSize64, 0xffffffff))
+	header.CompressedSize = uint32(min(header.CompressedSize64, 0xffffffff))
+	return nil
+}
+
+// zipAESKeys derives the AES key, the HMAC-SHA1 key, and the password verifier
+// of an entry from password and its salt, whose size is half the key size.
+func zipAESKeys(password string, salt []byte) (encryption, authentication, verifier []byte) {
+	keySize := 2 * len(salt)
+	key := pbkdf2.Key([]byte(password), salt, zipAESIterations, 2*keySize+zipAESVerifierSize, sha1.New)
+	return key[:keySize], key[keySize : 2*keySize], key[2*keySize:]
+}
+
+// zipAESCTR is AES in counter mode as WinZip uses it, with a little-endian
+// counter that starts at 1, unlike the big-endian one of cipher.NewCTR.
+type zipAESCTR struct {
+	block              cipher.Block
+	counter, keystream [aes.BlockSize]byte
+	// used is the number of bytes of keystream that have been used.
+	used int
+}
+
+func newZipAESCTR(key []byte) (*zipAESCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &zipAESCTR{block: block, used: aes.BlockSize}, nil
+}
+
+func (c *zipAESCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.keystream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.keystream[c.used]
+		c.used++
+	}
+}
+
+// zipAESWriter encrypts the data of an entry, after writing the salt and
+// password verifier that precede it. Closing it writes the authentication
+// code that follows the data, without closing the underlying writer.
+type zipAESWriter struct {
+	w   io.Writer
+	ctr *zipAESCTR
+	mac hash.Hash
+	buf []byte
+	// written is the number of bytes written to w.
+	written int64
+}
+
+func newZipAESWriter(w io.Writer, password string) (*zipAESWriter, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	encryption, authentication, verifier := zipAESKeys(password, salt)
+	ctr, err := newZipAESCTR(encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	aw := &zipAESWriter{w: w, ctr: ctr, mac: hmac.New(sha1.New, authentication)}
+	if err := aw.write(append(salt, verifier...)); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+func (w *zipAESWriter) Write(p []byte) (int, error) {
+	w.buf = slices.Grow(w.buf[:0], len(p))[:len(p)]
+	w.ctr.XORKeyStream(w.buf, p)
+	w.mac.Write(w.buf)
+	if err := w.write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *zipAESWriter) Close() error {
+	return w.write(w.mac.Sum(nil)[:zipAESMACSize])
+}
+
+func (w *zipAESWriter) write(p []byte) error {
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	return err
+}
+
+// zipAESReader decrypts the size bytes of data of an entry read from r, which
+// starts after the salt and password verifier, and checks the authentication
+// code that follows the data once it's all been read.
+type zipAESReader struct {
+	r    io.Reader
+	size int64
+	ctr  *zipAESCTR
+	mac  hash.Hash
+	done bool
+}
+
+func (r *zipAESReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	if r.size == 0 {
+		code := make([]byte, zipAESMACSize)
+		if _, err := io.ReadFull(r.r, code); err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		if !hmac.Equal(code, r.mac.Sum(nil)[:zipAESMACSize]) {
+			return 0, ErrAuthentication
+		}
+		r.done = true
+		return 0, io.EOF
+	}
+
+	n, err := r.r.Read(p[:min(int64(len(p)), r.size)])
+	r.mac.Write(p[:n])
+	r.ctr.XORKeyStream(p[:n], p[:n])
+	r.size -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// zipCryptoKeys are the keys of the traditional PKWARE encryption, which is
+// easily broken, so it's only ever decrypted, since other tools still write
+// it by default.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	keys := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for _, b := range []byte(password) {
+		keys.update(b)
+	}
+	return keys
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ k[0]>>8
+	k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ k[2]>>8
+}
+
+func (k *zipCryptoKeys) decrypt(p []byte) {
+	for i, b := range p {
+		temp := k[2]&0xffff | 2
+		p[i] = b ^ byte(temp*(temp^1)>>8)
+		k.update(p[i])
+	}
+}
+
+// zipCryptoReader decrypts data encrypted with the traditional PKWARE
+// encryption read from r.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+func (r *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.keys.decrypt(p[:n])
+	return n, err
+}
+
+// checksumReader fails with zip.ErrChecksum once r is fully read if what was
+// read doesn't have the CRC-32 want.
+type checksumReader struct {
+	io.ReadCloser
+	crc  hash.Hash32
+	want uint32
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.crc.Write(p[:n])
+	if err == io.EOF && r.crc.Sum32() != r.want {
+		err = zip.ErrChecksum
+	}
+	return n, err
+}
+
+// openEncryptedZipEntry opens the encrypted zip entry f, decrypting it with
+// password. Entries encrypted with AES and with the traditional PKWARE
+// encryption can be decrypted, as long as they're stored or compressed with
+// deflate, which are the only methods other tools use for them.
+func openEncryptedZipEntry(f *zip.File, password string) (io.ReadCloser, error) {
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	method := f.Method
+	checkCRC := true
+	if f.Method == zipMethodAES {
+		var version uint16
+		var strength byte
+		if version, strength, method, err = zipAESExtra(f.Extra); err != nil {
+			return nil, err
+		}
+		// AE-1 also stores the CRC-32 of the plaintext.
+		checkCRC = version == 1
+
+		saltSize := 4 + 4*int(strength)
+		header := make([]byte, saltSize+zipAESVerifierSize)
+		if _, err := io.ReadFull(raw, header); err != nil {
+			return nil, err
+		}
+		encryption, authentication, verifier := zipAESKeys(password, header[:saltSize])
+		if !bytes.Equal(verifier, header[saltSize:]) {
+			return nil, ErrIncorrectPassword
+		}
+		ctr, err := newZipAESCTR(encryption)
+		if err != nil {
+			return nil, err
+		}
+
+		size := int64(f.CompressedSize64) - int64(len(header)) - zipAESMACSize
+		if size < 0 {
+			return nil, zip.ErrFormat
+		}
+		r = &zipAESReader{r: raw, size: size, ctr: ctr, mac: hmac.New(sha1.New, authentication)}
+	} else {
+		keys := newZipCryptoKeys(password)
+		header := make([]byte, zipCryptoHeaderSize)
+		if _, err := io.ReadFull(raw, header); err != nil {
+			return nil, err
+		}
+		keys.decrypt(header)
+
+		// The last byte of the header is checked against the high byte of
+		// the CRC-32, or of the MS-DOS time when it's stored in a data
+		// descriptor, as Info-ZIP does.
+		check := byte(f.CRC32 >> 24)
+		if f.Flags&zipFlagDataDescriptor != 0 {
+			check = byte(f.ModifiedTime >> 8)
+		}
+		if header[zipCryptoHeaderSize-1] != check {
+			return nil, ErrIncorrectPassword
+		}
+		r = &zipCryptoReader{r: raw, keys: keys}
+	}
+
+	var rc io.ReadCloser
+	switch method {
+	case zip.Store:
+		rc = io.NopCloser(r)
+	case zip.Deflate:
+		rc = flate.NewReader(r)
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+	if checkCRC {
+		rc = &checksumReader{ReadCloser: rc, crc: crc32.NewIEEE(), want: f.CRC32}
+	}
+	return rc, nil
+}
+
+// zipAESExtra returns the AES version, key strength, and actual compression
+// method stored in the AES extra field in extra.
+func zipAESExtra(extra []byte) (version uint16, strength byte, method uint16, err error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != zipExtraAES {
+			continue
+		}
+
+		if size < 7 || field[2] != 'A' || field[3] != 'E' || field[4] < 1 || field[4] > 3 {
+			return 0, 0, 0, zip.ErrFormat
+		}
+		return binary.LittleEndian.Uint16(field), field[4], binary.LittleEndian.Uint16(field[5:]), nil
+	}
+	return 0, 0, 0, zip.ErrFormat
+}
+
+// zipEntryFile is an opened zip entry, as returned by archives.FileInfo.Open.
+type zipEntryFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+func (f zipEntryFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// Extract decrypts the encrypted entries of the zip when they're opened.
+// archives.Zip can't open their raw data, so the zip is read again to find it
+// the first time an encrypted entry is handled.
+func (z encryptedZip) Extract(ctx context.Context, input io.Reader, handle archives.FileHandler) error {
+	inputAt, ok := input.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		// archives.Zip reports that zips can only be read from inputs
+		// that can be seeked.
+		return z.Zip.Extract(ctx, input, handle)
+	}
+	start, err := inputAt.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	size, err := inputAt.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := inputAt.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	var files map[string][]*zip.File
+	// seen is the number of entries with each name that have been handled,
+	// so that entries with the same name can be told apart.
+	seen := map[string]int{}
+
+	return z.Zip.Extract(ctx, input, func(ctx context.Context, info archives.FileInfo) error {
+		seen[info.NameInArchive]++
+		header, ok := info.Header.(zip.FileHeader)
+		if !ok || header.Flags&zipFlagEncrypted == 0 {
+			return handle(ctx, info)
+		}
+
+		if files == nil {
+			zr, err := zip.NewReader(inputAt, size)
+			if err != nil {
+				return err
+			}
+			files = map[string][]*zip.File{}
+			// Entries are handled with their names decoded as
+			// archives.Zip decodes them.
+			for _, f := range zr.File {
+				name := f.Name
+				if f.NonUTF8 && z.TextEncoding != nil {
+					if decoded, err := z.TextEncoding.NewDecoder().String(name); err == nil {
+						name = decoded
+					}
+				}
+				files[name] = append(files[name], f)
+			}
+		}
+		named := files[info.NameInArchive]
+		if len(named) < seen[info.NameInArchive] {
+			return zip.ErrFormat
+		}
+		f := named[seen[info.NameInArchive]-1]
+
+		entryInfo := info.FileInfo
+		info.Open = func() (fs.File, error) {
+			rc, err := openEncryptedZipEntry(f, z.password)
+			if err != nil {
+				return nil, err
+			}
+			return zipEntryFile{rc, entryInfo}, nil
+		}
+		return handle(ctx, info)
+	})
+}
+
+// ZipMethod returns the compression method of the contents of the zip entry
+// with header, which for entries encrypted with AES is stored in their extra
+// field, and whether the entry is encrypted. If that field can't be read, the
+// method in header is returned along with the error.
+func ZipMethod(header zip.FileHeader) (method uint16, encrypted bool, err error) {
+	method, encrypted = header.Method, header.Flags&zipFlagEncrypted != 0
+	if encrypted && method == zipMethodAES {
+		_, _, aesMethod, err := zipAESExtra(header.Extra)
+		if err != nil {
+			return method, encrypted, err
+		}
+		method = aesMethod
+	}
+	return method, encrypted, nil
+}
+
+// ReencryptZipEntry writes the encrypted zip entry f to zw, decrypted with
+// oldPassword and encrypted with AES-256 using newPassword, compressed with the
+// same method as before, or with deflate if that isn't stored or deflated.
+func ReencryptZipEntry(zw *zip.Writer, f *zip.File, oldPassword, newPassword string) error {
+	method, _, err := ZipMethod(f.FileHeader)
+	if err != nil {
+		return err
+	}
+	if method != zip.Store {
+		method = zip.Deflate
+	}
+	decrypted, err := openEncryptedZipEntry(f, oldPassword)
+	if err != nil {
+		return err
+	}
+	defer decrypted.Close()
+
+	header := f.FileHeader
+	header.Flags &^= zipFlagEncrypted | zipFlagDataDescriptor
+	header.Extra = withoutZipExtra(header.Extra, zipExtraAES)
+	return writeZipAESEntry(zw, &header, method, 0, newPassword, decrypted)
+}
+
+// withoutZipExtra returns the fields of the extra data of a zip entry, extra,
+// other than those with id.
+func withoutZipExtra(extra []byte, id uint16) []byte {
+	var kept []byte
+	for len(extra) >= 4 {
+		size := 4 + int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < size {
+			break
+		}
+		if binary.LittleEndian.Uint16(extra) != id {
+			kept = append(kept, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+	return kept
+}
@@ -0,0 +1,169 @@
+package squish
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+// extractEncryptedZip extracts archive with password, returning the extracted
+// tree, with directories as "/", symbolic links as "-> " and their targets,
+// and regular files as their contents.
+func extractEncryptedZip(t *testing.T, archive []byte, password string) (map[string]string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	opts := ExtractOptions{Input: bytes.NewReader(archive), Name: "archive.zip", Password: password, Dir: dir}
+	if err := Extract(context.Background(), opts); err != nil {
+		return nil, err
+	}
+
+	tree := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		name, _ := filepath.Rel(dir, path)
+		switch {
+		case entry.IsDir():
+			tree[filepath.ToSlash(name)] = "/"
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			tree[filepath.ToSlash(name)] = "-> " + target
+		default:
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			tree[filepath.ToSlash(name)] = string(contents)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree, nil
+}
+
+func TestEncryptedZip(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "in", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{"in/a": "a", "in/sub/b": string(bytes.Repeat([]byte("b"), 100000)), "in/empty": ""} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a", filepath.Join(dir, "in", "l")); err != nil {
+		t.Fatal(err)
+	}
+	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{filepath.Join(dir, "in"): "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"in": "/", "in/a": "a", "in/sub": "/", "in/sub/b": string(bytes.Repeat([]byte("b"), 100000)), "in/empty": "", "in/l": "-> a"}
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		format, err := WithPassword(archives.Zip{Compression: method}, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := Archive(context.Background(), format.(archives.Archiver), &buf, files, nil); err != nil {
+			t.Fatal(err)
+		}
+		archive := buf.Bytes()
+
+		got, err := extractEncryptedZip(t, archive, "secret")
+		if err != nil {
+			t.Fatalf("method %d: %s", method, err)
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("method %d: got tree %v, want %v", method, got, want)
+		}
+
+		if _, err := extractEncryptedZip(t, archive, "wrong"); !errors.Is(err, ErrIncorrectPassword) {
+			t.Errorf("method %d: got error %v with the wrong password, want %v", method, err, ErrIncorrectPassword)
+		}
+		if _, err := extractEncryptedZip(t, archive, ""); !errors.Is(err, ErrPasswordRequired) {
+			t.Errorf("method %d: got error %v without a password, want %v", method, err, ErrPasswordRequired)
+		}
+
+		// Changing the last byte of in/sub/b's data leaves the zip
+		// readable, but the authentication code no longer matches.
+		tampered := bytes.Clone(archive)
+		i := bytes.LastIndex(tampered, []byte("PK\x07\x08")) - zipAESMACSize - 1
+		tampered[i] ^= 1
+		if _, err := extractEncryptedZip(t, tampered, "secret"); !errors.Is(err, ErrAuthentication) {
+			t.Errorf("method %d: got error %v for tampered data, want %v", method, err, ErrAuthentication)
+		}
+	}
+}
+
+func TestCreateEncryptedZip(t *testing.T) {
+	level := 9
+	var buf bytes.Buffer
+	err := Create(context.Background(), CreateOptions{
+		Format:   "zip",
+		Level:    &level,
+		Password: "secret",
+		Files:    fstest.MapFS{"a.txt": {Data: []byte("squish squish squish"), Mode: 0o644}},
+		Output:   &buf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := extractEncryptedZip(t, buf.Bytes(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"a.txt": "squish squish squish"}; !maps.Equal(got, want) {
+		t.Errorf("got tree %v, want %v", got, want)
+	}
+
+	if err := Create(context.Background(), CreateOptions{Format: "tar", Password: "secret", Files: fstest.MapFS{}, Output: &buf}); err == nil {
+		t.Error("created an encrypted tar")
+	}
+}
+
+func TestZipCrypto(t *testing.T) {
+	// Created by Info-ZIP's zip -P hunter2, which stores the entry's sizes
+	// in a data descriptor.
+	archive, err := base64.StdEncoding.DecodeString("UEsDBAoACQAAAIMYIljh4eTqHAAAABAAAAAFAAAAcy50eHR/nxG3zxv7Nc6/6iXgSwofAh3PhOEFyYUxCzLgUEsHCOHh5OocAAAAEAAAAFBLAQIeAwoACQAAAIMYIljh4eTqHAAAABAAAAAFAAAAAAAAAAEAAACkgQAAAABzLnR4dFBLBQYAAAAAAQABADMAAABPAAAAAAA=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := extractEncryptedZip(t, archive, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"s.txt": "secret contents\n"}; !maps.Equal(got, want) {
+		t.Errorf("got tree %v, want %v", got, want)
+	}
+
+	if _, err := extractEncryptedZip(t, archive, "hunter3"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("got error %v with the wrong password, want %v", err, ErrIncorrectPassword)
+	}
+}
+
+func TestWithoutZipExtra(t *testing.T) {
+	extra := []byte{0x55, 0x54, 1, 0, 7, 0x01, 0x99, 2, 0, 1, 2, 0x75, 0x78, 0, 0}
+	got := withoutZipExtra(extra, zipExtraAES)
+	if want := []byte{0x55, 0x54, 1, 0, 7, 0x75, 0x78, 0, 0}; !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package squish
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+// zipVariants are the formats that are zips following conventions of their
+// own, named by their extensions.
+var zipVariants = []string{"jar", "war", "ear", "apk", "epub", "cbz"}
+
+// epubMimetype is the contents of the mimetype entry of an EPUB.
+const epubMimetype = "application/epub+zip"
+
+// ZipVariant writes zips following the conventions of a format derived from
+// zip, which it's named by. Java archives (jar, war and ear) and Android
+// packages (apk) have their META-INF/ directory and META-INF/MANIFEST.MF
+// first, as java.util.jar.JarInputStream requires, and are compressed with
+// deflate. EPUBs have their mimetype entry first, stored uncompressed and
+// without an extra field, as the OCF specification requires, and the rest are
+// compressed with deflate. Comic book archives (cbz) hold images that are
+// already compressed, so they're stored.
+type ZipVariant struct {
+	leveledZip
+	name string
+	// Warn is called with a printf-style format and its arguments to report
+	// problems that don't stop the zip from being written, like an EPUB whose
+	// mimetype isn't that of an EPUB. They're ignored if it's nil.
+	Warn func(format string, a ...any)
+}
+
+// LookupZipVariant returns the zip variant with the extension ext, given with
+// or without its leading dot.
+func LookupZipVariant(ext string) (ZipVariant, bool) {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if !slices.Contains(zipVariants, ext) {
+		return ZipVariant{}, false
+	}
+	return ZipVariant{name: ext}, true
+}
+
+func (z ZipVariant) Extension() string { return "." + z.name }
+
+func (z ZipVariant) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	zw := z.newWriter(output)
+	for _, file := range z.Order(files) {
+		if err := z.archiveFile(ctx, zw, file); err != nil {
+			zw.Close()
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ArchiveAsync writes the files in the order they're sent, so they must be
+// sent in the order given by Order.
+func (z ZipVariant) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	zw := z.newWriter(output)
+	for job := range jobs {
+		job.Result <- z.archiveFile(ctx, zw, job.File)
+	}
+	return zw.Close()
+}
+
+// Order returns files with the entries that must come first moved to the
+// start, leaving the rest in the same order.
+func (z ZipVariant) Order(files []archives.FileInfo) []archives.FileInfo {
+	rank := func(file archives.FileInfo) int {
+		switch name := strings.TrimSuffix(file.NameInArchive, "/"); {
+		case z.name == "epub" && name == "mimetype":
+			return 0
+		case z.name != "epub" && z.name != "cbz" && name == "META-INF":
+			return 0
+		case z.name != "epub" && z.name != "cbz" && name == "META-INF/MANIFEST.MF":
+			return 1
+		default:
+			return 2
+		}
+	}
+	files = slices.Clone(files)
+	slices.SortStableFunc(files, func(a, b archives.FileInfo) int { return rank(a) - rank(b) })
+	return files
+}
+
+func (z ZipVariant) archiveFile(ctx context.Context, zw *zip.Writer, file archives.FileInfo) error {
+	switch {
+	case z.name == "cbz":
+		return writeZipEntry(ctx, zw, file, zip.Store)
+	case z.name == "epub" && file.NameInArchive == "mimetype" && file.Mode().IsRegular():
+		return z.writeEPUBMimetype(zw, file)
+	default:
+		return writeZipEntry(ctx, zw, file, zip.Deflate)
+	}
+}
+
+// writeEPUBMimetype writes the mimetype entry of an EPUB to zw, stored with
+// its sizes in its local header rather than a data descriptor, and without the
+// extra field that a modification time would add, warning if its contents
+// aren't those of an EPUB.
+func (z ZipVariant) writeEPUBMimetype(zw *zip.Writer, file archives.FileInfo) error {
+	input, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	contents, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file.NameInArchive, err)
+	}
+	if string(contents) != epubMimetype && z.Warn != nil {
+		z.Warn("mimetype isn't %s, so EPUB readers may not open the archive", epubMimetype)
+	}
+
+	header := &zip.FileHeader{Name: file.NameInArchive, Method: zip.Store, CreatorVersion: 20, ReaderVersion: 20, CRC32: crc32.ChecksumIEEE(contents), CompressedSize64: uint64(len(contents)), UncompressedSize64: uint64(len(contents))}
+	header.ModifiedDate, header.ModifiedTime = msdosTime(file.ModTime())
+	w, err := zw.CreateRaw(header)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+	}
+	if _, err := w.Write(contents); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.NameInArchive, err)
+	}
+	return nil
+}
+
+// msdosTime returns the MS-DOS date and time fields of a zip header for t.
+func msdosTime(t time.Time) (dosDate, dosTime uint16) {
+	dosDate = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	dosTime = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return dosDate, dosTime
+}
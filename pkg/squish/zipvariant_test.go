@@ -0,0 +1,83 @@
+package squish
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestZipVariant(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"META-INF/container.xml": "<container/>",
+		"OEBPS/chapter.xhtml":    "<html/>",
+		"mimetype":               epubMimetype,
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := archives.FilesFromDisk(ctx, nil, map[string]string{
+		filepath.Join(dir, "META-INF"): "META-INF",
+		filepath.Join(dir, "OEBPS"):    "OEBPS",
+		filepath.Join(dir, "mimetype"): "mimetype",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	format, err := LookupFormat("epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := format.(archives.Archiver).Archive(ctx, &archive, files); err != nil {
+		t.Fatal(err)
+	}
+
+	// EPUB readers check for the mimetype at a fixed offset.
+	if want := "mimetype" + epubMimetype; !bytes.HasPrefix(archive.Bytes()[30:], []byte(want)) {
+		t.Errorf("archive doesn't start with %q", want)
+	}
+	r, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := r.File[0]; first.Method != zip.Store || len(first.Extra) > 0 {
+		t.Errorf("mimetype has method %d and extra field %x", first.Method, first.Extra)
+	}
+	for _, f := range r.File[1:] {
+		if !f.Mode().IsDir() && f.Method != zip.Deflate {
+			t.Errorf("%s has method %d", f.Name, f.Method)
+		}
+	}
+}
+
+func TestZipVariantOrder(t *testing.T) {
+	var files []archives.FileInfo
+	for _, name := range []string{"com/", "com/A.class", "META-INF/MANIFEST.MF", "META-INF/"} {
+		files = append(files, archives.FileInfo{NameInArchive: name})
+	}
+	variant, ok := LookupZipVariant(".JAR")
+	if !ok {
+		t.Fatal(".JAR isn't a zip variant")
+	}
+	var got []string
+	for _, file := range variant.Order(files) {
+		got = append(got, file.NameInArchive)
+	}
+	if want := []string{"META-INF/", "META-INF/MANIFEST.MF", "com/", "com/A.class"}; !slices.Equal(got, want) {
+		t.Errorf("got order %q, want %q", got, want)
+	}
+}
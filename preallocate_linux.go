@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate allocates size bytes for f, which must be empty, and extends it
+// to size, so that it's laid out contiguously as it's written, rather than
+// growing a block at a time.
+func preallocate(f *os.File, size int64) error {
+	if err := syscall.Fallocate(int(f.Fd()), 0, 0, size); err != nil {
+		return os.NewSyscallError("fallocate", err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// preallocate allocates size bytes for f, which is only supported on Linux.
+func preallocate(f *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+
+	"github.com/mholt/archives"
+)
+
+const prefetchChunkSize = 1 << 20
+
+// prefetchReader reads ahead of its consumer in a background goroutine,
+// buffering up to a fixed number of chunks, so that slow reads from the
+// underlying reader overlap with processing of the data already read.
+type prefetchReader struct {
+	chunks  chan []byte
+	stop    chan struct{}
+	current []byte
+
+	// err is the error that ended reading, and is only valid once chunks has
+	// been closed.
+	err error
+}
+
+// newPrefetchReader starts reading up to size bytes ahead from r. The reader
+// must be closed to stop the background goroutine.
+func newPrefetchReader(r io.Reader, size int) *prefetchReader {
+	p := &prefetchReader{
+		chunks: make(chan []byte, max(size/prefetchChunkSize, 1)),
+		stop:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.chunks)
+		for {
+			buf := make([]byte, prefetchChunkSize)
+			n, err := r.Read(buf)
+			if n > 0 {
+				select {
+				case p.chunks <- buf[:n]:
+				case <-p.stop:
+					return
+				}
+			}
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				p.err = err
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *prefetchReader) Read(b []byte) (int, error) {
+	if len(p.current) == 0 {
+		chunk, ok := <-p.chunks
+		if !ok {
+			if p.err != nil {
+				return 0, p.err
+			}
+			return 0, io.EOF
+		}
+		p.current = chunk
+	}
+
+	n := copy(b, p.current)
+	p.current = p.current[n:]
+	return n, nil
+}
+
+func (p *prefetchReader) Close() error {
+	close(p.stop)
+	return nil
+}
+
+// requiresRandomAccess reports whether extracting format needs to seek within
+// its input, in which case the input can't be read ahead sequentially.
+func requiresRandomAccess(format archives.Format) bool {
+	switch format.(type) {
+	case archives.Zip, archives.SevenZip:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func TestPrefetchReader(t *testing.T) {
+	data := make([]byte, 6*prefetchChunkSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	input := &countingReader{Reader: bytes.NewReader(data)}
+	p := newPrefetchReader(input, 2*prefetchChunkSize)
+	defer p.Close()
+
+	// Once the first chunk is taken, the reader keeps reading ahead until two
+	// more are buffered, and it's holding a fourth, but no further.
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(p, first); err != nil {
+		t.Fatal(err)
+	}
+	ahead := int64(4 * prefetchChunkSize)
+	deadline := time.Now().Add(5 * time.Second)
+	for input.n.Load() < ahead && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := input.n.Load(); n != ahead {
+		t.Errorf("read %d bytes ahead, want %d", n, ahead)
+	}
+
+	rest, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(first, rest...), data) {
+		t.Error("read different data")
+	}
+}
+
+func TestPrefetchReaderError(t *testing.T) {
+	errRead := errors.New("read failed")
+	input := io.MultiReader(bytes.NewReader([]byte("squish")), iotest.ErrReader(errRead))
+	p := newPrefetchReader(input, prefetchChunkSize)
+	defer p.Close()
+
+	got, err := io.ReadAll(p)
+	if string(got) != "squish" || !errors.Is(err, errRead) {
+		t.Errorf("got %q, %v, want squish and the read error", got, err)
+	}
+}
+
+func TestRequiresRandomAccess(t *testing.T) {
+	for _, format := range []archives.Format{archives.Zip{}, archives.SevenZip{}} {
+		if !requiresRandomAccess(format) {
+			t.Errorf("%s doesn't require random access", format.Extension())
+		}
+	}
+	for _, format := range []archives.Format{archives.Tar{}, archives.Gz{}, archives.CompressedArchive{Archival: archives.Tar{}, Extraction: archives.Tar{}, Compression: archives.Zstd{}}} {
+		if requiresRandomAccess(format) {
+			t.Errorf("%s requires random access", format.Extension())
+		}
+	}
+}
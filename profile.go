@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// startProfiling starts the CPU profile and execution trace requested by
+// --cpuprofile and --trace, returning a function that stops them and writes
+// the heap profile requested by --memprofile, for diagnosing slow operations.
+func startProfiling() func() {
+	var stops []func()
+	stop := func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+	start := func(path string, startFn func(io.Writer) error, stopFn func()) {
+		f, err := os.Create(path)
+		if err != nil {
+			stop()
+			bail("failed to create profile: %s", err)
+		}
+		if err := startFn(f); err != nil {
+			f.Close()
+			stop()
+			bail("failed to start profiling: %s", err)
+		}
+		stops = append(stops, func() {
+			stopFn()
+			if err := f.Close(); err != nil {
+				logMessage(slog.LevelWarn, nil, "failed to write profile %s: %s", path, err)
+			}
+		})
+	}
+
+	if cli.CPUProfile != "" {
+		start(cli.CPUProfile, pprof.StartCPUProfile, pprof.StopCPUProfile)
+	}
+	if cli.Trace != "" {
+		start(cli.Trace, trace.Start, trace.Stop)
+	}
+	if cli.MemProfile != "" {
+		stops = append(stops, func() { writeHeapProfile(cli.MemProfile) })
+	}
+	return stop
+}
+
+// writeHeapProfile writes a profile of the memory that's been allocated to
+// path, after a garbage collection, so that it's up to date.
+func writeHeapProfile(path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		logMessage(slog.LevelWarn, nil, "failed to create profile: %s", err)
+		return
+	}
+	defer f.Close()
+	runtime.GC()
+	if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+		logMessage(slog.LevelWarn, nil, "failed to write profile %s: %s", path, err)
+	}
+}
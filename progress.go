@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+const progressInterval = 100 * time.Millisecond
+
+// progress reports how many bytes of the current entry have been processed to
+// stderr, along with the overall number of bytes processed, the throughput,
+// and if the total is known, the estimated time remaining. The same is also
+// reported as events if they were requested. A nil *progress is valid and
+// reports nothing. When entries are processed concurrently, the most recently
+// started one is reported.
+type progress struct {
+	mu       sync.Mutex
+	name     string
+	size     int64
+	done     int64
+	lastDraw time.Time
+
+	started time.Time
+	// total is the overall number of bytes to process, or negative if it's
+	// unknown.
+	total     int64
+	totalDone int64
+	// countInput is true if the overall progress is measured by the bytes
+	// read from the input, rather than the bytes of the entries processed.
+	countInput bool
+	entries    int64
+	// entryBytes is the number of bytes of the entries processed, and
+	// archiveBytes the size of the archive they were extracted from, or the
+	// number of bytes written to the archive they were added to.
+	entryBytes   int64
+	archiveBytes int64
+	// extracting is true if entries are extracted from an archive, rather
+	// than added to one, and archiveSized if the size of the archive is
+	// known up front, rather than counted as it's read.
+	extracting   bool
+	archiveSized bool
+
+	// summary is the format that the summary of the operation is printed in
+	// once it's finished, text or json, or empty if it isn't printed.
+	summary string
+
+	// show is true if progress is shown on stderr, rather than only being
+	// reported as events.
+	show bool
+}
+
+// newProgress returns the progress of an operation, which prints a summary of
+// it in the given format once it's finished, or nil if nothing would be
+// reported.
+func newProgress(summary string) *progress {
+	if !cli.Progress && events == nil && summary == "" {
+		return nil
+	}
+	p := &progress{started: time.Now(), total: -1, show: cli.Progress, summary: summary}
+	transfers.progress.Store(p)
+	return p
+}
+
+// isTerminal reports whether f is a terminal, and so whether progress should
+// be shown by default.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// setTotal sets the overall number of bytes that will be processed, so that
+// the estimated time remaining can be shown.
+func (p *progress) setTotal(total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+}
+
+// start begins reporting progress for a new entry. A negative size means the
+// size is unknown.
+func (p *progress) start(name string, size int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.name, p.size, p.done = name, size, 0
+	p.entries++
+	events.emit("entry_start", map[string]any{"name": name, "size": size})
+	p.draw()
+}
+
+// finish reports that the entry name, of which size bytes were processed, is
+// finished.
+func (p *progress) finish(name string, size int64) {
+	if p == nil {
+		return
+	}
+	events.emit("entry_end", map[string]any{"name": name, "size": size})
+}
+
+func (p *progress) add(n int, entry bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry {
+		p.done += int64(n)
+		p.entryBytes += int64(n)
+	} else if p.extracting && !p.archiveSized {
+		p.archiveBytes += int64(n)
+	}
+	if entry != p.countInput {
+		p.totalDone += int64(n)
+	}
+	if time.Since(p.lastDraw) >= progressInterval {
+		p.draw()
+	}
+}
+
+// tick redraws the progress if it hasn't been drawn recently, for changes
+// that aren't made through the progress itself.
+func (p *progress) tick() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.lastDraw) >= progressInterval {
+		p.draw()
+	}
+}
+
+func (p *progress) draw() {
+	p.lastDraw = time.Now()
+
+	fields := map[string]any{"bytes": p.totalDone, "total": p.total}
+	transfers.addFields(fields)
+	events.emit("progress", fields)
+	if !p.show {
+		return
+	}
+
+	overall := byteSize(p.totalDone).String()
+	if p.total >= 0 {
+		overall += " / " + byteSize(p.total).String() + fmt.Sprintf(" (%d%%)", percent(p.totalDone, p.total))
+	}
+
+	var rate float64
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		rate = float64(p.totalDone) / elapsed
+	}
+	overall += ", " + byteSize(rate).String() + "/s"
+	if p.total >= 0 && rate > 0 {
+		remaining := time.Duration(float64(max(p.total-p.totalDone, 0)) / rate * float64(time.Second))
+		overall += ", " + localize("ETA %s", remaining.Round(time.Second))
+	}
+
+	entry := byteSize(p.done).String()
+	if p.size >= 0 {
+		entry += " / " + byteSize(p.size).String() + fmt.Sprintf(" (%d%%)", percent(p.done, p.size))
+	}
+
+	line := fmt.Sprintf("%s | %s: %s", overall, p.name, entry)
+	if transferred := transfers.String(time.Since(p.started)); transferred != "" {
+		line += " | " + transferred
+	}
+	fmt.Fprint(os.Stderr, "\r\x1b[K"+line)
+}
+
+func percent(done, size int64) int64 {
+	if size <= 0 {
+		return 100
+	}
+	return min(done*100/size, 100)
+}
+
+// println prints line to w, erasing the progress line first and redrawing it
+// afterwards so that they don't overlap. A nil *progress just prints line.
+func (p *progress) println(w io.Writer, line string) {
+	if p == nil || !p.show {
+		fmt.Fprintln(w, line)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprint(os.Stderr, "\r\x1b[K")
+	fmt.Fprintln(w, line)
+	p.draw()
+}
+
+// clear erases the progress line once processing is finished, and reports
+// a summary of the operation as an event.
+func (p *progress) clear() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.show {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+	}
+	transfers.progress.CompareAndSwap(p, nil)
+
+	fields := map[string]any{
+		"success":         exitCode == 0,
+		"entries":         p.entries,
+		"bytes":           p.totalDone,
+		"warnings":        warnings.Load(),
+		"elapsed_seconds": time.Since(p.started).Seconds(),
+	}
+	transfers.addFields(fields)
+	events.emit("summary", fields)
+
+	if p.summary != "" && exitCode == 0 {
+		p.printSummary(os.Stderr)
+	}
+}
+
+// printSummary prints how many entries were archived or extracted, their
+// size and that of the archive, the ratio between them, and how long it took.
+func (p *progress) printSummary(w io.Writer) {
+	elapsed := time.Since(p.started)
+	var ratio, rate float64
+	if p.entryBytes > 0 {
+		ratio = float64(p.archiveBytes) / float64(p.entryBytes)
+	}
+	if elapsed > 0 {
+		rate = float64(p.entryBytes) / elapsed.Seconds()
+	}
+
+	inputBytes, outputBytes := p.entryBytes, p.archiveBytes
+	if p.extracting {
+		inputBytes, outputBytes = outputBytes, inputBytes
+	}
+	if p.summary == "json" {
+		line, err := json.Marshal(map[string]any{
+			"entries":          p.entries,
+			"input_bytes":      inputBytes,
+			"output_bytes":     outputBytes,
+			"ratio":            ratio,
+			"elapsed_seconds":  elapsed.Seconds(),
+			"bytes_per_second": rate,
+		})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(w, "%s\n", line)
+		return
+	}
+
+	elapsed = elapsed.Round(time.Millisecond)
+	if p.extracting {
+		fmt.Fprintln(w, localize("extracted %d entries, %s from %s (ratio %.3f), in %s at %s/s", p.entries, byteSize(outputBytes), byteSize(inputBytes), ratio, elapsed, byteSize(rate)))
+	} else {
+		fmt.Fprintln(w, localize("archived %d entries, %s into %s (ratio %.3f), in %s at %s/s", p.entries, byteSize(inputBytes), byteSize(outputBytes), ratio, elapsed, byteSize(rate)))
+	}
+}
+
+// extractFrom reports that entries are extracted from an archive of size
+// bytes, or a negative size if it isn't known, in which case the bytes read
+// from the input are counted instead.
+func (p *progress) extractFrom(size int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.extracting = true
+	if size >= 0 {
+		p.archiveBytes, p.archiveSized = size, true
+	}
+}
+
+// output wraps w so that bytes written to it are counted as the size of the
+// archive that entries are added to.
+func (p *progress) output(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return progressWriter{w, p}
+}
+
+// input wraps the input r, of size bytes or a negative size if it's unknown,
+// so that the overall progress is measured by the bytes read from it.
+func (p *progress) input(r io.Reader, size int64) io.Reader {
+	if p == nil {
+		return r
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.countInput = true
+	p.total = size
+	return progressReader{r, p, false}
+}
+
+// reader wraps r so that bytes read from it count towards the current entry.
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return progressReader{r, p, true}
+}
+
+// writer wraps w so that bytes written to it count towards the current entry.
+func (p *progress) writer(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return entryWriter{w, p}
+}
+
+// file wraps file so that opening it starts a new entry, and bytes read from
+// it count towards that entry.
+func (p *progress) file(file archives.FileInfo) archives.FileInfo {
+	if p == nil || file.Open == nil {
+		return file
+	}
+
+	open := file.Open
+	file.Open = func() (fs.File, error) {
+		f, err := open()
+		if err != nil {
+			return nil, err
+		}
+		p.start(file.NameInArchive, file.Size())
+		return &progressFile{File: f, p: p, name: file.NameInArchive}, nil
+	}
+	return file
+}
+
+type progressReader struct {
+	io.Reader
+	p     *progress
+	entry bool
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.p.add(n, r.entry)
+	return n, err
+}
+
+type progressWriter struct {
+	io.Writer
+	p *progress
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.p.mu.Lock()
+	w.p.archiveBytes += int64(n)
+	w.p.mu.Unlock()
+	return n, err
+}
+
+type entryWriter struct {
+	io.Writer
+	p *progress
+}
+
+func (w entryWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.p.add(n, true)
+	return n, err
+}
+
+type progressFile struct {
+	fs.File
+	p    *progress
+	name string
+	read int64
+}
+
+func (f *progressFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.read += int64(n)
+	f.p.add(n, true)
+	return n, err
+}
+
+func (f *progressFile) Close() error {
+	f.p.finish(f.name, f.read)
+	return f.File.Close()
+}
+
+// transfers counts the bytes received from remote inputs and sent to remote
+// outputs, including those of retried requests. They're reported separately
+// from the bytes processed, since the two differ when the input is
+// prefetched, formats that need random access only read parts of it, or
+// uploads are buffered into parts.
+var transfers transferProgress
+
+type transferProgress struct {
+	downloaded, uploaded, retries atomic.Int64
+	// progress is redrawn as bytes are transferred.
+	progress atomic.Pointer[progress]
+}
+
+func (t *transferProgress) add(counter *atomic.Int64, n int) {
+	counter.Add(int64(n))
+	t.progress.Load().tick()
+}
+
+// retried reports that a request was retried, or a dropped connection was
+// resumed.
+func (t *transferProgress) retried() {
+	t.retries.Add(1)
+	t.progress.Load().tick()
+}
+
+// download wraps the body of a response so that bytes read from it are
+// counted as downloaded.
+func (t *transferProgress) download(body io.ReadCloser) io.ReadCloser {
+	return transferReader{body, t, &t.downloaded}
+}
+
+// upload wraps the body of a request so that bytes read from it are counted as
+// uploaded.
+func (t *transferProgress) upload(body io.ReadCloser) io.ReadCloser {
+	return transferReader{body, t, &t.uploaded}
+}
+
+// addFields adds the transfer counts to the fields of an event, if anything
+// was transferred.
+func (t *transferProgress) addFields(fields map[string]any) {
+	downloaded, uploaded, retries := t.downloaded.Load(), t.uploaded.Load(), t.retries.Load()
+	if downloaded == 0 && uploaded == 0 && retries == 0 {
+		return
+	}
+	fields["downloaded"] = downloaded
+	fields["uploaded"] = uploaded
+	fields["retries"] = retries
+}
+
+// String describes the transfers and their throughput over elapsed, or
+// returns an empty string if nothing was transferred.
+func (t *transferProgress) String(elapsed time.Duration) string {
+	var parts []string
+	rate := func(n int64) string {
+		if elapsed <= 0 {
+			return byteSize(0).String() + "/s"
+		}
+		return byteSize(float64(n)/elapsed.Seconds()).String() + "/s"
+	}
+	if downloaded := t.downloaded.Load(); downloaded > 0 {
+		parts = append(parts, localize("downloaded %s at %s", byteSize(downloaded), rate(downloaded)))
+	}
+	if uploaded := t.uploaded.Load(); uploaded > 0 {
+		parts = append(parts, localize("uploaded %s at %s", byteSize(uploaded), rate(uploaded)))
+	}
+	if retries := t.retries.Load(); retries > 0 {
+		parts = append(parts, localize("%d retries", retries))
+	}
+	return strings.Join(parts, ", ")
+}
+
+type transferReader struct {
+	io.ReadCloser
+	t       *transferProgress
+	counter *atomic.Int64
+}
+
+func (r transferReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.t.add(r.counter, n)
+	return n, err
+}
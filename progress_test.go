@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrintSummary(t *testing.T) {
+	p := newProgress("json")
+	p.extractFrom(-1)
+	input := strings.NewReader(strings.Repeat("x", 100))
+	if _, err := new(bytes.Buffer).ReadFrom(p.input(input, -1)); err != nil {
+		t.Fatal(err)
+	}
+	p.start("entry", 400)
+	if _, err := new(bytes.Buffer).ReadFrom(p.reader(strings.NewReader(strings.Repeat("y", 400)))); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	p.printSummary(&buf)
+	var summary struct {
+		Entries     int64   `json:"entries"`
+		InputBytes  int64   `json:"input_bytes"`
+		OutputBytes int64   `json:"output_bytes"`
+		Ratio       float64 `json:"ratio"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Entries != 1 || summary.InputBytes != 100 || summary.OutputBytes != 400 || summary.Ratio != 0.25 {
+		t.Errorf("got summary %s", buf.Bytes())
+	}
+
+	p = newProgress("text")
+	if _, err := p.output(&buf).Write(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	p.start("entry", 20)
+	if _, err := new(bytes.Buffer).ReadFrom(p.reader(strings.NewReader(strings.Repeat("y", 20)))); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	p.printSummary(&buf)
+	if want := "archived 1 entries, 20 B into 10 B (ratio 0.500), in "; !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("got summary %q, want it to start with %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// reflinkHeadSize is how much of the start of files is hashed to find the
+// files that entries may have the same contents as.
+const reflinkHeadSize = 64 << 10
+
+// reflinkMinSize is the size of the smallest files that are reflinked, since
+// smaller ones only take up a block or so either way.
+const reflinkMinSize = 16 << 10
+
+// reflinkKey identifies the files that may have the same contents, by their
+// sizes and the hashes of their first reflinkHeadSize bytes.
+type reflinkKey struct {
+	size int64
+	head [sha256.Size]byte
+}
+
+// reflinkSource is a file that later entries with the same contents can be
+// made reflinks of.
+type reflinkSource struct {
+	root extractRoot
+	name string
+}
+
+// reflinkIndex finds files with the same contents as the regular files being
+// extracted, for --reflink, so that they can be made reflinks of them, sharing
+// their blocks rather than being written out again. Candidates are found by
+// their reflinkKeys, then compared with the entries in full.
+type reflinkIndex struct {
+	// root is where the extracted files, which are candidates for the
+	// entries after them, are.
+	root  extractRoot
+	mu    sync.Mutex
+	files map[reflinkKey]reflinkSource
+}
+
+// newReflinkIndex returns an index of the files extracted beneath root, and the
+// regular files beneath from, if it's not empty.
+func newReflinkIndex(root extractRoot, from string) (*reflinkIndex, error) {
+	r := &reflinkIndex{root: root, files: map[reflinkKey]reflinkSource{}}
+	if from == "" {
+		return r, nil
+	}
+	err := filepath.WalkDir(from, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < reflinkMinSize {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		head := make([]byte, min(info.Size(), reflinkHeadSize))
+		if _, err := io.ReadFull(f, head); err != nil {
+			return err
+		}
+		name, err := filepath.Rel(from, p)
+		if err != nil {
+			return err
+		}
+		key := reflinkKey{info.Size(), sha256.Sum256(head)}
+		if _, ok := r.files[key]; !ok {
+			r.files[key] = reflinkSource{pathRoot(from), name}
+		}
+		return nil
+	})
+	return r, err
+}
+
+// copy writes src, the size bytes of the contents of an entry, to output
+// through dst, which wraps it, unless a file with the same contents is found,
+// in which case output is made a reflink of it, or a copy of it if the
+// filesystem doesn't support reflinks. Files that differ are written from the
+// first byte that does, with what came before copied from the file they were
+// compared with. Once written, output is a candidate for the entries after it,
+// as name beneath r.root.
+func (r *reflinkIndex) copy(output *os.File, dst io.Writer, src io.Reader, size int64, name string, limits *extractLimits) (int64, error) {
+	if size < reflinkMinSize {
+		return copyContents(dst, src)
+	}
+	head := make([]byte, min(size, reflinkHeadSize))
+	if n, err := io.ReadFull(src, head); err != nil {
+		written, _ := dst.Write(head[:n])
+		return int64(written), err
+	}
+	key := reflinkKey{size, sha256.Sum256(head)}
+
+	r.mu.Lock()
+	source, ok := r.files[key]
+	r.mu.Unlock()
+	var written int64
+	var err error
+	if ok {
+		written, err = r.copyFrom(output, dst, src, head, size, source, limits)
+	} else {
+		written, err = writeAll(dst, head, src)
+	}
+	if err == nil {
+		r.mu.Lock()
+		if _, ok := r.files[key]; !ok {
+			r.files[key] = reflinkSource{r.root, name}
+		}
+		r.mu.Unlock()
+	}
+	return written, err
+}
+
+// copyFrom writes head and the rest of src to output as copy does, comparing
+// them with source.
+func (r *reflinkIndex) copyFrom(output *os.File, dst io.Writer, src io.Reader, head []byte, size int64, source reflinkSource, limits *extractLimits) (int64, error) {
+	candidate, err := source.root.OpenFile(source.name, os.O_RDONLY, 0)
+	if err != nil {
+		// The candidate may have been removed or replaced since, so the
+		// entry is just written.
+		return writeAll(dst, head, src)
+	}
+	defer candidate.Close()
+
+	// matched counts the bytes of the entry that are the same as those of
+	// the candidate, and data holds the bytes read from the entry that
+	// haven't been compared yet.
+	var matched int64
+	data := head
+	chunk, candidateChunk := make([]byte, compareChunkSize), make([]byte, reflinkHeadSize)
+	for {
+		n, _ := io.ReadFull(candidate, candidateChunk[:len(data)])
+		same := 0
+		for same < n && data[same] == candidateChunk[same] {
+			same++
+		}
+		matched += int64(same)
+		if same < len(data) {
+			written, err := copyFileRange(output, candidate, matched, limits)
+			if err != nil {
+				return written, err
+			}
+			rest, err := writeAll(dst, data[same:], src)
+			return written + rest, err
+		}
+		if matched == size {
+			break
+		}
+		n, err := io.ReadFull(src, chunk[:min(int64(len(chunk)), size-matched)])
+		if err != nil {
+			written, copyErr := copyFileRange(output, candidate, matched, limits)
+			if copyErr != nil {
+				return written, copyErr
+			}
+			rest, _ := dst.Write(chunk[:n])
+			return written + int64(rest), err
+		}
+		data = chunk[:n]
+	}
+
+	// The candidate may be longer than the entry, in which case only the
+	// part that's the same is copied.
+	if stat, err := candidate.Stat(); err == nil && stat.Size() == size {
+		if err := reflink(output, candidate); err == nil {
+			return size, limits.grow(size)
+		}
+	}
+	return copyFileRange(output, candidate, size, limits)
+}
+
+// writeAll writes head and then the rest of src to dst.
+func writeAll(dst io.Writer, head []byte, src io.Reader) (int64, error) {
+	n, err := dst.Write(head)
+	if err != nil {
+		return int64(n), err
+	}
+	written, err := copyContents(dst, src)
+	return int64(n) + written, err
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// reflink makes dst a reflink of src, which is only supported on Linux, so
+// files are copied instead.
+func reflink(dst, src *os.File) error {
+	return errors.ErrUnsupported
+}
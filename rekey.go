@@ -0,0 +1,163 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/klauspost/compress/zip"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// rekey re-encrypts an encrypted archive under new keys, for rotating
+// credentials. Its contents are streamed from the old encryption to the new,
+// so they're never written to disk decrypted. Inputs encrypted as a whole with
+// age or gpg are encrypted to --new-recipient instead, and zips have their
+// encrypted entries encrypted with --new-password instead.
+func rekey(ctx context.Context) {
+	input, err := os.Open(cli.Rekey.Input)
+	if err != nil {
+		bail("failed to open input file: %s", err)
+	}
+	defer input.Close()
+	header := make([]byte, gpgSniffSize)
+	n, _ := input.ReadAt(header, 0)
+	header = header[:n]
+
+	_, ageName := strings.CutSuffix(cli.Rekey.Input, ageExtension)
+	_, gpgName := trimGPGExtension(cli.Rekey.Input)
+	ageInput := ageName || isAge(header)
+	stream := ageInput || gpgName || isOpenPGP(header)
+	var ageRecipients []age.Recipient
+	var gpgRecipients []string
+	if stream {
+		if cli.Rekey.OldPassword.given() || cli.Rekey.NewPassword.given() {
+			bail("--old-password and --new-password can only be used with zips")
+		}
+		if len(cli.Rekey.NewRecipient) == 0 {
+			bail("--new-recipient must be given to re-encrypt age- or gpg-encrypted inputs")
+		}
+		if ageInput && len(cli.Rekey.Identity) == 0 {
+			bail("--identity must be given to decrypt age-encrypted inputs")
+		}
+		ageRecipients, gpgRecipients = splitRecipients(cli.Rekey.NewRecipient, nil)
+	} else if len(cli.Rekey.NewRecipient) > 0 || len(cli.Rekey.Identity) > 0 {
+		bail("--new-recipient and --identity can only be used with age- or gpg-encrypted inputs")
+	}
+
+	// The output is only put in place once it's completely written, so the
+	// input can be replaced.
+	outputPath := cmp.Or(cli.Rekey.Output, cli.Rekey.Input)
+	file, err := createAtomic(outputPath, "")
+	if err != nil {
+		bail("failed to create output file: %s", err)
+	}
+	var output io.WriteCloser = file
+	commit := file.commit
+	if stream {
+		output, commit, err = encryptOutput(file, file.commit, ageRecipients, gpgRecipients, strings.HasSuffix(outputPath, ".asc"))
+		if err != nil {
+			bail("failed to encrypt output: %s", err)
+		}
+	}
+	defer func() {
+		if err := output.Close(); err != nil {
+			bail("failed to close output file: %s", err)
+		}
+	}()
+
+	if stream {
+		err = rekeyStream(ctx, input, output, ageInput)
+	} else {
+		err = rekeyZip(ctx, input, output)
+	}
+	if err != nil {
+		bail("failed to re-encrypt input: %s", err)
+	}
+	commit()
+}
+
+// rekeyStream decrypts input, which is encrypted with age if ageInput is
+// true, or OpenPGP otherwise, writing it to output.
+func rekeyStream(ctx context.Context, input *os.File, output io.Writer, ageInput bool) error {
+	var decrypted io.ReadCloser
+	if ageInput {
+		identities, err := readAgeIdentities(cli.Rekey.Identity)
+		if err != nil {
+			return fmt.Errorf("failed to read identities: %w", err)
+		}
+		if decrypted, err = decryptAge(input, identities); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if decrypted, err = decryptGPG(input); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.Copy(output, contextReader{ctx, decrypted})
+	// gpg only reports whether the input was intact once it exits.
+	if closeErr := decrypted.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// rekeyZip decrypts the encrypted entries of the zip input with
+// --old-password, and writes the zip to output with them encrypted with AES-256
+// using --new-password. Entries that aren't encrypted are copied as they are.
+func rekeyZip(ctx context.Context, input *os.File, output io.Writer) error {
+	info, err := input.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(input, info.Size())
+	if err != nil {
+		return fmt.Errorf("input isn't encrypted with age or gpg, or a zip: %w", err)
+	}
+	oldPassword := cli.Rekey.OldPassword.get(false)
+	newPassword := cli.Rekey.NewPassword.get(true)
+	if newPassword == "" {
+		bail("--new-password must be given to re-encrypt zips")
+	}
+
+	zw := zip.NewWriter(output)
+	if err := zw.SetComment(zr.Comment); err != nil {
+		return err
+	}
+	rekeyed := 0
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, encrypted, _ := squish.ZipMethod(f.FileHeader); !encrypted {
+			if err := zw.Copy(f); err != nil {
+				return withEntry(f.Name, err)
+			}
+			continue
+		}
+		if oldPassword == "" {
+			bail("--old-password must be given to decrypt the encrypted entries of zips")
+		}
+		if err := squish.ReencryptZipEntry(zw, f, oldPassword, newPassword); err != nil {
+			return withEntry(f.Name, err)
+		}
+		rekeyed++
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if rekeyed == 0 {
+		warn("no entries of %s are encrypted, so it was only copied", cli.Rekey.Input)
+	} else {
+		logMessage(slog.LevelInfo, nil, "re-encrypted %d entries", rekeyed)
+	}
+	return nil
+}
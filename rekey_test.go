@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+func TestRekeyZip(t *testing.T) {
+	// The same zip as in TestZipCrypto, encrypted with the traditional
+	// PKWARE encryption.
+	archive, err := base64.StdEncoding.DecodeString("UEsDBAoACQAAAIMYIljh4eTqHAAAABAAAAAFAAAAcy50eHR/nxG3zxv7Nc6/6iXgSwofAh3PhOEFyYUxCzLgUEsHCOHh5OocAAAAEAAAAFBLAQIeAwoACQAAAIMYIljh4eTqHAAAABAAAAAFAAAAAAAAAAEAAACkgQAAAABzLnR4dFBLBQYAAAAAAQABADMAAABPAAAAAAA=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "s.zip")
+	if err := os.WriteFile(path, archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	saved := cli.Rekey
+	t.Cleanup(func() { cli.Rekey = saved })
+
+	// The rekeyed archive is read as any other would be.
+	extract := func(archive []byte, password string) (map[string]string, error) {
+		dir := t.TempDir()
+		opts := squish.ExtractOptions{Input: bytes.NewReader(archive), Name: "s.zip", Password: password, Dir: dir}
+		if err := squish.Extract(context.Background(), opts); err != nil {
+			return nil, err
+		}
+		return readTree(t, dir), nil
+	}
+
+	want := map[string]string{"s.txt": "secret contents\n"}
+	for _, rekeyed := range []string{"first", "second"} {
+		input, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := cli.Rekey.NewPassword
+		if rekeyed == "first" {
+			old = password{value: "hunter2"}
+		}
+		cli.Rekey.OldPassword, cli.Rekey.NewPassword = old, password{value: "correct horse " + rekeyed}
+		var buf bytes.Buffer
+		err = rekeyZip(context.Background(), input, &buf)
+		input.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", rekeyed, err)
+		}
+
+		got, err := extract(buf.Bytes(), "correct horse "+rekeyed)
+		if err != nil {
+			t.Fatalf("%s: %s", rekeyed, err)
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("%s: got tree %v, want %v", rekeyed, got, want)
+		}
+		if _, err := extract(buf.Bytes(), old.value); !errors.Is(err, squish.ErrIncorrectPassword) {
+			t.Errorf("%s: got error %v with the old password, want %v", rekeyed, err, squish.ErrIncorrectPassword)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
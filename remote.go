@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// azureAPIVersion is the version of the Azure Blob Storage REST API used.
+const azureAPIVersion = "2021-08-06"
+
+// remoteSchemes are the URL schemes of remote inputs and outputs. s3://, gs://
+// and az:// URLs name objects in Amazon S3, Google Cloud Storage and Azure Blob
+// Storage respectively.
+var remoteSchemes = []string{"http", "https", "s3", "gs", "az"}
+
+// isURL reports whether path is a remote URL rather than a local path.
+func isURL(path string) bool {
+	scheme, _, ok := strings.Cut(path, "://")
+	return ok && slices.Contains(remoteSchemes, scheme)
+}
+
+// urlBaseName returns the last element of the path of rawURL, for use where
+// the name of a local input would be.
+func urlBaseName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return path.Base(u.Path)
+}
+
+// remoteObject is a file that's accessed over HTTP(S), possibly through a cloud
+// storage service's API.
+type remoteObject struct {
+	scheme string
+	url    *url.URL
+}
+
+// parseRemote resolves rawURL to the HTTPS URL of the object it names.
+// Credentials for cloud storage services are taken from the environment when
+// requests are made: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN for S3, whose region is taken from AWS_REGION and endpoint
+// from AWS_ENDPOINT_URL if set, GOOGLE_OAUTH_ACCESS_TOKEN for Google Cloud
+// Storage, and AZURE_STORAGE_SAS_TOKEN for Azure Blob Storage. Requests are
+// made anonymously if they aren't set.
+func parseRemote(rawURL string) (*remoteObject, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	object := &remoteObject{scheme: u.Scheme}
+	switch u.Scheme {
+	case "http", "https":
+		object.url = u
+
+	case "s3":
+		if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+			endpointURL, err := url.Parse(endpoint)
+			if err != nil {
+				return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL: %w", err)
+			}
+			object.url = endpointURL.JoinPath(u.Host, u.Path)
+		} else {
+			object.url = &url.URL{Scheme: "https", Host: u.Host + ".s3." + s3Region() + ".amazonaws.com", Path: u.Path}
+		}
+
+	case "gs":
+		object.url = &url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + u.Host + u.Path}
+
+	case "az":
+		// az://ACCOUNT/CONTAINER/BLOB
+		object.url = &url.URL{Scheme: "https", Host: u.Host + ".blob.core.windows.net", Path: u.Path}
+
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme: %s", u.Scheme)
+	}
+
+	if u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return nil, fmt.Errorf("URL doesn't name an object: %s", rawURL)
+	}
+
+	return object, nil
+}
+
+// newRequest creates an authenticated request for the object, with query added
+// to the object's query parameters.
+func (o *remoteObject) newRequest(method string, query url.Values, body []byte) (*http.Request, error) {
+	u := *o.url
+	q := u.Query()
+	for key, values := range query {
+		q[key] = values
+	}
+	if sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); o.scheme == "az" && sas != "" {
+		sasQuery, err := url.ParseQuery(strings.TrimPrefix(sas, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_SAS_TOKEN: %w", err)
+		}
+		for key, values := range sasQuery {
+			q[key] = values
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	var bodyR io.Reader
+	if body != nil {
+		bodyR = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u.String(), bodyR)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = transfers.upload(req.Body)
+	}
+
+	switch o.scheme {
+	case "s3":
+		signS3(req, time.Now())
+	case "gs":
+		if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	case "az":
+		req.Header.Set("x-ms-version", azureAPIVersion)
+	}
+
+	return req, nil
+}
+
+// do sends req and returns the response if its status indicates success.
+func (o *remoteObject) do(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unexpected status: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	return resp, nil
+}
+
+// httpInput reads a remote file over HTTP. Sequential reads stream a single
+// response body, which is resumed with a range request from the current
+// offset if the connection drops. If the server supports range requests,
+// ReadAt and seeking to arbitrary offsets are also supported, so formats that
+// need random access work too. Only the parts of the file that are read at are
+// requested, so listing a large remote zip, or extracting a few of its
+// entries, transfers its central directory and those entries rather than the
+// whole file.
+type httpInput struct {
+	object *remoteObject
+	size   int64
+	ranges bool
+	etag   string
+
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+
+	// chunks are the most recently requested parts of the file read at,
+	// least recent first, since readers like zip's read through small
+	// buffers. Zip entries are extracted concurrently, so they're guarded by
+	// mu.
+	mu        sync.Mutex
+	chunks    []remoteChunk
+	cached    int
+	nextChunk int64
+	lastChunk int64
+}
+
+const (
+	// minRemoteChunk and maxRemoteChunk are the least and most that ReadAt
+	// requests at once. Requests start at the least, and double while reads
+	// continue where the last request ended, so that reading the entries of
+	// a zip takes few requests without transferring much more than was read.
+	minRemoteChunk = 64 << 10
+	maxRemoteChunk = 16 << 20
+
+	// maxRemoteCache is how much of what ReadAt requested is kept.
+	maxRemoteCache = 64 << 20
+)
+
+// remoteChunk is a part of a remote file, starting at offset.
+type remoteChunk struct {
+	offset int64
+	data   []byte
+}
+
+func openURL(rawURL string) (*httpInput, error) {
+	object, err := parseRemote(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := object.newRequest(http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return &httpInput{
+		object: object,
+		size:   resp.ContentLength,
+		ranges: resp.Header.Get("Accept-Ranges") == "bytes",
+		etag:   resp.Header.Get("ETag"),
+		body:   transfers.download(resp.Body),
+	}, nil
+}
+
+// get requests the bytes of the file from start up to and including end, or
+// until the end of the file if end is negative.
+func (h *httpInput) get(start, end int64) (io.ReadCloser, error) {
+	req, err := h.object.newRequest(http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ranged := start > 0 || end >= 0
+	if ranged {
+		if !h.ranges {
+			return nil, errors.New("server doesn't support range requests")
+		}
+
+		if end >= 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+		}
+
+		// If the file has changed, the server responds with the whole new
+		// file instead, which is caught below.
+		if h.etag != "" {
+			req.Header.Set("If-Range", h.etag)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case ranged && resp.StatusCode == http.StatusPartialContent, !ranged && resp.StatusCode == http.StatusOK:
+		return transfers.download(resp.Body), nil
+	case ranged && resp.StatusCode == http.StatusOK:
+		resp.Body.Close()
+		return nil, errors.New("remote file changed while it was being read")
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+}
+
+func (h *httpInput) closeBody() {
+	if h.body != nil {
+		h.body.Close()
+		h.body = nil
+	}
+}
+
+func (h *httpInput) Read(p []byte) (int, error) {
+	for resumed := false; ; resumed = true {
+		if h.size >= 0 && h.offset >= h.size {
+			return 0, io.EOF
+		}
+
+		if h.body == nil || h.bodyOffset != h.offset {
+			h.closeBody()
+			body, err := h.get(h.offset, -1)
+			if err != nil {
+				return 0, err
+			}
+			h.body, h.bodyOffset = body, h.offset
+		}
+
+		n, err := h.body.Read(p)
+		h.offset += int64(n)
+		h.bodyOffset += int64(n)
+
+		// The connection was probably dropped, so resume from the current
+		// offset, but only once in a row so that persistent errors are still
+		// reported.
+		if err != nil && err != io.EOF && h.ranges && (n > 0 || !resumed) {
+			h.closeBody()
+			transfers.retried()
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (h *httpInput) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if h.size >= 0 && off >= h.size {
+			return n, io.EOF
+		}
+
+		chunk, ok := h.cachedChunk(off)
+		if !ok {
+			var err error
+			if chunk, err = h.fetchChunk(off, int64(len(p)-n)); err != nil {
+				return n, err
+			}
+		}
+
+		copied := copy(p[n:], chunk.data[off-chunk.offset:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// cachedChunk returns the requested chunk containing off, if it's still kept.
+func (h *httpInput) cachedChunk(off int64) (remoteChunk, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.chunks) - 1; i >= 0; i-- {
+		chunk := h.chunks[i]
+		if off >= chunk.offset && off < chunk.offset+int64(len(chunk.data)) {
+			return chunk, true
+		}
+	}
+	return remoteChunk{}, false
+}
+
+// fetchChunk requests a chunk of the file starting at off, of at least want
+// bytes unless the file ends first, and keeps it for later reads.
+func (h *httpInput) fetchChunk(off, want int64) (remoteChunk, error) {
+	h.mu.Lock()
+	size := int64(minRemoteChunk)
+	if off == h.nextChunk {
+		size = max(size, min(2*h.lastChunk, maxRemoteChunk))
+	}
+	h.mu.Unlock()
+	size = max(size, want)
+
+	end := off + size - 1
+	if h.size >= 0 {
+		end = min(end, h.size-1)
+	}
+
+	var data []byte
+	for resumed := false; ; resumed = true {
+		body, err := h.get(off, end)
+		if err != nil {
+			return remoteChunk{}, err
+		}
+		data, err = io.ReadAll(body)
+		body.Close()
+
+		// As with Read, retry once if the connection was probably
+		// dropped.
+		if err != nil && !resumed {
+			transfers.retried()
+			continue
+		} else if err != nil {
+			return remoteChunk{}, err
+		}
+		break
+	}
+	if len(data) == 0 {
+		return remoteChunk{}, io.EOF
+	}
+
+	chunk := remoteChunk{offset: off, data: data}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.chunks = append(h.chunks, chunk)
+	h.cached += len(data)
+	for len(h.chunks) > 1 && h.cached > maxRemoteCache {
+		h.cached -= len(h.chunks[0].data)
+		h.chunks = h.chunks[1:]
+	}
+	h.nextChunk, h.lastChunk = off+int64(len(data)), size
+	return chunk, nil
+}
+
+func (h *httpInput) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += h.offset
+	case io.SeekEnd:
+		if h.size < 0 {
+			return 0, errors.New("remote file size is unknown")
+		}
+		offset += h.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	// Stop streaming the file once it's read elsewhere, like at the end of a
+	// zip, rather than leaving the whole file to be transferred.
+	if h.body != nil && offset != h.bodyOffset {
+		h.closeBody()
+	}
+	h.offset = offset
+	return offset, nil
+}
+
+func (h *httpInput) Close() error {
+	if h.body == nil {
+		return nil
+	}
+	return h.body.Close()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransferProgress(t *testing.T) {
+	const contents = "contents of the remote object"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			io.Copy(io.Discard, r.Body)
+			return
+		}
+		io.WriteString(w, contents)
+	}))
+	defer server.Close()
+
+	downloaded, uploaded := transfers.downloaded.Load(), transfers.uploaded.Load()
+
+	input, err := openURL(server.URL + "/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, input); err != nil {
+		t.Fatal(err)
+	}
+	input.Close()
+
+	output, err := createURL(context.Background(), server.URL+"/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(output, strings.Repeat(contents, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := transfers.downloaded.Load() - downloaded; got != int64(len(contents)) {
+		t.Errorf("got %d bytes downloaded, want %d", got, len(contents))
+	}
+	if got := transfers.uploaded.Load() - uploaded; got != int64(2*len(contents)) {
+		t.Errorf("got %d bytes uploaded, want %d", got, 2*len(contents))
+	}
+}
+
+func TestHTTPInputZipRanges(t *testing.T) {
+	// A zip of many incompressible entries, of which only one is read.
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	random := rand.New(rand.NewSource(1))
+	for i := range 64 {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("%d.bin", i), Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.CopyN(w, random, 256<<10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests, ranged atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if start, end, ok := strings.Cut(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), "-"); ok {
+			s, _ := strconv.ParseInt(start, 10, 64)
+			e, _ := strconv.ParseInt(end, 10, 64)
+			ranged.Add(e - s + 1)
+		}
+		http.ServeContent(w, r, "archive.zip", time.Time{}, bytes.NewReader(archive.Bytes()))
+	}))
+	defer server.Close()
+
+	input, err := openURL(server.URL + "/archive.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	size, err := input.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(input, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.Open("40.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(io.Discard, f); err != nil || n != 256<<10 {
+		t.Fatalf("read %d bytes of 40.bin: %v", n, err)
+	}
+	f.Close()
+
+	if got := ranged.Load(); got > int64(archive.Len()/8) {
+		t.Errorf("requested %d bytes of a %d byte zip to read one entry", got, archive.Len())
+	}
+	if got := requests.Load(); got > 8 {
+		t.Errorf("made %d requests to read one entry", got)
+	}
+}
+
+// flakyServer serves contents with range requests, dropping the connection
+// halfway through every response to a request without a range, and returns
+// the ranges that were requested.
+func flakyServer(t *testing.T, contents []byte, etag string) (*httptest.Server, *[]string) {
+	var ranges []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("Range") != "" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+			return
+		}
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+		w.Write(contents[:len(contents)/2])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	t.Cleanup(server.Close)
+	return server, &ranges
+}
+
+func TestHTTPInputResume(t *testing.T) {
+	contents := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(contents)
+	server, ranges := flakyServer(t, contents, `"v1"`)
+
+	input, err := openURL(server.URL + "/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	got, err := io.ReadAll(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, contents) {
+		t.Errorf("read %d bytes that differ from the %d byte object", len(got), len(contents))
+	}
+	if want := fmt.Sprintf("bytes=%d-", len(contents)/2); len(*ranges) != 1 || (*ranges)[0] != want {
+		t.Errorf("got requested ranges %q, want %q", *ranges, want)
+	}
+}
+
+func TestHTTPInputChanged(t *testing.T) {
+	contents := make([]byte, 1<<20)
+	server, _ := flakyServer(t, contents, `"v1"`)
+
+	input, err := openURL(server.URL + "/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	// The object is replaced before the dropped connection is resumed.
+	input.etag = `"v0"`
+	if _, err := io.ReadAll(input); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("got error %v, want the object to have changed", err)
+	}
+}
+
+func TestExtractURL(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/b", typeflag: tar.TypeReg, contents: strings.Repeat("b", 1<<20)},
+	})
+	server, ranges := flakyServer(t, archive, `"v1"`)
+
+	output := filepath.Join(t.TempDir(), "out")
+	parseCLI(t, "extract", server.URL+"/archive.tar", output)
+	if code := runCommand(t, func() { extract(context.Background()) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	want := map[string]string{"a": "/", "a/b": strings.Repeat("b", 1<<20)}
+	if got := readTree(t, output); !maps.Equal(got, want) {
+		t.Errorf("got output with %d entries, want a and a/b", len(got))
+	}
+	if len(*ranges) == 0 {
+		t.Error("the dropped connection wasn't resumed")
+	}
+}
+
+func TestURLBaseName(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"https://example.com/releases/v1/app.tar.gz?token=x": "app.tar.gz",
+		"s3://bucket/a/b.zip":                                "b.zip",
+	} {
+		if !isURL(rawURL) {
+			t.Errorf("%s isn't a URL", rawURL)
+		}
+		if got := urlBaseName(rawURL); got != want {
+			t.Errorf("%s: got %q, want %q", rawURL, got, want)
+		}
+	}
+	for _, path := range []string{"archive.tar", "./https://x", "ftp://example.com/a.zip"} {
+		if isURL(path) {
+			t.Errorf("%s is a URL", path)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// repack recompresses a compressed archive or file with another compression
+// in a single pass, streaming what the old compression decompresses to into
+// the new one, so that the archive within is kept byte for byte, and nothing
+// but the output is written to disk.
+func repack(ctx context.Context) {
+	input, err := openFile(ctx, cli.Repack.Input)
+	if err != nil {
+		bail("failed to open input file: %s", err)
+	}
+	defer closeInput(input)
+
+	inputName := cli.Repack.Input
+	if isURL(inputName) {
+		inputName = urlBaseName(inputName)
+	}
+	format, inputR, err := identify(ctx, inputName, input)
+	if err != nil {
+		bail("failed to identify format: %s", err)
+	}
+	from, ok := outerCompression(format)
+	if !ok {
+		bail("%s isn't compressed as a whole, so it can't be repacked", cli.Repack.Input)
+	}
+	to, err := lookupCompression(cli.Repack.To)
+	if err != nil {
+		bail("invalid --to: %s", err)
+	}
+	if cli.Repack.Level != nil {
+		leveled, err := squish.WithLevel(to, *cli.Repack.Level)
+		if err != nil {
+			bail("%s", err)
+		}
+		to = leveled.(archives.Compression)
+	}
+	to = withThreads(to, cli.Repack.Threads).(archives.Compression)
+
+	outputPath := cli.Repack.Output
+	if outputPath == "" {
+		outputPath = squish.OutputName(inputName, from, false) + to.Extension()
+	}
+	if !cli.Repack.Force && outputPath != stdioPath {
+		if _, err := os.Lstat(outputPath); err == nil {
+			bail("output %s already exists, use --force to replace it", outputPath)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			bail("failed to check for existing output: %s", err)
+		}
+	}
+	logger.Debug("repacking", "input", cli.Repack.Input, "from", from.Extension(), "to", to.Extension(), "output", outputPath)
+
+	decompressed, err := from.(archives.Decompressor).OpenReader(inputR)
+	if err != nil {
+		bail("failed to open decompressor: %s", err)
+	}
+	defer decompressed.Close()
+
+	// The output is only put in place once it's completely written, so the
+	// input can be replaced.
+	output := withRetries(ctx, os.Stdout)
+	commit := func() {}
+	if outputPath != stdioPath {
+		file, err := createAtomic(outputPath, "")
+		if err != nil {
+			bail("failed to create output file: %s", err)
+		}
+		output, commit = file, file.commit
+	}
+	defer func() {
+		if err := output.Close(); err != nil {
+			bail("failed to close output file: %s", err)
+		}
+	}()
+
+	compressed, err := to.(archives.Compressor).OpenWriter(output)
+	if err != nil {
+		bail("failed to open compressor: %s", err)
+	}
+	if _, err := io.Copy(compressed, contextReader{ctx, decompressed}); err != nil {
+		compressed.Close()
+		bail("failed to repack input: %s", err)
+	}
+	// The compressor is closed before committing, since that's what writes
+	// the end of the output.
+	if err := compressed.Close(); err != nil {
+		bail("failed to repack input: %s", err)
+	}
+	commit()
+}
+
+// outerCompression returns the compression that format, a compressed archive
+// or file, is compressed with as a whole, or false if it isn't.
+func outerCompression(format archives.Format) (archives.Compression, bool) {
+	if compressed, ok := format.(archives.CompressedArchive); ok {
+		format = compressed.Compression
+	}
+	compression, ok := format.(archives.Compression)
+	if !ok || compression == nil {
+		return nil, false
+	}
+	if _, ok := compression.(archives.Decompressor); !ok {
+		return nil, false
+	}
+	return compression, true
+}
+
+// lookupCompression returns the compression named by name, like zst, or that
+// of a compressed archive format like tar.zst.
+func lookupCompression(name string) (archives.Compression, error) {
+	format, err := squish.LookupFormat(name)
+	if err != nil {
+		return nil, err
+	}
+	if compressed, ok := format.(archives.CompressedArchive); ok {
+		format = compressed.Compression
+	}
+	compression, ok := format.(archives.Compression)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a compression format, like zst or xz", name)
+	}
+	if _, ok := compression.(archives.Compressor); !ok {
+		return nil, fmt.Errorf("%s compression can't be written", name)
+	}
+	return compression, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestOuterCompression(t *testing.T) {
+	tests := []struct {
+		format archives.Format
+		want   string
+		ok     bool
+	}{
+		{archives.CompressedArchive{Archival: archives.Tar{}, Extraction: archives.Tar{}, Compression: archives.Gz{}}, ".gz", true},
+		{archives.Xz{}, ".xz", true},
+		{archives.Tar{}, "", false},
+		{archives.Zip{}, "", false},
+	}
+
+	for _, test := range tests {
+		compression, ok := outerCompression(test.format)
+		if ok != test.ok {
+			t.Errorf("%s: got %t, want %t", test.format.Extension(), ok, test.ok)
+		} else if ok && compression.Extension() != test.want {
+			t.Errorf("%s: got %s, want %s", test.format.Extension(), compression.Extension(), test.want)
+		}
+	}
+}
+
+func TestLookupCompression(t *testing.T) {
+	for name, want := range map[string]string{"zst": ".zst", "gzip": ".gz", "tar.xz": ".xz", "tbz2": ".bz2"} {
+		compression, err := lookupCompression(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if compression.Extension() != want {
+			t.Errorf("%s: got %s, want %s", name, compression.Extension(), want)
+		}
+	}
+	for _, name := range []string{"zip", "tar", "nope"} {
+		if _, err := lookupCompression(name); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+// resumeStateName is the name of the file in the output directory that
+// --resume records the progress of extraction in. It's removed once
+// extraction finishes.
+const resumeStateName = ".squish-resume"
+
+// resumeCheckpoint is how much of a file is written between the records of
+// its progress.
+const resumeCheckpoint = 64 << 20
+
+// resumeRecord is a line of the state file. The first record of an entry is
+// written before it's created, those of regular files are followed by the
+// number of bytes known to have been written as they're extracted, and the
+// last record of a regular file, symbolic link or directory says that it's
+// done. When the input is remote, the state file starts with a record of its
+// URL and ETag instead, which is all together its resume token.
+type resumeRecord struct {
+	Name   string `json:"name,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+	// Input is where the entry starts in the input, if it's an
+	// uncompressed tar, so that a remote input can be requested from there
+	// when extraction is resumed.
+	Input *int64 `json:"input,omitempty"`
+	// Entry is the name in the archive of a regular file that's done, which
+	// hard links to it are resolved with.
+	Entry string `json:"entry,omitempty"`
+	// ModTime and AccessTime are the times of a directory, which are
+	// restored once extraction is finished, even if its entry isn't read
+	// again.
+	ModTime    *time.Time `json:"mtime,omitempty"`
+	AccessTime *time.Time `json:"atime,omitempty"`
+	URL        string     `json:"url,omitempty"`
+	ETag       string     `json:"etag,omitempty"`
+}
+
+// resumeState records the progress of extraction to a state file in the
+// output directory, so that extraction can be resumed after being
+// interrupted. A nil *resumeState is valid and records nothing.
+type resumeState struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	// offsets are the entries that were started by an earlier extraction,
+	// with how much of them was written, and done are the entries that were
+	// completely extracted, by their paths in the output.
+	offsets map[string]int64
+	done    map[string]bool
+	// source is the record of the remote input, which is written first,
+	// starts are where the entries that were started begin in the input,
+	// when that's known, and records are the done records of regular files
+	// and directories, which hard links and the times of directories are
+	// restored from.
+	source  resumeRecord
+	starts  map[string]int64
+	records []resumeRecord
+	// truncate is whether the state file is started over when it's opened.
+	truncate bool
+	// position, if non-nil, tracks where the entries being extracted start
+	// in the input.
+	position *tarPosition
+}
+
+// readResumeState reads the state file in the output directory, if there is
+// one, which open opens to record further progress. For a remote input, source
+// is the record of its URL and ETag, and if the state file was written for a
+// different input, or a different version of it, it's started over.
+func readResumeState(output string, source resumeRecord) (*resumeState, error) {
+	s := &resumeState{path: filepath.Join(output, resumeStateName), source: source}
+	s.reset()
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var recordedSource resumeRecord
+	var records int
+	for scanner.Scan() {
+		// The last line may have been cut off by the interruption.
+		var record resumeRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			continue
+		}
+		records++
+		switch {
+		case record.Name == "":
+			recordedSource = record
+		case record.Done:
+			s.done[record.Name] = true
+			if record.Entry != "" || record.ModTime != nil {
+				s.records = append(s.records, record)
+			}
+		default:
+			s.offsets[record.Name] = record.Offset
+		}
+		if _, ok := s.starts[record.Name]; !ok && record.Input != nil {
+			s.starts[record.Name] = *record.Input
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if records > 0 && (recordedSource.URL != source.URL || recordedSource.ETag != source.ETag) {
+		warn("%s changed since extraction was interrupted, so it's extracted from the start", cmp.Or(source.URL, "the input"))
+		s.reset()
+		s.truncate = true
+	}
+	return s, nil
+}
+
+// reset forgets the progress of the earlier extraction.
+func (s *resumeState) reset() {
+	s.offsets, s.done, s.starts, s.records = map[string]int64{}, map[string]bool{}, map[string]int64{}, nil
+}
+
+// open opens the state file to record further progress, starting with the
+// record of the remote input if it's new.
+func (s *resumeState) open() error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if s.truncate {
+		flag |= os.O_TRUNC
+	}
+	var err error
+	if s.file, err = os.OpenFile(s.path, flag, 0o644); err != nil {
+		return err
+	}
+	if s.source.URL == "" {
+		return nil
+	}
+	info, err := s.file.Stat()
+	if err != nil || info.Size() > 0 {
+		return err
+	}
+	return s.record(s.source)
+}
+
+// inputOffset returns where in the input the interrupted extraction can be
+// continued from: the start of the first entry that it started but didn't
+// finish, or if it finished all of them, of the last one, which is read again
+// to find the entries after it. It returns false if that isn't known, or if
+// it's the start of the input.
+func (s *resumeState) inputOffset() (int64, bool) {
+	var unfinished int64
+	var started bool
+	for name := range s.offsets {
+		if s.done[name] {
+			continue
+		}
+		start, ok := s.starts[name]
+		if !ok {
+			return 0, false
+		}
+		if !started || start < unfinished {
+			unfinished, started = start, true
+		}
+	}
+	if started {
+		return unfinished, unfinished > 0
+	}
+
+	var last int64
+	for name := range s.done {
+		last = max(last, s.starts[name])
+	}
+	return last, last > 0
+}
+
+// continueRemote seeks remote, an uncompressed tar, to where the interrupted
+// extraction can be continued from, so that it's requested from there rather
+// than downloaded again from the start, returning the offset, or false if it
+// has to be read from the start.
+func (s *resumeState) continueRemote(remote *httpInput) (int64, bool) {
+	offset, ok := s.inputOffset()
+	if !ok || !remote.ranges || remote.etag == "" {
+		return 0, false
+	}
+	if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+		return 0, false
+	}
+	return offset, true
+}
+
+// entryStart returns where the entry being extracted starts in the input, if
+// that's known.
+func (s *resumeState) entryStart() *int64 {
+	if s == nil || s.position == nil || s.position.start < 0 {
+		return nil
+	}
+	start := s.position.start
+	return &start
+}
+
+// completed reports whether the regular file name was completely extracted.
+func (s *resumeState) completed(name string) bool {
+	return s != nil && s.done[filepath.ToSlash(name)]
+}
+
+// started returns how much of name is known to have been written, if it was
+// started by an earlier extraction.
+func (s *resumeState) started(name string) (int64, bool) {
+	if s == nil {
+		return 0, false
+	}
+	offset, ok := s.offsets[filepath.ToSlash(name)]
+	return offset, ok
+}
+
+func (s *resumeState) record(record resumeRecord) error {
+	if s == nil {
+		return nil
+	}
+	record.Name = filepath.ToSlash(record.Name)
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// close closes the state file, keeping it so that extraction can be resumed.
+func (s *resumeState) close() error {
+	if s == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// finish removes the state file once extraction has finished.
+func (s *resumeState) finish() error {
+	if s == nil {
+		return nil
+	}
+	return errors.Join(s.file.Close(), os.Remove(s.path))
+}
+
+// restoreResumed restores what the interrupted extraction being resumed
+// recorded about the entries it finished, which may not be read again: the
+// regular files that hard links can be resolved to, and, if it's continued
+// partway through the input, the directories whose times finish restores.
+func (e *entryExtractor) restoreResumed(continued bool) {
+	for _, record := range e.resume.records {
+		name := filepath.FromSlash(record.Name)
+		if record.Entry != "" {
+			if e.linkTargets == nil {
+				e.linkTargets = map[string]string{}
+			}
+			e.linkTargets[path.Clean(record.Entry)] = name
+		}
+		if record.ModTime != nil && continued && e.times {
+			header := &tar.Header{Typeflag: tar.TypeDir, Name: record.Name, Mode: 0o755, ModTime: *record.ModTime}
+			if record.AccessTime != nil {
+				header.AccessTime = *record.AccessTime
+			}
+			e.dirs = append(e.dirs, archives.FileInfo{FileInfo: header.FileInfo(), Header: header, NameInArchive: name})
+		}
+	}
+}
+
+// tarPosition tracks where each entry of an uncompressed tar read through it
+// starts, which is where the contents of the entry before it end, since tar
+// readers don't read further than the header of the entry they're at until
+// its contents are read.
+type tarPosition struct {
+	r    io.Reader
+	read int64
+	// start is where the current entry starts, and next is where the entry
+	// after it does, which are -1 once they aren't known.
+	start, next int64
+}
+
+// newTarPosition returns a tarPosition reading r, which starts offset bytes
+// into the input, at the start of an entry.
+func newTarPosition(r io.Reader, offset int64) *tarPosition {
+	return &tarPosition{r: r, read: offset, start: -1, next: offset}
+}
+
+func (p *tarPosition) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	return n, err
+}
+
+// advance is called with each entry as it's read, before its contents are.
+// Where the entries after a sparse file start isn't known, since the size of
+// its contents in the archive isn't its size.
+func (p *tarPosition) advance(info archives.FileInfo) {
+	p.start = p.next
+	header, ok := info.Header.(*tar.Header)
+	if p.next < 0 || !ok || isSparse(header) {
+		p.next = -1
+		return
+	}
+	// Links, directories and special files have no contents, whatever
+	// their sizes say.
+	size := header.Size
+	switch header.Typeflag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		size = 0
+	}
+	p.next = p.read + (size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+}
+
+// resumeWriter records the progress of writing the regular file name to
+// output every resumeCheckpoint bytes, once they've been synced.
+type resumeWriter struct {
+	io.Writer
+	output *os.File
+	state  *resumeState
+	name   string
+	offset int64
+	next   int64
+}
+
+func (w *resumeWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.offset += int64(n)
+	if err == nil && w.offset >= w.next {
+		if err = w.output.Sync(); err == nil {
+			err = w.state.record(resumeRecord{Name: w.name, Offset: w.offset})
+		}
+		w.next = w.offset + resumeCheckpoint
+	}
+	return n, err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func TestResume(t *testing.T) {
+	ctx := context.Background()
+	input := t.TempDir()
+	for name, data := range map[string]string{"a": "hello world", "b": "new", "c": "c"} {
+		if err := os.WriteFile(filepath.Join(input, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := archives.FilesFromDisk(ctx, nil, map[string]string{input: "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The earlier extraction wrote part of a, which is only recorded up to
+	// where it was synced, and all of b, and didn't get to c.
+	output := t.TempDir()
+	if err := os.Mkdir(filepath.Join(output, "in"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"in/a": "hello wXXXXXXXXXXX", "in/b": "old"} {
+		if err := os.WriteFile(filepath.Join(output, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := `{"name":"in/a"}` + "\n" + `{"name":"in/a","offset":6}` + "\n" +
+		`{"name":"in/b"}` + "\n" + `{"name":"in/b","done":true}` + "\n" + `{"name":"in/c"`
+	if err := os.WriteFile(filepath.Join(output, resumeStateName), []byte(state), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resume, err := readResumeState(output, resumeRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resume.open(); err != nil {
+		t.Fatal(err)
+	}
+	e := &entryExtractor{root: pathRoot(output), dirMode: fs.ModeDir | 0o755, overwrite: "never", resume: resume}
+	for _, file := range files {
+		if err := e.extract(ctx, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := resume.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"in/a": "hello world", "in/b": "old", "in/c": "c"} {
+		if data, err := os.ReadFile(filepath.Join(output, name)); err != nil || string(data) != want {
+			t.Errorf("got %q, %v for %s, want %q", data, err, name, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(output, resumeStateName)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("state file wasn't removed: %v", err)
+	}
+}
+
+func TestResumeRemote(t *testing.T) {
+	contents := map[string]string{
+		"d/a": strings.Repeat("a", 64<<10),
+		"d/b": strings.Repeat("b", 64<<10),
+		"d/c": strings.Repeat("c", 64<<10),
+		"e":   strings.Repeat("e", 64<<10),
+	}
+	archive := makeTar(t, []testEntry{
+		{name: "d/", typeflag: tar.TypeDir},
+		{name: "d/a", typeflag: tar.TypeReg, contents: contents["d/a"]},
+		{name: "d/b", typeflag: tar.TypeReg, contents: contents["d/b"]},
+		{name: "d/c", typeflag: tar.TypeReg, contents: contents["d/c"]},
+		{name: "d/h", typeflag: tar.TypeLink, linkname: "d/a"},
+		{name: "e", typeflag: tar.TypeReg, contents: contents["e"]},
+	})
+
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range")+" if "+r.Header.Get("If-Range"))
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "archive.tar", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer server.Close()
+	url := server.URL + "/archive.tar"
+	source := resumeRecord{URL: url, ETag: `"v1"`}
+	output := t.TempDir()
+
+	// extractFrom extracts the remote input from offset, canceling the
+	// extraction once it reaches stop, returning the entries it read.
+	extractFrom := func(resume *resumeState, input *httpInput, offset int64, continued bool, stop string) ([]string, error) {
+		if err := resume.open(); err != nil {
+			t.Fatal(err)
+		}
+		e := &entryExtractor{root: pathRoot(output), dirMode: fs.ModeDir | 0o755, overwrite: "never", times: true, resume: resume}
+		e.restoreResumed(continued)
+		resume.position = newTarPosition(input, offset)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var read []string
+		err := archives.Tar{}.Extract(ctx, resume.position, func(ctx context.Context, info archives.FileInfo) error {
+			resume.position.advance(info)
+			read = append(read, info.NameInArchive)
+			if info.NameInArchive == stop {
+				cancel()
+			}
+			return e.extract(ctx, info)
+		})
+		if err == nil {
+			err = e.finish()
+		}
+		return read, err
+	}
+
+	// The first extraction is interrupted partway through d/c.
+	input, err := openURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	resume, err := readResumeState(output, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractFrom(resume, input, 0, false, "d/c"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want the extraction to be interrupted", err)
+	}
+	if err := resume.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A different version of the input can't be continued.
+	if changed, err := readResumeState(output, resumeRecord{URL: url, ETag: `"v2"`}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := changed.inputOffset(); ok {
+		t.Error("the state of a different version of the input was kept")
+	}
+
+	// The second continues from the header of d/c, which comes after that of
+	// d and the headers and contents of d/a and d/b.
+	input, err = openURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	if resume, err = readResumeState(output, source); err != nil {
+		t.Fatal(err)
+	}
+	offset, ok := resume.continueRemote(input)
+	if want := int64(512 + 2*(512+64<<10)); !ok || offset != want {
+		t.Fatalf("continued from %d, %t, want %d", offset, ok, want)
+	}
+	read, err := extractFrom(resume, input, offset, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resume.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"d/c", "d/h", "e"}; !slices.Equal(read, want) {
+		t.Errorf("read %q when continuing, want %q", read, want)
+	}
+	if want := []string{"bytes=132608- if \"v1\""}; !slices.Equal(ranges, want) {
+		t.Errorf("got range requests %q, want %q", ranges, want)
+	}
+	for name, want := range contents {
+		if data, err := os.ReadFile(filepath.Join(output, name)); err != nil || string(data) != want {
+			t.Errorf("%s wasn't extracted: %v", name, err)
+		}
+	}
+	a, err := os.Stat(filepath.Join(output, "d", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, err := os.Stat(filepath.Join(output, "d", "h")); err != nil || !os.SameFile(a, h) {
+		t.Errorf("d/h isn't a hard link to d/a: %v", err)
+	}
+	// The times of d are restored, although it wasn't read again.
+	if d, err := os.Stat(filepath.Join(output, "d")); err != nil || !d.ModTime().Equal(time.Unix(0, 0)) {
+		t.Errorf("got modification time %v, %v for d, want the one in the archive", d.ModTime(), err)
+	}
+	if _, err := os.Stat(filepath.Join(output, resumeStateName)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("state file wasn't removed: %v", err)
+	}
+}
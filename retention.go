@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// retention is how many backups --keep, --keep-daily, --keep-weekly and
+// --keep-monthly keep.
+type retention struct {
+	last, daily, weekly, monthly int
+}
+
+func (r retention) enabled() bool {
+	return r.last > 0 || r.daily > 0 || r.weekly > 0 || r.monthly > 0
+}
+
+// backup is an archive in the same set of backups as the output.
+type backup struct {
+	name    string
+	modTime time.Time
+}
+
+// backupPattern matches the names of the backups in the same set as output,
+// which was expanded from template. If the name in template has placeholders,
+// that's what it expands to, and otherwise it's the names like output's but
+// for their digits, like the dates in backup-2006-01-02.tar.zst. It returns
+// false if neither has any.
+func backupPattern(template, output string) (*regexp.Regexp, bool) {
+	if pattern, ok := templatePattern(filepath.Base(template)); ok {
+		return pattern, true
+	}
+	base := filepath.Base(output)
+	digits := regexp.MustCompile(`[0-9]+`)
+	if !digits.MatchString(base) {
+		return nil, false
+	}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, match := range digits.FindAllStringIndex(base, -1) {
+		pattern.WriteString(regexp.QuoteMeta(base[last:match[0]]) + "[0-9]+")
+		last = match[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(base[last:]) + "$")
+	return regexp.MustCompile(pattern.String()), true
+}
+
+// retained returns the names of the backups that r keeps: the newest r.last,
+// and the newest of each of the r.daily, r.weekly and r.monthly most recent
+// days, weeks and months with any, in local time, like restic forget.
+func (r retention) retained(backups []backup) map[string]bool {
+	backups = slices.Clone(backups)
+	slices.SortStableFunc(backups, func(a, b backup) int { return b.modTime.Compare(a.modTime) })
+
+	kept := map[string]bool{}
+	for _, b := range backups[:min(r.last, len(backups))] {
+		kept[b.name] = true
+	}
+	for _, period := range []struct {
+		n   int
+		key func(time.Time) string
+	}{
+		{r.daily, func(t time.Time) string { return t.Format(time.DateOnly) }},
+		{r.weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{r.monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	} {
+		seen := map[string]bool{}
+		for _, b := range backups {
+			key := period.key(b.modTime.Local())
+			if len(seen) == period.n {
+				break
+			}
+			if !seen[key] {
+				seen[key] = true
+				kept[b.name] = true
+			}
+		}
+	}
+	return kept
+}
+
+// pruneBackups deletes the backups beside output whose names match pattern
+// that r doesn't keep, along with the files beside them whose names start
+// with theirs, like their manifests and indexes. The output itself is always
+// kept.
+func pruneBackups(output string, pattern *regexp.Regexp, r retention) {
+	dir := filepath.Dir(output)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		warn("failed to find old backups: %s", err)
+		return
+	}
+
+	var backups []backup
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !pattern.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			warn("failed to find old backups: %s", err)
+			continue
+		}
+		backups = append(backups, backup{entry.Name(), info.ModTime()})
+	}
+
+	kept := r.retained(backups)
+	kept[filepath.Base(output)] = true
+	for _, entry := range entries {
+		name := entry.Name()
+		i := slices.IndexFunc(backups, func(b backup) bool {
+			return name == b.name || strings.HasPrefix(name, b.name+".") && !pattern.MatchString(name)
+		})
+		if i < 0 || kept[backups[i].name] {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			warn("failed to delete old backup: %s", err)
+			continue
+		}
+		logMessage(slog.LevelInfo, nil, "deleted old backup %s", path)
+	}
+}
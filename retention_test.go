@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBackupPattern(t *testing.T) {
+	pattern, ok := backupPattern("dir/backup-2026-01-02.tar.zst", "dir/backup-2026-01-02.tar.zst")
+	if !ok {
+		t.Fatal("got no pattern")
+	}
+	for name, want := range map[string]bool{
+		"backup-2026-01-02.tar.zst":        true,
+		"backup-2025-12-31.tar.zst":        true,
+		"backup-2025-12-31.tar.zst.sha256": false,
+		"backup-2025-12.tar.zst":           false,
+		"other-2025-12-31.tar.zst":         false,
+		"backup-2025-12-31Xtar.zst":        false,
+	} {
+		if got := pattern.MatchString(name); got != want {
+			t.Errorf("%s: got %t, want %t", name, got, want)
+		}
+	}
+
+	if _, ok := backupPattern("backup.tar.zst", "backup.tar.zst"); ok {
+		t.Error("got a pattern for a name without digits")
+	}
+
+	pattern, ok = backupPattern("{hostname}/backup-{date}.tar.zst", "host/backup-2026-01-02.tar.zst")
+	if !ok {
+		t.Fatal("got no pattern for a template")
+	}
+	if !pattern.MatchString("backup-yesterday.tar.zst") || pattern.MatchString("backup-2026-01-02.tar.zst.sha256") {
+		t.Error("the template's pattern didn't match the names it expands to")
+	}
+}
+
+func TestRetained(t *testing.T) {
+	day := func(month time.Month, day int) time.Time { return time.Date(2026, month, day, 12, 0, 0, 0, time.Local) }
+	backups := []backup{
+		{"a", day(1, 1).AddDate(0, -1, 0)},
+		{"b", day(1, 20)},
+		{"c", day(2, 10)},
+		{"d", day(2, 11)},
+		{"e", day(2, 11).Add(time.Hour)},
+		{"f", day(2, 12)},
+	}
+	for _, test := range []struct {
+		r    retention
+		want []string
+	}{
+		{retention{last: 2}, []string{"e", "f"}},
+		{retention{daily: 2}, []string{"e", "f"}},
+		{retention{daily: 3}, []string{"c", "e", "f"}},
+		{retention{weekly: 2}, []string{"b", "f"}},
+		{retention{monthly: 2}, []string{"b", "f"}},
+		{retention{last: 1, monthly: 3}, []string{"a", "b", "f"}},
+		{retention{last: 10}, []string{"a", "b", "c", "d", "e", "f"}},
+	} {
+		var got []string
+		for name := range test.r.retained(backups) {
+			got = append(got, name)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%+v: got %v, want %v", test.r, got, test.want)
+		}
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	for i, name := range []string{"b-1.tar", "b-1.tar.sha256", "b-2.tar", "b-3.tar", "other.tar"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Unix(int64(i), 0)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := filepath.Join(dir, "b-3.tar")
+	pattern, _ := backupPattern(output, output)
+	pruneBackups(output, pattern, retention{last: 2})
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"b-2.tar", "b-3.tar", "other.tar"}; !slices.Equal(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
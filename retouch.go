@@ -0,0 +1,227 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func retouch(ctx context.Context) {
+	if cli.Retouch.Output == "" && isURL(cli.Retouch.Input) {
+		bail("--output must be specified when the input is a URL")
+	}
+
+	input, extractor, inputR := openExtractor(ctx, cli.Retouch.Input)
+	defer closeInput(input)
+	extractor = withPAXGlobals(extractor, &paxGlobals{})
+
+	archiver, ok := extractor.(archives.ArchiverAsync)
+	if !ok {
+		bail("identified format doesn't support rewriting archives")
+	}
+
+	if cli.Retouch.Chown != nil && !isTar(extractor) {
+		warn("ownership isn't stored by the identified format, so --chown has no effect")
+	}
+
+	// Write to a temporary file beside the output, so that the input isn't
+	// replaced until the new archive is complete.
+	outputPath := cli.Retouch.Output
+	if outputPath == "" {
+		outputPath = cli.Retouch.Input
+	}
+	output, err := os.CreateTemp(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".*")
+	if err != nil {
+		bail("failed to create output file: %s", err)
+	}
+	defer func() {
+		// This fails harmlessly if the output was already renamed.
+		os.Remove(output.Name())
+	}()
+
+	perm := fs.FileMode(0o644)
+	if info, err := os.Stat(outputPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := output.Chmod(perm); err != nil {
+		output.Close()
+		bail("failed to set output file permissions: %s", err)
+	}
+
+	// The archivers close the writers that finish the archive, like the
+	// central directory of a zip, in a defer and drop their errors, so a
+	// failure to write them is only seen here.
+	written := &stickyWriter{w: output}
+	jobs := make(chan archives.ArchiveAsyncJob)
+	archiveErr := make(chan error, 1)
+	go func() {
+		archiveErr <- archiver.ArchiveAsync(ctx, written, jobs)
+	}()
+
+	result := make(chan error)
+	err = extractor.Extract(ctx, inputR, func(ctx context.Context, info archives.FileInfo) error {
+		if retouchMatches(cli.Retouch.Patterns, info.NameInArchive) {
+			info.FileInfo = retouchedInfo{info.FileInfo}
+		}
+
+		jobs <- archives.ArchiveAsyncJob{File: info, Result: result}
+		return <-result
+	})
+	close(jobs)
+	if archiveErr := <-archiveErr; err == nil {
+		err = archiveErr
+	}
+	if err == nil {
+		err = written.err
+	}
+	if err != nil {
+		output.Close()
+		bail("failed to rewrite archive: %s", err)
+	}
+
+	if err := output.Close(); err != nil {
+		bail("failed to close output file: %s", err)
+	}
+	if err := os.Rename(output.Name(), outputPath); err != nil {
+		bail("failed to replace output file: %s", err)
+	}
+}
+
+// stickyWriter is an io.Writer that records the first error returned by w,
+// and fails every write after it.
+type stickyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *stickyWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.w.Write(p)
+	s.err = err
+	return n, err
+}
+
+// retouchMatches reports whether name matches any of patterns, or patterns is
+// empty. Patterns containing a slash are matched against the whole name, and
+// others are matched against its last element.
+func retouchMatches(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	name = strings.TrimSuffix(name, "/")
+	for _, pattern := range patterns {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isTar(format any) bool {
+	if compressed, ok := format.(archives.CompressedArchive); ok {
+		format = compressed.Extraction
+	}
+	switch format.(type) {
+	case archives.Tar, globalTar:
+		return true
+	}
+	return false
+}
+
+// retouchedInfo applies the changes requested by the retouch flags to the
+// metadata of an entry.
+type retouchedInfo struct {
+	fs.FileInfo
+}
+
+func (i retouchedInfo) Mode() fs.FileMode {
+	return cli.Retouch.Chmod.apply(i.FileInfo.Mode())
+}
+
+func (i retouchedInfo) ModTime() time.Time {
+	if cli.Retouch.SetMtime == nil {
+		return i.FileInfo.ModTime()
+	}
+	return time.Time(*cli.Retouch.SetMtime)
+}
+
+// Sys returns a copy of the original tar header with the new ownership, since
+// that's where archives.Tar takes it from. Other fields of the header that
+// are also returned by FileInfo's methods are taken from those instead.
+func (i retouchedInfo) Sys() any {
+	header, ok := i.FileInfo.Sys().(*tar.Header)
+	if !ok || cli.Retouch.Chown == nil {
+		return i.FileInfo.Sys()
+	}
+
+	copied := *header
+	header = &copied
+	header.Uid, header.Gid = cli.Retouch.Chown.uid, cli.Retouch.Chown.gid
+	header.Uname, header.Gname = "", ""
+	return header
+}
+
+// owner is a flag value holding a numeric UID:GID pair.
+type owner struct {
+	uid, gid int
+}
+
+func (o *owner) UnmarshalText(text []byte) error {
+	uidStr, gidStr, ok := strings.Cut(string(text), ":")
+	if !ok {
+		return fmt.Errorf("invalid owner %q, expected UID:GID", text)
+	}
+
+	uid, err := strconv.Atoi(uidStr)
+	if err != nil || uid < 0 {
+		return fmt.Errorf("invalid UID %q", uidStr)
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil || gid < 0 {
+		return fmt.Errorf("invalid GID %q", gidStr)
+	}
+
+	*o = owner{uid: uid, gid: gid}
+	return nil
+}
+
+// timestamp is a flag value holding a time in RFC 3339 format, as a date, or as
+// seconds since the Unix epoch prefixed with @.
+type timestamp time.Time
+
+func (t *timestamp) UnmarshalText(text []byte) error {
+	str := string(text)
+	if seconds, ok := strings.CutPrefix(str, "@"); ok {
+		unix, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid time %q", str)
+		}
+		*t = timestamp(time.Unix(unix, 0))
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if parsed, err := time.Parse(layout, str); err == nil {
+			*t = timestamp(parsed)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid time %q", str)
+}
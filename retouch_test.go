@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// retouchEntry is the metadata and contents of an entry of an archive that
+// retouch may change.
+type retouchEntry struct {
+	contents string
+	mode     fs.FileMode
+	mtime    time.Time
+	uid, gid int
+}
+
+// writeRetouchInput writes entries to path as a tar, tar.gz or zip, depending
+// on its extension.
+func writeRetouchInput(t *testing.T, path string, entries map[string]retouchEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	if strings.HasSuffix(path, ".zip") {
+		w := zip.NewWriter(&buf)
+		for name, entry := range entries {
+			header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: entry.mtime}
+			header.SetMode(entry.mode)
+			f, err := w.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte(entry.contents))
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		var out io.WriteCloser = nopWriteCloser{&buf}
+		if strings.HasSuffix(path, ".gz") {
+			out = gzip.NewWriter(&buf)
+		}
+		w := tar.NewWriter(out)
+		for name, entry := range entries {
+			header, err := tar.FileInfoHeader(fakeFileInfo{name: name, entry: entry}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			header.Name, header.Uid, header.Gid = name, entry.uid, entry.gid
+			if err := w.WriteHeader(header); err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(entry.contents))
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := out.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeFileInfo describes a retouchEntry to tar.FileInfoHeader.
+type fakeFileInfo struct {
+	name  string
+	entry retouchEntry
+}
+
+func (i fakeFileInfo) Name() string       { return filepath.Base(i.name) }
+func (i fakeFileInfo) Size() int64        { return int64(len(i.entry.contents)) }
+func (i fakeFileInfo) Mode() fs.FileMode  { return i.entry.mode }
+func (i fakeFileInfo) ModTime() time.Time { return i.entry.mtime }
+func (i fakeFileInfo) IsDir() bool        { return i.entry.mode.IsDir() }
+func (i fakeFileInfo) Sys() any           { return nil }
+
+// readRetouchOutput reads the entries of the tar, tar.gz or zip at path.
+func readRetouchOutput(t *testing.T, path string) map[string]retouchEntry {
+	t.Helper()
+	entries := map[string]retouchEntry{}
+	if strings.HasSuffix(path, ".zip") {
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries[f.Name] = retouchEntry{contents: string(contents), mode: f.Mode(), mtime: f.Modified.UTC()}
+		}
+		return entries
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		if in, err = gzip.NewReader(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := tar.NewReader(in)
+	for {
+		header, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[header.Name] = retouchEntry{
+			contents: string(contents),
+			mode:     header.FileInfo().Mode(),
+			mtime:    header.ModTime.UTC(),
+			uid:      header.Uid,
+			gid:      header.Gid,
+		}
+	}
+	return entries
+}
+
+func TestRetouch(t *testing.T) {
+	mtime := time.Unix(1.5e9, 0).UTC()
+	input := map[string]retouchEntry{
+		"a.txt":   {contents: "a", mode: 0o644, mtime: mtime, uid: 5, gid: 6},
+		"b.bin":   {contents: strings.Repeat("b", 1000), mode: 0o755, mtime: mtime, uid: 5, gid: 6},
+		"d/c.txt": {contents: "c", mode: 0o644, mtime: mtime, uid: 5, gid: 6},
+	}
+	retouched := time.Unix(1e9, 0).UTC()
+
+	for _, ext := range []string{".tar", ".tar.gz", ".zip"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "in"+ext)
+			writeRetouchInput(t, path, input)
+
+			parseCLI(t, "retouch", "--set-mtime", "@1000000000", "--chmod", "go-r", "--chown", "1:2", path, "*.txt")
+			if code := runCommand(t, func() { retouch(context.Background()) }); code != 0 {
+				t.Fatalf("got exit code %d", code)
+			}
+
+			// Only the entries matching the pattern are changed, and
+			// their contents never are. Zips don't store ownership.
+			want := maps.Clone(input)
+			for _, name := range []string{"a.txt", "d/c.txt"} {
+				entry := want[name]
+				entry.mtime, entry.mode = retouched, 0o600
+				if ext != ".zip" {
+					entry.uid, entry.gid = 1, 2
+				}
+				want[name] = entry
+			}
+			if ext == ".zip" {
+				for name, entry := range want {
+					entry.uid, entry.gid = 0, 0
+					want[name] = entry
+				}
+			}
+			if got := readRetouchOutput(t, path); !maps.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n < len(p) {
+		written := w.n
+		w.n = 0
+		return written, errors.New("no space left on device")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestStickyWriter(t *testing.T) {
+	w := &stickyWriter{w: &failingWriter{n: 3}}
+	if n, err := w.Write([]byte("ab")); n != 2 || err != nil {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if _, err := w.Write([]byte("cd")); err == nil {
+		t.Fatal("got no error past the end of the space")
+	}
+	// Later writes fail too, even if the writer would accept them, so the
+	// error is kept for after the archiver drops it.
+	if _, err := w.Write([]byte("e")); err == nil || w.err == nil {
+		t.Errorf("got %v, %v after an error", err, w.err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// retry calls op until it succeeds, fails with a non-transient error, or has
+// been retried --retries times, doubling the delay between attempts starting
+// from --retry-delay. Waiting for the next attempt stops once ctx is done, in
+// which case the last error is returned.
+func retry(ctx context.Context, op func() error) error {
+	delay := cli.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= cli.Retries || !isTransient(err) {
+			return err
+		}
+
+		logger.Debug("retrying after transient error", "error", err, "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// retryTransfer is like retry, but counts retries towards the transfers
+// reported by progress.
+func retryTransfer(ctx context.Context, op func() error) error {
+	attempted := false
+	return retry(ctx, func() error {
+		if attempted {
+			transfers.retried()
+		}
+		attempted = true
+		return op()
+	})
+}
+
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EIO) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// retryingInput retries failed reads from an inputFile. All reads are
+// performed with ReadAt so a failed read can be retried from the same offset
+// regardless of how far the underlying file position advanced.
+type retryingInput struct {
+	inputFile
+	ctx    context.Context
+	offset int64
+}
+
+func (r *retryingInput) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *retryingInput) ReadAt(p []byte, off int64) (n int, err error) {
+	err = retry(r.ctx, func() error {
+		m, err := r.inputFile.ReadAt(p[n:], off+int64(n))
+		n += m
+		return err
+	})
+	return n, err
+}
+
+func (r *retryingInput) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent {
+		offset, whence = r.offset+offset, io.SeekStart
+	}
+
+	offset, err := r.inputFile.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// retryingWriter retries failed writes, resuming after any bytes that were
+// written before the failure.
+type retryingWriter struct {
+	io.WriteCloser
+	ctx context.Context
+}
+
+func (w retryingWriter) Write(p []byte) (n int, err error) {
+	err = retry(w.ctx, func() error {
+		m, err := w.WriteCloser.Write(p[n:])
+		n += m
+		return err
+	})
+	return n, err
+}
+
+// withRetries wraps w with retryingWriter if retries are enabled, which stops
+// retrying once ctx is done.
+func withRetries(ctx context.Context, w io.WriteCloser) io.WriteCloser {
+	if cli.Retries == 0 {
+		return w
+	}
+
+	return retryingWriter{w, ctx}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	saved, savedDelay := cli.Retries, cli.RetryDelay
+	t.Cleanup(func() { cli.Retries, cli.RetryDelay = saved, savedDelay })
+	cli.Retries, cli.RetryDelay = 3, time.Millisecond
+
+	tests := []struct {
+		name     string
+		errs     []error
+		want     error
+		attempts int
+	}{
+		{"succeeds", []error{nil}, nil, 1},
+		{"transient", []error{syscall.EIO, syscall.ECONNRESET, nil}, nil, 3},
+		{"not transient", []error{syscall.ENOSPC}, syscall.ENOSPC, 1},
+		{"exhausted", []error{syscall.EIO, syscall.EIO, syscall.EIO, syscall.EIO}, syscall.EIO, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := retry(context.Background(), func() error {
+				attempts++
+				return test.errs[attempts-1]
+			})
+			if !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+				t.Errorf("got error %v, want %v", err, test.want)
+			}
+			if attempts != test.attempts {
+				t.Errorf("got %d attempts, want %d", attempts, test.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	saved, savedDelay := cli.Retries, cli.RetryDelay
+	t.Cleanup(func() { cli.Retries, cli.RetryDelay = saved, savedDelay })
+	cli.Retries, cli.RetryDelay = 3, time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	done := make(chan error)
+	go func() {
+		done <- retry(ctx, func() error { return syscall.EIO })
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, syscall.EIO) {
+			t.Errorf("got error %v, want the last one", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting for the next attempt didn't stop when the context was canceled")
+	}
+}
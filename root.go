@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// extractRoot creates extracted entries beneath the output directory. Names
+// are relative to the output directory, and must be local.
+type extractRoot interface {
+	Mkdir(name string, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error)
+	// Symlink creates name as a symbolic link to target.
+	Symlink(target, name string) error
+	// Link creates name as a hard link to the existing file target, which
+	// is also relative to the output directory.
+	Link(target, name string) error
+	// Mknod creates name as the named pipe or device given by mode, with the
+	// device number given by major and minor.
+	Mknod(name string, mode fs.FileMode, major, minor int64) error
+	// Lstat returns information about name without following it if it's a
+	// symbolic link.
+	Lstat(name string) (fs.FileInfo, error)
+	// Remove removes name, which mustn't be a directory.
+	Remove(name string) error
+	// RemoveDir removes the empty directory name.
+	RemoveDir(name string) error
+	// Chtimes changes the access and modification times of name, leaving
+	// either unchanged if it's zero.
+	Chtimes(name string, atime, mtime time.Time) error
+	// Lchown changes the numeric owner and group of name without following
+	// it if it's a symbolic link.
+	Lchown(name string, uid, gid int) error
+	// Lsetxattr sets the extended attribute attr of name without following
+	// it if it's a symbolic link.
+	Lsetxattr(name, attr string, value []byte) error
+}
+
+// pathRoot is an extractRoot that joins names to the path of the output
+// directory, relying on the names having been cleaned to stay beneath it.
+type pathRoot string
+
+func (r pathRoot) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(filepath.Join(string(r), name), perm)
+}
+
+func (r pathRoot) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(filepath.Join(string(r), name), perm)
+}
+
+func (r pathRoot) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	return os.OpenFile(filepath.Join(string(r), name), flag, perm)
+}
+
+func (r pathRoot) Symlink(target, name string) error {
+	return os.Symlink(target, filepath.Join(string(r), name))
+}
+
+func (r pathRoot) Link(target, name string) error {
+	return os.Link(filepath.Join(string(r), target), filepath.Join(string(r), name))
+}
+
+func (r pathRoot) Mknod(name string, mode fs.FileMode, major, minor int64) error {
+	return mknod(filepath.Join(string(r), name), mode, major, minor)
+}
+
+func (r pathRoot) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(filepath.Join(string(r), name))
+}
+
+func (r pathRoot) Remove(name string) error {
+	return os.Remove(filepath.Join(string(r), name))
+}
+
+func (r pathRoot) RemoveDir(name string) error {
+	path := filepath.Join(string(r), name)
+	if err := syscall.Rmdir(path); err != nil {
+		return &fs.PathError{Op: "rmdir", Path: path, Err: err}
+	}
+	return nil
+}
+
+func (r pathRoot) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(filepath.Join(string(r), name), atime, mtime)
+}
+
+func (r pathRoot) Lchown(name string, uid, gid int) error {
+	return os.Lchown(filepath.Join(string(r), name), uid, gid)
+}
+
+func (r pathRoot) Lsetxattr(name, attr string, value []byte) error {
+	return lsetxattr(filepath.Join(string(r), name), attr, value)
+}
+
+// absoluteRoot is an extractRoot for --absolute-names, which writes names that
+// are absolute paths where they say, and any others beneath the extractRoot it
+// wraps.
+type absoluteRoot struct {
+	extractRoot
+}
+
+func (r absoluteRoot) rootOf(name string) extractRoot {
+	if filepath.IsAbs(name) {
+		return pathRoot("")
+	}
+	return r.extractRoot
+}
+
+func (r absoluteRoot) Mkdir(name string, perm fs.FileMode) error {
+	return r.rootOf(name).Mkdir(name, perm)
+}
+
+func (r absoluteRoot) MkdirAll(name string, perm fs.FileMode) error {
+	return r.rootOf(name).MkdirAll(name, perm)
+}
+
+func (r absoluteRoot) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	return r.rootOf(name).OpenFile(name, flag, perm)
+}
+
+func (r absoluteRoot) Symlink(target, name string) error {
+	return r.rootOf(name).Symlink(target, name)
+}
+
+// Link links names and targets that are both absolute, or both beneath the
+// output directory.
+func (r absoluteRoot) Link(target, name string) error {
+	if filepath.IsAbs(target) != filepath.IsAbs(name) {
+		return &os.LinkError{Op: "link", Old: target, New: name, Err: errors.New("hard links between absolute and relative names aren't supported")}
+	}
+	return r.rootOf(name).Link(target, name)
+}
+
+func (r absoluteRoot) Mknod(name string, mode fs.FileMode, major, minor int64) error {
+	return r.rootOf(name).Mknod(name, mode, major, minor)
+}
+
+func (r absoluteRoot) Lstat(name string) (fs.FileInfo, error) {
+	return r.rootOf(name).Lstat(name)
+}
+
+func (r absoluteRoot) Remove(name string) error {
+	return r.rootOf(name).Remove(name)
+}
+
+func (r absoluteRoot) RemoveDir(name string) error {
+	return r.rootOf(name).RemoveDir(name)
+}
+
+func (r absoluteRoot) Chtimes(name string, atime, mtime time.Time) error {
+	return r.rootOf(name).Chtimes(name, atime, mtime)
+}
+
+func (r absoluteRoot) Lchown(name string, uid, gid int) error {
+	return r.rootOf(name).Lchown(name, uid, gid)
+}
+
+func (r absoluteRoot) Lsetxattr(name, attr string, value []byte) error {
+	return r.rootOf(name).Lsetxattr(name, attr, value)
+}
@@ -0,0 +1,300 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Constants from include/uapi/asm-generic/fcntl.h, include/uapi/linux/fcntl.h
+// and include/uapi/linux/stat.h, which the syscall package doesn't define.
+const (
+	oPath             = 0x200000
+	atSymlinkNoFollow = 0x100
+	atRemoveDir       = 0x200
+	utimeOmit         = 1<<30 - 2
+)
+
+// restrictedRoot is an extractRoot that resolves every name one component at
+// a time with openat relative to a pinned directory file descriptor, never
+// following symbolic links, so that entries can't be created outside of the
+// directory regardless of their names or what's already on disk.
+type restrictedRoot struct {
+	fd   int
+	name string
+}
+
+// openRestrictedRoot pins the directory sub beneath dir, which must be local
+// to it. sub itself is resolved without following symbolic links.
+func openRestrictedRoot(dir, sub string) (*restrictedRoot, error) {
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: dir, Err: err}
+	}
+
+	root := &restrictedRoot{fd: fd, name: dir}
+	subFD, err := root.walk(sub, false, 0)
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+
+	if subFD != fd {
+		root.Close()
+	}
+	return &restrictedRoot{fd: subFD, name: filepath.Join(dir, sub)}, nil
+}
+
+// walk opens the directory name, creating any missing directories along the
+// way with perm if create is true. The caller must close the returned file
+// descriptor if it differs from r.fd.
+func (r *restrictedRoot) walk(name string, create bool, perm fs.FileMode) (int, error) {
+	if !filepath.IsLocal(name) {
+		return 0, &fs.PathError{Op: "openat", Path: name, Err: errors.New("path escapes restricted root")}
+	}
+
+	fd := r.fd
+	for _, component := range strings.Split(filepath.Clean(name), string(filepath.Separator)) {
+		if component == "." {
+			continue
+		}
+
+		if create {
+			if err := syscall.Mkdirat(fd, component, uint32(perm.Perm())); err != nil && err != syscall.EEXIST {
+				r.closeWalked(fd)
+				return 0, &fs.PathError{Op: "mkdirat", Path: name, Err: err}
+			}
+		}
+
+		next, err := syscall.Openat(fd, component, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		r.closeWalked(fd)
+		if err != nil {
+			return 0, &fs.PathError{Op: "openat", Path: name, Err: err}
+		}
+		fd = next
+	}
+
+	return fd, nil
+}
+
+func (r *restrictedRoot) closeWalked(fd int) {
+	if fd != r.fd {
+		syscall.Close(fd)
+	}
+}
+
+func (r *restrictedRoot) Mkdir(name string, perm fs.FileMode) error {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(parent)
+
+	if err := syscall.Mkdirat(parent, filepath.Base(name), uint32(perm.Perm())); err != nil {
+		return &fs.PathError{Op: "mkdirat", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (r *restrictedRoot) MkdirAll(name string, perm fs.FileMode) error {
+	fd, err := r.walk(name, true, perm)
+	if err != nil {
+		return err
+	}
+	r.closeWalked(fd)
+	return nil
+}
+
+func (r *restrictedRoot) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeWalked(parent)
+
+	fd, err := syscall.Openat(parent, filepath.Base(name), flag|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, uint32(perm.Perm()))
+	if err != nil {
+		return nil, &fs.PathError{Op: "openat", Path: name, Err: err}
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(r.name, name)), nil
+}
+
+func (r *restrictedRoot) Symlink(target, name string) error {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(parent)
+
+	targetP, err := syscall.BytePtrFromString(target)
+	if err != nil {
+		return &fs.PathError{Op: "symlinkat", Path: name, Err: err}
+	}
+	base, err := syscall.BytePtrFromString(filepath.Base(name))
+	if err != nil {
+		return &fs.PathError{Op: "symlinkat", Path: name, Err: err}
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_SYMLINKAT, uintptr(unsafe.Pointer(targetP)), uintptr(parent), uintptr(unsafe.Pointer(base))); errno != 0 {
+		return &fs.PathError{Op: "symlinkat", Path: name, Err: errno}
+	}
+	return nil
+}
+
+func (r *restrictedRoot) Link(target, name string) error {
+	targetParent, err := r.walk(filepath.Dir(target), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(targetParent)
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(parent)
+
+	// Without AT_SYMLINK_FOLLOW, a target that's a symbolic link is linked
+	// to itself, rather than followed.
+	targetBase, err := syscall.BytePtrFromString(filepath.Base(target))
+	if err != nil {
+		return &fs.PathError{Op: "linkat", Path: name, Err: err}
+	}
+	base, err := syscall.BytePtrFromString(filepath.Base(name))
+	if err != nil {
+		return &fs.PathError{Op: "linkat", Path: name, Err: err}
+	}
+	if _, _, errno := syscall.Syscall6(syscall.SYS_LINKAT, uintptr(targetParent), uintptr(unsafe.Pointer(targetBase)), uintptr(parent), uintptr(unsafe.Pointer(base)), 0, 0); errno != 0 {
+		return &fs.PathError{Op: "linkat", Path: name, Err: errno}
+	}
+	return nil
+}
+
+func (r *restrictedRoot) Mknod(name string, mode fs.FileMode, major, minor int64) error {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(parent)
+
+	if err := syscall.Mknodat(parent, filepath.Base(name), unixFileType(mode)|uint32(mode.Perm()), mkdev(major, minor)); err != nil {
+		return &fs.PathError{Op: "mknodat", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (r *restrictedRoot) Lstat(name string) (fs.FileInfo, error) {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeWalked(parent)
+
+	fd, err := syscall.Openat(parent, filepath.Base(name), oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "openat", Path: name, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), filepath.Join(r.name, name))
+	defer f.Close()
+	return f.Stat()
+}
+
+func (r *restrictedRoot) Remove(name string) error {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(parent)
+
+	if err := syscall.Unlinkat(parent, filepath.Base(name)); err != nil {
+		return &fs.PathError{Op: "unlinkat", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (r *restrictedRoot) RemoveDir(name string) error {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(parent)
+
+	base, err := syscall.BytePtrFromString(filepath.Base(name))
+	if err != nil {
+		return &fs.PathError{Op: "unlinkat", Path: name, Err: err}
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_UNLINKAT, uintptr(parent), uintptr(unsafe.Pointer(base)), atRemoveDir); errno != 0 {
+		return &fs.PathError{Op: "unlinkat", Path: name, Err: errno}
+	}
+	return nil
+}
+
+func (r *restrictedRoot) Chtimes(name string, atime, mtime time.Time) error {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(parent)
+
+	var times [2]syscall.Timespec
+	for i, t := range []time.Time{atime, mtime} {
+		if t.IsZero() {
+			times[i] = syscall.Timespec{Nsec: utimeOmit}
+		} else {
+			times[i] = syscall.NsecToTimespec(t.UnixNano())
+		}
+	}
+
+	base, err := syscall.BytePtrFromString(filepath.Base(name))
+	if err != nil {
+		return &fs.PathError{Op: "utimensat", Path: name, Err: err}
+	}
+	if _, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(parent), uintptr(unsafe.Pointer(base)), uintptr(unsafe.Pointer(&times)), atSymlinkNoFollow, 0, 0); errno != 0 {
+		return &fs.PathError{Op: "utimensat", Path: name, Err: errno}
+	}
+	return nil
+}
+
+func (r *restrictedRoot) Lchown(name string, uid, gid int) error {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(parent)
+
+	if err := syscall.Fchownat(parent, filepath.Base(name), uid, gid, atSymlinkNoFollow); err != nil {
+		return &fs.PathError{Op: "fchownat", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (r *restrictedRoot) Lsetxattr(name, attr string, value []byte) error {
+	parent, err := r.walk(filepath.Dir(name), false, 0)
+	if err != nil {
+		return err
+	}
+	defer r.closeWalked(parent)
+
+	// There's no *at variant of setxattr, so name is opened instead, which
+	// fails for symbolic links. O_NONBLOCK prevents opening FIFOs from
+	// blocking.
+	fd, err := syscall.Openat(parent, filepath.Base(name), syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return &fs.PathError{Op: "openat", Path: name, Err: err}
+	}
+	defer syscall.Close(fd)
+
+	if err := fsetxattr(fd, attr, value); err != nil {
+		return &fs.PathError{Op: "fsetxattr", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (r *restrictedRoot) Close() error {
+	return syscall.Close(r.fd)
+}
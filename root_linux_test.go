@@ -0,0 +1,143 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestRestrictedRootSymlink(t *testing.T) {
+	outside := t.TempDir()
+	archive := makeTar(t, []testEntry{
+		{name: "link/evil", typeflag: tar.TypeReg, contents: "evil"},
+		{name: "file", typeflag: tar.TypeReg, contents: "evil"},
+	})
+
+	for _, name := range []string{"link", "file"} {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			output := filepath.Join(parent, "out")
+			if err := os.Mkdir(output, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			// Plant a symbolic link that leads outside of the output where
+			// the archive will write.
+			target := outside
+			if name == "file" {
+				target = filepath.Join(outside, "file")
+			}
+			if err := os.Symlink(target, filepath.Join(output, name)); err != nil {
+				t.Fatal(err)
+			}
+
+			root, err := openRestrictedRoot(parent, "out")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer root.Close()
+
+			// A link in place of a file is replaced rather than followed, but
+			// one in place of a parent directory is an error.
+			_, _, err = extractTest(t, archives.Tar{}, archive, &entryExtractor{root: root, overwrite: "always"})
+			if wantErr := name == "link"; (err != nil) != wantErr {
+				t.Errorf("got error %v, want error: %t", err, wantErr)
+			}
+
+			if got := readTree(t, outside); len(got) != 0 {
+				t.Errorf("files were written outside of the output: %v", got)
+			}
+		})
+	}
+}
+
+func TestRestrictedRootHardLink(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a/b", typeflag: tar.TypeReg, contents: "contents"},
+		{name: "c/d", typeflag: tar.TypeLink, linkname: "a/b"},
+	})
+	parent := t.TempDir()
+	output := filepath.Join(parent, "out")
+	if err := os.Mkdir(output, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	root, err := openRestrictedRoot(parent, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	if _, _, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{root: root}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, output); got["c/d"] != "contents" {
+		t.Errorf("got output %v", got)
+	}
+}
+
+func TestRestrictedRootSymlinkedOutput(t *testing.T) {
+	parent := t.TempDir()
+	if err := os.Symlink(t.TempDir(), filepath.Join(parent, "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	if root, err := openRestrictedRoot(parent, "out"); err == nil {
+		root.Close()
+		t.Error("pinning a symbolic link succeeded")
+	}
+}
+
+func TestExtractSameOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing owners requires root")
+	}
+
+	// Entries are written owned by root.
+	archive := makeTar(t, []testEntry{
+		{name: "d/", typeflag: tar.TypeDir},
+		{name: "d/f", typeflag: tar.TypeReg, contents: "f"},
+	})
+
+	for _, restricted := range []bool{false, true} {
+		e := &entryExtractor{sameOwner: true, ownerMap: map[int]int{0: 1234}, groupMap: map[int]int{0: 5678}}
+		var restrictedOutput string
+		if restricted {
+			parent := t.TempDir()
+			restrictedOutput = filepath.Join(parent, "out")
+			if err := os.Mkdir(restrictedOutput, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			root, err := openRestrictedRoot(parent, "out")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer root.Close()
+			e.root = root
+		}
+
+		_, output, err := extractTest(t, archives.Tar{}, archive, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restricted {
+			output = restrictedOutput
+		}
+
+		for _, name := range []string{"d", "d/f"} {
+			info, err := os.Lstat(filepath.Join(output, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stat := info.Sys().(*syscall.Stat_t)
+			if stat.Uid != 1234 || stat.Gid != 5678 {
+				t.Errorf("restricted %t: %s is owned by %d:%d, want 1234:5678", restricted, name, stat.Uid, stat.Gid)
+			}
+		}
+	}
+}
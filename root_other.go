@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+type restrictedRoot struct {
+	extractRoot
+}
+
+func openRestrictedRoot(_, _ string) (*restrictedRoot, error) {
+	return nil, errors.New("--restrict-to is only supported on Linux")
+}
+
+func (*restrictedRoot) Close() error {
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+const (
+	// maxPreviewBytes is the most that's read from an entry for its preview.
+	maxPreviewBytes = 4096
+	// maxPreviewLine is the length in runes beyond which preview lines are
+	// cut off.
+	maxPreviewLine = 100
+)
+
+func sample(ctx context.Context) {
+	if cli.Sample.Count < 1 {
+		bail("invalid number of entries: %d", cli.Sample.Count)
+	}
+	if cli.Sample.Lines < 0 {
+		bail("invalid number of lines: %d", cli.Sample.Lines)
+	}
+
+	seed := rand.Uint64()
+	if cli.Sample.Seed != nil {
+		seed = *cli.Sample.Seed
+	}
+
+	input, extractor, inputR := openExtractor(ctx, cli.Sample.Input)
+	defer closeInput(input)
+
+	s := newSampler(cli.Sample.Count, cli.Sample.Lines, rand.New(rand.NewPCG(seed, seed)))
+	if err := extractor.Extract(ctx, inputR, s.add); err != nil {
+		bail("failed to read archive: %s", err)
+	}
+
+	for i, entry := range s.samples() {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(entry)
+	}
+}
+
+// sampledEntry is an entry chosen by a sampler, along with its preview, or its
+// target if it's a symbolic link.
+type sampledEntry struct {
+	// index is the position of the entry in the archive, and key is the key
+	// it was chosen by.
+	index           int
+	key             float64
+	name            string
+	size            int64
+	preview, target string
+}
+
+func (e sampledEntry) String() string {
+	switch {
+	case e.target != "":
+		return fmt.Sprintf("%s -> %s", e.name, e.target)
+	case e.preview == "":
+		return fmt.Sprintf("%s (%s)", e.name, byteSize(e.size))
+	}
+	return fmt.Sprintf("%s (%s)\n%s", e.name, byteSize(e.size), e.preview)
+}
+
+// sampleHeap is a min-heap of sampled entries ordered by key.
+type sampleHeap []sampledEntry
+
+func (h sampleHeap) Len() int           { return len(h) }
+func (h sampleHeap) Less(i, j int) bool { return h[i].key < h[j].key }
+func (h sampleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x any)        { *h = append(*h, x.(sampledEntry)) }
+
+func (h *sampleHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// sampler chooses up to n of the regular files and symbolic links in an
+// archive in a single pass, using weighted reservoir sampling, so that
+// archives that can only be read once don't have to be buffered. Larger
+// files are more likely to be chosen, but only logarithmically so, so that a
+// few huge files don't crowd out everything else.
+type sampler struct {
+	n, lines int
+	rng      *rand.Rand
+	chosen   sampleHeap
+	seen     int
+}
+
+func newSampler(n, lines int, rng *rand.Rand) *sampler {
+	return &sampler{n: n, lines: lines, rng: rng}
+}
+
+// add considers info for the sample, reading its preview if it's chosen.
+func (s *sampler) add(_ context.Context, info archives.FileInfo) error {
+	weight := sampleWeight(info)
+	if weight == 0 {
+		return nil
+	}
+	index := s.seen
+	s.seen++
+
+	// Each entry's key is u^(1/weight) for a uniformly random u, and those
+	// with the largest keys are chosen, as in Efraimidis and Spirakis' A-Res
+	// algorithm. Logarithms are compared instead to avoid underflow.
+	key := math.Log(1-s.rng.Float64()) / weight
+	if len(s.chosen) == s.n && key <= s.chosen[0].key {
+		return nil
+	}
+
+	entry := sampledEntry{index: index, key: key, name: info.NameInArchive, size: info.Size()}
+	var err error
+	if info.Mode()&fs.ModeSymlink != 0 {
+		entry.target, err = squish.LinkTarget(info)
+	} else {
+		entry.preview, err = entryPreview(info, s.lines)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", info.NameInArchive, err)
+	}
+
+	if len(s.chosen) == s.n {
+		s.chosen[0] = entry
+		heap.Fix(&s.chosen, 0)
+	} else {
+		heap.Push(&s.chosen, entry)
+	}
+	return nil
+}
+
+// samples returns the chosen entries in the order they're stored in the
+// archive.
+func (s *sampler) samples() []sampledEntry {
+	samples := slices.Clone(s.chosen)
+	slices.SortFunc(samples, func(a, b sampledEntry) int {
+		return cmp.Compare(a.index, b.index)
+	})
+	return samples
+}
+
+// sampleWeight returns how likely info is to be sampled relative to other
+// entries, or 0 if it can't be, as with directories, which have no contents
+// to preview.
+func sampleWeight(info archives.FileInfo) float64 {
+	switch {
+	case info.Mode().IsRegular():
+		return math.Log2(float64(info.Size()) + 2)
+	case info.Mode()&fs.ModeSymlink != 0:
+		return 1
+	}
+	return 0
+}
+
+// entryPreview returns up to lines lines from the start of the regular file
+// info, indented, or a description of its contents if it's binary.
+func entryPreview(info archives.FileInfo, lines int) (string, error) {
+	if lines == 0 || info.Size() == 0 {
+		return "", nil
+	}
+
+	entry, err := info.Open()
+	if err != nil {
+		return "", err
+	}
+	defer entry.Close()
+
+	data, err := io.ReadAll(io.LimitReader(entry, maxPreviewBytes))
+	if err != nil {
+		return "", err
+	}
+
+	// Entries containing NUL bytes are treated as binary, as by grep.
+	if bytes.IndexByte(data, 0) >= 0 {
+		return fmt.Sprintf("    binary data starting with % x", data[:min(len(data), 16)]), nil
+	}
+
+	text := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	var preview []string
+	for _, line := range text[:min(lines, len(text))] {
+		line = strings.TrimSuffix(line, "\r")
+		if runes := []rune(line); len(runes) > maxPreviewLine {
+			line = string(runes[:maxPreviewLine]) + "..."
+		}
+		if line != "" {
+			line = "    " + line
+		}
+		preview = append(preview, line)
+	}
+	return strings.Join(preview, "\n"), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestSampler(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/b", typeflag: tar.TypeReg, contents: "one\ntwo\r\nthree\nfour\n"},
+		{name: "a/l", typeflag: tar.TypeSymlink, linkname: "b"},
+		{name: "c", typeflag: tar.TypeReg, contents: "\x7fELF\x00\x01"},
+		{name: "d", typeflag: tar.TypeReg, contents: strings.Repeat("x", maxPreviewLine+1)},
+		{name: "e", typeflag: tar.TypeReg},
+	})
+
+	s := newSampler(10, 3, rand.New(rand.NewPCG(1, 1)))
+	if err := (archives.Tar{}).Extract(context.Background(), bytes.NewReader(archive), s.add); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, entry := range s.samples() {
+		got = append(got, entry.String())
+	}
+	want := []string{
+		"a/b (20 B)\n    one\n    two\n    three",
+		"a/l -> b",
+		"c (6 B)\n    binary data starting with 7f 45 4c 46 00 01",
+		"d (101 B)\n    " + strings.Repeat("x", maxPreviewLine) + "...",
+		"e (0 B)",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got samples %q, want %q", got, want)
+	}
+}
+
+func TestSamplerCount(t *testing.T) {
+	var entries []testEntry
+	for i := range 100 {
+		entries = append(entries, testEntry{name: strings.Repeat("a", i+1), typeflag: tar.TypeReg, contents: "a"})
+	}
+	archive := makeTar(t, entries)
+
+	for seed := range uint64(10) {
+		s := newSampler(7, 0, rand.New(rand.NewPCG(seed, seed)))
+		if err := (archives.Tar{}).Extract(context.Background(), bytes.NewReader(archive), s.add); err != nil {
+			t.Fatal(err)
+		}
+
+		samples := s.samples()
+		if len(samples) != 7 {
+			t.Fatalf("seed %d: got %d samples, want 7", seed, len(samples))
+		}
+		if !slices.IsSortedFunc(samples, func(a, b sampledEntry) int { return a.index - b.index }) {
+			t.Errorf("seed %d: samples aren't in archive order: %v", seed, samples)
+		}
+	}
+}
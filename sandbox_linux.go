@@ -0,0 +1,265 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// sandboxEnv is set in the environment of the re-executed, sandboxed process.
+const sandboxEnv = "SQUISH_SANDBOXED"
+
+// prSetNoNewPrivs is from include/uapi/linux/prctl.h.
+const prSetNoNewPrivs = 38
+
+// Landlock constants, from include/uapi/linux/landlock.h.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	landlockWriteFile  = 1 << 1
+	landlockRemoveDir  = 1 << 4
+	landlockRemoveFile = 1 << 5
+	landlockMakeChar   = 1 << 6
+	landlockMakeDir    = 1 << 7
+	landlockMakeReg    = 1 << 8
+	landlockMakeSock   = 1 << 9
+	landlockMakeFifo   = 1 << 10
+	landlockMakeBlock  = 1 << 11
+	landlockMakeSym    = 1 << 12
+	landlockRefer      = 1 << 13
+	landlockTruncate   = 1 << 14
+)
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// landlockPathBeneathAttr is packed in C, but since parentFD is the last
+// field, the kernel only reads the first 12 bytes, which are laid out the
+// same way.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFD      int32
+}
+
+func inSandbox() bool {
+	return os.Getenv(sandboxEnv) != ""
+}
+
+// sandbox confines the process so that it can only modify output, which is
+// created as an empty file if it doesn't exist, and can't use syscalls that
+// extraction has no need for.
+//
+// Landlock only restricts the thread that enables it and threads created by
+// it afterwards, which doesn't include the rest of the Go runtime's threads,
+// so the first call re-executes the process from the restricted thread, and
+// doesn't return. The call in the re-executed process installs the seccomp
+// filter, which does apply to all threads, and returns.
+func sandbox(output string) {
+	if inSandbox() {
+		if err := restrictSyscalls(); err != nil {
+			bail("failed to restrict syscalls: %s", err)
+		}
+		return
+	}
+
+	outputF, err := os.Open(output)
+	if errors.Is(err, fs.ErrNotExist) {
+		outputF, err = os.Create(output)
+	}
+	if err != nil {
+		bail("failed to create output: %s", err)
+	}
+	defer func() {
+		if err := outputF.Close(); err != nil {
+			bail("failed to close output: %s", err)
+		}
+	}()
+
+	outputInfo, err := outputF.Stat()
+	if err != nil {
+		bail("failed to stat output: %s", err)
+	}
+
+	runtime.LockOSThread()
+
+	if err := restrictWrites(outputF, outputInfo.IsDir()); err != nil {
+		bail("failed to restrict writes: %s", err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		bail("failed to find executable: %s", err)
+	}
+
+	env := append(os.Environ(), sandboxEnv+"=1")
+	if history != nil {
+		fd, err := inheritable(history.file)
+		if err != nil {
+			bail("failed to pass history file to sandbox: %s", err)
+		}
+		env = append(env, historyFDEnv+"="+fd)
+	}
+	// A password that was asked for is passed through a pipe, rather than
+	// the environment, which other processes can read.
+	var passwordR *os.File
+	if cli.Extract.Password.prompt {
+		var w *os.File
+		passwordR, w, err = os.Pipe()
+		if err == nil {
+			_, err = w.WriteString(cli.Extract.Password.value)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		var fd string
+		if err == nil {
+			fd, err = inheritable(passwordR)
+		}
+		if err != nil {
+			bail("failed to pass password to sandbox: %s", err)
+		}
+		env = append(env, passwordFDEnv+"="+fd)
+	}
+	if err := syscall.Exec(executable, os.Args, env); err != nil {
+		bail("failed to re-execute in sandbox: %s", err)
+	}
+	// The pipe must not be closed by its finalizer before it's inherited.
+	runtime.KeepAlive(passwordR)
+}
+
+// inheritable makes f inherited by processes that are executed, returning its
+// file descriptor.
+func inheritable(f *os.File) (string, error) {
+	fd := f.Fd()
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_SETFD, 0); errno != 0 {
+		return "", errno
+	}
+	return strconv.FormatUint(uint64(fd), 10), nil
+}
+
+// restrictWrites uses Landlock to prevent the current thread, and any process
+// it executes, from modifying anything other than output.
+func restrictWrites(output *os.File, dir bool) error {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno == syscall.ENOSYS || errno == syscall.EOPNOTSUPP {
+		return errors.New("Landlock is not supported or not enabled by the running kernel")
+	} else if errno != 0 {
+		return fmt.Errorf("failed to get Landlock ABI version: %w", errno)
+	}
+
+	handled := uint64(landlockWriteFile | landlockRemoveDir | landlockRemoveFile | landlockMakeChar | landlockMakeDir |
+		landlockMakeReg | landlockMakeSock | landlockMakeFifo | landlockMakeBlock | landlockMakeSym)
+	if abi >= 2 {
+		handled |= landlockRefer
+	}
+	if abi >= 3 {
+		handled |= landlockTruncate
+	}
+
+	allowed := handled &^ (landlockMakeChar | landlockMakeSock | landlockMakeFifo | landlockMakeBlock)
+	if !dir {
+		allowed &= landlockWriteFile | landlockTruncate
+	}
+
+	attr := landlockRulesetAttr{handledAccessFS: handled}
+	rulesetFD, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create Landlock ruleset: %w", errno)
+	}
+	defer syscall.Close(int(rulesetFD))
+
+	rule := landlockPathBeneathAttr{allowedAccess: allowed, parentFD: int32(output.Fd())}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, rulesetFD, landlockRulePathBeneath, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to add Landlock rule: %w", errno)
+	}
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %w", errno)
+	}
+
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, rulesetFD, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce Landlock ruleset: %w", errno)
+	}
+
+	return nil
+}
+
+// BPF and seccomp constants, from include/uapi/linux/filter.h and
+// include/uapi/linux/seccomp.h.
+const (
+	bpfLdWAbs = 0x20
+	bpfJeqK   = 0x15
+	bpfJgeK   = 0x35
+	bpfRetK   = 0x06
+
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+
+	seccompDataNr   = 0
+	seccompDataArch = 4
+
+	// x32 syscalls on amd64 have this bit set, and are denied entirely since
+	// they would otherwise bypass the checks on their numbers.
+	x32SyscallBit = 0x40000000
+)
+
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+// restrictSyscalls installs a seccomp filter on all threads that makes the
+// syscalls in deniedSyscalls fail with EPERM, and kills the process if a
+// syscall is made using a different architecture's calling convention.
+func restrictSyscalls() error {
+	if deniedSyscalls == nil {
+		return nil
+	}
+
+	n := len(deniedSyscalls)
+	filter := []sockFilter{
+		{code: bpfLdWAbs, k: seccompDataArch},
+		{code: bpfJeqK, jt: 1, k: auditArch},
+		{code: bpfRetK, k: seccompRetKillProcess},
+		{code: bpfLdWAbs, k: seccompDataNr},
+		{code: bpfJgeK, jt: uint8(n + 1), k: x32SyscallBit},
+	}
+	for i, nr := range deniedSyscalls {
+		filter = append(filter, sockFilter{code: bpfJeqK, jt: uint8(n - i), k: nr})
+	}
+	filter = append(filter,
+		sockFilter{code: bpfRetK, k: seccompRetAllow},
+		sockFilter{code: bpfRetK, k: seccompRetErrno | uint32(syscall.EPERM)},
+	)
+
+	prog := sockFprog{len: uint16(len(filter)), filter: &filter[0]}
+	if _, _, errno := syscall.Syscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// sandboxTestEnv is set to the output of a sandbox when the test binary is
+// run by TestSandbox to be confined to it.
+const sandboxTestEnv = "SQUISH_TEST_SANDBOX_OUTPUT"
+
+func TestSandbox(t *testing.T) {
+	if output := os.Getenv(sandboxTestEnv); output != "" {
+		sandbox(output)
+
+		if err := os.WriteFile(filepath.Join(output, "inside"), []byte("inside"), 0o644); err != nil {
+			t.Errorf("failed to write inside the output: %s", err)
+		}
+		if err := os.WriteFile(filepath.Join(filepath.Dir(output), "outside"), []byte("outside"), 0o644); !errors.Is(err, syscall.EACCES) {
+			t.Errorf("got error %v writing outside the output, want %v", err, syscall.EACCES)
+		}
+		if _, err := syscall.ForkExec("/bin/true", nil, &syscall.ProcAttr{}); !errors.Is(err, syscall.EPERM) {
+			t.Errorf("got error %v running a program, want %v", err, syscall.EPERM)
+		}
+		return
+	}
+
+	parent := t.TempDir()
+	output := filepath.Join(parent, "out")
+	if err := os.Mkdir(output, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSandbox$", "-test.v")
+	cmd.Env = append(os.Environ(), sandboxTestEnv+"="+output)
+	out, err := cmd.CombinedOutput()
+	if strings.Contains(string(out), "Landlock is not supported") {
+		t.Skip("Landlock is not supported by the running kernel")
+	}
+	if err != nil {
+		t.Fatalf("sandboxed process failed: %s\n%s", err, out)
+	}
+
+	if got := readTree(t, parent); len(got) != 2 || got["out/inside"] != "inside" {
+		t.Errorf("got tree %v, want only out/inside", got)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+func inSandbox() bool {
+	return false
+}
+
+func sandbox(_ string) {
+	bail("--sandbox is only supported on Linux")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// windowsSafeName returns the slash-separated name with each element that
+// isn't a valid file name on Windows renamed so that it is, by replacing
+// reserved characters, and trailing dots and spaces, with underscores, and
+// appending an underscore to reserved device names like CON, before any
+// extension. Backslashes are left alone, since Windows treats them as
+// separators.
+func windowsSafeName(name string) string {
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		if elem == "." || elem == ".." {
+			continue
+		}
+		elem = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(`<>:"|?*`, r) {
+				return '_'
+			}
+			return r
+		}, elem)
+		if trimmed := strings.TrimRight(elem, ". "); trimmed != elem {
+			elem = trimmed + strings.Repeat("_", len(elem)-len(trimmed))
+		}
+		base, ext, dotted := strings.Cut(elem, ".")
+		if slices.ContainsFunc(windowsReservedNames, func(reserved string) bool { return strings.EqualFold(reserved, strings.TrimRight(base, " ")) }) {
+			elem = base + "_"
+			if dotted {
+				elem += "." + ext
+			}
+		}
+		elems[i] = elem
+	}
+	return strings.Join(elems, "/")
+}
+
+// sanitizeName returns cleanedName, an entry's cleaned name, renamed by
+// windowsSafeName, leaving the volume of absolute names alone, and whether it
+// was renamed.
+func sanitizeName(cleanedName string) (string, bool) {
+	volume := filepath.VolumeName(cleanedName)
+	sanitized := volume + filepath.FromSlash(windowsSafeName(filepath.ToSlash(cleanedName[len(volume):])))
+	return sanitized, sanitized != cleanedName
+}
+
+// writeRenameMap writes the entries that were renamed by --sanitize-names to
+// path, one per line, with the name in the archive and the path it was
+// extracted to separated by a tab.
+func writeRenameMap(path string, renames [][2]string) error {
+	var lines strings.Builder
+	for _, rename := range renames {
+		fmt.Fprintf(&lines, "%s\t%s\n", rename[0], filepath.ToSlash(rename[1]))
+	}
+	return os.WriteFile(path, []byte(lines.String()), 0o644)
+}
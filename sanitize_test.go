@@ -0,0 +1,56 @@
+package main
+
+import (
+	"archive/tar"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestWindowsSafeName(t *testing.T) {
+	for name, want := range map[string]string{
+		"a/b.txt":          "a/b.txt",
+		"a:b/c?d":          "a_b/c_d",
+		"CON":              "CON_",
+		"dir/aux.tar.gz":   "dir/aux_.tar.gz",
+		"console":          "console",
+		"trailing.":        "trailing_",
+		"spaces  /x":       "spaces__/x",
+		"./a/../b":         "./a/../b",
+		"tab\there":        "tab_here",
+		`back\slash`:       `back\slash`,
+		"com1 .txt/nul...": "com1 _.txt/nul___",
+	} {
+		got := windowsSafeName(name)
+		if got != want {
+			t.Errorf("%q: got %q, want %q", name, got, want)
+		}
+		// Backslashes are separators on Windows.
+		if _, invalid := windowsInvalidElem(strings.ReplaceAll(got, `\`, "/")); invalid {
+			t.Errorf("%q: %q is still invalid", name, got)
+		}
+	}
+}
+
+func TestExtractSanitizeNames(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a:b/", typeflag: tar.TypeDir},
+		{name: "a:b/con.txt", typeflag: tar.TypeReg, contents: "c"},
+		{name: "ok", typeflag: tar.TypeReg, contents: "ok"},
+	})
+
+	e := &entryExtractor{sanitizeNames: true}
+	_, output, err := extractTest(t, archives.Tar{}, archive, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a_b": "/", "a_b/con_.txt": "c", "ok": "ok"}
+	if got := readTree(t, output); !maps.Equal(got, want) {
+		t.Errorf("got output %v, want %v", got, want)
+	}
+	if len(e.renames) != 2 || e.renames[1] != [2]string{"a:b/con.txt", "a_b/con_.txt"} {
+		t.Errorf("got renames %v", e.renames)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mholt/archives"
+)
+
+// maxGrepLine is the length of the longest line grep can match, beyond which
+// the rest of the entry is skipped.
+const maxGrepLine = 16 << 20
+
+func grep(ctx context.Context) {
+	pattern := cli.Grep.Pattern
+	if cli.Grep.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		bail("invalid pattern: %s", err)
+	}
+
+	searchArchives(ctx, cli.Grep.Inputs, cli.Grep.Threads, false, func(input string, info archives.FileInfo, results *bytes.Buffer) (bool, error) {
+		if !info.Mode().IsRegular() {
+			return false, nil
+		}
+		return grepEntry(re, input, info, results)
+	})
+}
+
+// grepEntry writes the lines of info that match re to results, prefixed with
+// the input and entry names and the line number. Entries containing NUL bytes
+// are treated as binary, and are only reported once if they match.
+func grepEntry(re *regexp.Regexp, input string, info archives.FileInfo, results *bytes.Buffer) (matched bool, err error) {
+	entry, err := info.Open()
+	if err != nil {
+		return false, err
+	}
+	defer entry.Close()
+
+	scanner := bufio.NewScanner(entry)
+	scanner.Buffer(nil, maxGrepLine)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Bytes()
+		if !re.Match(line) {
+			continue
+		}
+
+		if bytes.IndexByte(line, 0) >= 0 {
+			fmt.Fprintf(results, "%s:%s: binary file matches\n", input, info.NameInArchive)
+			return true, nil
+		}
+		fmt.Fprintf(results, "%s:%s:%d:%s\n", input, info.NameInArchive, lineNumber, line)
+		matched = true
+	}
+	if err := scanner.Err(); err == bufio.ErrTooLong {
+		warn("%s:%s: skipped remainder of entry with a line longer than %s", input, info.NameInArchive, byteSize(maxGrepLine))
+	} else if err != nil {
+		return matched, err
+	}
+
+	return matched, nil
+}
+
+func find(ctx context.Context) {
+	searchArchives(ctx, cli.Find.Inputs, cli.Find.Threads, true, func(input string, info archives.FileInfo, results *bytes.Buffer) (bool, error) {
+		if !typeMatches(cli.Find.Type, info) {
+			return false, nil
+		}
+		if cli.Find.Name != "" {
+			if ok, _ := path.Match(cli.Find.Name, path.Base(strings.TrimSuffix(info.NameInArchive, "/"))); !ok {
+				return false, nil
+			}
+		}
+
+		fmt.Fprintf(results, "%s:%s\n", input, info.NameInArchive)
+		return true, nil
+	})
+}
+
+// searchArchives calls match for every entry of each of inputs, searching up to
+// threads inputs concurrently. match writes any results to the buffer it's
+// given, which is printed once the whole input has been searched so that
+// results from different inputs aren't interleaved. If useIndex is true,
+// entries are read from the index beside each input, if there is one, which
+// can't be opened. Inputs that can't be searched are reported as warnings. The
+// exit status is 1 if nothing matched, like grep(1).
+func searchArchives(ctx context.Context, inputs []string, threads int, useIndex bool, match func(input string, info archives.FileInfo, results *bytes.Buffer) (bool, error)) {
+	if threads < 1 {
+		bail("invalid number of threads: %d", threads)
+	}
+
+	var matched atomic.Bool
+	var stdoutMu sync.Mutex
+	workers := newWorkerPool(threads)
+	for _, input := range inputs {
+		workers.run(func() error {
+			var results bytes.Buffer
+			err := searchArchive(ctx, input, useIndex, func(ctx context.Context, info archives.FileInfo) error {
+				ok, err := match(input, info, &results)
+				if err != nil {
+					return fmt.Errorf("%s: %w", info.NameInArchive, err)
+				}
+				if ok {
+					matched.Store(true)
+				}
+				return nil
+			})
+
+			stdoutMu.Lock()
+			io.Copy(os.Stdout, &results)
+			stdoutMu.Unlock()
+
+			if err != nil {
+				warn("%s: %s", input, err)
+			}
+			return nil
+		})
+	}
+	workers.wait()
+
+	if !matched.Load() {
+		exitCode = 1
+	}
+}
+
+func searchArchive(ctx context.Context, name string, useIndex bool, handle archives.FileHandler) error {
+	if useIndex && name != stdioPath && !isURL(name) {
+		input, err := openFile(ctx, name)
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+		index, ok := readIndex(name, fileSize(input))
+		input.Close()
+		if ok {
+			return walkIndexEntries(ctx, index.Entries, handle)
+		}
+	}
+
+	input, format, inputR, err := identifyInput(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	extractor, ok := format.(archives.Extractor)
+	if !ok {
+		return fmt.Errorf("identified format doesn't support extraction")
+	}
+
+	return extractor.Extract(ctx, inputR, handle)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeSearchArchives writes archives to search to a temporary directory,
+// returning their paths, with a file that isn't an archive last.
+func writeSearchArchives(t *testing.T) []string {
+	dir := t.TempDir()
+	archives := map[string][]byte{
+		"a.tar": makeTar(t, []testEntry{
+			{name: "docs/", typeflag: tar.TypeDir},
+			{name: "docs/readme.md", typeflag: tar.TypeReg, contents: "intro\nfind the Needle\n"},
+			{name: "bin", typeflag: tar.TypeReg, contents: "\x00needle\x00"},
+			{name: "link.md", typeflag: tar.TypeSymlink, linkname: "docs/readme.md"},
+		}),
+		"b.zip": makeZip(t, []testEntry{
+			{name: "notes.md", contents: "no match\nneedle\nneedle again"},
+		}),
+		"c.txt": []byte("needle, but not in an archive"),
+	}
+	var paths []string
+	for _, name := range []string{"a.tar", "b.zip", "c.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, archives[name], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// sortedLines returns the lines of output, sorted, since archives are
+// searched concurrently.
+func sortedLines(output string) []string {
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	slices.Sort(lines)
+	return lines
+}
+
+func TestGrep(t *testing.T) {
+	paths := writeSearchArchives(t)
+	a, b := paths[0], paths[1]
+
+	// The input that isn't an archive is skipped with a warning.
+	parseCLI(t, append([]string{"grep", "-i", "needle"}, paths...)...)
+	warned := warnings.Load()
+	var code int
+	output := captureStdout(t, func() { code = runCommand(t, func() { grep(context.Background()) }) })
+	want := []string{
+		a + ":bin: binary file matches",
+		a + ":docs/readme.md:2:find the Needle",
+		b + ":notes.md:2:needle",
+		b + ":notes.md:3:needle again",
+	}
+	if got := sortedLines(output); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if code != 0 {
+		t.Errorf("got exit code %d, want 0", code)
+	}
+	if n := warnings.Load() - warned; n != 1 {
+		t.Errorf("got %d warnings, want 1", n)
+	}
+
+	parseCLI(t, "grep", "Needle again", a, b)
+	output = captureStdout(t, func() { code = runCommand(t, func() { grep(context.Background()) }) })
+	if output != "" || code != 1 {
+		t.Errorf("got %q and exit code %d, want nothing and 1", output, code)
+	}
+}
+
+func TestFind(t *testing.T) {
+	paths := writeSearchArchives(t)
+	a, b := paths[0], paths[1]
+
+	for _, test := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"--name", "*.md"}, []string{a + ":docs/readme.md", a + ":link.md", b + ":notes.md"}},
+		{[]string{"--name", "*.md", "--type", "f"}, []string{a + ":docs/readme.md", b + ":notes.md"}},
+		{[]string{"--type", "d"}, []string{a + ":docs/"}},
+	} {
+		parseCLI(t, append(append([]string{"find"}, test.args...), paths...)...)
+		var code int
+		output := captureStdout(t, func() { code = runCommand(t, func() { find(context.Background()) }) })
+		if got := sortedLines(output); !slices.Equal(got, test.want) || code != 0 {
+			t.Errorf("%q: got %q and exit code %d, want %q", test.args, got, code, test.want)
+		}
+	}
+}
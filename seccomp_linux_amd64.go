@@ -0,0 +1,23 @@
+package main
+
+import "syscall"
+
+const (
+	auditArch  = 0xc000003e
+	sysSeccomp = 317
+)
+
+var deniedSyscalls = []uint32{
+	syscall.SYS_EXECVE, 322, // execveat
+	syscall.SYS_PTRACE, 311, // process_vm_writev
+	syscall.SYS_MOUNT, syscall.SYS_UMOUNT2, syscall.SYS_PIVOT_ROOT, syscall.SYS_CHROOT,
+	308, // setns
+	syscall.SYS_UNSHARE,
+	syscall.SYS_INIT_MODULE, 313, // finit_module
+	syscall.SYS_DELETE_MODULE, syscall.SYS_KEXEC_LOAD, syscall.SYS_REBOOT,
+	syscall.SYS_SWAPON, syscall.SYS_SWAPOFF,
+	321, // bpf
+	syscall.SYS_PERF_EVENT_OPEN,
+	323, // userfaultfd
+	syscall.SYS_KEYCTL, syscall.SYS_ADD_KEY,
+}
@@ -0,0 +1,21 @@
+package main
+
+import "syscall"
+
+const (
+	auditArch  = 0xc00000b7
+	sysSeccomp = syscall.SYS_SECCOMP
+)
+
+var deniedSyscalls = []uint32{
+	syscall.SYS_EXECVE, syscall.SYS_EXECVEAT,
+	syscall.SYS_PTRACE, syscall.SYS_PROCESS_VM_WRITEV,
+	syscall.SYS_MOUNT, syscall.SYS_UMOUNT2, syscall.SYS_PIVOT_ROOT, syscall.SYS_CHROOT,
+	syscall.SYS_SETNS, syscall.SYS_UNSHARE,
+	syscall.SYS_INIT_MODULE, syscall.SYS_FINIT_MODULE,
+	syscall.SYS_DELETE_MODULE, syscall.SYS_KEXEC_LOAD, syscall.SYS_REBOOT,
+	syscall.SYS_SWAPON, syscall.SYS_SWAPOFF,
+	syscall.SYS_BPF, syscall.SYS_PERF_EVENT_OPEN,
+	282, // userfaultfd
+	syscall.SYS_KEYCTL, syscall.SYS_ADD_KEY,
+}
@@ -0,0 +1,12 @@
+//go:build linux && !amd64 && !arm64
+
+package main
+
+// Syscalls are only restricted on amd64 and arm64; elsewhere the sandbox
+// consists of Landlock alone.
+const (
+	auditArch  = 0
+	sysSeccomp = 0
+)
+
+var deniedSyscalls []uint32
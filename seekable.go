@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// seekableFS wraps an archive file system so that the regular files it opens
+// implement io.Seeker.
+type seekableFS struct {
+	fs.FS
+}
+
+func (fsys seekableFS) Open(name string) (fs.File, error) {
+	file, err := fsys.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return file, nil
+	}
+
+	return &seekableEntry{fsys: fsys.FS, name: name, File: file, size: info.Size()}, nil
+}
+
+// seekableEntry makes an archive entry, which can generally only be read
+// sequentially, seekable. Seeking backwards reopens the entry, and seeking
+// forwards discards everything up to the new offset. Both are deferred until
+// the next read, so seeking to find the size is free.
+type seekableEntry struct {
+	fsys fs.FS
+	name string
+	fs.File
+	size int64
+
+	// pos is the position of File, and offset is the position that the next
+	// read should start from.
+	pos, offset int64
+}
+
+func (e *seekableEntry) Read(p []byte) (int, error) {
+	if e.offset < e.pos {
+		// The old handle is being discarded, and archives reports spurious
+		// errors when closing entries of stream-backed file systems, so any
+		// error here is ignored.
+		_ = e.File.Close()
+
+		file, err := e.fsys.Open(e.name)
+		if err != nil {
+			return 0, err
+		}
+		e.File, e.pos = file, 0
+	}
+
+	if e.offset > e.pos {
+		skipped, err := io.CopyN(io.Discard, e.File, e.offset-e.pos)
+		e.pos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := e.File.Read(p)
+	e.pos += int64(n)
+	e.offset = e.pos
+	return n, err
+}
+
+func (e *seekableEntry) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += e.offset
+	case io.SeekEnd:
+		offset += e.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	e.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+
+	"github.com/mholt/archives"
+)
+
+// seekableFrameSize is how much is compressed in each frame of an archive
+// written with --index before a new one is started, so that it's about the
+// most that has to be decompressed to reach any entry.
+const seekableFrameSize = 1 << 20
+
+// Archives written with create --index are compressed as a sequence of
+// independent gzip members or zstd frames, which are still read as a single
+// stream by any other tool, and a new one is started at the start of an entry
+// once the current one holds seekableFrameSize, so that their index records
+// which one each entry starts in.
+
+// seekableTar writes compressed tar archives for --index, recording where
+// each entry is in index.
+type seekableTar struct {
+	archives.CompressedArchive
+	index *archiveIndex
+}
+
+// withSeekIndex returns format, which must be a tar.gz or tar.zst archive,
+// writing its entries so that they can be found with a seekIndex, or false if
+// it isn't.
+func withSeekIndex(format archives.Format) (seekableTar, bool) {
+	compressed, ok := format.(archives.CompressedArchive)
+	if !ok || !isSeekableCompression(compressed.Compression) {
+		return seekableTar{}, false
+	}
+	if _, ok := compressed.Archival.(archives.ArchiverAsync); !ok || !isTar(compressed) {
+		return seekableTar{}, false
+	}
+	index := &archiveIndex{Version: indexVersion, Format: compressed.Extension()[1:], Entries: []indexEntry{}}
+	return seekableTar{compressed, index}, true
+}
+
+// isSeekableCompression reports whether compression can be read from the
+// start of any of its members or frames.
+func isSeekableCompression(compression archives.Compression) bool {
+	switch compression.(type) {
+	case archives.Gz, parallelGz, archives.Zstd:
+		return true
+	}
+	return false
+}
+
+func (t seekableTar) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	jobs := make(chan archives.ArchiveAsyncJob)
+	archiveErr := make(chan error, 1)
+	go func() {
+		archiveErr <- t.ArchiveAsync(ctx, output, jobs)
+	}()
+
+	result := make(chan error)
+	var err error
+	for _, file := range files {
+		jobs <- archives.ArchiveAsyncJob{File: file, Result: result}
+		if err = <-result; err != nil {
+			break
+		}
+	}
+	close(jobs)
+	return errors.Join(err, <-archiveErr)
+}
+
+func (t seekableTar) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	frames := &frameWriter{output: output, compression: t.Compression}
+	tarJobs := make(chan archives.ArchiveAsyncJob)
+	archiveErr := make(chan error, 1)
+	go func() {
+		archiveErr <- t.Archival.(archives.ArchiverAsync).ArchiveAsync(ctx, frames, tarJobs)
+	}()
+
+	// Jobs are passed on one at a time, so that frames are only cut between
+	// entries. Each entry's header follows the padding of the one before
+	// it, which is only written along with it.
+	result := make(chan error)
+	var err error
+	for job := range jobs {
+		if err == nil && (frames.compressor == nil || frames.n-frames.frameN >= seekableFrameSize) {
+			err = frames.cut()
+		}
+		if err != nil {
+			job.Result <- err
+			continue
+		}
+
+		before, frame, frameN := frames.n, frames.frame, frames.frameN
+		tarJobs <- archives.ArchiveAsyncJob{File: job.File, Result: result}
+		err = <-result
+		// Entries that the tar writer skipped aren't indexed.
+		if err == nil && frames.n > before {
+			entry := newIndexEntry(job.File)
+			entry.Offset = &entryOffset{Frame: frame, Skip: (before+tarBlockSize-1)/tarBlockSize*tarBlockSize - frameN}
+			t.index.Entries = append(t.index.Entries, entry)
+		}
+		job.Result <- err
+	}
+	close(tarJobs)
+	return errors.Join(err, <-archiveErr, frames.Close())
+}
+
+// frameWriter compresses what's written to it to output, starting a new
+// member or frame of compression whenever it's cut.
+type frameWriter struct {
+	output      io.Writer
+	compression archives.Compression
+	compressor  io.WriteCloser
+	// n is how much has been written to it, and written is how much has been
+	// written to output, which were frameN and frame when the current
+	// member or frame was started.
+	n, written    int64
+	frameN, frame int64
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	if w.compressor == nil {
+		if err := w.cut(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.compressor.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// cut ends the current member or frame, and starts a new one.
+func (w *frameWriter) cut() error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	compressor, err := w.compression.OpenWriter(writerFunc(func(p []byte) (int, error) {
+		n, err := w.output.Write(p)
+		w.written += int64(n)
+		return n, err
+	}))
+	if err != nil {
+		return err
+	}
+	w.compressor, w.frameN, w.frame = compressor, w.n, w.written
+	return nil
+}
+
+func (w *frameWriter) Close() error {
+	if w.compressor == nil {
+		return nil
+	}
+	err := w.compressor.Close()
+	w.compressor = nil
+	return err
+}
+
+// writerFunc is a function that implements io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// withSeekableEntries returns extractor, which reads the archive input at
+// name, reading only the entries that selects selects if there's an index
+// beside it that records where they are, by seeking to each of them. Only tar
+// archives that are uncompressed, or were compressed in frames by create
+// --index, can be read like this.
+func withSeekableEntries(name string, input inputFile, extractor archives.Extractor, selects func(indexEntry) bool) archives.Extractor {
+	if !isTar(extractor) {
+		return extractor
+	}
+	var compression archives.Compression
+	if compressed, ok := extractor.(archives.CompressedArchive); ok {
+		if !isSeekableCompression(compressed.Compression) {
+			return extractor
+		}
+		compression = compressed.Compression
+	}
+	// The extractor reads from where input is now if there's no index.
+	offset, err := input.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return extractor
+	}
+	size, err := input.Seek(0, io.SeekEnd)
+	if _, seekErr := input.Seek(offset, io.SeekStart); err != nil || seekErr != nil {
+		return extractor
+	}
+	index, ok := readIndex(name, size)
+	if !ok || slices.ContainsFunc(index.Entries, func(entry indexEntry) bool { return entry.Offset == nil }) {
+		return extractor
+	}
+	logger.Debug("reading entries with the index", "input", name)
+	return seekableExtractor{extractor, compression, index, io.NewSectionReader(input, 0, size), selects}
+}
+
+// seekableExtractor reads the entries of a tar archive, compressed with
+// compression if it's non-nil, that selects selects, by seeking to where its
+// index says they are, ignoring the reader it's given.
+type seekableExtractor struct {
+	archives.Extractor
+	compression archives.Compression
+	index       *archiveIndex
+	input       *io.SectionReader
+	selects     func(indexEntry) bool
+}
+
+func (e seekableExtractor) Extract(ctx context.Context, _ io.Reader, handle archives.FileHandler) error {
+	var skipped []string
+	for _, entry := range e.index.Entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !e.selects(entry) || slices.ContainsFunc(skipped, func(dir string) bool { return entryWithin(entry.Name, dir) }) {
+			continue
+		}
+
+		err := e.extractEntry(ctx, entry, handle)
+		if errors.Is(err, fs.SkipAll) {
+			return nil
+		} else if errors.Is(err, fs.SkipDir) && entry.Mode.IsDir() {
+			skipped = append(skipped, path.Clean(entry.Name))
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractEntry reads the archive from the start of the member or frame that
+// entry starts in, or from its header if it's uncompressed, and handles it.
+func (e seekableExtractor) extractEntry(ctx context.Context, entry indexEntry, handle archives.FileHandler) error {
+	offset := entry.Offset
+	var r io.Reader
+	if e.compression == nil {
+		r = io.NewSectionReader(e.input, offset.Frame+offset.Skip, e.input.Size()-offset.Frame-offset.Skip)
+	} else {
+		decompressed, err := e.compression.(archives.Decompressor).OpenReader(io.NewSectionReader(e.input, offset.Frame, e.input.Size()-offset.Frame))
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		if _, err := io.CopyN(io.Discard, decompressed, offset.Skip); err != nil {
+			return fmt.Errorf("failed to seek to %s: %w", entry.Name, err)
+		}
+		r = decompressed
+	}
+
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("failed to seek to %s: %w", entry.Name, err)
+	}
+	if path.Clean(header.Name) != path.Clean(entry.Name) {
+		return fmt.Errorf("the index beside the archive doesn't match it, since %s was found in place of %s", header.Name, entry.Name)
+	}
+
+	info := header.FileInfo()
+	file := archives.FileInfo{
+		FileInfo:      info,
+		Header:        header,
+		NameInArchive: header.Name,
+		LinkTarget:    header.Linkname,
+		Open: func() (fs.File, error) {
+			return seekableEntryFile{tr, info}, nil
+		},
+	}
+	if err := handle(ctx, file); err != nil && !errors.Is(err, fs.SkipAll) && !errors.Is(err, fs.SkipDir) {
+		return fmt.Errorf("handling file: %s: %w", header.Name, err)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// seekableEntryFile is an opened entry read by a seekableExtractor.
+type seekableEntryFile struct {
+	io.Reader
+	info fs.FileInfo
+}
+
+func (f seekableEntryFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (seekableEntryFile) Close() error                 { return nil }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestSeekIndex(t *testing.T) {
+	contents := map[string][]byte{}
+	files := []archives.FileInfo{memDir("d")}
+	for i := range 20 {
+		// Some entries are larger than a frame, and others are packed
+		// together into one.
+		data := make([]byte, rand.Intn(seekableFrameSize/4)+1)
+		if i%5 == 0 {
+			data = make([]byte, seekableFrameSize+123)
+		}
+		rand.Read(data)
+		name := fmt.Sprintf("d/f%d", i)
+		contents[name] = data
+		files = append(files, memFile(name, data))
+	}
+
+	for _, compression := range []archives.Compression{archives.Gz{}, archives.Zstd{}} {
+		format := archives.CompressedArchive{Archival: archives.Tar{}, Extraction: archives.Tar{}, Compression: compression}
+		seekable, ok := withSeekIndex(format)
+		if !ok {
+			t.Fatalf("%s couldn't be indexed", format.Extension())
+		}
+		var buf bytes.Buffer
+		if err := seekable.Archive(context.Background(), &buf, files); err != nil {
+			t.Fatal(err)
+		}
+		if len(seekable.index.Entries) != len(files) {
+			t.Fatalf("got %d indexed entries, want %d", len(seekable.index.Entries), len(files))
+		}
+
+		// The archive is still read as a whole as usual.
+		seen := 0
+		err := format.Extract(context.Background(), bytes.NewReader(buf.Bytes()), func(ctx context.Context, info archives.FileInfo) error {
+			seen++
+			return nil
+		})
+		if err != nil || seen != len(files) {
+			t.Fatalf("got %d entries and %v reading %s as a whole", seen, err, format.Extension())
+		}
+
+		input := io.NewSectionReader(bytes.NewReader(buf.Bytes()), 0, int64(buf.Len()))
+		for _, entry := range seekable.index.Entries {
+			extractor := seekableExtractor{format, compression, seekable.index, input, func(e indexEntry) bool { return e.Name == entry.Name }}
+			found := false
+			err := extractor.Extract(context.Background(), nil, func(ctx context.Context, info archives.FileInfo) error {
+				found = true
+				if info.IsDir() {
+					return nil
+				}
+				f, err := info.Open()
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				got, err := io.ReadAll(f)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(got, contents[info.NameInArchive]) {
+					t.Errorf("got different contents for %s in %s", info.NameInArchive, format.Extension())
+				}
+				return fs.SkipAll
+			})
+			if err != nil || !found {
+				t.Errorf("got %t and %v seeking to %s in %s", found, err, entry.Name, format.Extension())
+			}
+		}
+	}
+
+	if _, ok := withSeekIndex(archives.CompressedArchive{Archival: archives.Tar{}, Extraction: archives.Tar{}, Compression: archives.Xz{}}); ok {
+		t.Error("a tar.xz archive was indexed")
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "a.tar.gz")
+	index := &archiveIndex{Version: indexVersion, Format: "tar.gz", Entries: []indexEntry{}}
+	if err := os.WriteFile(archive, []byte("archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeIndex(archive, index); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := readIndex(archive, int64(len("archive"))); !ok {
+		t.Error("the index wasn't read")
+	}
+	if _, ok := readIndex(archive, 1); ok {
+		t.Error("the index of a different archive was read")
+	}
+}
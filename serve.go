@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"github.com/mholt/archives"
+)
+
+func serve(ctx context.Context) {
+	input, _, _ := openExtractor(ctx, cli.Serve.Input)
+	defer closeInput(input)
+
+	fsys, err := archives.FileSystem(ctx, filepath.Base(trimVolumeSuffix(cli.Serve.Input)), input)
+	if err != nil {
+		bail("failed to open archive file system: %s", err)
+	}
+
+	// Index the archive up front so that requests don't each rescan it.
+	if _, err := fs.ReadDir(fsys, "."); err != nil {
+		bail("failed to index archive: %s", err)
+	}
+
+	listener, err := net.Listen("tcp", cli.Serve.Listen)
+	if err != nil {
+		bail("failed to listen: %s", err)
+	}
+
+	logMessage(slog.LevelInfo, nil, "serving %s on http://%s", cli.Serve.Input, listener.Addr())
+
+	server := &http.Server{Handler: serveHandler(fsys)}
+	// Interrupting squish stops the server, which isn't a failure.
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		bail("failed to serve archive: %s", err)
+	}
+}
+
+// serveHandler serves the entries of the archive file system fsys, listing
+// the contents of its directories.
+func serveHandler(fsys fs.FS) http.Handler {
+	return http.FileServer(http.FS(seekableFS{fsys}))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestServeHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.tar")
+	archive := makeTar(t, []testEntry{
+		{name: "dir/", typeflag: tar.TypeDir},
+		{name: "dir/a.txt", typeflag: tar.TypeReg, contents: "contents of a"},
+		{name: "b.txt", typeflag: tar.TypeReg, contents: "contents of b"},
+	})
+	if err := os.WriteFile(path, archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	fsys, err := archives.FileSystem(context.Background(), path, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(serveHandler(fsys))
+	defer server.Close()
+
+	get := func(path string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	if resp, body := get("/", nil); resp.StatusCode != http.StatusOK || !strings.Contains(body, `href="dir/"`) || !strings.Contains(body, `href="b.txt"`) {
+		t.Errorf("got listing %d %q", resp.StatusCode, body)
+	}
+	if resp, body := get("/dir/", nil); resp.StatusCode != http.StatusOK || !strings.Contains(body, `href="a.txt"`) {
+		t.Errorf("got listing of dir %d %q", resp.StatusCode, body)
+	}
+	if resp, body := get("/dir/a.txt", nil); resp.StatusCode != http.StatusOK || body != "contents of a" {
+		t.Errorf("got file %d %q", resp.StatusCode, body)
+	}
+	if resp, body := get("/b.txt", http.Header{"Range": {"bytes=12-"}}); resp.StatusCode != http.StatusPartialContent || body != "b" {
+		t.Errorf("got range %d %q", resp.StatusCode, body)
+	}
+	if resp, _ := get("/missing", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d for a missing entry", resp.StatusCode)
+	}
+}
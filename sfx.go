@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// sfxMagic ends self-extracting archives, after the offset of the archive
+// within them, so that squish can tell that it's been prepended to one.
+const sfxMagic = "\x7fsquish-sfx"
+
+const sfxTrailerLen = 8 + len(sfxMagic)
+
+// sfxStub opens the executable that --self-extracting prepends to archives
+// for target, given as GOOS/GOARCH, returning it along with its size, which
+// excludes any archive that's appended to it. There's no separate, smaller
+// extractor: the stub is squish itself when it's built for target, or
+// otherwise squish-GOOS-GOARCH beside it, with .exe appended for windows.
+// Those aren't built or installed with squish, so they must be cross-compiled
+// and put there by hand.
+func sfxStub(target string) (*os.File, int64, error) {
+	goos, goarch, ok := strings.Cut(target, "/")
+	if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+		return nil, 0, fmt.Errorf("invalid target %q, which must be given as GOOS/GOARCH, like linux/amd64", target)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, 0, err
+	}
+	path := self
+	if goos != runtime.GOOS || goarch != runtime.GOARCH {
+		name := "squish-" + goos + "-" + goarch
+		if goos == "windows" {
+			name += ".exe"
+		}
+		path = filepath.Join(filepath.Dir(self), name)
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, fmt.Errorf("no stub for %s was found at %s; squish can only prepend itself, for %s/%s, unless it's built for %s and put there, like with GOOS=%s GOARCH=%s go build -o %s", target, path, runtime.GOOS, runtime.GOARCH, target, goos, goarch, path)
+	} else if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	size := info.Size()
+	if offset, _, ok := sfxArchive(f, size); ok {
+		size = offset
+	}
+	return f, size, nil
+}
+
+// writeSFXStub writes the first size bytes of stub to w, which the archive
+// follows.
+func writeSFXStub(w io.Writer, stub *os.File, size int64) error {
+	_, err := io.Copy(w, io.NewSectionReader(stub, 0, size))
+	return err
+}
+
+// sfxTrailer returns what ends a self-extracting archive whose archive starts
+// at offset.
+func sfxTrailer(offset int64) []byte {
+	return append(binary.LittleEndian.AppendUint64(nil, uint64(offset)), sfxMagic...)
+}
+
+// sfxArchive returns where the archive is within f, which is size bytes long,
+// and its length, or false if f isn't a self-extracting archive.
+func sfxArchive(f io.ReaderAt, size int64) (offset, length int64, ok bool) {
+	if size < int64(sfxTrailerLen) {
+		return 0, 0, false
+	}
+	trailer := make([]byte, sfxTrailerLen)
+	if _, err := f.ReadAt(trailer, size-int64(sfxTrailerLen)); err != nil || string(trailer[8:]) != sfxMagic {
+		return 0, 0, false
+	}
+	offset = int64(binary.LittleEndian.Uint64(trailer))
+	if offset < 0 || offset > size-int64(sfxTrailerLen) {
+		return 0, 0, false
+	}
+	return offset, size - int64(sfxTrailerLen) - offset, true
+}
+
+// sfxInput reads the archive within a self-extracting archive.
+type sfxInput struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (f sfxInput) Close() error { return f.file.Close() }
+
+// selfExtracting returns the path of the running executable if it's a
+// self-extracting archive, which extracts itself when it's run.
+func selfExtracting() (string, bool) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", false
+	}
+	f, err := os.Open(self)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", false
+	}
+	_, _, ok := sfxArchive(f, info.Size())
+	return self, ok
+}
+
+// makeExecutable lets those who can read the file at path execute it.
+func makeExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	perm := info.Mode().Perm()
+	return os.Chmod(path, perm|perm&0o444>>2)
+}
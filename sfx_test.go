@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSFXArchive(t *testing.T) {
+	stub := bytes.Repeat([]byte{0x7f}, 1000)
+	archive := []byte("the archive")
+	sfx := slices.Concat(stub, archive, sfxTrailer(int64(len(stub))))
+
+	offset, length, ok := sfxArchive(bytes.NewReader(sfx), int64(len(sfx)))
+	if !ok {
+		t.Fatal("the self-extracting archive wasn't recognized")
+	}
+	if got := sfx[offset : offset+length]; !bytes.Equal(got, archive) {
+		t.Errorf("got archive %q, want %q", got, archive)
+	}
+
+	if _, _, ok := sfxArchive(bytes.NewReader(archive), int64(len(archive))); ok {
+		t.Error("a plain archive was recognized as self-extracting")
+	}
+	corrupt := slices.Concat(archive, sfxTrailer(1<<40))
+	if _, _, ok := sfxArchive(bytes.NewReader(corrupt), int64(len(corrupt))); ok {
+		t.Error("a trailer with an offset past the end was accepted")
+	}
+}
+
+func TestOpenFileSFX(t *testing.T) {
+	archive := []byte("the archive")
+	path := filepath.Join(t.TempDir(), "app")
+	if err := os.WriteFile(path, slices.Concat([]byte("stub"), archive, sfxTrailer(4)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := openFile(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if size := fileSize(f); size != int64(len(archive)) {
+		t.Errorf("got size %d, want %d", size, len(archive))
+	}
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, archive) {
+		t.Errorf("read %q, want %q", got, archive)
+	}
+}
+
+func TestSFXStubTarget(t *testing.T) {
+	for _, target := range []string{"", "linux", "linux/", "/amd64", "linux/amd64/v2"} {
+		if f, _, err := sfxStub(target); err == nil {
+			f.Close()
+			t.Errorf("%q: got no error", target)
+		}
+	}
+}
+
+func TestSFXStubMissing(t *testing.T) {
+	target := "plan9/386"
+	if runtime.GOOS == "plan9" {
+		target = "linux/amd64"
+	}
+	f, _, err := sfxStub(target)
+	if err == nil {
+		f.Close()
+		t.Skipf("a stub for %s is installed", target)
+	}
+	if !strings.Contains(err.Error(), "go build -o") {
+		t.Errorf("got error %q, which doesn't say how to build the stub", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+// sidecarSuffix is appended to the output path to give that of the file
+// written by --sidecar.
+const sidecarSuffix = ".meta.json"
+
+// sidecar describes how an archive was created, for --sidecar json, so that it
+// still describes itself once it's in cold storage, away from the scripts that
+// created it.
+type sidecar struct {
+	Archive    string    `json:"archive"`
+	Created    time.Time `json:"created"`
+	Host       string    `json:"host"`
+	Version    string    `json:"squish_version"`
+	Args       []string  `json:"args"`
+	Dir        string    `json:"dir"`
+	Format     string    `json:"format"`
+	Level      *int      `json:"level,omitempty"`
+	Entries    int       `json:"entries"`
+	InputBytes int64     `json:"input_bytes"`
+	// ManifestSHA256 is the SHA-256 digest of the archive's manifest, as
+	// written by --manifest=sha256, which identifies its contents however
+	// they're compressed.
+	ManifestSHA256 string `json:"manifest_sha256"`
+}
+
+// buildVersion returns the version of squish, as recorded when it was built.
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// writeSidecar writes the sidecar of the archive at output, which was created
+// in the format with extension from files, whose sha256 digests are recorded
+// in manifest.
+func writeSidecar(output, extension string, files []archives.FileInfo, manifest *checksumManifest) error {
+	digest := sha256.New()
+	if err := manifest.write(digest); err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	dir, _ := os.Getwd()
+	s := sidecar{
+		Archive:        filepath.Base(output),
+		Created:        time.Now(),
+		Host:           host,
+		Version:        buildVersion(),
+		Args:           withoutPasswords(os.Args[1:]),
+		Dir:            dir,
+		Format:         strings.TrimPrefix(extension, "."),
+		Level:          cli.Create.Level,
+		Entries:        len(files),
+		InputBytes:     totalSize(files),
+		ManifestSHA256: hex.EncodeToString(digest.Sum(nil)),
+	}
+
+	f, err := createAtomic(output+sidecarSuffix, "")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	f.commit()
+	return f.Close()
+}
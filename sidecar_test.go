@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestWriteSidecar(t *testing.T) {
+	manifest := newChecksumManifest(sha256.New)
+	files := []archives.FileInfo{manifest.file(memFile("a", []byte("a\n"))), memDir("d")}
+	f, err := files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(t.TempDir(), "a.tar.gz")
+	if err := writeSidecar(output, ".tar.gz", files, manifest); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output + sidecarSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s sidecar
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	var written bytes.Buffer
+	manifest.write(&written)
+	digest := sha256.Sum256(written.Bytes())
+	if s.Archive != "a.tar.gz" || s.Format != "tar.gz" || s.Entries != 2 || s.InputBytes != 2 || s.ManifestSHA256 != hex.EncodeToString(digest[:]) {
+		t.Errorf("got sidecar %+v", s)
+	}
+}
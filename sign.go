@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Signatures are written in the SSHSIG format of ssh-keygen -Y sign, described
+// in PROTOCOL.sshsig in OpenSSH, so that they can also be checked with
+// ssh-keygen -Y verify -n file.
+const (
+	sshSigMagic     = "SSHSIG"
+	sshSigNamespace = "file"
+	sshSigBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshSigEnd       = "-----END SSH SIGNATURE-----"
+	sshSigColumns   = 70
+	sshEd25519      = "ssh-ed25519"
+	// signatureExtension is appended to the path of an output or input to
+	// give the path of its detached signature.
+	signatureExtension = ".sig"
+)
+
+var errSignatureMismatch = errors.New("signature doesn't match")
+
+// readSigningKey reads an Ed25519 private key from path, in PKCS #8 PEM format,
+// as written by openssl genpkey -algorithm ed25519, or in OpenSSH format, as
+// written by ssh-keygen -t ed25519 -N "".
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := key.(ed25519.PrivateKey); ok {
+			return key, nil
+		}
+		return nil, errors.New("only Ed25519 keys are supported")
+	case "OPENSSH PRIVATE KEY":
+		return parseOpenSSHPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// parseOpenSSHPrivateKey parses the first key in an unencrypted openssh-key-v1
+// private key file, described in PROTOCOL.key in OpenSSH.
+func parseOpenSSHPrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	r, ok := bytes.CutPrefix(data, []byte("openssh-key-v1\x00"))
+	if !ok {
+		return nil, errors.New("invalid OpenSSH private key")
+	}
+	d := sshDecoder{data: r}
+	cipher, kdf, _ := d.string(), d.string(), d.string()
+	if string(cipher) != "none" || string(kdf) != "none" {
+		return nil, errors.New("encrypted OpenSSH private keys aren't supported, remove the passphrase with ssh-keygen -p")
+	}
+	if d.uint32() < 1 {
+		return nil, errors.New("no keys found")
+	}
+	d.string()
+
+	private := sshDecoder{data: d.string()}
+	if private.uint32() != private.uint32() {
+		return nil, errors.New("invalid OpenSSH private key")
+	}
+	if keyType := string(private.string()); keyType != sshEd25519 {
+		return nil, fmt.Errorf("only Ed25519 keys are supported, not %s", keyType)
+	}
+	private.string()
+	key := private.string()
+	if d.err != nil || private.err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid OpenSSH private key")
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// readVerifyingKey reads an Ed25519 public key from path, in PKIX PEM format,
+// as written by openssl pkey -pubout, or as an OpenSSH public key line, as in
+// .pub files and authorized_keys.
+func readVerifyingKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := key.(ed25519.PublicKey); ok {
+			return key, nil
+		}
+		return nil, errors.New("only Ed25519 keys are supported")
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != sshEd25519 || len(fields) < 2 {
+			return nil, errors.New("only Ed25519 keys are supported")
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, errors.New("invalid OpenSSH public key")
+		}
+		return parseSSHEd25519PublicKey(blob)
+	}
+	return nil, errors.New("no public key found")
+}
+
+func parseSSHEd25519PublicKey(blob []byte) (ed25519.PublicKey, error) {
+	d := sshDecoder{data: blob}
+	keyType, key := d.string(), d.string()
+	if d.err != nil || len(d.data) != 0 || string(keyType) != sshEd25519 || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// sshSignedData returns the data that's signed in an SSHSIG signature of a
+// message whose SHA-512 hash is digest.
+func sshSignedData(digest []byte) []byte {
+	data := []byte(sshSigMagic)
+	data = appendSSHString(data, []byte(sshSigNamespace))
+	data = appendSSHString(data, nil)
+	data = appendSSHString(data, []byte("sha512"))
+	return appendSSHString(data, digest)
+}
+
+// sshSignature returns the armored SSHSIG signature by key of a message whose
+// SHA-512 hash is digest.
+func sshSignature(key ed25519.PrivateKey, digest []byte) []byte {
+	publicKey := appendSSHString(appendSSHString(nil, []byte(sshEd25519)), key.Public().(ed25519.PublicKey))
+	signature := appendSSHString(appendSSHString(nil, []byte(sshEd25519)), ed25519.Sign(key, sshSignedData(digest)))
+
+	blob := []byte(sshSigMagic)
+	blob = binary.BigEndian.AppendUint32(blob, 1)
+	blob = appendSSHString(blob, publicKey)
+	blob = appendSSHString(blob, []byte(sshSigNamespace))
+	blob = appendSSHString(blob, nil)
+	blob = appendSSHString(blob, []byte("sha512"))
+	blob = appendSSHString(blob, signature)
+
+	var armored bytes.Buffer
+	armored.WriteString(sshSigBegin + "\n")
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	for len(encoded) > 0 {
+		n := min(len(encoded), sshSigColumns)
+		armored.WriteString(encoded[:n] + "\n")
+		encoded = encoded[n:]
+	}
+	armored.WriteString(sshSigEnd + "\n")
+	return armored.Bytes()
+}
+
+// verifySSHSignature checks that armored is an SSHSIG signature by key of
+// message, which is read entirely.
+func verifySSHSignature(key ed25519.PublicKey, armored []byte, message io.Reader) error {
+	text := strings.TrimSpace(string(armored))
+	text, ok := strings.CutPrefix(text, sshSigBegin)
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	if text, ok = strings.CutSuffix(text, sshSigEnd); !ok {
+		return errors.New("invalid signature")
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+
+	rest, ok := bytes.CutPrefix(blob, []byte(sshSigMagic))
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	d := sshDecoder{data: rest}
+	version := d.uint32()
+	publicKey, namespace, _, hashName, signature := d.string(), d.string(), d.string(), d.string(), d.string()
+	if d.err != nil || version != 1 {
+		return errors.New("invalid signature")
+	}
+	if signer, err := parseSSHEd25519PublicKey(publicKey); err != nil || !signer.Equal(key) {
+		return errors.New("signed by a different key")
+	}
+	if string(namespace) != sshSigNamespace {
+		return fmt.Errorf("signature has namespace %q, not %q", namespace, sshSigNamespace)
+	}
+
+	var h hash.Hash
+	switch string(hashName) {
+	case "sha512":
+		h = sha512.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return fmt.Errorf("unsupported signature hash %q", hashName)
+	}
+	if _, err := io.Copy(h, message); err != nil {
+		return err
+	}
+
+	s := sshDecoder{data: signature}
+	sigType, sig := s.string(), s.string()
+	if s.err != nil || string(sigType) != sshEd25519 {
+		return errors.New("invalid signature")
+	}
+	signed := []byte(sshSigMagic)
+	signed = appendSSHString(signed, namespace)
+	signed = appendSSHString(signed, nil)
+	signed = appendSSHString(signed, hashName)
+	signed = appendSSHString(signed, h.Sum(nil))
+	if !ed25519.Verify(key, signed, sig) {
+		return errSignatureMismatch
+	}
+	return nil
+}
+
+// appendSSHString appends s to b, prefixed with its length, as strings are
+// encoded in the SSH protocol.
+func appendSSHString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sshDecoder reads values encoded as in the SSH protocol, recording the first
+// error and returning zero values after it.
+type sshDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *sshDecoder) uint32() uint32 {
+	if len(d.data) < 4 {
+		d.err = io.ErrUnexpectedEOF
+		d.data = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.data)
+	d.data = d.data[4:]
+	return v
+}
+
+func (d *sshDecoder) string() []byte {
+	n := d.uint32()
+	if uint64(n) > uint64(len(d.data)) {
+		d.err = io.ErrUnexpectedEOF
+		d.data = nil
+		return nil
+	}
+	s := d.data[:n]
+	d.data = d.data[n:]
+	return s
+}
+
+// signingWriter hashes what's written to an output, and once the output is
+// committed and closed successfully, writes the detached signature of it by
+// key beside it.
+type signingWriter struct {
+	io.WriteCloser
+	commitOutput func()
+	committed    bool
+
+	path string
+	key  ed25519.PrivateKey
+	hash hash.Hash
+}
+
+func newSigningWriter(output io.WriteCloser, commit func(), path string, key ed25519.PrivateKey) *signingWriter {
+	return &signingWriter{WriteCloser: output, commitOutput: commit, path: path, key: key, hash: sha512.New()}
+}
+
+func (w *signingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+func (w *signingWriter) commit() {
+	w.committed = true
+	w.commitOutput()
+}
+
+func (w *signingWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil || !w.committed {
+		return err
+	}
+
+	sig, err := createAtomic(w.path+signatureExtension, "")
+	if err != nil {
+		return err
+	}
+	_, err = sig.Write(sshSignature(w.key, w.hash.Sum(nil)))
+	if err == nil {
+		sig.commit()
+	}
+	return errors.Join(err, sig.Close())
+}
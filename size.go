@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag value holding a number of bytes, accepting an optional
+// binary unit suffix such as "512K", "2G", or "1.5GiB".
+type byteSize int64
+
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"", 1},
+}
+
+func (s *byteSize) UnmarshalText(text []byte) error {
+	str := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(string(text)), "B"), "I")
+
+	for _, unit := range byteSizeUnits {
+		number, ok := strings.CutSuffix(str, unit.suffix)
+		if !ok {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil || value < 0 {
+			return fmt.Errorf("invalid size %q", text)
+		}
+
+		*s = byteSize(value * float64(unit.multiplier))
+		return nil
+	}
+
+	panic("unreachable")
+}
+
+func (s byteSize) String() string {
+	for _, unit := range byteSizeUnits[:len(byteSizeUnits)-1] {
+		if int64(s) >= unit.multiplier {
+			return fmt.Sprintf("%.1f %siB", float64(s)/float64(unit.multiplier), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", s)
+}
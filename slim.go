@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mholt/archives"
+)
+
+// contentFilter rewrites the contents of the regular files matching any of
+// globs as they're archived, writing the rewritten contents of src to dst.
+type contentFilter struct {
+	globs []glob
+	// failure is the warning printed when the filter fails, given the name
+	// of the file and the error.
+	failure string
+	apply   func(dst *os.File, src io.Reader) error
+}
+
+// contentFilters returns the filters given by --minify-json and
+// --strip-binaries, in the order they're tried.
+func contentFilters() []contentFilter {
+	var filters []contentFilter
+	if len(cli.Create.MinifyJSON) > 0 {
+		filters = append(filters, contentFilter{globs: cli.Create.MinifyJSON, failure: "failed to minify %s, so it was archived unchanged: %s", apply: minifyJSON})
+	}
+	if len(cli.Create.StripBinaries) > 0 {
+		filters = append(filters, contentFilter{globs: cli.Create.StripBinaries, failure: "failed to strip %s, so it was archived unchanged: %s", apply: stripBinary})
+	}
+	return filters
+}
+
+// withContentFilters applies the first of filters that matches each regular
+// file in files. Filtered files are rewritten to temporary files when their
+// size is first needed, which is just before they're archived, since formats
+// like tar store sizes before contents. The temporary files are kept within
+// budget.
+func withContentFilters(files []archives.FileInfo, filters []contentFilter, budget *tempBudget) {
+	if len(filters) == 0 {
+		return
+	}
+	for i, file := range files {
+		if !file.Mode().IsRegular() || file.Open == nil {
+			continue
+		}
+		name := strings.Trim(file.NameInArchive, "/")
+		j := slices.IndexFunc(filters, func(filter contentFilter) bool {
+			return slices.ContainsFunc(filter.globs, func(g glob) bool { return g.matches(name) })
+		})
+		if j < 0 {
+			continue
+		}
+
+		filtered := &filteredFile{FileInfo: file.FileInfo, name: file.NameInArchive, open: file.Open, filter: filters[j], budget: budget}
+		files[i].FileInfo, files[i].Open = filtered, filtered.Open
+	}
+}
+
+// filteredFile is a file whose contents are rewritten by a filter. If the
+// filter fails, a warning is printed and the original contents are archived.
+type filteredFile struct {
+	fs.FileInfo
+	name   string
+	open   func() (fs.File, error)
+	filter contentFilter
+	budget *tempBudget
+
+	once sync.Once
+	// temp holds the filtered contents, or is empty if they couldn't be
+	// filtered.
+	temp string
+	size int64
+}
+
+// run filters the contents once.
+func (f *filteredFile) run() {
+	f.once.Do(func() {
+		// Filters never make files larger, so the original size is
+		// claimed until the filtered size is known.
+		original := f.FileInfo.Size()
+		if !f.budget.claim(original) {
+			warn("%s was archived unchanged, since rewriting it would exceed --max-tmp", f.name)
+			return
+		}
+		temp, size, err := f.rewrite()
+		if err != nil {
+			f.budget.release(original)
+			warn(f.filter.failure, f.name, err)
+			return
+		}
+		f.budget.release(original - size)
+		f.temp, f.size = temp, size
+	})
+}
+
+func (f *filteredFile) rewrite() (string, int64, error) {
+	src, err := f.open()
+	if err != nil {
+		return "", 0, err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(cli.Create.Tempdir, "squish-filter-*")
+	if err != nil {
+		return "", 0, err
+	}
+	err = f.filter.apply(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	// strip replaces the file rather than rewriting it, so its size is
+	// found by its name.
+	var info fs.FileInfo
+	if err == nil {
+		info, err = os.Stat(dst.Name())
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", 0, err
+	}
+	return dst.Name(), info.Size(), nil
+}
+
+func (f *filteredFile) Size() int64 {
+	f.run()
+	if f.temp == "" {
+		return f.FileInfo.Size()
+	}
+	return f.size
+}
+
+// Open returns the filtered contents, whose temporary file is removed when
+// it's closed.
+func (f *filteredFile) Open() (fs.File, error) {
+	f.run()
+	if f.temp == "" {
+		return f.open()
+	}
+	file, err := os.Open(f.temp)
+	if err != nil {
+		return nil, err
+	}
+	return filteredContents{File: file, info: f}, nil
+}
+
+type filteredContents struct {
+	*os.File
+	info *filteredFile
+}
+
+func (c filteredContents) Stat() (fs.FileInfo, error) {
+	return c.info, nil
+}
+
+func (c filteredContents) Close() error {
+	err := c.File.Close()
+	if os.Remove(c.File.Name()) == nil {
+		c.info.budget.release(c.info.size)
+	}
+	return err
+}
+
+// tempBudget keeps the temporary files that filtered files are rewritten to
+// within --max-tmp in total. A nil *tempBudget is valid and limits nothing.
+type tempBudget struct {
+	limit int64
+
+	mu   sync.Mutex
+	used int64
+}
+
+func newTempBudget(limit int64) *tempBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &tempBudget{limit: limit}
+}
+
+// claim reports whether size more bytes fit in the budget, claiming them if
+// they do. They must be released once the file holding them is removed.
+func (b *tempBudget) claim(size int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+size > b.limit {
+		return false
+	}
+	b.used += size
+	return true
+}
+
+func (b *tempBudget) release(size int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= size
+}
+
+// minifyJSON writes src without insignificant whitespace.
+func minifyJSON(dst *os.File, src io.Reader) error {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, data); err != nil {
+		return err
+	}
+	_, err = compacted.WriteTo(dst)
+	return err
+}
+
+// stripBinary writes src with its symbols and debugging information removed
+// by strip, which fails for files that aren't object files or executables.
+func stripBinary(dst *os.File, src io.Reader) error {
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("strip", "--", dst.Name())
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("strip: %w", err)
+	}
+	return nil
+}
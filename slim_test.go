@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestContentFilters(t *testing.T) {
+	dir := t.TempDir()
+	contents := map[string]string{
+		"a.json":   "{\n  \"a\": [1, 2],\n  \"b\": \"x y\"\n}\n",
+		"bad.json": "{bad\n",
+		"c.txt":    "{ }\n",
+	}
+	for name, data := range contents {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := archives.FilesFromDisk(context.Background(), nil, map[string]string{dir: "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters := []contentFilter{{globs: []glob{"*.json"}, failure: "%s: %s", apply: minifyJSON}}
+	withContentFilters(files, filters, nil)
+
+	want := map[string]string{
+		"in/a.json":   `{"a":[1,2],"b":"x y"}`,
+		"in/bad.json": contents["bad.json"],
+		"in/c.txt":    contents["c.txt"],
+	}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want[file.NameInArchive] {
+			t.Errorf("%s: got %q, want %q", file.NameInArchive, data, want[file.NameInArchive])
+		}
+		if file.Size() != int64(len(data)) {
+			t.Errorf("%s: got size %d, want %d", file.NameInArchive, file.Size(), len(data))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+// snapshotVersion is the version of the snapshot format that's written, and
+// the only one that's read.
+const snapshotVersion = 1
+
+// snapshot records the metadata of the files in an archive created with
+// --listed-incremental, by their names in the archive, so that the next
+// archive created with it only includes the files that changed since. Like
+// the quick check of rsync, files are assumed to be unchanged if their size,
+// modification time and mode are.
+type snapshot struct {
+	Version int                      `json:"version"`
+	Files   map[string]snapshotEntry `json:"files"`
+}
+
+type snapshotEntry struct {
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+}
+
+// readSnapshot reads the snapshot at path, or returns an empty one if it
+// doesn't exist yet, in which case every file is archived.
+func readSnapshot(path string) (*snapshot, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &snapshot{Version: snapshotVersion, Files: map[string]snapshotEntry{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	if s.Files == nil {
+		s.Files = map[string]snapshotEntry{}
+	}
+	return &s, nil
+}
+
+// changed returns the files that are new or changed since s was taken, along
+// with the snapshot of every file in files. Directories are always included,
+// so that those that are empty, and the modes and times of the rest, are
+// kept.
+func (s *snapshot) changed(files []archives.FileInfo) ([]archives.FileInfo, *snapshot) {
+	next := &snapshot{Version: snapshotVersion, Files: make(map[string]snapshotEntry, len(files))}
+	changed := make([]archives.FileInfo, 0, len(files))
+	for _, file := range files {
+		name := strings.Trim(file.NameInArchive, "/")
+		entry := snapshotEntry{Size: file.Size(), ModTime: file.ModTime(), Mode: file.Mode()}
+		next.Files[name] = entry
+
+		previous, ok := s.Files[name]
+		if file.IsDir() || !ok || previous.Size != entry.Size || !previous.ModTime.Equal(entry.ModTime) || previous.Mode != entry.Mode {
+			changed = append(changed, file)
+		}
+	}
+	return changed, next
+}
+
+// writeSnapshot replaces the snapshot at path with s.
+func writeSnapshot(path string, s *snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := createAtomic(path, "")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	f.commit()
+	return f.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, name := range []string{"same", "changed", "chmodded"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	discover := func() []archives.FileInfo {
+		files, err := archives.FilesFromDisk(ctx, nil, map[string]string{dir: "in"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	names := func(files []archives.FileInfo) []string {
+		var names []string
+		for _, file := range files {
+			names = append(names, file.NameInArchive)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot")
+	first, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, next := first.changed(discover())
+	if got, want := names(files), []string{"in", "in/changed", "in/chmodded", "in/same"}; !slices.Equal(got, want) {
+		t.Errorf("got %q in the first archive, want %q", got, want)
+	}
+	if err := writeSnapshot(path, next); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "changed"), []byte("more"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Changing only the modification time counts too.
+	if err := os.Chtimes(filepath.Join(dir, "changed"), time.Time{}, time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "chmodded"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	second, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ = second.changed(discover())
+	if got, want := names(files), []string{"in", "in/changed", "in/chmodded", "in/new"}; !slices.Equal(got, want) {
+		t.Errorf("got %q in the second archive, want %q", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// reserveError is returned for entries that would leave less than
+// --reserve-space free on the filesystem of the output if they were written.
+type reserveError struct {
+	name    string
+	reserve int64
+}
+
+func (e *reserveError) Error() string {
+	return fmt.Sprintf("writing %s would leave less than %s free on the output filesystem", e.name, byteSize(e.reserve))
+}
+
+// spaceReserve keeps --reserve-space free on the filesystem of the output at
+// path, by claiming space for entries before they're written. A nil
+// *spaceReserve is valid and claims nothing.
+type spaceReserve struct {
+	path    string
+	reserve int64
+
+	mu sync.Mutex
+	// claimed is the space claimed by entries that are still being written,
+	// which the filesystem may not have accounted for yet.
+	claimed int64
+}
+
+func newSpaceReserve(path string, reserve int64) *spaceReserve {
+	if reserve <= 0 {
+		return nil
+	}
+	return &spaceReserve{path: path, reserve: reserve}
+}
+
+// claim claims size bytes for writing name, or returns a *reserveError if
+// that would leave too little free. The space must be released once it's
+// written.
+func (s *spaceReserve) claim(name string, size int64) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	free, err := freeSpace(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to check free space of output filesystem: %w", err)
+	}
+	if free-s.claimed-size < s.reserve {
+		return &reserveError{name: name, reserve: s.reserve}
+	}
+	s.claimed += size
+	return nil
+}
+
+func (s *spaceReserve) release(size int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claimed -= size
+}
+
+// reserveWriter claims space for each write to the output name before making
+// it, for outputs whose size isn't known in advance.
+type reserveWriter struct {
+	io.Writer
+	s    *spaceReserve
+	name string
+}
+
+func (w reserveWriter) Write(p []byte) (int, error) {
+	if err := w.s.claim(w.name, int64(len(p))); err != nil {
+		return 0, err
+	}
+	defer w.s.release(int64(len(p)))
+	return w.Writer.Write(p)
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// freeSpace returns the number of bytes available to unprivileged users on
+// the filesystem containing path.
+func freeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * stat.Bsize, nil
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestExtractReserveSpace(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "d/", typeflag: tar.TypeDir},
+		{name: "d/f", typeflag: tar.TypeReg, contents: "f"},
+	})
+
+	tests := []struct {
+		whenFull string
+		wantErr  bool
+		want     map[string]string
+	}{
+		{"stop", true, map[string]string{"d": "/"}},
+		{"rollback", true, map[string]string{}},
+		{"skip", false, map[string]string{"d": "/"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.whenFull, func(t *testing.T) {
+			// No filesystem has this much free.
+			e := &entryExtractor{space: newSpaceReserve(t.TempDir(), 1<<62), whenFull: test.whenFull}
+			_, output, err := extractTest(t, archives.Tar{}, archive, e)
+			var reserveErr *reserveError
+			if errors.As(err, &reserveErr) != test.wantErr {
+				t.Fatalf("got error %v, want reserve error: %t", err, test.wantErr)
+			}
+			if test.whenFull == "rollback" {
+				if removed := e.rollback(); removed != 1 {
+					t.Errorf("rollback removed %d entries, want 1", removed)
+				}
+			}
+
+			got := readTree(t, output)
+			if !maps.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func freeSpace(string) (int64, error) {
+	return 0, errors.New("--reserve-space is only supported on Linux")
+}
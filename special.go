@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/fs"
+
+	"github.com/mholt/archives"
+)
+
+// isSpecialFile reports whether mode is that of a named pipe, device, socket,
+// or anything else that isn't a regular file, directory, or symbolic link.
+func isSpecialFile(mode fs.FileMode) bool {
+	return mode.Type()&^(fs.ModeDir|fs.ModeSymlink) != 0
+}
+
+// withoutSpecialFiles removes the special files from files, warning about
+// each, unless store is true, in which case only sockets are removed, since
+// tar archives can't store them. store is false if --special-files wasn't
+// given, or the format can't store special files.
+func withoutSpecialFiles(files []archives.FileInfo, store bool) []archives.FileInfo {
+	kept := files[:0]
+	for _, file := range files {
+		switch mode := file.Mode(); {
+		case !isSpecialFile(mode):
+		case mode&fs.ModeSocket != 0:
+			warn("skipped socket %s, which can't be stored in archives", file.NameInArchive)
+			continue
+		case !store && cli.Create.SpecialFiles:
+			warn("skipped special file %s, which can't be stored by the identified format", file.NameInArchive)
+			continue
+		case !store:
+			warn("skipped special file %s, which is only archived with --special-files", file.NameInArchive)
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
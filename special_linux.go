@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// specialFilesSupported is whether special files can be restored.
+const specialFilesSupported = true
+
+func mknod(path string, mode fs.FileMode, major, minor int64) error {
+	if err := syscall.Mknod(path, unixFileType(mode)|uint32(mode.Perm()), mkdev(major, minor)); err != nil {
+		return &fs.PathError{Op: "mknod", Path: path, Err: err}
+	}
+	return nil
+}
+
+// unixFileType returns the file type bits of st_mode for the special file
+// mode.
+func unixFileType(mode fs.FileMode) uint32 {
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return syscall.S_IFIFO
+	case mode&fs.ModeCharDevice != 0:
+		return syscall.S_IFCHR
+	case mode&fs.ModeDevice != 0:
+		return syscall.S_IFBLK
+	case mode&fs.ModeSocket != 0:
+		return syscall.S_IFSOCK
+	}
+	return syscall.S_IFREG
+}
+
+// mkdev encodes a device number as glibc's makedev does.
+func mkdev(major, minor int64) int {
+	return int((major&0xfffff000)<<32 | (major&0xfff)<<8 | (minor&0xffffff00)<<12 | minor&0xff)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"archive/tar"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestExtractSpecialFiles(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a", typeflag: tar.TypeReg, contents: "a"},
+		{name: "fifo", typeflag: tar.TypeFifo},
+	})
+
+	for _, specialFiles := range []bool{false, true} {
+		_, output, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{specialFiles: specialFiles})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]string{"a": "a"}
+		if specialFiles {
+			want["fifo"] = "?"
+		}
+		if got := readTree(t, output); !maps.Equal(got, want) {
+			t.Errorf("special files %t: got output %v, want %v", specialFiles, got, want)
+		}
+		if !specialFiles {
+			continue
+		}
+
+		info, err := os.Lstat(filepath.Join(output, "fifo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Type() != fs.ModeNamedPipe {
+			t.Errorf("got mode %s, want a named pipe", info.Mode())
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// specialFilesSupported is whether special files can be restored.
+const specialFilesSupported = false
+
+func mknod(string, fs.FileMode, int64, int64) error {
+	return errors.New("special files are only supported on Linux")
+}
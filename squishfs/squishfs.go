@@ -0,0 +1,61 @@
+// Package squishfs gives read-only access to the entries of archives in any of
+// the formats squish can extract, as an fs.FS. It depends on nothing from the
+// squish command, so it's light enough to import into tools that only need to
+// look inside archives, like web services that inspect uploads.
+package squishfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/mholt/archives"
+)
+
+// ErrNotArchive is returned for inputs that aren't archives, including those
+// that are only compressed.
+var ErrNotArchive = errors.New("not an archive")
+
+// Open returns the entries of the archive at path, which is reopened whenever
+// they're read. Its format is identified by its name and contents.
+func Open(ctx context.Context, path string) (fs.FS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	format, err := identify(ctx, path, f)
+	if err != nil {
+		return nil, err
+	}
+	return archives.ArchiveFS{Path: path, Format: format, Context: ctx}, nil
+}
+
+// FromReader returns the entries of the archive of size bytes read from r,
+// which must stay open while they're read. Its format is identified by its
+// contents, and by name if it isn't empty, e.g. the name of an upload.
+func FromReader(ctx context.Context, name string, r io.ReaderAt, size int64) (fs.FS, error) {
+	format, err := identify(ctx, name, io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	return archives.ArchiveFS{Stream: io.NewSectionReader(r, 0, size), Format: format, Context: ctx}, nil
+}
+
+func identify(ctx context.Context, name string, stream io.Reader) (archives.Extractor, error) {
+	format, _, err := archives.Identify(ctx, name, stream)
+	if errors.Is(err, archives.NoMatch) {
+		return nil, ErrNotArchive
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to identify format: %w", err)
+	}
+	extractor, ok := format.(archives.Extractor)
+	if !ok {
+		return nil, ErrNotArchive
+	}
+	return extractor, nil
+}
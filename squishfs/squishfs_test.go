@@ -0,0 +1,75 @@
+package squishfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestFromReader(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := archives.FilesFromDisk(ctx, nil, map[string]string{dir: "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	format := archives.CompressedArchive{Compression: archives.Gz{}, Archival: archives.Tar{}}
+	if err := format.Archive(ctx, &archive, files); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := FromReader(ctx, "", bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(fsys, "in/a"); err != nil || string(data) != "b\n" {
+		t.Errorf("got %q, %v reading in/a, want \"b\\n\"", data, err)
+	}
+	var names []string
+	err = fs.WalkDir(fsys, ".", func(name string, _ fs.DirEntry, err error) error {
+		names = append(names, name)
+		return err
+	})
+	if want := []string{".", "in", "in/a"}; err != nil || !slices.Equal(names, want) {
+		t.Errorf("got %q, %v walking archive, want %q", names, err, want)
+	}
+
+	path := filepath.Join(dir, "archive.tar.gz")
+	if err := os.WriteFile(path, archive.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if fsys, err = Open(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(fsys, "in/a"); err != nil || string(data) != "b\n" {
+		t.Errorf("got %q, %v reading in/a from %s, want \"b\\n\"", data, err, path)
+	}
+}
+
+func TestNotArchive(t *testing.T) {
+	var compressed bytes.Buffer
+	w, err := archives.Gz{}.OpenWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("not an archive\n"))
+	w.Close()
+
+	for _, data := range [][]byte{[]byte("not an archive\n"), compressed.Bytes()} {
+		_, err := FromReader(context.Background(), "", bytes.NewReader(data), int64(len(data)))
+		if !errors.Is(err, ErrNotArchive) {
+			t.Errorf("got %v for %q, want %v", err, data, ErrNotArchive)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+func stat(ctx context.Context) {
+	selector := newEntrySelector(cli.Stat.Entry, cli.Stat.EntryIndex)
+
+	input, extractor, inputR := openExtractor(ctx, cli.Stat.Input)
+	defer closeInput(input)
+	globals := &paxGlobals{}
+	extractor = withPAXGlobals(extractor, globals)
+
+	found := false
+	err := extractor.Extract(ctx, inputR, func(ctx context.Context, info archives.FileInfo) error {
+		if !selector.selects(info) {
+			return nil
+		}
+		found = true
+
+		fmt.Printf("name:     %s\n", info.NameInArchive)
+		fmt.Printf("size:     %d\n", info.Size())
+		fmt.Printf("mode:     %s\n", info.Mode())
+		fmt.Printf("modified: %s\n", info.ModTime())
+		if info.LinkTarget != "" {
+			fmt.Printf("target:   %s\n", info.LinkTarget)
+		}
+		records := entryMetaRecords(info)
+		keys := make([]string, 0, len(records))
+		for key := range records {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			fmt.Printf("meta:     %s=%s\n", key, records[key])
+		}
+
+		if cli.Stat.Raw {
+			printRawHeader(info.Header)
+			records := globals.records()
+			keys := make([]string, 0, len(records))
+			for key := range records {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			for _, key := range keys {
+				fmt.Printf("global:   %s=%s\n", key, records[key])
+			}
+		}
+
+		return fs.SkipAll
+	})
+	if err != nil {
+		bail("failed to read archive: %s", err)
+	}
+
+	if !found {
+		selector.bailNotFound()
+	}
+}
+
+// entrySelector selects a single entry of an archive, either by its path or
+// by its index among all entries in the order they're stored, counting from
+// 0, which is useful when names are duplicated or awkward to pass through a
+// shell.
+type entrySelector struct {
+	name  string
+	index *int
+	seen  int
+}
+
+// newEntrySelector bails unless exactly one of name and index is given.
+func newEntrySelector(name string, index *int) *entrySelector {
+	if name != "" && index != nil {
+		bail("an entry path and --entry-index can't both be given")
+	}
+	if name == "" && index == nil {
+		bail("an entry path or --entry-index must be given")
+	}
+	if index != nil && *index < 0 {
+		bail("invalid entry index: %d", *index)
+	}
+
+	return &entrySelector{name: name, index: index}
+}
+
+// selects reports whether info is the selected entry. It must be called for
+// every entry, in order.
+func (s *entrySelector) selects(info archives.FileInfo) bool {
+	i := s.seen
+	s.seen++
+
+	if s.index != nil {
+		return i == *s.index
+	}
+	return path.Clean(info.NameInArchive) == path.Clean(s.name)
+}
+
+func (s *entrySelector) bailNotFound() {
+	if s.index != nil {
+		bail("there is no entry at index %d in the archive", *s.index)
+	}
+	bail("entry %s not found in archive", s.name)
+}
+
+func (s *entrySelector) String() string {
+	if s.index != nil {
+		return fmt.Sprintf("entry at index %d", *s.index)
+	}
+	return "entry " + s.name
+}
+
+func printRawHeader(header any) {
+	switch header := header.(type) {
+	case *tar.Header:
+		fmt.Printf("typeflag: %q\n", header.Typeflag)
+		fmt.Printf("uid:      %d\n", header.Uid)
+		fmt.Printf("gid:      %d\n", header.Gid)
+		fmt.Printf("uname:    %s\n", header.Uname)
+		fmt.Printf("gname:    %s\n", header.Gname)
+		fmt.Printf("format:   %s\n", header.Format)
+		keys := make([]string, 0, len(header.PAXRecords))
+		for key := range header.PAXRecords {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			fmt.Printf("pax:      %s=%s\n", key, header.PAXRecords[key])
+		}
+
+	case zip.FileHeader:
+		fmt.Printf("method:   %d\n", header.Method)
+		fmt.Printf("flags:    %#04x\n", header.Flags)
+		fmt.Printf("creator:  %#04x\n", header.CreatorVersion)
+		fmt.Printf("external: %#08x\n", header.ExternalAttrs)
+		fmt.Printf("crc32:    %#08x\n", header.CRC32)
+		if len(header.Extra) > 0 {
+			fmt.Printf("extra:    %x\n", header.Extra)
+		}
+		if header.Comment != "" {
+			fmt.Printf("comment:  %s\n", header.Comment)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEntryIndex(t *testing.T) {
+	// Entries with the same name can only be told apart by their index.
+	archive := filepath.Join(t.TempDir(), "in.tar")
+	data := makeTar(t, []testEntry{
+		{name: "a", typeflag: tar.TypeReg, contents: "first"},
+		{name: "a", typeflag: tar.TypeReg, contents: "second"},
+		{name: "d/", typeflag: tar.TypeDir},
+	})
+	if err := os.WriteFile(archive, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args []string) (output string, code int) {
+		parseCLI(t, args...)
+		output = captureStdout(t, func() {
+			code = runCommand(t, func() {
+				if args[0] == "cat" {
+					cat(context.Background())
+				} else {
+					stat(context.Background())
+				}
+			})
+		})
+		return output, code
+	}
+
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"cat", archive, "a"}, "first"},
+		{[]string{"cat", "--entry-index", "0", archive}, "first"},
+		{[]string{"cat", "--entry-index", "1", archive}, "second"},
+		{[]string{"stat", "--entry-index", "1", archive}, "name:     a\nsize:     6\n"},
+		{[]string{"stat", "--entry-index", "2", archive}, "name:     d/\nsize:     0\n"},
+	} {
+		got, code := run(test.args)
+		if code != 0 || !strings.HasPrefix(got, test.want) {
+			t.Errorf("%q: got %q and exit code %d, want %q", test.args, got, code, test.want)
+		}
+	}
+
+	// Exactly one of an entry path and an index must be given, and the
+	// index must be of an entry in the archive, which cat can only write if
+	// it's a regular file.
+	for _, args := range [][]string{
+		{"cat", "--entry-index", "0", archive, "a"},
+		{"cat", archive},
+		{"cat", "--entry-index", "3", archive},
+		{"cat", "--entry-index=-1", archive},
+		{"cat", "--entry-index", "2", archive},
+		{"stat", "--entry-index", "0", archive, "a"},
+		{"stat", archive},
+		{"stat", "--entry-index", "3", archive},
+	} {
+		got, code := run(args)
+		if code == 0 || got != "" {
+			t.Errorf("%q: got %q and exit code %d, want an error", args, got, code)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+// opStats are the times reported by --stats: how long was spent walking the
+// inputs of create, reading inputs and writing outputs, summed across
+// threads, and the rest of the time, which is mostly spent compressing or
+// decompressing. A nil *opStats is valid and measures nothing.
+type opStats struct {
+	started                   time.Time
+	walking, reading, writing atomic.Int64
+}
+
+// stats is set by --stats.
+var stats *opStats
+
+// walked counts the time since start as spent walking the inputs.
+func (s *opStats) walked(start time.Time) {
+	if s != nil {
+		s.walking.Add(int64(time.Since(start)))
+	}
+}
+
+// input returns f, with the time spent reading it counted.
+func (s *opStats) input(f inputFile) inputFile {
+	if s == nil {
+		return f
+	}
+	return timedInput{f, s}
+}
+
+// reader returns r, with the time spent reading it counted.
+func (s *opStats) reader(r io.Reader) io.Reader {
+	if s == nil {
+		return r
+	}
+	return timedReader{r, s}
+}
+
+// output returns w, with the time spent writing it counted.
+func (s *opStats) output(w io.WriteCloser) io.WriteCloser {
+	if s == nil {
+		return w
+	}
+	return timedWriter{w, s}
+}
+
+// file returns file, with the time spent reading it counted once it's opened.
+func (s *opStats) file(file archives.FileInfo) archives.FileInfo {
+	if s == nil || file.Open == nil {
+		return file
+	}
+	open := file.Open
+	file.Open = func() (fs.File, error) {
+		start := time.Now()
+		f, err := open()
+		s.reading.Add(int64(time.Since(start)))
+		if err != nil {
+			return nil, err
+		}
+		return timedFile{f, s}, nil
+	}
+	return file
+}
+
+type timedReader struct {
+	r io.Reader
+	s *opStats
+}
+
+func (r timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.r.Read(p)
+	r.s.reading.Add(int64(time.Since(start)))
+	return n, err
+}
+
+type timedInput struct {
+	inputFile
+	s *opStats
+}
+
+func (f timedInput) Read(p []byte) (int, error) {
+	return timedReader{f.inputFile, f.s}.Read(p)
+}
+
+func (f timedInput) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.inputFile.ReadAt(p, off)
+	f.s.reading.Add(int64(time.Since(start)))
+	return n, err
+}
+
+type timedFile struct {
+	fs.File
+	s *opStats
+}
+
+func (f timedFile) Read(p []byte) (int, error) {
+	return timedReader{f.File, f.s}.Read(p)
+}
+
+type timedWriter struct {
+	io.WriteCloser
+	s *opStats
+}
+
+func (w timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.WriteCloser.Write(p)
+	w.s.writing.Add(int64(time.Since(start)))
+	return n, err
+}
+
+// print prints the times to stderr, along with how much memory was used,
+// naming the rest of the time after what command mostly spends it on.
+func (s *opStats) print(command string) {
+	if s == nil {
+		return
+	}
+	total := time.Since(s.started)
+	walking, reading, writing := time.Duration(s.walking.Load()), time.Duration(s.reading.Load()), time.Duration(s.writing.Load())
+	rest := "other"
+	switch command {
+	case "create":
+		rest = "compressing"
+	case "extract":
+		rest = "decompressing"
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	round := func(d time.Duration) time.Duration { return max(d, 0).Round(time.Millisecond) }
+	if command == "create" {
+		fmt.Fprintf(os.Stderr, "walking:       %s\n", round(walking))
+	}
+	fmt.Fprintf(os.Stderr, "reading:       %s\n", round(reading))
+	fmt.Fprintf(os.Stderr, "writing:       %s\n", round(writing))
+	fmt.Fprintf(os.Stderr, "%-14s %s\n", rest+":", round(total-walking-reading-writing))
+	fmt.Fprintf(os.Stderr, "total:         %s\n", round(total))
+	fmt.Fprintf(os.Stderr, "memory:        %s from the OS, %s allocated, %d GCs\n", byteSize(mem.Sys), byteSize(mem.TotalAlloc), mem.NumGC)
+}
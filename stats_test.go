@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowWriter is a writer that takes at least a millisecond to write.
+type slowWriter struct{ bytes.Buffer }
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return w.Buffer.Write(p)
+}
+
+func (w *slowWriter) Close() error { return nil }
+
+func TestStats(t *testing.T) {
+	var nilStats *opStats
+	r := strings.NewReader("contents")
+	if nilStats.reader(r) != io.Reader(r) {
+		t.Error("nil stats wrapped a reader")
+	}
+	nilStats.walked(time.Now())
+	nilStats.print("create")
+
+	s := &opStats{started: time.Now()}
+	var w slowWriter
+	if _, err := io.Copy(s.output(&w), s.reader(readerOnly{strings.NewReader("contents")})); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != "contents" {
+		t.Errorf("got %q", w.String())
+	}
+	if s.writing.Load() < int64(time.Millisecond) {
+		t.Errorf("counted %s writing, want at least 1ms", time.Duration(s.writing.Load()))
+	}
+	if s.reading.Load() <= 0 {
+		t.Error("counted no time reading")
+	}
+}
+
+// readerOnly hides the WriterTo of a reader, so that it's read through Read.
+type readerOnly struct{ io.Reader }
+
+func TestStartProfiling(t *testing.T) {
+	dir := t.TempDir()
+	cli.CPUProfile, cli.MemProfile, cli.Trace = filepath.Join(dir, "cpu"), filepath.Join(dir, "mem"), filepath.Join(dir, "trace")
+	defer func() { cli.CPUProfile, cli.MemProfile, cli.Trace = "", "", "" }()
+	startProfiling()()
+	for _, name := range []string{"cpu", "mem", "trace"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		} else if info.Size() == 0 {
+			t.Errorf("%s profile is empty", name)
+		}
+	}
+}
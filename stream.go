@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// streamHeadSize is how much of the start of a stream input is kept in
+// memory, so that its format can be identified by reading it and seeking
+// back, as with regular files.
+const streamHeadSize = 4 << 20
+
+var errStreamSeek = errors.New("can't seek in an input that isn't a regular file or block device")
+
+// isStream reports whether info is of a file that can't be seeked, like a tape
+// drive, which is a character device, or a named pipe.
+func isStream(info fs.FileInfo) bool {
+	return info.Mode()&(fs.ModeCharDevice|fs.ModeNamedPipe|fs.ModeSocket) != 0
+}
+
+// streamInput reads an input that can't be seeked in blocks of a fixed size,
+// since tape drives fail reads that are smaller than the blocks they were
+// written with. The start of the input is kept until more than
+// streamHeadSize has been read, and can be seeked within until then, while
+// it can always be seeked forward.
+type streamInput struct {
+	file  *os.File
+	block []byte
+
+	// buf holds what's been read from the file from bufStart up to end,
+	// which is the whole head while it's kept, and otherwise what's left
+	// of the last block. pos is the position of the next Read.
+	buf           []byte
+	bufStart, end int64
+	pos           int64
+	keepHead      bool
+}
+
+func newStreamInput(file *os.File, blockSize int) *streamInput {
+	return &streamInput{file: file, block: make([]byte, blockSize), keepHead: true}
+}
+
+// fill reads the next block from the file.
+func (s *streamInput) fill() error {
+	n, err := s.file.Read(s.block)
+	if s.keepHead && len(s.buf)+n <= streamHeadSize {
+		s.buf = append(s.buf, s.block[:n]...)
+	} else {
+		s.keepHead = false
+		s.buf, s.bufStart = s.block[:n], s.end
+	}
+	s.end += int64(n)
+	if n == 0 && err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+func (s *streamInput) Read(p []byte) (int, error) {
+	if s.pos == s.end {
+		if err := s.fill(); s.pos == s.end {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf[s.pos-s.bufStart:])
+	s.pos += int64(n)
+	return n, nil
+}
+
+// ReadAt reads from the head of the input, reading further into it if
+// necessary, which is enough to identify its format.
+func (s *streamInput) ReadAt(p []byte, off int64) (int, error) {
+	for s.keepHead && s.end < off+int64(len(p)) {
+		if err := s.fill(); err != nil {
+			break
+		}
+	}
+	if off < s.bufStart || off > s.end {
+		return 0, errStreamSeek
+	}
+	n := copy(p, s.buf[off-s.bufStart:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *streamInput) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	default:
+		return 0, errStreamSeek
+	}
+	if offset < s.bufStart {
+		return 0, errStreamSeek
+	}
+	// Seeking forward reads what's skipped, as tar does to skip the
+	// contents of entries.
+	for s.end < offset {
+		if err := s.fill(); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	s.pos = min(offset, s.end)
+	return offset, nil
+}
+
+func (s *streamInput) Close() error {
+	return s.file.Close()
+}
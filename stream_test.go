@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestStreamInput(t *testing.T) {
+	data := make([]byte, streamHeadSize+1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	info, err := r.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isStream(info) {
+		t.Fatal("pipe isn't a stream")
+	}
+	s := newStreamInput(r, 1000)
+	defer s.Close()
+
+	// The head can be read and seeked within, as identification does.
+	header := make([]byte, 10)
+	if _, err := s.ReadAt(header, 0); err != nil || !bytes.Equal(header, data[:10]) {
+		t.Fatalf("got %x, %v from ReadAt", header, err)
+	}
+	buf := make([]byte, 5000)
+	if _, err := io.ReadFull(s, buf); err != nil || !bytes.Equal(buf, data[:5000]) {
+		t.Fatalf("got %v reading head", err)
+	}
+	if _, err := s.Seek(100, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(s, buf); err != nil || !bytes.Equal(buf, data[100:5100]) {
+		t.Fatalf("got %v reading head after seeking", err)
+	}
+	if _, err := s.Seek(0, io.SeekEnd); err == nil {
+		t.Error("seeked to the end of a stream")
+	}
+
+	// Seeking forward past the head skips what's between.
+	offset := int64(streamHeadSize + 12345)
+	if pos, err := s.Seek(offset, io.SeekStart); err != nil || pos != offset {
+		t.Fatalf("got %d, %v seeking past head", pos, err)
+	}
+	if _, err := s.Seek(0, io.SeekStart); err == nil {
+		t.Error("seeked back after the head was dropped")
+	}
+	rest, err := io.ReadAll(s)
+	if err != nil || !bytes.Equal(rest, data[offset:]) {
+		t.Errorf("got %d bytes, %v after seeking past head, want %d", len(rest), err, len(data)-int(offset))
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// syncArchive makes the archive given by cli.Sync.Archive exactly reflect the
+// directory, rewriting as little of it as its format allows.
+func syncArchive(ctx context.Context) {
+	dir := cli.Sync.Directory
+	found, err := archives.FilesFromDisk(ctx, nil, map[string]string{dir: filepath.Base(dir)})
+	if err != nil {
+		bail("failed to discover files: %s", err)
+	}
+	files := withoutSpecialFiles(withoutOutputs(found, existingOutputs(cli.Sync.Archive, false)), false)
+	files = filterFiles(files, nil, cli.Sync.Exclude)
+
+	var format archives.Format
+	if cli.Sync.Format != "" {
+		format, err = squish.LookupFormat(cli.Sync.Format)
+	} else {
+		format, _, err = archives.Identify(ctx, cli.Sync.Archive, nil)
+	}
+	if err != nil {
+		bail("failed to identify format: %s", err)
+	}
+	archiver, ok := format.(archives.Archiver)
+	extractor, canExtract := format.(archives.Extractor)
+	if !ok || !canExtract {
+		bail("identified format doesn't support archiving")
+	}
+
+	// The global headers of tar archives are kept when they're rewritten.
+	globals := &paxGlobals{}
+	archived, err := readSyncEntries(ctx, cli.Sync.Archive, withPAXGlobals(extractor, globals))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		bail("failed to read archive: %s", err)
+	}
+	plan := planSync(archived, files)
+
+	progress := newProgress("")
+	defer progress.clear()
+	if cli.Verbose > 0 || cli.Sync.DryRun {
+		for _, change := range plan.changes {
+			progress.println(os.Stdout, change)
+		}
+	}
+	if cli.Sync.DryRun || (archived != nil && len(plan.changes) == 0 && !plan.duplicates) {
+		return
+	}
+
+	var changed []archives.FileInfo
+	for i, file := range files {
+		files[i] = interruptibleFile(ctx, progress.file(file))
+		if !plan.unchanged[syncName(file.NameInArchive)] {
+			changed = append(changed, files[i])
+		}
+	}
+
+	// Only the added and modified files are read when appending to tar
+	// archives and copying the rest of zips.
+	appendTar := archived != nil && isPlainTar(format) && plan.onlyAdded()
+	zipFormat, copyZip := archiver.(archives.Zip)
+	copyZip = copyZip && archived != nil
+	read := files
+	if appendTar || copyZip {
+		read = changed
+	}
+	progress.setTotal(totalSize(read))
+	history.setInputBytes(totalSize(read))
+
+	switch {
+	case appendTar:
+		var u *tarUpdate
+		if u, err = readTarUpdate(cli.Sync.Archive); err == nil {
+			err = u.append(cli.Sync.Archive, func(w io.Writer) error {
+				return archiver.Archive(ctx, w, changed)
+			})
+		}
+	case copyZip:
+		err = syncZip(ctx, cli.Sync.Archive, zipFormat, files, plan.unchanged)
+	default:
+		err = rewriteArchive(ctx, cli.Sync.Archive, withPAXGlobals(archiver, globals), files)
+	}
+	if err != nil {
+		bail("failed to update archive: %s", err)
+	}
+}
+
+// syncName returns the name of an entry as it's compared between the archive
+// and the directory.
+func syncName(name string) string {
+	return path.Clean(strings.TrimPrefix(name, "/"))
+}
+
+// readSyncEntries returns the metadata of the entries in the archive at
+// archivePath, by their names, without reading their contents. Entries that
+// appear more than once are returned with nil metadata, since the archive has
+// to be rewritten to remove the extra copies.
+func readSyncEntries(ctx context.Context, archivePath string, format archives.Extractor) (map[string]fs.FileInfo, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := map[string]fs.FileInfo{}
+	err = format.Extract(ctx, f, func(_ context.Context, info archives.FileInfo) error {
+		name := syncName(info.NameInArchive)
+		if _, ok := entries[name]; ok {
+			entries[name] = nil
+		} else {
+			entries[name] = info.FileInfo
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// syncPlan is how an archive differs from the directory it mirrors.
+type syncPlan struct {
+	// changes describe each entry to be added (A), modified (M) or deleted
+	// (D), with the deleted entries last.
+	changes []string
+	added   int
+	// unchanged are the names of the entries that can be kept as they are.
+	unchanged map[string]bool
+	// duplicates is whether any entries appear more than once in the
+	// archive.
+	duplicates bool
+}
+
+func (p *syncPlan) onlyAdded() bool {
+	return p.added == len(p.changes) && !p.duplicates
+}
+
+// planSync compares the entries in the archive with files. Regular files are
+// unchanged if their type, mode and size are the same and their modification
+// times are within a second, since formats store them at different
+// precisions. Directories are only compared by their modes, since their
+// modification times change whenever their contents do.
+func planSync(archived map[string]fs.FileInfo, files []archives.FileInfo) *syncPlan {
+	plan := &syncPlan{unchanged: map[string]bool{}}
+	seen := map[string]bool{}
+	for _, file := range files {
+		name := syncName(file.NameInArchive)
+		seen[name] = true
+
+		entry, ok := archived[name]
+		if !ok {
+			plan.changes = append(plan.changes, "A "+name)
+			plan.added++
+			continue
+		}
+		if entry == nil {
+			plan.duplicates = true
+			plan.changes = append(plan.changes, "M "+name)
+			continue
+		}
+
+		same := entry.Mode() == file.Mode()
+		if same && !file.IsDir() {
+			delta := entry.ModTime().Sub(file.ModTime())
+			same = entry.Size() == file.Size() && delta < time.Second && delta > -time.Second
+		}
+		if same {
+			plan.unchanged[name] = true
+		} else {
+			plan.changes = append(plan.changes, "M "+name)
+		}
+	}
+
+	var deleted []string
+	for name := range archived {
+		if !seen[name] {
+			deleted = append(deleted, "D "+name)
+		}
+	}
+	slices.Sort(deleted)
+	plan.changes = append(plan.changes, deleted...)
+	return plan
+}
+
+// rewriteArchive replaces the archive at archivePath with one of files.
+func rewriteArchive(ctx context.Context, archivePath string, format archives.Archiver, files []archives.FileInfo) error {
+	output, err := createAtomic(archivePath, "")
+	if err != nil {
+		return err
+	}
+	if err := format.Archive(ctx, output, files); err != nil {
+		output.Close()
+		return err
+	}
+	output.commit()
+	return output.Close()
+}
+
+// syncZip replaces the zip at archivePath with one of files, copying the
+// compressed data of the entries named in unchanged from it, so that only the
+// files that were added or modified are compressed. They're compressed into a
+// scratch zip by format first, so that their entries are the same as those
+// of an archive created from scratch.
+func syncZip(ctx context.Context, archivePath string, format archives.Zip, files []archives.FileInfo, unchanged map[string]bool) error {
+	old, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	scratch, err := os.CreateTemp(filepath.Dir(archivePath), "."+filepath.Base(archivePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		scratch.Close()
+		os.Remove(scratch.Name())
+	}()
+	var changed []archives.FileInfo
+	for _, file := range files {
+		if !unchanged[syncName(file.NameInArchive)] {
+			changed = append(changed, file)
+		}
+	}
+	if err := format.Archive(ctx, scratch, changed); err != nil {
+		return err
+	}
+	size, err := scratch.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	fresh, err := zip.NewReader(scratch, size)
+	if err != nil {
+		return err
+	}
+
+	entries := func(files []*zip.File) map[string]*zip.File {
+		byName := make(map[string]*zip.File, len(files))
+		for _, f := range files {
+			byName[syncName(f.Name)] = f
+		}
+		return byName
+	}
+	oldEntries, freshEntries := entries(old.File), entries(fresh.File)
+
+	output, err := createAtomic(archivePath, "")
+	if err != nil {
+		return err
+	}
+	write := func() error {
+		zw := zip.NewWriter(output)
+		if err := zw.SetComment(old.Comment); err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := syncName(file.NameInArchive)
+			entry := freshEntries[name]
+			if unchanged[name] {
+				entry = oldEntries[name]
+			}
+			if entry == nil {
+				continue
+			}
+			if err := zw.Copy(entry); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return zw.Close()
+	}
+	if err := write(); err != nil {
+		output.Close()
+		return err
+	}
+	output.commit()
+	return output.Close()
+}
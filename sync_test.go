@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, name := range []string{"same", "changed", "deleted"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	discover := func() []archives.FileInfo {
+		files, err := archives.FilesFromDisk(ctx, nil, map[string]string{dir: "in"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "out.zip")
+	format := archives.Zip{Compression: zip.Deflate}
+	if err := rewriteArchive(ctx, archivePath, format, discover()); err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(filepath.Join(dir, "changed"), []byte("changed again"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "changed"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "deleted")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "added"), []byte("added"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	archived, err := readSyncEntries(ctx, archivePath, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := discover()
+	plan := planSync(archived, files)
+	if want := []string{"A in/added", "M in/changed", "D in/deleted"}; !slices.Equal(plan.changes, want) {
+		t.Errorf("got changes %q, want %q", plan.changes, want)
+	}
+	if plan.onlyAdded() {
+		t.Error("plan with modified and deleted entries only adds entries")
+	}
+
+	if err := syncZip(ctx, archivePath, format, files, plan.unchanged); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got := map[string]string{}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[f.Name] = string(data)
+	}
+	want := map[string]string{"in/added": "added", "in/changed": "changed again", "in/same": "same"}
+	if len(got) != len(want) {
+		t.Errorf("got entries %q, want %q", got, want)
+	}
+	for name, data := range want {
+		if got[name] != data {
+			t.Errorf("got %s containing %q, want %q", name, got[name], data)
+		}
+	}
+
+	archived, err = readSyncEntries(ctx, archivePath, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := planSync(archived, discover()); len(plan.changes) > 0 {
+		t.Errorf("got changes %q after syncing, want none", plan.changes)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errTapeStopped = errors.New("stopped at the end of the tape")
+
+// isStreamOutput reports whether output is an existing file that can't be
+// replaced atomically, like a tape drive or named pipe, which is written in
+// place by a tapeWriter.
+func isStreamOutput(output string) bool {
+	if output == stdioPath || isURL(output) {
+		return false
+	}
+	info, err := os.Stat(output)
+	return err == nil && isStream(info)
+}
+
+// tapeWriter writes to a tape drive, or another output that can't be seeked,
+// in records of a fixed size, like tar --blocking-factor, since each write to
+// a tape drive writes a block. The last record is padded with zeros. Once
+// length has been written to a tape, or the drive reports the end of it, the
+// next tape is asked for, like tar --multi-volume.
+type tapeWriter struct {
+	name   string
+	file   *os.File
+	record []byte
+	n      int
+
+	length  int64
+	written int64
+	volume  int
+}
+
+func newTapeWriter(name string, recordSize int, length int64) (*tapeWriter, error) {
+	if length > 0 && length < int64(recordSize) {
+		return nil, errors.New("tape length must be at least the record size")
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &tapeWriter{name: name, file: file, record: make([]byte, recordSize), length: length, volume: 1}, nil
+}
+
+func (w *tapeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.record[w.n:], p)
+		w.n += n
+		written += n
+		p = p[n:]
+		if w.n == len(w.record) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush writes the buffered record, changing tapes first if it wouldn't fit
+// on this one.
+func (w *tapeWriter) flush() error {
+	if w.length > 0 && w.written+int64(len(w.record)) > w.length {
+		if err := w.nextVolume(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		_, err := w.file.Write(w.record)
+		// The record is written again from its start on the next tape,
+		// since drives only write whole blocks.
+		if errors.Is(err, syscall.ENOSPC) {
+			if err := w.nextVolume(); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		w.written += int64(len(w.record))
+		w.n = 0
+		return nil
+	}
+}
+
+// nextVolume closes the tape, asks for the next one to be inserted, and opens
+// it.
+func (w *tapeWriter) nextVolume() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	w.volume++
+	if !transfers.progress.Load().confirm(localize("insert tape %d for %s and continue? [y/N] ", w.volume, w.name)) {
+		return errTapeStopped
+	}
+
+	file, err := os.OpenFile(w.name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	w.file, w.written = file, 0
+	return nil
+}
+
+// Close pads the last record with zeros and writes it. What's been written
+// can't be taken back, so there's nothing to commit.
+func (w *tapeWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	var err error
+	if w.n > 0 {
+		clear(w.record[w.n:])
+		err = w.flush()
+	}
+	if w.file != nil {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+		w.file = nil
+	}
+	return err
+}
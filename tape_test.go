@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTapeWriter(t *testing.T) {
+	saved := answers
+	defer func() { answers = saved }()
+
+	// Each volume is written over the last, since the tape is a regular
+	// file here.
+	path := filepath.Join(t.TempDir(), "tape")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 500)
+
+	answers = bufio.NewReader(strings.NewReader("y\ny\n"))
+	w, err := newTapeWriter(path, 1024, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.volume != 3 {
+		t.Errorf("wrote %d volumes, want 3", w.volume)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(bytes.Clone(data[4096:]), make([]byte, 5*1024-len(data))...)
+	if !bytes.Equal(got[:1024], want) {
+		t.Errorf("got last volume %q, want %q", got[:1024], want)
+	}
+
+	answers = bufio.NewReader(strings.NewReader("n\n"))
+	if w, err = newTapeWriter(path, 1024, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); !errors.Is(err, errTapeStopped) {
+		t.Errorf("got %v, want %v", err, errTapeStopped)
+	}
+	w.Close()
+}
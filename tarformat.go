@@ -0,0 +1,120 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+// tarFormats are the tar formats that --tar-format can choose, other than
+// ustar, which is written by ustarTar.
+var tarFormats = map[string]tar.Format{
+	"pax": tar.FormatPAX,
+	"gnu": tar.FormatGNU,
+}
+
+// formatTar writes tar archives whose headers are all in one format, for
+// --tar-format, rather than in the oldest format that can represent each
+// entry.
+//
+// PAX headers keep long names, large sizes, sub-second times, and the access
+// and change times, along with extended attributes and metadata, in extended
+// headers. GNU headers keep long names and large sizes with GNU extensions,
+// and access and change times, but can't hold extended headers, so those are
+// dropped with a warning, and modification times are rounded to the nearest
+// second.
+type formatTar struct {
+	archives.Tar
+	format tar.Format
+}
+
+func (t formatTar) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	tw := tar.NewWriter(output)
+	for _, file := range files {
+		if err := t.archiveFile(ctx, tw, file); err != nil {
+			tw.Close()
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (t formatTar) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	tw := tar.NewWriter(output)
+	for job := range jobs {
+		job.Result <- t.archiveFile(ctx, tw, job.File)
+	}
+	return tw.Close()
+}
+
+func (t formatTar) archiveFile(ctx context.Context, tw *tar.Writer, file archives.FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(file, file.LinkTarget)
+	if err != nil {
+		return fmt.Errorf("file %s: creating header: %w", file.NameInArchive, err)
+	}
+	header.Name = file.NameInArchive
+	if file.IsDir() {
+		header.Name = strings.TrimSuffix(header.Name, "/") + "/"
+	}
+	header.Format = t.format
+	if t.format == tar.FormatGNU {
+		header.ModTime = header.ModTime.Round(time.Second)
+		if len(header.PAXRecords) > 0 {
+			warn("dropped the extended headers of %s, which GNU tar archives can't store", file.NameInArchive)
+			header.PAXRecords = nil
+		}
+	}
+	if t.NumericUIDGID {
+		header.Uname, header.Gname = "", ""
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("file %s: writing header: %w", file.NameInArchive, err)
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	input, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	if _, err := io.Copy(tw, input); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.NameInArchive, err)
+	}
+	return nil
+}
+
+// withTarFormat returns format, a tar archive that may be compressed, written
+// with headers in the format named by name, or false if format isn't a tar
+// archive.
+func withTarFormat(format archives.Format, name string) (archives.Format, bool) {
+	variant := func(t archives.Tar) archives.Archival {
+		if name == "ustar" {
+			return ustarTar{t}
+		}
+		return formatTar{t, tarFormats[name]}
+	}
+
+	switch format := format.(type) {
+	case archives.Tar:
+		return variant(format), true
+	case archives.CompressedArchive:
+		if tar, ok := format.Archival.(archives.Tar); ok {
+			format.Archival = variant(tar)
+			return format, true
+		}
+	}
+	return nil, false
+}
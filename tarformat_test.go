@@ -0,0 +1,70 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func TestFormatTar(t *testing.T) {
+	longName := strings.Repeat("d/", 60) + "f"
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 600_000_000, time.UTC)
+	header := &tar.Header{Name: longName, Typeflag: tar.TypeReg, Mode: 0o644, Size: 1, ModTime: modTime, PAXRecords: map[string]string{metaPAXPrefix + "builder": "ci"}}
+	files := []archives.FileInfo{{
+		FileInfo:      header.FileInfo(),
+		NameInArchive: longName,
+		Open: func() (fs.File, error) {
+			return memReader{bytes.NewReader([]byte("f")), memFileInfo{name: longName, size: 1}}, nil
+		},
+	}}
+
+	tests := []struct {
+		format  tar.Format
+		modTime time.Time
+		meta    string
+	}{
+		{tar.FormatPAX, modTime, "ci"},
+		{tar.FormatGNU, modTime.Round(time.Second), ""},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := (formatTar{format: test.format}).Archive(context.Background(), &buf, files); err != nil {
+			t.Fatalf("%s: %s", test.format, err)
+		}
+
+		r := tar.NewReader(&buf)
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("%s: %s", test.format, err)
+		}
+		if got.Format&test.format == 0 {
+			t.Errorf("%s: got header format %s", test.format, got.Format)
+		}
+		if got.Name != longName || !got.ModTime.Equal(test.modTime) || got.PAXRecords[metaPAXPrefix+"builder"] != test.meta {
+			t.Errorf("%s: got name %s, time %s and records %v", test.format, got.Name, got.ModTime, got.PAXRecords)
+		}
+		if contents, err := io.ReadAll(r); err != nil || string(contents) != "f" {
+			t.Errorf("%s: got contents %q, error %v", test.format, contents, err)
+		}
+	}
+}
+
+func TestWithTarFormat(t *testing.T) {
+	compressed := archives.CompressedArchive{Compression: archives.Gz{}, Archival: archives.Tar{}, Extraction: archives.Tar{}}
+	if format, ok := withTarFormat(compressed, "ustar"); !ok || format.(archives.CompressedArchive).Archival != (ustarTar{}) {
+		t.Errorf("got %v, %t for a compressed tar archive", format, ok)
+	}
+	if format, ok := withTarFormat(archives.Tar{}, "gnu"); !ok || format != (formatTar{format: tar.FormatGNU}) {
+		t.Errorf("got %v, %t for a tar archive", format, ok)
+	}
+	if _, ok := withTarFormat(archives.Zip{}, "pax"); ok {
+		t.Error("a zip was given a tar format")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// templateHelp documents the placeholders of output templates.
+const templateHelp = "The path may contain placeholders, which are replaced when the output is created: {date}, {date:LAYOUT} and {time}, the current date, formatted with a Go time layout like 2006-01-02, which {date} defaults to, or 15-04-05 for {time}; {unix}, the seconds since the Unix epoch; {hostname}; and {user}, the current user's name. {{ and }} are literal braces."
+
+// templatePart is a literal part of an output template, or a placeholder if
+// name isn't empty.
+type templatePart struct {
+	literal, name, layout string
+}
+
+// parseTemplate splits an output template into its literal parts and
+// placeholders.
+func parseTemplate(template string) ([]templatePart, error) {
+	var parts []templatePart
+	var literal strings.Builder
+	for i := 0; i < len(template); i++ {
+		switch c := template[i]; {
+		case strings.HasPrefix(template[i:], "{{"), strings.HasPrefix(template[i:], "}}"):
+			literal.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated placeholder in %q", template)
+			}
+			name, layout, _ := strings.Cut(template[i+1:i+end], ":")
+			switch name {
+			case "date":
+				layout = cmp.Or(layout, time.DateOnly)
+			case "time":
+				layout = cmp.Or(layout, "15-04-05")
+			case "unix", "hostname", "user":
+				if layout != "" {
+					return nil, fmt.Errorf("placeholder {%s} doesn't take a layout", name)
+				}
+			default:
+				return nil, fmt.Errorf("unknown placeholder {%s}", template[i+1:i+end])
+			}
+			if literal.Len() > 0 {
+				parts = append(parts, templatePart{literal: literal.String()})
+				literal.Reset()
+			}
+			parts = append(parts, templatePart{name: name, layout: layout})
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("unmatched } in %q, which must be given as }}", template)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	if literal.Len() > 0 {
+		parts = append(parts, templatePart{literal: literal.String()})
+	}
+	return parts, nil
+}
+
+// expandTemplate replaces the placeholders in an output template with their
+// values at now.
+func expandTemplate(template string, now time.Time) (string, error) {
+	parts, err := parseTemplate(template)
+	if err != nil {
+		return "", err
+	}
+	var expanded strings.Builder
+	for _, part := range parts {
+		switch part.name {
+		case "":
+			expanded.WriteString(part.literal)
+		case "date", "time":
+			expanded.WriteString(now.Format(part.layout))
+		case "unix":
+			expanded.WriteString(strconv.FormatInt(now.Unix(), 10))
+		case "hostname":
+			host, err := os.Hostname()
+			if err != nil {
+				return "", err
+			}
+			expanded.WriteString(host)
+		case "user":
+			u, err := user.Current()
+			if err != nil {
+				return "", err
+			}
+			expanded.WriteString(u.Username)
+		}
+	}
+	return expanded.String(), nil
+}
+
+// templatePattern matches the paths that template expands to, or returns
+// false if it has no placeholders.
+func templatePattern(template string) (*regexp.Regexp, bool) {
+	parts, err := parseTemplate(template)
+	if err != nil {
+		return nil, false
+	}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	placeholders := false
+	for _, part := range parts {
+		if part.name == "" {
+			pattern.WriteString(regexp.QuoteMeta(part.literal))
+		} else {
+			pattern.WriteString(".+?")
+			placeholders = true
+		}
+	}
+	if !placeholders {
+		return nil, false
+	}
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String()), true
+}
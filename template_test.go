@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for template, want := range map[string]string{
+		"backup.tar.zst":                        "backup.tar.zst",
+		"backup-{date}.tar.zst":                 "backup-2026-01-02.tar.zst",
+		"backup-{date:20060102}-{time}.tar.zst": "backup-20260102-03-04-05.tar.zst",
+		"{hostname}/{unix}.tar":                 host + "/1767323045.tar",
+		"{{date}}.tar":                          "{date}.tar",
+	} {
+		got, err := expandTemplate(template, now)
+		if err != nil {
+			t.Errorf("%s: %s", template, err)
+		} else if got != want {
+			t.Errorf("%s: got %s, want %s", template, got, want)
+		}
+	}
+
+	for _, template := range []string{"{date", "date}", "{host}", "{unix:2006}"} {
+		if _, err := expandTemplate(template, now); err == nil {
+			t.Errorf("%s: got no error", template)
+		}
+	}
+}
+
+func TestTemplatePattern(t *testing.T) {
+	pattern, ok := templatePattern("backup-{date}.tar")
+	if !ok {
+		t.Fatal("got no pattern")
+	}
+	if !pattern.MatchString("backup-2026-01-02.tar") || pattern.MatchString("backup-.tar") || pattern.MatchString("other-2026-01-02.tar") {
+		t.Error("the pattern didn't match only the names the template expands to")
+	}
+	if _, ok := templatePattern("{{backup}}.tar"); ok {
+		t.Error("got a pattern for a template without placeholders")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// rawTerminal puts the terminal f into raw mode, so that keys are read as
+// they're pressed, without being echoed or interpreted, and reads return
+// after a tenth of a second even if no key was pressed, returning a function
+// that restores its previous settings.
+func rawTerminal(f *os.File) (func() error, error) {
+	var termios syscall.Termios
+	if err := ioctlTermios(f, syscall.TCGETS, &termios); err != nil {
+		return nil, err
+	}
+	restored := termios
+
+	termios.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	termios.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	termios.Cflag |= syscall.CS8
+	termios.Cc[syscall.VMIN] = 0
+	termios.Cc[syscall.VTIME] = 1
+	if err := ioctlTermios(f, syscall.TCSETS, &termios); err != nil {
+		return nil, err
+	}
+	return func() error { return ioctlTermios(f, syscall.TCSETS, &restored) }, nil
+}
+
+// terminalSize returns the number of columns and rows of the terminal f.
+func terminalSize(f *os.File) (int, int, error) {
+	var size struct{ rows, cols, x, y uint16 }
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0, 0, os.NewSyscallError("ioctl", errno)
+	}
+	return int(size.cols), int(size.rows), nil
+}
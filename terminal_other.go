@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func rawTerminal(*os.File) (func() error, error) {
+	return nil, errors.New("browse is only supported on Linux")
+}
+
+func terminalSize(*os.File) (int, int, error) {
+	return 0, 0, errors.New("browse is only supported on Linux")
+}
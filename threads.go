@@ -0,0 +1,86 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/mholt/archives"
+)
+
+// threadsHelp documents which formats --threads applies to.
+const threadsHelp = "Parallel compression is used for gz (gzip), zst (zstd), and sz (snappy), and the output is identical regardless of the number of threads. Other formats, including xz, are always compressed using a single thread."
+
+// withThreads configures format to compress using up to threads goroutines,
+// where this is supported without making the output depend on the number of
+// threads.
+func withThreads(format archives.Format, threads int) archives.Format {
+	switch format := format.(type) {
+	case archives.CompressedArchive:
+		format.Compression = withThreads(format.Compression, threads).(archives.Compression)
+		return format
+
+	case archives.Gz:
+		return parallelGz{Gz: format, threads: threads}
+
+	case archives.Zstd:
+		format.EncoderOptions = append(format.EncoderOptions, zstd.WithEncoderConcurrency(threads))
+		return format
+
+	case archives.Sz:
+		format.S2.Concurrency = threads
+		return format
+
+	default:
+		return format
+	}
+}
+
+// parallelGzBlockSize is the size of the blocks that parallelGz compresses
+// independently. It must not depend on the number of threads, since the
+// block boundaries determine the output.
+const parallelGzBlockSize = 1 << 20
+
+// parallelGz compresses gzip using a fixed block size, like pigz, so that the
+// output is the same no matter how many blocks are compressed concurrently.
+// archives.Gz's multithreaded mode can't be used for this, since it always
+// uses as many threads as there are CPUs. Its header holds the original name
+// and modification time given by --gzip-name and --gzip-mtime, which are
+// otherwise empty, and with rsyncable, it's compressed by an rsyncableGz
+// writer instead.
+type parallelGz struct {
+	archives.Gz
+	threads   int
+	name      string
+	modTime   time.Time
+	rsyncable bool
+}
+
+func (gz parallelGz) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	level := gz.CompressionLevel
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	modTime := gz.modTime
+	if modTime.IsZero() {
+		modTime = time.Unix(0, 0)
+	}
+	if gz.rsyncable {
+		return newRsyncableGz(w, level, gz.name, modTime)
+	}
+
+	wc, err := pgzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	wc.Name, wc.ModTime = gz.name, modTime
+
+	if err := wc.SetConcurrency(parallelGzBlockSize, gz.threads); err != nil {
+		return nil, err
+	}
+
+	return wc, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/mholt/archives"
+)
+
+func TestThreadsDeterministic(t *testing.T) {
+	// The input is large enough to be split between threads, and
+	// compressible.
+	input := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(input)
+	for i := range input {
+		input[i] %= 16
+	}
+
+	for _, format := range []archives.Compression{archives.Zstd{}, archives.Sz{}} {
+		var outputs [][]byte
+		for _, threads := range []int{1, 4} {
+			var buf bytes.Buffer
+			w, err := withThreads(format, threads).(archives.Compressor).OpenWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(input); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := format.(archives.Decompressor).OpenReader(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, input) {
+				t.Errorf("%s: the output with %d threads didn't decompress to the input", format.Extension(), threads)
+			}
+			outputs = append(outputs, buf.Bytes())
+		}
+		if !bytes.Equal(outputs[0], outputs[1]) {
+			t.Errorf("%s: the output depends on the number of threads", format.Extension())
+		}
+	}
+}
+
+func TestParallelGz(t *testing.T) {
+	// The input spans several blocks, and is compressible.
+	input := make([]byte, 3*parallelGzBlockSize+12345)
+	rand.New(rand.NewSource(1)).Read(input)
+	for i := range input {
+		input[i] %= 16
+	}
+
+	var outputs [][]byte
+	for _, threads := range []int{1, 4} {
+		var buf bytes.Buffer
+		w, err := withThreads(archives.Gz{}, threads).(archives.Compressor).OpenWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Errorf("the output with %d threads didn't decompress to the input", threads)
+		}
+		outputs = append(outputs, buf.Bytes())
+	}
+
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("the output depends on the number of threads")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleBurst is how far reads and writes may run ahead of --limit-rate
+// before they're slowed down, so that small ones aren't each delayed.
+const throttleBurst = 100 * time.Millisecond
+
+// throttle limits reads and writes to --limit-rate bytes per second. A nil
+// *throttle is valid and limits nothing.
+type throttle struct {
+	mu   sync.Mutex
+	rate float64
+	// next is when everything taken so far will have been paid for at rate.
+	next time.Time
+}
+
+func newThrottle(rate byteSize) *throttle {
+	if rate <= 0 {
+		return nil
+	}
+	return &throttle{rate: float64(rate)}
+}
+
+// chunk is the most that's read or written at once, so that each only waits
+// for about throttleBurst, and interrupts aren't held up.
+func (t *throttle) chunk() int {
+	return max(int(t.rate*throttleBurst.Seconds()), tarBlockSize)
+}
+
+// take waits until n more bytes can be read or written.
+func (t *throttle) take(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	now := time.Now()
+	t.next = later(t.next, now).Add(time.Duration(float64(n) / t.rate * float64(time.Second)))
+	wait := t.next.Sub(now) - throttleBurst
+	t.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// throttledWriter writes to w at the rate allowed by t.
+type throttledWriter struct {
+	io.WriteCloser
+	t *throttle
+}
+
+// throttleOutput returns w, limited to --limit-rate if it's given.
+func throttleOutput(w io.WriteCloser) io.WriteCloser {
+	t := newThrottle(cli.LimitRate)
+	if t == nil {
+		return w
+	}
+	return throttledWriter{w, t}
+}
+
+func (w throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), w.t.chunk())]
+		w.t.take(len(chunk))
+		n, err := w.WriteCloser.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// throttledReader reads from r at the rate allowed by t.
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+// throttleReader returns r, limited to --limit-rate if it's given.
+func throttleReader(r io.Reader) io.Reader {
+	t := newThrottle(cli.LimitRate)
+	if t == nil {
+		return r
+	}
+	return throttledReader{r, t}
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:min(len(p), r.t.chunk())])
+	r.t.take(n)
+	return n, err
+}
+
+// throttledInput reads from an input file at the rate allowed by t.
+type throttledInput struct {
+	inputFile
+	t *throttle
+}
+
+// throttleInput returns f, limited to --limit-rate if it's given.
+func throttleInput(f inputFile) inputFile {
+	t := newThrottle(cli.LimitRate)
+	if t == nil {
+		return f
+	}
+	return throttledInput{f, t}
+}
+
+func (f throttledInput) Read(p []byte) (int, error) {
+	return throttledReader{f.inputFile, f.t}.Read(p)
+}
+
+func (f throttledInput) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for len(p) > 0 {
+		n, err := f.inputFile.ReadAt(p[:min(len(p), f.t.chunk())], off)
+		f.t.take(n)
+		read += n
+		if err != nil {
+			return read, err
+		}
+		p, off = p[n:], off+int64(n)
+	}
+	return read, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	const rate = 1 << 20
+	data := make([]byte, rate/2)
+
+	var buf bytes.Buffer
+	start := time.Now()
+	w := throttledWriter{nopWriteCloser{&buf}, newThrottle(rate)}
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if buf.Len() != len(data) {
+		t.Errorf("wrote %d bytes, want %d", buf.Len(), len(data))
+	}
+	// Writes can run ahead by throttleBurst.
+	if want := 500*time.Millisecond - throttleBurst; elapsed < want-10*time.Millisecond {
+		t.Errorf("took %s, want at least %s", elapsed, want)
+	}
+}
+
+func TestThrottleNil(t *testing.T) {
+	if newThrottle(0) != nil {
+		t.Error("got a throttle for a rate of 0")
+	}
+	var throttle *throttle
+	throttle.take(1 << 30)
+}
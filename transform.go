@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// transformHelp documents the syntax accepted by flags holding a transform.
+const transformHelp = "Rules are given in the form s/REGEXP/REPLACEMENT/FLAGS as with sed(1), where any character can be used in place of /, \\1 to \\9 in the replacement refer to submatches and & to the whole match, and the flags are g to replace every match instead of the first, and i to match case-insensitively. Rules are applied in order, and entries whose names become empty are skipped."
+
+// transform is a flag value holding a sed-style substitution applied to entry
+// names.
+type transform struct {
+	re          *regexp.Regexp
+	replacement string
+	global      bool
+}
+
+func (t *transform) UnmarshalText(text []byte) error {
+	str := string(text)
+	if len(str) < 2 || str[0] != 's' {
+		return fmt.Errorf("invalid transform %q, expected s/REGEXP/REPLACEMENT/FLAGS", str)
+	}
+	delim, size := utf8.DecodeRuneInString(str[1:])
+
+	parts := splitUnescaped(str[1+size:], delim)
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid transform %q, expected s/REGEXP/REPLACEMENT/FLAGS", str)
+	}
+
+	pattern, flags := parts[0], parts[2]
+	global := false
+	for _, flag := range flags {
+		switch flag {
+		case 'g':
+			global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return fmt.Errorf("invalid transform %q: unknown flag %q", str, flag)
+		}
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid transform %q: %w", str, err)
+	}
+
+	*t = transform{re: re, replacement: sedReplacement(parts[1]), global: global}
+	return nil
+}
+
+// splitUnescaped splits s at each occurrence of delim that isn't preceded by
+// a backslash, removing the backslashes from escaped delimiters.
+func splitUnescaped(s string, delim rune) []string {
+	var parts []string
+	var part strings.Builder
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			if r != delim {
+				part.WriteByte('\\')
+			}
+			part.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	if escaped {
+		part.WriteByte('\\')
+	}
+	return append(parts, part.String())
+}
+
+// sedReplacement converts a replacement in sed's syntax to the syntax of
+// regexp.Regexp.Expand.
+func sedReplacement(replacement string) string {
+	var b strings.Builder
+	for i := 0; i < len(replacement); i++ {
+		switch c := replacement[i]; {
+		case c == '\\' && i+1 < len(replacement):
+			i++
+			if next := replacement[i]; next >= '0' && next <= '9' {
+				fmt.Fprintf(&b, "${%c}", next)
+			} else if next == '$' {
+				b.WriteString("$$")
+			} else {
+				b.WriteByte(next)
+			}
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// apply returns name with the substitution applied.
+func (t *transform) apply(name string) string {
+	if t.global {
+		return t.re.ReplaceAllString(name, t.replacement)
+	}
+
+	match := t.re.FindStringSubmatchIndex(name)
+	if match == nil {
+		return name
+	}
+	expanded := t.re.ExpandString(nil, t.replacement, name, match)
+	return name[:match[0]] + string(expanded) + name[match[1]:]
+}
+
+// transformName applies each of transforms to name in turn.
+func transformName(transforms []transform, name string) string {
+	for _, t := range transforms {
+		name = t.apply(name)
+	}
+	return name
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestTransformApply(t *testing.T) {
+	tests := []struct {
+		rule string
+		name string
+		want string
+	}{
+		{"s|^build/|artifacts/|", "build/a/b", "artifacts/a/b"},
+		{"s|^build/|artifacts/|", "x/build/a", "x/build/a"},
+		{"s/a/x/", "banana", "bxnana"},
+		{"s/a/x/g", "banana", "bxnxnx"},
+		{"s/A/x/gi", "bAnana", "bxnxnx"},
+		{`s/(.*)-([0-9.]*)/\2\/\1/`, "pkg-1.2", "1.2/pkg"},
+		{"s,^,&prefix/,", "a", "prefix/a"},
+		{"s/.*\\.o//", "a.o", ""},
+		{"s/a/$1/", "a", "$1"},
+		{"s/a,b/c/", "a,b", "c"},
+	}
+
+	for _, test := range tests {
+		var tr transform
+		if err := tr.UnmarshalText([]byte(test.rule)); err != nil {
+			t.Errorf("%s: %s", test.rule, err)
+			continue
+		}
+
+		if got := tr.apply(test.name); got != test.want {
+			t.Errorf("%s applied to %s: got %q, want %q", test.rule, test.name, got, test.want)
+		}
+	}
+}
+
+func TestTransformInvalid(t *testing.T) {
+	for _, rule := range []string{"", "s", "s/a/b", "s/a/b/c/d", "y/a/b/", "s/a/b/x", "s/(/b/"} {
+		var tr transform
+		if err := tr.UnmarshalText([]byte(rule)); err == nil {
+			t.Errorf("%q: got no error", rule)
+		}
+	}
+}
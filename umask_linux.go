@@ -0,0 +1,16 @@
+//go:build linux
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// processUmask returns the umask of the process. It's briefly changed to read
+// it, so this must be called before anything else creates files.
+func processUmask() fs.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return fs.FileMode(mask) & fs.ModePerm
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "io/fs"
+
+// processUmask returns the usual umask, 022, which can only be read on Linux.
+func processUmask() fs.FileMode {
+	return 0o022
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/mholt/archives"
+)
+
+// compareChunkSize is how much of an entry and the existing file it would be
+// extracted to are compared at a time by --if-changed.
+const compareChunkSize = 32 << 10
+
+// prefixedFile is an entry whose contents start with those of r, which were
+// read from it or are the same as those that were, followed by the rest of
+// the entry. Closing it also closes existing.
+type prefixedFile struct {
+	fs.File
+	r        io.Reader
+	existing *os.File
+}
+
+func (f *prefixedFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *prefixedFile) Close() error {
+	if f.existing != nil {
+		f.existing.Close()
+	}
+	return f.File.Close()
+}
+
+// compareExisting compares the contents of the regular file info with those
+// of name beneath e.root, which is the same size, returning how many bytes at
+// the start of them are the same, which is the size of info if they're
+// identical. Otherwise, info is returned with Open replaced to return the
+// entry that was opened to compare it, since the entries of streamed archives
+// can only be read once, with the bytes that were the same read back from
+// the existing file, which must be rewritten after them in place, or replaced
+// if none were.
+func (e *entryExtractor) compareExisting(info archives.FileInfo, name string) (archives.FileInfo, int64, error) {
+	existing, err := e.root.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return info, 0, err
+	}
+	entry, err := info.Open()
+	if err != nil {
+		existing.Close()
+		return info, 0, err
+	}
+
+	var entryR io.Reader = entry
+	verify := func() error { return nil }
+	if e.checksums != nil {
+		entryR, verify = e.checksums.check(info.NameInArchive, entryR)
+	}
+
+	var matched int64
+	chunk, existingChunk := make([]byte, compareChunkSize), make([]byte, compareChunkSize)
+	for {
+		n, err := io.ReadFull(entryR, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			existing.Close()
+			entry.Close()
+			return info, 0, err
+		}
+		m, _ := io.ReadFull(existing, existingChunk[:n])
+
+		same := 0
+		for same < m && chunk[same] == existingChunk[same] {
+			same++
+		}
+		matched += int64(same)
+		if same < n {
+			// The rest of this chunk was read from the entry, so it's
+			// read again before the rest of it. If nothing was the same,
+			// the existing file is replaced instead, so it's closed.
+			file := &prefixedFile{File: entry, r: io.MultiReader(io.NewSectionReader(existing, 0, matched), bytes.NewReader(chunk[same:n]), entry), existing: existing}
+			if matched == 0 {
+				existing.Close()
+				file.existing = nil
+			}
+			info.Open = func() (fs.File, error) { return file, nil }
+			return info, matched, nil
+		}
+		if n < len(chunk) {
+			existing.Close()
+			if err := entry.Close(); err != nil {
+				return info, matched, err
+			}
+			return info, matched, verify()
+		}
+	}
+}
+
+// chmod changes the permissions of the regular file name beneath e.root.
+func (e *entryExtractor) chmod(name string, perm fs.FileMode) error {
+	f, err := e.root.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(perm)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func TestExtractIfChanged(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	contents := map[string][]byte{
+		"same":      []byte("same"),
+		"changed":   bytes.Repeat([]byte("0123456789"), 10000),
+		"different": []byte("abc"),
+	}
+	for name, data := range contents {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := archives.FilesFromDisk(ctx, nil, map[string]string{dir: "in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := (archives.Tar{}).Archive(ctx, &archive, files); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	if err := os.Mkdir(filepath.Join(out, "in"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	changed := bytes.Clone(contents["changed"])
+	changed[len(changed)/2] = 'x'
+	existing := map[string][]byte{"same": contents["same"], "changed": changed, "different": []byte("xyz")}
+	old := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, data := range existing {
+		path := filepath.Join(out, "in", name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The archive is streamed, so its entries can only be read once.
+	e := &entryExtractor{root: pathRoot(out), dirMode: fs.ModeDir | 0o755, overwrite: "always", ifChanged: true, times: true}
+	if err := (archives.Tar{}).Extract(ctx, bytes.NewReader(archive.Bytes()), e.extract); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range contents {
+		path := filepath.Join(out, "in", name)
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s wasn't extracted correctly", name)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unchanged := info.ModTime().Equal(old); unchanged != (name == "same") {
+			t.Errorf("%s modified at %s", name, info.ModTime())
+		}
+	}
+}
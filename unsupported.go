@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// zipFlagStrongEncryption is set in the headers of zip entries encrypted with
+// PKWARE's strong encryption, which isn't supported.
+const zipFlagStrongEncryption = 0x40
+
+// zipMethods are the compression methods of zip entries that can be
+// extracted, and zipEncryptedMethods those of encrypted entries.
+var (
+	zipMethods          = []uint16{zip.Store, zip.Deflate, archives.ZipMethodBzip2, archives.ZipMethodZstd, archives.ZipMethodXz}
+	zipEncryptedMethods = []uint16{zip.Store, zip.Deflate}
+)
+
+// tarTypes are the types of tar entries that can be extracted. Other types,
+// like GNU volume headers, are extracted as regular files by archives.Tar, as
+// tar.Header.FileInfo doesn't know their modes.
+var tarTypes = []byte{tar.TypeReg, tar.TypeRegA, tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo, tar.TypeCont, tar.TypeGNUSparse}
+
+// unsupportedFeature is a feature of archive entries that can't be extracted,
+// described by format and arg, if it isn't nil.
+type unsupportedFeature struct {
+	format string
+	arg    any
+}
+
+// String describes f in the user's language.
+func (f unsupportedFeature) String() string {
+	if f.arg == nil {
+		return localize(f.format)
+	}
+	return localize(f.format, f.arg)
+}
+
+// entryUnsupported returns the feature of info that can't be extracted, if it
+// has one.
+func entryUnsupported(info archives.FileInfo) (unsupportedFeature, bool) {
+	switch header := info.Header.(type) {
+	case zip.FileHeader:
+		if header.Flags&zipFlagStrongEncryption != 0 {
+			return unsupportedFeature{"PKWARE's strong encryption", nil}, true
+		}
+		method, encrypted, _ := squish.ZipMethod(header)
+		if !encrypted {
+			if !slices.Contains(zipMethods, method) {
+				return unsupportedFeature{"zip compression method %d", method}, true
+			}
+			return unsupportedFeature{}, false
+		}
+		if !slices.Contains(zipEncryptedMethods, method) {
+			return unsupportedFeature{"zip compression method %d with encryption", method}, true
+		}
+
+	case *tar.Header:
+		if !slices.Contains(tarTypes, header.Typeflag) {
+			return unsupportedFeature{"tar entry type %q", string(header.Typeflag)}, true
+		}
+	}
+	return unsupportedFeature{}, false
+}
+
+// unsupportedError is returned with --strict for entries that can't be
+// extracted.
+type unsupportedError struct {
+	feature unsupportedFeature
+}
+
+func (e *unsupportedError) Error() string {
+	return fmt.Sprintf("%s isn't supported", e.feature)
+}
+
+// capabilityReport collects the entries of an archive that can't be
+// extracted, by the features they use, so that they're reported together
+// rather than one at a time.
+type capabilityReport struct {
+	features []unsupportedFeature
+	entries  map[unsupportedFeature][]string
+	// scanned is whether the entries were collected before extracting, so
+	// that they aren't collected again.
+	scanned bool
+}
+
+func (r *capabilityReport) add(feature unsupportedFeature, name string) {
+	if r.entries == nil {
+		r.entries = map[unsupportedFeature][]string{}
+	}
+	if _, ok := r.entries[feature]; !ok {
+		r.features = append(r.features, feature)
+	}
+	r.entries[feature] = append(r.entries[feature], name)
+}
+
+// len returns the number of entries in r.
+func (r *capabilityReport) len() int {
+	n := 0
+	for _, names := range r.entries {
+		n += len(names)
+	}
+	return n
+}
+
+// warn reports the entries in r, listing up to reportedNames of those using
+// each feature.
+func (r *capabilityReport) warn() {
+	const reportedNames = 5
+	for _, feature := range r.features {
+		names := r.entries[feature]
+		listed := strings.Join(names[:min(len(names), reportedNames)], ", ")
+		if len(names) > reportedNames {
+			listed += ", ..."
+		}
+		warn("%d entries use %s, which isn't supported, so they're skipped: %s", len(names), feature, listed)
+	}
+}
+
+// scanCapabilities reads the headers of the entries of the archive r, without
+// their contents, collecting those selected by types and patterns that can't
+// be extracted in report.
+func scanCapabilities(ctx context.Context, format archives.Extractor, r io.Reader, types []string, patterns []glob, report *capabilityReport) error {
+	report.scanned = true
+	return format.Extract(ctx, r, func(ctx context.Context, info archives.FileInfo) error {
+		if feature, ok := entryUnsupported(info); ok && selectsName(info, types, patterns) {
+			report.add(feature, info.NameInArchive)
+		}
+		return nil
+	})
+}
+
+// selectsName reports whether info is selected by --type and the patterns of
+// extract, without recording which patterns matched it.
+func selectsName(info archives.FileInfo, types []string, patterns []glob) bool {
+	if !typeMatches(types, info) {
+		return false
+	}
+	name := filepath.ToSlash(filepath.Clean(info.NameInArchive))
+	return len(patterns) == 0 || slices.ContainsFunc(patterns, func(pattern glob) bool { return pattern.matches(name) })
+}
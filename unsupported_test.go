@@ -0,0 +1,59 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestExtractUnsupported(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a", typeflag: tar.TypeReg, contents: "a"},
+		{name: "volume", typeflag: 'V'},
+		{name: "b", typeflag: tar.TypeReg, contents: "b"},
+	})
+
+	report := &capabilityReport{}
+	_, output, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{unsupported: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readTree(t, output), map[string]string{"a": "a", "b": "b"}; !maps.Equal(got, want) {
+		t.Errorf("got output %v, want %v", got, want)
+	}
+	if got := report.len(); got != 1 {
+		t.Errorf("got %d unsupported entries, want 1", got)
+	}
+
+	var unsupportedErr *unsupportedError
+	_, _, err = extractTest(t, archives.Tar{}, archive, &entryExtractor{unsupported: &capabilityReport{}, strict: true})
+	if !errors.As(err, &unsupportedErr) {
+		t.Errorf("got error %v with strict, want an unsupported error", err)
+	}
+}
+
+func TestScanCapabilities(t *testing.T) {
+	archive := makeTar(t, []testEntry{
+		{name: "a/volume", typeflag: 'V'},
+		{name: "b/volume", typeflag: 'V'},
+		{name: "b/c", typeflag: tar.TypeReg, contents: "c"},
+	})
+
+	report := &capabilityReport{}
+	if err := scanCapabilities(context.Background(), archives.Tar{}, bytes.NewReader(archive), nil, []glob{"b"}, report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.scanned {
+		t.Error("report wasn't marked as scanned")
+	}
+	feature := unsupportedFeature{"tar entry type %q", "V"}
+	if got, want := report.entries, map[unsupportedFeature][]string{feature: {"b/volume"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+var errUpdateFormat = errors.New("not an uncompressed tar archive")
+
+// tarUpdate is an existing uncompressed tar archive that create --update
+// appends to, like tar -u: the latest modification time of each entry in it,
+// and the offset of its end-of-archive marker, which new entries replace.
+type tarUpdate struct {
+	modTimes map[string]time.Time
+	end      int64
+}
+
+// readTarUpdate reads the entries of the tar archive at archivePath, which is
+// read entirely, since the end of the last entry can only be found by reading
+// them all. An empty file is treated as an empty archive.
+func readTarUpdate(archivePath string) (*tarUpdate, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	u := &tarUpdate{modTimes: map[string]time.Time{}}
+	br := bufio.NewReader(f)
+	if block, err := br.Peek(tarBlockSize); len(block) == 0 && err == io.EOF {
+		return u, nil
+	} else if !isTarHeader(block) {
+		return nil, errUpdateFormat
+	}
+
+	r := &countingReader{Reader: br}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return u, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// The data of sparse entries is shorter than their size, so the
+		// end of each entry is found by reading it.
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return nil, err
+		}
+		u.end = (r.n.Load() + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+
+		// Entries can appear more than once in archives that were updated
+		// before, and the latest copy is the one that's extracted last.
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if modTime, ok := u.modTimes[name]; !ok || hdr.ModTime.After(modTime) {
+			u.modTimes[name] = hdr.ModTime
+		}
+	}
+}
+
+// newer returns the files that aren't in the archive, or that were modified
+// after the entries with the same names in it. Times are compared to the
+// second, since that's all that ustar headers store.
+func (u *tarUpdate) newer(files []archives.FileInfo) []archives.FileInfo {
+	newer := make([]archives.FileInfo, 0, len(files))
+	for _, file := range files {
+		name := path.Clean(strings.TrimPrefix(file.NameInArchive, "/"))
+		modTime, ok := u.modTimes[name]
+		if !ok || file.ModTime().Round(time.Second).After(modTime.Round(time.Second)) {
+			newer = append(newer, file)
+		}
+	}
+	return newer
+}
+
+// append calls write to write entries to the archive at archivePath in place
+// of its end-of-archive marker. If that fails, the marker is written back
+// after the existing entries, so that the archive is left as it was.
+func (u *tarUpdate) append(archivePath string, write func(io.Writer) error) (err error) {
+	f, err := os.OpenFile(archivePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	appended := false
+	defer func() {
+		// The marker is two blocks of zeros.
+		if !appended {
+			f.Truncate(u.end)
+			f.WriteAt(make([]byte, 2*tarBlockSize), u.end)
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if _, err := f.Seek(u.end, io.SeekStart); err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		return err
+	}
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	// Any padding that followed the old marker is dropped.
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	appended = true
+	return f.Sync()
+}
+
+// isPlainTar reports whether format is an uncompressed tar archive.
+func isPlainTar(format archives.Format) bool {
+	switch format.(type) {
+	case archives.Tar, ustarTar, formatTar:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func TestTarUpdate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, name := range []string{"same", "changed"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	discover := func() []archives.FileInfo {
+		files, err := archives.FilesFromDisk(ctx, nil, map[string]string{dir: "in"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	update := func(archivePath string) []string {
+		u, err := readTarUpdate(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := u.newer(discover())
+		err = u.append(archivePath, func(w io.Writer) error {
+			return archives.Tar{}.Archive(ctx, w, files)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.NameInArchive)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "out.tar")
+	if err := os.WriteFile(archivePath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := update(archivePath), []string{"in", "in/changed", "in/same"}; !slices.Equal(got, want) {
+		t.Errorf("got %q in the first update, want %q", got, want)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "changed"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := update(archivePath), []string{"in/changed", "in/new"}; !slices.Equal(got, want) {
+		t.Errorf("got %q in the second update, want %q", got, want)
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if want := []string{"in", "in/changed", "in/same", "in/changed", "in/new"}; !slices.Equal(names, want) {
+		t.Errorf("got entries %q, want %q", names, want)
+	}
+
+	if err := os.WriteFile(archivePath, []byte("not a tar archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readTarUpdate(archivePath); !errors.Is(err, errUpdateFormat) {
+		t.Errorf("got %v, want %v", err, errUpdateFormat)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// uploadPartSize is the size of each part of a multipart upload, which must be
+// at least 5 MiB for S3.
+const uploadPartSize = 16 << 20
+
+// objectWriter uploads everything written to it as a remote object. Objects no
+// larger than a single part are uploaded with one request when the writer is
+// closed. Larger objects are uploaded in parts as they're written, so that
+// outputs of any size can be uploaded without being stored locally.
+type objectWriter struct {
+	ctx    context.Context
+	object *remoteObject
+	buf    []byte
+	upload multipartUpload
+	parts  int
+	err    error
+}
+
+// multipartUpload uploads an object in numbered parts, starting from 1.
+type multipartUpload interface {
+	uploadPart(number int, data []byte) error
+	complete() error
+	abort()
+}
+
+func createURL(ctx context.Context, rawURL string) (*objectWriter, error) {
+	object, err := parseRemote(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectWriter{ctx: ctx, object: object}, nil
+}
+
+func (w *objectWriter) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	for len(p) > 0 {
+		// Only upload a full buffer once there's more to write, so that the
+		// final part, which is uploaded on close, is never empty.
+		if len(w.buf) == uploadPartSize {
+			if err := w.flush(); err != nil {
+				w.err = err
+				return n, err
+			}
+		}
+
+		if w.buf == nil {
+			w.buf = make([]byte, 0, uploadPartSize)
+		}
+		written := min(len(p), uploadPartSize-len(w.buf))
+		w.buf = append(w.buf, p[:written]...)
+		n += written
+		p = p[written:]
+	}
+
+	return n, nil
+}
+
+func (w *objectWriter) flush() error {
+	if w.upload == nil {
+		upload, err := w.startUpload()
+		if err != nil {
+			return fmt.Errorf("failed to start multipart upload: %w", err)
+		}
+		w.upload = upload
+	}
+
+	// Parts are buffered, so they can be uploaded again if a request fails.
+	w.parts++
+	if err := retryTransfer(w.ctx, func() error { return w.upload.uploadPart(w.parts, w.buf) }); err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", w.parts, err)
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *objectWriter) startUpload() (multipartUpload, error) {
+	if w.object.scheme == "az" {
+		return &blockUpload{object: w.object}, nil
+	}
+	if w.object.scheme != "s3" && w.object.scheme != "gs" {
+		return nil, errors.New("multipart uploads are only supported for S3, Google Cloud Storage and Azure Blob Storage")
+	}
+
+	req, err := w.object.newRequest(http.MethodPost, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.object.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &xmlUpload{object: w.object, id: result.UploadID}, nil
+}
+
+// Close finishes uploading the object. If a write failed, any parts that were
+// already uploaded are discarded instead.
+func (w *objectWriter) Close() error {
+	if w.err != nil {
+		if w.upload != nil {
+			w.upload.abort()
+		}
+		return w.err
+	}
+
+	if w.upload == nil {
+		return retryTransfer(w.ctx, w.put)
+	}
+
+	if err := w.flush(); err != nil {
+		w.upload.abort()
+		return err
+	}
+	if err := w.upload.complete(); err != nil {
+		w.upload.abort()
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// put uploads the buffered contents as the whole object.
+func (w *objectWriter) put() error {
+	body := w.buf
+	if body == nil {
+		body = []byte{}
+	}
+
+	req, err := w.object.newRequest(http.MethodPut, nil, body)
+	if err != nil {
+		return err
+	}
+	if w.object.scheme == "az" {
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+	}
+
+	resp, err := w.object.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// xmlUpload is a multipart upload using the S3 API, which Google Cloud Storage
+// also supports.
+type xmlUpload struct {
+	object *remoteObject
+	id     string
+	etags  []string
+}
+
+func (u *xmlUpload) uploadPart(number int, data []byte) error {
+	req, err := u.object.newRequest(http.MethodPut, url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {u.id}}, data)
+	if err != nil {
+		return err
+	}
+	resp, err := u.object.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	u.etags = append(u.etags, resp.Header.Get("ETag"))
+	return nil
+}
+
+func (u *xmlUpload) complete() error {
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	body := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range u.etags {
+		body.Parts = append(body.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := u.object.newRequest(http.MethodPost, url.Values{"uploadId": {u.id}}, data)
+	if err != nil {
+		return err
+	}
+	resp, err := u.object.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 can report an error in the body of a successful response, after it
+	// has started sending it.
+	var result struct {
+		XMLName xml.Name
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if result.XMLName.Local == "Error" {
+		return errors.New(result.Message)
+	}
+
+	return nil
+}
+
+func (u *xmlUpload) abort() {
+	req, err := u.object.newRequest(http.MethodDelete, url.Values{"uploadId": {u.id}}, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := u.object.do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// blockUpload is a multipart upload using Azure Blob Storage's block API.
+type blockUpload struct {
+	object *remoteObject
+	ids    []string
+}
+
+func (u *blockUpload) uploadPart(number int, data []byte) error {
+	// Block IDs must all be the same length.
+	id := base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%08d", number))
+
+	req, err := u.object.newRequest(http.MethodPut, url.Values{"comp": {"block"}, "blockid": {id}}, data)
+	if err != nil {
+		return err
+	}
+	resp, err := u.object.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	u.ids = append(u.ids, id)
+	return nil
+}
+
+func (u *blockUpload) complete() error {
+	body := struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string
+	}{Latest: u.ids}
+
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := u.object.newRequest(http.MethodPut, url.Values{"comp": {"blocklist"}}, append([]byte(xml.Header), data...))
+	if err != nil {
+		return err
+	}
+	resp, err := u.object.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// abort does nothing, since Azure discards uncommitted blocks automatically.
+func (u *blockUpload) abort() {}
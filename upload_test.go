@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a server implementing enough of S3's multipart upload API to
+// upload objects. Requests that fail matches are answered with an error.
+type fakeS3 struct {
+	fail func(r *http.Request) bool
+
+	mu       sync.Mutex
+	parts    map[int][]byte
+	objects  map[string][]byte
+	aborted  bool
+	requests []string
+}
+
+func newFakeS3(t *testing.T, fail func(r *http.Request) bool) *fakeS3 {
+	s := &fakeS3{fail: fail, parts: map[int][]byte{}, objects: map[string][]byte{}}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	return s
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	body, _ := io.ReadAll(r.Body)
+	if s.fail != nil && s.fail(r) {
+		http.Error(w, "failed", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>upload-id</UploadId></InitiateMultipartUploadResult>")
+
+	case r.Method == http.MethodPut && query.Get("uploadId") == "upload-id":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		s.parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+
+	case r.Method == http.MethodPost && query.Get("uploadId") == "upload-id":
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var object []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				fmt.Fprint(w, "<Error><Message>invalid part</Message></Error>")
+				return
+			}
+			object = append(object, s.parts[part.PartNumber]...)
+		}
+		s.objects[r.URL.Path] = object
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+
+	case r.Method == http.MethodDelete && query.Get("uploadId") == "upload-id":
+		s.aborted = true
+
+	case r.Method == http.MethodPut:
+		s.objects[r.URL.Path] = body
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// upload writes contents to s3://bucket/object, returning the error from
+// writing or closing it.
+func upload(t *testing.T, contents []byte) error {
+	t.Helper()
+	w, err := createURL(context.Background(), "s3://bucket/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(contents); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func TestMultipartUpload(t *testing.T) {
+	contents := bytes.Repeat([]byte("squish"), (2*uploadPartSize+100)/6)
+
+	s := newFakeS3(t, nil)
+	if err := upload(t, contents); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.objects["/bucket/object"], contents) {
+		t.Errorf("got %d uploaded bytes, want %d", len(s.objects["/bucket/object"]), len(contents))
+	}
+	if len(s.parts) != 3 {
+		t.Errorf("got %d parts, want 3", len(s.parts))
+	}
+	if s.aborted {
+		t.Error("aborted a successful upload")
+	}
+
+	// Objects no larger than a part are uploaded with a single request.
+	s = newFakeS3(t, nil)
+	if err := upload(t, []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"PUT /bucket/object"}; strings.Join(s.requests, ",") != strings.Join(want, ",") {
+		t.Errorf("got requests %q, want %q", s.requests, want)
+	}
+	if got := string(s.objects["/bucket/object"]); got != "small" {
+		t.Errorf("got object %q, want %q", got, "small")
+	}
+}
+
+func TestMultipartUploadErrors(t *testing.T) {
+	contents := bytes.Repeat([]byte{0}, 2*uploadPartSize+1)
+	tests := []struct {
+		name    string
+		fail    func(r *http.Request) bool
+		err     string
+		aborted bool
+	}{
+		{
+			name: "initiate",
+			fail: func(r *http.Request) bool { return r.URL.Query().Has("uploads") },
+			err:  "failed to start multipart upload: unexpected status: 500",
+		},
+		{
+			name:    "part",
+			fail:    func(r *http.Request) bool { return r.URL.Query().Get("partNumber") == "2" },
+			err:     "failed to upload part 2: unexpected status: 500",
+			aborted: true,
+		},
+		{
+			name:    "last part",
+			fail:    func(r *http.Request) bool { return r.URL.Query().Get("partNumber") == "3" },
+			err:     "failed to upload part 3: unexpected status: 500",
+			aborted: true,
+		},
+		{
+			name:    "complete",
+			fail:    func(r *http.Request) bool { return r.Method == http.MethodPost && r.URL.Query().Has("uploadId") },
+			err:     "failed to complete multipart upload: unexpected status: 500",
+			aborted: true,
+		},
+	}
+
+	for _, test := range tests {
+		s := newFakeS3(t, test.fail)
+		err := upload(t, contents)
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+		if s.aborted != test.aborted {
+			t.Errorf("%s: got aborted %t, want %t", test.name, s.aborted, test.aborted)
+		}
+		if _, ok := s.objects["/bucket/object"]; ok {
+			t.Errorf("%s: object was uploaded", test.name)
+		}
+	}
+
+	// Errors reported in the body of a successful response to completing the
+	// upload fail it too.
+	s := newFakeS3(t, nil)
+	w, err := createURL(context.Background(), "s3://bucket/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(contents); err != nil {
+		t.Fatal(err)
+	}
+	w.upload.(*xmlUpload).etags[0] = `"wrong"`
+	if err := w.Close(); err == nil || err.Error() != "failed to complete multipart upload: invalid part" {
+		t.Errorf("got error %v, want one reporting the invalid part", err)
+	}
+	if !s.aborted {
+		t.Error("didn't abort the failed upload")
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// printEntry prints the name of an entry that's being archived or extracted
+// to w if --verbose was given, preceded by its mode and size if it was given
+// twice.
+func printEntry(w io.Writer, progress *progress, name string, info fs.FileInfo) {
+	if cli.Verbose == 0 {
+		return
+	}
+
+	line := name
+	if cli.Verbose > 1 {
+		line = fmt.Sprintf("%s %12d %s", info.Mode(), info.Size(), name)
+	}
+	progress.println(w, line)
+}
+
+// verboseOutput returns where printEntry should print to, which is stdout
+// unless it's being used for something else.
+func verboseOutput(output string) io.Writer {
+	if output == stdioPath || events.usesStdout() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerbose(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("abc"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries are printed to stderr when the archive is written to stdout.
+	for _, test := range []struct {
+		flags []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"-v"}, "a.txt\n"},
+		{[]string{"-vv"}, "-rw-r-----            3 a.txt\n"},
+		{[]string{"--verbose", "--verbose"}, "-rw-r-----            3 a.txt\n"},
+	} {
+		parseCLI(t, append(append(test.flags, "create", "-C", dir, "--format", "tar"), "-", "a.txt")...)
+		var code int
+		var archive string
+		printed := captureStderr(t, func() {
+			archive = captureStdout(t, func() {
+				code = runCommand(t, func() { create(context.Background(), cli.Create.Force) })
+			})
+		})
+		if code != 0 || len(archive) == 0 {
+			t.Fatalf("%q: got exit code %d and %d bytes", test.flags, code, len(archive))
+		}
+		if printed != test.want {
+			t.Errorf("%q: got %q on stderr, want %q", test.flags, printed, test.want)
+		}
+	}
+
+	// Otherwise, they're printed to stdout, as they are when extracting.
+	output := filepath.Join(t.TempDir(), "out.tar")
+	parseCLI(t, "-v", "create", "-C", dir, output, "a.txt")
+	if got := captureStdout(t, func() { runCommand(t, func() { create(context.Background(), cli.Create.Force) }) }); got != "a.txt\n" {
+		t.Errorf("create: got %q on stdout, want a.txt", got)
+	}
+	parseCLI(t, "-vv", "extract", output, filepath.Join(t.TempDir(), "extracted"))
+	if got := captureStdout(t, func() { runCommand(t, func() { extract(context.Background()) }) }); got != "-rw-r-----            3 a.txt\n" {
+		t.Errorf("extract: got %q on stdout, want a.txt with its mode and size", got)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go4.org/readerutil"
+)
+
+// volumeWriter writes to a sequence of numbered files (name.001, name.002,
+// ...), starting a new volume whenever the current one reaches size bytes. The
+// last volume is always smaller than the others, even if it's empty, so that
+// openVolumes can tell when it's missing.
+// Volumes are written to temporary files beside them, or in tempDir if it's
+// given, which replace the numbered files when the writer is closed if commit
+// was called, and are removed otherwise.
+type volumeWriter struct {
+	name      string
+	tempDir   string
+	size      int64
+	current   *os.File
+	written   int64
+	temps     []string
+	committed bool
+}
+
+func newVolumeWriter(name, tempDir string, size int64) *volumeWriter {
+	return &volumeWriter{name: name, tempDir: tempDir, size: size}
+}
+
+func volumeName(name string, index int) string {
+	return fmt.Sprintf("%s.%03d", name, index)
+}
+
+func (w *volumeWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if w.current == nil || w.written == w.size {
+			if err := w.next(); err != nil {
+				return n, err
+			}
+		}
+
+		chunk := p[:min(int64(len(p)), w.size-w.written)]
+		written, err := w.current.Write(chunk)
+		n += written
+		w.written += int64(written)
+		if err != nil {
+			return n, err
+		}
+		p = p[written:]
+	}
+
+	return n, nil
+}
+
+func (w *volumeWriter) next() error {
+	if w.current != nil {
+		if err := w.current.Close(); err != nil {
+			return err
+		}
+	}
+
+	name := volumeName(w.name, len(w.temps)+1)
+	current, err := os.CreateTemp(cmp.Or(w.tempDir, filepath.Dir(name)), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	w.temps = append(w.temps, current.Name())
+	w.current, w.written = current, 0
+	return nil
+}
+
+func (w *volumeWriter) commit() {
+	w.committed = true
+}
+
+func (w *volumeWriter) Close() error {
+	// Always produce at least one volume, even for empty output, and end
+	// with an empty one if the last is full.
+	var err error
+	if w.committed && (w.current == nil || w.written == w.size) {
+		err = w.next()
+	}
+	if w.current != nil {
+		if closeErr := w.current.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	for i, temp := range w.temps {
+		if err == nil && w.committed {
+			if err = os.Chmod(temp, 0o644); err == nil {
+				err = moveFile(temp, volumeName(w.name, i+1))
+			}
+		}
+		if err != nil || !w.committed {
+			os.Remove(temp)
+		}
+	}
+	return err
+}
+
+// volumes reads the concatenation of a sequence of split volumes as though it
+// were a single file.
+type volumes struct {
+	*io.SectionReader
+	files []*os.File
+}
+
+// firstVolumeSuffix is the suffix identifying the first of a sequence of
+// volumes written by volumeWriter.
+const firstVolumeSuffix = ".001"
+
+// trimVolumeSuffix returns name with firstVolumeSuffix removed, if present.
+func trimVolumeSuffix(name string) string {
+	return strings.TrimSuffix(name, firstVolumeSuffix)
+}
+
+// openVolumes opens every volume in the sequence starting at first, which
+// must end with firstVolumeSuffix. It fails if any of them is missing, which
+// for the last is when the one before it is as large as the first.
+func openVolumes(first string) (*volumes, error) {
+	name := trimVolumeSuffix(first)
+
+	var v volumes
+	var parts []readerutil.SizeReaderAt
+	for index := 1; ; index++ {
+		file, err := os.Open(volumeName(name, index))
+		if errors.Is(err, fs.ErrNotExist) && index > 1 {
+			if laterVolume(name, index) || len(parts) > 1 && parts[len(parts)-1].Size() == parts[0].Size() {
+				v.Close()
+				return nil, fmt.Errorf("volume %s is missing", volumeName(name, index))
+			}
+			break
+		} else if err != nil {
+			v.Close()
+			return nil, err
+		}
+		v.files = append(v.files, file)
+
+		stat, err := file.Stat()
+		if err != nil {
+			v.Close()
+			return nil, err
+		}
+		parts = append(parts, io.NewSectionReader(file, 0, stat.Size()))
+	}
+
+	multi := readerutil.NewMultiReaderAt(parts...)
+	v.SectionReader = io.NewSectionReader(multi, 0, multi.Size())
+	return &v, nil
+}
+
+// laterVolume reports whether there's a volume in the sequence of name
+// numbered after index.
+func laterVolume(name string, index int) bool {
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), filepath.Base(name)+".")
+		if n, err := strconv.Atoi(suffix); ok && err == nil && n > index {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *volumes) Close() error {
+	var errs []error
+	for _, file := range v.files {
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
+}
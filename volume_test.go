@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitVolumes(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	if err := os.Mkdir(input, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"in": "/"}
+	for _, name := range []string{"a", "b", "c"} {
+		data := make([]byte, 3000)
+		rand.Read(data)
+		if err := os.WriteFile(filepath.Join(input, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		want["in/"+name] = string(data)
+	}
+
+	output := filepath.Join(dir, "out.tar")
+	parseCLI(t, "create", "--split-size", "4K", "-C", dir, output, "in")
+	if code := runCommand(t, func() { create(context.Background(), cli.Create.Force) }); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+
+	// Every volume but the last is full.
+	var volumes [][]byte
+	for index := 1; ; index++ {
+		data, err := os.ReadFile(volumeName(output, index))
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		volumes = append(volumes, data)
+	}
+	if len(volumes) < 3 {
+		t.Fatalf("got %d volumes, want at least 3", len(volumes))
+	}
+	for i, volume := range volumes {
+		if last := i == len(volumes)-1; last && len(volume) >= 4096 || !last && len(volume) != 4096 {
+			t.Errorf("volume %d of %d has %d bytes", i+1, len(volumes), len(volume))
+		}
+	}
+
+	// The volumes are read as one archive given the first, and joined.
+	extracted := filepath.Join(dir, "extracted")
+	parseCLI(t, "extract", output+firstVolumeSuffix, extracted)
+	if code := runCommand(t, func() { extract(context.Background()) }); code != 0 {
+		t.Fatalf("extract: got exit code %d", code)
+	}
+	if got := readTree(t, extracted); !maps.Equal(got, want) {
+		t.Errorf("extracted %d entries, not the %d archived", len(got), len(want))
+	}
+	parseCLI(t, "join", output+firstVolumeSuffix)
+	if code := runCommand(t, func() { join(context.Background()) }); code != 0 {
+		t.Fatalf("join: got exit code %d", code)
+	}
+	if joined, err := os.ReadFile(output); err != nil || !bytes.Equal(joined, bytes.Join(volumes, nil)) {
+		t.Errorf("got %d joined bytes and %v, want the %d in the volumes", len(joined), err, len(bytes.Join(volumes, nil)))
+	}
+
+	// Missing volumes, including the last, are reported rather than read
+	// as a truncated archive.
+	for _, index := range []int{2, len(volumes)} {
+		missing := volumeName(output, index)
+		if err := os.Rename(missing, missing+".moved"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := openVolumes(output + firstVolumeSuffix); err == nil {
+			t.Errorf("volume %d was missing without an error", index)
+		}
+		parseCLI(t, "join", output+firstVolumeSuffix, filepath.Join(dir, "joined"))
+		if code := runCommand(t, func() { join(context.Background()) }); code == 0 {
+			t.Errorf("join: volume %d was missing without an error", index)
+		}
+		if err := os.Rename(missing+".moved", missing); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVolumeWriterFull(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out")
+	w := newVolumeWriter(name, "", 2)
+	if _, err := w.Write([]byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	w.commit()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// When the volumes are exactly full, an empty one ends them, so that
+	// they're still read without the last being thought missing.
+	if info, err := os.Stat(volumeName(name, 3)); err != nil || info.Size() != 0 {
+		t.Fatalf("got %v, %v, want an empty third volume", info, err)
+	}
+	v, err := openVolumes(name + firstVolumeSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if got, err := io.ReadAll(v); err != nil || string(got) != "abcd" {
+		t.Errorf("got %q, %v, want abcd", got, err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+)
+
+var warnings atomic.Int64
+
+// warn reports a problem that doesn't stop the operation, such as an entry
+// that couldn't be reproduced exactly. Unlike bail, it may be called from any
+// goroutine.
+func warn(format string, a ...any) {
+	warnings.Add(1)
+	message := fmt.Sprintf(format, a...)
+	details := errorDetails(a)
+	logMessage(slog.LevelWarn, details, format, a...)
+	details["message"] = message
+	events.emit("warning", details)
+}
+
+// failOnWarnings makes squish exit with a non-zero status if it would have
+// succeeded, but --fail-on-warn was given and warnings were reported.
+func failOnWarnings() {
+	if n := warnings.Load(); cli.FailOnWarn && n > 0 && exitCode == 0 {
+		logMessage(slog.LevelError, nil, "failing due to %d warning(s)", n)
+		exitCode = 1
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestWarn(t *testing.T) {
+	var logged bytes.Buffer
+	savedLogger, savedWarnings, savedExitCode, savedFailOnWarn := logger, warnings.Load(), exitCode, cli.FailOnWarn
+	t.Cleanup(func() {
+		logger, exitCode, cli.FailOnWarn = savedLogger, savedExitCode, savedFailOnWarn
+		warnings.Store(savedWarnings)
+	})
+	logger = slog.New(newTextHandler(&logged, slog.LevelInfo))
+	warnings.Store(0)
+	exitCode = 0
+
+	// Renamed entries are extracted with a warning.
+	archive := makeTar(t, []testEntry{
+		{name: "./a", typeflag: tar.TypeReg, contents: "a"},
+		{name: "b//c/../d", typeflag: tar.TypeReg, contents: "d"},
+	})
+	if _, _, err := extractTest(t, archives.Tar{}, archive, &entryExtractor{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := warnings.Load(); n != 1 {
+		t.Errorf("got %d warnings, want 1", n)
+	}
+	if got := logged.String(); !strings.HasPrefix(got, "warning: ") || !strings.Contains(got, "b//c/../d") {
+		t.Errorf("logged %q, want a warning about b//c/../d", got)
+	}
+
+	// Warnings only change the exit status with --fail-on-warn.
+	failOnWarnings()
+	if exitCode != 0 {
+		t.Errorf("got exit code %d without --fail-on-warn, want 0", exitCode)
+	}
+	cli.FailOnWarn = true
+	failOnWarnings()
+	if exitCode != 1 {
+		t.Errorf("got exit code %d with --fail-on-warn, want 1", exitCode)
+	}
+	exitCode = exitCodes["corrupt"]
+	failOnWarnings()
+	if exitCode != exitCodes["corrupt"] {
+		t.Errorf("got exit code %d, want the earlier failure's to be kept", exitCode)
+	}
+}
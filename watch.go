@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// watchCreate runs create, and again whenever the inputs change once they've
+// been left unchanged for --watch-delay, until squish is interrupted. Builds
+// that fail are logged, and the next change is waited for, so that the output
+// is left as it was after the last successful build.
+func watchCreate(ctx context.Context) {
+	if cli.Create.Output == stdioPath || isURL(cli.Create.Output) {
+		bail("--watch can only be used with outputs on disk")
+	}
+	if slices.Contains(cli.Create.Inputs, stdioPath) {
+		bail("--watch can't be used when compressing stdin")
+	}
+
+	changes, err := watchChanges(ctx, cli.Create.Inputs)
+	if err != nil {
+		bail("failed to watch inputs: %s", err)
+	}
+
+	// create changes the output and level it's given for --auto and
+	// --compat, so they're restored before each build. The outputs are
+	// skipped when looking for changes, including every name that those
+	// with placeholders expand to, along with the last one that was built,
+	// in case the directory it's in has placeholders too.
+	template, level := cli.Create.Output, cli.Create.Level
+	templates := append([]string{template}, cli.Create.Also...)
+	outputs := templates
+	force := cli.Create.Force
+	for {
+		state := inputState(cli.Create.Inputs, outputs)
+		cli.Create.Output, cli.Create.Level = template, level
+		exitCode = 0
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			create(ctx, force)
+		}()
+		<-done
+		outputs = append(slices.Clip(templates), cli.Create.Output)
+		if ctx.Err() != nil {
+			return
+		}
+		// Once the output's been created, it's replaced by the builds
+		// after.
+		if exitCode == 0 {
+			force = true
+		}
+		logMessage(slog.LevelInfo, nil, "waiting for changes to the inputs")
+
+		for state == inputState(cli.Create.Inputs, outputs) {
+			if !waitForChanges(ctx, changes) {
+				return
+			}
+		}
+	}
+}
+
+// waitForChanges waits for changes to be sent to, and then for them to stop
+// for --watch-delay, reporting false if ctx is canceled first.
+func waitForChanges(ctx context.Context, changes <-chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-changes:
+	}
+	delay := time.NewTimer(cli.Create.WatchDelay)
+	defer delay.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changes:
+			delay.Reset(cli.Create.WatchDelay)
+		case <-delay.C:
+			return true
+		}
+	}
+}
+
+// inputState returns a digest of the names and modes of the inputs and
+// everything within them, and the sizes and modification times of all but the
+// directories, which changes when they do. The outputs, their volumes and
+// sidecars, and the temporary files they're written to are skipped, so that
+// writing them isn't a change.
+func inputState(inputs []string, outputs []string) string {
+	skipped := make([]outputFiles, len(outputs))
+	for i, output := range outputs {
+		skipped[i] = newOutputFiles(output)
+	}
+
+	h := sha256.New()
+	for _, input := range inputs {
+		filepath.WalkDir(input, func(path string, d fs.DirEntry, err error) error {
+			if slices.ContainsFunc(skipped, func(output outputFiles) bool { return output.matches(path) }) {
+				return nil
+			}
+			h.Write([]byte(path + "\x00"))
+			if err != nil {
+				h.Write([]byte(err.Error() + "\x00"))
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				h.Write([]byte(err.Error() + "\x00"))
+				return nil
+			}
+			h.Write(binary.LittleEndian.AppendUint64(nil, uint64(info.Mode())))
+			// Directories are modified whenever the output is written into
+			// them, and their entries are compared anyway.
+			if info.IsDir() {
+				return nil
+			}
+			h.Write(binary.LittleEndian.AppendUint64(nil, uint64(info.Size())))
+			h.Write(binary.LittleEndian.AppendUint64(nil, uint64(info.ModTime().UnixNano())))
+			return nil
+		})
+	}
+	return string(h.Sum(nil))
+}
+
+// outputFiles matches the paths of an output, and of its volumes, sidecars
+// and temporary files, which start with its name. If the name of the output
+// has placeholders, it matches those of every output it expands to.
+type outputFiles struct {
+	dir   string
+	names *regexp.Regexp
+}
+
+func newOutputFiles(output string) outputFiles {
+	base := filepath.Base(output)
+	names := regexp.QuoteMeta(base)
+	if pattern, ok := templatePattern(base); ok {
+		names = strings.TrimSuffix(strings.TrimPrefix(pattern.String(), "^"), "$")
+	}
+	// Temporary files are named like .out.tar.zst.123.
+	return outputFiles{filepath.Dir(output), regexp.MustCompile(`^\.?` + names)}
+}
+
+func (o outputFiles) matches(path string) bool {
+	return filepath.Dir(path) == o.dir && o.names.MatchString(filepath.Base(path))
+}
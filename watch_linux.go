@@ -0,0 +1,119 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// watchMask is the inotify events that may change the inputs.
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// watchChanges returns a channel that's sent to whenever the inputs, or
+// anything within them, may have changed, until ctx is canceled, by watching
+// each of their directories with inotify, including those created later.
+func watchChanges(ctx context.Context, inputs []string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// Since it's non-blocking, the file is read through the runtime's poller,
+	// so closing it stops the read below.
+	f := os.NewFile(uintptr(fd), "inotify")
+	w := &inotifyWatcher{fd: fd, dirs: map[int32]string{}}
+	for _, input := range inputs {
+		if err := w.add(input); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for events := buf[:n]; len(events) >= syscall.SizeofInotifyEvent; {
+				wd := int32(binary.NativeEndian.Uint32(events))
+				mask := binary.NativeEndian.Uint32(events[4:])
+				nameLen := int(binary.NativeEndian.Uint32(events[12:]))
+				name := events[syscall.SizeofInotifyEvent:min(syscall.SizeofInotifyEvent+nameLen, len(events))]
+				events = events[min(syscall.SizeofInotifyEvent+nameLen, len(events)):]
+
+				// Directories created or moved into those watched are
+				// watched too, along with their contents.
+				if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					if dir, ok := w.dir(wd); ok {
+						if err := w.add(filepath.Join(dir, string(bytes.TrimRight(name, "\x00")))); err != nil {
+							warn("failed to watch new directory: %s", err)
+						}
+					}
+				}
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// inotifyWatcher adds inotify watches for the directories under inputs.
+type inotifyWatcher struct {
+	fd   int
+	mu   sync.Mutex
+	dirs map[int32]string
+}
+
+// add watches the directories under root if it's a directory, or otherwise
+// the directory containing it, so that it's still watched once it's replaced,
+// as editors do when saving.
+func (w *inotifyWatcher) add(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Inputs removed since they were walked are reported by the
+			// builds.
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			if path != root {
+				return nil
+			}
+			path = filepath.Dir(path)
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			return &fs.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+		}
+		w.mu.Lock()
+		w.dirs[int32(wd)] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) dir(wd int32) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dir, ok := w.dirs[wd]
+	return dir, ok
+}
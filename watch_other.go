@@ -0,0 +1,36 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// watchPollInterval is how often the inputs are checked for changes where
+// inotify isn't available.
+const watchPollInterval = time.Second
+
+// watchChanges returns a channel that's sent to every watchPollInterval until
+// ctx is canceled, since the inputs are compared with how they were when they
+// were last archived to tell whether they've changed.
+func watchChanges(ctx context.Context, inputs []string) (<-chan struct{}, error) {
+	changes := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(watchPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case changes <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInputState(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.tar.zst")
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state := inputState([]string{dir}, []string{output})
+
+	for _, name := range []string{"out.tar.zst", ".out.tar.zst.123", "out.tar.zst.sha256"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inputState([]string{dir}, []string{output}) != state {
+		t.Error("writing the output changed the state")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("ab"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if inputState([]string{dir}, []string{output}) == state {
+		t.Error("modifying an input didn't change the state")
+	}
+}
+
+func TestInputStateTemplate(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "backup-{date}.tar.zst")
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state := inputState([]string{dir}, []string{output})
+
+	// Every name that the output expands to is skipped, not only those
+	// that were built, so that its pruned backups aren't changes either.
+	for _, name := range []string{"backup-2026-10-16.tar.zst", ".backup-2026-10-17.tar.zst.123", "backup-2026-10-17.tar.zst.sha256"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inputState([]string{dir}, []string{output}) != state {
+		t.Error("writing an output changed the state")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "backup.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if inputState([]string{dir}, []string{output}) == state {
+		t.Error("adding an input didn't change the state")
+	}
+}
+
+func TestWaitForChanges(t *testing.T) {
+	cli.Create.WatchDelay = 50 * time.Millisecond
+	changes := make(chan struct{})
+	go func() {
+		for range 3 {
+			changes <- struct{}{}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	if !waitForChanges(context.Background(), changes) {
+		t.Fatal("got false without canceling")
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("returned after %s, before the changes stopped for the delay", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if waitForChanges(ctx, changes) {
+		t.Error("got true once canceled")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/mholt/archives"
+)
+
+// workerPool runs functions concurrently, with at most a fixed number running
+// at once, and remembers the first error returned by any of them.
+type workerPool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func newWorkerPool(workers int) *workerPool {
+	return &workerPool{slots: make(chan struct{}, workers)}
+}
+
+// run waits for a free worker and starts f on it. It returns the first error
+// returned by any function run so far, in which case f is not started.
+func (p *workerPool) run(f func() error) error {
+	p.slots <- struct{}{}
+	if err := p.firstErr(); err != nil {
+		<-p.slots
+		return err
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.slots }()
+
+		if err := f(); err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+// wait waits for all started functions to return, and returns the first error
+// returned by any of them.
+func (p *workerPool) wait() error {
+	p.wg.Wait()
+	return p.firstErr()
+}
+
+func (p *workerPool) firstErr() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// supportsConcurrentExtraction reports whether the entries of format can be
+// read independently of each other. 7z is excluded since entries in solid
+// blocks have to be decompressed from the start of the block, so reading them
+// concurrently repeats work.
+func supportsConcurrentExtraction(format archives.Extractor) bool {
+	_, ok := format.(archives.Zip)
+	return ok
+}
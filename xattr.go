@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/tar"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mholt/archives"
+)
+
+// xattrPAXPrefix prefixes the names of extended attributes in the PAX records
+// of tar headers, as written by star, GNU tar and bsdtar.
+const xattrPAXPrefix = "SCHILY.xattr."
+
+// aclXattrs are the extended attributes that POSIX ACLs are stored in on
+// Linux, which are selected by --acls rather than --xattrs.
+var aclXattrs = []string{"system.posix_acl_access", "system.posix_acl_default"}
+
+// capabilityXattr is the extended attribute that file capabilities are stored
+// in on Linux, which is selected by either --xattrs or --capabilities.
+const capabilityXattr = "security.capability"
+
+// xattrSelected reports whether the extended attribute attr is selected by
+// xattrs, which selects every attribute except POSIX ACLs, acls, which
+// selects only those, or capabilities, which selects only file capabilities.
+func xattrSelected(attr string, xattrs, acls, capabilities bool) bool {
+	if slices.Contains(aclXattrs, attr) {
+		return acls
+	}
+	if attr == capabilityXattr {
+		return xattrs || capabilities
+	}
+	return xattrs
+}
+
+// withXattrs stores the extended attributes of files selected by --xattrs,
+// --acls and --capabilities in their tar headers. files must have been
+// discovered from root by archives.FilesFromDisk.
+func withXattrs(files []archives.FileInfo, root string) ([]archives.FileInfo, error) {
+	if len(files) == 0 || (!cli.Create.Xattrs && !cli.Create.ACLs && !cli.Create.Capabilities) {
+		return files, nil
+	}
+
+	walked := newWalkedRoot(files, root)
+	for i, file := range files {
+		diskPath := walked.diskPath(file)
+
+		// The attributes of files that symbolic links were dereferenced to
+		// are stored, rather than those of the links.
+		var err error
+		if cli.Create.Dereference && file.Mode()&fs.ModeSymlink == 0 {
+			if diskPath, err = filepath.EvalSymlinks(diskPath); err != nil {
+				return nil, err
+			}
+		}
+		if files[i], err = xattrFile(file, diskPath); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// xattrFile returns file with the extended attributes of the file at diskPath
+// selected by --xattrs, --acls and --capabilities stored in its tar header, if
+// it has any.
+func xattrFile(file archives.FileInfo, diskPath string) (archives.FileInfo, error) {
+	if !cli.Create.Xattrs && !cli.Create.ACLs && !cli.Create.Capabilities {
+		return file, nil
+	}
+
+	xattrs, err := listXattrs(diskPath)
+	if err != nil {
+		return file, &fs.PathError{Op: "llistxattr", Path: diskPath, Err: err}
+	}
+
+	records := map[string]string{}
+	for attr, value := range xattrs {
+		if xattrSelected(attr, cli.Create.Xattrs, cli.Create.ACLs, cli.Create.Capabilities) {
+			records[xattrPAXPrefix+attr] = value
+		}
+	}
+	if len(records) == 0 {
+		return file, nil
+	}
+
+	header, err := tar.FileInfoHeader(file.FileInfo, file.LinkTarget)
+	if err != nil {
+		return file, err
+	}
+	header.PAXRecords = records
+	file.FileInfo = xattrInfo{file.FileInfo, header}
+	return file, nil
+}
+
+// xattrInfo stores extended attributes as PAX records in the tar header of an
+// entry, which archives.Tar takes the ownership, access and change times, and
+// PAX records of entries from if their Sys method returns one. The header is
+// created from the original file info, so that those are kept too.
+type xattrInfo struct {
+	fs.FileInfo
+	header *tar.Header
+}
+
+func (i xattrInfo) Sys() any {
+	return i.header
+}
+
+// entryXattrs returns the extended attributes stored in the tar header of
+// info that are selected by xattrs, acls and capabilities.
+func entryXattrs(info archives.FileInfo, xattrs, acls, capabilities bool) map[string]string {
+	header, ok := info.Header.(*tar.Header)
+	if !ok || (!xattrs && !acls && !capabilities) {
+		return nil
+	}
+
+	selected := map[string]string{}
+	for key, value := range header.PAXRecords {
+		if attr, ok := strings.CutPrefix(key, xattrPAXPrefix); ok && xattrSelected(attr, xattrs, acls, capabilities) {
+			selected[attr] = value
+		}
+	}
+	return selected
+}
@@ -0,0 +1,115 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"syscall"
+	"unsafe"
+)
+
+// xattrsSupported is whether extended attributes can be archived and
+// restored.
+const xattrsSupported = true
+
+// listXattrs returns the extended attributes of the file at path, without
+// following it if it's a symbolic link.
+func listXattrs(path string) (map[string]string, error) {
+	pathP, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := xattrBuffer(func(buf []byte) (uintptr, syscall.Errno) {
+		n, _, errno := syscall.Syscall(syscall.SYS_LLISTXATTR, uintptr(unsafe.Pointer(pathP)), bufferPointer(buf), uintptr(len(buf)))
+		return n, errno
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		nameP, err := syscall.BytePtrFromString(string(name))
+		if err != nil {
+			return nil, err
+		}
+		value, err := xattrBuffer(func(buf []byte) (uintptr, syscall.Errno) {
+			n, _, errno := syscall.Syscall6(syscall.SYS_LGETXATTR, uintptr(unsafe.Pointer(pathP)), uintptr(unsafe.Pointer(nameP)), bufferPointer(buf), uintptr(len(buf)), 0, 0)
+			return n, errno
+		})
+		// The attribute may have been removed since it was listed.
+		if err == syscall.ENODATA {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = string(value)
+	}
+	return xattrs, nil
+}
+
+// xattrBuffer calls get with a buffer large enough for the value it returns,
+// first asking for the size with an empty buffer, and again if the value grew
+// in between.
+func xattrBuffer(get func(buf []byte) (uintptr, syscall.Errno)) ([]byte, error) {
+	for {
+		size, errno := get(nil)
+		if errno != 0 {
+			return nil, errno
+		} else if size == 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, size)
+		n, errno := get(buf)
+		if errno == syscall.ERANGE {
+			continue
+		} else if errno != 0 {
+			return nil, errno
+		}
+		return buf[:n], nil
+	}
+}
+
+func bufferPointer(buf []byte) uintptr {
+	if len(buf) == 0 {
+		return 0
+	}
+	return uintptr(unsafe.Pointer(&buf[0]))
+}
+
+// lsetxattr sets the extended attribute attr of the file at path, without
+// following it if it's a symbolic link.
+func lsetxattr(path, attr string, value []byte) error {
+	pathP, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attrP, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return err
+	}
+
+	if _, _, errno := syscall.Syscall6(syscall.SYS_LSETXATTR, uintptr(unsafe.Pointer(pathP)), uintptr(unsafe.Pointer(attrP)), bufferPointer(value), uintptr(len(value)), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// fsetxattr sets the extended attribute attr of the open file fd.
+func fsetxattr(fd int, attr string, value []byte) error {
+	attrP, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return err
+	}
+
+	if _, _, errno := syscall.Syscall6(syscall.SYS_FSETXATTR, uintptr(fd), uintptr(unsafe.Pointer(attrP)), bufferPointer(value), uintptr(len(value)), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
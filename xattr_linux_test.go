@@ -0,0 +1,69 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestXattrs(t *testing.T) {
+	const value = "value\x00with a NUL"
+
+	// Check that the filesystem supports user extended attributes.
+	probe := filepath.Join(t.TempDir(), "probe")
+	if err := os.WriteFile(probe, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := lsetxattr(probe, "user.squish", []byte(value)); errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("user extended attributes aren't supported by the filesystem")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	xattrs, err := listXattrs(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xattrs["user.squish"] != value {
+		t.Errorf("got extended attributes %q", xattrs)
+	}
+
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	records := map[string]string{xattrPAXPrefix + "user.squish": value}
+	for _, header := range []*tar.Header{
+		{Name: "d/", Typeflag: tar.TypeDir, Mode: 0o755, PAXRecords: records},
+		{Name: "d/f", Typeflag: tar.TypeReg, Mode: 0o644, PAXRecords: records},
+	} {
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, restore := range []bool{false, true} {
+		_, output, err := extractTest(t, archives.Tar{}, buf.Bytes(), &entryExtractor{xattrs: restore})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"d", "d/f"} {
+			xattrs, err := listXattrs(filepath.Join(output, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored := xattrs["user.squish"] == value; restored != restore {
+				t.Errorf("xattrs %t: %s has extended attributes %q", restore, name, xattrs)
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// xattrsSupported is whether extended attributes can be archived and
+// restored.
+const xattrsSupported = false
+
+var errXattrsUnsupported = errors.New("extended attributes are only supported on Linux")
+
+func listXattrs(string) (map[string]string, error) {
+	return nil, errXattrsUnsupported
+}
+
+func lsetxattr(string, string, []byte) error {
+	return errXattrsUnsupported
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestXattrSelected(t *testing.T) {
+	tests := []struct {
+		attr                       string
+		xattrs, acls, capabilities bool
+		want                       bool
+	}{
+		{"security.selinux", true, false, false, true},
+		{"security.selinux", false, true, false, false},
+		{"security.selinux", false, false, true, false},
+		{"security.capability", true, false, false, true},
+		{"security.capability", false, false, true, true},
+		{"security.capability", false, true, false, false},
+		{"system.posix_acl_access", true, false, true, false},
+		{"system.posix_acl_default", false, true, false, true},
+		{"user.name", true, true, true, true},
+	}
+
+	for _, test := range tests {
+		if got := xattrSelected(test.attr, test.xattrs, test.acls, test.capabilities); got != test.want {
+			t.Errorf("%s with xattrs %t, acls %t and capabilities %t: got %t, want %t", test.attr, test.xattrs, test.acls, test.capabilities, got, test.want)
+		}
+	}
+}
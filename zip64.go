@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+
+	"github.com/mholt/archives"
+
+	"mtoohey.com/squish/pkg/squish"
+)
+
+// Limits of zips without zip64 records.
+const (
+	zip32MaxEntries = 1<<16 - 1
+	zip32MaxSize    = 1<<32 - 1
+)
+
+// Signatures and sizes of the records at the end of zips.
+const (
+	zipDirectoryHeaderSignature = 0x02014b50
+	zipDirectoryHeaderLen       = 46
+	zipDirectoryEndSignature    = 0x06054b50
+	zipDirectoryEndLen          = 22
+	zip64DirectoryEndSignature  = 0x06064b50
+	zip64DirectoryEndLen        = 56
+	zip64LocatorSignature       = 0x07064b50
+	zip64LocatorLen             = 20
+	zip64ExtraID                = 0x0001
+	zipVersion45                = 45
+)
+
+// errZip64 is returned for zips that would need zip64 records when they're
+// forbidden.
+var errZip64 = errors.New("the zip needs zip64 records, since it's 4 GiB or larger or has 65,535 or more entries")
+
+// isZip reports whether format writes zips.
+func isZip(format archives.Format) bool {
+	_, variant := format.(squish.ZipVariant)
+	return variant || format.Extension() == ".zip"
+}
+
+// checkZip32 returns errZip64, explaining that forbiddenBy forbids zip64
+// records, if files can't be stored in a zip without them. That's only known
+// for sure once the zip is written, by the size of the zip64Writer it's
+// written to.
+func checkZip32(files []archives.FileInfo, forbiddenBy string) error {
+	if len(files) >= zip32MaxEntries {
+		return fmt.Errorf("%w, but %s forbids them", errZip64, forbiddenBy)
+	}
+	for _, file := range files {
+		if file.Mode().IsRegular() && file.Size() >= zip32MaxSize {
+			return fmt.Errorf("%w, since %s is 4 GiB or larger, but %s forbids them", errZip64, file.NameInArchive, forbiddenBy)
+		}
+	}
+	return nil
+}
+
+// zip64Writer writes a zip to w for --zip64. When zip64 records are forbidden,
+// it fails once more than a zip without them can hold is written. With always,
+// it holds
+// back what's written after the contents of the last regular file, which
+// includes the central directory, so that finish can rewrite it with zip64
+// records for every entry, as the zip writers only use them when they have
+// to.
+type zip64Writer struct {
+	w      io.Writer
+	always bool
+	// forbiddenBy is the flag that forbids zip64 records, if they are.
+	forbiddenBy string
+	n           int64
+	// held is what was written since the start of the zip or the last
+	// regular file was closed, while holding, starting at offset heldAt.
+	holding bool
+	held    bytes.Buffer
+	heldAt  int64
+}
+
+// newZip64Writer returns a zip64Writer that either always writes zip64
+// records, or fails if they're needed, explaining that forbiddenBy forbids
+// them.
+func newZip64Writer(w io.Writer, always bool, forbiddenBy string) *zip64Writer {
+	return &zip64Writer{w: w, always: always, forbiddenBy: forbiddenBy, holding: always}
+}
+
+func (w *zip64Writer) Write(p []byte) (int, error) {
+	if !w.always && w.n+int64(len(p)) >= zip32MaxSize {
+		return 0, fmt.Errorf("%w, but %s forbids them", errZip64, w.forbiddenBy)
+	}
+	w.n += int64(len(p))
+	if w.holding {
+		return w.held.Write(p)
+	}
+	return w.w.Write(p)
+}
+
+// watch returns files with their regular files wrapped, so that w knows when
+// their contents have been written.
+func (w *zip64Writer) watch(files []archives.FileInfo) []archives.FileInfo {
+	if !w.always {
+		return files
+	}
+	watched := make([]archives.FileInfo, len(files))
+	for i, file := range files {
+		if open := file.Open; file.Mode().IsRegular() && open != nil {
+			file.Open = func() (fs.File, error) {
+				if err := w.release(); err != nil {
+					return nil, err
+				}
+				f, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return zip64WatchedFile{f, w}, nil
+			}
+		}
+		watched[i] = file
+	}
+	return watched
+}
+
+// release writes what w held back, since more entries follow it.
+func (w *zip64Writer) release() error {
+	w.holding = false
+	_, err := w.w.Write(w.held.Bytes())
+	w.held.Reset()
+	return err
+}
+
+// zip64WatchedFile is a regular file being archived by a zip64Writer, which
+// holds back what's written once it's closed.
+type zip64WatchedFile struct {
+	fs.File
+	w *zip64Writer
+}
+
+func (f zip64WatchedFile) Close() error {
+	f.w.holding, f.w.heldAt = true, f.w.n
+	return f.File.Close()
+}
+
+// finish writes what w held back, with the central directory rewritten to
+// use zip64 records with always.
+func (w *zip64Writer) finish() error {
+	if !w.always {
+		return nil
+	}
+	tail, err := withZip64Directory(w.held.Bytes(), w.heldAt)
+	if err != nil {
+		return err
+	}
+	w.held.Reset()
+	_, err = w.w.Write(tail)
+	return err
+}
+
+// withZip64Directory returns tail, the end of a zip starting at offset start,
+// which includes the whole central directory, with a zip64 extra field added
+// to each central directory header that doesn't have one, and the end of
+// central directory record preceded by zip64 ones.
+func withZip64Directory(tail []byte, start int64) ([]byte, error) {
+	le := binary.LittleEndian
+	end := bytes.LastIndex(tail, le.AppendUint32(nil, zipDirectoryEndSignature))
+	if end < 0 || len(tail)-end < zipDirectoryEndLen {
+		return nil, errors.New("failed to find the end of the central directory")
+	}
+	records := int(le.Uint16(tail[end+10:]))
+	dirOffset := int64(le.Uint32(tail[end+16:]))
+	comment := tail[end+zipDirectoryEndLen:]
+	if locator := end - zip64LocatorLen; locator >= 0 && le.Uint32(tail[locator:]) == zip64LocatorSignature {
+		record := int(int64(le.Uint64(tail[locator+8:])) - start)
+		if record < 0 || record+zip64DirectoryEndLen > locator {
+			return nil, errors.New("failed to find the zip64 end of the central directory")
+		}
+		records = int(le.Uint64(tail[record+32:]))
+		dirOffset = int64(le.Uint64(tail[record+48:]))
+	}
+	dir := int(dirOffset - start)
+	if dir < 0 || dir > end {
+		return nil, errors.New("failed to find the central directory")
+	}
+
+	rewritten := bytes.NewBuffer(slices.Clone(tail[:dir]))
+	header := dir
+	for range records {
+		if header+zipDirectoryHeaderLen > end || le.Uint32(tail[header:]) != zipDirectoryHeaderSignature {
+			return nil, errors.New("failed to read the central directory")
+		}
+		fixed := slices.Clone(tail[header : header+zipDirectoryHeaderLen])
+		nameLen, extraLen, commentLen := int(le.Uint16(fixed[28:])), int(le.Uint16(fixed[30:])), int(le.Uint16(fixed[32:]))
+		next := header + zipDirectoryHeaderLen + nameLen + extraLen + commentLen
+		if next > end {
+			return nil, errors.New("failed to read the central directory")
+		}
+		name := tail[header+zipDirectoryHeaderLen : header+zipDirectoryHeaderLen+nameLen]
+		extra := slices.Clone(tail[header+zipDirectoryHeaderLen+nameLen : header+zipDirectoryHeaderLen+nameLen+extraLen])
+		entryComment := tail[next-commentLen : next]
+
+		if !hasZipExtra(extra, zip64ExtraID) {
+			extra = le.AppendUint16(extra, zip64ExtraID)
+			extra = le.AppendUint16(extra, 24)
+			extra = le.AppendUint64(extra, uint64(le.Uint32(fixed[24:])))
+			extra = le.AppendUint64(extra, uint64(le.Uint32(fixed[20:])))
+			extra = le.AppendUint64(extra, uint64(le.Uint32(fixed[42:])))
+			le.PutUint32(fixed[20:], zip32MaxSize)
+			le.PutUint32(fixed[24:], zip32MaxSize)
+			le.PutUint32(fixed[42:], zip32MaxSize)
+			le.PutUint16(fixed[30:], uint16(len(extra)))
+			if le.Uint16(fixed[6:]) < zipVersion45 {
+				le.PutUint16(fixed[6:], zipVersion45)
+			}
+		}
+		rewritten.Write(fixed)
+		rewritten.Write(name)
+		rewritten.Write(extra)
+		rewritten.Write(entryComment)
+		header = next
+	}
+
+	dirSize := int64(rewritten.Len() - dir)
+	record := start + int64(rewritten.Len())
+	var buf []byte
+	buf = le.AppendUint32(buf, zip64DirectoryEndSignature)
+	buf = le.AppendUint64(buf, zip64DirectoryEndLen-12)
+	buf = le.AppendUint16(buf, zipVersion45)
+	buf = le.AppendUint16(buf, zipVersion45)
+	buf = le.AppendUint32(buf, 0)
+	buf = le.AppendUint32(buf, 0)
+	buf = le.AppendUint64(buf, uint64(records))
+	buf = le.AppendUint64(buf, uint64(records))
+	buf = le.AppendUint64(buf, uint64(dirSize))
+	buf = le.AppendUint64(buf, uint64(dirOffset))
+
+	buf = le.AppendUint32(buf, zip64LocatorSignature)
+	buf = le.AppendUint32(buf, 0)
+	buf = le.AppendUint64(buf, uint64(record))
+	buf = le.AppendUint32(buf, 1)
+
+	buf = le.AppendUint32(buf, zipDirectoryEndSignature)
+	buf = le.AppendUint16(buf, 0)
+	buf = le.AppendUint16(buf, 0)
+	buf = le.AppendUint16(buf, zip32MaxEntries)
+	buf = le.AppendUint16(buf, zip32MaxEntries)
+	buf = le.AppendUint32(buf, zip32MaxSize)
+	buf = le.AppendUint32(buf, zip32MaxSize)
+	buf = le.AppendUint16(buf, uint16(len(comment)))
+	buf = append(buf, comment...)
+
+	rewritten.Write(buf)
+	return rewritten.Bytes(), nil
+}
+
+// hasZipExtra reports whether the extra field extra has a block with id.
+func hasZipExtra(extra []byte, id uint16) bool {
+	for len(extra) >= 4 {
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if binary.LittleEndian.Uint16(extra) == id {
+			return true
+		}
+		if 4+size > len(extra) {
+			break
+		}
+		extra = extra[4+size:]
+	}
+	return false
+}
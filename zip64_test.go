@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+func TestZip64Always(t *testing.T) {
+	files := []archives.FileInfo{
+		memFile("a.txt", []byte("squish")),
+		memDir("d"),
+		memFile("d/b.txt", bytes.Repeat([]byte("b"), 1000)),
+		memDir("e"),
+	}
+	var buf bytes.Buffer
+	w := newZip64Writer(&buf, true, "")
+	if err := (archives.Zip{}).Archive(context.Background(), w, w.watch(files)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), binary.LittleEndian.AppendUint32(nil, zip64DirectoryEndSignature)) {
+		t.Error("no zip64 end of central directory record was written")
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(files) {
+		t.Fatalf("got %d entries, want %d", len(zr.File), len(files))
+	}
+	for i, f := range zr.File {
+		if f.Name != files[i].NameInArchive && f.Name != files[i].NameInArchive+"/" {
+			t.Errorf("got entry %s, want %s", f.Name, files[i].NameInArchive)
+		}
+		if !hasZipExtra(f.Extra, zip64ExtraID) {
+			t.Errorf("%s has no zip64 extra field", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(data)) != files[i].Size() {
+			t.Errorf("got %d bytes for %s, want %d", len(data), f.Name, files[i].Size())
+		}
+	}
+}
+
+func TestCheckZip32(t *testing.T) {
+	files := []archives.FileInfo{memFile("a.txt", []byte("squish"))}
+	if err := checkZip32(files, "--zip64=never"); err != nil {
+		t.Errorf("got %v for a small zip", err)
+	}
+
+	for len(files) < zip32MaxEntries {
+		files = append(files, memDir("d"))
+	}
+	if err := checkZip32(files, "--zip64=never"); !errors.Is(err, errZip64) {
+		t.Errorf("got %v for %d entries, want %v", err, len(files), errZip64)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// zipCommentMaxLen is the length of the longest comment that zips can have.
+const zipCommentMaxLen = 1<<16 - 1
+
+// checkZipComment returns an error if zips can't have comment, which must fit
+// in the end of central directory record, and mustn't contain its signature,
+// since the record is found by searching backwards for it.
+func checkZipComment(comment string) error {
+	if len(comment) > zipCommentMaxLen {
+		return fmt.Errorf("the comment is %d bytes, but zip comments are at most %d", len(comment), zipCommentMaxLen)
+	}
+	if !utf8.ValidString(comment) {
+		return errors.New("the comment isn't valid UTF-8")
+	}
+	if bytes.Contains([]byte(comment), binary.LittleEndian.AppendUint32(nil, zipDirectoryEndSignature)) {
+		return errors.New("the comment contains the signature of the end of a zip")
+	}
+	return nil
+}
+
+// zipCommentWriter writes a zip to w, holding back its end of central directory
+// record, which the zip writers always write last, without a comment, so that
+// finish can give it one.
+type zipCommentWriter struct {
+	w    io.Writer
+	tail []byte
+}
+
+func (w *zipCommentWriter) Write(p []byte) (int, error) {
+	w.tail = append(w.tail, p...)
+	if excess := len(w.tail) - zipDirectoryEndLen; excess > 0 {
+		if _, err := w.w.Write(w.tail[:excess]); err != nil {
+			return 0, err
+		}
+		w.tail = append(w.tail[:0], w.tail[excess:]...)
+	}
+	return len(p), nil
+}
+
+// finish writes the end of central directory record that w held back, with
+// comment.
+func (w *zipCommentWriter) finish(comment string) error {
+	le := binary.LittleEndian
+	if len(w.tail) != zipDirectoryEndLen || le.Uint32(w.tail) != zipDirectoryEndSignature || le.Uint16(w.tail[20:]) != 0 {
+		return errors.New("failed to find the end of the central directory")
+	}
+	le.PutUint16(w.tail[20:], uint16(len(comment)))
+	_, err := w.w.Write(append(w.tail, comment...))
+	return err
+}
+
+// zipDirectoryEnd returns the offset of the end of central directory record of
+// the zip r, which is size bytes long: the last one that the rest of r is the
+// comment of.
+func zipDirectoryEnd(r io.ReaderAt, size int64) (int64, error) {
+	start := max(0, size-zipDirectoryEndLen-zipCommentMaxLen)
+	tail := make([]byte, size-start)
+	if _, err := r.ReadAt(tail, start); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	le := binary.LittleEndian
+	for end := len(tail) - zipDirectoryEndLen; end >= 0; end-- {
+		if le.Uint32(tail[end:]) == zipDirectoryEndSignature && end+zipDirectoryEndLen+int(le.Uint16(tail[end+20:])) == len(tail) {
+			return start + int64(end), nil
+		}
+	}
+	return 0, errors.New("failed to find the end of the central directory, so it isn't a zip")
+}
+
+// setZipComment replaces the comment of the zip at path in place, rewriting
+// only the end of central directory record, which the comment follows.
+func setZipComment(path, comment string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end, err := zipDirectoryEnd(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	record := binary.LittleEndian.AppendUint16(nil, uint16(len(comment)))
+	if _, err := f.WriteAt(append(record, comment...), end+20); err != nil {
+		return err
+	}
+	if err := f.Truncate(end + zipDirectoryEndLen + int64(len(comment))); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// comment prints the comment of a zip, or replaces it with the one given.
+func comment(ctx context.Context) {
+	if cli.Comment.Text != nil {
+		if err := checkZipComment(*cli.Comment.Text); err != nil {
+			bail("invalid comment: %s", err)
+		}
+		if err := setZipComment(cli.Comment.Input, *cli.Comment.Text); err != nil {
+			bail("failed to set comment: %s", err)
+		}
+		return
+	}
+
+	input, format, _ := openInput(ctx, cli.Comment.Input)
+	defer closeInput(input)
+	if !isZip(format) {
+		bail("only zips have comments")
+	}
+	zr, err := zip.NewReader(input, fileSize(input))
+	if err != nil {
+		bail("failed to read zip: %s", err)
+	}
+	if zr.Comment != "" {
+		fmt.Println(zr.Comment)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func writeTestZip(t *testing.T, w *zipCommentWriter) {
+	t.Helper()
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readZipComment(t *testing.T, data []byte) string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr.Comment
+}
+
+func TestZipCommentWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &zipCommentWriter{w: &buf}
+	writeTestZip(t, w)
+	if err := w.finish("built from 1a2b3c"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readZipComment(t, buf.Bytes()); got != "built from 1a2b3c" {
+		t.Errorf("got comment %q", got)
+	}
+
+	w = &zipCommentWriter{w: &buf}
+	if _, err := w.Write([]byte("not a zip, but long enough to hold back")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.finish("comment"); err == nil {
+		t.Error("gave a comment to something that isn't a zip")
+	}
+}
+
+func TestSetZipComment(t *testing.T) {
+	var buf bytes.Buffer
+	w := &zipCommentWriter{w: &buf}
+	writeTestZip(t, w)
+	if err := w.finish("old comment"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "a.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, comment := range []string{"a longer new comment", "short", ""} {
+		if err := setZipComment(path, comment); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readZipComment(t, data); got != comment {
+			t.Errorf("got comment %q, want %q", got, comment)
+		}
+		if len(data) != buf.Len()-len("old comment")+len(comment) {
+			t.Errorf("%q: the zip is %d bytes, want only the comment to have changed", comment, len(data))
+		}
+	}
+
+	notZip := filepath.Join(t.TempDir(), "not.zip")
+	if err := os.WriteFile(notZip, []byte(strings.Repeat("x", 100)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setZipComment(notZip, "comment"); err == nil {
+		t.Error("set the comment of something that isn't a zip")
+	}
+}
+
+func TestCheckZipComment(t *testing.T) {
+	for _, comment := range []string{strings.Repeat("x", zipCommentMaxLen+1), "PK\x05\x06", "\xff"} {
+		if err := checkZipComment(comment); err == nil {
+			t.Errorf("%.20q: got no error", comment)
+		}
+	}
+	if err := checkZipComment(strings.Repeat("x", zipCommentMaxLen)); err != nil {
+		t.Error(err)
+	}
+}
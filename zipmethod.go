@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+// zipMethodNames are the compression methods that --zip-method can choose
+// for every entry, by their names.
+var zipMethodNames = map[string]uint16{
+	"store":   zip.Store,
+	"deflate": zip.Deflate,
+	"zstd":    archives.ZipMethodZstd,
+}
+
+// autoZip writes zips whose regular files are compressed with deflate, at
+// level if it isn't zero, except for those that are already compressed, like
+// images, videos and other archives, which are stored, for --zip-method=auto.
+// Files are judged to be compressed by their extensions, and otherwise by the
+// type and entropy of the start of their contents, as with --auto, which is
+// much faster than deflating them for next to nothing.
+type autoZip struct {
+	archives.Zip
+	level int
+}
+
+func (z autoZip) Archive(ctx context.Context, output io.Writer, files []archives.FileInfo) error {
+	zw := z.newWriter(output)
+	for _, file := range files {
+		if err := z.archiveFile(ctx, zw, file); err != nil {
+			zw.Close()
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (z autoZip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan archives.ArchiveAsyncJob) error {
+	zw := z.newWriter(output)
+	for job := range jobs {
+		job.Result <- z.archiveFile(ctx, zw, job.File)
+	}
+	return zw.Close()
+}
+
+func (z autoZip) newWriter(output io.Writer) *zip.Writer {
+	zw := zip.NewWriter(output)
+	if z.level != 0 {
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, z.level)
+		})
+	}
+	return zw
+}
+
+func (z autoZip) archiveFile(ctx context.Context, zw *zip.Writer, file archives.FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(file)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+	}
+	header.Name = file.NameInArchive
+	header.Method = zip.Deflate
+	header.Extra = zipExtra(file)
+	if file.IsDir() {
+		header.Name = strings.TrimSuffix(header.Name, "/") + "/"
+		header.Method = zip.Store
+	} else if file.Mode().IsRegular() {
+		_, compressed, err := sniffContents(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.NameInArchive, err)
+		}
+		if compressed {
+			header.Method = zip.Store
+		}
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", file.NameInArchive, err)
+	}
+	if file.IsDir() {
+		return nil
+	}
+
+	input, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	if _, err := io.Copy(w, input); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.NameInArchive, err)
+	}
+	return nil
+}
+
+// withZipMethod returns format, which must be a zip, compressing its entries
+// with the method named by name, or choosing between deflate and store for
+// each with auto, or false if format isn't a zip.
+func withZipMethod(format archives.Format, name string) (archives.Format, bool) {
+	zipFormat, ok := format.(archives.Zip)
+	if !ok {
+		return nil, false
+	}
+	if name == "auto" {
+		return autoZip{Zip: zipFormat}, true
+	}
+	zipFormat.Compression = zipMethodNames[name]
+	return zipFormat, true
+}
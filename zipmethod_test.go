@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archives"
+)
+
+func TestAutoZip(t *testing.T) {
+	files := []archives.FileInfo{
+		memFile("notes.txt", []byte(strings.Repeat("squish ", 1000))),
+		memFile("photo.jpg", []byte("\xff\xd8\xff\xe0")),
+		memFile("pattern", bytes.Repeat([]byte{0x8f, 0x13, 0xe2, 0x5a, 0x77, 0xc1, 0x04, 0xb9}, 32)),
+		memDir("d"),
+	}
+	var buf bytes.Buffer
+	if err := (autoZip{level: 9}).Archive(context.Background(), &buf, files); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint16{"notes.txt": zip.Deflate, "photo.jpg": zip.Store, "pattern": zip.Deflate, "d/": zip.Store}
+	for _, f := range zr.File {
+		if f.Method != want[f.Name] {
+			t.Errorf("got method %d for %s, want %d", f.Method, f.Name, want[f.Name])
+		}
+	}
+	if len(zr.File) != len(want) {
+		t.Errorf("got %d entries, want %d", len(zr.File), len(want))
+	}
+}
+
+func TestWithZipMethod(t *testing.T) {
+	if format, ok := withZipMethod(archives.Zip{}, "zstd"); !ok || format.(archives.Zip).Compression != archives.ZipMethodZstd {
+		t.Errorf("got %v, %t for zstd", format, ok)
+	}
+	if format, ok := withZipMethod(archives.Zip{}, "auto"); !ok || format != (autoZip{}) {
+		t.Errorf("got %v, %t for auto", format, ok)
+	}
+	if _, ok := withZipMethod(archives.Tar{}, "store"); ok {
+		t.Error("a tar archive was given a zip method")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/klauspost/compress/zstd"
+	"github.com/mholt/archives"
+)
+
+// zstdLongWindowLog is the window log that --zstd-long uses, as zstd --long
+// does by default. Windows up to this size can be decompressed by zstd
+// without --long or --memory.
+const zstdLongWindowLog = 27
+
+// Window logs that --zstd-window-log accepts.
+const (
+	zstdMinWindowLog = 10
+	zstdMaxWindowLog = 29
+)
+
+// withZstdWindow returns format, which must compress with zstd, possibly as a
+// compressed archive, matching data up to 1<<windowLog bytes back, or false if
+// it doesn't compress with zstd. Larger windows find matches between similar
+// files that are far apart, like VM images or database dumps, but use about as
+// much memory again to compress and decompress.
+func withZstdWindow(format archives.Format, windowLog int) (archives.Format, bool) {
+	switch format := format.(type) {
+	case archives.CompressedArchive:
+		compression, ok := withZstdWindow(format.Compression, windowLog)
+		if ok {
+			format.Compression = compression.(archives.Compression)
+		}
+		return format, ok
+	case archives.Zstd:
+		format.EncoderOptions = append(format.EncoderOptions, zstd.WithWindowSize(1<<windowLog))
+		return format, true
+	}
+	return nil, false
+}
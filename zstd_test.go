@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/mholt/archives"
+)
+
+func TestZstdWindow(t *testing.T) {
+	// The input repeats further back than the default window.
+	chunk := make([]byte, 9<<20)
+	rand.New(rand.NewSource(1)).Read(chunk)
+	input := append(bytes.Clone(chunk), chunk...)
+
+	compress := func(format archives.Format) int {
+		var buf bytes.Buffer
+		w, err := format.(archives.Compressor).OpenWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Len()
+	}
+
+	long, ok := withZstdWindow(archives.Zstd{}, zstdLongWindowLog)
+	if !ok {
+		t.Fatal("zst wasn't given a window")
+	}
+	if size, longSize := compress(archives.Zstd{}), compress(long); longSize > size*3/4 {
+		t.Errorf("got %d bytes with a long window, and %d without", longSize, size)
+	}
+
+	if _, ok := withZstdWindow(archives.Gz{}, zstdLongWindowLog); ok {
+		t.Error("gz was given a zstd window")
+	}
+}